@@ -0,0 +1,272 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fortis-admin/internal/signing"
+)
+
+const (
+	chainLogName  = "backup-chain.log"
+	chainHeadName = "backup-chain.head"
+)
+
+// ChainEntry is one line of the append-only backup log. Each entry commits to
+// the previous one, so removing or rewriting a line breaks every later hash.
+type ChainEntry struct {
+	Seq           int       `json:"seq"`
+	Time          time.Time `json:"time"`
+	BackupID      string    `json:"backup_id"`
+	Archive       string    `json:"archive"`
+	ArchiveSHA256 string    `json:"archive_sha256"`
+	MetaSHA256    string    `json:"meta_sha256"`
+	PrevHash      string    `json:"prev_hash"`
+	Hash          string    `json:"hash"`
+	KeyID         string    `json:"key_id,omitempty"`
+	Signature     string    `json:"signature,omitempty"`
+}
+
+func (e ChainEntry) computeHash() string {
+	s := fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s", e.Seq, e.Time.UTC().Format(time.RFC3339Nano), e.BackupID, e.Archive, e.ArchiveSHA256, e.MetaSHA256, e.PrevHash)
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// ChainHead is the signed latest entry of the log. Dropping the newest
+// entries together with their archives leaves a consistent but shorter
+// chain; the head still names the entry that was removed.
+type ChainHead struct {
+	Seq       int       `json:"seq"`
+	Hash      string    `json:"hash"`
+	KeyID     string    `json:"key_id"`
+	SignedAt  time.Time `json:"signed_at"`
+	Signature string    `json:"signature"`
+}
+
+func (h ChainHead) payload() []byte {
+	return []byte(fmt.Sprintf("fortis-backup-chain-head-v1\n%d\n%s\n", h.Seq, h.Hash))
+}
+
+type ChainReport struct {
+	Path     string   `json:"path"`
+	Entries  int      `json:"entries"`
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func ChainLogPath(dir string) string { return filepath.Join(dir, chainLogName) }
+
+func chainHeadPath(dir string) string { return filepath.Join(dir, chainHeadName) }
+
+func writeChainHead(dir string, e ChainEntry, priv ed25519.PrivateKey) error {
+	h := ChainHead{Seq: e.Seq, Hash: e.Hash, KeyID: signing.PrivateKeyID(priv), SignedAt: time.Now()}
+	h.Signature = signing.Sign(priv, h.payload())
+	tmp := chainHeadPath(dir) + ".tmp"
+	if err := writeJSONFile(tmp, h, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, chainHeadPath(dir))
+}
+
+func readChain(path string) ([]ChainEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := []ChainEntry{}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" {
+			continue
+		}
+		var e ChainEntry
+		if err := json.Unmarshal([]byte(ln), &e); err != nil {
+			return out, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		out = append(out, e)
+	}
+	return out, s.Err()
+}
+
+func appendChain(dir string, meta BackupMeta, metaPath string, priv ed25519.PrivateKey) (ChainEntry, error) {
+	path := ChainLogPath(dir)
+	entries, err := readChain(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return ChainEntry{}, err
+	}
+
+	metaSum, _, err := sha256File(metaPath)
+	if err != nil {
+		return ChainEntry{}, err
+	}
+	e := ChainEntry{
+		Seq:           1,
+		Time:          time.Now(),
+		BackupID:      meta.ID,
+		Archive:       filepath.Base(meta.ArchivePath),
+		ArchiveSHA256: meta.ChecksumSHA256,
+		MetaSHA256:    metaSum,
+	}
+	if n := len(entries); n > 0 {
+		e.Seq = entries[n-1].Seq + 1
+		e.PrevHash = entries[n-1].Hash
+	}
+	e.Hash = e.computeHash()
+	if priv != nil {
+		e.KeyID = signing.PrivateKeyID(priv)
+		e.Signature = signing.Sign(priv, []byte(e.Hash))
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return ChainEntry{}, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return ChainEntry{}, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return ChainEntry{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return ChainEntry{}, err
+	}
+	if priv != nil {
+		if err := writeChainHead(dir, e, priv); err != nil {
+			return ChainEntry{}, err
+		}
+	}
+	return e, nil
+}
+
+// VerifyChain walks the backup log in dir, re-computing every link and
+// checking that each recorded archive still exists with the recorded hash.
+// Backups present in dir without a log entry are reported as well. With
+// trusted keys every entry and the chain head must carry a valid signature.
+func VerifyChain(dir string, trusted []ed25519.PublicKey) (ChainReport, error) {
+	path := ChainLogPath(dir)
+	rep := ChainReport{Path: path, OK: true}
+	problem := func(format string, args ...any) {
+		rep.OK = false
+		rep.Problems = append(rep.Problems, fmt.Sprintf(format, args...))
+	}
+
+	entries, err := readChain(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			problem("chain log not found")
+			return rep, nil
+		}
+		problem("chain log unreadable: %v", err)
+	}
+	rep.Entries = len(entries)
+
+	recorded := map[string]struct{}{}
+	prev := ""
+	for i, e := range entries {
+		recorded[e.BackupID] = struct{}{}
+		if e.Seq != i+1 {
+			problem("entry %d: sequence %d out of order (entry removed?)", i+1, e.Seq)
+		}
+		if e.PrevHash != prev {
+			problem("entry %d (%s): previous hash mismatch", e.Seq, e.BackupID)
+		}
+		if e.computeHash() != e.Hash {
+			problem("entry %d (%s): hash mismatch (entry rewritten)", e.Seq, e.BackupID)
+		}
+		prev = e.Hash
+
+		if len(trusted) > 0 {
+			if e.Signature == "" {
+				problem("entry %d (%s): not signed", e.Seq, e.BackupID)
+			} else if err := signing.Verify(trusted, e.KeyID, []byte(e.Hash), e.Signature); err != nil {
+				problem("entry %d (%s): signature not valid for any trusted key", e.Seq, e.BackupID)
+			}
+		}
+
+		archive := filepath.Join(dir, e.Archive)
+		sum, _, err := sha256File(archive)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				problem("entry %d (%s): archive %s is missing (deleted)", e.Seq, e.BackupID, e.Archive)
+			} else {
+				problem("entry %d (%s): %v", e.Seq, e.BackupID, err)
+			}
+			continue
+		}
+		if sum != e.ArchiveSHA256 {
+			problem("entry %d (%s): archive %s was replaced (sha256 mismatch)", e.Seq, e.BackupID, e.Archive)
+		}
+		if metaSum, _, err := sha256File(sidecarPath(archive, ".meta.json")); err == nil && metaSum != e.MetaSHA256 {
+			problem("entry %d (%s): metadata was modified after creation", e.Seq, e.BackupID)
+		}
+	}
+
+	verifyChainHead(dir, entries, trusted, problem)
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return rep, err
+	}
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, ".meta.json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".meta.json")
+		if _, ok := recorded[id]; !ok {
+			problem("backup %s is not recorded in the chain log", id)
+		}
+	}
+	return rep, nil
+}
+
+// verifyChainHead compares the log with the signed head written by the
+// last signed backup.
+func verifyChainHead(dir string, entries []ChainEntry, trusted []ed25519.PublicKey, problem func(string, ...any)) {
+	b, err := os.ReadFile(chainHeadPath(dir))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			problem("chain head unreadable: %v", err)
+		} else if len(trusted) > 0 && len(entries) > 0 {
+			problem("chain head %s is missing; removal of the newest entries cannot be ruled out", chainHeadName)
+		}
+		return
+	}
+	var h ChainHead
+	if err := json.Unmarshal(b, &h); err != nil {
+		problem("chain head unreadable: %v", err)
+		return
+	}
+	if len(trusted) > 0 {
+		if err := signing.Verify(trusted, h.KeyID, h.payload(), h.Signature); err != nil {
+			problem("chain head: signature not valid for any trusted key")
+			return
+		}
+	}
+	switch {
+	case h.Seq > len(entries):
+		problem("chain log ends at entry %d but the signed head is entry %d (newest entries removed)", len(entries), h.Seq)
+	case h.Seq < 1 || entries[h.Seq-1].Hash != h.Hash:
+		problem("chain head does not match entry %d (log rewritten)", h.Seq)
+	case len(trusted) > 0 && h.Seq < len(entries):
+		problem("entries after %d are not covered by the signed head", h.Seq)
+	}
+}
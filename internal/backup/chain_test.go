@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addBackup writes an archive and its metadata to dir and appends them to
+// the chain, signing with priv when it is set.
+func addBackup(t *testing.T, dir, id string, priv ed25519.PrivateKey) {
+	t.Helper()
+	archive := filepath.Join(dir, id+".tar.gz")
+	if err := os.WriteFile(archive, []byte("archive "+id), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := sha256File(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := BackupMeta{ID: id, ArchivePath: archive, ChecksumSHA256: sum}
+	metaPath := sidecarPath(archive, ".meta.json")
+	if err := writeJSONFile(metaPath, meta, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendChain(dir, meta, metaPath, priv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dropLastEntry removes the newest log line and its backup files, as an
+// attacker covering up a backup would.
+func dropLastEntry(t *testing.T, dir, id string) {
+	t.Helper()
+	b, err := os.ReadFile(ChainLogPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimRight(string(b), "\n"), "\n")
+	if err := os.WriteFile(ChainLogPath(dir), []byte(strings.Join(lines[:len(lines)-1], "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{".tar.gz", ".meta.json"} {
+		if err := os.Remove(filepath.Join(dir, id+suffix)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, dir string)
+		trusted []ed25519.PublicKey
+		want    []string // substrings of the expected problems, in order
+	}{
+		{
+			name: "signed chain",
+			setup: func(t *testing.T, dir string) {
+				for i := 1; i <= 3; i++ {
+					addBackup(t, dir, fmt.Sprintf("b%d", i), priv)
+				}
+			},
+			trusted: []ed25519.PublicKey{pub},
+		},
+		{
+			name: "unsigned chain without trusted keys",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", nil)
+				addBackup(t, dir, "b2", nil)
+			},
+		},
+		{
+			name: "unsigned chain with trusted keys",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", nil)
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"entry 1 (b1): not signed", "chain head backup-chain.head is missing"},
+		},
+		{
+			name: "unsigned entry after signed ones",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				addBackup(t, dir, "b2", nil)
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"entry 2 (b2): not signed", "entries after 1 are not covered by the signed head"},
+		},
+		{
+			name: "signed by an untrusted key",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+			},
+			trusted: []ed25519.PublicKey{otherPub},
+			want:    []string{"entry 1 (b1): signature not valid", "chain head: signature not valid"},
+		},
+		{
+			name: "newest entry removed with its backup",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				addBackup(t, dir, "b2", priv)
+				dropLastEntry(t, dir, "b2")
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"chain log ends at entry 1 but the signed head is entry 2 (newest entries removed)"},
+		},
+		{
+			name: "head removed",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				if err := os.Remove(chainHeadPath(dir)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"chain head backup-chain.head is missing"},
+		},
+		{
+			name: "head removed is not flagged without trusted keys",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				if err := os.Remove(chainHeadPath(dir)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "middle entry rewritten",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				addBackup(t, dir, "b2", priv)
+				addBackup(t, dir, "b3", priv)
+				b, err := os.ReadFile(ChainLogPath(dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b = []byte(strings.Replace(string(b), `"archive":"b2.tar.gz"`, `"archive":"b1.tar.gz"`, 1))
+				if err := os.WriteFile(ChainLogPath(dir), b, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"entry 2 (b2): hash mismatch (entry rewritten)", "entry 2 (b2): archive b1.tar.gz was replaced", "entry 2 (b2): metadata was modified"},
+		},
+		{
+			name: "archive replaced",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				if err := os.WriteFile(filepath.Join(dir, "b1.tar.gz"), []byte("tampered"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"entry 1 (b1): archive b1.tar.gz was replaced"},
+		},
+		{
+			name: "backup without a log entry",
+			setup: func(t *testing.T, dir string) {
+				addBackup(t, dir, "b1", priv)
+				if err := os.WriteFile(filepath.Join(dir, "b9.meta.json"), []byte("{}\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			trusted: []ed25519.PublicKey{pub},
+			want:    []string{"backup b9 is not recorded in the chain log"},
+		},
+		{
+			name:  "no chain log",
+			setup: func(t *testing.T, dir string) {},
+			want:  []string{"chain log not found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.setup(t, dir)
+			rep, err := VerifyChain(dir, tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.OK != (len(tt.want) == 0) {
+				t.Errorf("OK = %t, problems %q", rep.OK, rep.Problems)
+			}
+			if len(rep.Problems) != len(tt.want) {
+				t.Fatalf("problems = %q, want %d matching %q", rep.Problems, len(tt.want), tt.want)
+			}
+			for i, w := range tt.want {
+				if !strings.Contains(rep.Problems[i], w) {
+					t.Errorf("problem %d = %q, want %q", i, rep.Problems[i], w)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package backup
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +13,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"fortis-admin/internal/signing"
 )
 
 func Create(opts CreateOptions) (BackupMeta, error) {
@@ -26,6 +31,15 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 		opts.Compress = CompressionGzip
 	}
 
+	var signer ed25519.PrivateKey
+	if opts.SigningKey != "" {
+		k, err := signing.LoadPrivateKey(opts.SigningKey)
+		if err != nil {
+			return BackupMeta{}, err
+		}
+		signer = k
+	}
+
 	id := fmt.Sprintf("backup-%s", time.Now().Format("20060102-150405"))
 	if err := ensureDir(opts.TargetDir); err != nil {
 		return BackupMeta{}, err
@@ -65,11 +79,34 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 	tw = w
 	_ = tw
 
+	spool, err := os.CreateTemp("", "fortis-spool-*")
+	if err != nil {
+		return BackupMeta{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	retryDelay := opts.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = 200 * time.Millisecond
+	}
+
+	manifest := Manifest{BackupID: id, CreatedAt: time.Now()}
+	var fileErrs []FileError
+	record := func(path, op string, attempts int, err error) error {
+		fileErrs = append(fileErrs, FileError{Path: path, Op: op, Attempts: attempts, Error: err.Error()})
+		if opts.MaxErrors > 0 && len(fileErrs) > opts.MaxErrors {
+			return fmt.Errorf("%w: %d file errors (limit %d)", ErrTooManyErrors, len(fileErrs), opts.MaxErrors)
+		}
+		return nil
+	}
+
+	var fatal error
 	for _, src := range opts.Sources {
 		src = filepath.Clean(src)
-		_ = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		walkErr := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				return nil
+				return record(path, "walk", 1, err)
 			}
 			rel := strings.TrimPrefix(path, string(filepath.Separator))
 			if rel == "" {
@@ -84,22 +121,79 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 			if info.IsDir() {
 				return nil
 			}
-			hdr, err := tar.FileInfoHeader(info, "")
-			if err != nil {
+
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				if err != nil {
+					return record(path, "readlink", 1, err)
+				}
+				hdr, err := tar.FileInfoHeader(info, target)
+				if err != nil {
+					return record(path, "header", 1, err)
+				}
+				hdr.Name = rel
+				if err := w.WriteHeader(hdr); err != nil {
+					fatal = err
+					return err
+				}
+				manifest.Files = append(manifest.Files, ManifestEntry{Path: rel, Type: manifestSymlink, Target: target, Mode: uint32(info.Mode().Perm())})
+				return nil
+			case !info.Mode().IsRegular():
+				// sockets, devices and fifos are not archived
 				return nil
 			}
+
+			res, attempts, err := spoolFile(path, spool, opts.Retries, retryDelay)
+			if err != nil {
+				return record(path, res.op, attempts, err)
+			}
+			hdr, err := tar.FileInfoHeader(res.info, "")
+			if err != nil {
+				return record(path, "header", attempts, err)
+			}
 			hdr.Name = rel
+			hdr.Size = res.size
 			if err := w.WriteHeader(hdr); err != nil {
-				return nil
+				fatal = err
+				return err
 			}
-			r, err := os.Open(path)
-			if err != nil {
-				return nil
+			if _, err := spool.Seek(0, io.SeekStart); err != nil {
+				fatal = err
+				return err
+			}
+			if _, err := io.CopyN(w, spool, res.size); err != nil {
+				fatal = err
+				return err
+			}
+			manifest.Files = append(manifest.Files, ManifestEntry{Path: rel, Size: res.size, Mode: uint32(res.info.Mode().Perm()), SHA256: res.sha256})
+			if res.changed {
+				return record(path, "modified", attempts, errors.New("file kept changing during read; archived last read"))
 			}
-			defer r.Close()
-			_, _ = io.Copy(w, r)
 			return nil
 		})
+		if fatal != nil {
+			_ = f.Close()
+			_ = os.Remove(archivePath)
+			return BackupMeta{}, fmt.Errorf("write archive: %w", fatal)
+		}
+		if walkErr != nil {
+			_ = f.Close()
+			_ = os.Remove(archivePath)
+			return BackupMeta{ID: id, Errors: fileErrs}, walkErr
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return BackupMeta{}, err
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return BackupMeta{}, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return BackupMeta{}, err
 	}
 
 	sum, size, err := sha256File(archivePath)
@@ -107,6 +201,15 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 		return BackupMeta{}, err
 	}
 
+	manifestPath := filepath.Join(opts.TargetDir, id+".manifest.json")
+	if err := writeJSONFile(manifestPath, manifest, 0o600); err != nil {
+		return BackupMeta{}, err
+	}
+	manifestSum, _, err := sha256File(manifestPath)
+	if err != nil {
+		return BackupMeta{}, err
+	}
+
 	meta := BackupMeta{
 		ID:             id,
 		CreatedAt:      time.Now(),
@@ -117,8 +220,14 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 		ChecksumSHA256: sum,
 		Encrypted:      opts.Encrypt,
 		Compression:    string(opts.Compress),
+		ManifestSHA256: manifestSum,
+		Partial:        len(fileErrs) > 0,
+		Errors:         fileErrs,
 		Notes:          notes,
 	}
+	if signer != nil {
+		meta.SigningKeyID = signing.PrivateKeyID(signer)
+	}
 
 	if opts.Encrypt {
 		meta.Notes = append(meta.Notes, "encrypt requested: not implemented (hook point)")
@@ -126,8 +235,91 @@ func Create(opts CreateOptions) (BackupMeta, error) {
 
 	// Write a sidecar metadata JSON for listing.
 	metaPath := filepath.Join(opts.TargetDir, id+".meta.json")
-	metaJSON := fmt.Sprintf("{\n  \"id\": %q,\n  \"created_at\": %q,\n  \"type\": %q,\n  \"archive_path\": %q,\n  \"size_bytes\": %d,\n  \"sha256\": %q,\n  \"encrypted\": %v,\n  \"compression\": %q,\n  \"sources\": %q,\n  \"notes\": %q\n}\n", meta.ID, meta.CreatedAt.Format(time.RFC3339), meta.Type, meta.ArchivePath, meta.SizeBytes, meta.ChecksumSHA256, meta.Encrypted, meta.Compression, strings.Join(meta.Sources, ","), strings.Join(meta.Notes, ";"))
-	_ = os.WriteFile(metaPath, []byte(metaJSON), 0o600)
+	if err := writeJSONFile(metaPath, meta, 0o600); err != nil {
+		return BackupMeta{}, err
+	}
+
+	if signer != nil {
+		sig, err := signBackup(signer, id, metaPath, manifestPath)
+		if err != nil {
+			return meta, err
+		}
+		if err := writeJSONFile(filepath.Join(opts.TargetDir, id+".sig"), sig, 0o644); err != nil {
+			return meta, err
+		}
+	}
 
+	if _, err := appendChain(opts.TargetDir, meta, metaPath, signer); err != nil {
+		return meta, fmt.Errorf("append chain log: %w", err)
+	}
+
+	if meta.Partial {
+		return meta, fmt.Errorf("%w: %d file errors", ErrPartialBackup, len(fileErrs))
+	}
 	return meta, nil
 }
+
+type spoolResult struct {
+	info    os.FileInfo
+	size    int64
+	sha256  string
+	changed bool
+	op      string
+}
+
+// spoolFile copies path into spool and re-checks size and mtime afterwards so
+// that the tar header always matches the bytes written. Files that change
+// while being read are retried; if they never settle the last read is kept and
+// reported as changed.
+func spoolFile(path string, spool *os.File, retries int, delay time.Duration) (spoolResult, int, error) {
+	var res spoolResult
+	attempts := 0
+	for {
+		attempts++
+		before, err := os.Lstat(path)
+		if err != nil {
+			res.op = "stat"
+			return res, attempts, err
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			res.op = "open"
+			return res, attempts, err
+		}
+		if err := spool.Truncate(0); err != nil {
+			_ = r.Close()
+			res.op = "spool"
+			return res, attempts, err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			_ = r.Close()
+			res.op = "spool"
+			return res, attempts, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(spool, h), r)
+		_ = r.Close()
+		if err != nil {
+			res.op = "read"
+			if attempts <= retries {
+				time.Sleep(delay)
+				continue
+			}
+			return res, attempts, err
+		}
+		after, err := os.Lstat(path)
+		if err != nil {
+			res.op = "stat"
+			return res, attempts, err
+		}
+
+		res.info = after
+		res.size = n
+		res.sha256 = hex.EncodeToString(h.Sum(nil))
+		res.changed = n != before.Size() || before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime())
+		if !res.changed || attempts > retries {
+			return res, attempts, nil
+		}
+		time.Sleep(delay)
+	}
+}
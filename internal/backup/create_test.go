@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolFile(t *testing.T) {
+	dir := t.TempDir()
+	stable := filepath.Join(dir, "stable")
+	content := []byte("stable content\n")
+	if err := os.WriteFile(stable, content, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	spool, err := os.CreateTemp(dir, "spool-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	// A previous, longer file must not leak into the spool.
+	if _, err := spool.WriteString(strings.Repeat("x", 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, attempts, err := spoolFile(stable, spool, 2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if res.changed || attempts != 1 || res.size != int64(len(content)) || res.sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("stable file: changed %t, attempts %d, size %d, sha256 %s", res.changed, attempts, res.size, res.sha256)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(spool); string(b) != string(content) {
+		t.Errorf("spool = %q, want %q", b, content)
+	}
+
+	if _, attempts, err := spoolFile(filepath.Join(dir, "missing"), spool, 2, time.Millisecond); err == nil || attempts != 1 {
+		t.Errorf("missing file: attempts %d, err %v", attempts, err)
+	}
+
+	// Files under /proc report size 0 but read non-empty, so every
+	// re-check sees a change.
+	const changing = "/proc/self/stat"
+	if _, err := os.Stat(changing); err != nil {
+		t.Skip("no /proc")
+	}
+	res, attempts, err = spoolFile(changing, spool, 2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.changed || attempts != 3 || res.size == 0 {
+		t.Errorf("changing file: changed %t, attempts %d, size %d; want changed after 3 attempts", res.changed, attempts, res.size)
+	}
+}
+
+func TestCreateFileErrors(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.conf"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := func(n string) string { return filepath.Join(src, "missing-"+n) }
+
+	t.Run("partial", func(t *testing.T) {
+		target := t.TempDir()
+		meta, err := Create(CreateOptions{TargetDir: target, Sources: []string{src, missing("1")}, Compress: CompressionNone})
+		if !errors.Is(err, ErrPartialBackup) {
+			t.Fatalf("err = %v, want ErrPartialBackup", err)
+		}
+		if !meta.Partial || len(meta.Errors) != 1 || meta.Errors[0].Op != "walk" || meta.Errors[0].Path != missing("1") {
+			t.Errorf("partial %t, errors %+v", meta.Partial, meta.Errors)
+		}
+		if _, err := os.Stat(meta.ArchivePath); err != nil {
+			t.Errorf("partial archive not kept: %v", err)
+		}
+		staged, err := stageItems(meta.ArchivePath, nil, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(staged) != 1 || !strings.HasSuffix(staged[0].name, "a.conf") {
+			t.Errorf("archive members = %+v, want a.conf", staged)
+		}
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		target := t.TempDir()
+		meta, err := Create(CreateOptions{TargetDir: target, Sources: []string{missing("1"), src, missing("2"), missing("3"), missing("4")}, MaxErrors: 2})
+		if !errors.Is(err, ErrTooManyErrors) || !strings.Contains(err.Error(), "3 file errors (limit 2)") {
+			t.Fatalf("err = %v, want ErrTooManyErrors after 3 errors", err)
+		}
+		if meta.ID == "" || len(meta.Errors) != 3 {
+			t.Errorf("meta %s carries %d errors, want 3", meta.ID, len(meta.Errors))
+		}
+		if entries, _ := os.ReadDir(target); len(entries) != 0 {
+			t.Errorf("aborted backup left %d files in the target", len(entries))
+		}
+	})
+
+	t.Run("within threshold", func(t *testing.T) {
+		target := t.TempDir()
+		meta, err := Create(CreateOptions{TargetDir: target, Sources: []string{missing("1"), src, missing("2")}, MaxErrors: 2})
+		if !errors.Is(err, ErrPartialBackup) || len(meta.Errors) != 2 {
+			t.Errorf("err = %v with %d errors, want a partial backup with 2", err, len(meta.Errors))
+		}
+	})
+
+	t.Run("changing file", func(t *testing.T) {
+		const changing = "/proc/self/stat"
+		if _, err := os.Stat(changing); err != nil {
+			t.Skip("no /proc")
+		}
+		target := t.TempDir()
+		meta, err := Create(CreateOptions{TargetDir: target, Sources: []string{src, changing}, Retries: 1, RetryDelay: time.Millisecond})
+		if !errors.Is(err, ErrPartialBackup) {
+			t.Fatalf("err = %v, want ErrPartialBackup", err)
+		}
+		if len(meta.Errors) != 1 || meta.Errors[0].Op != "modified" || meta.Errors[0].Attempts != 2 {
+			t.Errorf("errors = %+v, want one modified after 2 attempts", meta.Errors)
+		}
+		if reason, err := verifyManifest(meta.ArchivePath); err != nil || reason != "" {
+			t.Errorf("manifest of the archived last read does not verify: %q, %v", reason, err)
+		}
+	})
+}
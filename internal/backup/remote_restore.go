@@ -0,0 +1,321 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fortis-admin/internal/cluster"
+)
+
+type RemoteRestoreOptions struct {
+	BackupPath string
+	Host       string
+	TargetDir  string
+	Items      []string
+	DryRun     bool
+
+	// PreBackup archives the files about to be overwritten on the remote
+	// host into PreBackupDir before anything is extracted.
+	PreBackup    bool
+	PreBackupDir string
+
+	InventoryPath string
+	SSHUser       string
+	SSHPort       int
+	SSHKey        string
+	SSHTimeout    time.Duration
+}
+
+type RemoteFileResult struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type RemoteRestoreResult struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	Host          string             `json:"host"`
+	BackupPath    string             `json:"backup_path"`
+	TargetDir     string             `json:"target_dir"`
+	DryRun        bool               `json:"dry_run"`
+	PreBackupPath string             `json:"pre_backup_path,omitempty"`
+	Files         []RemoteFileResult `json:"files"`
+	Restored      int                `json:"restored"`
+	Failed        int                `json:"failed"`
+	OK            bool               `json:"ok"`
+}
+
+const (
+	remoteStatusPlanned  = "planned"
+	remoteStatusRestored = "restored"
+	remoteStatusFailed   = "failed"
+	remoteStatusMismatch = "mismatch"
+)
+
+type stagedEntry struct {
+	name   string
+	typ    string
+	sha256 string
+}
+
+// RestoreRemote streams the selected archive members to a host from the
+// cluster inventory and extracts them there with ownership and permissions
+// preserved. Every regular file is re-hashed on the remote side afterwards so
+// the per-file results reflect what actually landed on disk.
+func RestoreRemote(ctx context.Context, opts RemoteRestoreOptions) (RemoteRestoreResult, error) {
+	if opts.BackupPath == "" {
+		return RemoteRestoreResult{}, errors.New("--backup is required")
+	}
+	if strings.TrimSpace(opts.Host) == "" {
+		return RemoteRestoreResult{}, errors.New("--host is required")
+	}
+	if opts.TargetDir == "" {
+		opts.TargetDir = "/"
+	}
+	if opts.PreBackupDir == "" {
+		opts.PreBackupDir = "/var/backups/fortis"
+	}
+	if opts.SSHTimeout == 0 {
+		opts.SSHTimeout = 30 * time.Second
+	}
+
+	res := RemoteRestoreResult{Timestamp: time.Now(), Host: opts.Host, BackupPath: opts.BackupPath, TargetDir: opts.TargetDir, DryRun: opts.DryRun}
+
+	staged, err := os.CreateTemp("", "fortis-remote-restore-*.tar")
+	if err != nil {
+		return res, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	entries, err := stageItems(opts.BackupPath, opts.Items, staged)
+	if err != nil {
+		return res, err
+	}
+	if len(entries) == 0 {
+		return res, errors.New("no archive entries match the requested items")
+	}
+
+	if opts.DryRun {
+		for _, e := range entries {
+			res.Files = append(res.Files, RemoteFileResult{Path: e.name, Type: e.typ, Status: remoteStatusPlanned})
+		}
+		res.OK = true
+		return res, nil
+	}
+
+	var inv cluster.Inventory
+	if opts.InventoryPath != "" {
+		// Without an inventory the host is used as given; a broken one
+		// would silently drop its SSH user, port and key.
+		loaded, err := cluster.LoadInventory(opts.InventoryPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return res, fmt.Errorf("inventory %s: %w", opts.InventoryPath, err)
+		}
+		inv = loaded
+	}
+	sshOpts := cluster.ExecOptions{SSHUser: opts.SSHUser, SSHPort: opts.SSHPort, SSHKey: opts.SSHKey}
+	remote := func(ctx context.Context, command string, stdin io.Reader) (string, string, error) {
+		cmd := exec.CommandContext(ctx, "ssh", cluster.SSHArgs(inv, opts.Host, sshOpts, command)...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdin = stdin
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+	target := shellQuote(opts.TargetDir)
+
+	if opts.PreBackup {
+		names := []string{}
+		for _, e := range entries {
+			if e.typ != "dir" {
+				names = append(names, e.name)
+			}
+		}
+		pre := path.Join(opts.PreBackupDir, fmt.Sprintf("pre-restore-%s.tar.gz", time.Now().Format("20060102-150405")))
+		cmdline := fmt.Sprintf("mkdir -p %s && cd %s && tar --ignore-failed-read --no-recursion --null -czpf %s -T -",
+			shellQuote(opts.PreBackupDir), target, shellQuote(pre))
+		tctx, cancel := context.WithTimeout(ctx, opts.SSHTimeout)
+		_, stderr, err := remote(tctx, cmdline, strings.NewReader(strings.Join(names, "\x00")))
+		cancel()
+		if err != nil {
+			return res, fmt.Errorf("pre-restore backup on %s failed: %v: %s", opts.Host, err, strings.TrimSpace(stderr))
+		}
+		res.PreBackupPath = pre
+	}
+
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	cmdline := fmt.Sprintf("mkdir -p %s && tar -xpf - -C %s --same-owner --numeric-owner", target, target)
+	_, stderr, extractErr := remote(ctx, cmdline, staged)
+	tarErrs := parseToolErrors(stderr, "tar: ")
+	if extractErr != nil && len(tarErrs) == 0 {
+		return res, fmt.Errorf("remote extract on %s failed: %v: %s", opts.Host, extractErr, strings.TrimSpace(stderr))
+	}
+
+	sums := map[string]string{}
+	files := []string{}
+	for _, e := range entries {
+		if e.typ == "file" {
+			files = append(files, e.name)
+		}
+	}
+	sumErrs := map[string]string{}
+	if len(files) > 0 {
+		tctx, cancel := context.WithTimeout(ctx, opts.SSHTimeout)
+		out, stderr, err := remote(tctx, fmt.Sprintf("cd %s && xargs -0 sha256sum --", target), strings.NewReader(strings.Join(files, "\x00")))
+		cancel()
+		sums = parseSHA256Sums(out)
+		sumErrs = parseToolErrors(stderr, "sha256sum: ")
+		// sha256sum fails for missing files, which are reported per file;
+		// without any hash or per-file message the check itself failed.
+		if err != nil && len(sums) == 0 && len(sumErrs) == 0 {
+			return res, fmt.Errorf("remote sha256sum on %s failed: %v: %s", opts.Host, err, strings.TrimSpace(stderr))
+		}
+	}
+
+	for _, e := range entries {
+		fr := RemoteFileResult{Path: e.name, Type: e.typ, Status: remoteStatusRestored}
+		if msg, ok := tarErrs[e.name]; ok {
+			fr.Status = remoteStatusFailed
+			fr.Error = msg
+		} else if e.typ == "file" {
+			got, ok := sums[e.name]
+			switch {
+			case !ok:
+				fr.Status = remoteStatusFailed
+				fr.Error = "file not found on remote host after extract"
+				if msg, ok := sumErrs[e.name]; ok {
+					fr.Error = "sha256sum after extract: " + msg
+				}
+			case got != e.sha256:
+				fr.Status = remoteStatusMismatch
+				fr.Error = "sha256 mismatch after extract"
+			}
+		}
+		if fr.Status == remoteStatusRestored {
+			res.Restored++
+		} else {
+			res.Failed++
+		}
+		res.Files = append(res.Files, fr)
+	}
+	res.OK = res.Failed == 0
+	return res, nil
+}
+
+// stageItems copies the selected archive members into w as an uncompressed
+// tar stream, keeping the original headers (owner, mode, mtime).
+func stageItems(archivePath string, items []string, w io.Writer) ([]stagedEntry, error) {
+	tr, closeFn, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	tw := tar.NewWriter(w)
+	out := []stagedEntry{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, ok := memberName(h.Name)
+		if !ok {
+			continue
+		}
+		if !matchItems(name, items) {
+			continue
+		}
+		h.Name = name
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, err
+		}
+		e := stagedEntry{name: name}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			e.typ = "dir"
+		case tar.TypeSymlink:
+			e.typ = "symlink"
+		case tar.TypeReg:
+			e.typ = "file"
+			hs := sha256.New()
+			if _, err := io.Copy(io.MultiWriter(tw, hs), tr); err != nil {
+				return nil, err
+			}
+			e.sha256 = hex.EncodeToString(hs.Sum(nil))
+		default:
+			e.typ = "other"
+		}
+		out = append(out, e)
+	}
+	return out, tw.Close()
+}
+
+// parseToolErrors maps "<tool>: <name>: <message>" lines, as tar and
+// sha256sum print them, to the member name. Lines of other programs, such as
+// ssh, are ignored.
+func parseToolErrors(stderr, prefix string) map[string]string {
+	out := map[string]string{}
+	s := bufio.NewScanner(strings.NewReader(stderr))
+	for s.Scan() {
+		ln, ok := strings.CutPrefix(strings.TrimSpace(s.Text()), prefix)
+		if !ok {
+			continue
+		}
+		i := strings.Index(ln, ": ")
+		if i <= 0 {
+			continue
+		}
+		out[filepath.Clean(ln[:i])] = ln[i+2:]
+	}
+	return out
+}
+
+// parseSHA256Sums maps the names in sha256sum output to their hashes. A
+// leading backslash marks a line whose name has its backslashes, newlines and
+// carriage returns escaped.
+func parseSHA256Sums(out string) map[string]string {
+	sums := map[string]string{}
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		ln := s.Text()
+		escaped := strings.HasPrefix(ln, "\\")
+		ln = strings.TrimPrefix(ln, "\\")
+		sum, name, ok := strings.Cut(ln, " ")
+		if !ok || name == "" || (name[0] != ' ' && name[0] != '*') {
+			continue
+		}
+		name = name[1:]
+		if escaped {
+			name = sha256sumUnescaper.Replace(name)
+		}
+		sums[filepath.Clean(name)] = sum
+	}
+	return sums
+}
+
+var sha256sumUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
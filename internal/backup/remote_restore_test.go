@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSHA256Sums(t *testing.T) {
+	const (
+		a = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+		b = "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d"
+	)
+	out := a + "  etc/hosts\n" +
+		b + "  etc/two  spaces\n" +
+		a + " *bin/tool\n" +
+		"\\" + b + `  etc/back\\slash` + "\n" +
+		"\\" + a + `  etc/new\nline` + "\n" +
+		"sha256sum: etc/missing: No such file or directory\n" +
+		a + "  ./etc/./dotted\n" +
+		"\n"
+	want := map[string]string{
+		"etc/hosts":       a,
+		"etc/two  spaces": b,
+		"bin/tool":        a,
+		`etc/back\slash`:  b,
+		"etc/new\nline":   a,
+		"etc/dotted":      a,
+	}
+	if got := parseSHA256Sums(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSHA256Sums = %q\nwant %q", got, want)
+	}
+}
+
+func TestParseToolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		prefix string
+		want   map[string]string
+	}{
+		{
+			name: "tar",
+			stderr: "tar: Removing leading `/' from member names\n" +
+				"tar: etc/shadow: Cannot open: Permission denied\n" +
+				"  tar: etc/./ssh/sshd_config: Cannot change ownership to uid 0, gid 0: Operation not permitted\n" +
+				"tar: Exiting with failure status due to previous errors\n",
+			prefix: "tar: ",
+			want: map[string]string{
+				"etc/shadow":          "Cannot open: Permission denied",
+				"etc/ssh/sshd_config": "Cannot change ownership to uid 0, gid 0: Operation not permitted",
+			},
+		},
+		{
+			name:   "sha256sum",
+			stderr: "sha256sum: etc/missing: No such file or directory\n",
+			prefix: "sha256sum: ",
+			want:   map[string]string{"etc/missing": "No such file or directory"},
+		},
+		{
+			name:   "other tool",
+			stderr: "ssh: connect to host db1 port 22: Connection refused\n",
+			prefix: "tar: ",
+			want:   map[string]string{},
+		},
+		{name: "empty", prefix: "tar: ", want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseToolErrors(tt.stderr, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseToolErrors = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
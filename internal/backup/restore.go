@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -46,25 +47,12 @@ func Restore(opts RestoreOptions) error {
 		if err != nil {
 			return err
 		}
-		name := filepath.Clean(h.Name)
-		if name == "." || name == string(filepath.Separator) {
+		name, ok := memberName(h.Name)
+		if !ok {
 			continue
 		}
-		if len(opts.Items) > 0 {
-			match := false
-			for _, it := range opts.Items {
-				it = strings.TrimPrefix(filepath.Clean(it), string(filepath.Separator))
-				if it == "" {
-					continue
-				}
-				if strings.HasPrefix(name, it) {
-					match = true
-					break
-				}
-			}
-			if !match {
-				continue
-			}
+		if !matchItems(name, opts.Items) {
+			continue
 		}
 
 		dest := filepath.Join(opts.TargetDir, name)
@@ -87,3 +75,47 @@ func Restore(opts RestoreOptions) error {
 	}
 	return nil
 }
+
+// memberName cleans an archive member name and makes it relative to the
+// restore target, as tar does. It reports false for the root itself and for
+// names that climb out of the target ("..", "../x"); names that merely start
+// with dots, such as "..foo", are kept.
+func memberName(name string) (string, bool) {
+	n := path.Clean(filepath.ToSlash(name))
+	if n == "." || n == "/" || n == ".." || strings.HasPrefix(n, "../") {
+		return "", false
+	}
+	return filepath.FromSlash(strings.TrimLeft(n, "/")), true
+}
+
+func matchItems(name string, items []string) bool {
+	if len(items) == 0 {
+		return true
+	}
+	for _, it := range items {
+		it = strings.TrimPrefix(filepath.Clean(it), string(filepath.Separator))
+		if it == "" {
+			continue
+		}
+		if strings.HasPrefix(name, it) {
+			return true
+		}
+	}
+	return false
+}
+
+func openArchive(path string) (*tar.Reader, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return tar.NewReader(f), func() { _ = f.Close() }, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(gz), func() { _ = gz.Close(); _ = f.Close() }, nil
+}
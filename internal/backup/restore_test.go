@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeArchive writes an uncompressed tar holding files (name -> content)
+// in the given order.
+func writeArchive(t *testing.T, names []string, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "b.tar")
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, n := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: n, Mode: 0o644, Size: int64(len(files[n])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[n])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMemberName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"etc/hosts", "etc/hosts", true},
+		{"/etc/hosts", "etc/hosts", true},
+		{"//etc/./hosts", "etc/hosts", true},
+		{"./etc//hosts", "etc/hosts", true},
+		{"..foo", "..foo", true},
+		{"etc/...bak", "etc/...bak", true},
+		{"a/../b", "b", true},
+		{".", "", false},
+		{"/", "", false},
+		{"..", "", false},
+		{"../etc/passwd", "", false},
+		{"a/../../etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := memberName(tt.name)
+		if got != filepath.FromSlash(tt.want) || ok != tt.ok {
+			t.Errorf("memberName(%q) = %q, %t; want %q, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRestoreSkipsEscapingMembers(t *testing.T) {
+	names := []string{"..foo", "...bak", "../evil", "a/../../evil2", "etc/hosts", "/abs/file"}
+	files := map[string]string{"..foo": "1", "...bak": "2", "../evil": "x", "a/../../evil2": "x", "etc/hosts": "3", "/abs/file": "4"}
+	archive := writeArchive(t, names, files)
+
+	t.Run("local", func(t *testing.T) {
+		base := t.TempDir()
+		target := filepath.Join(base, "target")
+		if err := Restore(RestoreOptions{BackupPath: archive, TargetDir: target}); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []string{"..foo", "...bak", "etc/hosts", "/abs/file"} {
+			if b, err := os.ReadFile(filepath.Join(target, n)); err != nil || string(b) != files[n] {
+				t.Errorf("%s = %q, %v; want %q", n, b, err, files[n])
+			}
+		}
+		for _, n := range []string{"evil", "evil2"} {
+			if _, err := os.Stat(filepath.Join(base, n)); err == nil {
+				t.Errorf("%s was written outside the target", n)
+			}
+		}
+	})
+
+	t.Run("remote staging", func(t *testing.T) {
+		var buf bytes.Buffer
+		staged, err := stageItems(archive, nil, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range staged {
+			got = append(got, e.name)
+		}
+		if want := []string{"..foo", "...bak", filepath.FromSlash("etc/hosts"), filepath.FromSlash("abs/file")}; !reflect.DeepEqual(got, want) {
+			t.Errorf("staged %v, want %v", got, want)
+		}
+		staged, err = stageItems(archive, []string{"/abs"}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(staged) != 1 || staged[0].name != filepath.FromSlash("abs/file") {
+			t.Errorf("staged %+v for item /abs, want abs/file", staged)
+		}
+	})
+}
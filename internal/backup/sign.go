@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"fortis-admin/internal/signing"
+)
+
+// ManifestEntry is one archive member. Symbolic links have Type "symlink"
+// and their Target instead of a hash; regular files leave Type empty.
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Type   string `json:"type,omitempty"`
+	Target string `json:"target,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   uint32 `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+const manifestSymlink = "symlink"
+
+type Manifest struct {
+	BackupID  string          `json:"backup_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// Signature is stored next to the archive as <id>.sig and covers the exact
+// bytes of the metadata and manifest sidecars.
+type Signature struct {
+	Algorithm      string    `json:"algorithm"`
+	KeyID          string    `json:"key_id"`
+	BackupID       string    `json:"backup_id"`
+	MetaSHA256     string    `json:"meta_sha256"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	SignedAt       time.Time `json:"signed_at"`
+	Signature      string    `json:"signature"`
+}
+
+func (s Signature) payload() []byte {
+	return []byte(fmt.Sprintf("fortis-backup-sig-v1\n%s\n%s\n%s\n", s.BackupID, s.MetaSHA256, s.ManifestSHA256))
+}
+
+func signBackup(priv ed25519.PrivateKey, backupID, metaPath, manifestPath string) (Signature, error) {
+	metaSum, _, err := sha256File(metaPath)
+	if err != nil {
+		return Signature{}, err
+	}
+	manSum, _, err := sha256File(manifestPath)
+	if err != nil {
+		return Signature{}, err
+	}
+	sig := Signature{
+		Algorithm:      signing.Algorithm,
+		KeyID:          signing.PrivateKeyID(priv),
+		BackupID:       backupID,
+		MetaSHA256:     metaSum,
+		ManifestSHA256: manSum,
+		SignedAt:       time.Now(),
+	}
+	sig.Signature = signing.Sign(priv, sig.payload())
+	return sig, nil
+}
+
+type SignatureStatus string
+
+const (
+	SignatureValid     SignatureStatus = "valid"
+	SignatureMissing   SignatureStatus = "missing"
+	SignatureInvalid   SignatureStatus = "invalid"
+	SignatureUntrusted SignatureStatus = "untrusted"
+)
+
+func verifyBackupSignature(archivePath string, trusted []ed25519.PublicKey) (SignatureStatus, string, error) {
+	b, err := os.ReadFile(sidecarPath(archivePath, ".sig"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return SignatureMissing, "", nil
+		}
+		return "", "", err
+	}
+	var sig Signature
+	if err := json.Unmarshal(b, &sig); err != nil {
+		return SignatureInvalid, "", nil
+	}
+	if sig.Algorithm != signing.Algorithm {
+		return SignatureInvalid, sig.KeyID, nil
+	}
+
+	metaSum, _, err := sha256File(sidecarPath(archivePath, ".meta.json"))
+	if err != nil || metaSum != sig.MetaSHA256 {
+		return SignatureInvalid, sig.KeyID, nil
+	}
+	manSum, _, err := sha256File(sidecarPath(archivePath, ".manifest.json"))
+	if err != nil || manSum != sig.ManifestSHA256 {
+		return SignatureInvalid, sig.KeyID, nil
+	}
+
+	switch err := signing.Verify(trusted, sig.KeyID, sig.payload(), sig.Signature); {
+	case err == nil:
+		return SignatureValid, sig.KeyID, nil
+	case errors.Is(err, signing.ErrUntrusted):
+		return SignatureUntrusted, sig.KeyID, nil
+	default:
+		return SignatureInvalid, sig.KeyID, nil
+	}
+}
+
+func writeJSONFile(path string, v any, perm os.FileMode) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), perm)
+}
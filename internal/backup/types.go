@@ -1,6 +1,14 @@
 package backup
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrPartialBackup = errors.New("backup completed with file errors")
+	ErrTooManyErrors = errors.New("backup aborted: error threshold exceeded")
+)
 
 type Compression string
 
@@ -26,19 +34,42 @@ type CreateOptions struct {
 	Exclude   []string
 	Encrypt   bool
 	Compress  Compression
+
+	// SigningKey is a PEM-encoded ed25519 private key used to sign the
+	// metadata and manifest sidecars and the chain log entry.
+	SigningKey string
+
+	// Retries is how many times a file that changes while being read is
+	// re-read before it is archived as-is and reported.
+	Retries    int
+	RetryDelay time.Duration
+	// MaxErrors aborts the backup once more than this many files failed.
+	// Zero means no limit.
+	MaxErrors int
+}
+
+type FileError struct {
+	Path     string `json:"path" yaml:"path"`
+	Op       string `json:"op" yaml:"op"`
+	Attempts int    `json:"attempts" yaml:"attempts"`
+	Error    string `json:"error" yaml:"error"`
 }
 
 type BackupMeta struct {
-	ID             string     `json:"id" yaml:"id"`
-	CreatedAt      time.Time  `json:"created_at" yaml:"created_at"`
-	Type           BackupType `json:"type" yaml:"type"`
-	Sources        []string   `json:"sources" yaml:"sources"`
-	ArchivePath    string     `json:"archive_path" yaml:"archive_path"`
-	SizeBytes      int64      `json:"size_bytes" yaml:"size_bytes"`
-	ChecksumSHA256 string     `json:"sha256" yaml:"sha256"`
-	Encrypted      bool       `json:"encrypted" yaml:"encrypted"`
-	Compression    string     `json:"compression" yaml:"compression"`
-	Notes          []string   `json:"notes" yaml:"notes"`
+	ID             string      `json:"id" yaml:"id"`
+	CreatedAt      time.Time   `json:"created_at" yaml:"created_at"`
+	Type           BackupType  `json:"type" yaml:"type"`
+	Sources        []string    `json:"sources" yaml:"sources"`
+	ArchivePath    string      `json:"archive_path" yaml:"archive_path"`
+	SizeBytes      int64       `json:"size_bytes" yaml:"size_bytes"`
+	ChecksumSHA256 string      `json:"sha256" yaml:"sha256"`
+	Encrypted      bool        `json:"encrypted" yaml:"encrypted"`
+	Compression    string      `json:"compression" yaml:"compression"`
+	ManifestSHA256 string      `json:"manifest_sha256,omitempty" yaml:"manifest_sha256,omitempty"`
+	SigningKeyID   string      `json:"signing_key_id,omitempty" yaml:"signing_key_id,omitempty"`
+	Partial        bool        `json:"partial" yaml:"partial"`
+	Errors         []FileError `json:"errors,omitempty" yaml:"errors,omitempty"`
+	Notes          []string    `json:"notes" yaml:"notes"`
 }
 
 type ListOptions struct {
@@ -54,13 +85,20 @@ type VerifyOptions struct {
 	Quick      bool
 	Full       bool
 	Repair     bool
+
+	TrustedKeys      []string
+	RequireSignature bool
+	Chain            bool
 }
 
 type VerifyResult struct {
-	BackupPath string `json:"backup_path"`
-	OK         bool   `json:"ok"`
-	Reason     string `json:"reason"`
-	SHA256     string `json:"sha256"`
+	BackupPath string          `json:"backup_path"`
+	OK         bool            `json:"ok"`
+	Reason     string          `json:"reason"`
+	SHA256     string          `json:"sha256"`
+	Signature  SignatureStatus `json:"signature,omitempty"`
+	KeyID      string          `json:"key_id,omitempty"`
+	Chain      *ChainReport    `json:"chain,omitempty"`
 }
 
 type RestoreOptions struct {
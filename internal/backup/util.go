@@ -49,3 +49,18 @@ func matchAnyGlob(name string, globs []string) bool {
 	}
 	return false
 }
+
+// sidecarPath maps an archive path to one of its sidecar files
+// (e.g. backup-x.tar.gz -> backup-x.meta.json).
+func sidecarPath(archivePath, suffix string) string {
+	base := archivePath
+	switch {
+	case strings.HasSuffix(base, ".tar.gz"):
+		base = strings.TrimSuffix(base, ".tar.gz")
+	case strings.HasSuffix(base, ".tar"):
+		base = strings.TrimSuffix(base, ".tar")
+	default:
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return base + suffix
+}
@@ -1,11 +1,18 @@
 package backup
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"fortis-admin/internal/signing"
 )
 
 func Verify(opts VerifyOptions) (VerifyResult, error) {
@@ -26,11 +33,36 @@ func Verify(opts VerifyOptions) (VerifyResult, error) {
 
 	res := VerifyResult{BackupPath: opts.BackupPath, OK: true, SHA256: sum}
 
-	// If there is a sidecar meta file, validate checksum.
-	metaPath := strings.TrimSuffix(opts.BackupPath, filepath.Ext(opts.BackupPath)) + ".meta.json"
-	if strings.HasSuffix(opts.BackupPath, ".tar.gz") {
-		metaPath = strings.TrimSuffix(opts.BackupPath, ".tar.gz") + ".meta.json"
+	trusted, err := signing.LoadPublicKeys(opts.TrustedKeys)
+	if err != nil {
+		return VerifyResult{}, err
 	}
+
+	if opts.Chain {
+		chain, err := VerifyChain(filepath.Dir(opts.BackupPath), trusted)
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		res.Chain = &chain
+	}
+
+	// Check the signature before trusting anything the sidecars say.
+	if len(trusted) > 0 || opts.RequireSignature {
+		status, keyID, err := verifyBackupSignature(opts.BackupPath, trusted)
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		res.Signature = status
+		res.KeyID = keyID
+		if status != SignatureValid {
+			res.OK = false
+			res.Reason = "signature " + string(status)
+			return res, nil
+		}
+	}
+
+	// If there is a sidecar meta file, validate checksum.
+	metaPath := sidecarPath(opts.BackupPath, ".meta.json")
 	if b, err := os.ReadFile(metaPath); err == nil {
 		var raw map[string]any
 		if err := json.Unmarshal(b, &raw); err == nil {
@@ -55,6 +87,19 @@ func Verify(opts VerifyOptions) (VerifyResult, error) {
 			res.Reason = "restore simulation failed: " + err.Error()
 			return res, nil
 		}
+		if reason, err := verifyManifest(opts.BackupPath); err != nil {
+			return VerifyResult{}, err
+		} else if reason != "" {
+			res.OK = false
+			res.Reason = reason
+			return res, nil
+		}
+	}
+
+	if res.Chain != nil && !res.Chain.OK {
+		res.OK = false
+		res.Reason = "backup chain log verification failed"
+		return res, nil
 	}
 
 	if opts.Quick {
@@ -64,3 +109,74 @@ func Verify(opts VerifyOptions) (VerifyResult, error) {
 	}
 	return res, nil
 }
+
+// verifyManifest re-hashes every archive member and compares it with the
+// manifest sidecar. It returns a non-empty reason on mismatch.
+func verifyManifest(archivePath string) (string, error) {
+	b, err := os.ReadFile(sidecarPath(archivePath, ".manifest.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "manifest unreadable: " + err.Error(), nil
+	}
+	want := map[string]ManifestEntry{}
+	for _, e := range m.Files {
+		want[e.Path] = e
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(archivePath, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "archive unreadable: " + err.Error(), nil
+		}
+		e, ok := want[hdr.Name]
+		if !ok {
+			return "archive member not in manifest: " + hdr.Name, nil
+		}
+		delete(want, hdr.Name)
+		if e.Type == manifestSymlink || hdr.Typeflag == tar.TypeSymlink {
+			if e.Type != manifestSymlink || hdr.Typeflag != tar.TypeSymlink {
+				return "manifest type mismatch: " + hdr.Name, nil
+			}
+			if hdr.Linkname != e.Target {
+				return "manifest link target mismatch: " + hdr.Name, nil
+			}
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return "archive unreadable: " + err.Error(), nil
+		}
+		if hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+			return "manifest hash mismatch: " + hdr.Name, nil
+		}
+	}
+	for p := range want {
+		return "manifest entry missing from archive: " + p, nil
+	}
+	return "", nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"fortis-admin/internal/app"
 	"fortis-admin/internal/backup"
+	"fortis-admin/internal/signing"
 )
 
 func newBackupCmd(a *app.App) *cobra.Command {
@@ -42,6 +43,7 @@ func newBackupCmd(a *app.App) *cobra.Command {
 	cmd.AddCommand(newBackupSnapshotCmd(a))
 	cmd.AddCommand(newBackupMonitorCmd(a))
 	cmd.AddCommand(newBackupTestDRCmd(a))
+	cmd.AddCommand(newBackupKeygenCmd(a))
 	setGroupHelp(cmd, "BACKUP & RECOVERY COMMANDS", "fortis backup [command] [flags]", func(w io.Writer) {
 		_ = retention
 		_ = threads
@@ -55,7 +57,10 @@ func newBackupCmd(a *app.App) *cobra.Command {
 		io.WriteString(w, "    --type string                 Backup type (full, incremental, differential)\n")
 		io.WriteString(w, "    --exclude strings             Patterns to exclude\n")
 		io.WriteString(w, "    --encrypt                     Encrypt backup\n")
-		io.WriteString(w, "    --compress string             Compression algorithm (gzip, zstd, lz4, none)\n\n")
+		io.WriteString(w, "    --compress string             Compression algorithm (gzip, zstd, lz4, none)\n")
+		io.WriteString(w, "    --sign-key string             Ed25519 private key used to sign metadata\n")
+		io.WriteString(w, "    --retries int                 Re-read attempts for files modified during backup\n")
+		io.WriteString(w, "    --max-errors int              Abort when more than this many files fail\n\n")
 
 		io.WriteString(w, "  list [flags]                    List available backups\n")
 		io.WriteString(w, "    --detailed                    Show detailed information\n")
@@ -67,14 +72,19 @@ func newBackupCmd(a *app.App) *cobra.Command {
 		io.WriteString(w, "    --backup string               Backup to verify\n")
 		io.WriteString(w, "    --quick                       Quick verification (checksums only)\n")
 		io.WriteString(w, "    --full                        Full verification (restore test)\n")
-		io.WriteString(w, "    --repair                      Attempt to repair corrupt backups\n\n")
+		io.WriteString(w, "    --repair                      Attempt to repair corrupt backups\n")
+		io.WriteString(w, "    --trusted-key strings         Trusted Ed25519 public keys\n")
+		io.WriteString(w, "    --require-signature           Fail when the backup is not signed\n")
+		io.WriteString(w, "    --chain                       Verify the hash-chained backup log\n\n")
 
 		io.WriteString(w, "  restore [flags]                 Restore from backup\n")
 		io.WriteString(w, "    --backup string               Backup to restore from\n")
 		io.WriteString(w, "    --target string               Restore target location\n")
 		io.WriteString(w, "    --items strings               Specific items to restore\n")
 		io.WriteString(w, "    --time string                 Point-in-time recovery\n")
-		io.WriteString(w, "    --dry-run                     Simulation mode\n\n")
+		io.WriteString(w, "    --dry-run                     Simulation mode\n")
+		io.WriteString(w, "    --host string                 Restore to a remote inventory host over SSH\n")
+		io.WriteString(w, "    --pre-backup                  Back up overwritten remote files first\n\n")
 
 		io.WriteString(w, "  schedule [flags]                Manage backup schedules\n")
 		io.WriteString(w, "    --add string                  Add new schedule\n")
@@ -103,6 +113,10 @@ func newBackupCmd(a *app.App) *cobra.Command {
 		io.WriteString(w, "    --automated                   Automated test\n")
 		io.WriteString(w, "    --report                      Generate test report\n\n")
 
+		io.WriteString(w, "  keygen [flags]                  Generate a backup signing key pair\n")
+		io.WriteString(w, "    --private string              Private key output path\n")
+		io.WriteString(w, "    --public string               Public key output path\n\n")
+
 		io.WriteString(w, "FLAGS:\n")
 		io.WriteString(w, "  --retention string            Retention policy (e.g., \"30d\", \"12M\", \"2y\")\n")
 		io.WriteString(w, "  --threads int                 Number of parallel threads\n")
@@ -111,8 +125,10 @@ func newBackupCmd(a *app.App) *cobra.Command {
 
 		io.WriteString(w, "EXAMPLES:\n")
 		io.WriteString(w, "  fortis backup create --source /home /etc --target /backups --encrypt\n")
+		io.WriteString(w, "  fortis backup verify --backup /backups/backup-x.tar.gz --trusted-key /etc/fortis/backup.pub --chain\n")
 		io.WriteString(w, "  fortis backup list --detailed --sort date\n")
 		io.WriteString(w, "  fortis backup restore --backup backup-2024-01-01 --target /recovery\n")
+		io.WriteString(w, "  fortis backup restore --backup /backups/backup-x.tar.gz --host web01 --items etc/nginx --pre-backup\n")
 		io.WriteString(w, "  fortis backup schedule --add \"daily at 2am\"\n")
 		io.WriteString(w, "  fortis backup test-dr --scenario full-restore --automated\n")
 	})
@@ -122,14 +138,16 @@ func newBackupCmd(a *app.App) *cobra.Command {
 
 func newBackupCreateCmd(a *app.App) *cobra.Command {
 	var (
-		target   string
-		sources  []string
-		btype    string
-		exclude  []string
-		encrypt  bool
-		compress string
+		target    string
+		sources   []string
+		btype     string
+		exclude   []string
+		encrypt   bool
+		compress  string
+		signKey   string
+		retries   int
+		maxErrors int
 	)
-	_ = a
 	cmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create new backup",
@@ -140,20 +158,39 @@ func newBackupCreateCmd(a *app.App) *cobra.Command {
 			if strings.TrimSpace(target) == "" {
 				target = "./backups"
 			}
+			if signKey == "" {
+				signKey = a.Config.BackupSigningKey
+			}
 			meta, err := backup.Create(backup.CreateOptions{
-				TargetDir: target,
-				Sources:   sources,
-				Type:      backup.BackupType(btype),
-				Exclude:   exclude,
-				Encrypt:   encrypt,
-				Compress:  backup.Compression(compress),
+				TargetDir:  target,
+				Sources:    sources,
+				Type:       backup.BackupType(btype),
+				Exclude:    exclude,
+				Encrypt:    encrypt,
+				Compress:   backup.Compression(compress),
+				SigningKey: signKey,
+				Retries:    retries,
+				MaxErrors:  maxErrors,
 			})
-			if err != nil {
-				return err
-			}
 			enc := json.NewEncoder(cmd.OutOrStdout())
 			enc.SetIndent("", "  ")
-			return enc.Encode(meta)
+			if errors.Is(err, backup.ErrTooManyErrors) {
+				// No archive was kept; the file errors say why.
+				if encErr := enc.Encode(struct {
+					ID     string             `json:"id"`
+					Errors []backup.FileError `json:"errors"`
+				}{meta.ID, meta.Errors}); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+			if err != nil && !errors.Is(err, backup.ErrPartialBackup) {
+				return err
+			}
+			if encErr := enc.Encode(meta); encErr != nil {
+				return encErr
+			}
+			return err
 		},
 	}
 	cmd.Flags().StringVar(&target, "target", "", "Backup target directory")
@@ -162,6 +199,9 @@ func newBackupCreateCmd(a *app.App) *cobra.Command {
 	cmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Patterns to exclude")
 	cmd.Flags().BoolVar(&encrypt, "encrypt", false, "Encrypt backup")
 	cmd.Flags().StringVar(&compress, "compress", "gzip", "Compression algorithm (gzip, zstd, lz4, none)")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Ed25519 private key (PEM) used to sign metadata (default from config)")
+	cmd.Flags().IntVar(&retries, "retries", 2, "Re-read attempts for files modified during backup")
+	cmd.Flags().IntVar(&maxErrors, "max-errors", 0, "Abort when more than this many files fail (0 = no limit)")
 	return cmd
 }
 
@@ -210,42 +250,102 @@ func newBackupVerifyCmd(a *app.App) *cobra.Command {
 		quick      bool
 		full       bool
 		repair     bool
+		trusted    []string
+		requireSig bool
+		chain      bool
 	)
-	_ = a
 	cmd := &cobra.Command{
 		Use:   "verify",
 		Short: "Verify backup integrity",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			res, err := backup.Verify(backup.VerifyOptions{BackupPath: backupPath, Quick: quick, Full: full, Repair: repair})
+			if len(trusted) == 0 {
+				trusted = a.Config.BackupTrustedKeys
+			}
+			res, err := backup.Verify(backup.VerifyOptions{
+				BackupPath:       backupPath,
+				Quick:            quick,
+				Full:             full,
+				Repair:           repair,
+				TrustedKeys:      trusted,
+				RequireSignature: requireSig,
+				Chain:            chain,
+			})
 			if err != nil {
 				return err
 			}
 			enc := json.NewEncoder(cmd.OutOrStdout())
 			enc.SetIndent("", "  ")
-			return enc.Encode(res)
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+			if !res.OK {
+				return fmt.Errorf("verification failed: %s", res.Reason)
+			}
+			return nil
 		},
 	}
 	cmd.Flags().StringVar(&backupPath, "backup", "", "Backup to verify")
 	cmd.Flags().BoolVar(&quick, "quick", false, "Quick verification (checksums only)")
 	cmd.Flags().BoolVar(&full, "full", false, "Full verification (restore test)")
 	cmd.Flags().BoolVar(&repair, "repair", false, "Attempt to repair corrupt backups")
+	cmd.Flags().StringSliceVar(&trusted, "trusted-key", nil, "Trusted Ed25519 public keys (PEM) (default from config)")
+	cmd.Flags().BoolVar(&requireSig, "require-signature", false, "Fail when the backup is not signed by a trusted key")
+	cmd.Flags().BoolVar(&chain, "chain", false, "Verify the hash-chained backup log in the backup directory")
 	return cmd
 }
 
 func newBackupRestoreCmd(a *app.App) *cobra.Command {
 	var (
-		backupPath string
-		target     string
-		items      []string
-		timePt     string
-		dryRun     bool
+		backupPath   string
+		target       string
+		items        []string
+		timePt       string
+		dryRun       bool
+		host         string
+		preBackup    bool
+		preBackupDir string
+		invPath      string
+		sshUser      string
+		sshKey       string
+		sshPort      int
+		sshTimeout   int
 	)
-	_ = a
 	cmd := &cobra.Command{
 		Use:   "restore",
 		Short: "Restore from backup",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			_ = timePt
+			if host != "" {
+				if invPath == "" {
+					invPath = a.Config.InventoryFile
+				}
+				res, err := backup.RestoreRemote(cmd.Context(), backup.RemoteRestoreOptions{
+					BackupPath:    backupPath,
+					Host:          host,
+					TargetDir:     target,
+					Items:         items,
+					DryRun:        dryRun,
+					PreBackup:     preBackup,
+					PreBackupDir:  preBackupDir,
+					InventoryPath: invPath,
+					SSHUser:       sshUser,
+					SSHPort:       sshPort,
+					SSHKey:        sshKey,
+					SSHTimeout:    time.Duration(sshTimeout) * time.Second,
+				})
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(res); err != nil {
+					return err
+				}
+				if !res.OK {
+					return fmt.Errorf("remote restore to %s: %d of %d items failed", host, res.Failed, len(res.Files))
+				}
+				return nil
+			}
 			if err := backup.Restore(backup.RestoreOptions{BackupPath: backupPath, TargetDir: target, Items: items, DryRun: dryRun}); err != nil {
 				return err
 			}
@@ -258,10 +358,18 @@ func newBackupRestoreCmd(a *app.App) *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&backupPath, "backup", "", "Backup to restore from")
-	cmd.Flags().StringVar(&target, "target", "", "Restore target location")
+	cmd.Flags().StringVar(&target, "target", "", "Restore target location (remote default: /)")
 	cmd.Flags().StringSliceVar(&items, "items", nil, "Specific items to restore")
 	cmd.Flags().StringVar(&timePt, "time", "", "Point-in-time recovery")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Simulation mode")
+	cmd.Flags().StringVar(&host, "host", "", "Restore to a remote host from the cluster inventory")
+	cmd.Flags().BoolVar(&preBackup, "pre-backup", false, "Back up files that will be overwritten on the remote host first")
+	cmd.Flags().StringVar(&preBackupDir, "pre-backup-dir", "/var/backups/fortis", "Remote directory for pre-restore backups")
+	cmd.Flags().StringVar(&invPath, "inventory-file", "", "Inventory file (default from config)")
+	cmd.Flags().StringVar(&sshUser, "ssh-user", "", "SSH username (default from inventory)")
+	cmd.Flags().StringVar(&sshKey, "ssh-key", "", "SSH key to use")
+	cmd.Flags().IntVar(&sshPort, "ssh-port", 22, "SSH port")
+	cmd.Flags().IntVar(&sshTimeout, "ssh-timeout", 30, "SSH timeout in seconds")
 	return cmd
 }
 
@@ -400,3 +508,31 @@ func newBackupTestDRCmd(a *app.App) *cobra.Command {
 	cmd.Flags().BoolVar(&dryRun, "dry-run", true, "Simulation mode")
 	return cmd
 }
+
+func newBackupKeygenCmd(a *app.App) *cobra.Command {
+	var (
+		privPath string
+		pubPath  string
+		force    bool
+	)
+	_ = a
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a backup signing key pair",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if strings.TrimSpace(privPath) == "" || strings.TrimSpace(pubPath) == "" {
+				return errors.New("--private and --public are required")
+			}
+			id, err := signing.GenerateKey(privPath, pubPath, force)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Generated ed25519 key %s\n  private: %s\n  public:  %s\n", id, privPath, pubPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&privPath, "private", "/etc/fortis/backup-signing.key", "Private key output path")
+	cmd.Flags().StringVar(&pubPath, "public", "/etc/fortis/backup-signing.pub", "Public key output path")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace existing key files")
+	return cmd
+}
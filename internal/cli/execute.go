@@ -4,5 +4,7 @@ import "os"
 
 func Execute() {
 	cmd := NewRootCmd(os.Stdout, os.Stderr)
-	_ = cmd.Execute()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -1,12 +1,14 @@
 package cli
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,7 +16,9 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"fortis-admin/internal/app"
+	"fortis-admin/internal/cluster"
 	"fortis-admin/internal/hardening"
+	"fortis-admin/internal/signing"
 )
 
 func newHardenCmd(a *app.App) *cobra.Command {
@@ -36,14 +40,24 @@ func newHardenCmd(a *app.App) *cobra.Command {
 	cmd.PersistentFlags().StringVar(&configDir, "config-dir", "", "Configuration directory")
 
 	cmd.AddCommand(newHardenAuditCmd(a))
+	cmd.AddCommand(newHardenChecksCmd(a))
 	cmd.AddCommand(newHardenApplyCmd(a))
+	cmd.AddCommand(newHardenRollbackCmd(a))
+	cmd.AddCommand(newHardenBaselineCmd(a))
+	cmd.AddCommand(newHardenDriftCmd(a))
+	cmd.AddCommand(newHardenWaiversCmd(a))
+	cmd.AddCommand(newHardenHistoryCmd(a))
 	cmd.AddCommand(newHardenSSHCmdd(a))
+	cmd.AddCommand(newHardenSSHDConfigCmd(a))
 	cmd.AddCommand(newHardenFirewallCmd(a))
 	cmd.AddCommand(newHardenKernelCmd(a))
 	cmd.AddCommand(newHardenUsersCmd(a))
+	cmd.AddCommand(newHardenSudoersCmd(a))
+	cmd.AddCommand(newHardenPAMCmd(a))
 	cmd.AddCommand(newHardenComplianceCmd(a))
 	cmd.AddCommand(newHardenAutoFixCmd(a))
 	cmd.AddCommand(newHardenFilesystemCmd(a))
+	cmd.AddCommand(newHardenFIMCmd(a))
 	cmd.AddCommand(newHardenPackageAuditCmd(a))
 	cmd.AddCommand(newHardenAuditdCmd(a))
 	cmd.AddCommand(newHardenLoggingCmd(a))
@@ -56,17 +70,67 @@ func newHardenCmd(a *app.App) *cobra.Command {
 
 		io.WriteString(w, "COMMANDS:\n")
 		io.WriteString(w, "  audit [flags]                    Run security audit and generate report\n")
-		io.WriteString(w, "    --profile string               Audit profile (cis, pci, hipaa, custom)\n")
-		io.WriteString(w, "    --output string                Output format/file (json, yaml, html, pdf)\n")
-		io.WriteString(w, "    --level string                 Audit level (basic, medium, strict)\n")
-		io.WriteString(w, "    --fix                          Auto-fix low-risk issues\n\n")
+		io.WriteString(w, "    --profile string               Audit profile (cis, pci, hipaa, custom, or <config-dir>/profiles/<name>.yaml)\n")
+		io.WriteString(w, "    --output string                Output format or file (json, yaml, html, sarif, junit, csv, md, pdf)\n")
+		io.WriteString(w, "    --level string                 Audit level (basic, medium, strict): selects checks and thresholds\n")
+		io.WriteString(w, "    --fix                          Auto-fix low-risk issues\n")
+		io.WriteString(w, "    --cis-level int                CIS benchmark level; 2 adds Level 2 checks (default from profile)\n")
+		io.WriteString(w, "    --waivers string               Waivers file (default <config-dir>/waivers.yaml)\n")
+		io.WriteString(w, "    --host-group strings           Groups for waiver selectors (default from inventory)\n")
+		io.WriteString(w, "    --root string                  Audit an image root dir, OCI layout or image/layer tarball offline\n")
+		io.WriteString(w, "    --vuln-feed strings            Vulnerability feeds for the packages.cve_* checks (default /var/lib/fortis/vulnfeeds)\n")
+		io.WriteString(w, "    --min-score int                Exit non-zero when the score is below this value (image gates)\n")
+		io.WriteString(w, "    --history-dir string           Audit history store (default /var/lib/fortis/history)\n")
+		io.WriteString(w, "    --no-history                   Do not record this run in the audit history\n")
+		io.WriteString(w, "    --group string                 Fleet audit: run on every inventory host in the group over SSH\n")
+		io.WriteString(w, "    --hosts strings                Fleet audit: run on these hosts (also --hosts-file)\n")
+		io.WriteString(w, "    --ship                         Fleet audit: copy this fortis binary to the hosts for the run\n")
+		io.WriteString(w, "    --sudo                         Fleet audit: run the remote audit with sudo -n\n")
+		io.WriteString(w, "    --fleet-dir string             Previous fleet runs for trends (default /var/lib/fortis/fleet)\n\n")
+
+		io.WriteString(w, "  checks [flags]                   List registered audit checks (built-in and YAML)\n")
+		io.WriteString(w, "    --tag string                   Only show checks with this tag\n")
+		io.WriteString(w, "    --profile string               Only show checks selected by this audit profile\n")
+		io.WriteString(w, "    --level string                 Audit level used with --profile\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  waivers [flags]                  List audit waivers, whether they apply here, and expiry\n")
+		io.WriteString(w, "    --file string                  Waivers file (default <config-dir>/waivers.yaml)\n")
+		io.WriteString(w, "    --expiring-days int            Flag waivers expiring within N days (default 30)\n\n")
+
+		io.WriteString(w, "  history [flags]                  Show audit scores over time with newly failing and fixed checks\n")
+		io.WriteString(w, "    --host string                  Host or image root (default: the latest run's)\n")
+		io.WriteString(w, "    --profile string               Audit profile (default: the latest run's)\n")
+		io.WriteString(w, "    --level string                 Audit level (default: the latest run's)\n")
+		io.WriteString(w, "    --limit int                    Show the last N runs (default 20)\n")
+		io.WriteString(w, "    --dir string                   History store (default /var/lib/fortis/history)\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
 
 		io.WriteString(w, "  apply [flags]                    Apply hardening configuration\n")
 		io.WriteString(w, "    --profile string               Hardening profile to apply\n")
 		io.WriteString(w, "    --dry-run                      Show changes without applying\n")
-		io.WriteString(w, "    --rollback                     Create rollback point\n")
 		io.WriteString(w, "    --skip-checks                  Skip pre-application checks\n\n")
 
+		io.WriteString(w, "  rollback [--list | <id>]         List or undo hardening transactions (apply, audit --fix, kernel, firewall)\n")
+		io.WriteString(w, "    --list                         List recorded transactions\n")
+		io.WriteString(w, "    --force                        Roll back even if later transactions touched the same targets\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  baseline save [flags]            Record audit findings and config state as the hardened baseline\n")
+		io.WriteString(w, "    --file string                  Baseline file (default /var/lib/fortis/baseline.json)\n")
+		io.WriteString(w, "    --profile string               Audit profile\n")
+		io.WriteString(w, "    --level string                 Audit level\n\n")
+
+		io.WriteString(w, "  drift [flags]                    Compare the host with the saved baseline (exit 1 on drift)\n")
+		io.WriteString(w, "    --baseline string              Baseline file (default /var/lib/fortis/baseline.json)\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  sshd-config [flags]              Show effective sshd settings and the file/line that set them\n")
+		io.WriteString(w, "    --file string                  Main sshd config (default /etc/ssh/sshd_config)\n")
+		io.WriteString(w, "    --key string                   Only show this option\n")
+		io.WriteString(w, "    --validate                     Compare with 'sshd -T' output\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
 		io.WriteString(w, "  ssh [flags]                      Configure SSH security\n")
 		io.WriteString(w, "    --disable-root                 Disable root SSH login\n")
 		io.WriteString(w, "    --port number                  Change SSH port\n")
@@ -77,25 +141,90 @@ func newHardenCmd(a *app.App) *cobra.Command {
 		io.WriteString(w, "    --profile string               Firewall profile (webserver, database, desktop)\n")
 		io.WriteString(w, "    --ports string                 Comma-separated list of ports to allow\n")
 		io.WriteString(w, "    --direction string             Rule direction (incoming, outgoing, both)\n")
+		io.WriteString(w, "    --allow-from strings           Restrict --ports rules to these addresses or CIDRs\n")
+		io.WriteString(w, "    --default-incoming string      Default incoming policy (drop, accept)\n")
+		io.WriteString(w, "    --default-outgoing string      Default outgoing policy (drop, accept)\n")
+		io.WriteString(w, "    --policy string                YAML firewall policy (default <config-dir>/firewall/<profile>.yaml)\n")
+		io.WriteString(w, "    --backend string               Firewall backend (nftables, iptables, ufw; default detected)\n")
+		io.WriteString(w, "    --no-ssh-guard                 Do not add a rule keeping sshd reachable\n")
+		io.WriteString(w, "    --confirm-timeout duration     Revert unless confirmed within this time (default 2m, 0 disables)\n")
 		io.WriteString(w, "    --save                         Save rules to persist after reboot\n\n")
 
+		io.WriteString(w, "  firewall confirm [id]            Keep a firewall change and cancel its revert timer\n\n")
+
+		io.WriteString(w, "  firewall analyze [flags]         Audit live rules: default-accept policies, any-any rules, uncovered ports\n")
+		io.WriteString(w, "    --profile string               Compare with <config-dir>/firewall/<profile>.yaml\n")
+		io.WriteString(w, "    --policy string                YAML firewall policy to compare with\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
 		io.WriteString(w, "  kernel [flags]                   Harden kernel parameters\n")
-		io.WriteString(w, "    --apply string                 Apply preset (network, memory, security)\n")
+		io.WriteString(w, "    --profile string               Kernel profile (network, security, memory, cis or <config-dir>/sysctl/<name>.yaml)\n")
 		io.WriteString(w, "    --param string                 Set specific kernel parameter\n")
 		io.WriteString(w, "    --value string                 Parameter value\n")
-		io.WriteString(w, "    --persist                      Make changes persistent\n\n")
+		io.WriteString(w, "    --persist                      Make changes persistent\n")
+		io.WriteString(w, "    --audit                        Compare runtime and persisted values with the profile\n")
+		io.WriteString(w, "    --list                         List kernel profiles\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  users [flags]                    Audit accounts: UID 0, empty/weak passwords, aging, inactive, duplicates, homes, authorized_keys\n")
+		io.WriteString(w, "    --audit                        Audit accounts (the default)\n")
+		io.WriteString(w, "    --lock-inactive                Lock and expire inactive accounts (transactional, needs --yes)\n")
+		io.WriteString(w, "    --inactive-days int            Days without login after which an account is inactive (default 90)\n")
+		io.WriteString(w, "    --password-policy              Set login.defs aging and hashing and the PAM policy (see pam --apply)\n")
+		io.WriteString(w, "    --sudo-secure                  Add missing sudo use_pty/logfile Defaults and a 15 minute idle shell timeout\n")
+		io.WriteString(w, "    --root string                  Audit the accounts of an image root or tarball\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  pam [flags]                      Audit PAM stacks, pwquality and faillock: length, complexity, lockout, history\n")
+		io.WriteString(w, "    --apply                        Edit the stacks and config files to the policy (transactional, validated, needs --yes)\n")
+		io.WriteString(w, "    --dry-run                      Show the edits without applying\n")
+		io.WriteString(w, "    --root string                  Audit the PAM configuration of an image root or tarball\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  sudoers [flags]                  Audit sudoers and its includes: NOPASSWD, wildcards, writable files, Defaults\n")
+		io.WriteString(w, "    --file string                  Main sudoers file (default /etc/sudoers)\n")
+		io.WriteString(w, "    --matrix                       Print who can run what, as whom (aliases expanded)\n")
+		io.WriteString(w, "    --root string                  Audit the sudoers of an image root or tarball\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  auditd [flags]                   Compare CIS audit rules (identity, sudoers, time, modules, SUID programs) with rules.d and auditctl -l\n")
+		io.WriteString(w, "    --apply                        Write /etc/audit/rules.d/50-fortis.rules and load it (transactional, validated, needs --yes)\n")
+		io.WriteString(w, "    --dry-run                      Show the changes without applying\n")
+		io.WriteString(w, "    --immutable                    With --apply, also lock the rules until reboot (-e 2)\n")
+		io.WriteString(w, "    --rules string                 Rule set (default cis, or <config-dir>/auditd/<name>.yaml)\n")
+		io.WriteString(w, "    --print                        Print the generated rules\n")
+		io.WriteString(w, "    --root string                  Compare with the rules.d of an image root or tarball\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
+
+		io.WriteString(w, "  package-audit [flags]            Match installed packages against offline vulnerability feeds\n")
+		io.WriteString(w, "    --import strings               Import feeds (OSV .json/.zip, Debian security tracker .json, OVAL .xml)\n")
+		io.WriteString(w, "    --feed strings                 Feed files or directories (default /var/lib/fortis/vulnfeeds)\n")
+		io.WriteString(w, "    --severity string              Only report vulnerabilities at or above this severity\n")
+		io.WriteString(w, "    --root string                  Audit the packages of an image root or tarball\n")
+		io.WriteString(w, "    --output string                Output format or file (json, yaml)\n\n")
+
+		io.WriteString(w, "  fim init [flags]                 Record hashes, permissions, owners and xattrs of the watched paths\n")
+		io.WriteString(w, "    --rules string                 Rules file (default <config-dir>/fim.yaml or built-in)\n")
+		io.WriteString(w, "    --db string                    Database file (default /var/lib/fortis/fim.db)\n")
+		io.WriteString(w, "    --sign-key string              Ed25519 private key (PEM) to sign the database (default from config)\n")
+		io.WriteString(w, "    --force                        Replace an existing database\n\n")
 
-		io.WriteString(w, "  users [flags]                    Manage user security\n")
-		io.WriteString(w, "    --lock-inactive                Lock inactive accounts\n")
-		io.WriteString(w, "    --password-policy              Set password policies\n")
-		io.WriteString(w, "    --sudo-secure                  Secure sudo configuration\n")
-		io.WriteString(w, "    --audit                        Audit user permissions\n\n")
+		io.WriteString(w, "  fim check [flags]                Report added, removed and changed files (exit 1 on changes)\n")
+		io.WriteString(w, "    --trusted-key strings          Trusted Ed25519 public keys (PEM) (default from config)\n")
+		io.WriteString(w, "    --require-signature            Fail unless the database is signed by a trusted key\n")
+		io.WriteString(w, "    --update                       Accept the changes as the new baseline\n")
+		io.WriteString(w, "    --json                         Output in JSON format\n\n")
 
 		io.WriteString(w, "  compliance [flags]               Generate compliance reports\n")
-		io.WriteString(w, "    --standard string              Compliance standard (pci-dss, hipaa, gdpr, iso27001)\n")
-		io.WriteString(w, "    --evidence                     Collect evidence for compliance\n")
-		io.WriteString(w, "    --gap-analysis                 Show compliance gaps\n")
-		io.WriteString(w, "    --export string                Export format (csv, pdf, json)\n\n")
+		io.WriteString(w, "    --standard string              Compliance standard (pci-dss, hipaa, iso27001, nist-800-53; gdpr is a deprecated alias of iso27001)\n")
+		io.WriteString(w, "    --evidence                     Write a signed evidence bundle (<report>-evidence.tar.gz)\n")
+		io.WriteString(w, "    --evidence-file string         Evidence bundle path\n")
+		io.WriteString(w, "    --sign-key string              Ed25519 private key (PEM) for the bundle (default from config)\n")
+		io.WriteString(w, "    --gap-analysis                 Per-control gap report, including controls that need manual review\n")
+		io.WriteString(w, "    --export string                Export format or file (json, yaml, html, sarif, junit, csv, md, pdf)\n\n")
+
+		io.WriteString(w, "  compliance verify <bundle>       Verify an evidence bundle's hashes and signature\n")
+		io.WriteString(w, "    --trusted-key strings          Trusted Ed25519 public keys (PEM) (default from config)\n\n")
 
 		io.WriteString(w, "  auto-fix [flags]                 Automatically fix security issues\n")
 		io.WriteString(w, "    --level string                 Fix level (low, medium, high, critical)\n")
@@ -107,11 +236,30 @@ func newHardenCmd(a *app.App) *cobra.Command {
 		io.WriteString(w, "  --backup                Create backup before making changes\n")
 		io.WriteString(w, "  --yes                   Auto-confirm all prompts\n")
 		io.WriteString(w, "  --log-file string       Log file location\n")
-		io.WriteString(w, "  --config-dir string     Configuration directory\n\n")
+		io.WriteString(w, "  --config-dir string     Configuration directory (YAML checks in <dir>/checks, default /etc/fortis/harden)\n\n")
 
 		io.WriteString(w, "EXAMPLES:\n")
 		io.WriteString(w, "  fortis harden audit --profile cis --output html\n")
+		io.WriteString(w, "  fortis harden audit --cis-level 2 --output json\n")
+		io.WriteString(w, "  fortis harden audit --output results.sarif\n")
+		io.WriteString(w, "  fortis harden audit --output junit   # CI: failing checks are failed tests\n")
+		io.WriteString(w, "  fortis harden audit --group production --ship --output fleet.html\n")
+		io.WriteString(w, "  fortis harden audit --root golden.tar --min-score 80 --output junit\n")
 		io.WriteString(w, "  fortis harden apply --profile webserver --dry-run\n")
+		io.WriteString(w, "  fortis harden rollback --list\n")
+		io.WriteString(w, "  fortis harden baseline save --profile cis --level medium\n")
+		io.WriteString(w, "  fortis harden drift --json\n")
+		io.WriteString(w, "  fortis harden kernel --profile cis --audit\n")
+		io.WriteString(w, "  fortis harden package-audit --import debian-12-osv.zip\n")
+		io.WriteString(w, "  fortis harden fim check --require-signature --json\n")
+		io.WriteString(w, "  fortis harden users --lock-inactive --inactive-days 90 --yes\n")
+		io.WriteString(w, "  fortis harden sudoers --matrix\n")
+		io.WriteString(w, "  fortis harden pam --apply --dry-run\n")
+		io.WriteString(w, "  fortis harden auditd --apply --yes\n")
+		io.WriteString(w, "  fortis harden compliance --standard pci-dss --evidence --sign-key /etc/fortis/evidence.key\n")
+		io.WriteString(w, "  fortis harden compliance verify report-evidence.tar.gz --trusted-key /etc/fortis/evidence.pub\n")
+		io.WriteString(w, "  fortis harden firewall --backend nftables --ports 22,443/tcp --allow-from 10.0.0.0/8 --yes\n")
+		io.WriteString(w, "  fortis harden firewall analyze --profile webserver\n")
 		io.WriteString(w, "  fortis harden ssh --disable-root --key-only\n")
 		io.WriteString(w, "  fortis harden auto-fix --level medium --confirm\n")
 	})
@@ -120,16 +268,37 @@ func newHardenCmd(a *app.App) *cobra.Command {
 
 func newHardenAuditCmd(a *app.App) *cobra.Command {
 	var (
-		profile string
-		output  string
-		level   string
-		fix     bool
+		profile    string
+		output     string
+		level      string
+		fix        bool
+		cisLevel   int
+		waivers    string
+		hostGroups []string
+		root       string
+		vulnFeeds  []string
+		minScore   int
+		historyDir string
+		noHistory  bool
+		fleet      fleetFlags
 	)
 	cmd := &cobra.Command{
 		Use:   "audit",
 		Short: "Run comprehensive security audit",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			yes := getBoolFlag(cmd, "yes")
+			if cisLevel < 0 || cisLevel > 2 {
+				return fmt.Errorf("--cis-level must be 1 or 2")
+			}
+			if fleet.enabled() {
+				if root != "" {
+					return errors.New("--root cannot be combined with fleet targets")
+				}
+				if fix {
+					return errors.New("--fix is not supported for fleet audits; run it per host")
+				}
+				return runFleetAudit(cmd, a, fleet, profile, level, output, cisLevel)
+			}
 
 			rep, err := hardening.RunAudit(cmd.Context(), hardening.AuditOptions{
 				Profile: profile,
@@ -138,13 +307,27 @@ func newHardenAuditCmd(a *app.App) *cobra.Command {
 				Fix:     fix,
 				Yes:     yes,
 				Verbose: a.Verbose,
+
+				ConfigDir:      getStringFlag(cmd, "config-dir"),
+				BenchmarkLevel: cisLevel,
+				WaiversFile:    waivers,
+				HostGroups:     resolveHostGroups(a, hostGroups),
+				Root:           root,
+				VulnFeeds:      vulnFeeds,
 			})
 			if err != nil {
 				return err
 			}
+			if !noHistory {
+				if _, err := hardening.RecordHistory(historyDir, rep); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "warning: audit history not saved: %v\n", err)
+				} else if trend, err := hardening.LoadTrend(historyDir, rep); err == nil {
+					rep.History = trend
+				}
+			}
 
 			format := hardening.DetectFormat(output)
-			path, outErr := resolveAuditOutputPath(output, format, rep.Timestamp)
+			path, outErr := resolveAuditOutputPath(output, "audit", format, rep.Timestamp)
 			if outErr != nil {
 				return outErr
 			}
@@ -163,24 +346,483 @@ func newHardenAuditCmd(a *app.App) *cobra.Command {
 			}
 
 			if a.Verbose {
-				fmt.Fprintf(cmd.OutOrStdout(), "📊  [STATS] Passed: %d | Failed: %d | Warnings: %d | Skipped: %d\n", rep.Passed, rep.Failed, rep.Warnings, rep.Skipped)
+				fmt.Fprintf(cmd.OutOrStdout(), "📊  [STATS] Passed: %d | Failed: %d | Warnings: %d | Skipped: %d | Waived: %d\n", rep.Passed, rep.Failed, rep.Warnings, rep.Skipped, rep.Waived)
 				fmt.Fprintf(cmd.OutOrStdout(), "🎯  [SCORE] Security Score: %d/100 (%s)\n", rep.Score, rep.ScoreLabel)
 				fmt.Fprintf(cmd.OutOrStdout(), "📁  [SAVE]  Report saved to: %s\n", path)
-				return nil
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "Audit complete. Score: %d/100 (%s). Report: %s\n", rep.Score, rep.ScoreLabel, path)
+			}
+			if rep.Waived > 0 || rep.ExpiredWaivers > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Waivers: %d findings waived, %d expired waivers (see 'fortis harden waivers')\n", rep.Waived, rep.ExpiredWaivers)
+			}
+			if rep.RemediationError != "" {
+				return fmt.Errorf("remediation failed: %s", rep.RemediationError)
+			}
+			printTransaction(cmd.OutOrStdout(), rep.Transaction)
+			if minScore > 0 && rep.Score < minScore {
+				return fmt.Errorf("score %d is below the required minimum %d", rep.Score, minScore)
 			}
-
-			fmt.Fprintf(cmd.OutOrStdout(), "Audit complete. Score: %d/100 (%s). Report: %s\n", rep.Score, rep.ScoreLabel, path)
 			return nil
 		},
 	}
 	cmd.Flags().StringVar(&profile, "profile", "cis", "Audit profile (cis, pci, hipaa, custom)")
-	cmd.Flags().StringVar(&output, "output", "", "Output format/file (json, yaml, html, pdf)")
+	cmd.Flags().StringVar(&output, "output", "", "Output format or file (json, yaml, html, sarif, junit, csv, md, pdf)")
 	cmd.Flags().StringVar(&level, "level", "basic", "Audit level (basic, medium, strict)")
 	cmd.Flags().BoolVar(&fix, "fix", false, "Auto-fix low-risk issues")
+	cmd.Flags().IntVar(&cisLevel, "cis-level", 0, "CIS benchmark profile level (1 or 2; default from the audit profile)")
+	cmd.Flags().StringVar(&waivers, "waivers", "", "Waivers file (default <config-dir>/waivers.yaml)")
+	cmd.Flags().StringSliceVar(&hostGroups, "host-group", nil, "Inventory groups of this host for waiver selectors (default from inventory)")
+	cmd.Flags().StringVar(&root, "root", "", "Audit an image: a mounted root directory, OCI layout, or image/layer tarball")
+	cmd.Flags().StringSliceVar(&vulnFeeds, "vuln-feed", nil, "Vulnerability feeds for the packages.cve_* checks (default /var/lib/fortis/vulnfeeds)")
+	cmd.Flags().IntVar(&minScore, "min-score", 0, "Exit with an error when the score is below this value")
+	cmd.Flags().StringVar(&historyDir, "history-dir", "", "Audit history store (default /var/lib/fortis/history)")
+	cmd.Flags().BoolVar(&noHistory, "no-history", false, "Do not record this run in the audit history")
+	fleet.register(cmd)
+	return cmd
+}
+
+// fleetFlags select remote hosts for "harden audit"; any target flag
+// switches the command from a local audit to a fleet audit.
+type fleetFlags struct {
+	group        string
+	hosts        []string
+	hostsFile    string
+	inventory    string
+	sshUser      string
+	sshKey       string
+	sshPort      int
+	sshTimeout   int
+	parallel     int
+	remoteBinary string
+	ship         bool
+	sudo         bool
+	historyDir   string
+}
+
+func (f *fleetFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.group, "group", "", "Audit every inventory host in this group")
+	cmd.Flags().StringSliceVar(&f.hosts, "hosts", nil, "Audit these remote hosts")
+	cmd.Flags().StringVar(&f.hostsFile, "hosts-file", "", "Audit the remote hosts listed in this file")
+	cmd.Flags().StringVar(&f.inventory, "inventory-file", "", "Inventory file (default from config)")
+	cmd.Flags().StringVar(&f.sshUser, "ssh-user", "", "SSH username (default from inventory)")
+	cmd.Flags().StringVar(&f.sshKey, "ssh-key", "", "SSH key to use")
+	cmd.Flags().IntVar(&f.sshPort, "ssh-port", 22, "SSH port")
+	cmd.Flags().IntVar(&f.sshTimeout, "ssh-timeout", 600, "Per-host audit timeout in seconds")
+	cmd.Flags().IntVar(&f.parallel, "parallel", 4, "Hosts audited in parallel")
+	cmd.Flags().StringVar(&f.remoteBinary, "remote-binary", "fortis", "fortis command on the remote hosts")
+	cmd.Flags().BoolVar(&f.ship, "ship", false, "Copy this fortis binary to each host for the audit")
+	cmd.Flags().BoolVar(&f.sudo, "sudo", false, "Run the remote audit with sudo -n")
+	cmd.Flags().StringVar(&f.historyDir, "fleet-dir", hardening.DefaultFleetDir, "Directory keeping the previous fleet run for trends")
+}
+
+func (f fleetFlags) enabled() bool {
+	return f.group != "" || len(f.hosts) > 0 || f.hostsFile != ""
+}
+
+func runFleetAudit(cmd *cobra.Command, a *app.App, f fleetFlags, profile, level, output string, cisLevel int) error {
+	inventory := f.inventory
+	if inventory == "" {
+		inventory = a.Config.InventoryFile
+	}
+	rep, err := hardening.RunFleetAudit(cmd.Context(), hardening.FleetOptions{
+		Group:          f.group,
+		Hosts:          f.hosts,
+		HostsFile:      f.hostsFile,
+		InventoryPath:  inventory,
+		SSHUser:        f.sshUser,
+		SSHPort:        f.sshPort,
+		SSHKey:         f.sshKey,
+		SSHTimeout:     time.Duration(f.sshTimeout) * time.Second,
+		Parallel:       f.parallel,
+		Profile:        profile,
+		Level:          level,
+		BenchmarkLevel: cisLevel,
+		RemoteBinary:   f.remoteBinary,
+		Ship:           f.ship,
+		Sudo:           f.sudo,
+		HistoryDir:     f.historyDir,
+	})
+	if err != nil && len(rep.Hosts) == 0 {
+		return err
+	}
+
+	format := hardening.DetectFormat(output)
+	path, outErr := resolveAuditOutputPath(output, "fleet-audit", format, rep.Timestamp)
+	if outErr != nil {
+		return outErr
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, ferr := os.Create(path)
+	if ferr != nil {
+		return ferr
+	}
+	defer file.Close()
+	if rerr := hardening.RenderFleet(file, rep, format); rerr != nil {
+		return rerr
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Fleet audit (%s, profile %s/%s): %d hosts audited, %d unreachable, average score %.1f",
+		rep.Target, rep.Profile, rep.Level, rep.Audited, rep.Unreachable, rep.AverageScore)
+	if rep.PrevAverage != nil {
+		fmt.Fprintf(out, " (previous %.1f)", *rep.PrevAverage)
+	}
+	fmt.Fprintln(out)
+	for _, h := range rep.Hosts {
+		if !h.OK {
+			fmt.Fprintf(out, "  %-30s unreachable: %s\n", h.Host, h.Error)
+			continue
+		}
+		trend := "new"
+		if h.PrevScore != nil {
+			trend = hardening.FleetTrend(h.Delta)
+		}
+		fmt.Fprintf(out, "  %-30s %3d/100 %-4s failed %d, warnings %d, waived %d\n", h.Host, h.Score, trend, h.Failed, h.Warnings, h.Waived)
+		if a.Verbose {
+			for _, id := range h.NewFailures {
+				fmt.Fprintf(out, "      new failure: %s\n", id)
+			}
+			for _, id := range h.Fixed {
+				fmt.Fprintf(out, "      fixed:       %s\n", id)
+			}
+		}
+	}
+	if len(rep.CommonFailures) > 0 {
+		fmt.Fprintln(out, "Most common failing checks:")
+		for _, c := range rep.CommonFailures {
+			fmt.Fprintf(out, "  %-36s %3d hosts %-4s %s\n", c.ID, c.Hosts, hardening.FleetTrend(c.Delta), c.Title)
+		}
+	}
+	if len(rep.WorstHosts) > 0 {
+		fmt.Fprintf(out, "Worst hosts: %s\n", strings.Join(rep.WorstHosts, ", "))
+	}
+	fmt.Fprintf(out, "Fleet report saved to: %s\n", path)
+	if err != nil {
+		return err
+	}
+	if rep.Audited == 0 {
+		return errors.New("no host could be audited")
+	}
+	return nil
+}
+
+// resolveHostGroups returns explicit groups, or this host's groups in the
+// inventory. A missing inventory or unlisted host has no groups.
+func resolveHostGroups(a *app.App, explicit []string) []string {
+	if len(explicit) > 0 {
+		return explicit
+	}
+	inv, err := cluster.LoadInventory(a.Config.InventoryFile)
+	if err != nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	short, _, _ := strings.Cut(host, ".")
+	for _, name := range []string{host, short} {
+		if s := cluster.FindByHostnameOrIP(inv, name); s != nil {
+			return s.Groups
+		}
+	}
+	return nil
+}
+
+func newHardenHistoryCmd(a *app.App) *cobra.Command {
+	var (
+		dir     string
+		filter  hardening.HistoryFilter
+		limit   int
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show audit score history and changes between runs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := hardening.LoadHistory(dir)
+			if err != nil {
+				return err
+			}
+			series := hardening.SelectSeries(entries, filter)
+			points := hardening.Trend(series)
+			if limit > 0 && len(points) > limit {
+				points = points[len(points)-limit:]
+			}
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				type result struct {
+					Host    string                   `json:"host"`
+					Profile string                   `json:"profile"`
+					Level   string                   `json:"level"`
+					Runs    []hardening.HistoryPoint `json:"runs"`
+				}
+				res := result{Runs: points}
+				if len(series) > 0 {
+					last := series[len(series)-1]
+					res.Host, res.Profile, res.Level = last.Hostname, last.Profile, last.Level
+					if last.Root != "" {
+						res.Host = last.Root
+					}
+				}
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}
+			if len(series) == 0 {
+				fmt.Fprintln(out, "No audit history recorded yet. Run 'fortis harden audit' first.")
+				return nil
+			}
+			last := series[len(series)-1]
+			host := last.Hostname
+			if last.Root != "" {
+				host = last.Root
+			}
+			fmt.Fprintf(out, "Audit history for %s (profile %s, level %s): %d runs\n\n", host, last.Profile, last.Level, len(series))
+			fmt.Fprintf(out, "%-19s %-5s %-6s %-6s %-6s %-8s %s\n", "TIMESTAMP", "SCORE", "CHANGE", "PASSED", "FAILED", "NEW FAIL", "FIXED")
+			for _, p := range points {
+				change := "-"
+				if p.Delta != nil {
+					change = fmt.Sprintf("%+d", *p.Delta)
+				}
+				fmt.Fprintf(out, "%-19s %-5d %-6s %-6d %-6d %-8d %d\n", p.Timestamp.Local().Format("2006-01-02 15:04:05"), p.Score, change, p.Passed, p.Failed, len(p.NewFailures), len(p.Fixed))
+			}
+			for i := len(points) - 1; i >= 0; i-- {
+				p := points[i]
+				if len(p.NewFailures) == 0 && len(p.Fixed) == 0 {
+					continue
+				}
+				fmt.Fprintf(out, "\nChanges in the run of %s:\n", p.Timestamp.Local().Format("2006-01-02 15:04:05"))
+				for _, id := range p.NewFailures {
+					fmt.Fprintf(out, "  ❌ newly failing  %s\n", id)
+				}
+				for _, id := range p.Fixed {
+					fmt.Fprintf(out, "  ✅ fixed          %s\n", id)
+				}
+				break
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "History store (default /var/lib/fortis/history)")
+	cmd.Flags().StringVar(&filter.Host, "host", "", "Host or image root (default: that of the latest run)")
+	cmd.Flags().StringVar(&filter.Profile, "profile", "", "Audit profile (default: that of the latest run)")
+	cmd.Flags().StringVar(&filter.Level, "level", "", "Audit level (default: that of the latest run)")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Show the last N runs (0 for all)")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func newHardenWaiversCmd(a *app.App) *cobra.Command {
+	var (
+		file    string
+		jsonOut bool
+		soon    int
+	)
+	cmd := &cobra.Command{
+		Use:   "waivers",
+		Short: "List audit waivers and their expiry",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ws, err := hardening.LoadWaivers(getStringFlag(cmd, "config-dir"), file)
+			if err != nil {
+				return err
+			}
+			host, _ := os.Hostname()
+			groups := resolveHostGroups(a, nil)
+			now := time.Now()
+			type row struct {
+				hardening.Waiver
+				Status    string `json:"status"`
+				ThisHost  bool   `json:"this_host"`
+				ExpiresAt string `json:"expires_at"`
+			}
+			rows := make([]row, 0, len(ws))
+			for _, w := range ws {
+				r := row{Waiver: w, ExpiresAt: w.ExpiresAt().Format(time.RFC3339), ThisHost: w.AppliesTo(host, groups)}
+				switch {
+				case w.Expired(now):
+					r.Status = "expired"
+				case w.ExpiresAt().Sub(now) <= time.Duration(soon)*24*time.Hour:
+					r.Status = "expiring"
+				default:
+					r.Status = "active"
+				}
+				rows = append(rows, r)
+			}
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(rows)
+			}
+			if len(rows) == 0 {
+				fmt.Fprintln(out, "No waivers defined.")
+				return nil
+			}
+			fmt.Fprintf(out, "%-9s %-4s %-28s %-12s %-18s %s\n", "STATUS", "HOST", "CHECK", "EXPIRES", "APPROVER", "JUSTIFICATION")
+			for _, r := range rows {
+				here := ""
+				if r.ThisHost {
+					here = "yes"
+				}
+				fmt.Fprintf(out, "%-9s %-4s %-28s %-12s %-18s %s\n", r.Status, here, r.Check, r.Expires, r.Approver, r.Justification)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Waivers file (default <config-dir>/waivers.yaml)")
+	cmd.Flags().IntVar(&soon, "expiring-days", 30, "Mark waivers expiring within this many days")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
 	return cmd
 }
 
-func resolveAuditOutputPath(output string, format hardening.OutputFormat, ts time.Time) (string, error) {
+func newHardenChecksCmd(a *app.App) *cobra.Command {
+	var (
+		tag     string
+		profile string
+		level   string
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "checks",
+		Short: "List registered audit checks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configDir := getStringFlag(cmd, "config-dir")
+			reg, err := hardening.LoadRegistry(configDir)
+			if err != nil {
+				return err
+			}
+			selected := reg.Checks()
+			if profile != "" {
+				p, err := hardening.LoadProfile(configDir, profile)
+				if err != nil {
+					return err
+				}
+				if selected, err = p.Select(reg, level); err != nil {
+					return err
+				}
+			}
+			checks := []hardening.Check{}
+			for _, c := range selected {
+				if tag != "" && !c.HasTag(tag) {
+					continue
+				}
+				checks = append(checks, c)
+			}
+			if jsonOut {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(checks)
+			}
+			for _, c := range checks {
+				bench := "-"
+				if c.Benchmark != "" {
+					bench = fmt.Sprintf("%s L%d", c.Benchmark, c.Level)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%d\t%s\t%s\t%s\n", c.ID, c.Severity, c.Weight, bench, c.Source, c.Title)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&tag, "tag", "", "Only show checks with this tag")
+	cmd.Flags().StringVar(&profile, "profile", "", "Only show checks selected by this audit profile")
+	cmd.Flags().StringVar(&level, "level", "basic", "Audit level used with --profile (basic, medium, strict)")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	_ = a
+	return cmd
+}
+
+func newHardenSSHDConfigCmd(a *app.App) *cobra.Command {
+	var (
+		configPath string
+		key        string
+		validate   bool
+		jsonOut    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "sshd-config",
+		Short: "Show the effective sshd configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := hardening.ParseSSHDConfig(configPath)
+			if err != nil {
+				return err
+			}
+			settings := []hardening.SSHDSetting{}
+			for _, s := range cfg.Effective() {
+				if key == "" || strings.EqualFold(s.Key, key) {
+					settings = append(settings, s)
+				}
+			}
+			if key != "" && len(settings) == 0 {
+				if s, ok := cfg.Get(key); ok {
+					settings = append(settings, s)
+				}
+			}
+			var diffs []hardening.SSHDDiff
+			if validate {
+				if diffs, err = cfg.ValidateWithSSHD(cmd.Context()); err != nil {
+					return err
+				}
+			}
+
+			if jsonOut {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(map[string]any{
+					"path":      cfg.Path,
+					"files":     cfg.Files,
+					"settings":  settings,
+					"matches":   cfg.Matches,
+					"shadowed":  cfg.Shadowed,
+					"sshd_diff": diffs,
+				})
+			}
+			out := cmd.OutOrStdout()
+			for _, s := range settings {
+				fmt.Fprintf(out, "%s %s\t# %s\n", s.Key, s.Value, s.Origin())
+			}
+			for _, m := range cfg.Matches {
+				if key != "" {
+					if _, ok := m.Settings[strings.ToLower(key)]; !ok {
+						continue
+					}
+				}
+				fmt.Fprintf(out, "Match %s\t# %s:%d\n", m.Criteria, m.File, m.Line)
+				keys := make([]string, 0, len(m.Settings))
+				for k := range m.Settings {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					s := m.Settings[k]
+					fmt.Fprintf(out, "  %s %s\t# %s\n", s.Key, s.Value, s.Origin())
+				}
+			}
+			if a.Verbose {
+				for _, s := range cfg.Shadowed {
+					fmt.Fprintf(out, "ignored: %s %s at %s (an earlier value wins)\n", s.Key, s.Value, s.Origin())
+				}
+			}
+			if validate {
+				if len(diffs) == 0 {
+					fmt.Fprintln(out, "sshd -T agrees with the parsed configuration")
+					return nil
+				}
+				for _, d := range diffs {
+					fmt.Fprintf(out, "MISMATCH %s: parsed %q (%s), sshd -T %q\n", d.Key, d.Parsed, d.Origin, d.SSHD)
+				}
+				return fmt.Errorf("%d options differ from sshd -T", len(diffs))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "file", hardening.SSHDConfigPath, "Main sshd configuration file")
+	cmd.Flags().StringVar(&key, "key", "", "Only show this option")
+	cmd.Flags().BoolVar(&validate, "validate", false, "Compare the parsed values with 'sshd -T'")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func resolveAuditOutputPath(output, prefix string, format hardening.OutputFormat, ts time.Time) (string, error) {
 	if output != "" {
 		// If looks like a file path (has an extension or contains a slash), respect it.
 		if strings.Contains(output, "/") || strings.Contains(output, "\\") || strings.Contains(output, ".") {
@@ -188,8 +830,7 @@ func resolveAuditOutputPath(output string, format hardening.OutputFormat, ts tim
 		}
 	}
 
-	ext := string(format)
-	name := fmt.Sprintf("audit-%s.%s", ts.Format("20060102-150405"), ext)
+	name := fmt.Sprintf("%s-%s.%s", prefix, ts.Format("20060102-150405"), format.Ext())
 
 	preferred := filepath.Join("/var/log/fortis", name)
 	if canWriteDir("/var/log/fortis") {
@@ -235,6 +876,7 @@ func newHardenApplyCmd(a *app.App) *cobra.Command {
 		rollback   bool
 		skipChecks bool
 	)
+	_ = rollback
 	cmd := &cobra.Command{
 		Use:   "apply",
 		Short: "Apply hardening configuration",
@@ -243,7 +885,6 @@ func newHardenApplyCmd(a *app.App) *cobra.Command {
 			res, err := hardening.ApplyProfile(cmd.Context(), hardening.ApplyOptions{
 				Profile:    profile,
 				DryRun:     dryRun,
-				Rollback:   rollback,
 				SkipChecks: skipChecks,
 				Yes:        yes,
 			})
@@ -257,9 +898,7 @@ func newHardenApplyCmd(a *app.App) *cobra.Command {
 					fmt.Fprintf(cmd.OutOrStdout(), "- %s\n", p.Description)
 				}
 			}
-			if res.RollbackDir != "" {
-				fmt.Fprintf(cmd.OutOrStdout(), "Rollback saved to: %s\n", res.RollbackDir)
-			}
+			printTransaction(cmd.OutOrStdout(), res.TransactionID)
 			if dryRun {
 				fmt.Fprintln(cmd.OutOrStdout(), "[DRY-RUN] No changes applied.")
 			}
@@ -269,10 +908,196 @@ func newHardenApplyCmd(a *app.App) *cobra.Command {
 	cmd.Flags().StringVar(&profile, "profile", "", "Hardening profile to apply")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show changes without applying")
 	cmd.Flags().BoolVar(&rollback, "rollback", false, "Create rollback point")
+	_ = cmd.Flags().MarkDeprecated("rollback", "every apply now records a rollback point; see 'fortis harden rollback --list'")
 	cmd.Flags().BoolVar(&skipChecks, "skip-checks", false, "Skip pre-application checks")
 	return cmd
 }
 
+func printTransaction(w io.Writer, id string) {
+	if id != "" {
+		fmt.Fprintf(w, "Transaction %s recorded (undo with: fortis harden rollback %s)\n", id, id)
+	}
+}
+
+func newHardenRollbackCmd(a *app.App) *cobra.Command {
+	var (
+		list    bool
+		dir     string
+		force   bool
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "rollback [--list | <id>]",
+		Short: "List or undo hardening transactions",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+			if list || len(args) == 0 {
+				txs, err := hardening.ListTransactions(dir)
+				if err != nil {
+					return err
+				}
+				if jsonOut {
+					enc := json.NewEncoder(out)
+					enc.SetIndent("", "  ")
+					return enc.Encode(txs)
+				}
+				if len(txs) == 0 {
+					fmt.Fprintln(out, "No hardening transactions recorded.")
+					return nil
+				}
+				for _, tx := range txs {
+					fmt.Fprintf(out, "%s\t%s\t%s\t%d changes\t%s\n", tx.ID, tx.StartedAt.Format(time.RFC3339), tx.Status, len(tx.Changes), tx.Command)
+					if a.Verbose {
+						for _, c := range tx.Changes {
+							fmt.Fprintf(out, "    - %s\n", c)
+						}
+					}
+				}
+				return nil
+			}
+
+			if !getBoolFlag(cmd, "yes") {
+				return errors.New("refusing to roll back without --yes")
+			}
+			tx, err := hardening.Rollback(cmd.Context(), hardening.RollbackOptions{Dir: dir, ID: args[0], Force: force})
+			if jsonOut && tx.ID != "" {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(tx)
+			}
+			if err != nil {
+				return err
+			}
+			if !jsonOut {
+				fmt.Fprintf(out, "Rolled back %s (%s): restored %d files, %d sysctls", tx.ID, tx.Command, len(tx.Files), len(tx.Sysctls))
+				if tx.Firewall != nil {
+					fmt.Fprintf(out, ", %s ruleset", tx.Firewall.Backend)
+				}
+				fmt.Fprintln(out)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&list, "list", false, "List recorded transactions")
+	cmd.Flags().StringVar(&dir, "rollback-dir", hardening.DefaultRollbackDir, "Directory holding transaction journals and snapshots")
+	cmd.Flags().BoolVar(&force, "force", false, "Roll back even if later transactions changed the same targets")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func newHardenBaselineCmd(a *app.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "baseline",
+		Short: "Manage the hardening baseline used for drift detection",
+	}
+	cmd.AddCommand(newHardenBaselineSaveCmd(a))
+	return cmd
+}
+
+func newHardenBaselineSaveCmd(a *app.App) *cobra.Command {
+	var (
+		file    string
+		profile string
+		level   string
+	)
+	cmd := &cobra.Command{
+		Use:   "save",
+		Short: "Record audit findings and configuration state as the baseline",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := hardening.CaptureBaseline(cmd.Context(), hardening.BaselineOptions{
+				Profile:    profile,
+				Level:      level,
+				ConfigDir:  getStringFlag(cmd, "config-dir"),
+				HostGroups: resolveHostGroups(a, nil),
+			})
+			if err != nil {
+				return err
+			}
+			if err := hardening.SaveBaseline(file, b); err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Baseline saved to %s (profile %s/%s, score %d, %d findings, %d sshd settings, %d sysctls, %d firewall rules, %d SUID files)\n",
+				file, b.Profile, b.Level, b.Score, len(b.Findings), len(b.SSHD), len(b.Sysctls), len(b.Firewall.Rules), len(b.SUIDFiles))
+			if a.Verbose {
+				keys := make([]string, 0, len(b.Errors))
+				for k := range b.Errors {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					fmt.Fprintf(out, "    %s not captured: %s\n", k, b.Errors[k])
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", hardening.DefaultBaselinePath, "Baseline file")
+	cmd.Flags().StringVar(&profile, "profile", "cis", "Audit profile (cis, pci, hipaa, custom)")
+	cmd.Flags().StringVar(&level, "level", "basic", "Audit level (basic, medium, strict)")
+	return cmd
+}
+
+func newHardenDriftCmd(a *app.App) *cobra.Command {
+	var (
+		file    string
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Detect configuration drift from the saved baseline",
+		Long:  "Re-runs the baseline's audit and compares findings, sshd settings, sysctls,\nfirewall rules and SUID/SGID files. Exits non-zero when drift is found.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := hardening.LoadBaseline(file)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("no baseline at %s; run 'fortis harden baseline save' first", file)
+				}
+				return err
+			}
+			rep, err := hardening.DetectDrift(cmd.Context(), base, hardening.BaselineOptions{ConfigDir: getStringFlag(cmd, "config-dir"), HostGroups: resolveHostGroups(a, nil)})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
+				}
+			} else {
+				fmt.Fprintf(out, "Baseline from %s (profile %s/%s): score %d -> %d\n",
+					rep.BaselineTime.Format(time.RFC3339), rep.Profile, rep.Level, rep.BaseScore, rep.Score)
+				for _, it := range rep.Items {
+					if it.Change == hardening.DriftFixed && !a.Verbose {
+						continue
+					}
+					line := fmt.Sprintf("  %-12s %-9s %s", it.Change, it.Category, it.Key)
+					switch {
+					case it.Baseline != "" && it.Current != "":
+						line += fmt.Sprintf(": %s -> %s", it.Baseline, it.Current)
+					case it.Current != "":
+						line += ": " + it.Current
+					case it.Baseline != "":
+						line += ": was " + it.Baseline
+					}
+					fmt.Fprintln(out, line)
+				}
+				fmt.Fprintf(out, "New failures: %d | Changed values: %d | Fixed: %d\n", rep.NewFailures, rep.Changed, rep.Fixed)
+			}
+			if rep.Drifted {
+				return fmt.Errorf("drift detected: %d new failures, %d changed values", rep.NewFailures, rep.Changed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "baseline", hardening.DefaultBaselinePath, "Baseline file")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
 func newHardenSSHCmdd(a *app.App) *cobra.Command {
 	var (
 		disableRoot bool
@@ -312,146 +1137,721 @@ func newHardenSSHCmdd(a *app.App) *cobra.Command {
 
 func newHardenFirewallCmd(a *app.App) *cobra.Command {
 	var (
-		profile   string
-		ports     string
-		direction string
-		save      bool
+		profile         string
+		ports           string
+		direction       string
+		allowFrom       []string
+		defaultIncoming string
+		defaultOutgoing string
+		policy          string
+		backend         string
+		noSSHGuard      bool
+		confirmTimeout  time.Duration
+		save            bool
+	)
+	cmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "Configure firewall rules",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			yes := getBoolFlag(cmd, "yes")
+			res, err := hardening.ConfigureFirewall(cmd.Context(), hardening.FirewallOptions{
+				Profile:         profile,
+				Ports:           ports,
+				Direction:       direction,
+				Sources:         allowFrom,
+				DefaultIncoming: defaultIncoming,
+				DefaultOutgoing: defaultOutgoing,
+				PolicyFile:      policy,
+				ConfigDir:       getStringFlag(cmd, "config-dir"),
+				Backend:         backend,
+				NoSSHGuard:      noSSHGuard,
+				ConfirmTimeout:  confirmTimeout,
+				Save:            save,
+				Yes:             yes,
+				DryRun:          !yes,
+			})
+			if err != nil {
+				if res.TransactionID != "" {
+					printTransaction(cmd.ErrOrStderr(), res.TransactionID)
+				}
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Firewall backend: %s\n", res.Backend)
+			fmt.Fprintf(out, "Defaults: incoming %s, outgoing %s, forward %s\n", res.Policy.DefaultIncoming, res.Policy.DefaultOutgoing, res.Policy.DefaultForward)
+			for _, line := range res.Plan {
+				fmt.Fprintf(out, "- %s\n", line)
+			}
+			if len(res.Diff) == 0 {
+				fmt.Fprintln(out, "Ruleset: no changes")
+			} else {
+				fmt.Fprintln(out, "Ruleset changes:")
+				for _, line := range res.Diff {
+					fmt.Fprintf(out, "  %s\n", line)
+				}
+			}
+			printTransaction(out, res.TransactionID)
+			if !res.ConfirmBy.IsZero() {
+				fmt.Fprintf(out, "Rules revert at %s unless confirmed. From a NEW SSH session run:\n  fortis harden firewall confirm %s\n",
+					res.ConfirmBy.Local().Format("15:04:05"), res.TransactionID)
+			}
+			if !yes {
+				fmt.Fprintln(out, "[DRY-RUN] Re-run with --yes to apply.")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&profile, "profile", "", "Firewall profile (webserver, database, desktop)")
+	cmd.Flags().StringVar(&ports, "ports", "", "Comma-separated list of ports to allow (22, 53/udp, 8000-8080/tcp)")
+	cmd.Flags().StringVar(&direction, "direction", "incoming", "Rule direction (incoming, outgoing, both)")
+	cmd.Flags().StringSliceVar(&allowFrom, "allow-from", nil, "Restrict --ports rules to these addresses or CIDRs")
+	cmd.Flags().StringVar(&defaultIncoming, "default-incoming", "", "Default incoming policy (drop, accept)")
+	cmd.Flags().StringVar(&defaultOutgoing, "default-outgoing", "", "Default outgoing policy (drop, accept)")
+	cmd.Flags().StringVar(&policy, "policy", "", "YAML firewall policy (default <config-dir>/firewall/<profile>.yaml)")
+	cmd.Flags().StringVar(&backend, "backend", "", "Firewall backend (nftables, iptables, ufw; default detected)")
+	cmd.Flags().BoolVar(&noSSHGuard, "no-ssh-guard", false, "Do not add a rule keeping sshd reachable")
+	cmd.Flags().DurationVar(&confirmTimeout, "confirm-timeout", 2*time.Minute, "Revert unless confirmed within this time (0 disables)")
+	cmd.Flags().BoolVar(&save, "save", false, "Save rules to persist after reboot")
+	cmd.AddCommand(newHardenFirewallConfirmCmd(a))
+	cmd.AddCommand(newHardenFirewallAnalyzeCmd(a))
+	cmd.AddCommand(newHardenFirewallRevertCmd(a))
+	return cmd
+}
+
+func newHardenFirewallConfirmCmd(a *app.App) *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:   "confirm [id]",
+		Short: "Keep a firewall change and cancel its revert timer",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := ""
+			if len(args) == 1 {
+				id = args[0]
+			}
+			id, err := hardening.ConfirmFirewall(cmd.Context(), dir, id)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Firewall change %s confirmed\n", id)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "rollback-dir", hardening.DefaultRollbackDir, "Directory holding transaction journals and snapshots")
+	return cmd
+}
+
+func newHardenFirewallAnalyzeCmd(a *app.App) *cobra.Command {
+	var (
+		profile string
+		policy  string
+		backend string
+		asJSON  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Audit the live firewall rules against the declared policy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			res, err := hardening.AnalyzeFirewall(cmd.Context(), hardening.FirewallAnalyzeOptions{
+				Profile:    profile,
+				PolicyFile: policy,
+				ConfigDir:  getStringFlag(cmd, "config-dir"),
+				Backend:    backend,
+			})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}
+			live := res.Live
+			state := "inactive"
+			if live.Active {
+				state = "active"
+			}
+			fmt.Fprintf(out, "Firewall backend: %s (%s)\n", live.Backend, state)
+			fmt.Fprintf(out, "Defaults: incoming %s, outgoing %s, forward %s\n", live.DefaultIncoming, live.DefaultOutgoing, live.DefaultForward)
+			fmt.Fprintf(out, "Rules: %d, listening ports: %d\n", len(live.Rules), len(res.Listening))
+			if res.PolicyFile != "" {
+				fmt.Fprintf(out, "Policy: %s\n", res.PolicyFile)
+			}
+			if len(res.Issues) == 0 {
+				fmt.Fprintln(out, "No issues found")
+				return nil
+			}
+			fmt.Fprintf(out, "\n%-9s %-15s %s\n", "SEVERITY", "KIND", "ISSUE")
+			for _, iss := range res.Issues {
+				fmt.Fprintf(out, "%-9s %-15s %s\n", iss.Severity, iss.Kind, iss.Message)
+				if iss.Rule != "" {
+					fmt.Fprintf(out, "%-9s %-15s   %s\n", "", "", iss.Rule)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&profile, "profile", "", "Compare with <config-dir>/firewall/<profile>.yaml")
+	cmd.Flags().StringVar(&policy, "policy", "", "YAML firewall policy to compare with")
+	cmd.Flags().StringVar(&backend, "backend", "", "Firewall backend (nftables, iptables, ufw; default detected)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output in JSON format")
+	return cmd
+}
+
+// newHardenFirewallRevertCmd is run by the revert timer armed by
+// 'harden firewall --yes'.
+func newHardenFirewallRevertCmd(a *app.App) *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:    "revert-unconfirmed <id>",
+		Short:  "Roll back a firewall change that was not confirmed",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reverted, err := hardening.RevertUnconfirmedFirewall(cmd.Context(), dir, args[0])
+			if err != nil {
+				return err
+			}
+			if reverted {
+				fmt.Fprintf(cmd.OutOrStdout(), "Firewall change %s was not confirmed and has been rolled back\n", args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "rollback-dir", hardening.DefaultRollbackDir, "Directory holding transaction journals and snapshots")
+	return cmd
+}
+
+func newHardenKernelCmd(a *app.App) *cobra.Command {
+	var (
+		profile string
+		param   string
+		value   string
+		persist bool
+		audit   bool
+		list    bool
+		asJSON  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "kernel",
+		Short: "Optimize kernel security parameters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+			configDir := getStringFlag(cmd, "config-dir")
+			encode := func(v any) error {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(v)
+			}
+			if list {
+				profiles, err := hardening.ListKernelProfiles(configDir)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return encode(profiles)
+				}
+				fmt.Fprintf(out, "%-12s %-6s %s\n", "PROFILE", "KEYS", "DESCRIPTION")
+				for _, p := range profiles {
+					fmt.Fprintf(out, "%-12s %-6d %s\n", p.Name, len(p.Params), p.Description)
+				}
+				return nil
+			}
+			if audit {
+				if profile == "" {
+					return errors.New("--audit needs --profile")
+				}
+				res, err := hardening.AuditKernel(configDir, profile)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					if err := encode(res); err != nil {
+						return err
+					}
+				} else {
+					printKernelAudit(out, res)
+				}
+				if res.Failed > 0 {
+					return fmt.Errorf("%d of %d kernel parameters do not match profile %s", res.Failed, len(res.Entries), res.Profile)
+				}
+				return nil
+			}
+
+			yes := getBoolFlag(cmd, "yes")
+			res, err := hardening.ApplyKernel(cmd.Context(), hardening.KernelOptions{
+				Profile:   profile,
+				ConfigDir: configDir,
+				Param:     param,
+				Value:     value,
+				Persist:   persist,
+				Yes:       yes,
+				DryRun:    !yes,
+			})
+			if asJSON {
+				if jerr := encode(res); jerr != nil {
+					return jerr
+				}
+				return err
+			}
+			if len(res.Params) > 0 {
+				fmt.Fprintf(out, "%-45s %-12s %-12s %-12s %s\n", "KEY", "WANT", "BEFORE", "AFTER", "STATUS")
+				for _, r := range res.Params {
+					status := r.Status
+					if r.Error != "" {
+						status += ": " + r.Error
+					}
+					fmt.Fprintf(out, "%-45s %-12s %-12s %-12s %s\n", r.Key, kernelWant(r.Op, r.Want), r.Before, r.After, status)
+				}
+				for _, r := range res.Params {
+					if r.Overridden != "" {
+						fmt.Fprintf(out, "Warning: %s is overridden at boot by %s\n", r.Key, r.Overridden)
+					}
+				}
+			}
+			printTransaction(out, res.TransactionID)
+			if err != nil {
+				return err
+			}
+			if !yes {
+				fmt.Fprintln(out, "[DRY-RUN] Re-run with --yes to apply.")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&profile, "profile", "", "Kernel profile (network, security, memory, cis or <config-dir>/sysctl/<name>.yaml)")
+	cmd.Flags().StringVar(&profile, "apply", "", "Alias for --profile")
+	_ = cmd.Flags().MarkHidden("apply")
+	cmd.Flags().StringVar(&param, "param", "", "Set specific kernel parameter")
+	cmd.Flags().StringVar(&value, "value", "", "Parameter value")
+	cmd.Flags().BoolVar(&persist, "persist", false, "Make changes persistent")
+	cmd.Flags().BoolVar(&audit, "audit", false, "Compare runtime and persisted values with the profile")
+	cmd.Flags().BoolVar(&list, "list", false, "List kernel profiles")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func kernelWant(op, want string) string {
+	if op == "eq" {
+		return want
+	}
+	return op + " " + want
+}
+
+func printKernelAudit(out io.Writer, res hardening.KernelAudit) {
+	fmt.Fprintf(out, "Kernel profile: %s\n\n", res.Profile)
+	fmt.Fprintf(out, "%-45s %-12s %-12s %-12s %-6s %s\n", "KEY", "WANT", "RUNTIME", "PERSISTED", "RESULT", "ORIGIN")
+	for _, e := range res.Entries {
+		rt, ps := e.Runtime, e.Persisted
+		if !e.RuntimeFound {
+			rt = "(missing)"
+		}
+		if !e.PersistedFound {
+			ps = "(unset)"
+		}
+		result := "PASS"
+		switch {
+		case !e.RuntimeOK && !e.PersistedOK:
+			result = "FAIL"
+		case !e.RuntimeOK:
+			result = "RUN"
+		case !e.PersistedOK:
+			result = "BOOT"
+		}
+		fmt.Fprintf(out, "%-45s %-12s %-12s %-12s %-6s %s\n", e.Key, kernelWant(e.Op, e.Want), rt, ps, result, e.Origin)
+	}
+	fmt.Fprintf(out, "\n%d of %d parameters compliant (RUN: wrong at runtime, BOOT: not persisted)\n", len(res.Entries)-res.Failed, len(res.Entries))
+	if len(res.Conflicts) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nConflicting definitions:\n")
+	for _, c := range res.Conflicts {
+		fmt.Fprintf(out, "  %s (effective %s from %s)\n", c.Key, c.Effective.Value, c.Effective.Origin())
+		for _, d := range c.Definitions {
+			fmt.Fprintf(out, "    %-12s %s\n", d.Value, d.Origin())
+		}
+	}
+}
+
+func newHardenUsersCmd(a *app.App) *cobra.Command {
+	var (
+		lockInactive   bool
+		inactiveDays   int
+		passwordPolicy bool
+		sudoSecure     bool
+		audit          bool
+		dryRun         bool
+		rootPath       string
+		jsonOut        bool
+	)
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "Audit accounts and manage user security policies",
+		Long: "Audits /etc/passwd, /etc/shadow, /etc/group, /etc/login.defs and lastlog:\n" +
+			"UID 0 accounts, empty and weakly hashed passwords, password aging,\n" +
+			"inactive and duplicate accounts, home directories and authorized_keys.\n" +
+			"Without flags it runs the audit.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_ = args
+			yes := getBoolFlag(cmd, "yes")
+			out := cmd.OutOrStdout()
+			if passwordPolicy || sudoSecure {
+				res, err := hardening.ApplyUserPolicy(cmd.Context(), hardening.UserPolicyOptions{
+					PasswordPolicy: passwordPolicy,
+					SessionTimeout: sudoSecure,
+					SudoDefaults:   sudoSecure,
+					Yes:            yes,
+					DryRun:         dryRun || !yes,
+				})
+				if err != nil {
+					return err
+				}
+				for _, c := range res.Changes {
+					fmt.Fprintf(out, "  %s\n", c)
+				}
+				for _, n := range res.Notes {
+					fmt.Fprintf(out, "  note: %s\n", n)
+				}
+				if res.TransactionID == "" {
+					fmt.Fprintln(out, "[DRY-RUN] Re-run with --yes to apply.")
+				} else {
+					printTransaction(out, res.TransactionID)
+				}
+				if !audit && !lockInactive {
+					return nil
+				}
+			}
+
+			rep, err := hardening.AuditUsers(cmd.Context(), hardening.UserAuditOptions{Root: rootPath, InactiveDays: inactiveDays})
+			if err != nil {
+				return err
+			}
+			var locked hardening.LockUsersResult
+			if lockInactive {
+				if rootPath != "" {
+					return errors.New("--lock-inactive cannot be used with --root")
+				}
+				locked, err = hardening.LockUsers(cmd.Context(), hardening.LockUsersOptions{
+					Users:  rep.Inactive,
+					Yes:    yes,
+					DryRun: dryRun || !yes,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				v := any(rep)
+				if lockInactive {
+					v = struct {
+						hardening.UserAudit
+						Lock hardening.LockUsersResult `json:"lock"`
+					}{rep, locked}
+				}
+				if err := enc.Encode(v); err != nil {
+					return err
+				}
+			} else {
+				printUserAudit(out, rep, a.Verbose)
+				if lockInactive {
+					switch {
+					case len(rep.Inactive) == 0:
+						fmt.Fprintln(out, "No inactive accounts to lock.")
+					case locked.TransactionID == "":
+						fmt.Fprintf(out, "[DRY-RUN] Would lock: %s. Re-run with --yes to apply.\n", strings.Join(rep.Inactive, ", "))
+					default:
+						fmt.Fprintf(out, "Locked: %s\n", strings.Join(locked.Locked, ", "))
+						printTransaction(out, locked.TransactionID)
+					}
+				}
+			}
+			if rep.Failed(false) {
+				return errors.New("user audit found high-severity issues")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&audit, "audit", false, "Audit accounts (the default)")
+	cmd.Flags().BoolVar(&lockInactive, "lock-inactive", false, "Lock and expire inactive accounts (transactional)")
+	cmd.Flags().IntVar(&inactiveDays, "inactive-days", hardening.DefaultInactiveDays, "Days without login after which an account is inactive")
+	cmd.Flags().BoolVar(&passwordPolicy, "password-policy", false, "Set login.defs aging and hashing and the PAM policy")
+	cmd.Flags().BoolVar(&sudoSecure, "sudo-secure", false, "Add missing sudo use_pty/logfile Defaults and a 15 minute idle shell timeout")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show changes without applying")
+	cmd.Flags().StringVar(&rootPath, "root", "", "Audit the accounts of an image root or tarball")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func printUserAudit(out io.Writer, rep hardening.UserAudit, verbose bool) {
+	if verbose {
+		fmt.Fprintf(out, "%-16s %-6s %-10s %-13s %-11s %s\n", "USER", "UID", "PASSWORD", "HASH", "LAST LOGIN", "SHELL")
+		for _, u := range rep.Accounts {
+			last := "-"
+			if u.LastLogin != nil {
+				last = u.LastLogin.Format("2006-01-02")
+			}
+			fmt.Fprintf(out, "%-16s %-6d %-10s %-13s %-11s %s\n", u.Name, u.UID, u.Password, orDash(u.HashAlgorithm), last, u.Shell)
+		}
+		fmt.Fprintln(out)
+	}
+	if len(rep.Issues) > 0 {
+		fmt.Fprintf(out, "%-9s %-16s %-24s %s\n", "SEVERITY", "CHECK", "SUBJECT", "DETAIL")
+		for _, is := range rep.Issues {
+			fmt.Fprintf(out, "%-9s %-16s %-24s %s\n", is.Severity, is.Check, is.Subject, is.Detail)
+		}
+	}
+	for _, n := range rep.Notes {
+		fmt.Fprintf(out, "  note: %s\n", n)
+	}
+	fmt.Fprintf(out, "Accounts: %d | Issues: %d | Inactive (>%d days): %d\n", len(rep.Accounts), len(rep.Issues), rep.InactiveDays, len(rep.Inactive))
+}
+
+func newHardenPAMCmd(a *app.App) *cobra.Command {
+	_ = a
+	var (
+		apply    bool
+		dryRun   bool
+		rootPath string
+		jsonOut  bool
 	)
 	cmd := &cobra.Command{
-		Use:   "firewall",
-		Short: "Configure firewall rules",
+		Use:   "pam",
+		Short: "Audit and configure the PAM password and lockout policy",
+		Long: "Reads the shared PAM stacks (Debian common-*, RHEL system-auth and\n" +
+			"password-auth) with pwquality.conf, faillock.conf and pwhistory.conf and\n" +
+			"reports the effective minimum length, complexity, lockout, history and\n" +
+			"hashing settings. --apply edits them to 14 characters, 4 classes, lockout\n" +
+			"after 5 failures for 15 minutes and 5 remembered passwords, backs up every\n" +
+			"file and reverts unless the stacks still load and meet the policy.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			yes := getBoolFlag(cmd, "yes")
-			res, err := hardening.ConfigureFirewall(cmd.Context(), hardening.FirewallOptions{
-				Profile:   profile,
-				Ports:     ports,
-				Direction: direction,
-				Save:      save,
-				Yes:       yes,
-				DryRun:    !yes,
-			})
+			_ = args
+			out := cmd.OutOrStdout()
+			if apply {
+				if rootPath != "" {
+					return errors.New("--apply cannot be used with --root")
+				}
+				yes := getBoolFlag(cmd, "yes")
+				res, err := hardening.ApplyPAMPolicy(cmd.Context(), hardening.PAMPolicyOptions{Yes: yes, DryRun: dryRun || !yes})
+				if jsonOut && err == nil {
+					enc := json.NewEncoder(out)
+					enc.SetIndent("", "  ")
+					return enc.Encode(res)
+				}
+				for _, c := range res.Changes {
+					fmt.Fprintf(out, "  %s\n", c)
+				}
+				for _, n := range res.Notes {
+					fmt.Fprintf(out, "  note: %s\n", n)
+				}
+				if err != nil {
+					return err
+				}
+				switch {
+				case len(res.Changes) == 0:
+					fmt.Fprintln(out, "PAM already meets the policy.")
+				case res.TransactionID == "":
+					fmt.Fprintln(out, "[DRY-RUN] Re-run with --yes to apply.")
+				default:
+					printTransaction(out, res.TransactionID)
+				}
+				return nil
+			}
+			rep, err := hardening.AuditPAM(cmd.Context(), hardening.PAMAuditOptions{Root: rootPath})
 			if err != nil {
 				return err
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Firewall backend: %s\n", res.Backend)
-			for _, line := range res.Plan {
-				fmt.Fprintf(cmd.OutOrStdout(), "- %s\n", line)
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
+				}
+			} else {
+				printPAMAudit(out, rep, a.Verbose)
 			}
-			if !yes {
-				fmt.Fprintln(cmd.OutOrStdout(), "[DRY-RUN] Re-run with --yes to apply.")
+			for _, is := range rep.Issues {
+				if is.Severity == hardening.SeverityCritical || is.Severity == hardening.SeverityHigh {
+					return errors.New("PAM audit found high-severity issues")
+				}
 			}
 			return nil
 		},
 	}
-	cmd.Flags().StringVar(&profile, "profile", "", "Firewall profile (webserver, database, desktop)")
-	cmd.Flags().StringVar(&ports, "ports", "", "Comma-separated list of ports to allow")
-	cmd.Flags().StringVar(&direction, "direction", "incoming", "Rule direction (incoming, outgoing, both)")
-	cmd.Flags().BoolVar(&save, "save", false, "Save rules to persist after reboot")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Edit the PAM stacks and config files to the policy (transactional)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show changes without applying")
+	cmd.Flags().StringVar(&rootPath, "root", "", "Audit the PAM configuration of an image root or tarball")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
 	return cmd
 }
 
-func newHardenKernelCmd(a *app.App) *cobra.Command {
+// pamSettingOrder is the column order of the PAM settings table.
+var pamSettingOrder = []string{"quality_module", "minlen", "classes", "retry", "lockout_module", "deny", "unlock_time", "fail_interval", "remember", "hash"}
+
+func printPAMAudit(out io.Writer, rep hardening.PAMAudit, verbose bool) {
+	for _, st := range rep.Stacks {
+		fmt.Fprintf(out, "%s (%s)\n", st.Name, strings.Join(st.Files, ", "))
+		if verbose {
+			for _, lines := range [][]hardening.PAMLine{st.Auth, st.Account, st.Password} {
+				for _, l := range lines {
+					fmt.Fprintf(out, "    %-9s %-28s %s %s\n", l.Type, l.Control, l.Module, strings.Join(l.Args, " "))
+				}
+			}
+		}
+		for _, k := range pamSettingOrder {
+			if s, ok := st.Settings[k]; ok {
+				fmt.Fprintf(out, "  %-15s %-14s %s\n", k, orDash(s.Value), s.Origin)
+			}
+		}
+		fmt.Fprintln(out)
+	}
+	if len(rep.Issues) > 0 {
+		fmt.Fprintf(out, "%-9s %-12s %-36s %s\n", "SEVERITY", "CHECK", "SUBJECT", "DETAIL")
+		for _, is := range rep.Issues {
+			fmt.Fprintf(out, "%-9s %-12s %-36s %s\n", is.Severity, is.Check, is.Subject, is.Detail)
+		}
+	}
+	for _, n := range rep.Notes {
+		fmt.Fprintf(out, "  note: %s\n", n)
+	}
+	fmt.Fprintf(out, "Stacks: %d | Issues: %d\n", len(rep.Stacks), len(rep.Issues))
+}
+
+func newHardenSudoersCmd(a *app.App) *cobra.Command {
+	_ = a
 	var (
-		apply   string
-		param   string
-		value   string
-		persist bool
+		file     string
+		matrix   bool
+		rootPath string
+		jsonOut  bool
 	)
 	cmd := &cobra.Command{
-		Use:   "kernel",
-		Short: "Optimize kernel security parameters",
+		Use:   "sudoers",
+		Short: "Audit sudoers rules and show the effective privilege matrix",
+		Long: "Parses /etc/sudoers and everything it includes (#includedir, aliases,\n" +
+			"Defaults) and reports NOPASSWD ALL rules, wildcards, negations and shell\n" +
+			"escapes in command specs, sudoers files or granted commands writable by\n" +
+			"others, and missing use_pty/logfile Defaults. --matrix lists which users\n" +
+			"and groups can run what as whom.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			yes := getBoolFlag(cmd, "yes")
-			res, err := hardening.ApplyKernel(cmd.Context(), hardening.KernelOptions{
-				ApplyPreset: apply,
-				Param:       param,
-				Value:       value,
-				Persist:     persist,
-				Yes:         yes,
-				DryRun:      !yes,
-			})
+			_ = args
+			rep, err := hardening.AuditSudoers(cmd.Context(), hardening.SudoersAuditOptions{File: file, Root: rootPath})
 			if err != nil {
 				return err
 			}
-			for _, line := range res.Plan {
-				fmt.Fprintf(cmd.OutOrStdout(), "- %s\n", line)
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
+				}
+			} else {
+				printSudoersAudit(out, rep, matrix)
 			}
-			if !yes {
-				fmt.Fprintln(cmd.OutOrStdout(), "[DRY-RUN] Re-run with --yes to apply.")
+			for _, is := range rep.Issues {
+				if is.Severity == hardening.SeverityCritical || is.Severity == hardening.SeverityHigh {
+					return errors.New("sudoers audit found high-severity issues")
+				}
 			}
 			return nil
 		},
 	}
-	cmd.Flags().StringVar(&apply, "apply", "", "Apply preset (network, memory, security)")
-	cmd.Flags().StringVar(&param, "param", "", "Set specific kernel parameter")
-	cmd.Flags().StringVar(&value, "value", "", "Parameter value")
-	cmd.Flags().BoolVar(&persist, "persist", false, "Make changes persistent")
+	cmd.Flags().StringVar(&file, "file", hardening.SudoersPath, "Main sudoers file")
+	cmd.Flags().BoolVar(&matrix, "matrix", false, "Print who can run what, as whom (aliases expanded)")
+	cmd.Flags().StringVar(&rootPath, "root", "", "Audit the sudoers of an image root or tarball")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
 	return cmd
 }
 
-func newHardenUsersCmd(a *app.App) *cobra.Command {
-	var (
-		lockInactive   bool
-		passwordPolicy bool
-		sudoSecure     bool
-		audit          bool
-	)
-	cmd := &cobra.Command{
-		Use:   "users",
-		Short: "Manage user security policies",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			argv := []string{}
-			if lockInactive {
-				argv = append(argv, "--lock-inactive")
-			}
-			if passwordPolicy {
-				argv = append(argv, "--password-policy")
+func printSudoersAudit(out io.Writer, rep hardening.SudoersAudit, matrix bool) {
+	if matrix {
+		fmt.Fprintf(out, "%-16s %-12s %-14s %-9s %-40s %s\n", "PRINCIPAL", "HOSTS", "RUNAS", "AUTH", "COMMAND", "SOURCE")
+		for _, p := range rep.Privileges {
+			who := p.Principal
+			if len(p.Except) > 0 {
+				who += " (not " + strings.Join(p.Except, ",") + ")"
 			}
-			if sudoSecure {
-				argv = append(argv, "--sudo-secure")
-			}
-			if audit {
-				argv = append(argv, "--audit")
+			auth := "password"
+			if p.NoPassword {
+				auth = "NOPASSWD"
 			}
-			if getBoolFlag(cmd, "yes") {
-				argv = append(argv, "--yes")
+			command := p.Command
+			if p.Denied {
+				command = "!" + command
 			}
-			return a.RunScript(cmd.Context(), "user-security.sh", argv...)
-		},
+			fmt.Fprintf(out, "%-16s %-12s %-14s %-9s %-40s %s\n", who, p.Hosts, p.RunAs, auth, command, p.Source)
+		}
+		fmt.Fprintln(out)
 	}
-	cmd.Flags().BoolVar(&lockInactive, "lock-inactive", false, "Lock inactive accounts")
-	cmd.Flags().BoolVar(&passwordPolicy, "password-policy", false, "Set password policies")
-	cmd.Flags().BoolVar(&sudoSecure, "sudo-secure", false, "Secure sudo configuration")
-	cmd.Flags().BoolVar(&audit, "audit", false, "Audit user permissions")
-	return cmd
+	if len(rep.Issues) > 0 {
+		fmt.Fprintf(out, "%-9s %-18s %-28s %s\n", "SEVERITY", "CHECK", "SUBJECT", "DETAIL")
+		for _, is := range rep.Issues {
+			fmt.Fprintf(out, "%-9s %-18s %-28s %s\n", is.Severity, is.Check, is.Subject, is.Detail)
+		}
+	}
+	fmt.Fprintf(out, "Files: %d | Privileges: %d | Issues: %d\n", len(rep.Files), len(rep.Privileges), len(rep.Issues))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
 func newHardenComplianceCmd(a *app.App) *cobra.Command {
 	var (
-		standard  string
-		evidence  bool
-		gap       bool
-		exportFmt string
+		standard     string
+		evidence     bool
+		evidenceFile string
+		signKey      string
+		gap          bool
+		exportFmt    string
 	)
 	cmd := &cobra.Command{
 		Use:   "compliance",
 		Short: "Generate compliance reports",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			_ = args
+			if repl, ok := hardening.DeprecatedStandard(standard); ok {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: --standard %s is deprecated and will be removed; using %s\n", standard, repl)
+			}
 			outPath, fmtDetected, err := hardening.ResolveComplianceOutputPath(exportFmt, time.Now())
 			if err != nil {
 				return err
 			}
-			// Explicitly stub pdf.
-			if strings.HasSuffix(strings.ToLower(outPath), ".pdf") || strings.ToLower(exportFmt) == "pdf" {
-				return errors.New("pdf export not implemented")
-			}
-			rep, err := hardening.GenerateComplianceReport(cmd.Context(), hardening.ComplianceOptions{
+			opts := hardening.ComplianceOptions{
 				Standard:        standard,
-				CollectEvidence: evidence,
+				CollectEvidence: evidence || evidenceFile != "",
 				GapAnalysis:     gap,
 				Format:          fmtDetected,
-			})
+				ConfigDir:       getStringFlag(cmd, "config-dir"),
+				EvidencePath:    evidenceFile,
+			}
+			if opts.CollectEvidence {
+				if opts.EvidencePath == "" {
+					opts.EvidencePath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) + "-evidence.tar.gz"
+				}
+				if signKey == "" {
+					signKey = a.Config.EvidenceSigningKey
+				}
+				if signKey == "" {
+					signKey = a.Config.BackupSigningKey
+				}
+				if signKey != "" {
+					key, err := signing.LoadPrivateKey(signKey)
+					if err != nil {
+						return fmt.Errorf("evidence signing key: %w", err)
+					}
+					opts.SigningKey = key
+				}
+			}
+			rep, err := hardening.GenerateComplianceReport(cmd.Context(), opts)
 			if err != nil {
 				return err
 			}
@@ -466,15 +1866,76 @@ func newHardenComplianceCmd(a *app.App) *cobra.Command {
 			if err := hardening.RenderCompliance(f, rep, fmtDetected); err != nil {
 				return err
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Compliance report saved to: %s\n", outPath)
+			out := cmd.OutOrStdout()
+			sum := rep.Summary
+			fmt.Fprintf(out, "%s: %d controls | Compliant %d | Non-compliant %d | Partial %d | Not assessed %d | Manual %d\n",
+				rep.Framework, sum.Total, sum.Compliant, sum.NonCompliant, sum.Partial, sum.NotAssessed, sum.Manual)
+			fmt.Fprintf(out, "Score: %d/100 | Coverage: %.1f%% of controls assessed automatically\n", rep.Score, rep.Coverage)
+			if gap && a.Verbose {
+				for _, g := range rep.Gaps {
+					fmt.Fprintf(out, "  %-14s %-20s %s\n", g.Status, g.Control, g.Title)
+				}
+			}
+			if e := rep.Evidence; e != nil {
+				signed := "unsigned"
+				if e.Signed {
+					signed = "signed by key " + e.KeyID
+				}
+				fmt.Fprintf(out, "Evidence bundle saved to: %s (%d artifacts, %s)\n", e.Bundle, e.Artifacts, signed)
+			}
+			fmt.Fprintf(out, "Compliance report saved to: %s\n", outPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&standard, "standard", "", "Compliance standard (pci-dss, hipaa, iso27001, nist-800-53, or <config-dir>/frameworks/<id>.yaml; gdpr is a deprecated alias of iso27001)")
+	cmd.Flags().BoolVar(&evidence, "evidence", false, "Write a signed evidence bundle next to the report")
+	cmd.Flags().StringVar(&evidenceFile, "evidence-file", "", "Evidence bundle path (default <report>-evidence.tar.gz)")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Ed25519 private key (PEM) to sign the evidence bundle (default from config)")
+	cmd.Flags().BoolVar(&gap, "gap-analysis", false, "Per-control gap report, including controls that need manual review")
+	cmd.Flags().StringVar(&exportFmt, "export", "", "Export format or file (json, yaml, html, sarif, junit, csv, md, pdf)")
+	cmd.AddCommand(newHardenComplianceVerifyCmd(a))
+	return cmd
+}
+
+func newHardenComplianceVerifyCmd(a *app.App) *cobra.Command {
+	var (
+		trusted    []string
+		requireSig bool
+	)
+	cmd := &cobra.Command{
+		Use:   "verify <bundle>",
+		Short: "Verify a compliance evidence bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(trusted) == 0 {
+				trusted = a.Config.EvidenceTrustedKeys
+			}
+			if len(trusted) == 0 {
+				trusted = a.Config.BackupTrustedKeys
+			}
+			keys, err := signing.LoadPublicKeys(trusted)
+			if err != nil {
+				return err
+			}
+			m, status, err := hardening.VerifyEvidenceBundle(args[0], keys)
+			if err != nil {
+				return fmt.Errorf("evidence bundle %s: %w", args[0], err)
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Bundle:    %s\n", m.BundleID)
+			fmt.Fprintf(out, "Host:      %s (machine-id %s)\n", m.Host.Hostname, m.Host.MachineID)
+			fmt.Fprintf(out, "Standard:  %s\n", m.Framework)
+			fmt.Fprintf(out, "Created:   %s\n", m.CreatedAt.Format(time.RFC3339))
+			fmt.Fprintf(out, "Files:     %d verified\n", len(m.Files))
+			fmt.Fprintf(out, "Signature: %s\n", status)
+			if requireSig && status != hardening.EvidenceVerified {
+				return fmt.Errorf("evidence bundle is not signed by a trusted key (%s)", status)
+			}
 			return nil
 		},
 	}
-	cmd.Flags().StringVar(&standard, "standard", "", "Compliance standard (pci-dss, hipaa, gdpr, iso27001)")
-	cmd.Flags().BoolVar(&evidence, "evidence", false, "Collect evidence for compliance")
-	cmd.Flags().BoolVar(&gap, "gap-analysis", false, "Show compliance gaps")
-	cmd.Flags().StringVar(&exportFmt, "export", "", "Export format (csv, pdf, json)")
-	_ = a
+	cmd.Flags().StringSliceVar(&trusted, "trusted-key", nil, "Trusted Ed25519 public keys (PEM) (default from config)")
+	cmd.Flags().BoolVar(&requireSig, "require-signature", false, "Fail unless the bundle is signed by a trusted key")
 	return cmd
 }
 
@@ -590,23 +2051,219 @@ func newHardenFilesystemCmd(a *app.App) *cobra.Command {
 	return cmd
 }
 
+func newHardenFIMCmd(a *app.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fim",
+		Short: "File integrity monitoring",
+		Long: "Records hashes, permissions, owners and extended attributes of the paths in\n" +
+			"<config-dir>/fim.yaml (or the built-in rules) and reports changes against them.",
+	}
+	cmd.AddCommand(newHardenFIMInitCmd(a))
+	cmd.AddCommand(newHardenFIMCheckCmd(a))
+	return cmd
+}
+
+// loadFIMKeys resolves the FIM signing and trusted keys, falling back to
+// the configured FIM keys and then the backup keys.
+func loadFIMKeys(a *app.App, signKey string, trusted []string) (ed25519.PrivateKey, []ed25519.PublicKey, error) {
+	if signKey == "" {
+		signKey = a.Config.FIMSigningKey
+	}
+	if signKey == "" {
+		signKey = a.Config.BackupSigningKey
+	}
+	if len(trusted) == 0 {
+		trusted = a.Config.FIMTrustedKeys
+	}
+	if len(trusted) == 0 {
+		trusted = a.Config.BackupTrustedKeys
+	}
+	var key ed25519.PrivateKey
+	if signKey != "" {
+		k, err := signing.LoadPrivateKey(signKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fim signing key: %w", err)
+		}
+		key = k
+	}
+	keys, err := signing.LoadPublicKeys(trusted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, keys, nil
+}
+
+func newHardenFIMInitCmd(a *app.App) *cobra.Command {
+	var (
+		rules   string
+		db      string
+		signKey string
+		force   bool
+		jsonOut bool
+	)
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Build the file integrity database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, _, err := loadFIMKeys(a, signKey, nil)
+			if err != nil {
+				return err
+			}
+			res, err := hardening.InitFIM(cmd.Context(), hardening.FIMOptions{
+				ConfigDir:  getStringFlag(cmd, "config-dir"),
+				RulesFile:  rules,
+				Database:   db,
+				SigningKey: key,
+				Force:      force,
+			})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}
+			for _, e := range res.Errors {
+				fmt.Fprintf(out, "  warning: %s\n", e)
+			}
+			signed := "unsigned"
+			if res.Signed {
+				signed = "signed by key " + res.KeyID
+			}
+			fmt.Fprintf(out, "Recorded %d files from %s\n", res.Files, res.Rules)
+			fmt.Fprintf(out, "Database saved to: %s (%s)\n", res.Database, signed)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&rules, "rules", "", "Rules file (default <config-dir>/fim.yaml or built-in)")
+	cmd.Flags().StringVar(&db, "db", hardening.DefaultFIMDatabase, "Database file")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Ed25519 private key (PEM) to sign the database (default from config)")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing database")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func newHardenFIMCheckCmd(a *app.App) *cobra.Command {
+	var (
+		db         string
+		signKey    string
+		trusted    []string
+		requireSig bool
+		update     bool
+		jsonOut    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Report files added, removed or changed since the database was built",
+		Long:  "Verifies the database signature, rescans with the rules stored in the\ndatabase and exits non-zero when files changed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, keys, err := loadFIMKeys(a, signKey, trusted)
+			if err != nil {
+				return err
+			}
+			rep, err := hardening.CheckFIM(cmd.Context(), hardening.FIMOptions{
+				Database:         db,
+				SigningKey:       key,
+				TrustedKeys:      keys,
+				RequireSignature: requireSig,
+				Update:           update,
+			})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if jsonOut {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
+				}
+			} else {
+				for _, c := range rep.Changes {
+					fmt.Fprintf(out, "  %-8s %s\n", c.Change, c.Path)
+					for _, at := range c.Attributes {
+						fmt.Fprintf(out, "           %-7s %s -> %s\n", at.Name, orDash(at.Old), orDash(at.New))
+					}
+				}
+				for _, e := range rep.Errors {
+					fmt.Fprintf(out, "  warning: %s\n", e)
+				}
+				sig := rep.Signature
+				if rep.KeyID != "" {
+					sig += " (key " + rep.KeyID + ")"
+				}
+				fmt.Fprintf(out, "Baseline from %s, signature %s\n", rep.BaselineAt.Format(time.RFC3339), sig)
+				fmt.Fprintf(out, "Files: %d | Added: %d | Removed: %d | Changed: %d\n", rep.Files, rep.Added, rep.Removed, rep.Changed)
+				if rep.Updated {
+					fmt.Fprintf(out, "Database updated: %s\n", rep.Database)
+				}
+			}
+			if len(rep.Changes) > 0 && !rep.Updated {
+				return fmt.Errorf("integrity check failed: %d added, %d removed, %d changed", rep.Added, rep.Removed, rep.Changed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&db, "db", hardening.DefaultFIMDatabase, "Database file")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Ed25519 private key (PEM) to re-sign the database with --update (default from config)")
+	cmd.Flags().StringSliceVar(&trusted, "trusted-key", nil, "Trusted Ed25519 public keys (PEM) (default from config)")
+	cmd.Flags().BoolVar(&requireSig, "require-signature", false, "Fail unless the database is signed by a trusted key")
+	cmd.Flags().BoolVar(&update, "update", false, "Accept the changes as the new baseline")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	return cmd
+}
+
 func newHardenPackageAuditCmd(a *app.App) *cobra.Command {
 	var (
 		listPkgs bool
 		output   string
+		feeds    []string
+		imports  []string
+		feedDir  string
+		root     string
+		severity string
 	)
 	cmd := &cobra.Command{
-		Use:    "package-audit",
-		Short:  "Installed package vulnerability check",
-		Hidden: true,
+		Use:   "package-audit",
+		Short: "Match installed packages against offline vulnerability feeds",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rep, err := hardening.AuditPackages(cmd.Context(), hardening.PackageAuditOptions{List: listPkgs})
+			if len(imports) > 0 {
+				for _, src := range imports {
+					info, err := hardening.ImportVulnFeed(src, feedDir)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "Imported %s (%s, %d entries)\n", info.Path, info.Format, info.Entries)
+				}
+				return nil
+			}
+			if len(feeds) == 0 {
+				feeds = []string{feedDir}
+			}
+			var minSev hardening.Severity
+			if severity != "" {
+				minSev = hardening.Severity(strings.ToLower(severity))
+				switch minSev {
+				case hardening.SeverityLow, hardening.SeverityMedium, hardening.SeverityHigh, hardening.SeverityCritical:
+				default:
+					return fmt.Errorf("unknown severity %q (want low, medium, high, critical)", severity)
+				}
+			}
+			rep, err := hardening.AuditPackages(cmd.Context(), hardening.PackageAuditOptions{List: listPkgs, Root: root, Feeds: feeds, MinSeverity: minSev})
 			if err != nil {
 				return err
 			}
+			if output == "" {
+				printPackageAudit(cmd.OutOrStdout(), rep)
+				return nil
+			}
+
 			w := io.Writer(cmd.OutOrStdout())
 			var file *os.File
-			if output != "" {
+			if output != "json" && output != "yaml" {
 				if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
 					return err
 				}
@@ -619,19 +2276,20 @@ func newHardenPackageAuditCmd(a *app.App) *cobra.Command {
 				w = f
 			}
 
-			if strings.HasSuffix(output, ".yaml") || strings.HasSuffix(output, ".yml") {
+			if output == "yaml" || strings.HasSuffix(output, ".yaml") || strings.HasSuffix(output, ".yml") {
 				b, err := yaml.Marshal(rep)
 				if err != nil {
 					return err
 				}
-				_, err = w.Write(b)
-				return err
-			}
-
-			enc := json.NewEncoder(w)
-			enc.SetIndent("", "  ")
-			if err := enc.Encode(rep); err != nil {
-				return err
+				if _, err = w.Write(b); err != nil {
+					return err
+				}
+			} else {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(rep); err != nil {
+					return err
+				}
 			}
 			if file != nil {
 				fmt.Fprintf(cmd.OutOrStdout(), "Report saved to: %s\n", output)
@@ -640,32 +2298,193 @@ func newHardenPackageAuditCmd(a *app.App) *cobra.Command {
 		},
 	}
 	cmd.Flags().BoolVar(&listPkgs, "list", false, "Include package list")
-	cmd.Flags().StringVar(&output, "output", "", "Output file (json|yaml inferred by extension)")
+	cmd.Flags().StringVar(&output, "output", "", "Output format or file (json, yaml; format inferred from the file extension)")
+	cmd.Flags().StringSliceVar(&feeds, "feed", nil, "Vulnerability feed files or directories (default the feed directory)")
+	cmd.Flags().StringSliceVar(&imports, "import", nil, "Import feed files (OSV .json/.zip, Debian security tracker .json, OVAL .xml; .gz/.bz2 allowed)")
+	cmd.Flags().StringVar(&feedDir, "feed-dir", hardening.DefaultVulnFeedDir, "Directory holding imported feeds")
+	cmd.Flags().StringVar(&root, "root", "", "Audit the packages of a mounted root filesystem or image tarball")
+	cmd.Flags().StringVar(&severity, "severity", "", "Only report vulnerabilities at or above this severity")
 	_ = a
 	return cmd
 }
 
+func printPackageAudit(out io.Writer, rep hardening.PackageAuditReport) {
+	dist := rep.Distribution
+	if dist == "" {
+		dist = "unknown distribution"
+	}
+	fmt.Fprintf(out, "Packages: %d (%s, %s)\n", rep.TotalPackages, rep.Manager, dist)
+	for _, f := range rep.Feeds {
+		fmt.Fprintf(out, "Feed: %s (%s, %d entries, %s)\n", f.Path, f.Format, f.Entries, f.Modified.Format("2006-01-02"))
+	}
+	if rep.Note != "" {
+		fmt.Fprintf(out, "Note: %s\n", rep.Note)
+		return
+	}
+	if len(rep.Vulnerabilities) == 0 {
+		fmt.Fprintln(out, "No known vulnerabilities found")
+		return
+	}
+	fmt.Fprintf(out, "\n%-9s %-18s %-20s %-26s %s\n", "SEVERITY", "ID", "PACKAGE", "INSTALLED", "FIXED")
+	for _, v := range rep.Vulnerabilities {
+		fixed := v.FixedVersion
+		if fixed == "" {
+			fixed = "(no fix yet)"
+		}
+		fmt.Fprintf(out, "%-9s %-18s %-20s %-26s %s\n", v.Severity, v.ID, v.Package, v.InstalledVersion, fixed)
+	}
+	fmt.Fprintf(out, "\n%d vulnerabilities: %d critical, %d high, %d medium, %d low\n", len(rep.Vulnerabilities), rep.Critical, rep.High, rep.Medium, rep.Low)
+}
+
 func newHardenAuditdCmd(a *app.App) *cobra.Command {
-	var apply bool
+	var (
+		apply     bool
+		dryRun    bool
+		immutable bool
+		ruleSet   string
+		show      bool
+		rootPath  string
+		jsonOut   bool
+	)
 	cmd := &cobra.Command{
-		Use:    "auditd",
-		Short:  "Auditd rule deployment",
-		Hidden: true,
+		Use:   "auditd",
+		Short: "Generate CIS audit rules and compare them with the loaded rules",
+		Long: "Expands an audit rule set (built-in cis, or <config-dir>/auditd/<name>.yaml)\n" +
+			"for this host: watches on identity files, sudoers, time, network and\n" +
+			"MAC policy changes, syscall rules per ABI, kernel module loading and one\n" +
+			"execution rule per SUID/SGID program. Reports the rules missing from\n" +
+			"rules.d and from \"auditctl -l\". --apply writes them to\n" +
+			"/etc/audit/rules.d/50-fortis.rules, loads them with augenrules and\n" +
+			"reverts unless every rule is loaded afterwards.",
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			argv := []string{}
+			_ = args
+			out := cmd.OutOrStdout()
+			configDir := getStringFlag(cmd, "config-dir")
+			encode := func(v any) error {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(v)
+			}
 			if apply {
-				argv = append(argv, "--apply")
+				if rootPath != "" {
+					return errors.New("--apply cannot be used with --root")
+				}
+				yes := getBoolFlag(cmd, "yes")
+				res, err := hardening.ApplyAuditdRules(cmd.Context(), hardening.AuditdApplyOptions{
+					RuleSet:   ruleSet,
+					ConfigDir: configDir,
+					Immutable: immutable,
+					Yes:       yes,
+					DryRun:    dryRun || !yes,
+				})
+				if jsonOut && err == nil {
+					return encode(res)
+				}
+				for _, c := range res.Changes {
+					fmt.Fprintf(out, "  %s\n", c)
+				}
+				for _, n := range res.Notes {
+					fmt.Fprintf(out, "  note: %s\n", n)
+				}
+				for _, m := range res.Missing {
+					fmt.Fprintf(out, "  not loaded: %s\n", m)
+				}
+				if err != nil {
+					return err
+				}
+				switch {
+				case len(res.Changes) == 0:
+					fmt.Fprintf(out, "Audit rules of rule set %s are in place (%d rules).\n", res.RuleSet, res.Rules)
+				case res.TransactionID == "":
+					fmt.Fprintln(out, "[DRY-RUN] Re-run with --yes to apply.")
+				default:
+					printTransaction(out, res.TransactionID)
+				}
+				return nil
 			}
-			if getBoolFlag(cmd, "yes") {
-				argv = append(argv, "--yes")
+			rep, err := hardening.AuditAuditd(cmd.Context(), hardening.AuditdAuditOptions{RuleSet: ruleSet, ConfigDir: configDir, Root: rootPath})
+			if err != nil {
+				return err
 			}
-			return a.RunScript(cmd.Context(), "auditd-setup.sh", argv...)
+			switch {
+			case jsonOut:
+				if err := encode(rep); err != nil {
+					return err
+				}
+			case show:
+				for _, r := range rep.Rules {
+					fmt.Fprintln(out, r.Rule)
+				}
+				return nil
+			default:
+				printAuditdReport(out, rep, a.Verbose)
+			}
+			switch {
+			case len(rep.Missing) > 0:
+				return fmt.Errorf("%d of %d audit rules are not loaded", len(rep.Missing), len(rep.Rules))
+			case len(rep.NotPersisted) > 0:
+				return fmt.Errorf("%d of %d audit rules are not in /etc/audit/rules.d", len(rep.NotPersisted), len(rep.Rules))
+			}
+			return nil
 		},
 	}
-	cmd.Flags().BoolVar(&apply, "apply", false, "Apply changes")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Write the rules to rules.d and load them (transactional)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show changes without applying")
+	cmd.Flags().BoolVar(&immutable, "immutable", false, "With --apply, also lock the rules until reboot (-e 2)")
+	cmd.Flags().StringVar(&ruleSet, "rules", "cis", "Rule set (built-in cis or <config-dir>/auditd/<name>.yaml)")
+	cmd.Flags().BoolVar(&show, "print", false, "Print the generated rules")
+	cmd.Flags().StringVar(&rootPath, "root", "", "Compare with the rules.d of an image root or tarball")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
 	return cmd
 }
 
+func printAuditdReport(out io.Writer, rep hardening.AuditdReport, verbose bool) {
+	header := false
+	for _, r := range rep.Rules {
+		disk, loaded := "missing", "-"
+		if r.File != "" {
+			disk = "ok"
+		}
+		if r.Loaded != nil {
+			loaded = "missing"
+			if *r.Loaded {
+				loaded = "ok"
+			}
+		}
+		if !verbose && disk == "ok" && loaded != "missing" {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(out, "%-16s %-12s %-8s %s\n", "GROUP", "RULES.D", "LOADED", "RULE")
+			header = true
+		}
+		fmt.Fprintf(out, "%-16s %-12s %-8s %s\n", r.Group, disk, loaded, r.Rule)
+	}
+	for _, r := range rep.NotLoaded {
+		fmt.Fprintf(out, "  rules.d only: %s\n", r)
+	}
+	for _, r := range rep.LoadedOnly {
+		fmt.Fprintf(out, "  loaded only: %s\n", r)
+	}
+	if verbose {
+		for _, s := range rep.Skipped {
+			fmt.Fprintf(out, "  left out: %s\n", s)
+		}
+	}
+	for _, n := range rep.Notes {
+		fmt.Fprintf(out, "  note: %s\n", n)
+	}
+	loaded := "not read"
+	if rep.LoadedRead {
+		loaded = fmt.Sprintf("%d missing", len(rep.Missing))
+		if rep.Immutable {
+			loaded += " (immutable)"
+		}
+	}
+	fmt.Fprintf(out, "Rule set %s: %d rules | rules.d: %d missing | loaded: %s | left out: %d\n", rep.RuleSet, len(rep.Rules), len(rep.NotPersisted), loaded, len(rep.Skipped))
+}
+
 func newHardenLoggingCmd(a *app.App) *cobra.Command {
 	var (
 		apply  bool
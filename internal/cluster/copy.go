@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CopyFile copies a local file to remotePath on host with scp, using the
+// same per-host user, port and key resolution as Exec.
+func CopyFile(ctx context.Context, inv Inventory, host string, opts ExecOptions, localPath, remotePath string) error {
+	if opts.SSHTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.SSHTimeout)
+		defer cancel()
+	}
+	// scp takes the port as -P; otherwise the options match ssh.
+	args := SSHArgs(inv, host, opts, "")
+	target := args[len(args)-2]
+	args = args[:len(args)-2]
+	for i, a := range args {
+		if a == "-p" {
+			args[i] = "-P"
+		}
+	}
+	args = append(args, "-q", localPath, fmt.Sprintf("%s:%s", target, remotePath))
+	b, err := exec.CommandContext(ctx, "scp", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("scp to %s: %w: %s", host, err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
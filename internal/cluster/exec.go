@@ -48,6 +48,48 @@ func Exec(ctx context.Context, opts ExecOptions) ([]ExecResult, error) {
 		opts.Parallel = 4
 	}
 
+	uniq, inv, err := ResolveTargets(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	type job struct{ host string }
+	jobs := make(chan job)
+	results := make(chan ExecResult)
+
+	worker := func() {
+		for j := range jobs {
+			res := runSSH(ctx, inv, j.host, opts)
+			results <- res
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Parallel; i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); worker() }()
+	}
+
+	go func() {
+		for _, h := range uniq {
+			jobs <- job{host: h}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	out := []ExecResult{}
+	for r := range results {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// ResolveTargets expands the explicit hosts, hosts file and inventory group
+// in opts into a de-duplicated host list, and returns the loaded inventory
+// for per-host SSH settings.
+func ResolveTargets(opts ExecOptions) ([]string, Inventory, error) {
 	targets := []string{}
 	if len(opts.Hosts) > 0 {
 		targets = append(targets, opts.Hosts...)
@@ -55,7 +97,7 @@ func Exec(ctx context.Context, opts ExecOptions) ([]ExecResult, error) {
 	if opts.HostsFile != "" {
 		hs, err := HostsFromFile(opts.HostsFile)
 		if err != nil {
-			return nil, err
+			return nil, Inventory{}, err
 		}
 		targets = append(targets, hs...)
 	}
@@ -92,45 +134,31 @@ func Exec(ctx context.Context, opts ExecOptions) ([]ExecResult, error) {
 		uniq = append(uniq, h)
 	}
 	if len(uniq) == 0 {
-		return nil, errors.New("no target hosts")
-	}
-
-	type job struct{ host string }
-	jobs := make(chan job)
-	results := make(chan ExecResult)
-
-	worker := func() {
-		for j := range jobs {
-			res := runSSH(ctx, inv, j.host, opts)
-			results <- res
-		}
-	}
-
-	var wg sync.WaitGroup
-	for i := 0; i < opts.Parallel; i++ {
-		wg.Add(1)
-		go func() { defer wg.Done(); worker() }()
+		return nil, inv, errors.New("no target hosts")
 	}
+	return uniq, inv, nil
+}
 
-	go func() {
-		for _, h := range uniq {
-			jobs <- job{host: h}
-		}
-		close(jobs)
-		wg.Wait()
-		close(results)
-	}()
+func runSSH(ctx context.Context, inv Inventory, host string, opts ExecOptions) ExecResult {
+	ctx2, cancel := context.WithTimeout(ctx, opts.SSHTimeout)
+	defer cancel()
 
-	out := []ExecResult{}
-	for r := range results {
-		out = append(out, r)
+	cmd := exec.CommandContext(ctx2, "ssh", SSHArgs(inv, host, opts, opts.Command)...)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return ExecResult{Host: host, OK: false, Output: string(b), Error: err.Error()}
 	}
-	return out, nil
+	return ExecResult{Host: host, OK: true, Output: string(b)}
 }
 
-func runSSH(ctx context.Context, inv Inventory, host string, opts ExecOptions) ExecResult {
+// SSHArgs builds the ssh argument list for host, applying the per-host user
+// and port from the inventory the same way cluster exec does.
+func SSHArgs(inv Inventory, host string, opts ExecOptions, remoteCmd string) []string {
 	user := opts.SSHUser
 	port := opts.SSHPort
+	if port == 0 {
+		port = 22
+	}
 
 	if s := FindByHostnameOrIP(inv, host); s != nil {
 		if user == "" && s.SSHUser != "" {
@@ -150,17 +178,7 @@ func runSSH(ctx context.Context, inv Inventory, host string, opts ExecOptions) E
 	if opts.SSHKey != "" {
 		args = append(args, "-i", opts.SSHKey)
 	}
-	args = append(args, target, opts.Command)
-
-	ctx2, cancel := context.WithTimeout(ctx, opts.SSHTimeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx2, "ssh", args...)
-	b, err := cmd.CombinedOutput()
-	if err != nil {
-		return ExecResult{Host: host, OK: false, Output: string(b), Error: err.Error()}
-	}
-	return ExecResult{Host: host, OK: true, Output: string(b)}
+	return append(args, target, remoteCmd)
 }
 
 func EncodeExecResultsJSON(res []ExecResult) ([]byte, error) {
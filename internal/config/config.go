@@ -11,6 +11,17 @@ type Config struct {
 	LogFile       string `yaml:"log_file"`
 	ScriptsDir    string `yaml:"scripts_dir"`
 	InventoryFile string `yaml:"inventory_file"`
+
+	BackupSigningKey  string   `yaml:"backup_signing_key"`
+	BackupTrustedKeys []string `yaml:"backup_trusted_keys"`
+
+	// Compliance evidence bundles fall back to the backup keys when unset.
+	EvidenceSigningKey  string   `yaml:"evidence_signing_key"`
+	EvidenceTrustedKeys []string `yaml:"evidence_trusted_keys"`
+
+	// File integrity databases fall back to the backup keys when unset.
+	FIMSigningKey  string   `yaml:"fim_signing_key"`
+	FIMTrustedKeys []string `yaml:"fim_trusted_keys"`
 }
 
 func Default() Config {
@@ -15,6 +15,8 @@ import (
 	"time"
 )
 
+const SSHDConfigPath = "/etc/ssh/sshd_config"
+
 type Result string
 
 const (
@@ -22,43 +24,101 @@ const (
 	ResultFail Result = "fail"
 	ResultWarn Result = "warn"
 	ResultSkip Result = "skip"
+	// ResultWaived is a failure or warning covered by an active waiver; it
+	// does not count towards the score.
+	ResultWaived Result = "waived"
 )
 
 type Finding struct {
-	ID             string `json:"id" yaml:"id"`
-	Title          string `json:"title" yaml:"title"`
-	Result         Result `json:"result" yaml:"result"`
-	Details        string `json:"details,omitempty" yaml:"details,omitempty"`
-	Recommendation string `json:"recommendation,omitempty" yaml:"recommendation,omitempty"`
-	Weight         int    `json:"weight" yaml:"weight"`
+	ID             string   `json:"id" yaml:"id"`
+	Title          string   `json:"title" yaml:"title"`
+	Result         Result   `json:"result" yaml:"result"`
+	Details        string   `json:"details,omitempty" yaml:"details,omitempty"`
+	Recommendation string   `json:"recommendation,omitempty" yaml:"recommendation,omitempty"`
+	Weight         int      `json:"weight" yaml:"weight"`
+	Severity       Severity `json:"severity,omitempty" yaml:"severity,omitempty"`
+	Tags           []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	References     []string `json:"references,omitempty" yaml:"references,omitempty"`
+	Benchmark      string   `json:"benchmark,omitempty" yaml:"benchmark,omitempty"`
+	// Waiver is the waiver matching a failing check, including expired
+	// ones so reports show why a finding re-surfaced.
+	Waiver *AppliedWaiver `json:"waiver,omitempty" yaml:"waiver,omitempty"`
 }
 
 type Report struct {
-	Timestamp   time.Time `json:"timestamp" yaml:"timestamp"`
-	Profile     string    `json:"profile" yaml:"profile"`
-	Level       string    `json:"level" yaml:"level"`
-	Hostname    string    `json:"hostname" yaml:"hostname"`
-	OS          string    `json:"os" yaml:"os"`
-	Platform    string    `json:"platform" yaml:"platform"`
-	Findings    []Finding `json:"findings" yaml:"findings"`
-	Passed      int       `json:"passed" yaml:"passed"`
-	Failed      int       `json:"failed" yaml:"failed"`
-	Warnings    int       `json:"warnings" yaml:"warnings"`
-	Skipped     int       `json:"skipped" yaml:"skipped"`
-	Score       int       `json:"score" yaml:"score"`
-	ScoreLabel  string    `json:"score_label" yaml:"score_label"`
-	ReportHash  string    `json:"report_hash" yaml:"report_hash"`
-	ReportPath  string    `json:"report_path" yaml:"report_path"`
-	GeneratedBy string    `json:"generated_by" yaml:"generated_by"`
+	Timestamp      time.Time `json:"timestamp" yaml:"timestamp"`
+	Profile        string    `json:"profile" yaml:"profile"`
+	Level          string    `json:"level" yaml:"level"`
+	Hostname       string    `json:"hostname" yaml:"hostname"`
+	OS             string    `json:"os" yaml:"os"`
+	Platform       string    `json:"platform" yaml:"platform"`
+	BenchmarkLevel int       `json:"benchmark_level,omitempty" yaml:"benchmark_level,omitempty"`
+	// Root is the image audited with AuditOptions.Root; Hostname is then
+	// the image's own hostname or name.
+	Root           string    `json:"root,omitempty" yaml:"root,omitempty"`
+	Findings       []Finding `json:"findings" yaml:"findings"`
+	Passed         int       `json:"passed" yaml:"passed"`
+	Failed         int       `json:"failed" yaml:"failed"`
+	Warnings       int       `json:"warnings" yaml:"warnings"`
+	Skipped        int       `json:"skipped" yaml:"skipped"`
+	Waived         int       `json:"waived" yaml:"waived"`
+	ExpiredWaivers int       `json:"expired_waivers,omitempty" yaml:"expired_waivers,omitempty"`
+	Score          int       `json:"score" yaml:"score"`
+	ScoreLabel     string    `json:"score_label" yaml:"score_label"`
+	ReportHash     string    `json:"report_hash" yaml:"report_hash"`
+	ReportPath     string    `json:"report_path" yaml:"report_path"`
+	GeneratedBy    string    `json:"generated_by" yaml:"generated_by"`
+	// Transaction is the rollback ID of the changes made by --fix;
+	// RemediationError is set when they failed and were reverted.
+	Transaction      string `json:"transaction,omitempty" yaml:"transaction,omitempty"`
+	RemediationError string `json:"remediation_error,omitempty" yaml:"remediation_error,omitempty"`
+	// History is the score trend of earlier runs of the same host, profile
+	// and level, filled in by callers that keep a history store.
+	History []HistoryPoint `json:"history,omitempty" yaml:"history,omitempty"`
 }
 
 type AuditOptions struct {
+	// Profile names an audit profile (built-in or <ConfigDir>/profiles) and
+	// Level one of basic, medium, strict.
 	Profile string
 	Level   string
 	Output  string
 	Fix     bool
 	Yes     bool
 	Verbose bool
+
+	// ConfigDir holds site-specific checks in <ConfigDir>/checks.
+	ConfigDir string
+	// Registry overrides the checks to run; nil loads the built-in checks
+	// plus those found in ConfigDir.
+	Registry *Registry
+	// IncludeChecks are check ID globs run in addition to the profile's
+	// selection, at every level; the profile's thresholds still apply.
+	IncludeChecks []string
+	// BenchmarkLevel selects benchmark checks up to this profile level
+	// (1 or 2). Checks that are not part of a benchmark always run. Zero
+	// uses the level the audit profile configures, or 1.
+	BenchmarkLevel int
+	// RollbackDir receives the --fix transaction; empty uses
+	// DefaultRollbackDir.
+	RollbackDir string
+	// WaiversFile overrides <ConfigDir>/waivers.yaml. HostGroups are this
+	// host's inventory groups, matched against waiver group selectors.
+	WaiversFile string
+	HostGroups  []string
+	// Root audits an image instead of the running host: a directory
+	// holding its root filesystem, or an image or layer tarball. File-based
+	// checks read the image; runtime-only checks are skipped.
+	Root string
+	// VulnFeeds are the vulnerability feeds of the packages.cve_* checks;
+	// empty uses DefaultVulnFeedDir.
+	VulnFeeds []string
+
+	fix      *fixTx
+	root     *auditRoot
+	packages *packageScan
+	users    *userScan
+	sudoers  *sudoScan
 }
 
 func RunAudit(ctx context.Context, opts AuditOptions) (Report, error) {
@@ -66,37 +126,132 @@ func RunAudit(ctx context.Context, opts AuditOptions) (Report, error) {
 		opts.Profile = "cis"
 	}
 	if opts.Level == "" {
-		opts.Level = "basic"
+		opts.Level = LevelBasic
+	}
+	opts.Level = strings.ToLower(strings.TrimSpace(opts.Level))
+	if _, err := levelRank(opts.Level); err != nil {
+		return Report{}, err
+	}
+
+	profile, err := LoadProfile(opts.ConfigDir, opts.Profile)
+	if err != nil {
+		return Report{}, err
+	}
+	if opts.BenchmarkLevel <= 0 {
+		opts.BenchmarkLevel = profile.benchmarkLevelFor(opts.Level)
+	}
+	for _, id := range opts.IncludeChecks {
+		profile.Checks = append(profile.Checks, ProfileRule{ID: id})
+	}
+
+	reg := opts.Registry
+	if reg == nil {
+		loaded, err := LoadRegistry(opts.ConfigDir)
+		if err != nil {
+			return Report{}, err
+		}
+		reg = loaded
+	}
+	checks, err := profile.Select(reg, opts.Level)
+	if err != nil {
+		return Report{}, err
+	}
+	waivers, err := LoadWaivers(opts.ConfigDir, opts.WaiversFile)
+	if err != nil {
+		return Report{}, err
 	}
 
 	host, _ := os.Hostname()
+	if opts.Root != "" {
+		if opts.Fix {
+			return Report{}, errors.New("--fix cannot be used with --root; images are audited read-only")
+		}
+		root, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return Report{}, err
+		}
+		opts.root = root
+		host = root.imageHostname()
+	}
+	opts.packages = &packageScan{}
+	opts.users = &userScan{}
+	opts.sudoers = &sudoScan{}
+	platform := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	if opts.root.isImage() {
+		platform = opts.root.imagePlatform()
+	}
 	rep := Report{
-		Timestamp:   time.Now(),
-		Profile:     opts.Profile,
-		Level:       opts.Level,
-		Hostname:    host,
-		OS:          runtime.GOOS,
-		Platform:    fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
-		GeneratedBy: "fortis",
+		Timestamp:      time.Now(),
+		Profile:        profile.Name,
+		Level:          opts.Level,
+		Hostname:       host,
+		OS:             runtime.GOOS,
+		Platform:       platform,
+		BenchmarkLevel: opts.BenchmarkLevel,
+		Root:           opts.Root,
+		GeneratedBy:    "fortis",
+	}
+
+	if opts.Fix && opts.Yes {
+		opts.fix = &fixTx{dir: opts.RollbackDir, command: fmt.Sprintf("audit --fix --profile %s --level %s", profile.Name, opts.Level)}
 	}
 
-	checks := defaultChecks()
+	var fixed []int
 	for _, c := range checks {
-		f, err := c(ctx, opts)
+		if c.Level > opts.BenchmarkLevel {
+			continue
+		}
+		w, waived := matchWaiver(waivers, c.ID, host, opts.HostGroups, rep.Timestamp)
+		runOpts := opts
+		if waived && !w.Expired(rep.Timestamp) {
+			// An accepted risk must not be remediated away.
+			runOpts.Fix = false
+		}
+		begun := opts.fix.begun()
+		f, err := c.Run(ctx, runOpts)
+		if opts.fix.begun() > begun {
+			fixed = append(fixed, len(rep.Findings))
+		}
 		if err != nil {
 			f.Result = ResultWarn
 			f.Details = err.Error()
 		}
+		applyCheckMeta(&f, c)
+		if waived {
+			applyWaiver(&f, w, rep.Timestamp)
+		}
 		rep.Findings = append(rep.Findings, f)
 	}
+	if tx := opts.fix.transaction(); tx != nil {
+		rep.Transaction = tx.ID()
+		if err := tx.Finish(ctx, nil); err != nil {
+			rep.RemediationError = err.Error()
+			if tx.Failed() != nil {
+				// Every change of the run was reverted, including those
+				// of checks that succeeded.
+				for _, i := range fixed {
+					f := &rep.Findings[i]
+					f.Result = ResultFail
+					f.Details = "remediation rolled back: " + err.Error()
+				}
+			}
+		}
+	}
 
 	totalWeight := 0
 	scoreWeight := 0
 	for _, f := range rep.Findings {
+		if f.Waiver != nil && f.Waiver.Expired {
+			rep.ExpiredWaivers++
+		}
 		if f.Result == ResultSkip {
 			rep.Skipped++
 			continue
 		}
+		if f.Result == ResultWaived {
+			rep.Waived++
+			continue
+		}
 		totalWeight += f.Weight
 		switch f.Result {
 		case ResultPass:
@@ -131,49 +286,92 @@ func RunAudit(ctx context.Context, opts AuditOptions) (Report, error) {
 	return rep, nil
 }
 
-type checkFunc func(ctx context.Context, opts AuditOptions) (Finding, error)
-
-func defaultChecks() []checkFunc {
-	return []checkFunc{
-		checkSSHRootLogin,
-		checkSSHPasswordAuth,
-		checkIPForwarding,
-		checkFirewallPresence,
+// applyCheckMeta fills finding fields the check implementation left empty
+// from the registered check definition.
+func applyCheckMeta(f *Finding, c Check) {
+	if f.ID == "" {
+		f.ID = c.ID
+	}
+	if f.Title == "" {
+		f.Title = c.Title
+	}
+	if f.Weight == 0 {
+		f.Weight = c.Weight
+	}
+	if f.Severity == "" {
+		f.Severity = c.Severity
+	}
+	if len(f.Tags) == 0 {
+		f.Tags = c.Tags
+	}
+	if len(f.References) == 0 {
+		f.References = c.References
+	}
+	if f.Benchmark == "" {
+		f.Benchmark = c.Benchmark
 	}
 }
 
 func checkSSHRootLogin(ctx context.Context, opts AuditOptions) (Finding, error) {
 	f := Finding{ID: "ssh.root_login", Title: "Ensure root SSH login is disabled", Weight: 30}
-	if runtime.GOOS != "linux" {
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
 		f.Result = ResultSkip
 		f.Details = "not supported on this OS"
 		return f, nil
 	}
 
-	cfgPath := "/etc/ssh/sshd_config"
-	b, err := os.ReadFile(cfgPath)
-	if err != nil {
+	cfgPath := SSHDConfigPath
+	if _, err := opts.root.Stat(cfgPath); err != nil {
+		if opts.root.isImage() && errors.Is(err, os.ErrNotExist) {
+			f.Result = ResultSkip
+			f.Details = cfgPath + " does not exist"
+			return f, nil
+		}
 		f.Result = ResultWarn
 		return f, err
 	}
 
-	val, found := sshdConfigValue(string(b), "PermitRootLogin")
-	if found && strings.EqualFold(strings.TrimSpace(val), "no") {
-		f.Result = ResultPass
+	val, origin, matches, _, err := sshdOptionValue(opts.root, cfgPath, "PermitRootLogin", "")
+	if err != nil {
+		f.Result = ResultWarn
+		return f, err
+	}
+	f.Details = fmt.Sprintf("PermitRootLogin %s (%s)", val, origin)
+	if strings.EqualFold(strings.TrimSpace(val), "no") {
+		m, ok := sshdMatchNotNo(matches)
+		if !ok {
+			f.Result = ResultPass
+			return f, nil
+		}
+		// --fix only edits the global setting; Match blocks are left to
+		// the administrator.
+		f.Result = ResultFail
+		f.Recommendation = fmt.Sprintf("Set PermitRootLogin no (or remove it) in the Match %s block at %s and reload sshd", m.Criteria, m.Origin)
 		return f, nil
 	}
 
 	f.Result = ResultFail
-	f.Recommendation = "Set PermitRootLogin no in /etc/ssh/sshd_config and reload sshd"
+	f.Recommendation = "Set PermitRootLogin no in the sshd configuration file that sets it (or /etc/ssh/sshd_config) and reload sshd"
 
 	if opts.Fix {
 		if !opts.Yes {
 			return f, errors.New("--fix requested but requires --yes to apply changes")
 		}
-		if err := setSSHDConfigKey(cfgPath, "PermitRootLogin", "no"); err != nil {
+		tx, err := opts.fix.begin()
+		if err != nil {
+			return f, err
+		}
+		if err := snapshotSSHDConfig(tx, cfgPath); err != nil {
+			return f, err
+		}
+		if err := tx.Do("Set PermitRootLogin no", func() error {
+			return setSSHDConfigKey(ctx, tx, cfgPath, "PermitRootLogin", "no")
+		}); err != nil {
+			return f, err
+		}
+		if err := reloadSSHDInTx(ctx, tx); err != nil {
 			return f, err
 		}
-		_ = exec.CommandContext(ctx, "systemctl", "reload", "sshd").Run()
 		f.Result = ResultWarn
 		f.Details = "applied remediation; re-run audit to verify"
 	}
@@ -183,36 +381,64 @@ func checkSSHRootLogin(ctx context.Context, opts AuditOptions) (Finding, error)
 
 func checkSSHPasswordAuth(ctx context.Context, opts AuditOptions) (Finding, error) {
 	f := Finding{ID: "ssh.password_auth", Title: "Ensure SSH password authentication is disabled", Weight: 25}
-	if runtime.GOOS != "linux" {
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
 		f.Result = ResultSkip
 		f.Details = "not supported on this OS"
 		return f, nil
 	}
 
-	cfgPath := "/etc/ssh/sshd_config"
-	b, err := os.ReadFile(cfgPath)
-	if err != nil {
+	cfgPath := SSHDConfigPath
+	if _, err := opts.root.Stat(cfgPath); err != nil {
+		if opts.root.isImage() && errors.Is(err, os.ErrNotExist) {
+			f.Result = ResultSkip
+			f.Details = cfgPath + " does not exist"
+			return f, nil
+		}
 		f.Result = ResultWarn
 		return f, err
 	}
 
-	val, found := sshdConfigValue(string(b), "PasswordAuthentication")
-	if found && strings.EqualFold(strings.TrimSpace(val), "no") {
-		f.Result = ResultPass
+	val, origin, matches, _, err := sshdOptionValue(opts.root, cfgPath, "PasswordAuthentication", "")
+	if err != nil {
+		f.Result = ResultWarn
+		return f, err
+	}
+	f.Details = fmt.Sprintf("PasswordAuthentication %s (%s)", val, origin)
+	if strings.EqualFold(strings.TrimSpace(val), "no") {
+		m, ok := sshdMatchNotNo(matches)
+		if !ok {
+			f.Result = ResultPass
+			return f, nil
+		}
+		// --fix only edits the global setting; Match blocks are left to
+		// the administrator.
+		f.Result = ResultFail
+		f.Recommendation = fmt.Sprintf("Set PasswordAuthentication no (or remove it) in the Match %s block at %s and reload sshd", m.Criteria, m.Origin)
 		return f, nil
 	}
 
 	f.Result = ResultFail
-	f.Recommendation = "Set PasswordAuthentication no in /etc/ssh/sshd_config and reload sshd"
+	f.Recommendation = "Set PasswordAuthentication no in the sshd configuration file that sets it (or /etc/ssh/sshd_config) and reload sshd"
 
 	if opts.Fix {
 		if !opts.Yes {
 			return f, errors.New("--fix requested but requires --yes to apply changes")
 		}
-		if err := setSSHDConfigKey(cfgPath, "PasswordAuthentication", "no"); err != nil {
+		tx, err := opts.fix.begin()
+		if err != nil {
+			return f, err
+		}
+		if err := snapshotSSHDConfig(tx, cfgPath); err != nil {
+			return f, err
+		}
+		if err := tx.Do("Set PasswordAuthentication no", func() error {
+			return setSSHDConfigKey(ctx, tx, cfgPath, "PasswordAuthentication", "no")
+		}); err != nil {
+			return f, err
+		}
+		if err := reloadSSHDInTx(ctx, tx); err != nil {
 			return f, err
 		}
-		_ = exec.CommandContext(ctx, "systemctl", "reload", "sshd").Run()
 		f.Result = ResultWarn
 		f.Details = "applied remediation; re-run audit to verify"
 	}
@@ -220,14 +446,73 @@ func checkSSHPasswordAuth(ctx context.Context, opts AuditOptions) (Finding, erro
 	return f, nil
 }
 
+// fixTx is the transaction of "audit --fix". It is begun by the first
+// check that remediates, so an audit that changes nothing leaves no
+// rollback entry behind. A nil *fixTx hands out a nil *Transaction.
+type fixTx struct {
+	dir, command string
+	tx           *Transaction
+	uses         int
+}
+
+func (l *fixTx) begin() (*Transaction, error) {
+	if l == nil {
+		return nil, nil
+	}
+	if l.tx == nil {
+		tx, err := BeginTransaction(l.dir, l.command)
+		if err != nil {
+			return nil, err
+		}
+		l.tx = tx
+	}
+	l.uses++
+	return l.tx, nil
+}
+
+// begun counts the begin calls, telling RunAudit which checks remediated.
+func (l *fixTx) begun() int {
+	if l == nil {
+		return 0
+	}
+	return l.uses
+}
+
+func (l *fixTx) transaction() *Transaction {
+	if l == nil {
+		return nil
+	}
+	return l.tx
+}
+
+// sshdMatchNotNo returns the first Match block that sets an option to
+// something other than "no".
+func sshdMatchNotNo(matches []sshdMatchValue) (sshdMatchValue, bool) {
+	for _, m := range matches {
+		if !strings.EqualFold(strings.TrimSpace(m.Value), "no") {
+			return m, true
+		}
+	}
+	return sshdMatchValue{}, false
+}
+
 func checkIPForwarding(ctx context.Context, opts AuditOptions) (Finding, error) {
 	f := Finding{ID: "sysctl.ip_forward", Title: "Ensure IPv4 forwarding is disabled", Weight: 20}
-	if runtime.GOOS != "linux" {
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
 		f.Result = ResultSkip
 		f.Details = "not supported on this OS"
 		return f, nil
 	}
 
+	if opts.root.isImage() {
+		res, details, err := evalPersistedSysctl(opts.root, "net.ipv4.ip_forward", "eq", "0")
+		f.Result, f.Details = res, details
+		if res == ResultFail {
+			f.Recommendation = "Set net.ipv4.ip_forward=0 in the image's /etc/sysctl.d"
+		}
+		return f, err
+	}
+
 	val, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
 	if err != nil {
 		f.Result = ResultWarn
@@ -245,8 +530,24 @@ func checkIPForwarding(ctx context.Context, opts AuditOptions) (Finding, error)
 		if !opts.Yes {
 			return f, errors.New("--fix requested but requires --yes to apply changes")
 		}
-		_ = exec.CommandContext(ctx, "sysctl", "-w", "net.ipv4.ip_forward=0").Run()
-		if err := persistSysctl("net.ipv4.ip_forward", "0"); err != nil {
+		tx, err := opts.fix.begin()
+		if err != nil {
+			return f, err
+		}
+		if err := tx.SnapshotSysctl("net.ipv4.ip_forward"); err != nil {
+			return f, err
+		}
+		if err := tx.SnapshotFile(sysctlPersistPath); err != nil {
+			return f, err
+		}
+		if err := tx.Do("sysctl -w net.ipv4.ip_forward=0", func() error {
+			return setSysctl(ctx, "net.ipv4.ip_forward", "0")
+		}); err != nil {
+			return f, err
+		}
+		if err := tx.Do("Persist sysctl net.ipv4.ip_forward=0", func() error {
+			return persistSysctl("net.ipv4.ip_forward", "0")
+		}); err != nil {
 			return f, err
 		}
 		f.Result = ResultWarn
@@ -258,23 +559,27 @@ func checkIPForwarding(ctx context.Context, opts AuditOptions) (Finding, error)
 
 func checkFirewallPresence(ctx context.Context, opts AuditOptions) (Finding, error) {
 	f := Finding{ID: "firewall.present", Title: "Ensure a firewall is installed", Weight: 25}
-	if runtime.GOOS != "linux" {
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
 		f.Result = ResultSkip
 		f.Details = "not supported on this OS"
 		return f, nil
 	}
 
-	if _, err := exec.LookPath("ufw"); err == nil {
+	lookPath := exec.LookPath
+	if opts.root.isImage() {
+		lookPath = opts.root.lookPath
+	}
+	if _, err := lookPath("ufw"); err == nil {
 		f.Result = ResultPass
 		f.Details = "ufw detected"
 		return f, nil
 	}
-	if _, err := exec.LookPath("nft"); err == nil {
+	if _, err := lookPath("nft"); err == nil {
 		f.Result = ResultPass
 		f.Details = "nftables detected"
 		return f, nil
 	}
-	if _, err := exec.LookPath("iptables"); err == nil {
+	if _, err := lookPath("iptables"); err == nil {
 		f.Result = ResultWarn
 		f.Details = "iptables detected (legacy)"
 		return f, nil
@@ -282,12 +587,13 @@ func checkFirewallPresence(ctx context.Context, opts AuditOptions) (Finding, err
 
 	f.Result = ResultFail
 	f.Recommendation = "Install and enable ufw or nftables"
-	_ = opts
 	return f, nil
 }
 
+const sysctlPersistPath = "/etc/sysctl.d/99-fortis.conf"
+
 func persistSysctl(key, value string) error {
-	path := "/etc/sysctl.d/99-fortis.conf"
+	path := sysctlPersistPath
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
@@ -316,51 +622,3 @@ func persistSysctl(key, value string) error {
 	content := strings.Join(lines, "\n") + "\n"
 	return os.WriteFile(path, []byte(content), 0o644)
 }
-
-func sshdConfigValue(cfg string, key string) (string, bool) {
-	s := bufio.NewScanner(strings.NewReader(cfg))
-	for s.Scan() {
-		line := strings.TrimSpace(s.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && strings.EqualFold(fields[0], key) {
-			return strings.Join(fields[1:], " "), true
-		}
-	}
-	return "", false
-}
-
-func setSSHDConfigKey(path, key, value string) error {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return err
-	}
-
-	backup := fmt.Sprintf("%s.bak.%d", path, time.Now().Unix())
-	if err := os.WriteFile(backup, b, 0o600); err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(b), "\n")
-	updated := false
-	for i := range lines {
-		trim := strings.TrimSpace(lines[i])
-		if trim == "" || strings.HasPrefix(trim, "#") {
-			continue
-		}
-		fields := strings.Fields(trim)
-		if len(fields) >= 1 && strings.EqualFold(fields[0], key) {
-			lines[i] = fmt.Sprintf("%s %s", key, value)
-			updated = true
-			break
-		}
-	}
-	if !updated {
-		lines = append(lines, fmt.Sprintf("%s %s", key, value))
-	}
-
-	out := strings.Join(lines, "\n")
-	return os.WriteFile(path, []byte(out), 0o600)
-}
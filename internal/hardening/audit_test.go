@@ -0,0 +1,136 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunAuditFixTransaction(t *testing.T) {
+	// fixCheck fails and, with --fix, rewrites path inside the audit's
+	// transaction; err makes the change step fail.
+	fixCheck := func(id, path string, err error) Check {
+		return Check{ID: id, Title: id, Source: "test", Run: func(ctx context.Context, opts AuditOptions) (Finding, error) {
+			f := Finding{Result: ResultFail}
+			if !opts.Fix {
+				return f, nil
+			}
+			tx, terr := opts.fix.begin()
+			if terr != nil {
+				return f, terr
+			}
+			if terr := tx.SnapshotFile(path); terr != nil {
+				return f, terr
+			}
+			if terr := tx.Do("rewrite "+filepath.Base(path), func() error {
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(path, []byte("fixed\n"), 0o644)
+			}); terr != nil {
+				return f, terr
+			}
+			f.Result = ResultWarn
+			f.Details = "applied remediation; re-run audit to verify"
+			return f, nil
+		}}
+	}
+	passCheck := Check{ID: "test.pass", Title: "pass", Source: "test", Run: func(ctx context.Context, opts AuditOptions) (Finding, error) {
+		return Finding{Result: ResultPass}, nil
+	}}
+
+	tests := []struct {
+		name        string
+		checks      func(dir string) []Check
+		wantTx      bool
+		wantErr     string
+		wantDetails map[string]string
+		wantFiles   map[string]string
+	}{
+		{
+			name:   "nothing to fix begins no transaction",
+			checks: func(dir string) []Check { return []Check{passCheck} },
+		},
+		{
+			name: "fixes are committed",
+			checks: func(dir string) []Check {
+				return []Check{fixCheck("test.a", filepath.Join(dir, "a.conf"), nil), passCheck}
+			},
+			wantTx:      true,
+			wantDetails: map[string]string{"test.a": "applied remediation"},
+			wantFiles:   map[string]string{"a.conf": "fixed\n"},
+		},
+		{
+			name: "a failed step rolls back the fixes before it",
+			checks: func(dir string) []Check {
+				return []Check{
+					fixCheck("test.a", filepath.Join(dir, "a.conf"), nil),
+					passCheck,
+					fixCheck("test.b", filepath.Join(dir, "b.conf"), errors.New("disk full")),
+				}
+			},
+			wantTx:  true,
+			wantErr: "rewrite b.conf: disk full",
+			wantDetails: map[string]string{
+				"test.a": "remediation rolled back: rewrite b.conf: disk full",
+				"test.b": "remediation rolled back",
+			},
+			wantFiles: map[string]string{"a.conf": "original\n", "b.conf": "original\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range []string{"a.conf", "b.conf"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("original\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reg := NewRegistry()
+			for _, c := range tt.checks(dir) {
+				if err := reg.Register(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rollbackDir := filepath.Join(dir, "rollback")
+			rep, err := RunAudit(context.Background(), AuditOptions{
+				Registry:    reg,
+				ConfigDir:   dir,
+				RollbackDir: rollbackDir,
+				Fix:         true,
+				Yes:         true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, _ := os.ReadDir(rollbackDir)
+			if got := len(entries) > 0; got != tt.wantTx || (rep.Transaction != "") != tt.wantTx {
+				t.Errorf("transaction %q with %d rollback entries, want one: %t", rep.Transaction, len(entries), tt.wantTx)
+			}
+			if !strings.Contains(rep.RemediationError, tt.wantErr) || (tt.wantErr == "") != (rep.RemediationError == "") {
+				t.Errorf("remediation error = %q, want %q", rep.RemediationError, tt.wantErr)
+			}
+			for _, f := range rep.Findings {
+				want, ok := tt.wantDetails[f.ID]
+				if !ok {
+					continue
+				}
+				if !strings.Contains(f.Details, want) {
+					t.Errorf("%s details = %q, want %q", f.ID, f.Details, want)
+				}
+				if strings.Contains(want, "rolled back") && f.Result != ResultFail {
+					t.Errorf("%s result = %s after rollback, want fail", f.ID, f.Result)
+				}
+			}
+			for name, want := range tt.wantFiles {
+				if b, _ := os.ReadFile(filepath.Join(dir, name)); string(b) != want {
+					t.Errorf("%s = %q, want %q", name, b, want)
+				}
+			}
+		})
+	}
+}
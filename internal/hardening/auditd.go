@@ -0,0 +1,1095 @@
+package hardening
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in audit rule sets. A file with the same name in <config-dir>/auditd
+// replaces the built-in one.
+//
+//go:embed auditd/*.yaml
+var auditdRuleSetFS embed.FS
+
+const (
+	auditRulesDir = "/etc/audit/rules.d"
+	// AuditdRulesFile holds the generated rules. augenrules concatenates
+	// rules.d in lexical order, so site files can sort before or after it.
+	AuditdRulesFile = "/etc/audit/rules.d/50-fortis.rules"
+	// auditdFinalizeFile sets -e 2 last, locking the rules until reboot.
+	auditdFinalizeFile = "/etc/audit/rules.d/99-fortis-finalize.rules"
+	// auditdLegacyFile was written by the auditd-setup.sh script.
+	auditdLegacyFile = "/etc/audit/rules.d/fortis.rules"
+)
+
+// AuditdRuleSet is a named list of audit rule groups.
+//
+//	name: site
+//	groups:
+//	  - name: identity
+//	    benchmark: CIS 4.1.3.8
+//	    rules:
+//	      - -w /etc/passwd -p wa -k identity
+//	      - -a always,exit -F arch=${arch} -S sethostname -k system-locale
+//	  - name: privileged
+//	    suid: -a always,exit -F path=${path} -F perm=x -F auid>=${uid_min} -F auid!=unset -k privileged
+type AuditdRuleSet struct {
+	Name        string        `json:"name" yaml:"name"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Groups      []AuditdGroup `json:"groups" yaml:"groups"`
+	Source      string        `json:"source" yaml:"-"`
+}
+
+type AuditdGroup struct {
+	Name        string   `json:"name" yaml:"name"`
+	Benchmark   string   `json:"benchmark,omitempty" yaml:"benchmark,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Rules       []string `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// SUID is a rule added once per SUID/SGID program, ${path} being the
+	// program.
+	SUID string `json:"suid,omitempty" yaml:"suid,omitempty"`
+}
+
+// auditdVars are the placeholders of rule templates, with the values used
+// to check that a template parses.
+var auditdVars = map[string]string{"${arch}": "b64", "${uid_min}": "1000", "${path}": "/usr/bin/true"}
+
+func parseAuditdRuleSet(source string, b []byte) (AuditdRuleSet, error) {
+	var s AuditdRuleSet
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return AuditdRuleSet{}, fmt.Errorf("%s: %w", source, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(path.Base(source), path.Ext(source))
+	}
+	s.Source = source
+	for i, g := range s.Groups {
+		if g.Name == "" {
+			return AuditdRuleSet{}, fmt.Errorf("%s: group %d: name is required", source, i+1)
+		}
+		templates := g.Rules
+		if g.SUID != "" {
+			templates = append(templates[:len(templates):len(templates)], g.SUID)
+		}
+		for _, t := range templates {
+			text := t
+			for k, v := range auditdVars {
+				text = strings.ReplaceAll(text, k, v)
+			}
+			if strings.Contains(text, "${") {
+				return AuditdRuleSet{}, fmt.Errorf("%s: group %s: unknown placeholder in %q", source, g.Name, t)
+			}
+			r, err := parseAuditRule(text)
+			if err != nil {
+				return AuditdRuleSet{}, fmt.Errorf("%s: group %s: %q: %w", source, g.Name, t, err)
+			}
+			if r.control {
+				return AuditdRuleSet{}, fmt.Errorf("%s: group %s: %q: control options are not rules", source, g.Name, t)
+			}
+		}
+	}
+	return s, nil
+}
+
+// LoadAuditdRuleSet reads <configDir>/auditd/<name>.yaml, falling back to
+// the built-in rule set of that name.
+func LoadAuditdRuleSet(configDir, name string) (AuditdRuleSet, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return AuditdRuleSet{}, fmt.Errorf("invalid audit rule set name %q", name)
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		p := filepath.Join(resolveConfigDir(configDir), "auditd", name+ext)
+		b, err := os.ReadFile(p)
+		if err == nil {
+			return parseAuditdRuleSet(p, b)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return AuditdRuleSet{}, err
+		}
+	}
+	b, err := auditdRuleSetFS.ReadFile("auditd/" + name + ".yaml")
+	if err != nil {
+		builtin, _ := fs.Glob(auditdRuleSetFS, "auditd/*.yaml")
+		for i := range builtin {
+			builtin[i] = strings.TrimSuffix(path.Base(builtin[i]), ".yaml")
+		}
+		return AuditdRuleSet{}, fmt.Errorf("unknown audit rule set %q (built-in: %s)", name, strings.Join(builtin, ", "))
+	}
+	return parseAuditdRuleSet("builtin:"+name+".yaml", b)
+}
+
+// auditRule is one parsed auditctl rule. Rules compare by their canonical
+// form, close to what "auditctl -l" prints: keys as -k, fields and
+// syscalls sorted, unset ids as "unset", and exit rules that only watch a
+// path as -w.
+type auditRule struct {
+	tokens   []string
+	control  bool
+	watch    string
+	perm     string
+	action   string
+	list     string
+	fields   []string
+	syscalls []string
+	keys     []string
+}
+
+// auditControlOptions are the auditctl options that configure the audit
+// system instead of adding a rule, and whether they take a value.
+var auditControlOptions = map[string]bool{
+	"-D": false, "-i": false, "-c": false, "--loginuid-immutable": false, "--reset-lost": false,
+	"-e": true, "-b": true, "-f": true, "-r": true, "--backlog_wait_time": true,
+}
+
+var (
+	auditActions = map[string]bool{"always": true, "never": true}
+	auditLists   = map[string]bool{"exit": true, "task": true, "user": true, "exclude": true, "filesystem": true, "io_uring": true}
+	// auditIDFields hold ids where -1 and 4294967295 mean unset.
+	auditIDFields = map[string]bool{"auid": true, "uid": true, "euid": true, "suid": true, "fsuid": true, "loginuid": true, "obj_uid": true, "gid": true, "egid": true, "sgid": true, "fsgid": true, "obj_gid": true}
+)
+
+func parseAuditRule(line string) (auditRule, error) {
+	r := auditRule{tokens: strings.Fields(line)}
+	for i := 0; i < len(r.tokens); i++ {
+		opt := r.tokens[i]
+		value := func() (string, error) {
+			if i+1 >= len(r.tokens) {
+				return "", fmt.Errorf("%s needs a value", opt)
+			}
+			i++
+			return r.tokens[i], nil
+		}
+		if hasValue, ok := auditControlOptions[opt]; ok {
+			r.control = true
+			if hasValue {
+				if _, err := value(); err != nil {
+					return auditRule{}, err
+				}
+			}
+			continue
+		}
+		v, err := value()
+		if err != nil {
+			return auditRule{}, err
+		}
+		switch opt {
+		case "-w":
+			r.watch = v
+		case "-p":
+			r.perm = v
+		case "-k":
+			r.keys = append(r.keys, v)
+		case "-a", "-A":
+			a, l, ok := strings.Cut(v, ",")
+			if !ok {
+				return auditRule{}, fmt.Errorf("%s %s: want action,list", opt, v)
+			}
+			if auditLists[a] && auditActions[l] {
+				a, l = l, a
+			}
+			if !auditActions[a] || !auditLists[l] {
+				return auditRule{}, fmt.Errorf("%s %s: want action,list", opt, v)
+			}
+			r.action, r.list = a, l
+		case "-S":
+			for _, s := range strings.Split(v, ",") {
+				if s != "" {
+					r.syscalls = append(r.syscalls, s)
+				}
+			}
+		case "-F":
+			name, op, val, ok := splitAuditField(v)
+			if !ok {
+				return auditRule{}, fmt.Errorf("-F %s: want field, operator and value", v)
+			}
+			if name == "key" {
+				r.keys = append(r.keys, val)
+				continue
+			}
+			r.fields = append(r.fields, name+op+normalizeAuditValue(name, val))
+		case "-C":
+			left, op, right, ok := splitAuditField(v)
+			if !ok || (op != "=" && op != "!=") {
+				return auditRule{}, fmt.Errorf("-C %s: want field=field or field!=field", v)
+			}
+			if right < left {
+				left, right = right, left
+			}
+			r.fields = append(r.fields, "C:"+left+op+right)
+		default:
+			return auditRule{}, fmt.Errorf("unknown option %s", opt)
+		}
+	}
+	switch {
+	case r.control:
+		if r.watch != "" || r.list != "" {
+			return auditRule{}, errors.New("control options cannot be combined with a rule")
+		}
+		return r, nil
+	case r.watch != "" && r.list != "":
+		return auditRule{}, errors.New("-w and -a cannot be combined")
+	case r.watch != "":
+		if len(r.syscalls) > 0 || len(r.fields) > 0 {
+			return auditRule{}, errors.New("-S and -F need -a, not -w")
+		}
+		if r.perm == "" {
+			r.perm = "rwxa"
+		}
+	case r.list != "":
+		if r.perm != "" {
+			return auditRule{}, errors.New("-p needs -w; use -F perm= with -a")
+		}
+	default:
+		return auditRule{}, errors.New("no -w or -a")
+	}
+	if r.perm != "" {
+		perm, ok := normalizeAuditPerm(r.perm)
+		if !ok {
+			return auditRule{}, fmt.Errorf("invalid permission %q", r.perm)
+		}
+		r.perm = perm
+	}
+	r.watch = trimAuditPath(r.watch)
+	if r.list == "exit" && len(r.syscalls) == 0 {
+		r.syscalls = []string{"all"}
+	}
+	sort.Strings(r.fields)
+	sort.Strings(r.syscalls)
+	sort.Strings(r.keys)
+	r.asWatch()
+	return r, nil
+}
+
+// asWatch turns "-a always,exit -F path=P -F perm=wa" into the watch it is.
+func (r *auditRule) asWatch() {
+	if r.action != "always" || r.list != "exit" || len(r.syscalls) != 1 || r.syscalls[0] != "all" {
+		return
+	}
+	var watch, perm string
+	for _, f := range r.fields {
+		name, op, val, _ := splitAuditField(f)
+		switch {
+		case op == "=" && (name == "path" || name == "dir"):
+			watch = val
+		case op == "=" && name == "perm":
+			perm = val
+		default:
+			return
+		}
+	}
+	if watch == "" || perm == "" {
+		return
+	}
+	r.watch, r.perm, r.action, r.list, r.fields, r.syscalls = watch, perm, "", "", nil, nil
+}
+
+// splitAuditField splits "auid>=1000" into name, operator and value.
+func splitAuditField(s string) (name, op, value string, ok bool) {
+	i := strings.IndexAny(s, "!=<>&")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	op = s[i : i+1]
+	if i+1 < len(s) && s[i+1] == '=' {
+		op = s[i : i+2]
+	}
+	if op == "!" {
+		return "", "", "", false
+	}
+	value = s[i+len(op):]
+	return s[:i], op, value, value != ""
+}
+
+func normalizeAuditValue(name, v string) string {
+	switch {
+	case auditIDFields[name] && (v == "-1" || v == "4294967295"):
+		return "unset"
+	case name == "perm":
+		if p, ok := normalizeAuditPerm(v); ok {
+			return p
+		}
+	case name == "path" || name == "dir":
+		return trimAuditPath(v)
+	}
+	return v
+}
+
+func normalizeAuditPerm(p string) (string, bool) {
+	var b strings.Builder
+	for _, c := range "rwxa" {
+		if strings.ContainsRune(p, c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String(), b.Len() == len(p)
+}
+
+func trimAuditPath(p string) string {
+	if len(p) > 1 {
+		return strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+func (r auditRule) canonical() string {
+	var b strings.Builder
+	if r.watch != "" {
+		fmt.Fprintf(&b, "-w %s -p %s", r.watch, r.perm)
+	} else {
+		fmt.Fprintf(&b, "-a %s,%s", r.action, r.list)
+		for _, f := range r.fields {
+			if c, ok := strings.CutPrefix(f, "C:"); ok {
+				fmt.Fprintf(&b, " -C %s", c)
+			} else {
+				fmt.Fprintf(&b, " -F %s", f)
+			}
+		}
+		if len(r.syscalls) > 0 {
+			fmt.Fprintf(&b, " -S %s", strings.Join(r.syscalls, ","))
+		}
+	}
+	for _, k := range r.keys {
+		fmt.Fprintf(&b, " -k %s", k)
+	}
+	return b.String()
+}
+
+func (r auditRule) String() string { return strings.Join(r.tokens, " ") }
+
+// field returns the value of the first "name=" field.
+func (r auditRule) field(name string) string {
+	for _, f := range r.fields {
+		if n, op, v, _ := splitAuditField(f); n == name && op == "=" {
+			return v
+		}
+	}
+	return ""
+}
+
+// withoutSyscalls drops the named syscalls from every -S of the rule. ok
+// is false when none remain.
+func (r auditRule) withoutSyscalls(drop map[string]bool) (auditRule, bool) {
+	var tokens []string
+	for i := 0; i < len(r.tokens); i++ {
+		if r.tokens[i] != "-S" || i+1 >= len(r.tokens) {
+			tokens = append(tokens, r.tokens[i])
+			continue
+		}
+		i++
+		var keep []string
+		for _, s := range strings.Split(r.tokens[i], ",") {
+			if !drop[s] {
+				keep = append(keep, s)
+			}
+		}
+		if len(keep) > 0 {
+			tokens = append(tokens, "-S", strings.Join(keep, ","))
+		}
+	}
+	nr, err := parseAuditRule(strings.Join(tokens, " "))
+	if err != nil || (len(nr.syscalls) == 1 && nr.syscalls[0] == "all") {
+		return auditRule{}, false
+	}
+	return nr, true
+}
+
+// auditRuleLine is a rule read from a rules file.
+type auditRuleLine struct {
+	file string
+	line int
+	rule auditRule
+}
+
+// readAuditRulesDir parses the *.rules files of rules.d in the order
+// augenrules concatenates them. Lines that do not parse are returned as
+// notes.
+func readAuditRulesDir(root *auditRoot) ([]auditRuleLine, []string) {
+	files, _ := root.Glob(path.Join(auditRulesDir, "*.rules"))
+	sort.Strings(files)
+	var rules []auditRuleLine
+	var notes []string
+	for _, f := range files {
+		b, err := root.ReadFile(f)
+		if err != nil {
+			notes = append(notes, err.Error())
+			continue
+		}
+		for i, ln := range strings.Split(string(b), "\n") {
+			ln = strings.TrimSpace(ln)
+			if ln == "" || strings.HasPrefix(ln, "#") {
+				continue
+			}
+			r, err := parseAuditRule(ln)
+			if err != nil {
+				notes = append(notes, fmt.Sprintf("%s:%d: %v", f, i+1, err))
+				continue
+			}
+			rules = append(rules, auditRuleLine{file: f, line: i + 1, rule: r})
+		}
+	}
+	return rules, notes
+}
+
+// loadedAuditRules returns the rules "auditctl -l" lists and the audit
+// enabled flag of "auditctl -s" (2: rules are locked until reboot).
+func loadedAuditRules(ctx context.Context) ([]auditRule, int, error) {
+	out, err := exec.CommandContext(ctx, "auditctl", "-l").CombinedOutput()
+	if err != nil {
+		if len(out) == 0 {
+			return nil, 0, fmt.Errorf("auditctl -l: %w", err)
+		}
+		return nil, 0, fmt.Errorf("auditctl -l: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	var rules []auditRule
+	for _, ln := range strings.Split(string(out), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln == "No rules" {
+			continue
+		}
+		r, err := parseAuditRule(ln)
+		if err != nil {
+			return nil, 0, fmt.Errorf("auditctl -l: %q: %w", ln, err)
+		}
+		rules = append(rules, r)
+	}
+	enabled := 0
+	if st, err := exec.CommandContext(ctx, "auditctl", "-s").Output(); err == nil {
+		for _, ln := range strings.Split(string(st), "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(ln), "enabled "); ok {
+				enabled, _ = strconv.Atoi(strings.TrimSpace(v))
+			}
+		}
+	}
+	return rules, enabled, nil
+}
+
+// loadAuditRules has augenrules merge rules.d into audit.rules and load it.
+func loadAuditRules(ctx context.Context) error {
+	if _, err := exec.LookPath("augenrules"); err != nil {
+		return errors.New("augenrules not found; install auditd")
+	}
+	return runCmd(ctx, nil, "augenrules", "--load")
+}
+
+// auditArches maps GOARCH to the audit ABIs of its syscall rules and the
+// ausyscall table of each.
+var auditArches = map[string][][2]string{
+	"amd64":   {{"b64", "x86_64"}, {"b32", "i386"}},
+	"arm64":   {{"b64", "aarch64"}},
+	"ppc64le": {{"b64", "ppc64"}},
+	"s390x":   {{"b64", "s390x"}},
+	"386":     {{"b32", "i386"}},
+	"arm":     {{"b32", "arm"}},
+}
+
+// ausyscallTable returns the syscall names ausyscall knows for machine.
+func ausyscallTable(ctx context.Context, machine string) (map[string]bool, error) {
+	out, err := exec.CommandContext(ctx, "ausyscall", machine, "--dump").Output()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, ln := range strings.Split(string(out), "\n") {
+		f := strings.Fields(ln)
+		if len(f) == 2 {
+			if _, err := strconv.Atoi(f[0]); err == nil {
+				names[f[1]] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("ausyscall %s: empty syscall table", machine)
+	}
+	return names, nil
+}
+
+// auditdSkipDirs are not searched for SUID/SGID programs: pseudo
+// filesystems and container storage, whose programs never run on the host
+// by path.
+var auditdSkipDirs = map[string]bool{"/proc": true, "/sys": true, "/dev": true, "/run": true, "/var/lib/docker": true, "/var/lib/containers": true}
+
+// auditdRemoteFS are filesystem types whose programs are not audited.
+var auditdRemoteFS = map[string]bool{"nfs": true, "nfs4": true, "cifs": true, "smb3": true, "9p": true, "ceph": true, "glusterfs": true, "afs": true, "fuse.sshfs": true}
+
+// privilegedPrograms lists the SUID/SGID executables of root. On the host,
+// remote, nosuid and noexec mounts are skipped as CIS does.
+func privilegedPrograms(ctx context.Context, root *auditRoot) []string {
+	skip := map[string]bool{}
+	for d := range auditdSkipDirs {
+		skip[d] = true
+	}
+	if !root.isImage() {
+		if b, err := os.ReadFile("/proc/self/mounts"); err == nil {
+			for _, ln := range strings.Split(string(b), "\n") {
+				f := strings.Fields(ln)
+				if len(f) < 4 || f[1] == "/" {
+					continue
+				}
+				opts := "," + f[3] + ","
+				if auditdRemoteFS[f[2]] || strings.Contains(opts, ",nosuid,") || strings.Contains(opts, ",noexec,") {
+					skip[f[1]] = true
+				}
+			}
+		}
+	}
+	var out []string
+	_ = root.WalkDir("/", func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if skip[p] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if m := fi.Mode(); m&(fs.ModeSetuid|fs.ModeSetgid) != 0 && m.Perm()&0o111 != 0 {
+			out = append(out, p)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out
+}
+
+// AuditdRule is one rule generated from a rule set.
+type AuditdRule struct {
+	Group     string `json:"group"`
+	Benchmark string `json:"benchmark,omitempty"`
+	Rule      string `json:"rule"`
+	// File is the rules.d file that has the rule; Loaded reports whether
+	// "auditctl -l" lists it (unset when the loaded rules were not read).
+	File   string `json:"file,omitempty"`
+	Loaded *bool  `json:"loaded,omitempty"`
+	canon  string
+}
+
+// auditdRules are the rules of a rule set expanded for one system.
+type auditdRules struct {
+	set     AuditdRuleSet
+	rules   []AuditdRule
+	skipped []string
+	notes   []string
+}
+
+// generateAuditdRules expands the templates of set for root: ${arch} per
+// syscall ABI, ${uid_min} from login.defs, suid rules per SUID/SGID
+// program. Watches below missing directories, rules for programs that
+// are not installed and syscalls the ABI does not have are left out.
+func generateAuditdRules(ctx context.Context, set AuditdRuleSet, root *auditRoot) (auditdRules, error) {
+	g := auditdRules{set: set}
+	arches := auditArches[runtime.GOARCH]
+	if len(arches) == 0 {
+		return g, fmt.Errorf("no audit syscall ABI known for %s", runtime.GOARCH)
+	}
+	if root.isImage() {
+		g.notes = append(g.notes, fmt.Sprintf("syscall rules are generated for %s, the architecture of this host", runtime.GOARCH))
+	}
+	tables := map[string]map[string]bool{}
+	if !root.isImage() {
+		for _, a := range arches {
+			t, err := ausyscallTable(ctx, a[1])
+			if err != nil {
+				g.notes = append(g.notes, "syscall names not validated: "+err.Error())
+				tables = nil
+				break
+			}
+			tables[a[0]] = t
+		}
+	}
+	uidMin := strconv.Itoa(readLoginDefs(root).UIDMin)
+	var programs []string
+	seen := map[string]bool{}
+
+	add := func(grp AuditdGroup, text string) {
+		r, err := parseAuditRule(text)
+		if err != nil {
+			g.skipped = append(g.skipped, fmt.Sprintf("%s: %v", text, err))
+			return
+		}
+		if w := r.watch; w != "" && !root.exists(path.Dir(w)) {
+			g.skipped = append(g.skipped, fmt.Sprintf("%s: no directory %s", text, path.Dir(w)))
+			return
+		}
+		if p := r.field("path"); p != "" && !root.exists(p) {
+			g.skipped = append(g.skipped, fmt.Sprintf("%s: %s is not installed", text, p))
+			return
+		}
+		if t := tables[r.field("arch")]; t != nil && r.watch == "" && r.syscalls[0] != "all" {
+			unknown := map[string]bool{}
+			var names []string
+			for _, s := range r.syscalls {
+				if !t[s] {
+					unknown[s] = true
+					names = append(names, s)
+				}
+			}
+			if len(unknown) > 0 {
+				nr, ok := r.withoutSyscalls(unknown)
+				if !ok {
+					g.skipped = append(g.skipped, fmt.Sprintf("%s: %s has no %s", text, r.field("arch"), strings.Join(names, ", ")))
+					return
+				}
+				r = nr
+			}
+		}
+		c := r.canonical()
+		if seen[c] {
+			return
+		}
+		seen[c] = true
+		g.rules = append(g.rules, AuditdRule{Group: grp.Name, Benchmark: grp.Benchmark, Rule: r.String(), canon: c})
+	}
+
+	for _, grp := range set.Groups {
+		templates := grp.Rules
+		if grp.SUID != "" {
+			if programs == nil {
+				programs = privilegedPrograms(ctx, root)
+				if err := ctx.Err(); err != nil {
+					return g, err
+				}
+			}
+			for _, p := range programs {
+				templates = append(templates[:len(templates):len(templates)], strings.ReplaceAll(grp.SUID, "${path}", p))
+			}
+		}
+		for _, t := range templates {
+			t = strings.ReplaceAll(t, "${uid_min}", uidMin)
+			if !strings.Contains(t, "${arch}") {
+				add(grp, t)
+				continue
+			}
+			for _, a := range arches {
+				add(grp, strings.ReplaceAll(t, "${arch}", a[0]))
+			}
+		}
+	}
+	return g, nil
+}
+
+type AuditdAuditOptions struct {
+	RuleSet   string
+	ConfigDir string
+	// Root audits an image root or tarball; loaded rules are not read.
+	Root string
+	root *auditRoot
+}
+
+// AuditdReport compares a rule set with the rules.d files and the rules
+// loaded in the kernel.
+type AuditdReport struct {
+	RuleSet string       `json:"rule_set"`
+	Rules   []AuditdRule `json:"rules"`
+	// Missing are generated rules "auditctl -l" does not list, NotPersisted
+	// those no rules.d file has.
+	Missing      []string `json:"missing,omitempty"`
+	NotPersisted []string `json:"not_persisted,omitempty"`
+	// NotLoaded are rules.d rules the kernel does not have, LoadedOnly
+	// loaded rules no rules.d file has.
+	NotLoaded  []string `json:"not_loaded,omitempty"`
+	LoadedOnly []string `json:"loaded_only,omitempty"`
+	// LoadedRead is false when "auditctl -l" could not be run; Immutable
+	// is set when the loaded rules are locked until reboot (-e 2).
+	LoadedRead bool     `json:"loaded_read"`
+	Immutable  bool     `json:"immutable,omitempty"`
+	Skipped    []string `json:"skipped,omitempty"`
+	Notes      []string `json:"notes,omitempty"`
+}
+
+// AuditAuditd generates the rules of a rule set for the system and reports
+// which are missing from rules.d and from the loaded rules.
+func AuditAuditd(ctx context.Context, opts AuditdAuditOptions) (AuditdReport, error) {
+	root := opts.root
+	if root == nil && opts.Root != "" {
+		r, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return AuditdReport{}, err
+		}
+		root = r
+	}
+	if runtime.GOOS != "linux" && !root.isImage() {
+		return AuditdReport{}, errors.New("auditd is not supported on this OS")
+	}
+	if opts.RuleSet == "" {
+		opts.RuleSet = "cis"
+	}
+	set, err := LoadAuditdRuleSet(opts.ConfigDir, opts.RuleSet)
+	if err != nil {
+		return AuditdReport{}, err
+	}
+	gen, err := generateAuditdRules(ctx, set, root)
+	if err != nil {
+		return AuditdReport{}, err
+	}
+	rep := AuditdReport{RuleSet: set.Name, Rules: gen.rules, Skipped: gen.skipped, Notes: gen.notes}
+
+	disk, notes := readAuditRulesDir(root)
+	rep.Notes = append(rep.Notes, notes...)
+	onDisk := map[string]string{}
+	for _, l := range disk {
+		if c := l.rule.canonical(); !l.rule.control && onDisk[c] == "" {
+			onDisk[c] = l.file
+		}
+	}
+	for i := range rep.Rules {
+		r := &rep.Rules[i]
+		if r.File = onDisk[r.canon]; r.File == "" {
+			rep.NotPersisted = append(rep.NotPersisted, r.Rule)
+		}
+	}
+
+	if root.isImage() {
+		rep.Notes = append(rep.Notes, "loaded rules not compared: "+runtimeOnly)
+		return rep, nil
+	}
+	loaded, enabled, err := loadedAuditRules(ctx)
+	if err != nil {
+		rep.Notes = append(rep.Notes, "loaded rules not compared: "+err.Error())
+		return rep, nil
+	}
+	rep.LoadedRead, rep.Immutable = true, enabled == 2
+	inKernel := map[string]bool{}
+	for _, r := range loaded {
+		inKernel[r.canonical()] = true
+	}
+	for i := range rep.Rules {
+		r := &rep.Rules[i]
+		ok := inKernel[r.canon]
+		r.Loaded = &ok
+		if !ok {
+			rep.Missing = append(rep.Missing, r.Rule)
+		}
+	}
+	rep.NotLoaded, rep.LoadedOnly = auditRulesDrift(disk, loaded)
+	if rep.Immutable && len(rep.NotLoaded) > 0 {
+		rep.Notes = append(rep.Notes, "loaded rules are immutable (-e 2); rules.d changes take effect after reboot")
+	}
+	return rep, nil
+}
+
+// auditRulesDrift compares the rules.d rules with the loaded ones.
+func auditRulesDrift(disk []auditRuleLine, loaded []auditRule) (notLoaded, loadedOnly []string) {
+	inKernel, onDisk := map[string]bool{}, map[string]bool{}
+	for _, r := range loaded {
+		inKernel[r.canonical()] = true
+	}
+	for _, l := range disk {
+		if l.rule.control {
+			continue
+		}
+		c := l.rule.canonical()
+		if !inKernel[c] && !onDisk[c] {
+			notLoaded = append(notLoaded, fmt.Sprintf("%s:%d: %s", l.file, l.line, l.rule))
+		}
+		onDisk[c] = true
+	}
+	for _, r := range loaded {
+		if c := r.canonical(); !r.control && !onDisk[c] {
+			onDisk[c] = true
+			loadedOnly = append(loadedOnly, r.String())
+		}
+	}
+	return notLoaded, loadedOnly
+}
+
+// renderAuditdRules formats the rules file, one comment line per group.
+func renderAuditdRules(set AuditdRuleSet, rules []AuditdRule) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "## Generated by fortis harden auditd from rule set %s; local changes are overwritten.\n", set.Name)
+	group := ""
+	for _, r := range rules {
+		if r.Group != group {
+			group = r.Group
+			b.WriteString("\n## " + group)
+			for _, g := range set.Groups {
+				if g.Name == group && g.Benchmark != "" {
+					b.WriteString(" (" + g.Benchmark + ")")
+				}
+				if g.Name == group && g.Description != "" {
+					b.WriteString(": " + g.Description)
+				}
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(r.Rule + "\n")
+	}
+	return b.Bytes()
+}
+
+type AuditdApplyOptions struct {
+	RuleSet   string
+	ConfigDir string
+	// Immutable adds -e 2, so the rules cannot change until reboot.
+	Immutable   bool
+	Yes         bool
+	DryRun      bool
+	RollbackDir string
+}
+
+type AuditdApplyResult struct {
+	RuleSet       string   `json:"rule_set"`
+	Rules         int      `json:"rules"`
+	Changes       []string `json:"changes"`
+	Missing       []string `json:"missing,omitempty"`
+	Notes         []string `json:"notes,omitempty"`
+	TransactionID string   `json:"transaction_id,omitempty"`
+}
+
+// ApplyAuditdRules writes the rules of a rule set to rules.d and loads
+// them with augenrules in one transaction. Rules that other rules.d files
+// already have are not repeated, as augenrules stops at a duplicate. The
+// change is reverted unless "auditctl -l" lists every rule afterwards.
+func ApplyAuditdRules(ctx context.Context, opts AuditdApplyOptions) (AuditdApplyResult, error) {
+	var res AuditdApplyResult
+	if runtime.GOOS != "linux" {
+		return res, errors.New("auditd is not supported on this OS")
+	}
+	if opts.RuleSet == "" {
+		opts.RuleSet = "cis"
+	}
+	set, err := LoadAuditdRuleSet(opts.ConfigDir, opts.RuleSet)
+	if err != nil {
+		return res, err
+	}
+	gen, err := generateAuditdRules(ctx, set, hostRoot)
+	if err != nil {
+		return res, err
+	}
+	res.RuleSet, res.Rules, res.Notes = set.Name, len(gen.rules), gen.notes
+	if len(gen.skipped) > 0 {
+		res.Notes = append(res.Notes, fmt.Sprintf("%d rules left out (missing paths or syscalls); see 'fortis harden auditd -v'", len(gen.skipped)))
+	}
+
+	disk, notes := readAuditRulesDir(hostRoot)
+	res.Notes = append(res.Notes, notes...)
+	elsewhere := map[string]string{}
+	for _, l := range disk {
+		if l.file != AuditdRulesFile && l.file != auditdLegacyFile && !l.rule.control {
+			elsewhere[l.rule.canonical()] = l.file
+		}
+	}
+	var own []AuditdRule
+	shared := 0
+	for _, r := range gen.rules {
+		if elsewhere[r.canon] != "" {
+			shared++
+			continue
+		}
+		own = append(own, r)
+	}
+	if shared > 0 {
+		res.Notes = append(res.Notes, fmt.Sprintf("%d rules are already in other rules.d files and not repeated", shared))
+	}
+
+	content := renderAuditdRules(set, own)
+	writeRules := true
+	if cur, err := os.ReadFile(AuditdRulesFile); err == nil && bytes.Equal(cur, content) {
+		writeRules = false
+	}
+	removeLegacy := hostRoot.exists(auditdLegacyFile)
+	finalize := []byte("## Generated by fortis harden auditd: lock the audit rules until reboot.\n-e 2\n")
+	writeFinalize := false
+	if opts.Immutable {
+		cur, err := os.ReadFile(auditdFinalizeFile)
+		writeFinalize = err != nil || !bytes.Equal(cur, finalize)
+	}
+
+	_, auditctlErr := exec.LookPath("auditctl")
+	var loaded []auditRule
+	enabled := 0
+	if auditctlErr == nil {
+		if loaded, enabled, err = loadedAuditRules(ctx); err != nil {
+			return res, err
+		}
+	} else {
+		res.Notes = append(res.Notes, "auditctl not found; rules are written but not loaded")
+	}
+	missing := func(loaded []auditRule) []string {
+		in := map[string]bool{}
+		for _, r := range loaded {
+			in[r.canonical()] = true
+		}
+		var out []string
+		for _, r := range gen.rules {
+			if !in[r.canon] {
+				out = append(out, r.Rule)
+			}
+		}
+		return out
+	}
+	load := auditctlErr == nil && enabled != 2 && (writeRules || removeLegacy || len(missing(loaded)) > 0)
+	if auditctlErr == nil && enabled == 2 {
+		res.Notes = append(res.Notes, "loaded rules are immutable (-e 2); the new rules take effect after reboot")
+	}
+
+	if writeRules {
+		res.Changes = append(res.Changes, fmt.Sprintf("write %s (%d rules)", AuditdRulesFile, len(own)))
+	}
+	if removeLegacy {
+		res.Changes = append(res.Changes, "remove "+auditdLegacyFile+" (replaced by "+path.Base(AuditdRulesFile)+")")
+	}
+	if writeFinalize {
+		res.Changes = append(res.Changes, "write "+auditdFinalizeFile+" (-e 2: rules locked until reboot)")
+	}
+	if load {
+		res.Changes = append(res.Changes, "load rules with augenrules --load")
+	}
+	if len(res.Changes) == 0 || opts.DryRun {
+		return res, nil
+	}
+	if !opts.Yes {
+		return res, errors.New("changing audit rules requires --yes (or use --dry-run)")
+	}
+
+	tx, err := BeginTransaction(opts.RollbackDir, "auditd rules")
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	err = func() error {
+		if err := os.MkdirAll(auditRulesDir, 0o750); err != nil {
+			return err
+		}
+		if writeRules {
+			if err := tx.SnapshotFile(AuditdRulesFile); err != nil {
+				return err
+			}
+			if err := tx.Do("write "+AuditdRulesFile, func() error { return writeAuditRulesFile(AuditdRulesFile, content) }); err != nil {
+				return err
+			}
+		}
+		if removeLegacy {
+			if err := tx.SnapshotFile(auditdLegacyFile); err != nil {
+				return err
+			}
+			if err := tx.Do("remove "+auditdLegacyFile, func() error { return os.Remove(auditdLegacyFile) }); err != nil {
+				return err
+			}
+		}
+		if writeFinalize {
+			if err := tx.SnapshotFile(auditdFinalizeFile); err != nil {
+				return err
+			}
+			if err := tx.Do("write "+auditdFinalizeFile, func() error { return writeAuditRulesFile(auditdFinalizeFile, finalize) }); err != nil {
+				return err
+			}
+		}
+		if !load {
+			return nil
+		}
+		if err := tx.Do("augenrules --load", func() error { return loadAuditRules(ctx) }); err != nil {
+			return err
+		}
+		after, _, err := loadedAuditRules(ctx)
+		if err != nil {
+			return err
+		}
+		if res.Missing = missing(after); len(res.Missing) > 0 {
+			return fmt.Errorf("%d rules not loaded after augenrules --load, first: %s", len(res.Missing), res.Missing[0])
+		}
+		return nil
+	}()
+	return res, tx.Finish(ctx, err)
+}
+
+func writeAuditRulesFile(p string, content []byte) error {
+	if err := os.WriteFile(p, content, 0o640); err != nil {
+		return err
+	}
+	return os.Chmod(p, 0o640)
+}
+
+// auditdPrivilegedCheck fails when a SUID/SGID program has no execution
+// rule (-F path=<program> -F perm=x) in rules.d.
+func auditdPrivilegedCheck(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{}
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
+		f.Result = ResultSkip
+		f.Details = "not supported on this OS"
+		return f, nil
+	}
+	disk, _ := readAuditRulesDir(opts.root)
+	audited := map[string]bool{}
+	for _, l := range disk {
+		p, perm := l.rule.field("path"), l.rule.field("perm")
+		if l.rule.watch != "" {
+			p, perm = l.rule.watch, l.rule.perm
+		}
+		if p != "" && strings.Contains(perm, "x") {
+			audited[p] = true
+		}
+	}
+	var found []string
+	for _, p := range privilegedPrograms(ctx, opts.root) {
+		if !audited[p] {
+			found = append(found, p)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return f, err
+	}
+	return issueFinding(f, found, SeverityMedium, SeverityLow, "Add '-a always,exit -F path=<program> -F perm=x -F auid>=1000 -F auid!=unset -k privileged' to rules.d for each program, or run 'fortis harden auditd --apply --yes'"), nil
+}
+
+// auditdLoadedCheck fails when the rules.d rules and the loaded rules
+// differ.
+func auditdLoadedCheck(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{}
+	switch {
+	case opts.root.isImage():
+		f.Result, f.Details = ResultSkip, runtimeOnly
+		return f, nil
+	case runtime.GOOS != "linux":
+		f.Result, f.Details = ResultSkip, "not supported on this OS"
+		return f, nil
+	}
+	if _, err := exec.LookPath("auditctl"); err != nil {
+		f.Result, f.Details = ResultSkip, "auditctl not found"
+		return f, nil
+	}
+	loaded, enabled, err := loadedAuditRules(ctx)
+	if err != nil {
+		f.Result, f.Details = ResultSkip, err.Error()
+		return f, nil
+	}
+	disk, _ := readAuditRulesDir(opts.root)
+	notLoaded, loadedOnly := auditRulesDrift(disk, loaded)
+	var found []string
+	for _, r := range notLoaded {
+		found = append(found, "not loaded: "+r)
+	}
+	for _, r := range loadedOnly {
+		found = append(found, "not in rules.d: "+r)
+	}
+	rec := "Run 'augenrules --load' so the kernel has the rules.d rules, and persist rules added with auditctl in rules.d"
+	if enabled == 2 {
+		rec = "The loaded rules are immutable (-e 2); reboot to load the rules.d rules"
+	}
+	return issueFinding(f, found, SeverityMedium, SeverityLow, rec), nil
+}
@@ -0,0 +1,289 @@
+package hardening
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseAuditRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // canonical form, or the error after "!"
+	}{
+		{"-w /etc/passwd -p wa -k identity", "-w /etc/passwd -p wa -k identity"},
+		{"-w /etc/sudoers.d/ -p aw -k scope", "-w /etc/sudoers.d -p wa -k scope"},
+		{"-w /etc/shadow", "-w /etc/shadow -p rwxa"},
+		{"-a always,exit -F path=/etc/passwd -F perm=wa -F key=identity", "-w /etc/passwd -p wa -k identity"},
+		{"-a always,exit -F dir=/etc/ssh/ -F perm=wa -k ssh", "-w /etc/ssh -p wa -k ssh"},
+		{"-a exit,always -F arch=b64 -S settimeofday,adjtimex -k time", "-a always,exit -F arch=b64 -S adjtimex,settimeofday -k time"},
+		{"-a always,exit -F arch=b64 -S execve -F auid!=-1 -C uid!=euid", "-a always,exit -C euid!=uid -F arch=b64 -F auid!=unset -S execve"},
+		{"-a always,exit -F auid!=4294967295 -F path=/usr/bin/su -F perm=x -k priv", "-a always,exit -F auid!=unset -F path=/usr/bin/su -F perm=x -S all -k priv"},
+		{"-a never,task", "-a never,task"},
+		{"-e 2", ""},
+		{"-D", ""},
+		{"-b", "!-b needs a value"},
+		{"-w /etc/passwd -S open", "!-S and -F need -a, not -w"},
+		{"-a always,exit -p wa", "!-p needs -w; use -F perm= with -a"},
+		{"-w /etc/passwd -a always,exit", "!-w and -a cannot be combined"},
+		{"-e 2 -w /etc/passwd", "!control options cannot be combined with a rule"},
+		{"-w /etc/passwd -p z", "!invalid permission \"z\""},
+		{"-a always", "!-a always: want action,list"},
+		{"-a sometimes,exit", "!-a sometimes,exit: want action,list"},
+		{"-a always,exit -F auid", "!-F auid: want field, operator and value"},
+		{"-a always,exit -C uid<euid", "!-C uid<euid: want field=field or field!=field"},
+		{"-k identity", "!no -w or -a"},
+		{"-x /etc/passwd", "!unknown option -x"},
+	}
+	for _, tt := range tests {
+		r, err := parseAuditRule(tt.line)
+		got := ""
+		switch {
+		case err != nil:
+			got = "!" + err.Error()
+		case !r.control:
+			got = r.canonical()
+		}
+		if got != tt.want {
+			t.Errorf("parseAuditRule(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseAuditdRuleSet(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"valid", "groups:\n  - name: g\n    rules: ['-w /etc/passwd -p wa']\n    suid: -a always,exit -F path=${path} -F perm=x\n", ""},
+		{"no group name", "groups:\n  - rules: ['-w /etc/passwd -p wa']\n", "group 1: name is required"},
+		{"unknown placeholder", "groups:\n  - name: g\n    rules: ['-w ${home} -p wa']\n", "unknown placeholder"},
+		{"bad rule", "groups:\n  - name: g\n    rules: ['-w /etc/passwd -p q']\n", "invalid permission"},
+		{"bad suid rule", "groups:\n  - name: g\n    suid: -a always,exit -F path=${path} -p x\n", "-p needs -w"},
+		{"control option", "groups:\n  - name: g\n    rules: ['-e 2']\n", "control options are not rules"},
+	}
+	for _, tt := range tests {
+		s, err := parseAuditdRuleSet("/etc/fortis/auditd/site.yaml", []byte(tt.yaml))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || s.Name != "site" {
+			t.Errorf("%s: name %q, err %v", tt.name, s.Name, err)
+		}
+	}
+
+	set, err := LoadAuditdRuleSet(t.TempDir(), "CIS")
+	if err != nil || set.Name != "cis" || set.Source != "builtin:cis.yaml" || len(set.Groups) == 0 {
+		t.Fatalf("built-in cis: %s from %s with %d groups, %v", set.Name, set.Source, len(set.Groups), err)
+	}
+	if _, err := LoadAuditdRuleSet("", "../cis"); err == nil {
+		t.Error("a rule set name with a path was accepted")
+	}
+	if _, err := LoadAuditdRuleSet(t.TempDir(), "nope"); err == nil || !strings.Contains(err.Error(), "built-in: cis") {
+		t.Errorf("unknown rule set: %v", err)
+	}
+}
+
+// auditdTestRoot is an image root with login.defs, an identity file, a
+// SUID and a SGID program and a program with neither.
+func auditdTestRoot(extra map[string]string) *auditRoot {
+	root := mapRoot(map[string]string{
+		"/etc/login.defs": "UID_MIN 500\n",
+		"/etc/passwd":     "root:x:0:0::/root:/bin/sh\n",
+	})
+	fsys := root.fsys.(fstest.MapFS)
+	fsys["usr/bin/passwd"] = &fstest.MapFile{Mode: fs.ModeSetuid | 0o755}
+	fsys["usr/bin/wall"] = &fstest.MapFile{Mode: fs.ModeSetgid | 0o755}
+	fsys["usr/bin/ls"] = &fstest.MapFile{Mode: 0o755}
+	fsys["usr/share/doc/setuid-data"] = &fstest.MapFile{Mode: fs.ModeSetuid | 0o644}
+	fsys["proc/1/exe"] = &fstest.MapFile{Mode: fs.ModeSetuid | 0o755}
+	for name, data := range extra {
+		fsys[strings.TrimPrefix(name, "/")] = &fstest.MapFile{Data: []byte(data), Mode: 0o640}
+	}
+	return root
+}
+
+func TestGenerateAuditdRules(t *testing.T) {
+	arches := auditArches[runtime.GOARCH]
+	if len(arches) == 0 {
+		t.Skipf("no audit ABI for %s", runtime.GOARCH)
+	}
+	set, err := parseAuditdRuleSet("test.yaml", []byte(`name: test
+groups:
+  - name: identity
+    benchmark: CIS 4.1.3.8
+    rules:
+      - -w /etc/passwd -p wa -k identity
+      - -a always,exit -F path=/etc/passwd -F perm=wa -k identity
+      - -w /etc/sudoers.d/site -p wa -k scope
+  - name: locale
+    rules:
+      - -a always,exit -F arch=${arch} -S sethostname -F auid>=${uid_min} -k locale
+      - -a always,exit -F path=/usr/bin/missing -F perm=x -F auid>=${uid_min} -k locale
+      - -a always,exit -F path=/usr/sbin/missing -F perm=x -k locale
+  - name: privileged
+    suid: -a always,exit -F path=${path} -F perm=x -F auid>=${uid_min} -F auid!=unset -k privileged
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := generateAuditdRules(context.Background(), set, auditdTestRoot(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"identity: -w /etc/passwd -p wa -k identity"}
+	for _, a := range arches {
+		want = append(want, "locale: -a always,exit -F arch="+a[0]+" -S sethostname -F auid>=500 -k locale")
+	}
+	want = append(want,
+		"privileged: -a always,exit -F path=/usr/bin/passwd -F perm=x -F auid>=500 -F auid!=unset -k privileged",
+		"privileged: -a always,exit -F path=/usr/bin/wall -F perm=x -F auid>=500 -F auid!=unset -k privileged",
+	)
+	var got []string
+	for _, r := range gen.rules {
+		got = append(got, r.Group+": "+r.Rule)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if gen.rules[0].Benchmark != "CIS 4.1.3.8" {
+		t.Errorf("benchmark %q, want CIS 4.1.3.8", gen.rules[0].Benchmark)
+	}
+	wantSkipped := []string{
+		"-w /etc/sudoers.d/site -p wa -k scope: no directory /etc/sudoers.d",
+		"-a always,exit -F path=/usr/bin/missing -F perm=x -F auid>=500 -k locale: /usr/bin/missing is not installed",
+		// A path rule that only watches is a watch, checked like -w.
+		"-a always,exit -F path=/usr/sbin/missing -F perm=x -k locale: no directory /usr/sbin",
+	}
+	if !reflect.DeepEqual(gen.skipped, wantSkipped) {
+		t.Errorf("skipped:\n%s\nwant:\n%s", strings.Join(gen.skipped, "\n"), strings.Join(wantSkipped, "\n"))
+	}
+	if len(gen.notes) != 1 || !strings.Contains(gen.notes[0], "architecture of this host") {
+		t.Errorf("notes = %q", gen.notes)
+	}
+
+	rendered := string(renderAuditdRules(set, gen.rules))
+	for _, s := range []string{
+		"## Generated by fortis harden auditd from rule set test; local changes are overwritten.\n",
+		"\n## identity (CIS 4.1.3.8)\n-w /etc/passwd -p wa -k identity\n\n## locale\n",
+		"\n## privileged\n-a always,exit -F path=/usr/bin/passwd ",
+	} {
+		if !strings.Contains(rendered, s) {
+			t.Errorf("rendered rules lack %q:\n%s", s, rendered)
+		}
+	}
+}
+
+func TestAuditAuditdDiff(t *testing.T) {
+	set := `name: site
+groups:
+  - name: identity
+    rules:
+      - -w /etc/passwd -p wa -k identity
+      - -w /etc/group -p wa -k identity
+      - -w /etc/shadow -p wa -k identity
+`
+	configDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(configDir, "auditd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "auditd", "site.yaml"), []byte(set), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := auditdTestRoot(map[string]string{
+		"/etc/group": "root:x:0:\n",
+		"/etc/audit/rules.d/10-base.rules": "# base\n-D\n-b 8192\n\n" +
+			"-a always,exit -F path=/etc/passwd -F perm=wa -F key=identity\n" +
+			"-w /etc/passwd -p wa -k identity\n" +
+			"-w /etc/hosts -p x -S open\n",
+		"/etc/audit/rules.d/50-fortis.rules": "-w /etc/group -p aw -k identity\n-w /etc/hosts -p wa -k net\n",
+		"/etc/audit/rules.d/notes.txt":       "-w /etc/ignored\n",
+	})
+
+	disk, notes := readAuditRulesDir(root)
+	var lines []string
+	for _, l := range disk {
+		c := l.rule.canonical()
+		if l.rule.control {
+			c = "(control)"
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", l.file, l.line, c))
+	}
+	wantLines := []string{
+		"/etc/audit/rules.d/10-base.rules:2: (control)",
+		"/etc/audit/rules.d/10-base.rules:3: (control)",
+		"/etc/audit/rules.d/10-base.rules:5: -w /etc/passwd -p wa -k identity",
+		"/etc/audit/rules.d/10-base.rules:6: -w /etc/passwd -p wa -k identity",
+		"/etc/audit/rules.d/50-fortis.rules:1: -w /etc/group -p wa -k identity",
+		"/etc/audit/rules.d/50-fortis.rules:2: -w /etc/hosts -p wa -k net",
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("rules.d:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(wantLines, "\n"))
+	}
+	if len(notes) != 1 || notes[0] != "/etc/audit/rules.d/10-base.rules:7: -S and -F need -a, not -w" {
+		t.Errorf("notes = %q", notes)
+	}
+
+	rep, err := AuditAuditd(context.Background(), AuditdAuditOptions{RuleSet: "site", ConfigDir: configDir, root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, r := range rep.Rules {
+		files[r.Rule] = r.File
+		if r.Loaded != nil {
+			t.Errorf("%s: loaded rules were compared for an image", r.Rule)
+		}
+	}
+	wantFiles := map[string]string{
+		"-w /etc/passwd -p wa -k identity": "/etc/audit/rules.d/10-base.rules",
+		"-w /etc/group -p wa -k identity":  "/etc/audit/rules.d/50-fortis.rules",
+		"-w /etc/shadow -p wa -k identity": "",
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("files = %v, want %v", files, wantFiles)
+	}
+	if want := []string{"-w /etc/shadow -p wa -k identity"}; !reflect.DeepEqual(rep.NotPersisted, want) {
+		t.Errorf("not persisted = %q, want %q", rep.NotPersisted, want)
+	}
+	if rep.LoadedRead || len(rep.Missing) != 0 {
+		t.Errorf("loaded read %t, missing %q", rep.LoadedRead, rep.Missing)
+	}
+
+	// auditctl -l prints exit rules with -F key= and ids as "unset".
+	var loaded []auditRule
+	for _, ln := range []string{
+		"-w /etc/passwd -p wa -k identity",
+		"-a always,exit -F arch=b64 -S sethostname -F auid!=unset -F key=locale",
+		"-e 1",
+	} {
+		r, err := parseAuditRule(ln)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded = append(loaded, r)
+	}
+	notLoaded, loadedOnly := auditRulesDrift(disk, loaded)
+	wantNotLoaded := []string{
+		"/etc/audit/rules.d/50-fortis.rules:1: -w /etc/group -p aw -k identity",
+		"/etc/audit/rules.d/50-fortis.rules:2: -w /etc/hosts -p wa -k net",
+	}
+	if !reflect.DeepEqual(notLoaded, wantNotLoaded) {
+		t.Errorf("not loaded:\n%s\nwant:\n%s", strings.Join(notLoaded, "\n"), strings.Join(wantNotLoaded, "\n"))
+	}
+	if want := []string{"-a always,exit -F arch=b64 -S sethostname -F auid!=unset -F key=locale"}; !reflect.DeepEqual(loadedOnly, want) {
+		t.Errorf("loaded only = %q, want %q", loadedOnly, want)
+	}
+}
@@ -0,0 +1,376 @@
+package hardening
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBaselinePath is where "harden baseline save" writes by default.
+const DefaultBaselinePath = "/var/lib/fortis/baseline.json"
+
+// Baseline is the hardened state of a host: audit findings plus the
+// configuration values the findings depend on.
+type Baseline struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	Hostname  string            `json:"hostname"`
+	Profile   string            `json:"profile"`
+	Level     string            `json:"level"`
+	Score     int               `json:"score"`
+	Findings  []BaselineFinding `json:"findings"`
+	SSHD      map[string]string `json:"sshd,omitempty"`
+	Sysctls   map[string]string `json:"sysctls,omitempty"`
+	Firewall  FirewallState     `json:"firewall"`
+	SUIDFiles []string          `json:"suid_files"`
+	SGIDFiles []string          `json:"sgid_files"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+type BaselineFinding struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Result  Result `json:"result"`
+	Details string `json:"details,omitempty"`
+}
+
+// FirewallState is the live ruleset with packet counters stripped.
+type FirewallState struct {
+	Backend string   `json:"backend"`
+	Rules   []string `json:"rules"`
+}
+
+type BaselineOptions struct {
+	Profile   string
+	Level     string
+	ConfigDir string
+	// FSRoot is scanned for SUID/SGID files; empty means "/".
+	FSRoot string
+	// HostGroups select group-scoped waivers (see AuditOptions).
+	HostGroups []string
+}
+
+const baselineVersion = 1
+
+// CaptureBaseline runs the audit and records the current configuration
+// state. Sources that cannot be read are noted in Errors rather than
+// failing the capture.
+func CaptureBaseline(ctx context.Context, opts BaselineOptions) (Baseline, error) {
+	reg, err := LoadRegistry(opts.ConfigDir)
+	if err != nil {
+		return Baseline{}, err
+	}
+	rep, err := RunAudit(ctx, AuditOptions{Profile: opts.Profile, Level: opts.Level, ConfigDir: opts.ConfigDir, Registry: reg, HostGroups: opts.HostGroups})
+	if err != nil {
+		return Baseline{}, err
+	}
+	b := Baseline{
+		Version:   baselineVersion,
+		CreatedAt: time.Now(),
+		Hostname:  rep.Hostname,
+		Profile:   rep.Profile,
+		Level:     rep.Level,
+		Score:     rep.Score,
+		Sysctls:   map[string]string{},
+		Errors:    map[string]string{},
+	}
+	for _, f := range rep.Findings {
+		b.Findings = append(b.Findings, BaselineFinding{ID: f.ID, Title: f.Title, Result: f.Result, Details: f.Details})
+	}
+
+	if _, err := os.Stat(SSHDConfigPath); err == nil {
+		cfg, err := ParseSSHDConfig(SSHDConfigPath)
+		if err != nil {
+			b.Errors["sshd"] = err.Error()
+		} else {
+			b.SSHD = map[string]string{}
+			for _, s := range cfg.Effective() {
+				b.SSHD[s.Key] = s.Value
+			}
+		}
+	}
+
+	for _, k := range trackedSysctls(reg, opts.ConfigDir) {
+		if v, err := readSysctl(k); err == nil {
+			b.Sysctls[k] = v
+		}
+	}
+
+	fw, err := captureFirewall(ctx)
+	if err != nil {
+		b.Errors["firewall"] = err.Error()
+	}
+	b.Firewall = fw
+
+	root := opts.FSRoot
+	if root == "" {
+		root = "/"
+	}
+	// Unlimited: a capped list would report every SUID file past the
+	// cap as new on each compare.
+	fs, err := ScanFilesystem(ctx, FilesystemOptions{Root: root, Limit: -1})
+	if err != nil {
+		b.Errors["filesystem"] = err.Error()
+	}
+	b.SUIDFiles, b.SGIDFiles = fs.SUIDFiles, fs.SGIDFiles
+	sort.Strings(b.SUIDFiles)
+	sort.Strings(b.SGIDFiles)
+	if len(b.Errors) == 0 {
+		b.Errors = nil
+	}
+	return b, nil
+}
+
+// trackedSysctls are the kernel parameters referenced by sysctl checks and
+// by the kernel profiles.
+func trackedSysctls(reg *Registry, configDir string) []string {
+	seen := map[string]bool{"net.ipv4.ip_forward": true}
+	for _, c := range reg.Checks() {
+		if c.spec != nil && strings.EqualFold(c.spec.Type, "sysctl") && c.spec.Key != "" {
+			seen[c.spec.Key] = true
+		}
+	}
+	// A broken site profile must not break baselines; its keys are left out.
+	names, _ := KernelProfileNames(configDir)
+	for _, n := range names {
+		p, err := LoadKernelProfile(configDir, n)
+		if err != nil {
+			continue
+		}
+		for _, kp := range p.Params {
+			seen[kp.Key] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+var firewallCounters = regexp.MustCompile(`\s*(\[\d+:\d+\]|counter packets \d+ bytes \d+)`)
+
+func captureFirewall(ctx context.Context) (FirewallState, error) {
+	st := FirewallState{Backend: detectFirewallBackend()}
+	var name string
+	var args []string
+	switch st.Backend {
+	case "ufw":
+		name, args = "ufw", []string{"status", "verbose"}
+	case "nftables":
+		name, args = "nft", []string{"list", "ruleset"}
+	case "iptables":
+		name, args = "iptables-save", nil
+	default:
+		return st, nil
+	}
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return st, fmt.Errorf("%s: %w", name, err)
+	}
+	for _, ln := range strings.Split(string(out), "\n") {
+		ln = strings.TrimSpace(firewallCounters.ReplaceAllString(ln, ""))
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		st.Rules = append(st.Rules, ln)
+	}
+	return st, nil
+}
+
+func SaveBaseline(path string, b Baseline) error {
+	if path == "" {
+		path = DefaultBaselinePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func LoadBaseline(path string) (Baseline, error) {
+	if path == "" {
+		path = DefaultBaselinePath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Baseline{}, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Baseline{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if b.Version != baselineVersion {
+		return Baseline{}, fmt.Errorf("%s: unsupported baseline version %d", path, b.Version)
+	}
+	return b, nil
+}
+
+// Drift change kinds. Fixed findings are reported but are not drift.
+const (
+	DriftNewFailure = "new-failure"
+	DriftFixed      = "fixed"
+	DriftChanged    = "changed"
+	DriftAdded      = "added"
+	DriftRemoved    = "removed"
+)
+
+type DriftItem struct {
+	Category string `json:"category"`
+	Key      string `json:"key"`
+	Change   string `json:"change"`
+	Baseline string `json:"baseline,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+type DriftReport struct {
+	Hostname     string      `json:"hostname"`
+	BaselineTime time.Time   `json:"baseline_time"`
+	CheckedAt    time.Time   `json:"checked_at"`
+	Profile      string      `json:"profile"`
+	Level        string      `json:"level"`
+	BaseScore    int         `json:"baseline_score"`
+	Score        int         `json:"score"`
+	Drifted      bool        `json:"drifted"`
+	NewFailures  int         `json:"new_failures"`
+	Fixed        int         `json:"fixed"`
+	Changed      int         `json:"changed"`
+	Items        []DriftItem `json:"items"`
+}
+
+// DetectDrift captures the current state with the baseline's profile and
+// level and compares the two.
+func DetectDrift(ctx context.Context, base Baseline, opts BaselineOptions) (DriftReport, error) {
+	opts.Profile, opts.Level = base.Profile, base.Level
+	cur, err := CaptureBaseline(ctx, opts)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	return CompareBaselines(base, cur), nil
+}
+
+// CompareBaselines reports what changed from base to cur.
+func CompareBaselines(base, cur Baseline) DriftReport {
+	rep := DriftReport{
+		Hostname:     cur.Hostname,
+		BaselineTime: base.CreatedAt,
+		CheckedAt:    cur.CreatedAt,
+		Profile:      base.Profile,
+		Level:        base.Level,
+		BaseScore:    base.Score,
+		Score:        cur.Score,
+		Items:        []DriftItem{},
+	}
+	add := func(it DriftItem) {
+		switch it.Change {
+		case DriftNewFailure:
+			rep.NewFailures++
+		case DriftFixed:
+			rep.Fixed++
+		default:
+			rep.Changed++
+		}
+		rep.Items = append(rep.Items, it)
+	}
+
+	was := map[string]BaselineFinding{}
+	for _, f := range base.Findings {
+		was[f.ID] = f
+	}
+	for _, f := range cur.Findings {
+		old, ok := was[f.ID]
+		bad := f.Result == ResultFail || f.Result == ResultWarn
+		switch {
+		case !ok && bad:
+			add(DriftItem{Category: "finding", Key: f.ID, Change: DriftNewFailure, Current: resultDetail(f)})
+		case ok && (old.Result == ResultPass || old.Result == ResultWaived) && bad:
+			// An expired waiver re-surfaces as a new failure.
+			add(DriftItem{Category: "finding", Key: f.ID, Change: DriftNewFailure, Baseline: resultDetail(old), Current: resultDetail(f)})
+		case ok && (old.Result == ResultFail || old.Result == ResultWarn) && f.Result == ResultPass:
+			add(DriftItem{Category: "finding", Key: f.ID, Change: DriftFixed, Baseline: resultDetail(old), Current: resultDetail(f)})
+		}
+	}
+
+	diffMaps("sshd", base.SSHD, cur.SSHD, add)
+	diffMaps("sysctl", base.Sysctls, cur.Sysctls, add)
+	if base.Firewall.Backend != cur.Firewall.Backend {
+		add(DriftItem{Category: "firewall", Key: "backend", Change: DriftChanged, Baseline: base.Firewall.Backend, Current: cur.Firewall.Backend})
+	}
+	diffLists("firewall", base.Firewall.Rules, cur.Firewall.Rules, add)
+	diffLists("suid", base.SUIDFiles, cur.SUIDFiles, add)
+	diffLists("sgid", base.SGIDFiles, cur.SGIDFiles, add)
+
+	rep.Drifted = rep.NewFailures > 0 || rep.Changed > 0
+	return rep
+}
+
+func resultDetail(f BaselineFinding) string {
+	if f.Details == "" {
+		return string(f.Result)
+	}
+	return fmt.Sprintf("%s: %s", f.Result, f.Details)
+}
+
+func diffMaps(category string, base, cur map[string]string, add func(DriftItem)) {
+	keys := map[string]bool{}
+	for k := range base {
+		keys[k] = true
+	}
+	for k := range cur {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		b, inBase := base[k]
+		c, inCur := cur[k]
+		switch {
+		case inBase && !inCur:
+			add(DriftItem{Category: category, Key: k, Change: DriftRemoved, Baseline: b})
+		case !inBase && inCur:
+			add(DriftItem{Category: category, Key: k, Change: DriftAdded, Current: c})
+		case b != c:
+			add(DriftItem{Category: category, Key: k, Change: DriftChanged, Baseline: b, Current: c})
+		}
+	}
+}
+
+func diffLists(category string, base, cur []string, add func(DriftItem)) {
+	inBase := map[string]bool{}
+	for _, v := range base {
+		inBase[v] = true
+	}
+	inCur := map[string]bool{}
+	for _, v := range cur {
+		inCur[v] = true
+	}
+	for _, v := range base {
+		if !inCur[v] {
+			add(DriftItem{Category: category, Key: v, Change: DriftRemoved})
+		}
+	}
+	for _, v := range cur {
+		if !inBase[v] {
+			add(DriftItem{Category: category, Key: v, Change: DriftAdded})
+		}
+	}
+}
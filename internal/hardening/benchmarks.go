@@ -0,0 +1,32 @@
+package hardening
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Benchmark content shipped with the binary. Each file is a regular YAML
+// check file; checks carry a benchmark ID and a profile level.
+//
+//go:embed benchmarks/*.yaml
+var benchmarkFS embed.FS
+
+func loadBenchmarks(r *Registry) error {
+	names, err := fs.Glob(benchmarkFS, "benchmarks/*.yaml")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		b, err := benchmarkFS.ReadFile(n)
+		if err != nil {
+			return err
+		}
+		if err := r.loadBytes("benchmark:"+path.Base(n), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
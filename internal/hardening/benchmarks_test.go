@@ -0,0 +1,54 @@
+package hardening
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCISFileContentChecks(t *testing.T) {
+	reg := NewRegistry()
+	if err := loadBenchmarks(reg); err != nil {
+		t.Fatal(err)
+	}
+	const rules = "/etc/audit/rules.d/50-cis.rules"
+	tests := []struct {
+		check string
+		files map[string]string
+		want  Result
+	}{
+		{"auditd.rules_identity", map[string]string{rules: "-w /etc/passwd -p wa -k identity\n"}, ResultPass},
+		{"auditd.rules_identity", map[string]string{rules: "  -w /etc/gshadow\n"}, ResultPass},
+		{"auditd.rules_identity", map[string]string{rules: "# -w /etc/passwd -p wa -k identity\n"}, ResultFail},
+		{"auditd.rules_identity", map[string]string{rules: "-w /etc/passwd.bak -p wa\n"}, ResultFail},
+		{"auditd.rules_sudoers", map[string]string{rules: "-w /etc/sudoers -p wa -k scope\n"}, ResultPass},
+		{"auditd.rules_sudoers", map[string]string{rules: "#-w /etc/sudoers -p wa -k scope\n"}, ResultFail},
+		{"auditd.rules_time_change", map[string]string{rules: "-a always,exit -F arch=b64 -S adjtimex,settimeofday -k time-change\n"}, ResultPass},
+		{"auditd.rules_time_change", map[string]string{rules: "# -a always,exit -F arch=b64 -S adjtimex -k time-change\n"}, ResultFail},
+		{"auditd.rules_network_env", map[string]string{rules: "-a always,exit -F arch=b64 -S sethostname -S setdomainname -k system-locale\n"}, ResultPass},
+		{"auditd.rules_network_env", map[string]string{rules: "-w /etc/hosts -p wa -k system-locale\n"}, ResultPass},
+		{"auditd.rules_network_env", map[string]string{rules: "-w /etc/hosts.allow -p wa\n"}, ResultFail},
+		{"auditd.rules_modules", map[string]string{rules: "-a always,exit -F arch=b64 -S init_module,finit_module,delete_module -k modules\n"}, ResultPass},
+		{"auditd.rules_modules", map[string]string{rules: "-a always,exit -F path=/usr/bin/kmod -F perm=x -k kernel_modules\n"}, ResultPass},
+		{"auditd.rules_modules", map[string]string{rules: "## -w /sbin/insmod -p x\n"}, ResultFail},
+		{"integrity.aide_scheduled", map[string]string{"/etc/crontab": "0 5 * * * root /usr/bin/aide.wrapper --config /etc/aide/aide.conf --check\n"}, ResultPass},
+		{"integrity.aide_scheduled", map[string]string{"/etc/cron.d/aide": "0 5 * * * root /usr/sbin/aide -C\n"}, ResultPass},
+		{"integrity.aide_scheduled", map[string]string{"/etc/systemd/system/aidecheck.timer": "[Timer]\nOnCalendar=daily\nUnit=aidecheck.service\n"}, ResultPass},
+		{"integrity.aide_scheduled", map[string]string{"/etc/crontab": "# 0 5 * * * root /usr/sbin/aide --check\n"}, ResultFail},
+		{"integrity.aide_scheduled", map[string]string{"/etc/cron.d/aide": "MAILTO=aide-reports@example.com\n0 5 * * * root /usr/sbin/aide --init\n"}, ResultFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.check, func(t *testing.T) {
+			c, ok := reg.Get(tt.check)
+			if !ok {
+				t.Fatalf("check %s is not in the benchmark", tt.check)
+			}
+			f, err := c.Run(context.Background(), AuditOptions{root: mapRoot(tt.files)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Result != tt.want {
+				t.Errorf("%v: result = %s, want %s (%s)", tt.files, f.Result, tt.want, f.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package hardening
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// scanSkipDirs are never descended into by the filesystem-wide checks.
+var scanSkipDirs = map[string]bool{"/proc": true, "/sys": true, "/dev": true, "/run": true}
+
+// remoteFSTypes are skipped like the CIS audit commands do with "df --local":
+// network filesystems are owned by another host and pseudo filesystems hold
+// no real files.
+var remoteFSTypes = map[string]bool{
+	"nfs": true, "nfs4": true, "cifs": true, "smbfs": true, "sshfs": true,
+	"fuse.sshfs": true, "ceph": true, "glusterfs": true, "9p": true,
+	"proc": true, "sysfs": true, "devtmpfs": true,
+}
+
+const maxListedPaths = 10
+
+// walkLocalFiles calls fn for every file and directory on local filesystems
+// of root. Unreadable entries are ignored. An image is walked whole; it has
+// no mounts of its own.
+func walkLocalFiles(ctx context.Context, root *auditRoot, fn func(path string, info fs.FileInfo)) error {
+	skip := map[string]bool{}
+	for k := range scanSkipDirs {
+		skip[k] = true
+	}
+	if f, err := os.Open("/proc/mounts"); err == nil && !root.isImage() {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 3 && remoteFSTypes[fields[2]] && fields[1] != "/" {
+				skip[fields[1]] = true
+			}
+		}
+		f.Close()
+	}
+	return root.WalkDir("/", func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && skip[path] {
+			return fs.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fn(path, info)
+		return nil
+	})
+}
+
+func checkWorldWritableFiles(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "files.world_writable", Title: "Ensure no world writable files exist", Weight: 10}
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
+		f.Result = ResultSkip
+		f.Details = "not supported on this OS"
+		return f, nil
+	}
+	var found []string
+	err := walkLocalFiles(ctx, opts.root, func(path string, info fs.FileInfo) {
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o002 == 0 {
+			return
+		}
+		found = append(found, path)
+	})
+	if err != nil {
+		return f, err
+	}
+	if len(found) == 0 {
+		f.Result = ResultPass
+		return f, nil
+	}
+	f.Result = ResultFail
+	f.Details = summarizePaths(found)
+	f.Recommendation = "Remove write access for other (chmod o-w) or move the files to a restricted location"
+	return f, nil
+}
+
+func checkUnownedFiles(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "files.unowned", Title: "Ensure no unowned or ungrouped files or directories exist", Weight: 5}
+	if runtime.GOOS != "linux" && !opts.root.isImage() {
+		f.Result = ResultSkip
+		f.Details = "not supported on this OS"
+		return f, nil
+	}
+	users := map[uint32]bool{}
+	groups := map[uint32]bool{}
+	known := func(cache map[uint32]bool, id uint32, lookup func(uint32) bool) bool {
+		if ok, seen := cache[id]; seen {
+			return ok
+		}
+		ok := lookup(id)
+		cache[id] = ok
+		return ok
+	}
+
+	var found []string
+	err := walkLocalFiles(ctx, opts.root, func(path string, info fs.FileInfo) {
+		uid, gid, ok := statOwner(info)
+		if !ok {
+			return
+		}
+		if !known(users, uid, opts.root.knownUser) || !known(groups, gid, opts.root.knownGroup) {
+			found = append(found, fmt.Sprintf("%s (%d:%d)", path, uid, gid))
+		}
+	})
+	if err != nil {
+		return f, err
+	}
+	if len(found) == 0 {
+		f.Result = ResultPass
+		return f, nil
+	}
+	f.Result = ResultFail
+	f.Details = summarizePaths(found)
+	f.Recommendation = "Assign the files to an existing user and group (chown) or remove them"
+	return f, nil
+}
+
+func summarizePaths(paths []string) string {
+	if len(paths) <= maxListedPaths {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:maxListedPaths], ", "), len(paths)-maxListedPaths)
+}
@@ -0,0 +1,897 @@
+package hardening
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CheckSpec is the YAML form of a check. Type selects the evaluator; the
+// remaining fields are read depending on the type:
+//
+//	file_content     path|paths (globs), pattern, expect (match|no_match)
+//	sysctl           key, value, op
+//	file_permission  path|paths, max_mode, owner, group
+//	config_value     path|paths, key, value, op, default, match (first|last)
+//	sshd_option      key, value, op, default (path defaults to sshd_config)
+//	pam_setting      key, value, op (effective PAM password/lockout setting:
+//	                 minlen, classes, quality_module, deny, remember, ...)
+//	mount_option     mount, options
+//	kernel_module    module (passes when the module is disabled)
+//	command          command, pattern, expect
+//	service          service, state (active|inactive|enabled|disabled)
+//	package          package, installed
+//
+// Value operators are eq, ne, ge, le, gt, lt, between ("1-300"), in (value is
+// a comma list of allowed values) and not_contains (value is a comma list of
+// forbidden items in a comma-separated setting).
+type CheckSpec struct {
+	ID             string   `yaml:"id"`
+	Title          string   `yaml:"title"`
+	Weight         int      `yaml:"weight"`
+	Severity       Severity `yaml:"severity"`
+	Tags           []string `yaml:"tags"`
+	References     []string `yaml:"references"`
+	Recommendation string   `yaml:"recommendation"`
+	Benchmark      string   `yaml:"benchmark"`
+	Level          int      `yaml:"level"`
+	Type           string   `yaml:"type"`
+
+	Path    string   `yaml:"path"`
+	Paths   []string `yaml:"paths"`
+	Pattern string   `yaml:"pattern"`
+	Expect  string   `yaml:"expect"`
+
+	Default string `yaml:"default"`
+	Match   string `yaml:"match"`
+
+	Mount   string   `yaml:"mount"`
+	Options []string `yaml:"options"`
+	Module  string   `yaml:"module"`
+
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+	Op    string `yaml:"op"`
+
+	MaxMode string `yaml:"max_mode"`
+	Owner   string `yaml:"owner"`
+	Group   string `yaml:"group"`
+
+	Command string `yaml:"command"`
+
+	Service string `yaml:"service"`
+	State   string `yaml:"state"`
+
+	Package   string `yaml:"package"`
+	Installed *bool  `yaml:"installed"`
+}
+
+type checkFile struct {
+	Checks []CheckSpec `yaml:"checks"`
+}
+
+func (r *Registry) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.loadBytes(path, b)
+}
+
+// loadBytes registers the checks in a YAML document; source is recorded on
+// each check and used in error messages.
+func (r *Registry) loadBytes(source string, b []byte) error {
+	var cf checkFile
+	if err := yaml.Unmarshal(b, &cf); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	for _, spec := range cf.Checks {
+		c, err := spec.Compile()
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		c.Source = source
+		if err := r.Register(c); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+func (s CheckSpec) Compile() (Check, error) {
+	if strings.TrimSpace(s.ID) == "" {
+		return Check{}, fmt.Errorf("check without id (title %q)", s.Title)
+	}
+	c := Check{ID: s.ID, Title: s.Title, Weight: s.Weight, Severity: s.Severity, Tags: s.Tags, References: s.References, Benchmark: s.Benchmark, Level: s.Level}
+	spec := s
+	c.spec = &spec
+	if c.Title == "" {
+		c.Title = s.ID
+	}
+
+	var eval func(ctx context.Context, root *auditRoot) (Result, string, error)
+	switch strings.ToLower(strings.TrimSpace(s.Type)) {
+	case "file_content":
+		paths := s.pathList()
+		if len(paths) == 0 || s.Pattern == "" {
+			return Check{}, fmt.Errorf("check %s: file_content requires path and pattern", s.ID)
+		}
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return Check{}, fmt.Errorf("check %s: %w", s.ID, err)
+		}
+		want := expectMatch(s.Expect)
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			files := expandPaths(root, paths)
+			if len(files) == 0 {
+				if want {
+					return ResultFail, "no file matches " + strings.Join(paths, ", "), nil
+				}
+				return ResultPass, "", nil
+			}
+			matched := false
+			for _, p := range files {
+				b, err := root.ReadFile(p)
+				if err != nil {
+					return ResultWarn, "", err
+				}
+				if re.Match(b) {
+					matched = true
+					break
+				}
+			}
+			return judgeMatch(matched, want, strings.Join(files, ", "))
+		}
+	case "sysctl":
+		if s.Key == "" || s.Value == "" {
+			return Check{}, fmt.Errorf("check %s: sysctl requires key and value", s.ID)
+		}
+		op := normalizeOp(s.Op)
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			if root.isImage() {
+				return evalPersistedSysctl(root, s.Key, op, s.Value)
+			}
+			got, err := readSysctl(s.Key)
+			if err != nil {
+				return ResultWarn, "", err
+			}
+			return judgeValue(s.Key, got, op, s.Value)
+		}
+	case "file_permission":
+		paths := s.pathList()
+		if len(paths) == 0 {
+			return Check{}, fmt.Errorf("check %s: file_permission requires path", s.ID)
+		}
+		var maxMode os.FileMode
+		if s.MaxMode != "" {
+			v, err := strconv.ParseUint(s.MaxMode, 8, 32)
+			if err != nil {
+				return Check{}, fmt.Errorf("check %s: invalid max_mode %q", s.ID, s.MaxMode)
+			}
+			maxMode = os.FileMode(v)
+		}
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			files := expandPaths(root, paths)
+			if len(files) == 0 {
+				return ResultSkip, strings.Join(paths, ", ") + " does not exist", nil
+			}
+			failed := []string{}
+			for _, p := range files {
+				res, details, err := evalFilePermission(root, p, s.MaxMode != "", maxMode, s.Owner, s.Group)
+				if err != nil {
+					return res, details, err
+				}
+				if res == ResultFail {
+					failed = append(failed, details)
+				}
+			}
+			if len(failed) > 0 {
+				return ResultFail, strings.Join(failed, "; "), nil
+			}
+			return ResultPass, "", nil
+		}
+	case "config_value", "sshd_option":
+		paths := s.pathList()
+		firstMatch := strings.EqualFold(s.Match, "first")
+		if s.Type == "sshd_option" {
+			if len(paths) == 0 {
+				paths = []string{SSHDConfigPath}
+			}
+			firstMatch = true
+		}
+		if len(paths) == 0 || s.Key == "" || s.Value == "" {
+			return Check{}, fmt.Errorf("check %s: %s requires path, key and value", s.ID, s.Type)
+		}
+		op := normalizeOp(s.Op)
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			files := expandPaths(root, paths)
+			if s.Type == "sshd_option" && !anyExists(root, files) {
+				// No sshd configuration: the SSH server is not installed.
+				return ResultSkip, strings.Join(paths, ", ") + " does not exist", nil
+			}
+			if s.Type == "sshd_option" {
+				got, origin, matches, found, err := sshdOptionValue(root, files[0], s.Key, s.Default)
+				if err != nil {
+					return ResultWarn, "", err
+				}
+				if !found {
+					return ResultFail, s.Key + " is not set", nil
+				}
+				res, details, err := judgeValue(s.Key, got, op, s.Value)
+				if err != nil || res != ResultPass {
+					return res, details + " [" + origin + "]", err
+				}
+				// A Match block that fails the check makes it fail for the
+				// connections it matches.
+				for _, m := range matches {
+					mres, mdetails, err := judgeValue(s.Key, m.Value, op, s.Value)
+					if err != nil || mres != ResultPass {
+						return mres, fmt.Sprintf("%s in Match %s [%s]", mdetails, m.Criteria, m.Origin), err
+					}
+				}
+				return res, details + " [" + origin + "]", nil
+			}
+			got, found, err := readConfigValue(root, files, s.Key, firstMatch)
+			if err != nil {
+				return ResultWarn, "", err
+			}
+			if !found {
+				if s.Default == "" {
+					return ResultFail, s.Key + " is not set", nil
+				}
+				got = s.Default
+			}
+			return judgeValue(s.Key, got, op, s.Value)
+		}
+	case "pam_setting":
+		if s.Key == "" || s.Value == "" {
+			return Check{}, fmt.Errorf("check %s: pam_setting requires key and value", s.ID)
+		}
+		op := normalizeOp(s.Op)
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			return evalPAMSetting(ctx, root, s.Key, op, s.Value)
+		}
+	case "mount_option":
+		if s.Mount == "" {
+			return Check{}, fmt.Errorf("check %s: mount_option requires mount", s.ID)
+		}
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			return evalMountOptions(root, s.Mount, s.Options)
+		}
+	case "kernel_module":
+		if s.Module == "" {
+			return Check{}, fmt.Errorf("check %s: kernel_module requires module", s.ID)
+		}
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			return evalKernelModuleDisabled(root, s.Module)
+		}
+	case "command":
+		if s.Command == "" || s.Pattern == "" {
+			return Check{}, fmt.Errorf("check %s: command requires command and pattern", s.ID)
+		}
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return Check{}, fmt.Errorf("check %s: %w", s.ID, err)
+		}
+		want := expectMatch(s.Expect)
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			if root.isImage() {
+				return ResultSkip, runtimeOnly, nil
+			}
+			out, _ := exec.CommandContext(ctx, "sh", "-c", s.Command).CombinedOutput()
+			return judgeMatch(re.Match(out), want, "command output")
+		}
+	case "service":
+		if s.Service == "" || s.State == "" {
+			return Check{}, fmt.Errorf("check %s: service requires service and state", s.ID)
+		}
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			return evalServiceState(ctx, root, s.Service, strings.ToLower(s.State))
+		}
+	case "package":
+		if s.Package == "" {
+			return Check{}, fmt.Errorf("check %s: package requires package", s.ID)
+		}
+		want := true
+		if s.Installed != nil {
+			want = *s.Installed
+		}
+		eval = func(ctx context.Context, root *auditRoot) (Result, string, error) {
+			installed, mgr, err := packageInstalled(ctx, root, s.Package)
+			if err != nil {
+				return ResultSkip, err.Error(), nil
+			}
+			details := fmt.Sprintf("%s installed=%v (%s)", s.Package, installed, mgr)
+			if installed == want {
+				return ResultPass, details, nil
+			}
+			return ResultFail, details, nil
+		}
+	default:
+		return Check{}, fmt.Errorf("check %s: unknown type %q", s.ID, s.Type)
+	}
+
+	c.Run = func(ctx context.Context, opts AuditOptions) (Finding, error) {
+		f := Finding{ID: c.ID, Title: c.Title, Weight: c.Weight}
+		res, details, err := eval(ctx, opts.root)
+		f.Result = res
+		f.Details = details
+		if res == ResultFail {
+			f.Recommendation = s.Recommendation
+		}
+		return f, err
+	}
+	return c, nil
+}
+
+func (s CheckSpec) pathList() []string {
+	out := []string{}
+	if s.Path != "" {
+		out = append(out, s.Path)
+	}
+	return append(out, s.Paths...)
+}
+
+// expandPaths resolves globs against root and drops paths that do not exist.
+func expandPaths(root *auditRoot, paths []string) []string {
+	out := []string{}
+	for _, p := range paths {
+		matches, err := root.Glob(p)
+		if err != nil {
+			continue
+		}
+		sort.Strings(matches)
+		out = append(out, matches...)
+	}
+	return out
+}
+
+func expectMatch(expect string) bool {
+	switch strings.ToLower(strings.TrimSpace(expect)) {
+	case "no_match", "absent", "false":
+		return false
+	default:
+		return true
+	}
+}
+
+func judgeMatch(matched, want bool, what string) (Result, string, error) {
+	if matched == want {
+		return ResultPass, "", nil
+	}
+	if want {
+		return ResultFail, "pattern not found in " + what, nil
+	}
+	return ResultFail, "forbidden pattern found in " + what, nil
+}
+
+func readSysctl(key string) (string, error) {
+	b, err := os.ReadFile("/proc/sys/" + strings.ReplaceAll(key, ".", "/"))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(string(b)), " "), nil
+}
+
+// sysctlConfigDirs are read in this order by systemd-sysctl; a file name in
+// an earlier directory hides the same name in a later one.
+var sysctlConfigDirs = []string{"/etc/sysctl.d", "/run/sysctl.d", "/usr/local/lib/sysctl.d", "/usr/lib/sysctl.d", "/lib/sysctl.d"}
+
+// SysctlDefinition is one assignment in a sysctl configuration file.
+type SysctlDefinition struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+}
+
+func (d SysctlDefinition) Origin() string { return fmt.Sprintf("%s:%d", d.File, d.Line) }
+
+// sysctlFiles returns root's sysctl configuration files in the order
+// systemd-sysctl applies them: sysctl.d files by name, then
+// /etc/sysctl.conf.
+func sysctlFiles(root *auditRoot) []string {
+	byName := map[string]string{}
+	for _, dir := range sysctlConfigDirs {
+		matches, _ := root.Glob(dir + "/*.conf")
+		for _, m := range matches {
+			if _, ok := byName[path.Base(m)]; !ok {
+				byName[path.Base(m)] = m
+			}
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	files := make([]string, 0, len(names)+1)
+	for _, n := range names {
+		files = append(files, byName[n])
+	}
+	return append(files, "/etc/sysctl.conf")
+}
+
+// sysctlDefinitions returns every assignment in root's sysctl configuration
+// in application order; for a key the last one wins at boot.
+func sysctlDefinitions(root *auditRoot) []SysctlDefinition {
+	out := []SysctlDefinition{}
+	for _, f := range sysctlFiles(root) {
+		b, err := root.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		for i, ln := range strings.Split(string(b), "\n") {
+			ln = strings.TrimSpace(ln)
+			if ln == "" || ln[0] == '#' || ln[0] == ';' {
+				continue
+			}
+			k, v, ok := strings.Cut(ln, "=")
+			if !ok {
+				continue
+			}
+			k = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(k), "-"), "/", ".")
+			out = append(out, SysctlDefinition{Key: k, Value: strings.Join(strings.Fields(v), " "), File: f, Line: i + 1})
+		}
+	}
+	return out
+}
+
+// persistedSysctl returns the value root's sysctl configuration sets for key
+// at boot.
+func persistedSysctl(root *auditRoot, key string) (value, origin string, found bool) {
+	for _, d := range sysctlDefinitions(root) {
+		if d.Key == key {
+			value, origin, found = d.Value, d.Origin(), true
+		}
+	}
+	return value, origin, found
+}
+
+// evalPersistedSysctl judges the value an image applies at boot. Keys it
+// does not set keep the kernel default, which cannot be known offline.
+func evalPersistedSysctl(root *auditRoot, key, op, want string) (Result, string, error) {
+	got, origin, found := persistedSysctl(root, key)
+	if !found {
+		return ResultSkip, key + " is not set in the image's sysctl configuration; " + runtimeOnly, nil
+	}
+	res, details, err := judgeValue(key, got, op, want)
+	return res, details + " [" + origin + "]", err
+}
+
+func normalizeOp(op string) string {
+	op = strings.ToLower(strings.TrimSpace(op))
+	if op == "" {
+		return "eq"
+	}
+	return op
+}
+
+func judgeValue(key, got, op, want string) (Result, string, error) {
+	ok, err := compareValue(got, op, want)
+	if err != nil {
+		return ResultWarn, "", err
+	}
+	details := fmt.Sprintf("%s = %s (want %s %s)", key, got, op, want)
+	if ok {
+		return ResultPass, details, nil
+	}
+	return ResultFail, details, nil
+}
+
+// compareValue compares configuration values. Ordering operators require
+// integers; eq/ne/in compare whitespace-normalized strings case-insensitively.
+func compareValue(got, op, want string) (bool, error) {
+	norm := func(v string) string { return strings.ToLower(strings.Join(strings.Fields(v), " ")) }
+	switch op {
+	case "eq":
+		return norm(got) == norm(want), nil
+	case "ne":
+		return norm(got) != norm(want), nil
+	case "in":
+		for _, w := range strings.Split(want, ",") {
+			if norm(got) == norm(w) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "not_contains":
+		have := map[string]bool{}
+		for _, g := range strings.Split(got, ",") {
+			have[norm(g)] = true
+		}
+		for _, w := range strings.Split(want, ",") {
+			if have[norm(w)] {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "between":
+		lo, hi, ok := strings.Cut(want, "-")
+		if !ok {
+			return false, fmt.Errorf("between expects a range like 1-300, got %q", want)
+		}
+		a, err := compareValue(got, "ge", lo)
+		if err != nil || !a {
+			return false, err
+		}
+		return compareValue(got, "le", hi)
+	}
+	g, err := parseIntValue(got)
+	if err != nil {
+		return false, err
+	}
+	w, err := parseIntValue(want)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "ge":
+		return g >= w, nil
+	case "le":
+		return g <= w, nil
+	case "gt":
+		return g > w, nil
+	case "lt":
+		return g < w, nil
+	default:
+		return false, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+// parseIntValue accepts plain integers and sshd-style durations (30s, 5m, 1h).
+func parseIntValue(v string) (int64, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "s"):
+		v = strings.TrimSuffix(v, "s")
+	case strings.HasSuffix(v, "m"):
+		v, mult = strings.TrimSuffix(v, "m"), 60
+	case strings.HasSuffix(v, "h"):
+		v, mult = strings.TrimSuffix(v, "h"), 3600
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value %q is not numeric", v)
+	}
+	return n * mult, nil
+}
+
+// readConfigValue looks up key in "key value" / "key=value" style files.
+// sshd uses the first occurrence; most other files use the last.
+func readConfigValue(root *auditRoot, files []string, key string, firstMatch bool) (string, bool, error) {
+	val, found := "", false
+	for _, p := range files {
+		b, err := root.ReadFile(p)
+		if err != nil {
+			return "", false, err
+		}
+		for _, ln := range strings.Split(string(b), "\n") {
+			ln = strings.TrimSpace(ln)
+			if ln == "" || strings.HasPrefix(ln, "#") {
+				continue
+			}
+			k, v := splitConfigLine(ln)
+			if !strings.EqualFold(k, key) {
+				continue
+			}
+			if firstMatch {
+				return v, true, nil
+			}
+			val, found = v, true
+		}
+	}
+	return val, found, nil
+}
+
+func splitConfigLine(ln string) (string, string) {
+	i := strings.IndexAny(ln, "= \t")
+	if i < 0 {
+		return ln, ""
+	}
+	k := strings.TrimSpace(ln[:i])
+	v := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(ln[i:]), "="))
+	return k, strings.TrimSpace(v)
+}
+
+// evalMountOptions reads the mount table of the running host. An image has
+// none, so the options its /etc/fstab would mount with are checked instead.
+func evalMountOptions(root *auditRoot, mount string, want []string) (Result, string, error) {
+	table := "/proc/mounts"
+	if root.isImage() {
+		table = "/etc/fstab"
+	}
+	b, err := root.ReadFile(table)
+	if err != nil {
+		if root.isImage() {
+			return ResultSkip, "no /etc/fstab in image; " + runtimeOnly, nil
+		}
+		return ResultSkip, "mount table not available", nil
+	}
+	var opts []string
+	found := false
+	for _, ln := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(ln)
+		if len(fields) >= 4 && fields[1] == mount && !strings.HasPrefix(fields[0], "#") {
+			opts = strings.Split(fields[3], ",")
+			found = true
+		}
+	}
+	if !found {
+		if root.isImage() {
+			return ResultFail, mount + " is not a separate mount in /etc/fstab", nil
+		}
+		return ResultFail, mount + " is not a separate mount", nil
+	}
+	have := map[string]bool{}
+	for _, o := range opts {
+		have[o] = true
+	}
+	missing := []string{}
+	for _, o := range want {
+		if !have[o] {
+			missing = append(missing, o)
+		}
+	}
+	if len(missing) > 0 {
+		return ResultFail, fmt.Sprintf("%s missing %s", mount, strings.Join(missing, ",")), nil
+	}
+	return ResultPass, "", nil
+}
+
+// evalKernelModuleDisabled passes when the module is not loaded and modprobe
+// is configured to refuse it (install /bin/false|/bin/true or blacklist). An
+// image has no loaded modules; only its modprobe configuration is checked.
+func evalKernelModuleDisabled(root *auditRoot, module string) (Result, string, error) {
+	norm := func(m string) string { return strings.ReplaceAll(m, "-", "_") }
+	if b, err := os.ReadFile("/proc/modules"); err == nil && !root.isImage() {
+		for _, ln := range strings.Split(string(b), "\n") {
+			if f := strings.Fields(ln); len(f) > 0 && norm(f[0]) == norm(module) {
+				return ResultFail, module + " is loaded", nil
+			}
+		}
+	}
+	installBlocked, blacklisted := false, false
+	for _, p := range expandPaths(root, []string{"/etc/modprobe.d/*.conf", "/lib/modprobe.d/*.conf", "/usr/lib/modprobe.d/*.conf"}) {
+		b, err := root.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		for _, ln := range strings.Split(string(b), "\n") {
+			f := strings.Fields(ln)
+			if len(f) < 2 || norm(f[1]) != norm(module) {
+				continue
+			}
+			switch f[0] {
+			case "install":
+				if len(f) >= 3 && (strings.HasSuffix(f[2], "/false") || strings.HasSuffix(f[2], "/true")) {
+					installBlocked = true
+				}
+			case "blacklist":
+				blacklisted = true
+			}
+		}
+	}
+	if installBlocked {
+		return ResultPass, "", nil
+	}
+	if blacklisted {
+		return ResultWarn, module + " is blacklisted but can still be loaded explicitly", nil
+	}
+	return ResultFail, module + " is not disabled in modprobe.d", nil
+}
+
+func evalFilePermission(root *auditRoot, path string, checkMode bool, maxMode os.FileMode, owner, group string) (Result, string, error) {
+	fi, err := root.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ResultSkip, path + " does not exist", nil
+		}
+		return ResultWarn, "", err
+	}
+	problems := []string{}
+	if checkMode {
+		if extra := fi.Mode().Perm() &^ maxMode.Perm(); extra != 0 {
+			problems = append(problems, fmt.Sprintf("mode %04o exceeds %04o", fi.Mode().Perm(), maxMode.Perm()))
+		}
+	}
+	if uid, gid, ok := statOwner(fi); ok {
+		if owner != "" {
+			if name := root.userName(uid); !idMatches(name, uid, owner) {
+				problems = append(problems, fmt.Sprintf("owner %s, want %s", name, owner))
+			}
+		}
+		if group != "" {
+			if name := root.groupName(gid); !idMatches(name, gid, group) {
+				problems = append(problems, fmt.Sprintf("group %s, want %s", name, group))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return ResultFail, path + ": " + strings.Join(problems, "; "), nil
+	}
+	return ResultPass, "", nil
+}
+
+func anyExists(root *auditRoot, paths []string) bool {
+	for _, p := range paths {
+		if root.exists(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// idMatches compares an owner with the wanted user or group, which may
+// be given by name or numeric ID.
+func idMatches(name string, id uint32, want string) bool {
+	if name == want {
+		return true
+	}
+	n, err := strconv.ParseUint(want, 10, 32)
+	return err == nil && uint32(n) == id
+}
+
+// lookupUserName and lookupGroupName fall back to the numeric ID, except
+// for 0, which is root even without a passwd or group entry.
+func lookupUserName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	if uid == 0 {
+		return "root"
+	}
+	return id
+}
+
+func lookupGroupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	if gid == 0 {
+		return "root"
+	}
+	return id
+}
+
+func evalServiceState(ctx context.Context, root *auditRoot, service, state string) (Result, string, error) {
+	verb := "is-active"
+	want := state
+	switch state {
+	case "active", "inactive":
+	case "enabled", "disabled":
+		verb = "is-enabled"
+	default:
+		return ResultWarn, "", fmt.Errorf("unknown service state %q", state)
+	}
+	var got string
+	switch {
+	case root.isImage() && verb == "is-active":
+		return ResultSkip, runtimeOnly, nil
+	case root.isImage():
+		got = unitFileState(root, service)
+	default:
+		if _, err := exec.LookPath("systemctl"); err != nil {
+			return ResultSkip, "systemctl not available", nil
+		}
+		out, _ := exec.CommandContext(ctx, "systemctl", verb, service).Output()
+		got = strings.TrimSpace(string(out))
+	}
+	if got == "" {
+		got = "unknown"
+	}
+	details := fmt.Sprintf("%s is %s", service, got)
+	switch {
+	case got == want:
+		return ResultPass, details, nil
+	case want == "inactive" && got != "active":
+		return ResultPass, details, nil
+	case want == "disabled" && got != "enabled":
+		return ResultPass, details, nil
+	default:
+		return ResultFail, details, nil
+	}
+}
+
+// unitFileState approximates "systemctl is-enabled" from an image's unit
+// files: masked units link to /dev/null and enabled units are linked from a
+// .wants or .requires directory under /etc/systemd/system.
+func unitFileState(root *auditRoot, service string) string {
+	unit := service
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	if target, err := root.Readlink("/etc/systemd/system/" + unit); err == nil && target == "/dev/null" {
+		return "masked"
+	}
+	for _, dir := range []string{"*.wants", "*.requires"} {
+		if m, _ := root.Glob(path.Join("/etc/systemd/system", dir, unit)); len(m) > 0 {
+			return "enabled"
+		}
+	}
+	for _, dir := range []string{"/etc/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"} {
+		if root.exists(path.Join(dir, unit)) {
+			return "disabled"
+		}
+	}
+	return "not-found"
+}
+
+func packageInstalled(ctx context.Context, root *auditRoot, name string) (bool, string, error) {
+	if root.isImage() {
+		return imagePackageInstalled(ctx, root, name)
+	}
+	if _, err := exec.LookPath("dpkg-query"); err == nil {
+		out, _ := exec.CommandContext(ctx, "dpkg-query", "-W", "-f", "${Status}", name).Output()
+		return strings.Contains(string(out), "install ok installed"), "dpkg", nil
+	}
+	if _, err := exec.LookPath("rpm"); err == nil {
+		err := exec.CommandContext(ctx, "rpm", "-q", name).Run()
+		return err == nil, "rpm", nil
+	}
+	return false, "", fmt.Errorf("no supported package manager")
+}
+
+// imagePackageInstalled reads the package database of an image: the dpkg
+// status file (or the status.d stanzas of distroless images), the apk
+// database, or the rpm database through "rpm --root" for mounted roots.
+func imagePackageInstalled(ctx context.Context, root *auditRoot, name string) (bool, string, error) {
+	if b, err := root.ReadFile("/var/lib/dpkg/status"); err == nil {
+		for _, stanza := range strings.Split(string(b), "\n\n") {
+			if controlField(stanza, "Package") == name {
+				return strings.Contains(controlField(stanza, "Status"), "install ok installed"), "dpkg", nil
+			}
+		}
+		return false, "dpkg", nil
+	}
+	if files, _ := root.Glob("/var/lib/dpkg/status.d/*"); len(files) > 0 {
+		for _, f := range files {
+			if b, err := root.ReadFile(f); err == nil && controlField(string(b), "Package") == name {
+				return true, "dpkg", nil
+			}
+		}
+		return false, "dpkg", nil
+	}
+	if b, err := root.ReadFile("/lib/apk/db/installed"); err == nil {
+		for _, ln := range strings.Split(string(b), "\n") {
+			if strings.TrimSpace(ln) == "P:"+name {
+				return true, "apk", nil
+			}
+		}
+		return false, "apk", nil
+	}
+	if root.exists("/var/lib/rpm") || root.exists("/usr/lib/sysimage/rpm") {
+		dir, ok := root.fsys.(dirFS)
+		if !ok {
+			return false, "", fmt.Errorf("the rpm database cannot be read from an image archive; audit the mounted root instead")
+		}
+		if _, err := exec.LookPath("rpm"); err != nil {
+			return false, "", fmt.Errorf("rpm is required to read the image's rpm database")
+		}
+		err := exec.CommandContext(ctx, "rpm", "--root", string(dir), "-q", name).Run()
+		return err == nil, "rpm", nil
+	}
+	return false, "", fmt.Errorf("no package database found in image")
+}
+
+// controlField returns a field of a Debian control stanza.
+func controlField(stanza, field string) string {
+	for _, ln := range strings.Split(stanza, "\n") {
+		if k, v, ok := strings.Cut(ln, ":"); ok && k == field {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
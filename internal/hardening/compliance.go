@@ -2,30 +2,87 @@ package hardening
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Control statuses. A control is compliant when every mapped check that ran
+// passed; manual controls have no automated checks, and not-assessed ones
+// have checks that did not produce a result on this host.
+const (
+	ControlCompliant    = "compliant"
+	ControlNonCompliant = "non-compliant"
+	ControlPartial      = "partial"
+	ControlNotAssessed  = "not-assessed"
+	ControlManual       = "manual"
 )
 
+// ControlCheck is a finding that contributes to a control's status.
+type ControlCheck struct {
+	ID      string `json:"id" yaml:"id"`
+	Title   string `json:"title" yaml:"title"`
+	Result  Result `json:"result" yaml:"result"`
+	Details string `json:"details,omitempty" yaml:"details,omitempty"`
+	// Evidence lists the check's artifact paths inside the evidence bundle.
+	Evidence []string `json:"evidence,omitempty" yaml:"evidence,omitempty"`
+}
+
+type ControlResult struct {
+	ID       string         `json:"id" yaml:"id"`
+	Title    string         `json:"title" yaml:"title"`
+	Status   string         `json:"status" yaml:"status"`
+	Severity Severity       `json:"severity,omitempty" yaml:"severity,omitempty"`
+	Checks   []ControlCheck `json:"checks,omitempty" yaml:"checks,omitempty"`
+	Guidance string         `json:"guidance,omitempty" yaml:"guidance,omitempty"`
+}
+
+// ComplianceGap is a control that is not shown to be compliant.
 type ComplianceGap struct {
-	Control string `json:"control"`
-	Title   string `json:"title"`
-	IssueID string `json:"issue_id"`
-	Details string `json:"details,omitempty"`
+	Control string   `json:"control" yaml:"control"`
+	Title   string   `json:"title" yaml:"title"`
+	Status  string   `json:"status" yaml:"status"`
+	Failing []string `json:"failing,omitempty" yaml:"failing,omitempty"`
+	Details string   `json:"details,omitempty" yaml:"details,omitempty"`
+}
+
+type ControlSummary struct {
+	Total        int `json:"total" yaml:"total"`
+	Automated    int `json:"automated" yaml:"automated"`
+	Compliant    int `json:"compliant" yaml:"compliant"`
+	NonCompliant int `json:"non_compliant" yaml:"non_compliant"`
+	Partial      int `json:"partial" yaml:"partial"`
+	NotAssessed  int `json:"not_assessed" yaml:"not_assessed"`
+	Manual       int `json:"manual" yaml:"manual"`
 }
 
 type ComplianceReport struct {
-	Timestamp time.Time       `json:"timestamp"`
-	Standard  string          `json:"standard"`
-	Host      string          `json:"host"`
-	Score     int             `json:"score"`
-	Gaps      []ComplianceGap `json:"gaps"`
-	Evidence  []string        `json:"evidence"`
-	Notes     []string        `json:"notes"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	Standard  string    `json:"standard" yaml:"standard"`
+	Framework string    `json:"framework" yaml:"framework"`
+	Host      string    `json:"host" yaml:"host"`
+	Profile   string    `json:"profile" yaml:"profile"`
+	// Score is the share of assessed controls that are compliant (partial
+	// ones count half); AuditScore is the underlying audit score.
+	Score      int `json:"score" yaml:"score"`
+	AuditScore int `json:"audit_score" yaml:"audit_score"`
+	// Coverage is the percentage of controls assessed automatically.
+	Coverage float64         `json:"coverage" yaml:"coverage"`
+	Summary  ControlSummary  `json:"summary" yaml:"summary"`
+	Controls []ControlResult `json:"controls" yaml:"controls"`
+	Gaps     []ComplianceGap `json:"gaps,omitempty" yaml:"gaps,omitempty"`
+	Evidence *EvidenceRef    `json:"evidence,omitempty" yaml:"evidence,omitempty"`
+	Notes    []string        `json:"notes,omitempty" yaml:"notes,omitempty"`
 }
 
 type ComplianceOptions struct {
@@ -33,8 +90,17 @@ type ComplianceOptions struct {
 	CollectEvidence bool
 	GapAnalysis     bool
 	Format          OutputFormat
+	// ConfigDir holds site checks, profiles and framework mappings.
+	ConfigDir string
+	// EvidencePath is where the evidence bundle is written when
+	// CollectEvidence is set; SigningKey signs its manifest (nil leaves
+	// the bundle unsigned).
+	EvidencePath string
+	SigningKey   ed25519.PrivateKey
 }
 
+// GenerateComplianceReport runs the framework's mapped checks with its
+// audit profile's thresholds and derives a status for every control.
 func GenerateComplianceReport(ctx context.Context, opts ComplianceOptions) (ComplianceReport, error) {
 	if strings.TrimSpace(opts.Standard) == "" {
 		opts.Standard = "pci-dss"
@@ -43,91 +109,314 @@ func GenerateComplianceReport(ctx context.Context, opts ComplianceOptions) (Comp
 		opts.Format = FormatJSON
 	}
 
-	auditProfile := "cis"
-	switch strings.ToLower(strings.TrimSpace(opts.Standard)) {
-	case "pci", "pci-dss":
-		auditProfile = "pci"
-	case "hipaa":
-		auditProfile = "hipaa"
-	case "gdpr":
-		auditProfile = "cis"
-	case "iso27001", "iso-27001":
-		auditProfile = "cis"
+	fw, err := LoadFramework(opts.ConfigDir, opts.Standard)
+	if err != nil {
+		return ComplianceReport{}, err
 	}
-
-	rep, err := RunAudit(ctx, AuditOptions{Profile: auditProfile, Level: "basic", Fix: false, Yes: false, Verbose: false})
+	reg, err := LoadRegistry(opts.ConfigDir)
+	if err != nil {
+		return ComplianceReport{}, err
+	}
+	rep, err := RunAudit(ctx, AuditOptions{
+		Registry:      reg,
+		Profile:       fw.Profile,
+		Level:         LevelBasic,
+		ConfigDir:     opts.ConfigDir,
+		IncludeChecks: fw.CheckPatterns(),
+		// Mapped Level 2 checks (auditd, AIDE) are part of the standard.
+		BenchmarkLevel: 2,
+	})
 	if err != nil {
 		return ComplianceReport{}, err
 	}
 
-	out := ComplianceReport{
-		Timestamp: time.Now(),
-		Standard:  opts.Standard,
-		Host:      rep.Hostname,
-		Score:     rep.Score,
+	out := assessControls(fw, rep)
+	if !opts.GapAnalysis {
+		out.Gaps = nil
+	}
+	if repl, ok := DeprecatedStandard(opts.Standard); ok && fw.ID == repl {
+		out.Notes = append(out.Notes, fmt.Sprintf("standard %q is deprecated and reported as %s; use --standard %s", opts.Standard, fw.Title, repl))
 	}
 
-	// Very lightweight mapping: failed findings are gaps.
-	if opts.GapAnalysis {
+	if opts.CollectEvidence {
+		if opts.EvidencePath == "" {
+			return ComplianceReport{}, errors.New("evidence collection requires a bundle path")
+		}
+		if opts.SigningKey == nil {
+			out.Notes = append(out.Notes, "evidence bundle is unsigned; set evidence_signing_key or pass --sign-key")
+		}
+		if err := collectEvidence(ctx, reg, fw, rep, &out, opts.EvidencePath, opts.SigningKey); err != nil {
+			return ComplianceReport{}, fmt.Errorf("evidence: %w", err)
+		}
+	}
+
+	return out, nil
+}
+
+// assessControls derives per-control status, the summary and gaps from an
+// audit report.
+func assessControls(fw Framework, rep Report) ComplianceReport {
+	out := ComplianceReport{
+		Timestamp:  time.Now(),
+		Standard:   fw.ID,
+		Framework:  fw.Name(),
+		Host:       rep.Hostname,
+		Profile:    rep.Profile,
+		AuditScore: rep.Score,
+	}
+	for _, c := range fw.Controls {
+		cr := ControlResult{ID: c.ID, Title: c.Title, Guidance: c.Guidance}
+		var passed, failed, warned int
 		for _, f := range rep.Findings {
-			if f.Result != ResultFail {
+			if !c.covers(f.ID) {
 				continue
 			}
-			out.Gaps = append(out.Gaps, ComplianceGap{Control: controlForStandard(opts.Standard, f.ID), Title: f.Title, IssueID: f.ID, Details: f.Details})
+			details := f.Details
+			if note := waiverNote(f.Waiver); note != "" {
+				details = strings.TrimPrefix(details+"; "+note, "; ")
+			}
+			cr.Checks = append(cr.Checks, ControlCheck{ID: f.ID, Title: f.Title, Result: f.Result, Details: details})
+			switch f.Result {
+			case ResultPass, ResultWaived:
+				// A waived check is an accepted, approved exception.
+				passed++
+			case ResultFail:
+				failed++
+			case ResultWarn:
+				warned++
+			}
+			if (f.Result == ResultFail || f.Result == ResultWarn) && severityRank(f.Severity) > severityRank(cr.Severity) {
+				cr.Severity = f.Severity
+			}
 		}
-	}
+		switch {
+		case !c.Automated():
+			cr.Status = ControlManual
+		case failed > 0:
+			cr.Status = ControlNonCompliant
+		case warned > 0:
+			cr.Status = ControlPartial
+		case passed > 0:
+			cr.Status = ControlCompliant
+		default:
+			cr.Status = ControlNotAssessed
+		}
+		out.Controls = append(out.Controls, cr)
+		out.Summary.add(cr.Status, c.Automated())
 
-	if opts.CollectEvidence {
-		out.Evidence = append(out.Evidence, fmt.Sprintf("audit_profile=%s", rep.Profile))
-		out.Evidence = append(out.Evidence, fmt.Sprintf("report_hash=%s", rep.ReportHash))
-		out.Notes = append(out.Notes, "evidence collection is lightweight; attach artifacts manually if required")
+		if cr.Status == ControlCompliant {
+			continue
+		}
+		gap := ComplianceGap{Control: c.ID, Title: c.Title, Status: cr.Status}
+		var details []string
+		for _, ch := range cr.Checks {
+			switch ch.Result {
+			case ResultFail, ResultWarn:
+				gap.Failing = append(gap.Failing, ch.ID)
+				if ch.Details != "" {
+					details = append(details, fmt.Sprintf("%s: %s", ch.ID, ch.Details))
+				}
+			}
+		}
+		switch cr.Status {
+		case ControlManual:
+			details = append(details, "not automatically assessable")
+		case ControlNotAssessed:
+			details = append(details, "mapped checks did not run on this host: "+strings.Join(c.Checks, ", "))
+		}
+		if c.Guidance != "" && len(gap.Failing) == 0 {
+			details = append(details, c.Guidance)
+		}
+		gap.Details = strings.Join(details, "; ")
+		out.Gaps = append(out.Gaps, gap)
 	}
 
-	return out, nil
+	s := out.Summary
+	if s.Total > 0 {
+		assessed := s.Compliant + s.NonCompliant + s.Partial
+		out.Coverage = math.Round(float64(assessed)*1000/float64(s.Total)) / 10
+		if assessed > 0 {
+			out.Score = (2*s.Compliant + s.Partial) * 50 / assessed
+		}
+	}
+	return out
 }
 
-func controlForStandard(standard, findingID string) string {
-	s := strings.ToLower(strings.TrimSpace(standard))
-	if s == "pci" || s == "pci-dss" {
-		return "PCI-DSS" + "::" + findingID
-	}
-	if s == "hipaa" {
-		return "HIPAA" + "::" + findingID
+func (s *ControlSummary) add(status string, automated bool) {
+	s.Total++
+	if automated {
+		s.Automated++
 	}
-	if s == "gdpr" {
-		return "GDPR" + "::" + findingID
+	switch status {
+	case ControlCompliant:
+		s.Compliant++
+	case ControlNonCompliant:
+		s.NonCompliant++
+	case ControlPartial:
+		s.Partial++
+	case ControlNotAssessed:
+		s.NotAssessed++
+	case ControlManual:
+		s.Manual++
 	}
-	if s == "iso27001" || s == "iso-27001" {
-		return "ISO27001" + "::" + findingID
+}
+
+func severityRank(s Severity) int {
+	switch s {
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	case SeverityCritical:
+		return 4
+	default:
+		return 0
 	}
-	return strings.ToUpper(s) + "::" + findingID
 }
 
-func RenderCompliance(w *os.File, rep ComplianceReport, format OutputFormat) error {
+func RenderCompliance(w io.Writer, rep ComplianceReport, format OutputFormat) error {
 	switch format {
 	case FormatJSON:
 		enc := json.NewEncoder(w)
 		enc.SetIndent("", "  ")
 		return enc.Encode(rep)
-	case FormatHTML:
-		// Simple HTML wrapper; keep in Go for portability.
-		html := "<!doctype html><html><head><meta charset=\"utf-8\"/><title>Compliance Report</title></head><body>" +
-			fmt.Sprintf("<h1>Compliance Report (%s)</h1>", rep.Standard) +
-			fmt.Sprintf("<p><b>Timestamp:</b> %s</p>", rep.Timestamp.Format(time.RFC3339)) +
-			fmt.Sprintf("<p><b>Host:</b> %s</p>", rep.Host) +
-			fmt.Sprintf("<p><b>Score:</b> %d/100</p>", rep.Score) +
-			"<h2>Gaps</h2><ul>"
-		for _, g := range rep.Gaps {
-			html += fmt.Sprintf("<li><code>%s</code> %s</li>", g.Control, g.Title)
+	case FormatYAML:
+		b, err := yaml.Marshal(rep)
+		if err != nil {
+			return err
 		}
-		html += "</ul></body></html>"
-		_, err := w.Write([]byte(html))
+		_, err = w.Write(b)
 		return err
+	case FormatHTML:
+		return renderComplianceHTML(w, rep)
+	case FormatSARIF, FormatJUnit, FormatCSV, FormatMarkdown, FormatPDF:
+		return renderExport(w, complianceExportDoc(rep), format)
 	default:
 		return errors.New("unsupported compliance export format")
 	}
 }
 
+func renderComplianceHTML(w io.Writer, rep ComplianceReport) error {
+	const tpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>Compliance Report</title>
+<style>
+body{font-family:system-ui,-apple-system,Segoe UI,Roboto,Helvetica,Arial,sans-serif;margin:24px}
+code{background:#f2f2f2;padding:2px 6px;border-radius:4px}
+.badge{display:inline-block;padding:2px 8px;border-radius:999px;font-size:12px}
+.compliant{background:#e8fff0;color:#116a2c}
+.non-compliant{background:#ffe8e8;color:#7d1b1b}
+.partial{background:#fff8e0;color:#6b4b00}
+.not-assessed,.manual{background:#eef2ff;color:#2a3a7a}
+td{vertical-align:top}
+small{color:#555}
+</style>
+</head>
+<body>
+<h1>Compliance Report ({{ .Framework }})</h1>
+<p><b>Timestamp:</b> {{ .Timestamp }}</p>
+<p><b>Host:</b> {{ .Host }} <b>Audit profile:</b> {{ .Profile }}</p>
+<p><b>Score:</b> {{ .Score }}/100 <b>Coverage:</b> {{ .Coverage }}% of controls assessed automatically</p>
+<p><b>Controls:</b> {{ .Summary.Total }} | Compliant {{ .Summary.Compliant }} | Non-compliant {{ .Summary.NonCompliant }} | Partial {{ .Summary.Partial }} | Not assessed {{ .Summary.NotAssessed }} | Manual {{ .Summary.Manual }}</p>
+{{ with .Evidence }}<p><b>Evidence:</b> <code>{{ .Bundle }}</code> ({{ .Artifacts }} artifacts, {{ if .Signed }}signed by key {{ .KeyID }}{{ else }}unsigned{{ end }})<br/><small>bundle sha256 {{ .SHA256 }}</small></p>{{ end }}
+{{ range .Notes }}<p><small>Note: {{ . }}</small></p>
+{{ end }}<hr/>
+<table cellpadding="8" cellspacing="0" border="0">
+<thead><tr><th align="left">Control</th><th align="left">Status</th><th align="left">Title</th><th align="left">Checks</th></tr></thead>
+<tbody>
+{{ range .Controls }}
+<tr>
+<td><code>{{ .ID }}</code></td>
+<td><span class="badge {{ .Status }}">{{ .Status }}</span></td>
+<td>{{ .Title }}{{ if .Guidance }}<br/><small>{{ .Guidance }}</small>{{ end }}</td>
+<td>{{ range .Checks }}<code>{{ .ID }}</code> {{ .Result }}{{ range .Evidence }}<br/><small>{{ . }}</small>{{ end }}<br/>{{ end }}</td>
+</tr>
+{{ end }}
+</tbody>
+</table>
+{{ if .Gaps }}
+<h2>Gaps</h2>
+<ul>
+{{ range .Gaps }}<li><code>{{ .Control }}</code> {{ .Title }} <span class="badge {{ .Status }}">{{ .Status }}</span>{{ if .Details }}<br/><small>{{ .Details }}</small>{{ end }}</li>
+{{ end }}
+</ul>
+{{ end }}
+</body>
+</html>`
+
+	t, err := template.New("compliance").Parse(tpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, rep)
+}
+
+// controlResult maps a control status onto the pass/fail vocabulary of
+// the export formats.
+func controlResult(status string) Result {
+	switch status {
+	case ControlCompliant:
+		return ResultPass
+	case ControlNonCompliant:
+		return ResultFail
+	case ControlPartial:
+		return ResultWarn
+	default:
+		return ResultSkip
+	}
+}
+
+func complianceExportDoc(rep ComplianceReport) exportDoc {
+	doc := exportDoc{
+		Title:     fmt.Sprintf("Compliance Report (%s)", rep.Framework),
+		Suite:     "fortis.compliance." + rep.Standard,
+		Host:      rep.Host,
+		Timestamp: rep.Timestamp,
+		Meta: []exportMeta{
+			{"Framework", rep.Framework},
+			{"Host", rep.Host},
+			{"Audit profile", rep.Profile},
+			{"Score", fmt.Sprintf("%d/100", rep.Score)},
+			{"Coverage", fmt.Sprintf("%.1f%% of controls assessed automatically", rep.Coverage)},
+			{"Controls", fmt.Sprintf("%d total | Compliant %d | Non-compliant %d | Partial %d | Not assessed %d | Manual %d",
+				rep.Summary.Total, rep.Summary.Compliant, rep.Summary.NonCompliant, rep.Summary.Partial, rep.Summary.NotAssessed, rep.Summary.Manual)},
+		},
+	}
+	if e := rep.Evidence; e != nil {
+		doc.Meta = append(doc.Meta, exportMeta{"Evidence", fmt.Sprintf("%s (%d artifacts, %s)", e.Bundle, e.Artifacts, evidenceSignedLabel(e))})
+	}
+	for _, n := range rep.Notes {
+		doc.Meta = append(doc.Meta, exportMeta{"Note", n})
+	}
+	for _, c := range rep.Controls {
+		it := exportItem{ID: c.ID, Title: c.Title, Result: controlResult(c.Status), Status: c.Status, Severity: c.Severity, Recommendation: c.Guidance}
+		var details []string
+		for _, ch := range c.Checks {
+			it.Checks = append(it.Checks, ch.ID)
+			if ch.Result == ResultFail || ch.Result == ResultWarn {
+				d := fmt.Sprintf("%s %s", ch.ID, ch.Result)
+				if ch.Details != "" {
+					d += ": " + ch.Details
+				}
+				details = append(details, d)
+			}
+		}
+		switch c.Status {
+		case ControlManual:
+			details = append(details, "not automatically assessable")
+		case ControlNotAssessed:
+			details = append(details, "mapped checks did not run on this host")
+		}
+		it.Details = strings.Join(details, "; ")
+		doc.Items = append(doc.Items, it)
+	}
+	return doc
+}
+
 func ResolveComplianceOutputPath(exportFmt string, ts time.Time) (string, OutputFormat, error) {
 	f := DetectFormat(exportFmt)
 	if exportFmt != "" {
@@ -135,7 +424,7 @@ func ResolveComplianceOutputPath(exportFmt string, ts time.Time) (string, Output
 			return exportFmt, f, nil
 		}
 	}
-	name := fmt.Sprintf("compliance-%s.%s", ts.Format("20060102-150405"), string(f))
+	name := fmt.Sprintf("compliance-%s.%s", ts.Format("20060102-150405"), f.Ext())
 	preferred := filepath.Join("/var/log/fortis", name)
 	if canWriteDir("/var/log/fortis") {
 		return preferred, f, nil
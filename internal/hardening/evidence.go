@@ -0,0 +1,652 @@
+package hardening
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"fortis-admin/internal/signing"
+)
+
+// An evidence bundle is a tar.gz holding report.json, the raw artifacts
+// each check decided on (config excerpts, command output, runtime values),
+// manifest.json listing every file with its SHA-256 and the control -> check
+// -> artifact mapping, and manifest.sig, an Ed25519 signature over the
+// manifest bytes.
+
+const (
+	evidenceVersion      = 1
+	evidenceManifestName = "manifest.json"
+	evidenceSigName      = "manifest.sig"
+	evidenceReportName   = "report.json"
+	// maxArtifactBytes caps command output and excerpts kept per artifact.
+	maxArtifactBytes = 256 << 10
+)
+
+type HostIdentity struct {
+	Hostname  string   `json:"hostname"`
+	MachineID string   `json:"machine_id,omitempty"`
+	BootID    string   `json:"boot_id,omitempty"`
+	OS        string   `json:"os,omitempty"`
+	Kernel    string   `json:"kernel,omitempty"`
+	Arch      string   `json:"arch"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// EvidenceArtifact describes one file under artifacts/ in the bundle.
+type EvidenceArtifact struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"` // file, command, value
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+	// For file artifacts: hash and metadata of the whole source file, of
+	// which the artifact holds the relevant lines.
+	SourceSHA256 string     `json:"source_sha256,omitempty"`
+	Mode         string     `json:"mode,omitempty"`
+	Owner        string     `json:"owner,omitempty"`
+	ModTime      *time.Time `json:"mod_time,omitempty"`
+	ExitCode     *int       `json:"exit_code,omitempty"`
+	CollectedAt  time.Time  `json:"collected_at"`
+}
+
+type EvidenceCheck struct {
+	ID        string             `json:"id"`
+	Title     string             `json:"title"`
+	Result    Result             `json:"result"`
+	Details   string             `json:"details,omitempty"`
+	Artifacts []EvidenceArtifact `json:"artifacts"`
+}
+
+type EvidenceControl struct {
+	ID     string   `json:"id"`
+	Title  string   `json:"title"`
+	Status string   `json:"status"`
+	Checks []string `json:"checks,omitempty"`
+}
+
+type EvidenceFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type EvidenceManifest struct {
+	Version   int               `json:"version"`
+	BundleID  string            `json:"bundle_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Host      HostIdentity      `json:"host"`
+	Standard  string            `json:"standard"`
+	Framework string            `json:"framework"`
+	Profile   string            `json:"profile"`
+	Controls  []EvidenceControl `json:"controls"`
+	Checks    []EvidenceCheck   `json:"checks"`
+	Files     []EvidenceFile    `json:"files"`
+}
+
+// EvidenceSignature is stored as manifest.sig.
+type EvidenceSignature struct {
+	Algorithm      string    `json:"algorithm"`
+	KeyID          string    `json:"key_id"`
+	BundleID       string    `json:"bundle_id"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+	SignedAt       time.Time `json:"signed_at"`
+	Signature      string    `json:"signature"`
+}
+
+func (s EvidenceSignature) payload() []byte {
+	return []byte(fmt.Sprintf("fortis-evidence-sig-v1\n%s\n%s\n", s.BundleID, s.ManifestSHA256))
+}
+
+// EvidenceRef points from a compliance report to its bundle. SHA256 is
+// the hash of the tarball and is only known outside the bundle.
+type EvidenceRef struct {
+	Bundle    string `json:"bundle" yaml:"bundle"`
+	BundleID  string `json:"bundle_id" yaml:"bundle_id"`
+	SHA256    string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Signed    bool   `json:"signed" yaml:"signed"`
+	KeyID     string `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	Artifacts int    `json:"artifacts" yaml:"artifacts"`
+}
+
+func evidenceSignedLabel(e *EvidenceRef) string {
+	if e.Signed {
+		return "signed by key " + e.KeyID
+	}
+	return "unsigned"
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+type evidenceCollector struct {
+	ctx   context.Context
+	files []bundleFile
+	seq   map[string]int
+}
+
+// collectEvidence gathers artifacts for every finding behind a control,
+// attaches their bundle paths to rep and writes the bundle to path.
+func collectEvidence(ctx context.Context, reg *Registry, fw Framework, audit Report, rep *ComplianceReport, bundlePath string, key ed25519.PrivateKey) error {
+	now := time.Now()
+	host := hostIdentity()
+	m := EvidenceManifest{
+		Version:   evidenceVersion,
+		BundleID:  fmt.Sprintf("evidence-%s-%s-%s", fw.ID, sanitizeArtifactName(host.Hostname), now.UTC().Format("20060102T150405Z")),
+		CreatedAt: now,
+		Host:      host,
+		Standard:  fw.ID,
+		Framework: fw.Name(),
+		Profile:   audit.Profile,
+	}
+	e := &evidenceCollector{ctx: ctx, seq: map[string]int{}}
+
+	used := map[string]bool{}
+	for _, c := range rep.Controls {
+		for _, ch := range c.Checks {
+			used[ch.ID] = true
+		}
+	}
+	artifacts := map[string][]string{}
+	for _, f := range audit.Findings {
+		if !used[f.ID] {
+			continue
+		}
+		ec := EvidenceCheck{ID: f.ID, Title: f.Title, Result: f.Result, Details: f.Details, Artifacts: []EvidenceArtifact{}}
+		if c, ok := reg.Get(f.ID); ok {
+			ec.Artifacts = e.collect(c, f)
+		}
+		for _, a := range ec.Artifacts {
+			artifacts[f.ID] = append(artifacts[f.ID], a.Path)
+		}
+		m.Checks = append(m.Checks, ec)
+	}
+
+	total := 0
+	for i := range rep.Controls {
+		c := &rep.Controls[i]
+		ctl := EvidenceControl{ID: c.ID, Title: c.Title, Status: c.Status}
+		for j := range c.Checks {
+			c.Checks[j].Evidence = artifacts[c.Checks[j].ID]
+			ctl.Checks = append(ctl.Checks, c.Checks[j].ID)
+		}
+		m.Controls = append(m.Controls, ctl)
+	}
+	for _, a := range artifacts {
+		total += len(a)
+	}
+
+	rep.Evidence = &EvidenceRef{Bundle: bundlePath, BundleID: m.BundleID, Signed: key != nil, Artifacts: total}
+	if key != nil {
+		rep.Evidence.KeyID = signing.PrivateKeyID(key)
+	}
+	reportJSON, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	e.files = append([]bundleFile{{name: evidenceReportName, data: append(reportJSON, '\n')}}, e.files...)
+
+	for _, f := range e.files {
+		sum := sha256.Sum256(f.data)
+		m.Files = append(m.Files, EvidenceFile{Path: f.name, Size: int64(len(f.data)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest = append(manifest, '\n')
+	e.files = append(e.files, bundleFile{name: evidenceManifestName, data: manifest})
+
+	if key != nil {
+		sum := sha256.Sum256(manifest)
+		sig := EvidenceSignature{
+			Algorithm:      signing.Algorithm,
+			KeyID:          rep.Evidence.KeyID,
+			BundleID:       m.BundleID,
+			ManifestSHA256: hex.EncodeToString(sum[:]),
+			SignedAt:       now,
+		}
+		sig.Signature = signing.Sign(key, sig.payload())
+		b, err := json.MarshalIndent(sig, "", "  ")
+		if err != nil {
+			return err
+		}
+		e.files = append(e.files, bundleFile{name: evidenceSigName, data: append(b, '\n')})
+	}
+
+	sum, err := writeBundle(bundlePath, e.files, now.Truncate(time.Second))
+	if err != nil {
+		return err
+	}
+	rep.Evidence.SHA256 = sum
+	return nil
+}
+
+func writeBundle(bundlePath string, files []bundleFile, mtime time.Time) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(bundlePath), 0o755); err != nil {
+		return "", err
+	}
+	tmp := bundlePath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, h))
+	tw := tar.NewWriter(gz)
+	write := func() error {
+		for _, bf := range files {
+			hdr := &tar.Header{Name: bf.name, Mode: 0o600, Size: int64(len(bf.data)), ModTime: mtime, Typeflag: tar.TypeReg}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(bf.data); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	if err := errors.Join(write(), f.Close()); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, bundlePath); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collect captures what check c looked at. YAML checks are described by
+// their spec; built-in checks are listed explicitly.
+func (e *evidenceCollector) collect(c Check, f Finding) []EvidenceArtifact {
+	var out []EvidenceArtifact
+	if s := c.spec; s != nil {
+		switch strings.ToLower(s.Type) {
+		case "file_content":
+			re, _ := regexp.Compile(s.Pattern)
+			for _, p := range expandPaths(hostRoot, s.pathList()) {
+				out = append(out, e.file(c.ID, p, func(ln string) bool { return re != nil && re.MatchString(ln) }))
+			}
+		case "file_permission":
+			for _, p := range expandPaths(hostRoot, s.pathList()) {
+				out = append(out, e.file(c.ID, p, nil))
+			}
+		case "config_value":
+			for _, p := range expandPaths(hostRoot, s.pathList()) {
+				out = append(out, e.file(c.ID, p, configKeyMatcher(s.Key)))
+			}
+		case "sshd_option":
+			paths := s.pathList()
+			if len(paths) == 0 {
+				paths = []string{SSHDConfigPath}
+			}
+			out = append(out, e.sshd(c.ID, paths[0], s.Key)...)
+		case "sysctl":
+			out = append(out, e.sysctl(c.ID, s.Key)...)
+		case "mount_option":
+			out = append(out, e.mount(c.ID, s.Mount)...)
+		case "kernel_module":
+			out = append(out, e.module(c.ID, s.Module)...)
+		case "command":
+			out = append(out, e.command(c.ID, "sh", "-c", s.Command))
+		case "service":
+			out = append(out, e.command(c.ID, "systemctl", "is-enabled", s.Service), e.command(c.ID, "systemctl", "is-active", s.Service))
+		case "package":
+			if _, err := exec.LookPath("dpkg-query"); err == nil {
+				out = append(out, e.command(c.ID, "dpkg-query", "-W", "-f", "${Package} ${Version} ${Status}\n", s.Package))
+			} else {
+				out = append(out, e.command(c.ID, "rpm", "-q", s.Package))
+			}
+		}
+		return out
+	}
+
+	switch c.ID {
+	case "ssh.root_login":
+		out = e.sshd(c.ID, SSHDConfigPath, "PermitRootLogin")
+	case "ssh.password_auth":
+		out = e.sshd(c.ID, SSHDConfigPath, "PasswordAuthentication")
+	case "sysctl.ip_forward":
+		out = e.sysctl(c.ID, "net.ipv4.ip_forward")
+	case "firewall.present", "firewall.enabled", "firewall.default_deny", "firewall.any_any", "firewall.uncovered_ports", "firewall.policy_drift":
+		switch detectFirewallBackend() {
+		case "ufw":
+			out = append(out, e.command(c.ID, "ufw", "status", "verbose"))
+		case "nftables":
+			out = append(out, e.command(c.ID, "nft", "list", "ruleset"))
+		case "iptables":
+			out = append(out, e.command(c.ID, "iptables", "-S"))
+		default:
+			out = append(out, e.value(c.ID, "firewall-tools", "no ufw, nft or iptables binary found in PATH\n"))
+		}
+	default:
+		// Scanning checks (world-writable, unowned files): the finding's
+		// path list is the evidence.
+		out = append(out, e.value(c.ID, "finding", fmt.Sprintf("result: %s\n%s\n", f.Result, f.Details)))
+	}
+	return out
+}
+
+func (e *evidenceCollector) add(checkID, name string, a EvidenceArtifact, body []byte) EvidenceArtifact {
+	if len(body) > maxArtifactBytes {
+		body = append(body[:maxArtifactBytes:maxArtifactBytes], []byte("\n[truncated]\n")...)
+	}
+	e.seq[checkID]++
+	a.Path = path.Join("artifacts", sanitizeArtifactName(checkID), fmt.Sprintf("%02d-%s.txt", e.seq[checkID], sanitizeArtifactName(name)))
+	sum := sha256.Sum256(body)
+	a.SHA256 = hex.EncodeToString(sum[:])
+	a.CollectedAt = time.Now()
+	e.files = append(e.files, bundleFile{name: a.Path, data: body})
+	return a
+}
+
+func (e *evidenceCollector) value(checkID, name, text string) EvidenceArtifact {
+	return e.add(checkID, name, EvidenceArtifact{Kind: "value", Source: name}, []byte(text))
+}
+
+// file records metadata and the lines selected by match (all lines of a
+// small file when match is nil and content is wanted) with line numbers.
+// Credential files only get metadata and a hash.
+func (e *evidenceCollector) file(checkID, p string, match func(string) bool) EvidenceArtifact {
+	a := EvidenceArtifact{Kind: "file", Source: p}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "# source: %s\n", p)
+	fi, err := os.Stat(p)
+	if err != nil {
+		fmt.Fprintf(&body, "# error: %v\n", err)
+		return e.add(checkID, filepath.Base(p), a, body.Bytes())
+	}
+	mt := fi.ModTime()
+	a.ModTime = &mt
+	a.Mode = fmt.Sprintf("%04o", fi.Mode().Perm())
+	if uid, gid, ok := fileOwner(fi); ok {
+		a.Owner = lookupUserName(uid) + ":" + lookupGroupName(gid)
+	}
+	data, err := os.ReadFile(p)
+	if err == nil {
+		sum := sha256.Sum256(data)
+		a.SourceSHA256 = hex.EncodeToString(sum[:])
+	}
+	fmt.Fprintf(&body, "# sha256: %s\n# mode: %s owner: %s size: %d modified: %s\n", a.SourceSHA256, a.Mode, a.Owner, fi.Size(), mt.Format(time.RFC3339))
+	switch {
+	case err != nil:
+		fmt.Fprintf(&body, "# content not readable: %v\n", err)
+	case sensitiveEvidencePath(p):
+		body.WriteString("# content withheld: credential file\n")
+	case match != nil:
+		for i, ln := range strings.Split(string(data), "\n") {
+			if match(ln) {
+				fmt.Fprintf(&body, "%d: %s\n", i+1, ln)
+			}
+		}
+	}
+	return e.add(checkID, filepath.Base(p), a, body.Bytes())
+}
+
+func (e *evidenceCollector) command(checkID, name string, args ...string) EvidenceArtifact {
+	cmdline := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	a := EvidenceArtifact{Kind: "command", Source: cmdline}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "$ %s\n", cmdline)
+	out, err := exec.CommandContext(e.ctx, name, args...).CombinedOutput()
+	body.Write(out)
+	code := 0
+	var ee *exec.ExitError
+	switch {
+	case errors.As(err, &ee):
+		code = ee.ExitCode()
+	case err != nil:
+		code = -1
+		fmt.Fprintf(&body, "# error: %v\n", err)
+	}
+	a.ExitCode = &code
+	fmt.Fprintf(&body, "# exit status: %d\n", code)
+	return e.add(checkID, name, a, body.Bytes())
+}
+
+func (e *evidenceCollector) sshd(checkID, main, key string) []EvidenceArtifact {
+	cfg, err := ParseSSHDConfig(main)
+	if err != nil {
+		return []EvidenceArtifact{e.file(checkID, main, configKeyMatcher(key))}
+	}
+	var out []EvidenceArtifact
+	for _, f := range cfg.Files {
+		out = append(out, e.file(checkID, f, configKeyMatcher(key)))
+	}
+	if s, ok := cfg.Get(key); ok {
+		out = append(out, e.value(checkID, "effective-"+key, fmt.Sprintf("%s %s\n# origin: %s\n", key, s.Value, s.Origin())))
+	}
+	return out
+}
+
+func (e *evidenceCollector) sysctl(checkID, key string) []EvidenceArtifact {
+	proc := "/proc/sys/" + strings.ReplaceAll(key, ".", "/")
+	v, err := readSysctl(key)
+	text := fmt.Sprintf("%s = %s\n", key, v)
+	if err != nil {
+		text = fmt.Sprintf("%s: %v\n", key, err)
+	}
+	out := []EvidenceArtifact{e.add(checkID, key, EvidenceArtifact{Kind: "value", Source: proc}, []byte(text))}
+	match := func(ln string) bool {
+		k, _ := splitConfigLine(strings.TrimSpace(ln))
+		return strings.ReplaceAll(k, "/", ".") == key
+	}
+	for _, p := range expandPaths(hostRoot, []string{"/etc/sysctl.conf", "/etc/sysctl.d/*.conf", "/run/sysctl.d/*.conf", "/usr/lib/sysctl.d/*.conf"}) {
+		if b, err := os.ReadFile(p); err == nil && strings.Contains(string(b), key) {
+			out = append(out, e.file(checkID, p, match))
+		}
+	}
+	return out
+}
+
+func (e *evidenceCollector) mount(checkID, mount string) []EvidenceArtifact {
+	byTarget := func(ln string) bool {
+		f := strings.Fields(ln)
+		return len(f) >= 2 && f[1] == mount
+	}
+	return []EvidenceArtifact{e.file(checkID, "/proc/mounts", byTarget), e.file(checkID, "/etc/fstab", byTarget)}
+}
+
+func (e *evidenceCollector) module(checkID, module string) []EvidenceArtifact {
+	norm := func(m string) string { return strings.ReplaceAll(m, "-", "_") }
+	out := []EvidenceArtifact{e.file(checkID, "/proc/modules", func(ln string) bool {
+		f := strings.Fields(ln)
+		return len(f) > 0 && norm(f[0]) == norm(module)
+	})}
+	for _, p := range expandPaths(hostRoot, []string{"/etc/modprobe.d/*.conf", "/lib/modprobe.d/*.conf", "/usr/lib/modprobe.d/*.conf"}) {
+		b, err := os.ReadFile(p)
+		if err != nil || !strings.Contains(norm(string(b)), norm(module)) {
+			continue
+		}
+		out = append(out, e.file(checkID, p, func(ln string) bool {
+			f := strings.Fields(ln)
+			return len(f) >= 2 && norm(f[1]) == norm(module)
+		}))
+	}
+	return out
+}
+
+// configKeyMatcher selects non-comment lines that set key.
+func configKeyMatcher(key string) func(string) bool {
+	return func(ln string) bool {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			return false
+		}
+		k, _ := splitConfigLine(ln)
+		return strings.EqualFold(k, key)
+	}
+}
+
+func sensitiveEvidencePath(p string) bool {
+	base := filepath.Base(p)
+	switch {
+	case strings.Contains(base, "shadow"):
+		return true
+	case strings.HasPrefix(p, "/etc/ssh/") && strings.HasSuffix(base, "_key"):
+		return true
+	case strings.HasSuffix(base, ".key") || strings.HasSuffix(base, ".pem"):
+		return true
+	}
+	return false
+}
+
+var artifactNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func sanitizeArtifactName(s string) string {
+	s = strings.Trim(artifactNameUnsafe.ReplaceAllString(s, "_"), "_.")
+	if s == "" {
+		return "x"
+	}
+	return s
+}
+
+func hostIdentity() HostIdentity {
+	h := HostIdentity{Arch: runtime.GOARCH}
+	h.Hostname, _ = os.Hostname()
+	read := func(p string) string {
+		b, _ := os.ReadFile(p)
+		return strings.TrimSpace(string(b))
+	}
+	h.MachineID = read("/etc/machine-id")
+	h.BootID = read("/proc/sys/kernel/random/boot_id")
+	h.Kernel = read("/proc/sys/kernel/osrelease")
+	for _, ln := range strings.Split(read("/etc/os-release"), "\n") {
+		if v, ok := strings.CutPrefix(ln, "PRETTY_NAME="); ok {
+			h.OS = strings.Trim(v, `"`)
+		}
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && !ipn.IP.IsLinkLocalUnicast() {
+				h.Addresses = append(h.Addresses, ipn.IP.String())
+			}
+		}
+		sort.Strings(h.Addresses)
+	}
+	return h
+}
+
+// Evidence verification statuses.
+const (
+	EvidenceVerified  = "verified"
+	EvidenceUnsigned  = "unsigned"
+	EvidenceUntrusted = "untrusted-key"
+)
+
+// VerifyEvidenceBundle checks every file against the manifest and the
+// manifest against its signature. Without trusted keys a valid signature
+// is reported as untrusted-key.
+func VerifyEvidenceBundle(bundlePath string, trusted []ed25519.PublicKey) (EvidenceManifest, string, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return EvidenceManifest{}, "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return EvidenceManifest{}, "", fmt.Errorf("%s: %w", bundlePath, err)
+	}
+	defer gz.Close()
+
+	sums := map[string]string{}
+	var manifestBytes, sigBytes []byte
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return EvidenceManifest{}, "", fmt.Errorf("%s: %w", bundlePath, err)
+		}
+		// Tolerate bundles re-packed with tar(1), which adds directories.
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		hdr.Name = strings.TrimPrefix(hdr.Name, "./")
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return EvidenceManifest{}, "", err
+		}
+		switch hdr.Name {
+		case evidenceManifestName:
+			manifestBytes = data
+		case evidenceSigName:
+			sigBytes = data
+		default:
+			sum := sha256.Sum256(data)
+			sums[hdr.Name] = hex.EncodeToString(sum[:])
+		}
+	}
+	if manifestBytes == nil {
+		return EvidenceManifest{}, "", errors.New("bundle has no manifest.json")
+	}
+	var m EvidenceManifest
+	if err := json.Unmarshal(manifestBytes, &m); err != nil {
+		return EvidenceManifest{}, "", fmt.Errorf("manifest.json: %w", err)
+	}
+
+	var problems []error
+	listed := map[string]bool{}
+	for _, mf := range m.Files {
+		listed[mf.Path] = true
+		got, ok := sums[mf.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("%s: missing from bundle", mf.Path))
+		case got != mf.SHA256:
+			problems = append(problems, fmt.Errorf("%s: sha256 mismatch", mf.Path))
+		}
+	}
+	for name := range sums {
+		if !listed[name] {
+			problems = append(problems, fmt.Errorf("%s: not listed in manifest", name))
+		}
+	}
+	if err := errors.Join(problems...); err != nil {
+		return m, "", err
+	}
+
+	if sigBytes == nil {
+		return m, EvidenceUnsigned, nil
+	}
+	var sig EvidenceSignature
+	if err := json.Unmarshal(sigBytes, &sig); err != nil {
+		return m, "", fmt.Errorf("manifest.sig: %w", err)
+	}
+	sum := sha256.Sum256(manifestBytes)
+	if sig.ManifestSHA256 != hex.EncodeToString(sum[:]) || sig.BundleID != m.BundleID {
+		return m, "", errors.New("signature does not match manifest")
+	}
+	switch err := signing.Verify(trusted, sig.KeyID, sig.payload(), sig.Signature); {
+	case err == nil:
+		return m, EvidenceVerified, nil
+	case errors.Is(err, signing.ErrUntrusted):
+		return m, EvidenceUntrusted, nil
+	default:
+		return m, "", fmt.Errorf("%w for key %s", err, sig.KeyID)
+	}
+}
@@ -0,0 +1,523 @@
+package hardening
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// exportDoc is the format-neutral view of a report that the SARIF, JUnit,
+// CSV, Markdown and PDF renderers share, so audit and compliance reports
+// export the same way.
+type exportDoc struct {
+	Title     string
+	Suite     string
+	Host      string
+	Timestamp time.Time
+	Meta      []exportMeta
+	Items     []exportItem
+}
+
+type exportMeta struct {
+	Key, Value string
+}
+
+type exportItem struct {
+	ID    string
+	Title string
+	// Result drives pass/fail semantics; Status, when set, is the label
+	// shown instead (e.g. a compliance control status).
+	Result         Result
+	Status         string
+	Severity       Severity
+	Weight         int
+	Benchmark      string
+	Tags           []string
+	References     []string
+	Details        string
+	Recommendation string
+	// Checks lists the audit checks behind a compliance control.
+	Checks []string
+	// Justification is set for waived items.
+	Justification string
+}
+
+func auditExportDoc(rep Report) exportDoc {
+	doc := exportDoc{
+		Title:     "Fortis Audit Report",
+		Suite:     "fortis.audit." + rep.Profile,
+		Host:      rep.Hostname,
+		Timestamp: rep.Timestamp,
+		Meta: []exportMeta{
+			{"Host", rep.Hostname},
+			{"OS", rep.Platform},
+			{"Profile", rep.Profile},
+			{"Level", rep.Level},
+			{"Score", fmt.Sprintf("%d/100 (%s)", rep.Score, rep.ScoreLabel)},
+			{"Stats", fmt.Sprintf("Passed %d | Failed %d | Warnings %d | Skipped %d", rep.Passed, rep.Failed, rep.Warnings, rep.Skipped)},
+		},
+	}
+	if rep.Root != "" {
+		doc.Meta = append(doc.Meta, exportMeta{"Image root", rep.Root})
+	}
+	if rep.Waived > 0 || rep.ExpiredWaivers > 0 {
+		doc.Meta = append(doc.Meta, exportMeta{"Waivers", fmt.Sprintf("%d waived | %d expired", rep.Waived, rep.ExpiredWaivers)})
+	}
+	if rep.BenchmarkLevel > 0 {
+		doc.Meta = append(doc.Meta, exportMeta{"CIS level", fmt.Sprint(rep.BenchmarkLevel)})
+	}
+	for _, f := range rep.Findings {
+		details := f.Details
+		if note := waiverNote(f.Waiver); note != "" {
+			details = strings.TrimPrefix(details+"; "+note, "; ")
+		}
+		it := exportItem{
+			ID:             f.ID,
+			Title:          f.Title,
+			Result:         f.Result,
+			Severity:       f.Severity,
+			Weight:         f.Weight,
+			Benchmark:      f.Benchmark,
+			Tags:           f.Tags,
+			References:     f.References,
+			Details:        details,
+			Recommendation: f.Recommendation,
+		}
+		if f.Result == ResultWaived {
+			it.Justification = f.Waiver.Justification
+		}
+		doc.Items = append(doc.Items, it)
+	}
+	return doc
+}
+
+func renderExport(w io.Writer, doc exportDoc, format OutputFormat) error {
+	switch format {
+	case FormatSARIF:
+		return renderSARIF(w, doc)
+	case FormatJUnit:
+		return renderJUnit(w, doc)
+	case FormatCSV:
+		return renderCSV(w, doc)
+	case FormatMarkdown:
+		return renderMarkdown(w, doc)
+	case FormatPDF:
+		return renderPDF(w, doc)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func (d exportDoc) hasChecks() bool {
+	for _, it := range d.Items {
+		if len(it.Checks) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (it exportItem) label() string {
+	if it.Status != "" {
+		return it.Status
+	}
+	return string(it.Result)
+}
+
+// SARIF 2.1.0. Only failing, warning and waived items become results (waived
+// ones carry an accepted suppression); every item is listed as a rule so
+// dashboards can show what was checked.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+	Properties  map[string]string `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name,omitempty"`
+	ShortDescription     sarifText          `json:"shortDescription"`
+	Help                 *sarifText         `json:"help,omitempty"`
+	HelpURI              string             `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifRuleProps     `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifRuleProps struct {
+	Tags             []string `json:"tags,omitempty"`
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+	Benchmark        string   `json:"benchmark,omitempty"`
+	Checks           []string `json:"checks,omitempty"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool   `json:"executionSuccessful"`
+	EndTimeUTC          string `json:"endTimeUtc"`
+}
+
+type sarifResult struct {
+	RuleID              string             `json:"ruleId"`
+	RuleIndex           int                `json:"ruleIndex"`
+	Level               string             `json:"level"`
+	Message             sarifText          `json:"message"`
+	Locations           []sarifLocation    `json:"locations"`
+	PartialFingerprints map[string]string  `json:"partialFingerprints"`
+	Suppressions        []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+// sarifSuppression marks a waived finding as an accepted, externally
+// recorded exception so code-scanning dashboards do not alert on it.
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysical  `json:"physicalLocation"`
+	LogicalLocations []sarifLogical `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysical struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogical struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+func sarifLevel(it exportItem) string {
+	if it.Result == ResultWarn {
+		return "warning"
+	}
+	switch it.Severity {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityLow:
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// sarifSecuritySeverity is the CVSS-like score code-scanning dashboards use
+// to bucket results.
+func sarifSecuritySeverity(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "9.5"
+	case SeverityHigh:
+		return "7.5"
+	case SeverityLow:
+		return "2.0"
+	default:
+		return "5.0"
+	}
+}
+
+func renderSARIF(w io.Writer, doc exportDoc) error {
+	run := sarifRun{
+		Tool:        sarifTool{Driver: sarifDriver{Name: "fortis", Rules: []sarifRule{}}},
+		Invocations: []sarifInvocation{{ExecutionSuccessful: true, EndTimeUTC: doc.Timestamp.UTC().Format(time.RFC3339)}},
+		Results:     []sarifResult{},
+		Properties:  map[string]string{},
+	}
+	for _, m := range doc.Meta {
+		run.Properties[strings.ToLower(strings.ReplaceAll(m.Key, " ", "_"))] = m.Value
+	}
+	host := doc.Host
+	if host == "" {
+		host = "localhost"
+	}
+	for i, it := range doc.Items {
+		rule := sarifRule{
+			ID:                   it.ID,
+			Name:                 it.Title,
+			ShortDescription:     sarifText{Text: it.Title},
+			DefaultConfiguration: sarifConfiguration{Level: sarifLevel(exportItem{Severity: it.Severity})},
+			Properties: sarifRuleProps{
+				Tags:             append([]string{"security"}, it.Tags...),
+				SecuritySeverity: sarifSecuritySeverity(it.Severity),
+				Benchmark:        it.Benchmark,
+				Checks:           it.Checks,
+			},
+		}
+		if it.Recommendation != "" {
+			rule.Help = &sarifText{Text: it.Recommendation}
+		}
+		for _, ref := range it.References {
+			if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+				rule.HelpURI = ref
+				break
+			}
+		}
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+
+		if it.Result != ResultFail && it.Result != ResultWarn && it.Result != ResultWaived {
+			continue
+		}
+		msg := it.Title
+		if it.Details != "" {
+			msg += ": " + it.Details
+		}
+		fp := sha256.Sum256([]byte(host + "|" + it.ID))
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    it.ID,
+			RuleIndex: i,
+			Level:     sarifLevel(it),
+			Message:   sarifText{Text: msg},
+			Locations: []sarifLocation{{
+				// Findings describe a host rather than a source file; the
+				// host name stands in for the artifact.
+				PhysicalLocation: sarifPhysical{ArtifactLocation: sarifArtifact{URI: host}},
+				LogicalLocations: []sarifLogical{{Name: host, Kind: "host"}},
+			}},
+			PartialFingerprints: map[string]string{"fortisFinding/v1": hex.EncodeToString(fp[:16])},
+		})
+		if it.Result == ResultWaived {
+			last := &run.Results[len(run.Results)-1]
+			last.Suppressions = []sarifSuppression{{Kind: "external", Status: "accepted", Justification: it.Justification}}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// JUnit XML: one test case per item. Failing and warning items are
+// failures so CI jobs stop on findings; skipped and waived checks are
+// skipped tests.
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Hostname   string          `xml:"hostname,attr,omitempty"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func renderJUnit(w io.Writer, doc exportDoc) error {
+	suite := junitSuite{
+		Name:      doc.Suite,
+		Hostname:  doc.Host,
+		Timestamp: doc.Timestamp.UTC().Format("2006-01-02T15:04:05"),
+		Time:      "0",
+	}
+	for _, m := range doc.Meta {
+		suite.Properties = append(suite.Properties, junitProperty{Name: m.Key, Value: m.Value})
+	}
+	for _, it := range doc.Items {
+		// Checks group by ID prefix (ssh, sysctl); control IDs such as
+		// 164.312(b) do not split meaningfully.
+		class := doc.Suite
+		if it.Status == "" {
+			class = it.ID
+			if i := strings.IndexByte(class, '.'); i > 0 {
+				class = class[:i]
+			}
+		}
+		tc := junitCase{ClassName: class, Name: it.ID + ": " + it.Title, Time: "0"}
+		switch it.Result {
+		case ResultFail, ResultWarn:
+			body := it.Details
+			if it.Recommendation != "" {
+				body = strings.TrimSpace(body + "\nRecommendation: " + it.Recommendation)
+			}
+			msg := it.Details
+			if msg == "" {
+				msg = it.Title
+			}
+			typ := string(it.Severity)
+			if it.Result == ResultWarn {
+				typ = "warning"
+			}
+			tc.Failure = &junitFailure{Message: msg, Type: typ, Body: body}
+			suite.Failures++
+		case ResultSkip, ResultWaived:
+			msg := it.Details
+			if it.Status != "" {
+				msg = strings.TrimSuffix(it.Status+": "+msg, ": ")
+			}
+			tc.Skipped = &junitSkipped{Message: msg}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	out := junitSuites{
+		Name:     doc.Title,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func renderCSV(w io.Writer, doc exportDoc) error {
+	checks := doc.hasChecks()
+	header := []string{"id", "title", "result", "severity", "weight", "benchmark", "tags", "references", "details", "recommendation"}
+	if checks {
+		header = append(header, "checks")
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, it := range doc.Items {
+		row := []string{it.ID, it.Title, it.label(), string(it.Severity), fmt.Sprint(it.Weight), it.Benchmark,
+			strings.Join(it.Tags, ";"), strings.Join(it.References, ";"), it.Details, it.Recommendation}
+		if checks {
+			row = append(row, strings.Join(it.Checks, ";"))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func renderMarkdown(w io.Writer, doc exportDoc) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", doc.Title)
+	fmt.Fprintf(&b, "- **Timestamp:** %s\n", doc.Timestamp.Format(time.RFC3339))
+	for _, m := range doc.Meta {
+		fmt.Fprintf(&b, "- **%s:** %s\n", m.Key, mdEscape(m.Value))
+	}
+
+	b.WriteString("\n## Findings\n\n")
+	if len(doc.Items) == 0 {
+		b.WriteString("No findings.\n")
+	} else {
+		b.WriteString("| ID | Result | Severity | Title | Details |\n|---|---|---|---|---|\n")
+		for _, it := range doc.Items {
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", it.ID, mdResult(it), it.Severity, mdEscape(it.Title), mdEscape(it.Details))
+		}
+	}
+
+	var recs []exportItem
+	for _, it := range doc.Items {
+		if (it.Result == ResultFail || it.Result == ResultWarn) && it.Recommendation != "" {
+			recs = append(recs, it)
+		}
+	}
+	if len(recs) > 0 {
+		b.WriteString("\n## Recommendations\n\n")
+		for _, it := range recs {
+			fmt.Fprintf(&b, "- **%s** (%s): %s\n", it.ID, it.Title, mdEscape(it.Recommendation))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mdResult(it exportItem) string {
+	switch it.Result {
+	case ResultPass:
+		return "✅ " + it.label()
+	case ResultFail:
+		return "❌ " + it.label()
+	case ResultWarn:
+		return "⚠️ " + it.label()
+	case ResultSkip:
+		return "⏭️ " + it.label()
+	case ResultWaived:
+		return "🛡️ " + it.label()
+	default:
+		return it.label()
+	}
+}
+
+var mdEscaper = strings.NewReplacer("|", `\|`, "\r", "", "\n", "<br>")
+
+func mdEscape(s string) string {
+	return mdEscaper.Replace(s)
+}
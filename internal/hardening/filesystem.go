@@ -10,6 +10,9 @@ import (
 
 type FilesystemOptions struct {
 	Root string
+	// Limit caps each file list; 0 means 200 and a negative value lists
+	// every file, as baselines need.
+	Limit int
 }
 
 type FilesystemReport struct {
@@ -18,6 +21,7 @@ type FilesystemReport struct {
 	SGIDFiles        []string `json:"sgid_files" yaml:"sgid_files"`
 	WorldWritable    []string `json:"world_writable" yaml:"world_writable"`
 	MountFSTabIssues []string `json:"fstab_issues" yaml:"fstab_issues"`
+	Truncated        bool     `json:"truncated,omitempty" yaml:"truncated,omitempty"`
 }
 
 func ScanFilesystem(ctx context.Context, opts FilesystemOptions) (FilesystemReport, error) {
@@ -31,7 +35,17 @@ func ScanFilesystem(ctx context.Context, opts FilesystemOptions) (FilesystemRepo
 		return rep, nil
 	}
 
-	limit := 200
+	limit := opts.Limit
+	if limit == 0 {
+		limit = 200
+	}
+	add := func(list *[]string, path string) {
+		if limit < 0 || len(*list) < limit {
+			*list = append(*list, path)
+		} else {
+			rep.Truncated = true
+		}
+	}
 	_ = filepath.WalkDir(opts.Root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -50,19 +64,13 @@ func ScanFilesystem(ctx context.Context, opts FilesystemOptions) (FilesystemRepo
 		}
 		mode := info.Mode()
 		if mode&os.ModeSetuid != 0 {
-			if len(rep.SUIDFiles) < limit {
-				rep.SUIDFiles = append(rep.SUIDFiles, path)
-			}
+			add(&rep.SUIDFiles, path)
 		}
 		if mode&os.ModeSetgid != 0 {
-			if len(rep.SGIDFiles) < limit {
-				rep.SGIDFiles = append(rep.SGIDFiles, path)
-			}
+			add(&rep.SGIDFiles, path)
 		}
 		if mode.Perm()&0o002 != 0 {
-			if len(rep.WorldWritable) < limit {
-				rep.WorldWritable = append(rep.WorldWritable, path)
-			}
+			add(&rep.WorldWritable, path)
 		}
 		return nil
 	})
@@ -0,0 +1,704 @@
+package hardening
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"fortis-admin/internal/signing"
+)
+
+// DefaultFIMDatabase is where "harden fim init" writes the baseline. The
+// signature, when the database is signed, is stored next to it as .sig.
+const DefaultFIMDatabase = "/var/lib/fortis/fim.db"
+
+//go:embed fim/default.yaml
+var defaultFIMRules []byte
+
+// fimAttributes are the attributes a rule can watch, in report order.
+var fimAttributes = []string{"type", "perm", "uid", "gid", "size", "mtime", "sha256", "xattrs", "target"}
+
+var fimBuiltinGroups = map[string][]string{
+	"all":   fimAttributes,
+	"perms": {"type", "perm", "uid", "gid", "xattrs", "target"},
+}
+
+// fimSkipDirs are never descended into: their contents are not files.
+var fimSkipDirs = map[string]bool{"/proc": true, "/sys": true, "/dev": true, "/run": true}
+
+// FIMRules selects the paths under integrity monitoring and, per path,
+// the attributes that count as a change.
+//
+//	groups:
+//	  logs: [perms]
+//	rules:
+//	  - path: /etc
+//	    attrs: [all]
+//	    exclude: [/etc/mtab, "*.swp"]
+//	  - path: /var/log
+//	    attrs: [all, -mtime, -size, -sha256]
+//	  - path: /etc/fortis
+//	    attrs: [perms]
+//	    recurse: false
+type FIMRules struct {
+	Groups map[string][]string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Rules  []FIMRule           `json:"rules" yaml:"rules"`
+	Source string              `json:"source" yaml:"-"`
+}
+
+type FIMRule struct {
+	Path    string   `json:"path" yaml:"path"`
+	Attrs   []string `json:"attrs" yaml:"attrs"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	// Recurse defaults to true; false watches the path itself only.
+	Recurse *bool `json:"recurse,omitempty" yaml:"recurse,omitempty"`
+
+	watch map[string]bool
+}
+
+func (r FIMRule) recurse() bool { return r.Recurse == nil || *r.Recurse }
+
+// excluded matches p against the rule's exclude patterns: a pattern with
+// a "/" matches the full path, any other the base name.
+func (r FIMRule) excluded(p string) bool {
+	for _, pat := range r.Exclude {
+		target := path.Base(p)
+		if strings.Contains(pat, "/") {
+			target = p
+		}
+		if ok, _ := path.Match(pat, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare validates the rules and expands groups into attribute sets.
+func (rs *FIMRules) prepare() error {
+	known := map[string]bool{}
+	for _, a := range fimAttributes {
+		known[a] = true
+	}
+	var expand func(name string, seen map[string]bool) ([]string, error)
+	expand = func(name string, seen map[string]bool) ([]string, error) {
+		if known[name] {
+			return []string{name}, nil
+		}
+		members, ok := rs.Groups[name]
+		if !ok {
+			members, ok = fimBuiltinGroups[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute or group %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("group %q includes itself", name)
+		}
+		seen[name] = true
+		defer delete(seen, name)
+		var out []string
+		for _, m := range members {
+			attrs, err := expand(strings.TrimSpace(m), seen)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, attrs...)
+		}
+		return out, nil
+	}
+
+	if len(rs.Rules) == 0 {
+		return errors.New("no rules")
+	}
+	paths := map[string]bool{}
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if !filepath.IsAbs(r.Path) {
+			return fmt.Errorf("rule %d: path %q is not absolute", i+1, r.Path)
+		}
+		r.Path = filepath.Clean(r.Path)
+		if paths[r.Path] {
+			return fmt.Errorf("rule %d: %s has more than one rule", i+1, r.Path)
+		}
+		paths[r.Path] = true
+		for _, pat := range r.Exclude {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("%s: exclude %q: %w", r.Path, pat, err)
+			}
+		}
+		r.watch = map[string]bool{}
+		for _, a := range r.Attrs {
+			a = strings.TrimSpace(a)
+			name, remove := strings.CutPrefix(a, "-")
+			attrs, err := expand(name, map[string]bool{})
+			if err != nil {
+				return fmt.Errorf("%s: %w", r.Path, err)
+			}
+			for _, x := range attrs {
+				r.watch[x] = !remove
+			}
+		}
+		for a, on := range r.watch {
+			if !on {
+				delete(r.watch, a)
+			}
+		}
+		if len(r.watch) == 0 {
+			return fmt.Errorf("%s: no attributes to watch", r.Path)
+		}
+	}
+	sort.Slice(rs.Rules, func(i, j int) bool { return rs.Rules[i].Path < rs.Rules[j].Path })
+	return nil
+}
+
+func (rs *FIMRules) rule(p string) *FIMRule {
+	for i := range rs.Rules {
+		if rs.Rules[i].Path == p {
+			return &rs.Rules[i]
+		}
+	}
+	return nil
+}
+
+func parseFIMRules(source string, b []byte) (FIMRules, error) {
+	var rs FIMRules
+	if err := yaml.Unmarshal(b, &rs); err != nil {
+		return FIMRules{}, fmt.Errorf("%s: %w", source, err)
+	}
+	rs.Source = source
+	if err := rs.prepare(); err != nil {
+		return FIMRules{}, fmt.Errorf("%s: %w", source, err)
+	}
+	return rs, nil
+}
+
+// LoadFIMRules reads file, or <config-dir>/fim.yaml, or the built-in rules.
+func LoadFIMRules(configDir, file string) (FIMRules, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return FIMRules{}, err
+		}
+		return parseFIMRules(file, b)
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		p := filepath.Join(resolveConfigDir(configDir), "fim"+ext)
+		b, err := os.ReadFile(p)
+		if err == nil {
+			return parseFIMRules(p, b)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return FIMRules{}, err
+		}
+	}
+	return parseFIMRules("builtin:fim.yaml", defaultFIMRules)
+}
+
+// FIMEntry is one file in the database. Attrs holds the watched
+// attributes of its rule, formatted as strings.
+type FIMEntry struct {
+	Path  string            `json:"path"`
+	Rule  string            `json:"rule"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+// FIMDatabase is stored gzip-compressed JSON. Check scans with the rules
+// recorded here, so editing fim.yaml takes effect at the next init.
+type FIMDatabase struct {
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	Hostname  string     `json:"hostname"`
+	Rules     FIMRules   `json:"rules"`
+	Entries   []FIMEntry `json:"entries"`
+	// SignedBy records the signing key so a removed .sig is noticed.
+	SignedBy string `json:"signed_by,omitempty"`
+}
+
+const fimDatabaseVersion = 1
+
+// FIMSignature is stored as <database>.sig.
+type FIMSignature struct {
+	Algorithm      string    `json:"algorithm"`
+	KeyID          string    `json:"key_id"`
+	DatabaseSHA256 string    `json:"database_sha256"`
+	SignedAt       time.Time `json:"signed_at"`
+	Signature      string    `json:"signature"`
+}
+
+func (s FIMSignature) payload() []byte {
+	return []byte(fmt.Sprintf("fortis-fim-sig-v1\n%s\n", s.DatabaseSHA256))
+}
+
+type FIMOptions struct {
+	ConfigDir string
+	// RulesFile replaces <config-dir>/fim.yaml for init.
+	RulesFile string
+	// Database defaults to DefaultFIMDatabase.
+	Database string
+	// SigningKey signs the database written by init or check --update.
+	SigningKey ed25519.PrivateKey
+	// TrustedKeys verify the database signature; the signing key's public
+	// half is trusted as well.
+	TrustedKeys      []ed25519.PublicKey
+	RequireSignature bool
+	// Force lets init replace an existing database.
+	Force bool
+	// Update makes check record the current state as the new baseline.
+	Update bool
+}
+
+func (o FIMOptions) database() string {
+	if o.Database == "" {
+		return DefaultFIMDatabase
+	}
+	return o.Database
+}
+
+type FIMInitResult struct {
+	Database string   `json:"database"`
+	Rules    string   `json:"rules"`
+	Files    int      `json:"files"`
+	Signed   bool     `json:"signed"`
+	KeyID    string   `json:"key_id,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// FIMReport is the result of an integrity check, shaped for forwarding
+// to a SIEM as one JSON document.
+type FIMReport struct {
+	Hostname   string      `json:"hostname"`
+	Database   string      `json:"database"`
+	BaselineAt time.Time   `json:"baseline_created_at"`
+	CheckedAt  time.Time   `json:"checked_at"`
+	Signature  string      `json:"signature"`
+	KeyID      string      `json:"key_id,omitempty"`
+	Files      int         `json:"files"`
+	Added      int         `json:"added"`
+	Removed    int         `json:"removed"`
+	Changed    int         `json:"changed"`
+	Changes    []FIMChange `json:"changes"`
+	Errors     []string    `json:"errors,omitempty"`
+	Updated    bool        `json:"updated,omitempty"`
+}
+
+const (
+	FIMAdded   = "added"
+	FIMRemoved = "removed"
+	FIMChanged = "changed"
+)
+
+type FIMChange struct {
+	Path       string          `json:"path"`
+	Change     string          `json:"change"`
+	Rule       string          `json:"rule"`
+	Attributes []FIMAttrChange `json:"attributes,omitempty"`
+}
+
+type FIMAttrChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// InitFIM scans the configured paths and writes a new database.
+func InitFIM(ctx context.Context, opts FIMOptions) (FIMInitResult, error) {
+	dbPath := opts.database()
+	if _, err := os.Stat(dbPath); err == nil && !opts.Force {
+		return FIMInitResult{}, fmt.Errorf("%s already exists; use \"fim check --update\" to accept changes or --force to rebuild", dbPath)
+	}
+	rules, err := LoadFIMRules(opts.ConfigDir, opts.RulesFile)
+	if err != nil {
+		return FIMInitResult{}, err
+	}
+	entries, scanErrs, err := scanFIM(ctx, &rules)
+	if err != nil {
+		return FIMInitResult{}, err
+	}
+	keyID, err := writeFIMDatabase(dbPath, rules, entries, opts.SigningKey)
+	if err != nil {
+		return FIMInitResult{}, err
+	}
+	return FIMInitResult{
+		Database: dbPath,
+		Rules:    rules.Source,
+		Files:    len(entries),
+		Signed:   keyID != "",
+		KeyID:    keyID,
+		Errors:   scanErrs,
+	}, nil
+}
+
+// CheckFIM verifies the database, rescans with its rules and reports
+// added, removed and changed files. A database that fails verification
+// is an error: its contents cannot be trusted as a baseline. Once keys are
+// configured, or the database was signed, an unsigned database fails too.
+func CheckFIM(ctx context.Context, opts FIMOptions) (FIMReport, error) {
+	dbPath := opts.database()
+	trusted := opts.TrustedKeys
+	if opts.SigningKey != nil {
+		trusted = append(trusted, opts.SigningKey.Public().(ed25519.PublicKey))
+	}
+	db, status, keyID, err := readFIMDatabase(dbPath, trusted)
+	if err != nil {
+		return FIMReport{}, err
+	}
+	// Unsigned databases are accepted only when no key is configured at
+	// all; a signature from an unknown key is never accepted.
+	switch {
+	case status == EvidenceUntrusted:
+		return FIMReport{}, fmt.Errorf("%s is signed by key %s, which is not trusted", dbPath, keyID)
+	case status == EvidenceUnsigned && db.SignedBy != "":
+		return FIMReport{}, fmt.Errorf("%s was signed by key %s but its signature is missing", dbPath, db.SignedBy)
+	case status == EvidenceUnsigned && (len(trusted) > 0 || opts.RequireSignature):
+		return FIMReport{}, fmt.Errorf("%s is not signed by a trusted key (%s)", dbPath, status)
+	}
+	if opts.Update && status != EvidenceUnsigned && opts.SigningKey == nil {
+		return FIMReport{}, fmt.Errorf("%s is signed; a signing key is required to update it", dbPath)
+	}
+	if err := db.Rules.prepare(); err != nil {
+		return FIMReport{}, fmt.Errorf("%s: %w", dbPath, err)
+	}
+	entries, scanErrs, err := scanFIM(ctx, &db.Rules)
+	if err != nil {
+		return FIMReport{}, err
+	}
+	rep := FIMReport{
+		Hostname:   db.Hostname,
+		Database:   dbPath,
+		BaselineAt: db.CreatedAt,
+		CheckedAt:  time.Now().UTC(),
+		Signature:  status,
+		KeyID:      keyID,
+		Files:      len(entries),
+		Changes:    compareFIM(&db.Rules, db.Entries, entries),
+		Errors:     scanErrs,
+	}
+	for _, c := range rep.Changes {
+		switch c.Change {
+		case FIMAdded:
+			rep.Added++
+		case FIMRemoved:
+			rep.Removed++
+		default:
+			rep.Changed++
+		}
+	}
+	if opts.Update && len(rep.Changes) > 0 {
+		if _, err := writeFIMDatabase(dbPath, db.Rules, entries, opts.SigningKey); err != nil {
+			return rep, err
+		}
+		rep.Updated = true
+	}
+	return rep, nil
+}
+
+func compareFIM(rules *FIMRules, old, cur []FIMEntry) []FIMChange {
+	before := make(map[string]FIMEntry, len(old))
+	for _, e := range old {
+		before[e.Path] = e
+	}
+	changes := []FIMChange{}
+	seen := make(map[string]bool, len(cur))
+	for _, e := range cur {
+		seen[e.Path] = true
+		o, ok := before[e.Path]
+		if !ok {
+			changes = append(changes, FIMChange{Path: e.Path, Change: FIMAdded, Rule: e.Rule})
+			continue
+		}
+		var attrs []FIMAttrChange
+		for _, a := range fimAttributes {
+			if r := rules.rule(e.Rule); r == nil || !r.watch[a] {
+				continue
+			}
+			if o.Attrs[a] != e.Attrs[a] {
+				attrs = append(attrs, FIMAttrChange{Name: a, Old: o.Attrs[a], New: e.Attrs[a]})
+			}
+		}
+		if len(attrs) > 0 {
+			changes = append(changes, FIMChange{Path: e.Path, Change: FIMChanged, Rule: e.Rule, Attributes: attrs})
+		}
+	}
+	for _, o := range old {
+		if !seen[o.Path] {
+			changes = append(changes, FIMChange{Path: o.Path, Change: FIMRemoved, Rule: o.Rule})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// scanFIM walks every rule's path. A directory that has a rule of its own
+// is left to that rule, so each file is recorded once, under the most
+// specific rule. Unreadable files are recorded with what could be read
+// and reported in the returned errors.
+func scanFIM(ctx context.Context, rules *FIMRules) ([]FIMEntry, []string, error) {
+	var entries []FIMEntry
+	var errs []string
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		err := filepath.WalkDir(r.Path, func(p string, d fs.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					errs = append(errs, err.Error())
+				}
+				return nil
+			}
+			if p != r.Path && (rules.rule(p) != nil || fimSkipDirs[p] || r.excluded(p)) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			e, err := fimEntry(p, r)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			if e.Attrs != nil {
+				entries = append(entries, e)
+			}
+			if d.IsDir() && p == r.Path && !r.recurse() {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, errs, nil
+}
+
+func fimEntry(p string, r *FIMRule) (FIMEntry, error) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return FIMEntry{}, err
+	}
+	e := FIMEntry{Path: p, Rule: r.Path, Attrs: map[string]string{}}
+	set := func(name, v string) {
+		if r.watch[name] {
+			e.Attrs[name] = v
+		}
+	}
+	typ := "other"
+	switch m := fi.Mode(); {
+	case m.IsRegular():
+		typ = "file"
+	case m.IsDir():
+		typ = "dir"
+	case m&fs.ModeSymlink != 0:
+		typ = "symlink"
+	}
+	set("type", typ)
+	set("perm", fmt.Sprintf("%04o", unixPerm(fi.Mode())))
+	if uid, gid, ok := fileOwner(fi); ok {
+		set("uid", strconv.FormatUint(uint64(uid), 10))
+		set("gid", strconv.FormatUint(uint64(gid), 10))
+	}
+	if typ == "file" {
+		set("size", strconv.FormatInt(fi.Size(), 10))
+	}
+	if typ != "dir" {
+		set("mtime", fi.ModTime().UTC().Format(time.RFC3339Nano))
+	}
+	if typ == "symlink" && r.watch["target"] {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return e, err
+		}
+		set("target", target)
+	}
+	if typ != "symlink" && r.watch["xattrs"] {
+		xa, err := readXattrs(p)
+		if err != nil {
+			return e, err
+		}
+		set("xattrs", xa)
+	}
+	if typ == "file" && r.watch["sha256"] {
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return e, err
+		}
+		set("sha256", sum)
+	}
+	return e, nil
+}
+
+// unixPerm returns the permission bits including setuid, setgid and
+// sticky, which os.FileMode keeps outside ModePerm.
+func unixPerm(m fs.FileMode) uint32 {
+	perm := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	return perm
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFIMDatabase writes the database and, with a key, its signature.
+// A stale signature from an earlier database is removed.
+func writeFIMDatabase(dbPath string, rules FIMRules, entries []FIMEntry, key ed25519.PrivateKey) (string, error) {
+	host, _ := os.Hostname()
+	db := FIMDatabase{
+		Version:   fimDatabaseVersion,
+		CreatedAt: time.Now().UTC(),
+		Hostname:  host,
+		Rules:     rules,
+		Entries:   entries,
+	}
+	if db.Entries == nil {
+		db.Entries = []FIMEntry{}
+	}
+	if key != nil {
+		db.SignedBy = signing.PrivateKeyID(key)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(db); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o700); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(dbPath, buf.Bytes()); err != nil {
+		return "", err
+	}
+	sigPath := dbPath + ".sig"
+	if key == nil {
+		if err := os.Remove(sigPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		return "", nil
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	sig := FIMSignature{
+		Algorithm:      signing.Algorithm,
+		KeyID:          db.SignedBy,
+		DatabaseSHA256: hex.EncodeToString(sum[:]),
+		SignedAt:       db.CreatedAt,
+	}
+	sig.Signature = signing.Sign(key, sig.payload())
+	b, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(sigPath, append(b, '\n')); err != nil {
+		return "", err
+	}
+	return sig.KeyID, nil
+}
+
+func writeFileAtomic(p string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// readFIMDatabase loads the database after checking its signature. It
+// returns the verification status (verified, unsigned, untrusted-key)
+// and the signing key ID.
+func readFIMDatabase(dbPath string, trusted []ed25519.PublicKey) (FIMDatabase, string, string, error) {
+	raw, err := os.ReadFile(dbPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return FIMDatabase{}, "", "", fmt.Errorf("no file integrity database at %s; run \"fortis harden fim init\" first", dbPath)
+		}
+		return FIMDatabase{}, "", "", err
+	}
+	status, keyID := EvidenceUnsigned, ""
+	sigBytes, err := os.ReadFile(dbPath + ".sig")
+	switch {
+	case err == nil:
+		var sig FIMSignature
+		if err := json.Unmarshal(sigBytes, &sig); err != nil {
+			return FIMDatabase{}, "", "", fmt.Errorf("%s.sig: %w", dbPath, err)
+		}
+		sum := sha256.Sum256(raw)
+		if sig.DatabaseSHA256 != hex.EncodeToString(sum[:]) {
+			return FIMDatabase{}, "", "", fmt.Errorf("%s does not match its signature: the database was modified", dbPath)
+		}
+		keyID = sig.KeyID
+		switch err := signing.Verify(trusted, sig.KeyID, sig.payload(), sig.Signature); {
+		case err == nil:
+			status = EvidenceVerified
+		case errors.Is(err, signing.ErrUntrusted):
+			status = EvidenceUntrusted
+		default:
+			return FIMDatabase{}, "", "", fmt.Errorf("%s: %w for key %s", dbPath, err, sig.KeyID)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return FIMDatabase{}, "", "", err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return FIMDatabase{}, "", "", fmt.Errorf("%s: %w", dbPath, err)
+	}
+	defer gz.Close()
+	var db FIMDatabase
+	if err := json.NewDecoder(gz).Decode(&db); err != nil {
+		return FIMDatabase{}, "", "", fmt.Errorf("%s: %w", dbPath, err)
+	}
+	if db.Version != fimDatabaseVersion {
+		return FIMDatabase{}, "", "", fmt.Errorf("%s: unsupported database version %d", dbPath, db.Version)
+	}
+	return db, status, keyID, nil
+}
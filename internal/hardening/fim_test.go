@@ -0,0 +1,292 @@
+package hardening
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fortis-admin/internal/signing"
+)
+
+// fimFixture is a watched directory, a rules file for it and the path
+// of a database that has not been written yet.
+type fimFixture struct {
+	watched, rules, db string
+}
+
+func newFIMFixture(t *testing.T) fimFixture {
+	t.Helper()
+	dir := t.TempDir()
+	f := fimFixture{
+		watched: filepath.Join(dir, "watched"),
+		rules:   filepath.Join(dir, "fim.yaml"),
+		db:      filepath.Join(dir, "db", "fim.db"),
+	}
+	if err := os.MkdirAll(f.watched, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.watched, "app.conf"), []byte("a=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := "rules:\n  - path: " + f.watched + "\n    attrs: [all, -mtime]\n"
+	if err := os.WriteFile(f.rules, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f fimFixture) init(t *testing.T, key ed25519.PrivateKey) {
+	t.Helper()
+	res, err := InitFIM(context.Background(), FIMOptions{RulesFile: f.rules, Database: f.db, SigningKey: key, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 2 || res.Signed != (key != nil) {
+		t.Fatalf("init: %+v", res)
+	}
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// rewriteFIMDatabase decodes the database, lets edit change it and
+// writes it back unsigned, leaving any signature file in place.
+func rewriteFIMDatabase(t *testing.T, dbPath string, edit func(*FIMDatabase)) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var db FIMDatabase
+	if err := json.NewDecoder(gz).Decode(&db); err != nil {
+		t.Fatal(err)
+	}
+	edit(&db)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbPath, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// dropEntries removes the baseline of every watched file, so a tampered
+// database would hide changes to them.
+func dropEntries(db *FIMDatabase) { db.Entries = []FIMEntry{} }
+
+func TestCheckFIMSignatures(t *testing.T) {
+	pub, priv := newKey(t)
+	otherPub, otherPriv := newKey(t)
+
+	tests := []struct {
+		name   string
+		signer ed25519.PrivateKey
+		tamper func(t *testing.T, f fimFixture)
+		opts   FIMOptions
+		status string
+		err    string
+	}{
+		{name: "unsigned without keys", status: EvidenceUnsigned},
+		{name: "verified", signer: priv, opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}}, status: EvidenceVerified},
+		{name: "verified by the signing key", signer: priv, opts: FIMOptions{SigningKey: priv}, status: EvidenceVerified},
+		{name: "verified among several keys", signer: priv, opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{otherPub, pub}}, status: EvidenceVerified},
+		{
+			name:   "database modified after signing",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) { rewriteFIMDatabase(t, f.db, dropEntries) },
+			opts:   FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}},
+			err:    "does not match its signature: the database was modified",
+		},
+		{
+			name:   "digest in the signature file updated",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) {
+				raw := rewriteFIMDatabase(t, f.db, dropEntries)
+				editSignature(t, f.db, func(s *FIMSignature) {
+					sum := sha256.Sum256(raw)
+					s.DatabaseSHA256 = hex.EncodeToString(sum[:])
+				})
+			},
+			opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}},
+			err:  signing.ErrInvalid.Error() + " for key " + signing.KeyID(pub),
+		},
+		{
+			name:   "forged signature",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) {
+				editSignature(t, f.db, func(s *FIMSignature) { s.Signature = strings.Repeat("A", len(s.Signature)) })
+			},
+			opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}},
+			err:  signing.ErrInvalid.Error() + " for key " + signing.KeyID(pub),
+		},
+		{
+			name:   "re-signed with another key",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) {
+				rules, err := LoadFIMRules("", f.rules)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := writeFIMDatabase(f.db, rules, nil, otherPriv); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}},
+			err:  "is signed by key " + signing.KeyID(otherPub) + ", which is not trusted",
+		},
+		{name: "signed but no keys configured", signer: priv, err: "which is not trusted"},
+		{
+			name:   "signature removed",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) {
+				if err := os.Remove(f.db + ".sig"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "was signed by key " + signing.KeyID(pub) + " but its signature is missing",
+		},
+		{
+			name:   "signature removed and signer erased",
+			signer: priv,
+			tamper: func(t *testing.T, f fimFixture) {
+				rewriteFIMDatabase(t, f.db, func(db *FIMDatabase) { db.SignedBy = "" })
+				if err := os.Remove(f.db + ".sig"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}},
+			err:  "is not signed by a trusted key (unsigned)",
+		},
+		{name: "unsigned with trusted keys", opts: FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}}, err: "is not signed by a trusted key"},
+		{name: "unsigned with a signing key", opts: FIMOptions{SigningKey: priv}, err: "is not signed by a trusted key"},
+		{name: "unsigned when a signature is required", opts: FIMOptions{RequireSignature: true}, err: "is not signed by a trusted key"},
+		{
+			name:   "update without a signing key",
+			signer: priv,
+			opts:   FIMOptions{TrustedKeys: []ed25519.PublicKey{pub}, Update: true},
+			err:    "a signing key is required to update it",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFIMFixture(t)
+			f.init(t, tt.signer)
+			if tt.tamper != nil {
+				tt.tamper(t, f)
+			}
+			opts := tt.opts
+			opts.RulesFile, opts.Database = f.rules, f.db
+			rep, err := CheckFIM(context.Background(), opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Signature != tt.status || len(rep.Changes) != 0 {
+				t.Errorf("signature %s with %d changes, want %s and none", rep.Signature, len(rep.Changes), tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckFIMUpdateKeepsSignature(t *testing.T) {
+	pub, priv := newKey(t)
+	f := newFIMFixture(t)
+	f.init(t, priv)
+	if err := os.WriteFile(filepath.Join(f.watched, "app.conf"), []byte("a=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.watched, "new.conf"), []byte("n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := FIMOptions{Database: f.db, TrustedKeys: []ed25519.PublicKey{pub}}
+	rep, err := CheckFIM(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Signature != EvidenceVerified || rep.Added != 1 || rep.Changed != 1 || rep.Removed != 0 || rep.Updated {
+		t.Fatalf("report: %+v", rep)
+	}
+	for _, c := range rep.Changes {
+		if c.Path == filepath.Join(f.watched, "app.conf") && (len(c.Attributes) != 1 || c.Attributes[0].Name != "sha256") {
+			t.Errorf("app.conf changed %+v, want sha256 only", c.Attributes)
+		}
+	}
+
+	opts.SigningKey, opts.Update = priv, true
+	if rep, err = CheckFIM(context.Background(), opts); err != nil || !rep.Updated {
+		t.Fatalf("update: %+v, %v", rep, err)
+	}
+	opts.SigningKey, opts.Update = nil, false
+	rep, err = CheckFIM(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Signature != EvidenceVerified || len(rep.Changes) != 0 {
+		t.Errorf("after update: signature %s with %d changes, want verified and none", rep.Signature, len(rep.Changes))
+	}
+}
+
+func TestInitFIMRefusesExistingDatabase(t *testing.T) {
+	f := newFIMFixture(t)
+	f.init(t, nil)
+	if _, err := InitFIM(context.Background(), FIMOptions{RulesFile: f.rules, Database: f.db}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("err = %v, want already exists", err)
+	}
+	// Rebuilding unsigned drops the signature of a signed database.
+	_, priv := newKey(t)
+	f.init(t, priv)
+	f.init(t, nil)
+	if _, err := os.Stat(f.db + ".sig"); !os.IsNotExist(err) {
+		t.Errorf("stale signature kept: %v", err)
+	}
+}
+
+// editSignature rewrites the database's signature file.
+func editSignature(t *testing.T, dbPath string, edit func(*FIMSignature)) {
+	t.Helper()
+	b, err := os.ReadFile(dbPath + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig FIMSignature
+	if err := json.Unmarshal(b, &sig); err != nil {
+		t.Fatal(err)
+	}
+	edit(&sig)
+	if b, err = json.Marshal(sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbPath+".sig", b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
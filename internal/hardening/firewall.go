@@ -4,65 +4,473 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type FirewallOptions struct {
-	Profile   string
-	Ports     string
+	Profile string
+	Ports   string
+	// Direction the --ports rules apply to: incoming, outgoing or both.
 	Direction string
-	Save      bool
-	Yes       bool
-	DryRun    bool
+	// Sources restricts the --ports rules to these CIDRs (the remote side:
+	// source of incoming, destination of outgoing traffic).
+	Sources []string
+	// DefaultIncoming and DefaultOutgoing are the chain policies (drop or
+	// accept); empty keeps the policy file's or drop/accept.
+	DefaultIncoming string
+	DefaultOutgoing string
+	// PolicyFile is a YAML FirewallPolicy. Empty uses
+	// <ConfigDir>/firewall/<profile>.yaml when it exists.
+	PolicyFile string
+	ConfigDir  string
+	// Backend overrides detection (nftables, iptables, ufw).
+	Backend string
+	// NoSSHGuard skips the rule that keeps sshd reachable.
+	NoSSHGuard bool
+	// ConfirmTimeout arms a timer that restores the previous ruleset unless
+	// the change is confirmed ("harden firewall confirm") in time. Zero
+	// disables it.
+	ConfirmTimeout time.Duration
+	Save           bool
+	Yes            bool
+	DryRun         bool
+	// RollbackDir receives the transaction; empty uses DefaultRollbackDir.
+	RollbackDir string
 }
 
 type FirewallResult struct {
-	Backend string
-	Plan    []string
+	Backend       string
+	Policy        FirewallPolicy
+	Plan          []string
+	Ruleset       string
+	Diff          []string
+	TransactionID string
+	// ConfirmBy is when the revert timer fires; zero when none is armed.
+	ConfirmBy time.Time
+}
+
+// FirewallPolicy is a complete host firewall: chain policies plus rules.
+// Connections already established, loopback traffic and the ICMP needed for
+// IPv6 neighbour discovery are always allowed.
+type FirewallPolicy struct {
+	DefaultIncoming string         `yaml:"default_incoming" json:"default_incoming"`
+	DefaultOutgoing string         `yaml:"default_outgoing" json:"default_outgoing"`
+	DefaultForward  string         `yaml:"default_forward" json:"default_forward"`
+	Rules           []FirewallRule `yaml:"rules" json:"rules"`
+}
+
+// FirewallRule matches traffic in one direction. Ports are single ports or
+// ranges ("8000-8080") and need protocol tcp or udp. Sources are CIDRs or
+// addresses of the remote side; empty matches any address.
+type FirewallRule struct {
+	Direction string   `yaml:"direction" json:"direction"`
+	Protocol  string   `yaml:"protocol" json:"protocol"`
+	Ports     []string `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Sources   []string `yaml:"sources,omitempty" json:"sources,omitempty"`
+	Action    string   `yaml:"action,omitempty" json:"action,omitempty"`
+	Comment   string   `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+// PortRange is an inclusive port range; From == To for a single port.
+type PortRange struct {
+	From, To int
+}
+
+func (p PortRange) String() string {
+	if p.From == p.To {
+		return strconv.Itoa(p.From)
+	}
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+func parsePortRange(s string) (PortRange, error) {
+	lo, hi, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		lo, hi, isRange = strings.Cut(strings.TrimSpace(s), ":")
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil || from < 1 || from > 65535 {
+		return PortRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	to := from
+	if isRange {
+		to, err = strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil || to < from || to > 65535 {
+			return PortRange{}, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return PortRange{From: from, To: to}, nil
+}
+
+func (r FirewallRule) portRanges() []PortRange {
+	out := make([]PortRange, 0, len(r.Ports))
+	for _, p := range r.Ports {
+		if pr, err := parsePortRange(p); err == nil {
+			out = append(out, pr)
+		}
+	}
+	return out
+}
+
+// normalizePolicyAction maps ufw-style words to nftables verdicts.
+func normalizePolicyAction(a string) string {
+	switch strings.ToLower(strings.TrimSpace(a)) {
+	case "allow", "accept":
+		return "accept"
+	case "deny", "drop":
+		return "drop"
+	case "reject":
+		return "reject"
+	}
+	return strings.ToLower(strings.TrimSpace(a))
+}
+
+func normalizeDirection(d string) string {
+	switch strings.ToLower(strings.TrimSpace(d)) {
+	case "", "in", "incoming", "input":
+		return "in"
+	case "out", "outgoing", "output":
+		return "out"
+	}
+	return strings.ToLower(strings.TrimSpace(d))
+}
+
+// Validate normalizes the policy in place and reports the first problem.
+func (p *FirewallPolicy) Validate() error {
+	for _, d := range []*string{&p.DefaultIncoming, &p.DefaultOutgoing, &p.DefaultForward} {
+		*d = normalizePolicyAction(*d)
+		if *d != "accept" && *d != "drop" {
+			return fmt.Errorf("default policy %q: want accept or drop", *d)
+		}
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		r.Direction = normalizeDirection(r.Direction)
+		if r.Direction != "in" && r.Direction != "out" {
+			return fmt.Errorf("rule %d: direction %q: want in or out", i+1, r.Direction)
+		}
+		r.Protocol = strings.ToLower(strings.TrimSpace(r.Protocol))
+		if r.Protocol == "" {
+			r.Protocol = "tcp"
+		}
+		switch r.Protocol {
+		case "tcp", "udp":
+		case "icmp", "icmpv6", "any":
+			if len(r.Ports) > 0 {
+				return fmt.Errorf("rule %d: ports need protocol tcp or udp", i+1)
+			}
+		default:
+			return fmt.Errorf("rule %d: protocol %q: want tcp, udp, icmp, icmpv6 or any", i+1, r.Protocol)
+		}
+		for j, port := range r.Ports {
+			pr, err := parsePortRange(port)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			r.Ports[j] = pr.String()
+		}
+		for j, s := range r.Sources {
+			cidr, err := normalizeCIDR(s)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			r.Sources[j] = cidr
+		}
+		if r.Action == "" {
+			r.Action = "accept"
+		}
+		r.Action = normalizePolicyAction(r.Action)
+		if r.Action != "accept" && r.Action != "drop" && r.Action != "reject" {
+			return fmt.Errorf("rule %d: action %q: want accept, drop or reject", i+1, r.Action)
+		}
+		if strings.ContainsAny(r.Comment, "\"'\n\\") {
+			return fmt.Errorf("rule %d: comment must not contain quotes, backslashes or newlines", i+1)
+		}
+	}
+	return nil
+}
+
+func normalizeCIDR(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid address or CIDR %q", s)
+	}
+	return n.String(), nil
+}
+
+func isIPv6CIDR(cidr string) bool { return strings.Contains(cidr, ":") }
+
+// LoadFirewallPolicy reads a YAML policy file.
+func LoadFirewallPolicy(path string) (FirewallPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return FirewallPolicy{}, err
+	}
+	var p FirewallPolicy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return FirewallPolicy{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.DefaultIncoming == "" {
+		p.DefaultIncoming = "drop"
+	}
+	if p.DefaultOutgoing == "" {
+		p.DefaultOutgoing = "accept"
+	}
+	if p.DefaultForward == "" {
+		p.DefaultForward = "drop"
+	}
+	if err := p.Validate(); err != nil {
+		return FirewallPolicy{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// firewallPolicyPath finds the policy file of a profile in the config
+// directory; empty when there is none.
+func firewallPolicyPath(configDir, profile string) string {
+	if profile == "" {
+		return ""
+	}
+	p := filepath.Join(resolveConfigDir(configDir), "firewall", profile+".yaml")
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// BuildFirewallPolicy assembles the policy from a policy file (explicit or
+// the profile's) and the --ports, --direction and --allow-from options.
+func BuildFirewallPolicy(opts FirewallOptions) (FirewallPolicy, error) {
+	pol := FirewallPolicy{DefaultIncoming: "drop", DefaultOutgoing: "accept", DefaultForward: "drop"}
+	file := opts.PolicyFile
+	if file == "" {
+		file = firewallPolicyPath(opts.ConfigDir, opts.Profile)
+	}
+	ports := parsePorts(opts.Ports)
+	if file != "" {
+		p, err := LoadFirewallPolicy(file)
+		if err != nil {
+			return pol, err
+		}
+		pol = p
+	} else if len(ports) == 0 {
+		ports = defaultPortsForProfile(opts.Profile)
+	}
+	if opts.DefaultIncoming != "" {
+		pol.DefaultIncoming = opts.DefaultIncoming
+	}
+	if opts.DefaultOutgoing != "" {
+		pol.DefaultOutgoing = opts.DefaultOutgoing
+	}
+
+	var dirs []string
+	switch strings.ToLower(strings.TrimSpace(opts.Direction)) {
+	case "", "in", "incoming":
+		dirs = []string{"in"}
+	case "out", "outgoing":
+		dirs = []string{"out"}
+	case "both":
+		dirs = []string{"in", "out"}
+	default:
+		return pol, fmt.Errorf("--direction %q: want incoming, outgoing or both", opts.Direction)
+	}
+	for _, spec := range ports {
+		port, proto, hasProto := strings.Cut(spec, "/")
+		protos := []string{"tcp", "udp"}
+		if hasProto {
+			protos = []string{strings.ToLower(proto)}
+		}
+		for _, d := range dirs {
+			for _, pr := range protos {
+				pol.Rules = append(pol.Rules, FirewallRule{Direction: d, Protocol: pr, Ports: []string{port}, Sources: append([]string(nil), opts.Sources...), Action: "accept"})
+			}
+		}
+	}
+	if err := pol.Validate(); err != nil {
+		return pol, err
+	}
+	if !opts.NoSSHGuard && pol.DefaultIncoming != "accept" {
+		addSSHGuard(&pol, hostRoot)
+	}
+	return pol, nil
+}
+
+// addSSHGuard keeps sshd reachable so applying a policy over SSH cannot
+// lock the operator out: every sshd port without an accepting rule is
+// allowed from anywhere, and the client of the current SSH session is
+// allowed on its port when the policy's sources leave it out. The sshd
+// ports are read from the sshd_config under root.
+func addSSHGuard(p *FirewallPolicy, root *auditRoot) {
+	guard := func(port int, source string) {
+		r := FirewallRule{Direction: "in", Protocol: "tcp", Ports: []string{strconv.Itoa(port)}, Action: "accept", Comment: "fortis ssh guard"}
+		if source != "" {
+			r.Sources = []string{source}
+		}
+		p.Rules = append([]FirewallRule{r}, p.Rules...)
+	}
+	ports := map[int]bool{}
+	if v, _, _, found, err := sshdOptionValue(root, SSHDConfigPath, "Port", ""); err == nil && found {
+		for _, f := range strings.Fields(v) {
+			if n, err := strconv.Atoi(f); err == nil {
+				ports[n] = true
+			}
+		}
+	}
+	if len(ports) == 0 {
+		ports[22] = true
+	}
+	for port := range ports {
+		if !policyAllows(*p, port, nil) {
+			guard(port, "")
+		}
+	}
+	if f := strings.Fields(os.Getenv("SSH_CONNECTION")); len(f) == 4 {
+		client := net.ParseIP(f[0])
+		port, err := strconv.Atoi(f[3])
+		if client != nil && err == nil && !policyAllows(*p, port, client) {
+			cidr, _ := normalizeCIDR(client.String())
+			guard(port, cidr)
+		}
+	}
+}
+
+// policyAllows reports whether an incoming TCP connection to port is
+// accepted by a rule. A nil client matches rules with any sources.
+func policyAllows(p FirewallPolicy, port int, client net.IP) bool {
+	for _, r := range p.Rules {
+		if r.Direction != "in" || r.Action != "accept" || (r.Protocol != "tcp" && r.Protocol != "any") {
+			continue
+		}
+		if client != nil && len(r.Sources) > 0 && !sourcesContain(r.Sources, client) {
+			continue
+		}
+		if len(r.Ports) == 0 {
+			return true
+		}
+		for _, pr := range r.portRanges() {
+			if port >= pr.From && port <= pr.To {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sourcesContain(sources []string, ip net.IP) bool {
+	for _, s := range sources {
+		if _, n, err := net.ParseCIDR(s); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 func ConfigureFirewall(ctx context.Context, opts FirewallOptions) (FirewallResult, error) {
-	_ = ctx
 	if runtime.GOOS != "linux" {
 		return FirewallResult{Backend: "unsupported", Plan: []string{"not supported on this OS"}}, nil
 	}
-	backend := detectFirewallBackend()
+	backend := opts.Backend
+	if backend == "" {
+		backend = detectFirewallBackend()
+	}
 	res := FirewallResult{Backend: backend}
-
-	ports := parsePorts(opts.Ports)
-	if len(ports) == 0 {
-		ports = defaultPortsForProfile(opts.Profile)
+	pol, err := BuildFirewallPolicy(opts)
+	if err != nil {
+		return res, err
 	}
+	res.Policy = pol
 
+	var fw firewallBackend
 	switch backend {
+	case "nftables", "nft":
+		res.Backend, fw = "nftables", nftBackend{}
+	case "iptables":
+		fw = iptablesBackend{}
 	case "ufw":
-		res.Plan = append(res.Plan, "ufw status verbose")
-		for _, p := range ports {
-			res.Plan = append(res.Plan, fmt.Sprintf("ufw allow %s", p))
+		fw = ufwBackend{}
+	case "none":
+		return res, errors.New("no supported firewall backend found (nftables, iptables or ufw)")
+	default:
+		return res, fmt.Errorf("unknown firewall backend %q", backend)
+	}
+
+	if res.Ruleset, err = fw.render(pol); err != nil {
+		return res, err
+	}
+	current, err := fw.current(ctx)
+	if err != nil {
+		return res, fmt.Errorf("read current ruleset: %w", err)
+	}
+	res.Diff = lineDiff(normalizeRuleset(current), normalizeRuleset(res.Ruleset))
+	res.Plan = fw.plan(pol, opts.Save)
+	if opts.ConfirmTimeout > 0 {
+		res.Plan = append(res.Plan, fmt.Sprintf("restore the previous ruleset after %s unless confirmed with 'fortis harden firewall confirm'", opts.ConfirmTimeout))
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+	if !opts.Yes {
+		return res, errors.New("refusing to apply firewall changes without --yes")
+	}
+	if err := fw.validate(ctx, pol); err != nil {
+		return res, fmt.Errorf("generated ruleset rejected: %w", err)
+	}
+
+	tx, err := BeginTransaction(opts.RollbackDir, "firewall --backend "+res.Backend)
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	apply := func() error {
+		if err := tx.SnapshotFirewall(ctx, res.Backend); err != nil {
+			return err
 		}
 		if opts.Save {
-			res.Plan = append(res.Plan, "ufw enable")
-		}
-		if opts.DryRun {
-			return res, nil
-		}
-		if !opts.Yes {
-			return res, errors.New("refusing to apply firewall changes without --yes")
+			for _, p := range fw.persistFiles() {
+				if err := tx.SnapshotFile(p); err != nil {
+					return err
+				}
+			}
 		}
-		_ = exec.CommandContext(ctx, "ufw", "status", "verbose").Run()
-		for _, p := range ports {
-			_ = exec.CommandContext(ctx, "ufw", "allow", p).Run()
+		if err := fw.apply(ctx, tx, pol); err != nil {
+			return err
 		}
 		if opts.Save {
-			_ = exec.CommandContext(ctx, "ufw", "--force", "enable").Run()
+			return fw.persist(ctx, tx, pol)
 		}
-		return res, nil
-	default:
-		res.Plan = append(res.Plan, "no supported firewall backend detected (ufw) - install ufw")
-		return res, nil
+		return nil
 	}
+	if err := tx.Finish(ctx, apply()); err != nil {
+		return res, err
+	}
+	if opts.ConfirmTimeout > 0 {
+		deadline, err := armFirewallRevert(ctx, opts.RollbackDir, tx.ID(), opts.ConfirmTimeout)
+		if err != nil {
+			// Without a timer nobody would undo a lockout: revert now.
+			if _, rerr := Rollback(ctx, RollbackOptions{Dir: opts.RollbackDir, ID: tx.ID()}); rerr != nil {
+				return res, fmt.Errorf("arm revert timer: %v; rollback failed: %w", err, rerr)
+			}
+			return res, fmt.Errorf("arm revert timer: %w (changes rolled back; use --confirm-timeout 0 to apply without one)", err)
+		}
+		res.ConfirmBy = deadline
+	}
+	return res, nil
 }
 
 func detectFirewallBackend() string {
@@ -105,3 +513,54 @@ func defaultPortsForProfile(profile string) []string {
 		return nil
 	}
 }
+
+// normalizeRuleset drops blank lines, comments and packet counters so that
+// listings compare by content.
+func normalizeRuleset(s string) []string {
+	out := []string{}
+	for _, ln := range strings.Split(s, "\n") {
+		ln = strings.TrimSpace(firewallCounters.ReplaceAllString(ln, ""))
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		out = append(out, ln)
+	}
+	return out
+}
+
+// lineDiff returns the lines removed from a ("- ") and added in b ("+ ") in
+// order, using the longest common subsequence. Equal inputs give nil.
+func lineDiff(a, b []string) []string {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,1109 @@
+package hardening
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LiveRule is a rule of the live ruleset read back into the rule model.
+// Direction is in, out or forward. Conditional rules also match on things
+// the model does not express (interfaces, connection state, ICMP types,
+// local addresses) or jump to another chain; they neither count as any-any
+// rules nor as opening a port.
+type LiveRule struct {
+	FirewallRule
+	Line        string `json:"line"`
+	Conditional bool   `json:"conditional,omitempty"`
+}
+
+// LiveFirewall is the effective live configuration. With iptables a
+// default is accept when either address family accepts; with nftables it is
+// drop when any table's base chain drops.
+type LiveFirewall struct {
+	Backend         string     `json:"backend"`
+	Active          bool       `json:"active"`
+	DefaultIncoming string     `json:"default_incoming"`
+	DefaultOutgoing string     `json:"default_outgoing"`
+	DefaultForward  string     `json:"default_forward"`
+	Rules           []LiveRule `json:"rules"`
+}
+
+// ListeningSocket is a TCP socket in LISTEN state on a non-loopback address.
+type ListeningSocket struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Process string `json:"process,omitempty"`
+}
+
+type FirewallIssue struct {
+	// Kind is inactive, default-accept, any-any, uncovered-port or
+	// policy-drift.
+	Kind     string   `json:"kind"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Rule     string   `json:"rule,omitempty"`
+	// Exposed marks an uncovered port the default policy lets through.
+	Exposed bool `json:"exposed,omitempty"`
+}
+
+type FirewallAnalysis struct {
+	Live       LiveFirewall      `json:"live"`
+	Listening  []ListeningSocket `json:"listening"`
+	PolicyFile string            `json:"policy_file,omitempty"`
+	Issues     []FirewallIssue   `json:"issues"`
+}
+
+type FirewallAnalyzeOptions struct {
+	// Profile selects <ConfigDir>/firewall/<profile>.yaml as the desired
+	// policy; PolicyFile overrides it. Without either, drift is not checked.
+	Profile    string
+	PolicyFile string
+	ConfigDir  string
+	// Backend overrides detection (nftables, iptables, ufw).
+	Backend string
+}
+
+// AnalyzeFirewall reads the live ruleset and listening sockets and reports
+// default-accept policies, any-any allow rules, listening ports no rule
+// opens and differences from the desired policy.
+func AnalyzeFirewall(ctx context.Context, opts FirewallAnalyzeOptions) (FirewallAnalysis, error) {
+	var a FirewallAnalysis
+	backend := opts.Backend
+	if backend == "" {
+		backend = detectFirewallBackend()
+	}
+	live, err := readLiveFirewall(ctx, backend)
+	if err != nil {
+		return a, err
+	}
+	a.Live = live
+	if a.Listening, err = listeningSockets(); err != nil {
+		return a, err
+	}
+
+	var desired *FirewallPolicy
+	a.PolicyFile = opts.PolicyFile
+	if a.PolicyFile == "" {
+		a.PolicyFile = firewallPolicyPath(opts.ConfigDir, opts.Profile)
+	}
+	if a.PolicyFile != "" {
+		p, err := LoadFirewallPolicy(a.PolicyFile)
+		if err != nil {
+			return a, err
+		}
+		desired = &p
+	}
+	a.Issues = analyzeLive(live, a.Listening, desired)
+	return a, nil
+}
+
+func analyzeLive(live LiveFirewall, listening []ListeningSocket, desired *FirewallPolicy) []FirewallIssue {
+	var issues []FirewallIssue
+	add := func(kind string, sev Severity, rule, format string, args ...any) {
+		issues = append(issues, FirewallIssue{Kind: kind, Severity: sev, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	if !live.Active {
+		if live.Backend == "none" {
+			add("inactive", SeverityHigh, "", "no firewall backend (nftables, iptables or ufw) is installed")
+		} else {
+			add("inactive", SeverityHigh, "", "%s is installed but no filtering ruleset is active", live.Backend)
+		}
+	} else {
+		if live.DefaultIncoming == "accept" {
+			add("default-accept", SeverityHigh, "", "incoming traffic is accepted by default")
+		}
+		if live.DefaultForward == "accept" {
+			add("default-accept", SeverityMedium, "", "forwarded traffic is accepted by default")
+		}
+		if live.DefaultOutgoing == "accept" {
+			add("default-accept", SeverityLow, "", "outgoing traffic is accepted by default")
+		}
+		for _, r := range live.Rules {
+			if r.Action == "accept" && r.Direction != "out" && !r.Conditional && r.Protocol == "any" && len(r.Sources) == 0 {
+				add("any-any", SeverityHigh, r.Line, "%s rule accepts any protocol and port from any address", map[string]string{"in": "incoming", "forward": "forward"}[r.Direction])
+			}
+		}
+	}
+
+	exposedByDefault := !live.Active || live.DefaultIncoming == "accept"
+	for _, s := range listening {
+		if live.Active && liveOpensPort(live, s.Port) {
+			continue
+		}
+		proc := ""
+		if s.Process != "" {
+			proc = " (" + s.Process + ")"
+		}
+		iss := FirewallIssue{Kind: "uncovered-port", Exposed: exposedByDefault}
+		if exposedByDefault {
+			iss.Severity = SeverityMedium
+			iss.Message = fmt.Sprintf("%s port %d%s has no rule and is reachable because nothing filters incoming traffic", s.Address, s.Port, proc)
+			if live.Active {
+				iss.Message = fmt.Sprintf("%s port %d%s has no rule and is reachable through the default accept policy", s.Address, s.Port, proc)
+			}
+		} else {
+			iss.Severity = SeverityLow
+			iss.Message = fmt.Sprintf("%s port %d%s is listening but no rule allows it; bind it to localhost or add a rule", s.Address, s.Port, proc)
+		}
+		issues = append(issues, iss)
+	}
+
+	if desired != nil && live.Active {
+		issues = append(issues, policyDrift(live, *desired)...)
+	}
+	return issues
+}
+
+// liveOpensPort reports whether an incoming TCP connection to port is
+// accepted by a rule, from any or some sources.
+func liveOpensPort(live LiveFirewall, port int) bool {
+	for _, r := range live.Rules {
+		if r.Direction != "in" || r.Action != "accept" || r.Conditional || (r.Protocol != "tcp" && r.Protocol != "any") {
+			continue
+		}
+		if len(r.Ports) == 0 {
+			return true
+		}
+		for _, pr := range r.portRanges() {
+			if port >= pr.From && port <= pr.To {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleAtom is one protocol, port range and remote address of a rule.
+type ruleAtom struct {
+	direction, protocol, action string
+	ports                       PortRange // zero for any port
+	source                      string    // empty for any address
+}
+
+func (a ruleAtom) String() string {
+	s := fmt.Sprintf("%s %s", a.direction, a.protocol)
+	if a.ports.From != 0 {
+		s += " port " + a.ports.String()
+	}
+	from := "from"
+	if a.direction == "out" {
+		from = "to"
+	}
+	if a.source != "" {
+		s += " " + from + " " + a.source
+	} else {
+		s += " " + from + " any"
+	}
+	return s + " " + a.action
+}
+
+func ruleAtoms(r FirewallRule) []ruleAtom {
+	ports := r.portRanges()
+	if len(ports) == 0 {
+		ports = []PortRange{{}}
+	}
+	sources := r.Sources
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+	var out []ruleAtom
+	for _, pr := range ports {
+		for _, s := range sources {
+			out = append(out, ruleAtom{direction: r.Direction, protocol: r.Protocol, action: r.Action, ports: pr, source: s})
+		}
+	}
+	return out
+}
+
+// ruleCovers reports whether r matches at least the traffic of atom.
+func ruleCovers(r FirewallRule, a ruleAtom) bool {
+	if r.Direction != a.direction || r.Action != a.action {
+		return false
+	}
+	if r.Protocol != "any" && r.Protocol != a.protocol {
+		return false
+	}
+	if ranges := r.portRanges(); len(ranges) > 0 {
+		if a.ports.From == 0 {
+			return false
+		}
+		ok := false
+		for _, pr := range ranges {
+			ok = ok || (a.ports.From >= pr.From && a.ports.To <= pr.To)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.Sources) == 0 {
+		return true
+	}
+	if a.source == "" {
+		return false
+	}
+	_, want, err := net.ParseCIDR(a.source)
+	if err != nil {
+		return false
+	}
+	wantOnes, _ := want.Mask.Size()
+	for _, s := range r.Sources {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		ones, _ := n.Mask.Size()
+		if n.Contains(want.IP) && ones <= wantOnes {
+			return true
+		}
+	}
+	return false
+}
+
+// policyDrift compares the live ruleset with the desired policy: differing
+// defaults, desired rules nothing live implements, and live accept rules
+// the policy does not ask for. The SSH guard rule is expected.
+func policyDrift(live LiveFirewall, want FirewallPolicy) []FirewallIssue {
+	var out []FirewallIssue
+	drift := func(sev Severity, rule, format string, args ...any) {
+		out = append(out, FirewallIssue{Kind: "policy-drift", Severity: sev, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, d := range []struct{ name, got, want string }{
+		{"incoming", live.DefaultIncoming, want.DefaultIncoming},
+		{"outgoing", live.DefaultOutgoing, want.DefaultOutgoing},
+		{"forward", live.DefaultForward, want.DefaultForward},
+	} {
+		if d.got != d.want {
+			drift(SeverityMedium, "", "default %s policy is %s, policy wants %s", d.name, d.got, d.want)
+		}
+	}
+	plain := []FirewallRule{}
+	for _, r := range live.Rules {
+		if !r.Conditional {
+			plain = append(plain, r.FirewallRule)
+		}
+	}
+	covered := func(rules []FirewallRule, a ruleAtom) bool {
+		for _, r := range rules {
+			if ruleCovers(r, a) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range want.Rules {
+		for _, a := range ruleAtoms(r) {
+			if !covered(plain, a) {
+				drift(SeverityMedium, "", "missing rule: %s", a)
+			}
+		}
+	}
+	for _, r := range live.Rules {
+		if r.Conditional || r.Action != "accept" || r.Comment == "fortis ssh guard" {
+			continue
+		}
+		for _, a := range ruleAtoms(r.FirewallRule) {
+			if !covered(want.Rules, a) {
+				drift(SeverityMedium, r.Line, "rule not in policy: %s", a)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func readLiveFirewall(ctx context.Context, backend string) (LiveFirewall, error) {
+	live := LiveFirewall{Backend: backend, DefaultIncoming: "accept", DefaultOutgoing: "accept", DefaultForward: "accept"}
+	output := func(name string, args ...string) (string, error) {
+		out, err := exec.CommandContext(ctx, name, args...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return string(out), nil
+	}
+	switch backend {
+	case "ufw":
+		out, err := output("ufw", "status", "verbose")
+		if err != nil {
+			return live, err
+		}
+		parseUFWStatus(&live, out)
+	case "nftables", "nft":
+		live.Backend = "nftables"
+		out, err := output("nft", "list", "ruleset")
+		if err != nil {
+			return live, err
+		}
+		parseNftRuleset(&live, out)
+	case "iptables":
+		out, err := output("iptables-save", "-t", "filter")
+		if err != nil {
+			return live, err
+		}
+		v4 := parseIptablesSave(out, "")
+		v6 := LiveFirewall{}
+		if _, lerr := exec.LookPath("ip6tables-save"); lerr == nil {
+			out6, err := output("ip6tables-save", "-t", "filter")
+			if err != nil {
+				return live, err
+			}
+			v6 = parseIptablesSave(out6, "ip6tables: ")
+		}
+		live.Active = v4.Active || v6.Active
+		live.DefaultIncoming = weakerPolicy(v4.DefaultIncoming, v6.DefaultIncoming)
+		live.DefaultOutgoing = weakerPolicy(v4.DefaultOutgoing, v6.DefaultOutgoing)
+		live.DefaultForward = weakerPolicy(v4.DefaultForward, v6.DefaultForward)
+		live.Rules = append(v4.Rules, v6.Rules...)
+	case "none":
+	default:
+		return live, fmt.Errorf("unknown firewall backend %q", backend)
+	}
+	return live, nil
+}
+
+// weakerPolicy combines the policies of two families or tables; empty
+// means the other had no such chain.
+func weakerPolicy(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	case a == "accept" || b == "accept":
+		return "accept"
+	}
+	return "drop"
+}
+
+var ufwColumns = regexp.MustCompile(`\s{2,}`)
+
+// parseUFWStatus reads "ufw status verbose":
+//
+//	Status: active
+//	Default: deny (incoming), allow (outgoing), disabled (routed)
+//	To                         Action      From
+//	22/tcp                     ALLOW IN    Anywhere
+//	80,443/tcp                 ALLOW IN    10.0.0.0/8                 # web
+func parseUFWStatus(live *LiveFirewall, out string) {
+	inTable := false
+	for _, ln := range strings.Split(out, "\n") {
+		ln = strings.TrimRight(ln, " \t")
+		switch {
+		case strings.HasPrefix(ln, "Status:"):
+			live.Active = strings.TrimSpace(strings.TrimPrefix(ln, "Status:")) == "active"
+			continue
+		case strings.HasPrefix(ln, "Default:"):
+			for _, part := range strings.Split(strings.TrimPrefix(ln, "Default:"), ",") {
+				f := strings.Fields(strings.NewReplacer("(", "", ")", "").Replace(part))
+				if len(f) != 2 {
+					continue
+				}
+				pol := "drop"
+				if f[0] == "allow" {
+					pol = "accept"
+				}
+				switch f[1] {
+				case "incoming":
+					live.DefaultIncoming = pol
+				case "outgoing":
+					live.DefaultOutgoing = pol
+				case "routed":
+					live.DefaultForward = pol
+				}
+			}
+			continue
+		case strings.HasPrefix(ln, "--"):
+			inTable = true
+			continue
+		}
+		if !inTable || strings.TrimSpace(ln) == "" {
+			continue
+		}
+		if r, ok := parseUFWRule(ln); ok {
+			live.Rules = append(live.Rules, r)
+		}
+	}
+}
+
+func parseUFWRule(ln string) (LiveRule, bool) {
+	r := LiveRule{Line: strings.TrimSpace(ln)}
+	body, comment, _ := strings.Cut(ln, "#")
+	r.Comment = strings.TrimSpace(comment)
+	cols := ufwColumns.Split(strings.TrimSpace(body), -1)
+	if len(cols) < 3 {
+		return r, false
+	}
+	to, action, from := cols[0], strings.Fields(cols[1]), cols[2]
+	if len(action) == 0 {
+		return r, false
+	}
+	switch action[0] {
+	case "ALLOW":
+		r.Action = "accept"
+	case "DENY":
+		r.Action = "drop"
+	case "REJECT":
+		r.Action = "reject"
+	case "LIMIT":
+		r.Action, r.Conditional = "accept", true
+	default:
+		return r, false
+	}
+	r.Direction = "in"
+	if len(action) > 1 {
+		switch action[1] {
+		case "OUT":
+			r.Direction = "out"
+		case "FWD":
+			r.Direction = "forward"
+		}
+	}
+	// The remote address is From for incoming rules and To for outgoing
+	// ones; the port is always the destination port in To.
+	toAddr, port := ufwEndpoint(to, &r)
+	fromAddr, fromPort := ufwEndpoint(from, &r)
+	remoteAddr, localAddr := fromAddr, toAddr
+	if r.Direction == "out" {
+		remoteAddr, localAddr = toAddr, fromAddr
+	}
+	if remoteAddr != "" {
+		if cidr, err := normalizeCIDR(remoteAddr); err == nil {
+			r.Sources = []string{cidr}
+		} else {
+			r.Conditional = true
+		}
+	}
+	if localAddr != "" || fromPort != "" {
+		r.Conditional = true
+	}
+	r.Protocol = "any"
+	if port != "" {
+		spec, proto, hasProto := strings.Cut(port, "/")
+		if hasProto {
+			r.Protocol = proto
+		} else {
+			// A port without protocol opens tcp and udp; tcp is what
+			// coverage and drift care about.
+			r.Protocol = "tcp"
+		}
+		for _, p := range strings.Split(spec, ",") {
+			if pr, err := parsePortRange(p); err == nil {
+				r.Ports = append(r.Ports, pr.String())
+			} else {
+				// Application profile names ("OpenSSH").
+				r.Conditional = true
+			}
+		}
+	}
+	return r, true
+}
+
+// ufwEndpoint splits a To/From column ("Anywhere", "10.0.0.0/8 22/tcp",
+// "22/tcp (v6)", "Anywhere on eth0") into address and port.
+func ufwEndpoint(col string, r *LiveRule) (addr, port string) {
+	col = strings.TrimSpace(strings.NewReplacer("(v6)", "", "(out)", "").Replace(col))
+	if i := strings.Index(col, " on "); i >= 0 {
+		r.Conditional = true
+		col = strings.TrimSpace(col[:i])
+	}
+	if strings.HasPrefix(col, "Anywhere") {
+		return "", strings.TrimSpace(strings.TrimPrefix(col, "Anywhere"))
+	}
+	f := strings.Fields(col)
+	switch {
+	case len(f) == 0:
+		return "", ""
+	case len(f) >= 2:
+		return f[0], f[1]
+	case net.ParseIP(strings.Split(f[0], "/")[0]) != nil:
+		return f[0], ""
+	}
+	return "", f[0]
+}
+
+// parseNftRuleset reads the base filter chains of "nft list ruleset".
+// Regular chains are only reachable through jumps, which are conditional.
+func parseNftRuleset(live *LiveFirewall, out string) {
+	var family, chain, hook string
+	policies := map[string]string{}
+	for _, raw := range strings.Split(out, "\n") {
+		ln := strings.TrimSpace(firewallCounters.ReplaceAllString(raw, ""))
+		f := strings.Fields(ln)
+		switch {
+		case len(f) == 0:
+			continue
+		case f[0] == "table" && len(f) >= 3:
+			family, chain, hook = f[1], "", ""
+			continue
+		case f[0] == "chain" && len(f) >= 2:
+			chain, hook = f[1], ""
+			continue
+		case f[0] == "}":
+			if chain != "" {
+				chain, hook = "", ""
+			}
+			continue
+		case f[0] == "type" && chain != "":
+			if len(f) >= 4 && f[1] == "filter" && f[2] == "hook" && (family == "inet" || family == "ip" || family == "ip6") {
+				hook = f[3]
+				pol := "accept"
+				if i := strings.Index(ln, "policy "); i >= 0 {
+					pol = strings.TrimSuffix(strings.Fields(ln[i+len("policy "):])[0], ";")
+				}
+				dir := map[string]string{"input": "in", "output": "out", "forward": "forward"}[hook]
+				if dir != "" {
+					// A packet must pass every base chain of its hook,
+					// so one dropping table makes the hook drop.
+					if policies[dir] != "drop" {
+						policies[dir] = pol
+					}
+					if dir == "in" {
+						live.Active = true
+					}
+				}
+			}
+			continue
+		}
+		dir := map[string]string{"input": "in", "output": "out", "forward": "forward"}[hook]
+		if chain == "" || dir == "" {
+			continue
+		}
+		if r, ok := parseNftRule(ln, dir, family); ok {
+			live.Rules = append(live.Rules, r)
+		}
+	}
+	if v := policies["in"]; v != "" {
+		live.DefaultIncoming = v
+	}
+	if v := policies["out"]; v != "" {
+		live.DefaultOutgoing = v
+	}
+	if v := policies["forward"]; v != "" {
+		live.DefaultForward = v
+	}
+}
+
+// nftTokens splits a rule, keeping "{ a, b }" sets and quoted strings as
+// single tokens.
+func nftTokens(s string) []string {
+	var out []string
+	var cur strings.Builder
+	depth, quoted := 0, false
+	flush := func() {
+		if cur.Len() > 0 {
+			out = append(out, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			cur.WriteRune(c)
+		case quoted:
+			cur.WriteRune(c)
+		case c == '{':
+			depth++
+			cur.WriteRune(c)
+		case c == '}':
+			depth--
+			cur.WriteRune(c)
+		case c == ' ' && depth == 0:
+			flush()
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	flush()
+	return out
+}
+
+// nftValues expands "22", "{ 80, 443 }" or "1000-2000" into its elements.
+func nftValues(tok string) []string {
+	tok = strings.TrimSuffix(strings.TrimPrefix(tok, "{"), "}")
+	var out []string
+	for _, v := range strings.Split(tok, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func parseNftRule(ln, dir, family string) (LiveRule, bool) {
+	r := LiveRule{Line: ln}
+	r.Direction = dir
+	r.Protocol = "any"
+	remote := "saddr"
+	if dir == "out" {
+		remote = "daddr"
+	}
+	t := nftTokens(ln)
+	for i := 0; i < len(t); i++ {
+		next := func() string {
+			if i+1 < len(t) {
+				i++
+				return t[i]
+			}
+			return ""
+		}
+		switch t[i] {
+		case "accept", "drop", "reject":
+			r.Action = t[i]
+		case "jump", "goto":
+			r.Action = t[i]
+			r.Conditional = true
+			next()
+		case "return", "continue", "queue":
+			return r, false
+		case "with":
+			// reject with icmp type ...
+			for i+1 < len(t) && t[i+1] != "comment" {
+				i++
+			}
+		case "counter":
+		case "comment":
+			r.Comment = strings.Trim(next(), `"`)
+		case "log":
+			for i+1 < len(t) && (t[i+1] == "prefix" || t[i+1] == "level" || t[i+1] == "flags" || strings.HasPrefix(t[i+1], `"`)) {
+				i++
+				if t[i] == "level" || t[i] == "flags" {
+					i++
+				}
+			}
+		case "ip", "ip6":
+			field := next()
+			val := next()
+			switch {
+			case field == remote && val != "!=":
+				for _, v := range nftValues(val) {
+					if cidr, err := normalizeCIDR(v); err == nil {
+						if !anyAddress(cidr) {
+							r.Sources = append(r.Sources, cidr)
+						}
+					} else {
+						r.Conditional = true
+					}
+				}
+			case field == "protocol":
+				r.Protocol = nftProto(val, &r)
+			default:
+				r.Conditional = true
+				if val == "!=" {
+					next()
+				}
+			}
+		case "tcp", "udp":
+			proto := t[i]
+			field := next()
+			val := next()
+			if field != "dport" || val == "!=" {
+				r.Conditional = true
+				continue
+			}
+			r.Protocol = proto
+			for _, v := range nftValues(val) {
+				if pr, err := parsePortRange(v); err == nil {
+					r.Ports = append(r.Ports, pr.String())
+				} else {
+					r.Conditional = true
+				}
+			}
+		case "meta":
+			if next() == "l4proto" {
+				r.Protocol = nftProto(next(), &r)
+			} else {
+				r.Conditional = true
+				next()
+			}
+		default:
+			r.Conditional = true
+		}
+	}
+	if r.Action == "" {
+		return r, false
+	}
+	if family == "ip6" && r.Protocol == "icmp" {
+		r.Conditional = true
+	}
+	return r, true
+}
+
+// anyAddress reports whether cidr matches every address of its family.
+func anyAddress(cidr string) bool { return cidr == "0.0.0.0/0" || cidr == "::/0" }
+
+func nftProto(v string, r *LiveRule) string {
+	switch v {
+	case "tcp", "udp", "icmp":
+		return v
+	case "ipv6-icmp", "icmpv6":
+		return "icmpv6"
+	}
+	r.Conditional = true
+	return "any"
+}
+
+// parseIptablesSave reads the INPUT, OUTPUT and FORWARD chains of an
+// iptables-save filter table. prefix marks lines of the IPv6 dump.
+func parseIptablesSave(out, prefix string) LiveFirewall {
+	live := LiveFirewall{}
+	dirs := map[string]string{"INPUT": "in", "OUTPUT": "out", "FORWARD": "forward"}
+	for _, ln := range strings.Split(out, "\n") {
+		ln = strings.TrimSpace(ln)
+		switch {
+		case strings.HasPrefix(ln, ":"):
+			f := strings.Fields(ln[1:])
+			if len(f) < 2 {
+				continue
+			}
+			pol := "drop"
+			if f[1] == "ACCEPT" {
+				pol = "accept"
+			}
+			switch dirs[f[0]] {
+			case "in":
+				live.DefaultIncoming = pol
+				live.Active = live.Active || pol != "accept"
+			case "out":
+				live.DefaultOutgoing = pol
+			case "forward":
+				live.DefaultForward = pol
+			}
+		case strings.HasPrefix(ln, "-A "):
+			f := splitUFWArgs(strings.ReplaceAll(ln, `"`, "'"))
+			if len(f) < 2 || dirs[f[1]] == "" {
+				continue
+			}
+			if r, ok := parseIptablesRule(f[2:], dirs[f[1]]); ok {
+				r.Line = prefix + ln
+				live.Rules = append(live.Rules, r)
+				live.Active = live.Active || dirs[f[1]] == "in"
+			}
+		}
+	}
+	return live
+}
+
+func parseIptablesRule(args []string, dir string) (LiveRule, bool) {
+	r := LiveRule{}
+	r.Direction, r.Protocol = dir, "any"
+	remote, local := "-s", "-d"
+	if dir == "out" {
+		remote, local = "-d", "-s"
+	}
+	negate := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		val := ""
+		if i+1 < len(args) {
+			val = args[i+1]
+		}
+		if a == "!" {
+			negate = true
+			continue
+		}
+		if negate {
+			r.Conditional = true
+			negate = false
+		}
+		switch a {
+		case remote:
+			i++
+			for _, v := range strings.Split(val, ",") {
+				if cidr, err := normalizeCIDR(v); err == nil && !anyAddress(cidr) {
+					r.Sources = append(r.Sources, cidr)
+				}
+			}
+		case local, "-i", "-o", "--sport", "--sports", "--ctstate", "--state", "--icmp-type", "--icmpv6-type":
+			i++
+			r.Conditional = true
+		case "-p":
+			i++
+			switch val {
+			case "tcp", "udp", "icmp":
+				r.Protocol = val
+			case "ipv6-icmp", "icmpv6":
+				r.Protocol = "icmpv6"
+			case "all":
+			default:
+				r.Conditional = true
+			}
+		case "-m":
+			// Match modules only load options; the options decide.
+			i++
+		case "--dport", "--dports", "--destination-port", "--destination-ports":
+			i++
+			for _, v := range strings.Split(val, ",") {
+				if pr, err := parsePortRange(v); err == nil {
+					r.Ports = append(r.Ports, pr.String())
+				}
+			}
+		case "--comment":
+			i++
+			r.Comment = strings.Trim(val, "'")
+		case "-j", "-g":
+			i++
+			switch val {
+			case "ACCEPT":
+				r.Action = "accept"
+			case "DROP":
+				r.Action = "drop"
+			case "REJECT":
+				r.Action = "reject"
+			case "LOG", "RETURN":
+				return r, false
+			default:
+				r.Action, r.Conditional = "jump", true
+			}
+		case "--reject-with", "--log-prefix", "--log-level":
+			i++
+		default:
+			r.Conditional = true
+			if strings.HasPrefix(a, "-") && val != "" && !strings.HasPrefix(val, "-") {
+				i++
+			}
+		}
+	}
+	return r, r.Action != ""
+}
+
+// listeningSockets parses /proc/net/tcp and tcp6 for LISTEN sockets outside
+// loopback and names their processes where /proc allows it.
+func listeningSockets() ([]ListeningSocket, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	type key struct {
+		addr string
+		port int
+	}
+	seen := map[key]bool{}
+	inodes := map[string][]int{}
+	var out []ListeningSocket
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, ln := range strings.Split(string(b), "\n")[1:] {
+			f := strings.Fields(ln)
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			if len(f) < 10 || f[3] != "0A" {
+				continue
+			}
+			ip, port, ok := parseProcAddr(f[1])
+			if !ok || ip.IsLoopback() {
+				continue
+			}
+			addr := ip.String()
+			if ip.IsUnspecified() {
+				addr = "*"
+			}
+			k := key{addr, port}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			inodes[f[9]] = append(inodes[f[9]], len(out))
+			out = append(out, ListeningSocket{Address: addr, Port: port})
+		}
+	}
+	for inode, proc := range socketProcesses(inodes) {
+		for _, i := range inodes[inode] {
+			out[i].Process = proc
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Port != out[j].Port {
+			return out[i].Port < out[j].Port
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out, nil
+}
+
+// parseProcAddr decodes "0100007F:0016": the address is in host byte order
+// per 32-bit word, the port big-endian.
+func parseProcAddr(s string) (net.IP, int, bool) {
+	h, p, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, false
+	}
+	raw, err := hex.DecodeString(h)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return nil, 0, false
+	}
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		ip[w], ip[w+1], ip[w+2], ip[w+3] = raw[w+3], raw[w+2], raw[w+1], raw[w]
+	}
+	port, err := strconv.ParseUint(p, 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return ip, int(port), true
+}
+
+// socketProcesses maps socket inodes to the command names of the
+// processes holding them. Processes that cannot be inspected are skipped.
+func socketProcesses(inodes map[string][]int) map[string]string {
+	out := map[string]string{}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if len(out) == len(inodes) {
+			break
+		}
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+		if _, want := inodes[inode]; !want || out[inode] != "" {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Dir(fd)), "comm"))
+		if err == nil {
+			out[inode] = strings.TrimSpace(string(comm))
+		}
+	}
+	return out
+}
+
+// firewallFinding runs the analyzer for a firewall check, skipping where it
+// cannot run.
+func firewallFinding(ctx context.Context, opts AuditOptions, f Finding, eval func(a FirewallAnalysis, f *Finding)) (Finding, error) {
+	if runtime.GOOS != "linux" {
+		f.Result = ResultSkip
+		f.Details = "not supported on this OS"
+		return f, nil
+	}
+	if opts.root.isImage() {
+		f.Result = ResultSkip
+		f.Details = runtimeOnly
+		return f, nil
+	}
+	a, err := AnalyzeFirewall(ctx, FirewallAnalyzeOptions{Profile: opts.Profile, ConfigDir: opts.ConfigDir})
+	if err != nil {
+		f.Result = ResultWarn
+		return f, err
+	}
+	eval(a, &f)
+	return f, nil
+}
+
+func issueMessages(a FirewallAnalysis, kind string, keep func(FirewallIssue) bool) []string {
+	var out []string
+	for _, iss := range a.Issues {
+		if iss.Kind == kind && (keep == nil || keep(iss)) {
+			msg := iss.Message
+			if iss.Rule != "" {
+				msg += ": " + iss.Rule
+			}
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+func checkFirewallEnabled(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "firewall.enabled", Title: "Ensure the firewall is active", Weight: 25}
+	return firewallFinding(ctx, opts, f, func(a FirewallAnalysis, f *Finding) {
+		if msgs := issueMessages(a, "inactive", nil); len(msgs) > 0 {
+			f.Result = ResultFail
+			f.Details = strings.Join(msgs, "; ")
+			f.Recommendation = "Load a ruleset with 'fortis harden firewall --yes' and enable it at boot with --save"
+			return
+		}
+		f.Result = ResultPass
+		f.Details = a.Live.Backend + " ruleset active"
+	})
+}
+
+func checkFirewallDefaultDeny(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "firewall.default_deny", Title: "Ensure the firewall denies incoming and forwarded traffic by default", Weight: 20}
+	return firewallFinding(ctx, opts, f, func(a FirewallAnalysis, f *Finding) {
+		if !a.Live.Active {
+			f.Result = ResultFail
+			f.Details = "no active ruleset"
+			f.Recommendation = "Load a ruleset with default policy drop"
+			return
+		}
+		msgs := issueMessages(a, "default-accept", func(i FirewallIssue) bool { return i.Severity != SeverityLow })
+		if len(msgs) > 0 {
+			f.Result = ResultFail
+			f.Details = strings.Join(msgs, "; ")
+			f.Recommendation = "Set the default incoming and forward policies to drop (fortis harden firewall --default-incoming drop)"
+			return
+		}
+		f.Result = ResultPass
+		f.Details = fmt.Sprintf("incoming %s, forward %s, outgoing %s", a.Live.DefaultIncoming, a.Live.DefaultForward, a.Live.DefaultOutgoing)
+	})
+}
+
+func checkFirewallAnyAny(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "firewall.any_any", Title: "Ensure no firewall rule allows any traffic from anywhere", Weight: 15}
+	return firewallFinding(ctx, opts, f, func(a FirewallAnalysis, f *Finding) {
+		if msgs := issueMessages(a, "any-any", nil); len(msgs) > 0 {
+			f.Result = ResultFail
+			f.Details = strings.Join(msgs, "; ")
+			f.Recommendation = "Replace any-any allow rules with rules for the needed protocols, ports and sources"
+			return
+		}
+		f.Result = ResultPass
+	})
+}
+
+func checkFirewallUncoveredPorts(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "firewall.uncovered_ports", Title: "Ensure every listening port is covered by a firewall rule", Weight: 15}
+	return firewallFinding(ctx, opts, f, func(a FirewallAnalysis, f *Finding) {
+		exposed := issueMessages(a, "uncovered-port", func(i FirewallIssue) bool { return i.Exposed })
+		blocked := issueMessages(a, "uncovered-port", func(i FirewallIssue) bool { return !i.Exposed })
+		switch {
+		case len(exposed) > 0:
+			f.Result = ResultFail
+			f.Details = strings.Join(exposed, "; ")
+			f.Recommendation = "Add explicit rules for the services that must be reachable and drop everything else by default"
+		case len(blocked) > 0:
+			f.Result = ResultWarn
+			f.Details = strings.Join(blocked, "; ")
+			f.Recommendation = "Bind services that need no remote access to localhost"
+		default:
+			f.Result = ResultPass
+			f.Details = fmt.Sprintf("%d listening ports checked", len(a.Listening))
+		}
+	})
+}
+
+func checkFirewallPolicyDrift(ctx context.Context, opts AuditOptions) (Finding, error) {
+	f := Finding{ID: "firewall.policy_drift", Title: "Ensure the firewall matches the declared policy", Weight: 15}
+	return firewallFinding(ctx, opts, f, func(a FirewallAnalysis, f *Finding) {
+		if a.PolicyFile == "" {
+			f.Result = ResultSkip
+			f.Details = "no firewall policy for profile " + opts.Profile + " (<config-dir>/firewall/" + opts.Profile + ".yaml)"
+			return
+		}
+		if msgs := issueMessages(a, "policy-drift", nil); len(msgs) > 0 {
+			f.Result = ResultFail
+			f.Details = strings.Join(msgs, "; ")
+			f.Recommendation = "Re-apply the policy with 'fortis harden firewall --policy " + a.PolicyFile + " --yes' or update the policy file"
+			return
+		}
+		f.Result = ResultPass
+		f.Details = "matches " + a.PolicyFile
+	})
+}
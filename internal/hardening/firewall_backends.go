@@ -0,0 +1,681 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// firewallBackend turns a FirewallPolicy into the ruleset of one tool and
+// loads it.
+type firewallBackend interface {
+	// render returns the generated ruleset in the form current lists it.
+	render(p FirewallPolicy) (string, error)
+	// current lists the live ruleset the generated one replaces.
+	current(ctx context.Context) (string, error)
+	plan(p FirewallPolicy, save bool) []string
+	// validate dry-runs the generated ruleset through the loader.
+	validate(ctx context.Context, p FirewallPolicy) error
+	apply(ctx context.Context, tx *Transaction, p FirewallPolicy) error
+	// persistFiles are written by persist and snapshotted before it.
+	persistFiles() []string
+	persist(ctx context.Context, tx *Transaction, p FirewallPolicy) error
+}
+
+// splitSources groups rule sources by address family. A rule without
+// sources matches both families.
+func splitSources(r FirewallRule) (v4, v6 []string, any bool) {
+	if len(r.Sources) == 0 {
+		return nil, nil, true
+	}
+	for _, s := range r.Sources {
+		if isIPv6CIDR(s) {
+			v6 = append(v6, s)
+		} else {
+			v4 = append(v4, s)
+		}
+	}
+	return v4, v6, false
+}
+
+// nftTable is the table fortis owns; rules of other tables (container
+// runtimes, libvirt) are left alone.
+const nftTable = "fortis"
+
+// nftPersistPath is included from the distribution's nftables.conf.
+const nftPersistPath = "/etc/fortis/firewall.nft"
+
+type nftBackend struct{}
+
+func (nftBackend) render(p FirewallPolicy) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s {\n", nftTable)
+	chain := func(name, policy string, rules []string) {
+		fmt.Fprintf(&b, "\tchain %s {\n\t\ttype filter hook %s priority filter; policy %s;\n", name, name, policy)
+		for _, r := range rules {
+			b.WriteString("\t\t" + r + "\n")
+		}
+		b.WriteString("\t}\n")
+	}
+	in := []string{
+		`iif "lo" accept`,
+		"ct state established,related accept",
+		"ct state invalid drop",
+		"icmp type echo-request accept",
+		"icmpv6 type { echo-request, nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert } accept",
+	}
+	out := []string{
+		`oif "lo" accept`,
+		"ct state established,related accept",
+		"icmpv6 type { nd-router-solicit, nd-neighbor-solicit, nd-neighbor-advert } accept",
+	}
+	for _, r := range p.Rules {
+		lines := nftRule(r)
+		if r.Direction == "out" {
+			out = append(out, lines...)
+		} else {
+			in = append(in, lines...)
+		}
+	}
+	chain("input", p.DefaultIncoming, in)
+	chain("forward", p.DefaultForward, nil)
+	chain("output", p.DefaultOutgoing, out)
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+func nftRule(r FirewallRule) []string {
+	addr := "saddr"
+	if r.Direction == "out" {
+		addr = "daddr"
+	}
+	var match string
+	switch r.Protocol {
+	case "tcp", "udp":
+		if len(r.Ports) > 0 {
+			match = r.Protocol + " dport " + nftSet(r.Ports)
+		} else {
+			match = "meta l4proto " + r.Protocol
+		}
+	case "icmp":
+		match = "meta l4proto icmp"
+	case "icmpv6":
+		match = "meta l4proto ipv6-icmp"
+	}
+	tail := r.Action
+	if r.Comment != "" {
+		tail += fmt.Sprintf(" comment %q", r.Comment)
+	}
+	join := func(parts ...string) string {
+		out := parts[:0]
+		for _, p := range parts {
+			if p != "" {
+				out = append(out, p)
+			}
+		}
+		return strings.Join(out, " ")
+	}
+	v4, v6, any := splitSources(r)
+	if any {
+		return []string{join(match, tail)}
+	}
+	// nft lists host addresses without a prefix length.
+	for i := range v4 {
+		v4[i] = strings.TrimSuffix(v4[i], "/32")
+	}
+	for i := range v6 {
+		v6[i] = strings.TrimSuffix(v6[i], "/128")
+	}
+	var lines []string
+	if len(v4) > 0 && r.Protocol != "icmpv6" {
+		lines = append(lines, join("ip "+addr+" "+nftSet(v4), match, tail))
+	}
+	if len(v6) > 0 && r.Protocol != "icmp" {
+		lines = append(lines, join("ip6 "+addr+" "+nftSet(v6), match, tail))
+	}
+	return lines
+}
+
+func nftSet(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "{ " + strings.Join(items, ", ") + " }"
+}
+
+// nftScript replaces the fortis table in one transaction: declaring the
+// table first makes the delete succeed when it does not exist yet.
+func nftScript(p FirewallPolicy) (string, error) {
+	rs, err := nftBackend{}.render(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("table inet %s\ndelete table inet %s\n%s", nftTable, nftTable, rs), nil
+}
+
+func (nftBackend) current(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "nft", "list", "table", "inet", nftTable).Output()
+	if err != nil {
+		// A missing table is an empty ruleset.
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (nftBackend) plan(p FirewallPolicy, save bool) []string {
+	plan := []string{fmt.Sprintf("nft -f: replace table inet %s (input %s, output %s, forward %s, %d rules)", nftTable, p.DefaultIncoming, p.DefaultOutgoing, p.DefaultForward, len(p.Rules))}
+	if save {
+		plan = append(plan, "write "+nftPersistPath+" and include it from "+nftMainConfig(), "systemctl enable nftables")
+	}
+	return plan
+}
+
+func (nftBackend) validate(ctx context.Context, p FirewallPolicy) error {
+	script, err := nftScript(p)
+	if err != nil {
+		return err
+	}
+	return runCmd(ctx, []byte(script), "nft", "-c", "-f", "-")
+}
+
+func (nftBackend) apply(ctx context.Context, tx *Transaction, p FirewallPolicy) error {
+	script, err := nftScript(p)
+	if err != nil {
+		return err
+	}
+	return tx.Do("nft -f: load table inet "+nftTable, func() error { return runCmd(ctx, []byte(script), "nft", "-f", "-") })
+}
+
+// nftMainConfig is the file the nftables service loads at boot.
+func nftMainConfig() string {
+	if _, err := os.Stat("/etc/sysconfig/nftables.conf"); err == nil {
+		return "/etc/sysconfig/nftables.conf"
+	}
+	return "/etc/nftables.conf"
+}
+
+func (nftBackend) persistFiles() []string { return []string{nftPersistPath, nftMainConfig()} }
+
+func (nftBackend) persist(ctx context.Context, tx *Transaction, p FirewallPolicy) error {
+	script, err := nftScript(p)
+	if err != nil {
+		return err
+	}
+	if err := tx.Do("write "+nftPersistPath, func() error {
+		if err := os.MkdirAll(filepath.Dir(nftPersistPath), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(nftPersistPath, []byte("# Generated by fortis harden firewall\n"+script), 0o600)
+	}); err != nil {
+		return err
+	}
+	main := nftMainConfig()
+	include := fmt.Sprintf("include %q", nftPersistPath)
+	b, err := os.ReadFile(main)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if !strings.Contains(string(b), include) {
+		if len(b) == 0 {
+			b = []byte("#!/usr/sbin/nft -f\n")
+		}
+		if b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		b = append(b, include+"\n"...)
+		if err := tx.Do("include "+nftPersistPath+" from "+main, func() error { return os.WriteFile(main, b, 0o755) }); err != nil {
+			return err
+		}
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return nil
+	}
+	return tx.Do("systemctl enable nftables", func() error { return runCmd(ctx, nil, "systemctl", "enable", "nftables") })
+}
+
+type iptablesBackend struct{}
+
+// maxMultiportPorts is the multiport match limit; a range counts twice.
+const maxMultiportPorts = 15
+
+// renderFamily returns the filter table for iptables-restore (family 4) or
+// ip6tables-restore (6) in iptables-save form.
+func (iptablesBackend) renderFamily(p FirewallPolicy, family int) string {
+	var b strings.Builder
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":INPUT %s [0:0]\n:FORWARD %s [0:0]\n:OUTPUT %s [0:0]\n",
+		strings.ToUpper(p.DefaultIncoming), strings.ToUpper(p.DefaultForward), strings.ToUpper(p.DefaultOutgoing))
+	icmp := "-p icmp -m icmp --icmp-type 8"
+	if family == 6 {
+		icmp = "-p ipv6-icmp -m icmp6 --icmpv6-type 128"
+	}
+	base := []string{
+		"-A INPUT -i lo -j ACCEPT",
+		"-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"-A INPUT -m conntrack --ctstate INVALID -j DROP",
+		"-A INPUT " + icmp + " -j ACCEPT",
+	}
+	if family == 6 {
+		for _, t := range []int{134, 135, 136} {
+			base = append(base, fmt.Sprintf("-A INPUT -p ipv6-icmp -m icmp6 --icmpv6-type %d -j ACCEPT", t))
+		}
+	}
+	base = append(base, "-A OUTPUT -o lo -j ACCEPT", "-A OUTPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT")
+	if family == 6 {
+		for _, t := range []int{133, 135, 136} {
+			base = append(base, fmt.Sprintf("-A OUTPUT -p ipv6-icmp -m icmp6 --icmpv6-type %d -j ACCEPT", t))
+		}
+	}
+	for _, r := range p.Rules {
+		base = append(base, iptablesRule(r, family)...)
+	}
+	// iptables-save lists chain by chain; keep that order so a loaded
+	// ruleset diffs clean.
+	for _, chain := range []string{"-A INPUT ", "-A OUTPUT "} {
+		for _, ln := range base {
+			if strings.HasPrefix(ln, chain) {
+				b.WriteString(ln + "\n")
+			}
+		}
+	}
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+func iptablesRule(r FirewallRule, family int) []string {
+	if (r.Protocol == "icmp" && family == 6) || (r.Protocol == "icmpv6" && family == 4) {
+		return nil
+	}
+	v4, v6, any := splitSources(r)
+	addrs := []string{""}
+	if !any {
+		addrs = v4
+		if family == 6 {
+			addrs = v6
+		}
+		if len(addrs) == 0 {
+			return nil
+		}
+	}
+	chain, addrFlag := "INPUT", "-s"
+	if r.Direction == "out" {
+		chain, addrFlag = "OUTPUT", "-d"
+	}
+	var protos []string
+	switch r.Protocol {
+	case "tcp", "udp":
+		if len(r.Ports) == 0 {
+			protos = []string{"-p " + r.Protocol}
+			break
+		}
+		for _, group := range multiportGroups(r.portRanges()) {
+			if len(group) == 1 {
+				protos = append(protos, fmt.Sprintf("-p %s -m %s --dport %s", r.Protocol, r.Protocol, iptablesPort(group[0])))
+				continue
+			}
+			ports := make([]string, len(group))
+			for i, pr := range group {
+				ports[i] = iptablesPort(pr)
+			}
+			protos = append(protos, fmt.Sprintf("-p %s -m multiport --dports %s", r.Protocol, strings.Join(ports, ",")))
+		}
+	case "icmp":
+		protos = []string{"-p icmp"}
+	case "icmpv6":
+		protos = []string{"-p ipv6-icmp"}
+	default:
+		protos = []string{""}
+	}
+	target := "-j " + strings.ToUpper(r.Action)
+	if r.Action == "reject" {
+		if family == 6 {
+			target += " --reject-with icmp6-port-unreachable"
+		} else {
+			target += " --reject-with icmp-port-unreachable"
+		}
+	}
+	comment := ""
+	if r.Comment != "" {
+		c := r.Comment
+		if strings.ContainsAny(c, " \t") {
+			c = `"` + c + `"`
+		}
+		comment = "-m comment --comment " + c
+	}
+	var out []string
+	for _, a := range addrs {
+		for _, pm := range protos {
+			parts := []string{"-A", chain}
+			if a != "" {
+				parts = append(parts, addrFlag, a)
+			}
+			for _, s := range []string{pm, comment, target} {
+				if s != "" {
+					parts = append(parts, s)
+				}
+			}
+			out = append(out, strings.Join(parts, " "))
+		}
+	}
+	return out
+}
+
+func iptablesPort(pr PortRange) string {
+	if pr.From == pr.To {
+		return fmt.Sprint(pr.From)
+	}
+	return fmt.Sprintf("%d:%d", pr.From, pr.To)
+}
+
+// multiportGroups splits port ranges into chunks the multiport match
+// accepts.
+func multiportGroups(ranges []PortRange) [][]PortRange {
+	var out [][]PortRange
+	var cur []PortRange
+	used := 0
+	for _, pr := range ranges {
+		n := 1
+		if pr.From != pr.To {
+			n = 2
+		}
+		if used+n > maxMultiportPorts {
+			out = append(out, cur)
+			cur, used = nil, 0
+		}
+		cur = append(cur, pr)
+		used += n
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func haveIP6tables() bool {
+	_, err := exec.LookPath("ip6tables-restore")
+	return err == nil
+}
+
+func (b iptablesBackend) render(p FirewallPolicy) (string, error) {
+	rs := b.renderFamily(p, 4)
+	if haveIP6tables() {
+		rs += "# IPv6\n" + b.renderFamily(p, 6)
+	}
+	return rs, nil
+}
+
+func (iptablesBackend) current(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "iptables-save", "-t", "filter").Output()
+	if err != nil {
+		return "", fmt.Errorf("iptables-save: %w", err)
+	}
+	if !haveIP6tables() {
+		return string(out), nil
+	}
+	out6, err := exec.CommandContext(ctx, "ip6tables-save", "-t", "filter").Output()
+	if err != nil {
+		return "", fmt.Errorf("ip6tables-save: %w", err)
+	}
+	return string(out) + string(out6), nil
+}
+
+func (iptablesBackend) plan(p FirewallPolicy, save bool) []string {
+	plan := []string{fmt.Sprintf("iptables-restore: replace filter table (INPUT %s, OUTPUT %s, FORWARD %s, %d rules)", p.DefaultIncoming, p.DefaultOutgoing, p.DefaultForward, len(p.Rules))}
+	if haveIP6tables() {
+		plan = append(plan, "ip6tables-restore: replace filter table")
+	}
+	if save {
+		plan = append(plan, "save the live rules to "+strings.Join(iptablesBackend{}.persistFiles(), " and "))
+	}
+	return plan
+}
+
+func (b iptablesBackend) validate(ctx context.Context, p FirewallPolicy) error {
+	if err := runCmd(ctx, []byte(b.renderFamily(p, 4)), "iptables-restore", "--test"); err != nil {
+		return err
+	}
+	if !haveIP6tables() {
+		return nil
+	}
+	return runCmd(ctx, []byte(b.renderFamily(p, 6)), "ip6tables-restore", "--test")
+}
+
+func (b iptablesBackend) apply(ctx context.Context, tx *Transaction, p FirewallPolicy) error {
+	v4 := b.renderFamily(p, 4)
+	if err := tx.Do("iptables-restore: load filter table", func() error { return runCmd(ctx, []byte(v4), "iptables-restore") }); err != nil {
+		return err
+	}
+	if !haveIP6tables() {
+		return nil
+	}
+	v6 := b.renderFamily(p, 6)
+	return tx.Do("ip6tables-restore: load filter table", func() error { return runCmd(ctx, []byte(v6), "ip6tables-restore") })
+}
+
+// persistFiles follows iptables-persistent on Debian and the iptables
+// service on RHEL.
+func (iptablesBackend) persistFiles() []string {
+	if _, err := os.Stat("/etc/iptables"); err != nil {
+		if _, err := os.Stat("/etc/sysconfig"); err == nil {
+			return []string{"/etc/sysconfig/iptables", "/etc/sysconfig/ip6tables"}
+		}
+	}
+	return []string{"/etc/iptables/rules.v4", "/etc/iptables/rules.v6"}
+}
+
+func (b iptablesBackend) persist(ctx context.Context, tx *Transaction, _ FirewallPolicy) error {
+	files := b.persistFiles()
+	save := func(path, tool string) error {
+		return tx.Do(tool+" > "+path, func() error {
+			out, err := exec.CommandContext(ctx, tool).Output()
+			if err != nil {
+				return fmt.Errorf("%s: %w", tool, err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(path, out, 0o600)
+		})
+	}
+	if err := save(files[0], "iptables-save"); err != nil {
+		return err
+	}
+	if !haveIP6tables() {
+		return nil
+	}
+	return save(files[1], "ip6tables-save")
+}
+
+// ufwBackend reconciles ufw's rule list with the policy: rules missing from
+// the policy are deleted and missing ones added. ufw has no atomic loader,
+// so the rules change one command at a time.
+type ufwBackend struct{}
+
+func (ufwBackend) render(p FirewallPolicy) (string, error) {
+	lines := []string{
+		"ufw default " + ufwAction(p.DefaultIncoming) + " incoming",
+		"ufw default " + ufwAction(p.DefaultOutgoing) + " outgoing",
+	}
+	for i, r := range p.Rules {
+		if r.Protocol == "icmp" || r.Protocol == "icmpv6" {
+			return "", fmt.Errorf("rule %d: the ufw backend cannot express %s rules", i+1, r.Protocol)
+		}
+		lines = append(lines, ufwRule(r)...)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func ufwAction(a string) string {
+	switch a {
+	case "accept":
+		return "allow"
+	case "drop":
+		return "deny"
+	}
+	return a
+}
+
+// ufwRule renders a rule the way "ufw show added" prints it.
+func ufwRule(r FirewallRule) []string {
+	head := "ufw " + ufwAction(r.Action)
+	if r.Direction == "out" {
+		head += " out"
+	}
+	ports := strings.ReplaceAll(strings.Join(r.Ports, ","), "-", ":")
+	proto := ""
+	if r.Protocol == "tcp" || r.Protocol == "udp" {
+		proto = r.Protocol
+	}
+	comment := ""
+	if r.Comment != "" {
+		comment = " comment '" + r.Comment + "'"
+	}
+	if len(r.Sources) == 0 {
+		switch {
+		case ports != "":
+			return []string{head + " " + ports + "/" + proto + comment}
+		case proto != "":
+			return []string{head + " proto " + proto + " from any to any" + comment}
+		}
+		return []string{head + " from any to any" + comment}
+	}
+	var out []string
+	for _, s := range r.Sources {
+		ln := head + " from " + s + " to any"
+		if r.Direction == "out" {
+			ln = head + " to " + s
+		}
+		if ports != "" {
+			ln += " port " + ports
+		}
+		if proto != "" {
+			ln += " proto " + proto
+		}
+		out = append(out, ln+comment)
+	}
+	return out
+}
+
+func (ufwBackend) current(ctx context.Context) (string, error) {
+	var lines []string
+	status, err := exec.CommandContext(ctx, "ufw", "status", "verbose").Output()
+	if err != nil {
+		return "", fmt.Errorf("ufw status: %w", err)
+	}
+	for _, ln := range strings.Split(string(status), "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(ln), "Default:")
+		if !ok {
+			continue
+		}
+		// Default: deny (incoming), allow (outgoing), disabled (routed)
+		for _, part := range strings.Split(rest, ",") {
+			f := strings.Fields(strings.NewReplacer("(", "", ")", "").Replace(part))
+			if len(f) == 2 && f[1] != "routed" {
+				lines = append(lines, "ufw default "+f[0]+" "+f[1])
+			}
+		}
+	}
+	added, err := exec.CommandContext(ctx, "ufw", "show", "added").Output()
+	if err != nil {
+		return "", fmt.Errorf("ufw show added: %w", err)
+	}
+	for _, ln := range strings.Split(string(added), "\n") {
+		if ln = strings.TrimSpace(ln); strings.HasPrefix(ln, "ufw ") {
+			lines = append(lines, ln)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// ufwCommands turns the diff between the live and generated rules into ufw
+// invocations: deletions first, then defaults and additions.
+func ufwCommands(diff []string) [][]string {
+	var del, add [][]string
+	for _, d := range diff {
+		sign, line := d[:1], strings.TrimPrefix(d[2:], "ufw ")
+		args := splitUFWArgs(line)
+		switch {
+		case sign == "-" && len(args) > 0 && args[0] != "default":
+			del = append(del, append([]string{"--force", "delete"}, args...))
+		case sign == "+":
+			add = append(add, args)
+		}
+	}
+	return append(del, add...)
+}
+
+// splitUFWArgs splits on spaces, keeping single-quoted comments whole.
+func splitUFWArgs(s string) []string {
+	var out []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+func (b ufwBackend) diff(ctx context.Context, p FirewallPolicy) ([]string, error) {
+	cur, err := b.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := b.render(p)
+	if err != nil {
+		return nil, err
+	}
+	return lineDiff(normalizeRuleset(cur), normalizeRuleset(rs)), nil
+}
+
+func (ufwBackend) plan(p FirewallPolicy, save bool) []string {
+	plan := []string{fmt.Sprintf("ufw: delete rules not in the policy, set defaults (incoming %s, outgoing %s) and add %d rules", ufwAction(p.DefaultIncoming), ufwAction(p.DefaultOutgoing), len(p.Rules))}
+	if save {
+		plan = append(plan, "ufw enable")
+	}
+	return plan
+}
+
+func (b ufwBackend) validate(_ context.Context, p FirewallPolicy) error {
+	_, err := b.render(p)
+	return err
+}
+
+func (b ufwBackend) apply(ctx context.Context, tx *Transaction, p FirewallPolicy) error {
+	diff, err := b.diff(ctx, p)
+	if err != nil {
+		return err
+	}
+	for _, args := range ufwCommands(diff) {
+		if err := tx.Do("ufw "+strings.Join(args, " "), func() error { return runCmd(ctx, nil, "ufw", args...) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ufwBackend) persistFiles() []string { return nil }
+
+func (ufwBackend) persist(ctx context.Context, tx *Transaction, _ FirewallPolicy) error {
+	return tx.Do("ufw enable", func() error { return runCmd(ctx, nil, "ufw", "--force", "enable") })
+}
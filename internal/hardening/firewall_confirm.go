@@ -0,0 +1,174 @@
+package hardening
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// confirmMarker in a transaction directory means the firewall change is
+// still waiting for confirmation; the revert timer rolls the transaction
+// back while it exists.
+const confirmMarker = "confirm-pending"
+
+type pendingConfirm struct {
+	Deadline time.Time `json:"deadline"`
+	// Unit is the systemd timer unit, empty when a detached sleep was used.
+	Unit string `json:"unit,omitempty"`
+}
+
+func revertUnitName(id string) string { return "fortis-firewall-revert-" + id }
+
+// armFirewallRevert schedules "fortis harden firewall revert-unconfirmed"
+// for transaction id after timeout. The timer runs outside this process
+// (systemd-run, or a detached sleep), so it fires even when the SSH session
+// that started the change is cut off.
+func armFirewallRevert(ctx context.Context, dir, id string, timeout time.Duration) (time.Time, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs := int(timeout.Round(time.Second) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	pending := pendingConfirm{Deadline: time.Now().Add(time.Duration(secs) * time.Second)}
+	_, noSystemd := exec.LookPath("systemd-run")
+	if noSystemd == nil {
+		pending.Unit = revertUnitName(id)
+	}
+	// The marker goes first so a timer firing early still finds it.
+	if err := writePendingConfirm(dir, id, pending); err != nil {
+		return time.Time{}, err
+	}
+	revert := []string{self, "harden", "firewall", "revert-unconfirmed", "--rollback-dir", dir, id}
+	err = errors.New("neither systemd-run nor setsid is available")
+	if noSystemd == nil {
+		args := append([]string{"--unit", pending.Unit, "--on-active=" + strconv.Itoa(secs) + "s", "--timer-property=AccuracySec=1s", "--"}, revert...)
+		err = runCmd(ctx, nil, "systemd-run", args...)
+	}
+	// Containers and hosts without systemd as init get a detached sleep.
+	// Its output is not captured: waiting for the pipe would wait out the
+	// sleep.
+	if _, lerr := exec.LookPath("setsid"); err != nil && lerr == nil {
+		args := append([]string{"-f", "sh", "-c", `sleep "$0"; exec "$@"`, strconv.Itoa(secs)}, revert...)
+		if rerr := exec.Command("setsid", args...).Run(); rerr != nil {
+			err = fmt.Errorf("%v; setsid: %w", err, rerr)
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		_ = os.Remove(filepath.Join(dir, id, confirmMarker))
+		return time.Time{}, err
+	}
+	appendJournal(filepath.Join(dir, id), JournalEntry{Time: time.Now(), Action: "arm-revert", Detail: pending.Deadline.Format(time.RFC3339)})
+	return pending.Deadline, nil
+}
+
+func writePendingConfirm(dir, id string, p pendingConfirm) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, id, confirmMarker), append(b, '\n'), 0o600)
+}
+
+func readPendingConfirm(dir, id string) (pendingConfirm, bool) {
+	b, err := os.ReadFile(filepath.Join(dir, id, confirmMarker))
+	if err != nil {
+		return pendingConfirm{}, false
+	}
+	var p pendingConfirm
+	_ = json.Unmarshal(b, &p)
+	return p, true
+}
+
+// PendingFirewallConfirmations lists transactions whose revert timer is
+// still armed, newest first.
+func PendingFirewallConfirmations(dir string) ([]TxInfo, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	all, err := ListTransactions(dir)
+	if err != nil {
+		return nil, err
+	}
+	out := []TxInfo{}
+	for _, tx := range all {
+		if tx.Status == TxReverted || tx.Status == TxRolledBack {
+			continue
+		}
+		if _, ok := readPendingConfirm(dir, tx.ID); ok {
+			out = append(out, tx)
+		}
+	}
+	return out, nil
+}
+
+// ConfirmFirewall keeps a firewall change: it removes the pending marker and
+// stops the revert timer. An empty id confirms the newest pending change.
+// Run it from a new SSH session to prove the host is still reachable.
+func ConfirmFirewall(ctx context.Context, dir, id string) (string, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	if id == "" {
+		pending, err := PendingFirewallConfirmations(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(pending) == 0 {
+			return "", errors.New("no firewall change is waiting for confirmation")
+		}
+		id = pending[0].ID
+	}
+	p, ok := readPendingConfirm(dir, id)
+	if !ok {
+		return id, fmt.Errorf("transaction %s is not waiting for confirmation", id)
+	}
+	if err := os.Remove(filepath.Join(dir, id, confirmMarker)); err != nil {
+		return id, err
+	}
+	if p.Unit != "" {
+		// Without the marker the timer is a no-op; stopping it is tidiness.
+		_ = runCmd(ctx, nil, "systemctl", "stop", p.Unit+".timer")
+	}
+	appendJournal(filepath.Join(dir, id), JournalEntry{Time: time.Now(), Action: "confirm"})
+	return id, nil
+}
+
+// RevertUnconfirmedFirewall is what the revert timer runs: it rolls back
+// transaction id unless it was confirmed in the meantime. The rollback is
+// forced, because restoring connectivity matters more than later changes
+// to the same targets. It reports whether a rollback happened.
+func RevertUnconfirmedFirewall(ctx context.Context, dir, id string) (bool, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	if _, ok := readPendingConfirm(dir, id); !ok {
+		return false, nil
+	}
+	marker := filepath.Join(dir, id, confirmMarker)
+	info, err := loadTxInfo(filepath.Join(dir, id))
+	if err != nil {
+		return false, err
+	}
+	if info.Status == TxReverted || info.Status == TxRolledBack {
+		return false, os.Remove(marker)
+	}
+	appendJournal(info.Dir, JournalEntry{Time: time.Now(), Action: "confirm-timeout"})
+	if _, err := Rollback(ctx, RollbackOptions{Dir: dir, ID: id, Force: true}); err != nil {
+		return true, err
+	}
+	return true, os.Remove(marker)
+}
@@ -0,0 +1,358 @@
+package hardening
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PortRange
+		err  bool
+	}{
+		{in: "22", want: PortRange{22, 22}},
+		{in: " 443 ", want: PortRange{443, 443}},
+		{in: "8000-8080", want: PortRange{8000, 8080}},
+		{in: "8000:8080", want: PortRange{8000, 8080}},
+		{in: "1-65535", want: PortRange{1, 65535}},
+		{in: "53-53", want: PortRange{53, 53}},
+		{in: "0", err: true},
+		{in: "65536", err: true},
+		{in: "8080-8000", err: true},
+		{in: "8080:8000", err: true},
+		{in: "1-65536", err: true},
+		{in: "ssh", err: true},
+		{in: "", err: true},
+		{in: "80-", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePortRange(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parsePortRange(%q) = %v, %v; want %v, error %t", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	p := FirewallPolicy{Rules: []FirewallRule{
+		{Direction: "in", Protocol: "tcp", Ports: []string{"80"}, Action: "accept"},
+		{Direction: "in", Protocol: "tcp", Ports: []string{"2200-2299"}, Sources: []string{"10.0.0.0/8"}, Action: "accept"},
+		{Direction: "in", Protocol: "udp", Ports: []string{"53"}, Action: "accept"},
+		{Direction: "in", Protocol: "tcp", Ports: []string{"25"}, Action: "drop"},
+		{Direction: "out", Protocol: "tcp", Ports: []string{"443"}, Action: "accept"},
+	}}
+	tests := []struct {
+		port   int
+		client string
+		want   bool
+	}{
+		{80, "", true},
+		{80, "192.0.2.7", true},
+		{2222, "10.1.2.3", true},
+		{2222, "192.0.2.7", false},
+		{2222, "", true},
+		{53, "", false},
+		{25, "", false},
+		{443, "", false},
+	}
+	for _, tt := range tests {
+		if got := policyAllows(p, tt.port, net.ParseIP(tt.client)); got != tt.want {
+			t.Errorf("policyAllows(%d, %q) = %t, want %t", tt.port, tt.client, got, tt.want)
+		}
+	}
+	if !policyAllows(FirewallPolicy{Rules: []FirewallRule{{Direction: "in", Protocol: "any", Action: "accept"}}}, 22, nil) {
+		t.Error("a portless rule for any protocol does not allow port 22")
+	}
+}
+
+func TestAddSSHGuard(t *testing.T) {
+	guard := func(port, source string) FirewallRule {
+		r := FirewallRule{Direction: "in", Protocol: "tcp", Ports: []string{port}, Action: "accept", Comment: "fortis ssh guard"}
+		if source != "" {
+			r.Sources = []string{source}
+		}
+		return r
+	}
+	web := FirewallRule{Direction: "in", Protocol: "tcp", Ports: []string{"443"}, Action: "accept"}
+	ssh := FirewallRule{Direction: "in", Protocol: "tcp", Ports: []string{"22"}, Action: "accept"}
+	sshFromLAN := FirewallRule{Direction: "in", Protocol: "tcp", Ports: []string{"22"}, Sources: []string{"10.0.0.0/8"}, Action: "accept"}
+	tests := []struct {
+		name  string
+		sshd  string
+		conn  string
+		rules []FirewallRule
+		want  []FirewallRule
+	}{
+		{
+			name:  "port 22 dropped by the policy",
+			rules: []FirewallRule{web},
+			want:  []FirewallRule{guard("22", ""), web},
+		},
+		{
+			name:  "port 22 already accepted",
+			rules: []FirewallRule{ssh},
+			want:  []FirewallRule{ssh},
+		},
+		{
+			name:  "sshd ports from sshd_config",
+			sshd:  "Port 2222\nPort 22\n",
+			rules: []FirewallRule{ssh},
+			want:  []FirewallRule{guard("2222", ""), ssh},
+		},
+		{
+			name:  "session client outside the allowed sources",
+			conn:  "192.0.2.7 51234 192.0.2.1 22",
+			rules: []FirewallRule{sshFromLAN},
+			want:  []FirewallRule{guard("22", "192.0.2.7/32"), sshFromLAN},
+		},
+		{
+			name:  "session client inside the allowed sources",
+			conn:  "10.9.8.7 51234 10.0.0.1 22",
+			rules: []FirewallRule{sshFromLAN, ssh},
+			want:  []FirewallRule{sshFromLAN, ssh},
+		},
+		{
+			name:  "IPv6 session client",
+			conn:  "2001:db8::7 51234 2001:db8::1 22",
+			rules: []FirewallRule{sshFromLAN, ssh},
+			want:  []FirewallRule{sshFromLAN, ssh},
+		},
+		{
+			name:  "IPv6 session client on a restricted port",
+			conn:  "2001:db8::7 51234 2001:db8::1 22",
+			rules: []FirewallRule{sshFromLAN},
+			want:  []FirewallRule{guard("22", "2001:db8::7/128"), sshFromLAN},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_CONNECTION", tt.conn)
+			files := map[string]string{}
+			if tt.sshd != "" {
+				files[SSHDConfigPath] = tt.sshd
+			}
+			p := FirewallPolicy{DefaultIncoming: "drop", Rules: append([]FirewallRule(nil), tt.rules...)}
+			addSSHGuard(&p, mapRoot(files))
+			if !reflect.DeepEqual(p.Rules, tt.want) {
+				t.Errorf("rules = %+v\nwant %+v", p.Rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFirewallPolicy(t *testing.T) {
+	t.Setenv("SSH_CONNECTION", "")
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "custom.yaml")
+	if err := os.WriteFile(policyFile, []byte(`default_outgoing: drop
+rules:
+  - direction: outgoing
+    protocol: udp
+    ports: ["53"]
+    action: allow
+  - ports: ["8000:8080"]
+    sources: ["10.0.0.1"]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    FirewallOptions
+		want    []string // direction protocol ports sources action
+		in, out string
+		err     string
+	}{
+		{
+			name: "profile defaults",
+			opts: FirewallOptions{Profile: "webserver", ConfigDir: dir, NoSSHGuard: true},
+			want: []string{"in tcp 80 - accept", "in tcp 443 - accept"},
+			in:   "drop", out: "accept",
+		},
+		{
+			name: "ports both ways from a source",
+			opts: FirewallOptions{Ports: "8000-8080/tcp,53", Direction: "both", Sources: []string{"10.0.0.0/8"}, NoSSHGuard: true},
+			want: []string{
+				"in tcp 8000-8080 10.0.0.0/8 accept", "out tcp 8000-8080 10.0.0.0/8 accept",
+				"in tcp 53 10.0.0.0/8 accept", "in udp 53 10.0.0.0/8 accept",
+				"out tcp 53 10.0.0.0/8 accept", "out udp 53 10.0.0.0/8 accept",
+			},
+			in: "drop", out: "accept",
+		},
+		{
+			name: "policy file plus ports",
+			opts: FirewallOptions{PolicyFile: policyFile, Ports: "443/tcp", NoSSHGuard: true},
+			want: []string{"out udp 53 - accept", "in tcp 8000-8080 10.0.0.1/32 accept", "in tcp 443 - accept"},
+			in:   "drop", out: "drop",
+		},
+		{
+			name: "no ssh guard when incoming is accepted",
+			opts: FirewallOptions{Ports: "443/tcp", DefaultIncoming: "allow"},
+			want: []string{"in tcp 443 - accept"},
+			in:   "accept", out: "accept",
+		},
+		{
+			name: "reversed range",
+			opts: FirewallOptions{Ports: "8080-8000/tcp"},
+			err:  `invalid port range "8080-8000"`,
+		},
+		{
+			name: "bad direction",
+			opts: FirewallOptions{Ports: "22", Direction: "sideways"},
+			err:  "--direction",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := BuildFirewallPolicy(tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range p.Rules {
+				src := strings.Join(r.Sources, ",")
+				if src == "" {
+					src = "-"
+				}
+				got = append(got, strings.Join([]string{r.Direction, r.Protocol, strings.Join(r.Ports, ","), src, r.Action}, " "))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %q\nwant %q", got, tt.want)
+			}
+			if p.DefaultIncoming != tt.in || p.DefaultOutgoing != tt.out || p.DefaultForward != "drop" {
+				t.Errorf("policies = %s/%s/%s, want %s/%s/drop", p.DefaultIncoming, p.DefaultOutgoing, p.DefaultForward, tt.in, tt.out)
+			}
+		})
+	}
+}
+
+// testFirewallPolicy exercises port sets, ranges, per-family sources,
+// outgoing rules, reject and portless protocols.
+func testFirewallPolicy(t *testing.T) FirewallPolicy {
+	t.Helper()
+	p := FirewallPolicy{DefaultIncoming: "drop", DefaultOutgoing: "accept", DefaultForward: "drop", Rules: []FirewallRule{
+		{Direction: "in", Protocol: "tcp", Ports: []string{"80", "443"}, Comment: "web"},
+		{Direction: "in", Protocol: "tcp", Ports: []string{"8000:8080"}, Sources: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{Direction: "out", Protocol: "udp", Ports: []string{"53"}, Sources: []string{"192.0.2.1"}, Action: "reject"},
+		{Direction: "in", Protocol: "icmp", Action: "drop"},
+	}}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNftRender(t *testing.T) {
+	got, err := nftBackend{}.render(testFirewallPolicy(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `table inet fortis {
+	chain input {
+		type filter hook input priority filter; policy drop;
+		iif "lo" accept
+		ct state established,related accept
+		ct state invalid drop
+		icmp type echo-request accept
+		icmpv6 type { echo-request, nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert } accept
+		tcp dport { 80, 443 } accept comment "web"
+		ip saddr 10.0.0.0/8 tcp dport 8000-8080 accept
+		ip6 saddr 2001:db8::/32 tcp dport 8000-8080 accept
+		meta l4proto icmp drop
+	}
+	chain forward {
+		type filter hook forward priority filter; policy drop;
+	}
+	chain output {
+		type filter hook output priority filter; policy accept;
+		oif "lo" accept
+		ct state established,related accept
+		icmpv6 type { nd-router-solicit, nd-neighbor-solicit, nd-neighbor-advert } accept
+		ip daddr 192.0.2.1 udp dport 53 reject
+	}
+}
+`
+	if got != want {
+		t.Errorf("nft ruleset:\n%s\nwant:\n%s\ndiff: %q", got, want, lineDiff(strings.Split(want, "\n"), strings.Split(got, "\n")))
+	}
+}
+
+func TestIptablesRender(t *testing.T) {
+	p := testFirewallPolicy(t)
+	tests := []struct {
+		family int
+		want   string
+	}{
+		{4, `*filter
+:INPUT DROP [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [0:0]
+-A INPUT -i lo -j ACCEPT
+-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A INPUT -m conntrack --ctstate INVALID -j DROP
+-A INPUT -p icmp -m icmp --icmp-type 8 -j ACCEPT
+-A INPUT -p tcp -m multiport --dports 80,443 -m comment --comment web -j ACCEPT
+-A INPUT -s 10.0.0.0/8 -p tcp -m tcp --dport 8000:8080 -j ACCEPT
+-A INPUT -p icmp -j DROP
+-A OUTPUT -o lo -j ACCEPT
+-A OUTPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A OUTPUT -d 192.0.2.1/32 -p udp -m udp --dport 53 -j REJECT --reject-with icmp-port-unreachable
+COMMIT
+`},
+		{6, `*filter
+:INPUT DROP [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [0:0]
+-A INPUT -i lo -j ACCEPT
+-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A INPUT -m conntrack --ctstate INVALID -j DROP
+-A INPUT -p ipv6-icmp -m icmp6 --icmpv6-type 128 -j ACCEPT
+-A INPUT -p ipv6-icmp -m icmp6 --icmpv6-type 134 -j ACCEPT
+-A INPUT -p ipv6-icmp -m icmp6 --icmpv6-type 135 -j ACCEPT
+-A INPUT -p ipv6-icmp -m icmp6 --icmpv6-type 136 -j ACCEPT
+-A INPUT -p tcp -m multiport --dports 80,443 -m comment --comment web -j ACCEPT
+-A INPUT -s 2001:db8::/32 -p tcp -m tcp --dport 8000:8080 -j ACCEPT
+-A OUTPUT -o lo -j ACCEPT
+-A OUTPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A OUTPUT -p ipv6-icmp -m icmp6 --icmpv6-type 133 -j ACCEPT
+-A OUTPUT -p ipv6-icmp -m icmp6 --icmpv6-type 135 -j ACCEPT
+-A OUTPUT -p ipv6-icmp -m icmp6 --icmpv6-type 136 -j ACCEPT
+COMMIT
+`},
+	}
+	for _, tt := range tests {
+		if got := (iptablesBackend{}).renderFamily(p, tt.family); got != tt.want {
+			t.Errorf("IPv%d ruleset:\n%s\ndiff: %q", tt.family, got, lineDiff(strings.Split(tt.want, "\n"), strings.Split(got, "\n")))
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{nil, nil, nil},
+		{[]string{"a", "b"}, []string{"a", "b"}, nil},
+		{nil, []string{"a"}, []string{"+ a"}},
+		{[]string{"a"}, nil, []string{"- a"}},
+		{[]string{"a", "b", "c"}, []string{"a", "x", "c"}, []string{"- b", "+ x"}},
+		{[]string{"a", "b", "c", "d"}, []string{"b", "c", "e"}, []string{"- a", "- d", "+ e"}},
+		{[]string{"x", "a"}, []string{"a", "x"}, []string{"- x", "+ x"}},
+	}
+	for _, tt := range tests {
+		if got := lineDiff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lineDiff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
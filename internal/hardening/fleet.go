@@ -0,0 +1,543 @@
+package hardening
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"fortis-admin/internal/cluster"
+)
+
+// DefaultFleetDir keeps the last fleet report per target so the next run
+// can show trends.
+const DefaultFleetDir = "/var/lib/fortis/fleet"
+
+// fleetTopN bounds the common-failure and worst-host lists.
+const fleetTopN = 10
+
+type FleetOptions struct {
+	// Targets, as for cluster exec.
+	Group         string
+	Hosts         []string
+	HostsFile     string
+	InventoryPath string
+
+	SSHUser string
+	SSHPort int
+	SSHKey  string
+	// SSHTimeout bounds one host's audit; zero means 10 minutes.
+	SSHTimeout time.Duration
+	Parallel   int
+
+	Profile        string
+	Level          string
+	BenchmarkLevel int
+
+	// RemoteBinary is the fortis command on the hosts. Ship copies the
+	// running binary to each host for the run instead; Sudo runs the audit
+	// through sudo -n for non-root SSH users.
+	RemoteBinary string
+	Ship         bool
+	Sudo         bool
+
+	// HistoryDir holds the previous fleet report; empty uses
+	// DefaultFleetDir.
+	HistoryDir string
+}
+
+type FleetHost struct {
+	Host     string   `json:"host" yaml:"host"`
+	Groups   []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	OK       bool     `json:"ok" yaml:"ok"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+	Score    int      `json:"score" yaml:"score"`
+	Passed   int      `json:"passed" yaml:"passed"`
+	Failed   int      `json:"failed" yaml:"failed"`
+	Warnings int      `json:"warnings" yaml:"warnings"`
+	Waived   int      `json:"waived" yaml:"waived"`
+	// Failing lists failed and warning checks.
+	Failing []string `json:"failing,omitempty" yaml:"failing,omitempty"`
+
+	// Trend against the previous run; PrevScore is nil for new hosts.
+	PrevScore   *int     `json:"prev_score,omitempty" yaml:"prev_score,omitempty"`
+	Delta       int      `json:"delta" yaml:"delta"`
+	NewFailures []string `json:"new_failures,omitempty" yaml:"new_failures,omitempty"`
+	Fixed       []string `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+}
+
+// FleetCheck counts the hosts failing one check.
+type FleetCheck struct {
+	ID       string   `json:"id" yaml:"id"`
+	Title    string   `json:"title" yaml:"title"`
+	Severity Severity `json:"severity,omitempty" yaml:"severity,omitempty"`
+	Hosts    int      `json:"hosts" yaml:"hosts"`
+	Prev     int      `json:"prev" yaml:"prev"`
+	Delta    int      `json:"delta" yaml:"delta"`
+}
+
+type FleetReport struct {
+	Timestamp   time.Time `json:"timestamp" yaml:"timestamp"`
+	Target      string    `json:"target" yaml:"target"`
+	Profile     string    `json:"profile" yaml:"profile"`
+	Level       string    `json:"level" yaml:"level"`
+	Audited     int       `json:"audited" yaml:"audited"`
+	Unreachable int       `json:"unreachable" yaml:"unreachable"`
+	// AverageScore is over audited hosts; Prev* describe the previous run
+	// of the same target and are unset on the first run.
+	AverageScore   float64      `json:"average_score" yaml:"average_score"`
+	PrevAverage    *float64     `json:"prev_average,omitempty" yaml:"prev_average,omitempty"`
+	PrevTimestamp  *time.Time   `json:"prev_timestamp,omitempty" yaml:"prev_timestamp,omitempty"`
+	Hosts          []FleetHost  `json:"hosts" yaml:"hosts"`
+	CommonFailures []FleetCheck `json:"common_failures" yaml:"common_failures"`
+	WorstHosts     []string     `json:"worst_hosts" yaml:"worst_hosts"`
+}
+
+// RunFleetAudit runs "harden audit" on every target host over SSH and
+// aggregates the reports.
+func RunFleetAudit(ctx context.Context, opts FleetOptions) (FleetReport, error) {
+	if opts.Profile == "" {
+		opts.Profile = "cis"
+	}
+	if opts.Level == "" {
+		opts.Level = LevelBasic
+	}
+	if opts.RemoteBinary == "" {
+		opts.RemoteBinary = "fortis"
+	}
+	if opts.SSHTimeout == 0 {
+		opts.SSHTimeout = 10 * time.Minute
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 4
+	}
+	execOpts := cluster.ExecOptions{
+		Group:         opts.Group,
+		Hosts:         opts.Hosts,
+		HostsFile:     opts.HostsFile,
+		InventoryPath: opts.InventoryPath,
+		SSHUser:       opts.SSHUser,
+		SSHPort:       opts.SSHPort,
+		SSHKey:        opts.SSHKey,
+		SSHTimeout:    opts.SSHTimeout,
+		Parallel:      1,
+		Output:        "json",
+	}
+	hosts, inv, err := cluster.ResolveTargets(execOpts)
+	if err != nil {
+		return FleetReport{}, err
+	}
+	localBin := ""
+	if opts.Ship {
+		if localBin, err = os.Executable(); err != nil {
+			return FleetReport{}, fmt.Errorf("locate fortis binary to ship: %w", err)
+		}
+	}
+
+	rep := FleetReport{
+		Timestamp: time.Now(),
+		Target:    fleetTarget(opts),
+		Profile:   opts.Profile,
+		Level:     opts.Level,
+	}
+	prev, err := loadFleetReport(opts.HistoryDir, rep.Target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return FleetReport{}, err
+	}
+
+	reports := make([]*Report, len(hosts))
+	rep.Hosts = make([]FleetHost, len(hosts))
+	sem := make(chan struct{}, opts.Parallel)
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		fh := FleetHost{Host: h}
+		if s := cluster.FindByHostnameOrIP(inv, h); s != nil {
+			fh.Groups = s.Groups
+		}
+		wg.Add(1)
+		go func(i int, fh FleetHost) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r, err := auditRemoteHost(ctx, inv, fh, execOpts, opts, localBin)
+			if err != nil {
+				fh.Error = err.Error()
+			} else {
+				fh.OK = true
+				reports[i] = &r
+			}
+			rep.Hosts[i] = fh
+		}(i, fh)
+	}
+	wg.Wait()
+
+	aggregateFleet(&rep, reports, prev)
+	if rep.Audited == 0 {
+		// Keep the last useful run as the trend reference.
+		return rep, nil
+	}
+	if err := saveFleetReport(opts.HistoryDir, rep); err != nil {
+		return rep, fmt.Errorf("save fleet history: %w", err)
+	}
+	return rep, nil
+}
+
+func fleetTarget(opts FleetOptions) string {
+	if opts.Group != "" {
+		return "group-" + opts.Group
+	}
+	if opts.HostsFile != "" {
+		return "file-" + strings.TrimSuffix(filepath.Base(opts.HostsFile), filepath.Ext(opts.HostsFile))
+	}
+	hs := append([]string(nil), opts.Hosts...)
+	sort.Strings(hs)
+	return "hosts-" + strings.Join(hs, "_")
+}
+
+func auditRemoteHost(ctx context.Context, inv cluster.Inventory, fh FleetHost, execOpts cluster.ExecOptions, opts FleetOptions, localBin string) (Report, error) {
+	bin := opts.RemoteBinary
+	cleanup := ""
+	if localBin != "" {
+		bin = fmt.Sprintf("/tmp/fortis-fleet-%d-%s", os.Getpid(), sanitizeArtifactName(fh.Host))
+		if err := cluster.CopyFile(ctx, inv, fh.Host, execOpts, localBin, bin); err != nil {
+			return Report{}, err
+		}
+		cleanup = "; rm -f " + shellQuote(bin)
+	}
+	args := []string{shellQuote(bin), "harden", "audit",
+		"--profile", shellQuote(opts.Profile), "--level", shellQuote(opts.Level)}
+	if opts.BenchmarkLevel > 0 {
+		args = append(args, "--cis-level", fmt.Sprint(opts.BenchmarkLevel))
+	}
+	if len(fh.Groups) > 0 {
+		args = append(args, "--host-group", shellQuote(strings.Join(fh.Groups, ",")))
+	}
+	if opts.Sudo {
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	// The report goes to a scratch file so only its JSON is printed; on
+	// failure the audit's own output explains why.
+	script := fmt.Sprintf(`d=$(mktemp -d) || exit 1; if %s --output "$d/report.json" >"$d/log" 2>&1; then cat "$d/report.json"; rc=0; else cat "$d/log"; rc=1; fi; rm -rf "$d"%s; exit $rc`,
+		strings.Join(args, " "), cleanup)
+
+	execOpts.Command = script
+	execOpts.Hosts = []string{fh.Host}
+	execOpts.Group = ""
+	execOpts.HostsFile = ""
+	res, err := cluster.Exec(ctx, execOpts)
+	if err != nil {
+		return Report{}, err
+	}
+	if len(res) != 1 {
+		return Report{}, errors.New("no result from host")
+	}
+	out := res[0].Output
+	if !res[0].OK {
+		return Report{}, fmt.Errorf("%s: %s", res[0].Error, lastLine(out))
+	}
+	i := strings.IndexByte(out, '{')
+	if i < 0 {
+		return Report{}, fmt.Errorf("no audit report in output: %s", lastLine(out))
+	}
+	var r Report
+	if err := json.NewDecoder(strings.NewReader(out[i:])).Decode(&r); err != nil {
+		return Report{}, fmt.Errorf("parse audit report: %w", err)
+	}
+	return r, nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// aggregateFleet fills per-host results, common failures, worst hosts and
+// trends against prev (nil on the first run).
+func aggregateFleet(rep *FleetReport, reports []*Report, prev *FleetReport) {
+	prevHosts := map[string]FleetHost{}
+	prevChecks := map[string]int{}
+	if prev != nil {
+		for _, h := range prev.Hosts {
+			if h.OK {
+				prevHosts[h.Host] = h
+			}
+		}
+		// Counts from the previous run's hosts, not just its top list.
+		for _, h := range prev.Hosts {
+			for _, id := range h.Failing {
+				prevChecks[id]++
+			}
+		}
+		if prev.Audited > 0 {
+			avg, ts := prev.AverageScore, prev.Timestamp
+			rep.PrevAverage, rep.PrevTimestamp = &avg, &ts
+		}
+	}
+
+	checks := map[string]*FleetCheck{}
+	total := 0
+	for i, r := range reports {
+		h := &rep.Hosts[i]
+		if r == nil {
+			rep.Unreachable++
+			continue
+		}
+		rep.Audited++
+		h.Score, h.Passed, h.Failed, h.Warnings, h.Waived = r.Score, r.Passed, r.Failed, r.Warnings, r.Waived
+		total += r.Score
+		passed := map[string]bool{}
+		for _, f := range r.Findings {
+			passed[f.ID] = f.Result == ResultPass
+			if f.Result != ResultFail && f.Result != ResultWarn {
+				continue
+			}
+			h.Failing = append(h.Failing, f.ID)
+			c := checks[f.ID]
+			if c == nil {
+				c = &FleetCheck{ID: f.ID, Title: f.Title, Severity: f.Severity}
+				checks[f.ID] = c
+			}
+			c.Hosts++
+		}
+		sort.Strings(h.Failing)
+		if p, ok := prevHosts[h.Host]; ok {
+			score := p.Score
+			h.PrevScore = &score
+			h.Delta = h.Score - p.Score
+			h.NewFailures = subtract(h.Failing, p.Failing)
+			// Only checks that now pass count as fixed, not waived ones or
+			// those a different profile or level left out.
+			for _, id := range subtract(p.Failing, h.Failing) {
+				if passed[id] {
+					h.Fixed = append(h.Fixed, id)
+				}
+			}
+		}
+	}
+	if rep.Audited > 0 {
+		rep.AverageScore = math.Round(float64(total)*10/float64(rep.Audited)) / 10
+	}
+
+	all := make([]FleetCheck, 0, len(checks))
+	for id, c := range checks {
+		c.Prev = prevChecks[id]
+		c.Delta = c.Hosts - c.Prev
+		all = append(all, *c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Hosts != all[j].Hosts {
+			return all[i].Hosts > all[j].Hosts
+		}
+		if a, b := severityRank(all[i].Severity), severityRank(all[j].Severity); a != b {
+			return a > b
+		}
+		return all[i].ID < all[j].ID
+	})
+	if len(all) > fleetTopN {
+		all = all[:fleetTopN]
+	}
+	rep.CommonFailures = all
+
+	sort.SliceStable(rep.Hosts, func(i, j int) bool {
+		a, b := rep.Hosts[i], rep.Hosts[j]
+		if a.OK != b.OK {
+			return a.OK
+		}
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		return a.Host < b.Host
+	})
+	rep.WorstHosts = []string{}
+	for _, h := range rep.Hosts {
+		if h.OK && len(rep.WorstHosts) < fleetTopN {
+			rep.WorstHosts = append(rep.WorstHosts, h.Host)
+		}
+	}
+}
+
+// subtract returns the sorted entries of a missing from b.
+func subtract(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !in[s] {
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func fleetHistoryPath(dir, target string) string {
+	if dir == "" {
+		dir = DefaultFleetDir
+	}
+	return filepath.Join(dir, sanitizeArtifactName(target)+".json")
+}
+
+func loadFleetReport(dir, target string) (*FleetReport, error) {
+	b, err := os.ReadFile(fleetHistoryPath(dir, target))
+	if err != nil {
+		return nil, err
+	}
+	var rep FleetReport
+	if err := json.Unmarshal(b, &rep); err != nil {
+		return nil, fmt.Errorf("%s: %w", fleetHistoryPath(dir, target), err)
+	}
+	return &rep, nil
+}
+
+func saveFleetReport(dir string, rep FleetReport) error {
+	p := fleetHistoryPath(dir, rep.Target)
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// RenderFleet writes a fleet report as JSON, YAML, HTML or Markdown.
+func RenderFleet(w io.Writer, rep FleetReport, format OutputFormat) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(rep)
+	case FormatHTML:
+		return renderFleetHTML(w, rep)
+	case FormatMarkdown:
+		return renderFleetMarkdown(w, rep)
+	default:
+		return fmt.Errorf("fleet reports support json, yaml, html and markdown, not %s", format)
+	}
+}
+
+// FleetTrend formats a delta as "+3", "-2" or "=".
+func FleetTrend(d int) string {
+	switch {
+	case d > 0:
+		return fmt.Sprintf("+%d", d)
+	case d < 0:
+		return fmt.Sprint(d)
+	default:
+		return "="
+	}
+}
+
+func renderFleetMarkdown(w io.Writer, rep FleetReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Fleet Audit Report (%s)\n\n", rep.Target)
+	fmt.Fprintf(&b, "- **Timestamp:** %s\n- **Profile:** %s/%s\n- **Hosts:** %d audited, %d unreachable\n- **Average score:** %.1f",
+		rep.Timestamp.Format(time.RFC3339), rep.Profile, rep.Level, rep.Audited, rep.Unreachable, rep.AverageScore)
+	if rep.PrevAverage != nil {
+		fmt.Fprintf(&b, " (previous %.1f on %s)", *rep.PrevAverage, rep.PrevTimestamp.Format(time.RFC3339))
+	}
+	b.WriteString("\n\n## Hosts\n\n| Host | Score | Trend | Failed | Warnings | Waived | New failures | Fixed |\n|---|---|---|---|---|---|---|---|\n")
+	for _, h := range rep.Hosts {
+		if !h.OK {
+			fmt.Fprintf(&b, "| %s | — | | | | | unreachable: %s | |\n", h.Host, mdEscape(h.Error))
+			continue
+		}
+		trend := "new"
+		if h.PrevScore != nil {
+			trend = FleetTrend(h.Delta)
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s | %d | %d | %d | %s | %s |\n", h.Host, h.Score, trend, h.Failed, h.Warnings, h.Waived,
+			strings.Join(h.NewFailures, ", "), strings.Join(h.Fixed, ", "))
+	}
+	b.WriteString("\n## Most common failing checks\n\n| Check | Severity | Hosts | Trend | Title |\n|---|---|---|---|---|\n")
+	for _, c := range rep.CommonFailures {
+		fmt.Fprintf(&b, "| `%s` | %s | %d | %s | %s |\n", c.ID, c.Severity, c.Hosts, FleetTrend(c.Delta), mdEscape(c.Title))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderFleetHTML(w io.Writer, rep FleetReport) error {
+	const tpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>Fortis Fleet Audit Report</title>
+<style>
+body{font-family:system-ui,-apple-system,Segoe UI,Roboto,Helvetica,Arial,sans-serif;margin:24px}
+code{background:#f2f2f2;padding:2px 6px;border-radius:4px}
+td{vertical-align:top}
+small{color:#555}
+.up{color:#116a2c}
+.down{color:#7d1b1b}
+</style>
+</head>
+<body>
+<h1>Fleet Audit Report ({{ .Target }})</h1>
+<p><b>Timestamp:</b> {{ .Timestamp }}</p>
+<p><b>Profile:</b> {{ .Profile }} <b>Level:</b> {{ .Level }}</p>
+<p><b>Hosts:</b> {{ .Audited }} audited, {{ .Unreachable }} unreachable <b>Average score:</b> {{ printf "%.1f" .AverageScore }}{{ with .PrevAverage }} (previous {{ prev . }}){{ end }}</p>
+<hr/>
+<h2>Hosts</h2>
+<table cellpadding="8" cellspacing="0" border="0">
+<thead><tr><th align="left">Host</th><th align="left">Score</th><th align="left">Trend</th><th align="left">Failed</th><th align="left">Warnings</th><th align="left">Waived</th><th align="left">Changes</th></tr></thead>
+<tbody>
+{{ range .Hosts }}
+<tr>
+<td>{{ .Host }}</td>
+{{ if .OK }}<td>{{ .Score }}</td>
+<td>{{ if .PrevScore }}<span class="{{ if gt .Delta 0 }}up{{ else if lt .Delta 0 }}down{{ end }}">{{ trend .Delta }}</span>{{ else }}new{{ end }}</td>
+<td>{{ .Failed }}</td><td>{{ .Warnings }}</td><td>{{ .Waived }}</td>
+<td>{{ range .NewFailures }}<small class="down">+ {{ . }}</small><br/>{{ end }}{{ range .Fixed }}<small class="up">- {{ . }}</small><br/>{{ end }}</td>
+{{ else }}<td colspan="6"><small>unreachable: {{ .Error }}</small></td>{{ end }}
+</tr>
+{{ end }}
+</tbody>
+</table>
+<h2>Most common failing checks</h2>
+<table cellpadding="8" cellspacing="0" border="0">
+<thead><tr><th align="left">Check</th><th align="left">Severity</th><th align="left">Hosts</th><th align="left">Trend</th><th align="left">Title</th></tr></thead>
+<tbody>
+{{ range .CommonFailures }}
+<tr><td><code>{{ .ID }}</code></td><td>{{ .Severity }}</td><td>{{ .Hosts }}</td><td>{{ trend .Delta }}</td><td>{{ .Title }}</td></tr>
+{{ end }}
+</tbody>
+</table>
+</body>
+</html>`
+
+	t, err := template.New("fleet").Funcs(template.FuncMap{
+		"trend": FleetTrend,
+		"prev":  func(f *float64) string { return fmt.Sprintf("%.1f", *f) },
+	}).Parse(tpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, rep)
+}
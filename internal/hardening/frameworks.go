@@ -0,0 +1,219 @@
+package hardening
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Compliance framework mappings shipped with the binary. A file in
+// <config-dir>/frameworks with the same id replaces the built-in one.
+//
+//go:embed frameworks/*.yaml
+var frameworkFS embed.FS
+
+// Framework maps a compliance standard's controls to audit checks.
+type Framework struct {
+	ID      string   `yaml:"id" json:"id"`
+	Title   string   `yaml:"title" json:"title"`
+	Version string   `yaml:"version" json:"version"`
+	Aliases []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	// Profile is the audit profile whose thresholds the assessment uses.
+	Profile  string    `yaml:"profile" json:"profile"`
+	Controls []Control `yaml:"controls" json:"controls"`
+	Source   string    `yaml:"-" json:"source"`
+}
+
+// Control is one requirement of a framework. Checks are check ID globs; a
+// control without checks cannot be assessed automatically.
+type Control struct {
+	ID       string   `yaml:"id" json:"id"`
+	Title    string   `yaml:"title" json:"title"`
+	Checks   []string `yaml:"checks,omitempty" json:"checks,omitempty"`
+	Guidance string   `yaml:"guidance,omitempty" json:"guidance,omitempty"`
+}
+
+func (c Control) Automated() bool { return len(c.Checks) > 0 }
+
+func (c Control) covers(checkID string) bool {
+	for _, p := range c.Checks {
+		if ok, _ := path.Match(p, checkID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Name is the title with the version, e.g. "PCI DSS 4.0".
+func (f Framework) Name() string {
+	if f.Version == "" {
+		return f.Title
+	}
+	return f.Title + " " + f.Version
+}
+
+// CheckPatterns lists every check glob the framework references.
+func (f Framework) CheckPatterns() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, c := range f.Controls {
+		for _, p := range c.Checks {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+func (f Framework) validate() error {
+	if f.ID == "" {
+		return errors.New("framework id is required")
+	}
+	if len(f.Controls) == 0 {
+		return fmt.Errorf("framework %s: no controls", f.ID)
+	}
+	seen := map[string]bool{}
+	for _, c := range f.Controls {
+		if c.ID == "" {
+			return fmt.Errorf("framework %s: control without id", f.ID)
+		}
+		if seen[c.ID] {
+			return fmt.Errorf("framework %s: duplicate control %s", f.ID, c.ID)
+		}
+		seen[c.ID] = true
+		for _, p := range c.Checks {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("framework %s: control %s: bad check pattern %q", f.ID, c.ID, p)
+			}
+		}
+	}
+	return nil
+}
+
+func parseFramework(source string, b []byte) (Framework, error) {
+	var f Framework
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return Framework{}, fmt.Errorf("%s: %w", source, err)
+	}
+	if f.ID == "" {
+		f.ID = strings.TrimSuffix(path.Base(source), path.Ext(source))
+	}
+	if f.Profile == "" {
+		f.Profile = "cis"
+	}
+	f.Source = source
+	if err := f.validate(); err != nil {
+		return Framework{}, fmt.Errorf("%s: %w", source, err)
+	}
+	return f, nil
+}
+
+// loadFrameworks returns the built-in frameworks overlaid with those in
+// <configDir>/frameworks, keyed by id.
+func loadFrameworks(configDir string) (map[string]Framework, error) {
+	out := map[string]Framework{}
+	names, err := fs.Glob(frameworkFS, "frameworks/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		b, err := frameworkFS.ReadFile(n)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parseFramework("builtin:"+path.Base(n), b)
+		if err != nil {
+			return nil, err
+		}
+		out[f.ID] = f
+	}
+
+	dir := filepath.Join(resolveConfigDir(configDir), "frameworks")
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parseFramework(p, b)
+		if err != nil {
+			return nil, err
+		}
+		out[f.ID] = f
+	}
+	return out, nil
+}
+
+// LoadFramework finds a framework by id or alias (case-insensitive).
+func LoadFramework(configDir, name string) (Framework, error) {
+	all, err := loadFrameworks(configDir)
+	if err != nil {
+		return Framework{}, err
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if f, ok := all[name]; ok {
+		return f, nil
+	}
+	for _, f := range all {
+		for _, a := range f.Aliases {
+			if strings.ToLower(a) == name {
+				return f, nil
+			}
+		}
+	}
+	if repl, ok := DeprecatedStandard(name); ok {
+		if f, ok := all[repl]; ok {
+			return f, nil
+		}
+	}
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return Framework{}, fmt.Errorf("unknown compliance standard %q (available: %s)", name, strings.Join(ids, ", "))
+}
+
+// deprecatedStandards were accepted before standards were mapped to
+// framework controls. GDPR has no technical control catalogue; its report
+// ran the same checks as ISO 27001, which it now resolves to.
+var deprecatedStandards = map[string]string{"gdpr": "iso27001"}
+
+// DeprecatedStandard returns the framework that replaces a retired
+// standard name.
+func DeprecatedStandard(name string) (string, bool) {
+	repl, ok := deprecatedStandards[strings.ToLower(strings.TrimSpace(name))]
+	return repl, ok
+}
+
+// FrameworkNames lists the ids of the built-in and site frameworks.
+func FrameworkNames(configDir string) ([]string, error) {
+	all, err := loadFrameworks(configDir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
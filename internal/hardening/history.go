@@ -0,0 +1,311 @@
+package hardening
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryDir keeps one summary per audit run.
+const DefaultHistoryDir = "/var/lib/fortis/history"
+
+// maxHistoryEntries bounds the store; the oldest runs are pruned first.
+const maxHistoryEntries = 1000
+
+// HistoryEntry is the summary of one audit run kept in the history store.
+type HistoryEntry struct {
+	Timestamp      time.Time      `json:"timestamp"`
+	Hostname       string         `json:"hostname"`
+	Root           string         `json:"root,omitempty"`
+	Profile        string         `json:"profile"`
+	Level          string         `json:"level"`
+	BenchmarkLevel int            `json:"benchmark_level,omitempty"`
+	Score          int            `json:"score"`
+	ScoreLabel     string         `json:"score_label"`
+	Passed         int            `json:"passed"`
+	Failed         int            `json:"failed"`
+	Warnings       int            `json:"warnings"`
+	Skipped        int            `json:"skipped"`
+	Waived         int            `json:"waived"`
+	ReportHash     string         `json:"report_hash"`
+	Checks         []HistoryCheck `json:"checks"`
+}
+
+type HistoryCheck struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Result Result `json:"result"`
+}
+
+// HistoryPoint is one run of a series with the changes since the run
+// before it. A check is newly failing when it fails now and did not before,
+// and fixed when it failed before and passes now.
+type HistoryPoint struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Score       int       `json:"score"`
+	Delta       *int      `json:"delta,omitempty"`
+	Passed      int       `json:"passed"`
+	Failed      int       `json:"failed"`
+	ReportHash  string    `json:"report_hash"`
+	NewFailures []string  `json:"new_failures,omitempty"`
+	Fixed       []string  `json:"fixed,omitempty"`
+}
+
+func historyEntry(rep Report) HistoryEntry {
+	e := HistoryEntry{
+		Timestamp:      rep.Timestamp,
+		Hostname:       rep.Hostname,
+		Root:           rep.Root,
+		Profile:        rep.Profile,
+		Level:          rep.Level,
+		BenchmarkLevel: rep.BenchmarkLevel,
+		Score:          rep.Score,
+		ScoreLabel:     rep.ScoreLabel,
+		Passed:         rep.Passed,
+		Failed:         rep.Failed,
+		Warnings:       rep.Warnings,
+		Skipped:        rep.Skipped,
+		Waived:         rep.Waived,
+		ReportHash:     rep.ReportHash,
+	}
+	for _, f := range rep.Findings {
+		e.Checks = append(e.Checks, HistoryCheck{ID: f.ID, Title: f.Title, Result: f.Result})
+	}
+	return e
+}
+
+// Series identifies runs that are comparable: same host (or image), profile
+// and level.
+func (e HistoryEntry) Series() string {
+	host := e.Hostname
+	if e.Root != "" {
+		host = e.Root
+	}
+	return fmt.Sprintf("%s/%s/%s", host, e.Profile, e.Level)
+}
+
+func historyDir(dir string) string {
+	if dir == "" {
+		return DefaultHistoryDir
+	}
+	return dir
+}
+
+// RecordHistory adds rep to the history store in dir (DefaultHistoryDir when
+// empty) and prunes the oldest runs beyond maxHistoryEntries.
+func RecordHistory(dir string, rep Report) (string, error) {
+	dir = historyDir(dir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	hash := rep.ReportHash
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	name := fmt.Sprintf("%s-%s.json", rep.Timestamp.UTC().Format("20060102T150405Z"), hash)
+	p := filepath.Join(dir, name)
+	b, err := json.MarshalIndent(historyEntry(rep), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return "", err
+	}
+	return p, pruneHistory(dir)
+}
+
+func historyFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	// Names start with the UTC timestamp, so lexical order is run order.
+	sort.Strings(files)
+	return files, nil
+}
+
+func pruneHistory(dir string) error {
+	files, err := historyFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > maxHistoryEntries {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// LoadHistory returns the stored runs, oldest first. A missing store is
+// empty; unreadable entries are skipped.
+func LoadHistory(dir string) ([]HistoryEntry, error) {
+	files, err := historyFiles(historyDir(dir))
+	if err != nil {
+		return nil, err
+	}
+	out := []HistoryEntry{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var e HistoryEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out, nil
+}
+
+// HistoryFilter selects a series. Empty fields match anything.
+type HistoryFilter struct {
+	Host    string
+	Profile string
+	Level   string
+}
+
+func (f HistoryFilter) match(e HistoryEntry) bool {
+	if f.Host != "" && f.Host != e.Hostname && f.Host != e.Root {
+		return false
+	}
+	if f.Profile != "" && !strings.EqualFold(f.Profile, e.Profile) {
+		return false
+	}
+	return f.Level == "" || strings.EqualFold(f.Level, e.Level)
+}
+
+// SelectSeries returns the runs matching filter that belong to the series
+// of the latest match, oldest first.
+func SelectSeries(entries []HistoryEntry, filter HistoryFilter) []HistoryEntry {
+	var series string
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter.match(entries[i]) {
+			series = entries[i].Series()
+			break
+		}
+	}
+	out := []HistoryEntry{}
+	if series == "" {
+		return out
+	}
+	for _, e := range entries {
+		if e.Series() == series && filter.match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// LoadTrend returns the stored runs of rep's series, rep included once it
+// has been recorded.
+func LoadTrend(dir string, rep Report) ([]HistoryPoint, error) {
+	entries, err := LoadHistory(dir)
+	if err != nil {
+		return nil, err
+	}
+	host := rep.Hostname
+	if rep.Root != "" {
+		host = rep.Root
+	}
+	return Trend(SelectSeries(entries, HistoryFilter{Host: host, Profile: rep.Profile, Level: rep.Level})), nil
+}
+
+// Trend turns a series into points with the changes between runs.
+func Trend(series []HistoryEntry) []HistoryPoint {
+	out := make([]HistoryPoint, 0, len(series))
+	for i, e := range series {
+		p := HistoryPoint{Timestamp: e.Timestamp, Score: e.Score, Passed: e.Passed, Failed: e.Failed, ReportHash: e.ReportHash}
+		if i > 0 {
+			prev := series[i-1]
+			d := e.Score - prev.Score
+			p.Delta = &d
+			p.NewFailures, p.Fixed = historyChanges(prev, e)
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func historyChanges(prev, cur HistoryEntry) (newFailures, fixed []string) {
+	before := map[string]Result{}
+	for _, c := range prev.Checks {
+		before[c.ID] = c.Result
+	}
+	for _, c := range cur.Checks {
+		was, seen := before[c.ID]
+		switch {
+		case c.Result == ResultFail && (!seen || was != ResultFail):
+			newFailures = append(newFailures, c.ID)
+		case c.Result == ResultPass && seen && was == ResultFail:
+			fixed = append(fixed, c.ID)
+		}
+	}
+	sort.Strings(newFailures)
+	sort.Strings(fixed)
+	return newFailures, fixed
+}
+
+// maxChartPoints is how many runs the HTML report charts.
+const maxChartPoints = 30
+
+// trendChart draws the score of the last runs as an inline SVG line chart.
+// Fewer than two points draw nothing.
+func trendChart(points []HistoryPoint) template.HTML {
+	if len(points) > maxChartPoints {
+		points = points[len(points)-maxChartPoints:]
+	}
+	if len(points) < 2 {
+		return ""
+	}
+	const w, h, pad = 640.0, 180.0, 28.0
+	x := func(i int) float64 { return pad + float64(i)*(w-2*pad)/float64(len(points)-1) }
+	y := func(score int) float64 { return h - pad - float64(score)*(h-2*pad)/100 }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" role="img" aria-label="Score trend">`, w, h, w, h)
+	for _, g := range []int{0, 50, 100} {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#ddd"/><text x="2" y="%.1f" font-size="10" fill="#777">%d</text>`, pad, y(g), w-pad, y(g), y(g)+3, g)
+	}
+	pts := make([]string, len(points))
+	for i, p := range points {
+		pts[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(p.Score))
+	}
+	fmt.Fprintf(&b, `<polyline fill="none" stroke="#2a5db0" stroke-width="2" points="%s"/>`, strings.Join(pts, " "))
+	for i, p := range points {
+		color := "#2a5db0"
+		if len(p.NewFailures) > 0 {
+			color = "#c0392b"
+		}
+		label := fmt.Sprintf("%s: %d/100", p.Timestamp.Local().Format("2006-01-02 15:04"), p.Score)
+		if len(p.NewFailures) > 0 {
+			label += "; newly failing: " + strings.Join(p.NewFailures, ", ")
+		}
+		if len(p.Fixed) > 0 {
+			label += "; fixed: " + strings.Join(p.Fixed, ", ")
+		}
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3.5" fill="%s"><title>%s</title></circle>`, x(i), y(p.Score), color, html.EscapeString(label))
+	}
+	first, last := points[0].Timestamp.Local(), points[len(points)-1].Timestamp.Local()
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" fill="#777">%s</text>`, pad, h-8, first.Format("2006-01-02"))
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" fill="#777" text-anchor="end">%s</text>`, w-pad, h-8, last.Format("2006-01-02"))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
@@ -2,48 +2,340 @@ package hardening
 
 import (
 	"context"
+	"embed"
 	"errors"
 	"fmt"
-	"os/exec"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Built-in kernel parameter profiles. A file with the same name in
+// <config-dir>/sysctl replaces the built-in one.
+//
+//go:embed sysctl/*.yaml
+var kernelProfileFS embed.FS
+
+// KernelProfile is an ordered list of kernel parameters.
+//
+//	name: web
+//	include: [network, security]
+//	params:
+//	  - key: net.core.somaxconn
+//	    value: "4096"
+//	    op: ge                 # eq (default), in, ge, le, gt, lt, between
+//	  - key: net.ipv4.ip_forward
+//	    value: "0"
+//
+// Included profiles come first. A key defined again replaces the earlier
+// definition in place, so application order stays that of first mention.
+type KernelProfile struct {
+	Name        string        `json:"name" yaml:"name"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Include     []string      `json:"include,omitempty" yaml:"include,omitempty"`
+	Params      []KernelParam `json:"params" yaml:"params"`
+	Source      string        `json:"source" yaml:"-"`
+}
+
+type KernelParam struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+	Op    string `json:"op,omitempty" yaml:"op,omitempty"`
+	// Set is the value written when the runtime value does not satisfy the
+	// comparison. It defaults to Value (the first entry for "in", the lower
+	// bound for "between").
+	Set         string `json:"set,omitempty" yaml:"set,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+var kernelOps = map[string]bool{"eq": true, "in": true, "ge": true, "le": true, "gt": true, "lt": true, "between": true}
+
+func (p KernelParam) op() string { return normalizeOp(p.Op) }
+
+// target is the value written to reach the desired state.
+func (p KernelParam) target() string {
+	if p.Set != "" {
+		return p.Set
+	}
+	switch p.op() {
+	case "in":
+		first, _, _ := strings.Cut(p.Value, ",")
+		return strings.TrimSpace(first)
+	case "between":
+		lo, _, _ := strings.Cut(p.Value, "-")
+		return strings.TrimSpace(lo)
+	}
+	return p.Value
+}
+
+func (p KernelParam) satisfied(got string) (bool, error) {
+	return compareValue(got, p.op(), p.Value)
+}
+
+func (p KernelParam) validate() error {
+	if p.Key == "" || strings.ContainsAny(p.Key, " =") {
+		return fmt.Errorf("invalid key %q", p.Key)
+	}
+	if strings.TrimSpace(p.Value) == "" {
+		return fmt.Errorf("%s: value is required", p.Key)
+	}
+	if !kernelOps[p.op()] {
+		return fmt.Errorf("%s: unsupported op %q", p.Key, p.Op)
+	}
+	// The value written must itself satisfy the comparison.
+	ok, err := p.satisfied(p.target())
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Key, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s: set value %q does not satisfy %s %s", p.Key, p.target(), p.op(), p.Value)
+	}
+	return nil
+}
+
+func parseKernelProfile(source string, b []byte) (KernelProfile, error) {
+	var p KernelProfile
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return KernelProfile{}, fmt.Errorf("%s: %w", source, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(path.Base(source), path.Ext(source))
+	}
+	p.Source = source
+	for i := range p.Params {
+		p.Params[i].Key = strings.ReplaceAll(strings.TrimSpace(p.Params[i].Key), "/", ".")
+		if err := p.Params[i].validate(); err != nil {
+			return KernelProfile{}, fmt.Errorf("%s: param %d: %w", source, i+1, err)
+		}
+	}
+	return p, nil
+}
+
+func readKernelProfile(configDir, name string) (KernelProfile, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return KernelProfile{}, fmt.Errorf("invalid kernel profile name %q", name)
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		p := filepath.Join(resolveConfigDir(configDir), "sysctl", name+ext)
+		b, err := os.ReadFile(p)
+		if err == nil {
+			return parseKernelProfile(p, b)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return KernelProfile{}, err
+		}
+	}
+	b, err := kernelProfileFS.ReadFile("sysctl/" + name + ".yaml")
+	if err != nil {
+		names, _ := KernelProfileNames(configDir)
+		return KernelProfile{}, fmt.Errorf("unknown kernel profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return parseKernelProfile("builtin:"+name+".yaml", b)
+}
+
+// LoadKernelProfile reads <configDir>/sysctl/<name>.yaml, falling back to
+// the built-in profile of that name, and resolves its includes.
+func LoadKernelProfile(configDir, name string) (KernelProfile, error) {
+	return loadKernelProfile(configDir, name, nil)
+}
+
+func loadKernelProfile(configDir, name string, stack []string) (KernelProfile, error) {
+	for _, s := range stack {
+		if s == name {
+			return KernelProfile{}, fmt.Errorf("kernel profile include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+		}
+	}
+	p, err := readKernelProfile(configDir, name)
+	if err != nil {
+		return KernelProfile{}, err
+	}
+	params := []KernelParam{}
+	index := map[string]int{}
+	add := func(kp KernelParam) {
+		if i, ok := index[kp.Key]; ok {
+			params[i] = kp
+			return
+		}
+		index[kp.Key] = len(params)
+		params = append(params, kp)
+	}
+	for _, inc := range p.Include {
+		sub, err := loadKernelProfile(configDir, inc, append(stack, name))
+		if err != nil {
+			return KernelProfile{}, fmt.Errorf("%s: %w", p.Source, err)
+		}
+		for _, kp := range sub.Params {
+			add(kp)
+		}
+	}
+	for _, kp := range p.Params {
+		add(kp)
+	}
+	p.Params = params
+	return p, nil
+}
+
+// KernelProfileNames lists the built-in and site kernel profile names.
+func KernelProfileNames(configDir string) ([]string, error) {
+	seen := map[string]bool{}
+	builtin, err := fs.Glob(kernelProfileFS, "sysctl/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range builtin {
+		seen[strings.TrimSuffix(path.Base(n), ".yaml")] = true
+	}
+	entries, err := os.ReadDir(filepath.Join(resolveConfigDir(configDir), "sysctl"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			seen[strings.TrimSuffix(e.Name(), ext)] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for n := range seen {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ListKernelProfiles loads every kernel profile, includes resolved.
+func ListKernelProfiles(configDir string) ([]KernelProfile, error) {
+	names, err := KernelProfileNames(configDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]KernelProfile, 0, len(names))
+	for _, n := range names {
+		p, err := LoadKernelProfile(configDir, n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
 type KernelOptions struct {
-	ApplyPreset string
+	Profile     string
+	ConfigDir   string
 	Param       string
 	Value       string
 	Persist     bool
 	Yes         bool
 	DryRun      bool
+	RollbackDir string
+}
+
+// Per-key outcomes of ApplyKernel.
+const (
+	KernelUnchanged  = "unchanged"
+	KernelWouldApply = "would-apply"
+	KernelApplied    = "applied"
+	KernelFailed     = "failed"
+	KernelSkipped    = "skipped"
+	KernelReverted   = "reverted"
+)
+
+type KernelParamResult struct {
+	Key    string `json:"key"`
+	Op     string `json:"op"`
+	Want   string `json:"want"`
+	Set    string `json:"set,omitempty"`
+	Before string `json:"before"`
+	After  string `json:"after,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Overridden names a sysctl file read after the fortis one that sets
+	// the key to another value, so the persisted value would not survive a
+	// reboot.
+	Overridden string `json:"overridden,omitempty"`
 }
 
 type KernelResult struct {
-	Plan []string
+	Profile       string              `json:"profile,omitempty"`
+	Params        []KernelParamResult `json:"params"`
+	TransactionID string              `json:"transaction_id,omitempty"`
 }
 
-func ApplyKernel(ctx context.Context, opts KernelOptions) (KernelResult, error) {
-	if runtime.GOOS != "linux" {
-		return KernelResult{Plan: []string{"not supported on this OS"}}, nil
+func kernelParams(opts KernelOptions) (string, []KernelParam, error) {
+	var params []KernelParam
+	name := ""
+	if opts.Profile != "" {
+		p, err := LoadKernelProfile(opts.ConfigDir, opts.Profile)
+		if err != nil {
+			return "", nil, err
+		}
+		name, params = p.Name, p.Params
 	}
-
-	pairs := map[string]string{}
 	if opts.Param != "" {
-		pairs[opts.Param] = opts.Value
+		kp := KernelParam{Key: strings.ReplaceAll(strings.TrimSpace(opts.Param), "/", "."), Value: opts.Value}
+		if err := kp.validate(); err != nil {
+			return "", nil, err
+		}
+		replaced := false
+		for i := range params {
+			if params[i].Key == kp.Key {
+				params[i], replaced = kp, true
+			}
+		}
+		if !replaced {
+			params = append(params, kp)
+		}
+	}
+	if len(params) == 0 {
+		return "", nil, errors.New("no kernel parameters specified")
 	}
-	for k, v := range presetSysctls(opts.ApplyPreset) {
-		pairs[k] = v
+	return name, params, nil
+}
+
+// ApplyKernel sets the parameters of a profile (and/or a single --param) in
+// profile order. Every key is read back after writing; the first key that
+// cannot be set or does not hold its value stops the run and reverts the
+// keys changed before it.
+func ApplyKernel(ctx context.Context, opts KernelOptions) (KernelResult, error) {
+	if runtime.GOOS != "linux" {
+		return KernelResult{}, errors.New("kernel parameters are not supported on this OS")
 	}
-	if len(pairs) == 0 {
-		return KernelResult{}, errors.New("no kernel parameters specified")
+	name, params, err := kernelParams(opts)
+	if err != nil {
+		return KernelResult{}, err
 	}
 
-	res := KernelResult{}
-	for k, v := range pairs {
-		res.Plan = append(res.Plan, fmt.Sprintf("sysctl -w %s=%s", k, v))
-		if opts.Persist {
-			res.Plan = append(res.Plan, fmt.Sprintf("persist %s=%s", k, v))
+	// Unknown keys fail before anything changes.
+	res := KernelResult{Profile: name}
+	var missing []string
+	for _, p := range params {
+		r := KernelParamResult{Key: p.Key, Op: p.op(), Want: p.Value, Set: p.target(), Status: KernelUnchanged}
+		got, err := readSysctl(p.Key)
+		if err != nil {
+			r.Status, r.Error = KernelFailed, "unknown parameter"
+			missing = append(missing, p.Key)
+			res.Params = append(res.Params, r)
+			continue
+		}
+		r.Before = got
+		if ok, _ := p.satisfied(got); !ok {
+			r.Status = KernelWouldApply
 		}
+		res.Params = append(res.Params, r)
+	}
+	if len(missing) > 0 {
+		return res, fmt.Errorf("kernel parameters not available on this kernel: %s", strings.Join(missing, ", "))
+	}
+	if opts.Persist {
+		markOverrides(params, res.Params)
 	}
 
 	if opts.DryRun {
@@ -53,34 +345,95 @@ func ApplyKernel(ctx context.Context, opts KernelOptions) (KernelResult, error)
 		return res, errors.New("refusing to apply kernel changes without --yes")
 	}
 
-	for k, v := range pairs {
-		_ = exec.CommandContext(ctx, "sysctl", "-w", fmt.Sprintf("%s=%s", k, v)).Run()
-		if opts.Persist {
-			if err := persistSysctl(k, v); err != nil {
-				return res, err
+	tx, err := BeginTransaction(opts.RollbackDir, "kernel")
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	err = tx.Finish(ctx, applySysctls(ctx, tx, params, res.Params, opts.Persist))
+	if err != nil {
+		for i := range res.Params {
+			if res.Params[i].Status == KernelApplied {
+				res.Params[i].Status, res.Params[i].After = KernelReverted, res.Params[i].Before
 			}
 		}
 	}
-	return res, nil
+	return res, err
 }
 
-func presetSysctls(name string) map[string]string {
-	switch strings.ToLower(strings.TrimSpace(name)) {
-	case "network":
-		return map[string]string{
-			"net.ipv4.conf.all.accept_redirects": "0",
-			"net.ipv4.conf.all.send_redirects":   "0",
+// applySysctls applies params in order, recording the outcome in results
+// (index-aligned with params). Keys after a failure are skipped.
+func applySysctls(ctx context.Context, tx *Transaction, params []KernelParam, results []KernelParamResult, persist bool) error {
+	for i, p := range params {
+		r := &results[i]
+		if r.Status == KernelUnchanged && !persist {
+			r.After = r.Before
+			continue
+		}
+		if r.Status == KernelWouldApply {
+			if err := applySysctl(ctx, tx, p, r); err != nil {
+				r.Status, r.Error = KernelFailed, err.Error()
+				for j := i + 1; j < len(results); j++ {
+					results[j].Status = KernelSkipped
+				}
+				return fmt.Errorf("%s: %w", p.Key, err)
+			}
+		} else {
+			r.After = r.Before
+		}
+		if !persist {
+			continue
+		}
+		// The current value is persisted: for ge/le keys that already
+		// satisfied the profile it may be stricter than the profile's.
+		k, v := p.Key, r.After
+		if err := tx.SnapshotFile(sysctlPersistPath); err != nil {
+			return err
 		}
-	case "security":
-		return map[string]string{
-			"kernel.kptr_restrict":  "2",
-			"kernel.dmesg_restrict": "1",
+		if err := tx.Do(fmt.Sprintf("Persist sysctl %s=%s", k, v), func() error { return persistSysctl(k, v) }); err != nil {
+			r.Status, r.Error = KernelFailed, err.Error()
+			return err
 		}
-	case "memory":
-		return map[string]string{
-			"vm.mmap_min_addr": "65536",
+	}
+	return nil
+}
+
+func applySysctl(ctx context.Context, tx *Transaction, p KernelParam, r *KernelParamResult) error {
+	if err := tx.SnapshotSysctl(p.Key); err != nil {
+		return err
+	}
+	set := p.target()
+	if err := tx.Do(fmt.Sprintf("sysctl -w %s=%s", p.Key, set), func() error { return setSysctl(ctx, p.Key, set) }); err != nil {
+		return err
+	}
+	got, err := readSysctl(p.Key)
+	if err != nil {
+		return err
+	}
+	r.After = got
+	if ok, _ := p.satisfied(got); !ok {
+		return fmt.Errorf("value is %s after setting %s", got, set)
+	}
+	r.Status = KernelApplied
+	return nil
+}
+
+// markOverrides flags keys that a sysctl file applied after the fortis one
+// sets to a value the profile does not accept. Files apply in name order
+// whatever their directory, and /etc/sysctl.conf applies last.
+func markOverrides(params []KernelParam, results []KernelParamResult) {
+	fortis := path.Base(sysctlPersistPath)
+	for _, d := range sysctlDefinitions(hostRoot) {
+		if d.File != "/etc/sysctl.conf" && path.Base(d.File) <= fortis {
+			continue
+		}
+		for i, p := range params {
+			if d.Key != p.Key {
+				continue
+			}
+			if ok, _ := p.satisfied(d.Value); !ok {
+				results[i].Overridden = d.Origin()
+			}
 		}
-	default:
-		return map[string]string{}
 	}
 }
@@ -0,0 +1,105 @@
+package hardening
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// KernelAuditEntry compares one profile parameter with the running kernel
+// and with the value the sysctl configuration sets at boot.
+type KernelAuditEntry struct {
+	Key            string `json:"key"`
+	Op             string `json:"op"`
+	Want           string `json:"want"`
+	Runtime        string `json:"runtime,omitempty"`
+	RuntimeFound   bool   `json:"runtime_found"`
+	RuntimeOK      bool   `json:"runtime_ok"`
+	Persisted      string `json:"persisted,omitempty"`
+	Origin         string `json:"origin,omitempty"`
+	PersistedFound bool   `json:"persisted_found"`
+	PersistedOK    bool   `json:"persisted_ok"`
+}
+
+// OK reports whether the key is compliant now and after a reboot.
+func (e KernelAuditEntry) OK() bool { return e.RuntimeOK && e.PersistedOK }
+
+// SysctlConflict is a key that several sysctl files set to different
+// values. Effective is the definition that wins at boot.
+type SysctlConflict struct {
+	Key         string             `json:"key"`
+	Definitions []SysctlDefinition `json:"definitions"`
+	Effective   SysctlDefinition   `json:"effective"`
+}
+
+type KernelAudit struct {
+	Profile   string             `json:"profile"`
+	Entries   []KernelAuditEntry `json:"entries"`
+	Conflicts []SysctlConflict   `json:"conflicts"`
+	Failed    int                `json:"failed"`
+}
+
+// AuditKernel compares the parameters of a kernel profile with the running
+// kernel (/proc/sys) and with the persisted sysctl configuration, and lists
+// keys defined with different values in several configuration files.
+// A key the configuration does not set keeps the kernel default at boot,
+// so it is compliant after a reboot only if the runtime value is the
+// default; that cannot be known, and it counts as not persisted.
+func AuditKernel(configDir, profile string) (KernelAudit, error) {
+	if runtime.GOOS != "linux" {
+		return KernelAudit{}, errors.New("kernel parameters are not supported on this OS")
+	}
+	p, err := LoadKernelProfile(configDir, profile)
+	if err != nil {
+		return KernelAudit{}, err
+	}
+	defs := sysctlDefinitions(hostRoot)
+	effective := map[string]SysctlDefinition{}
+	for _, d := range defs {
+		effective[d.Key] = d
+	}
+	out := KernelAudit{Profile: p.Name, Entries: []KernelAuditEntry{}, Conflicts: sysctlConflicts(defs)}
+	for _, kp := range p.Params {
+		e := KernelAuditEntry{Key: kp.Key, Op: kp.op(), Want: kp.Value}
+		if got, err := readSysctl(kp.Key); err == nil {
+			e.Runtime, e.RuntimeFound = got, true
+			e.RuntimeOK, _ = kp.satisfied(got)
+		}
+		if d, ok := effective[kp.Key]; ok {
+			e.Persisted, e.Origin, e.PersistedFound = d.Value, d.Origin(), true
+			e.PersistedOK, _ = kp.satisfied(d.Value)
+		}
+		if !e.OK() {
+			out.Failed++
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	return out, nil
+}
+
+// sysctlConflicts returns the keys set to different values in more than
+// one place, sorted by key. Repeating the same value is not a conflict.
+func sysctlConflicts(defs []SysctlDefinition) []SysctlConflict {
+	byKey := map[string][]SysctlDefinition{}
+	for _, d := range defs {
+		byKey[d.Key] = append(byKey[d.Key], d)
+	}
+	out := []SysctlConflict{}
+	for k, ds := range byKey {
+		if len(ds) < 2 {
+			continue
+		}
+		differ := false
+		for _, d := range ds[1:] {
+			if !strings.EqualFold(d.Value, ds[0].Value) {
+				differ = true
+			}
+		}
+		if differ {
+			out = append(out, SysctlConflict{Key: k, Definitions: ds, Effective: ds[len(ds)-1]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
@@ -2,78 +2,575 @@ package hardening
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type PackageAuditOptions struct {
 	List bool
+	// Root audits the packages of an image instead of the host.
+	Root string
+	// Feeds are feed files or directories; empty uses DefaultVulnFeedDir.
+	Feeds []string
+	// MinSeverity drops vulnerabilities rated below it.
+	MinSeverity Severity
 }
 
 type PackageAuditReport struct {
-	Manager       string   `json:"manager" yaml:"manager"`
-	TotalPackages int      `json:"total_packages" yaml:"total_packages"`
-	Packages      []string `json:"packages,omitempty" yaml:"packages,omitempty"`
-	Note          string   `json:"note" yaml:"note"`
+	Manager         string         `json:"manager" yaml:"manager"`
+	Distribution    string         `json:"distribution,omitempty" yaml:"distribution,omitempty"`
+	TotalPackages   int            `json:"total_packages" yaml:"total_packages"`
+	Packages        []string       `json:"packages,omitempty" yaml:"packages,omitempty"`
+	Feeds           []VulnFeedInfo `json:"feeds" yaml:"feeds"`
+	Vulnerabilities []PackageVuln  `json:"vulnerabilities" yaml:"vulnerabilities"`
+	Critical        int            `json:"critical" yaml:"critical"`
+	High            int            `json:"high" yaml:"high"`
+	Medium          int            `json:"medium" yaml:"medium"`
+	Low             int            `json:"low" yaml:"low"`
+	Note            string         `json:"note,omitempty" yaml:"note,omitempty"`
+}
+
+// PackageVuln is a vulnerability affecting an installed package.
+type PackageVuln struct {
+	// ID is the CVE, or the advisory ID for entries naming no CVE.
+	ID       string `json:"id" yaml:"id"`
+	Advisory string `json:"advisory,omitempty" yaml:"advisory,omitempty"`
+	// Package is the name the feed uses, often the source package;
+	// Installed lists the installed binary packages built from it.
+	Package          string   `json:"package" yaml:"package"`
+	Installed        []string `json:"installed" yaml:"installed"`
+	InstalledVersion string   `json:"installed_version" yaml:"installed_version"`
+	// FixedVersion is empty when no fix has been released.
+	FixedVersion string   `json:"fixed_version,omitempty" yaml:"fixed_version,omitempty"`
+	Severity     Severity `json:"severity" yaml:"severity"`
+	Summary      string   `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Feed         string   `json:"feed" yaml:"feed"`
+}
+
+// InstalledPackage is a binary package and the source package it was built
+// from.
+type InstalledPackage struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Arch          string `json:"arch,omitempty"`
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+}
+
+// versionFor returns the version to compare when the package is looked up
+// as name: feeds naming the source package carry source versions, which
+// differ from the binary's for binNMUs (5.2.15-2 vs 5.2.15-2+b9).
+func (p InstalledPackage) versionFor(name string) string {
+	if name == p.Source && p.SourceVersion != "" {
+		return p.SourceVersion
+	}
+	return p.Version
+}
+
+// PackageInventory is the package database of a host or image and the
+// distribution release it belongs to (from os-release).
+type PackageInventory struct {
+	Manager  string
+	Distro   string
+	Release  string
+	Codename string
+	Pretty   string
+	Packages []InstalledPackage
+
+	index map[string][]int
+}
+
+// lookup returns the packages named name or built from source name.
+func (inv *PackageInventory) lookup(name string) []InstalledPackage {
+	if inv.index == nil {
+		inv.index = map[string][]int{}
+		for i, p := range inv.Packages {
+			inv.index[p.Name] = append(inv.index[p.Name], i)
+			if p.Source != "" && p.Source != p.Name {
+				inv.index[p.Source] = append(inv.index[p.Source], i)
+			}
+		}
+	}
+	var out []InstalledPackage
+	for _, i := range inv.index[name] {
+		out = append(out, inv.Packages[i])
+	}
+	return out
+}
+
+// matchesRelease reports whether a feed entry for release applies: an
+// empty release applies to all, a codename or version must match, and a
+// major version covers its minor releases (9 covers 9.3).
+func (inv *PackageInventory) matchesRelease(release string) bool {
+	if release == "" {
+		return true
+	}
+	return release == inv.Codename || release == inv.Release || strings.HasPrefix(inv.Release, release+".")
 }
 
 func AuditPackages(ctx context.Context, opts PackageAuditOptions) (PackageAuditReport, error) {
-	rep := PackageAuditReport{Note: "CVE integration not implemented yet"}
-	if runtime.GOOS != "linux" {
+	rep := PackageAuditReport{Feeds: []VulnFeedInfo{}, Vulnerabilities: []PackageVuln{}}
+	var root *auditRoot
+	if opts.Root != "" {
+		r, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return rep, err
+		}
+		root = r
+	} else if runtime.GOOS != "linux" {
 		rep.Manager = "unsupported"
 		return rep, nil
 	}
 
+	inv, err := readPackageInventory(ctx, root)
+	if err != nil {
+		return rep, err
+	}
+	rep.Manager, rep.Distribution, rep.TotalPackages = inv.Manager, inv.Pretty, len(inv.Packages)
+	if opts.List {
+		for _, p := range inv.Packages {
+			rep.Packages = append(rep.Packages, p.Name+" "+p.Version)
+		}
+	}
+
+	feeds := opts.Feeds
+	if len(feeds) == 0 {
+		feeds = []string{DefaultVulnFeedDir}
+	}
+	db, err := LoadVulnFeeds(feeds)
+	if err != nil {
+		return rep, err
+	}
+	rep.Feeds = append(rep.Feeds, db.Feeds...)
+	if db.Empty() {
+		rep.Note = "no vulnerability feed loaded; import one with 'fortis harden package-audit --import <file>'"
+		return rep, nil
+	}
+	for _, v := range db.Match(inv) {
+		if opts.MinSeverity != "" && severityRank(v.Severity) < severityRank(opts.MinSeverity) {
+			continue
+		}
+		rep.Vulnerabilities = append(rep.Vulnerabilities, v)
+		switch v.Severity {
+		case SeverityCritical:
+			rep.Critical++
+		case SeverityHigh:
+			rep.High++
+		case SeverityMedium:
+			rep.Medium++
+		default:
+			rep.Low++
+		}
+	}
+	return rep, nil
+}
+
+// Match returns the vulnerabilities affecting inv, most severe first. An
+// entry is reported once per CVE and package; when several feeds know it,
+// the highest severity and fixed version win.
+func (db *VulnDB) Match(inv *PackageInventory) []PackageVuln {
+	found := map[string]*PackageVuln{}
+	add := func(ids []string, advisory, pkg string, installed []InstalledPackage, fixed string, sev Severity, summary, feed string) {
+		if len(ids) == 0 {
+			ids, advisory = []string{advisory}, ""
+		}
+		for _, id := range ids {
+			key := id + "\x00" + pkg
+			v, ok := found[key]
+			if !ok {
+				v = &PackageVuln{ID: id, Advisory: advisory, Package: pkg, InstalledVersion: installed[0].versionFor(pkg), FixedVersion: fixed, Severity: sev, Summary: summary, Feed: feed}
+				found[key] = v
+			}
+			if severityRank(sev) > severityRank(v.Severity) {
+				v.Severity = sev
+			}
+			if fixed != "" && (v.FixedVersion == "" || comparePackageVersions(inv.Manager, fixed, v.FixedVersion) > 0) {
+				v.FixedVersion = fixed
+			}
+			for _, p := range installed {
+				if !containsString(v.Installed, p.Name) {
+					v.Installed = append(v.Installed, p.Name)
+				}
+			}
+		}
+	}
+
+	for _, r := range db.records {
+		if r.Distro != inv.Distro || !inv.matchesRelease(r.Release) {
+			continue
+		}
+		var hit []InstalledPackage
+		fixed := ""
+		for _, p := range inv.lookup(r.Package) {
+			if ok, fx := r.affects(inv.Manager, p.versionFor(r.Package)); ok {
+				hit, fixed = append(hit, p), fx
+			}
+		}
+		if len(hit) > 0 {
+			advisory := ""
+			if !strings.HasPrefix(r.ID, "CVE-") {
+				advisory = r.ID
+			}
+			add(r.CVEs, advisory, r.Package, hit, fixed, r.Severity, r.Summary, r.Feed)
+		}
+	}
+
+	for _, feed := range db.oval {
+		for _, d := range feed.defs {
+			if d.Class != "patch" && d.Class != "vulnerability" {
+				continue
+			}
+			var hits []ovalHit
+			if !feed.evalCriteria(d.Criteria, inv, &hits) {
+				continue
+			}
+			advisory := ""
+			for _, r := range d.Refs {
+				if r.Source != "CVE" {
+					advisory = r.RefID
+					break
+				}
+			}
+			if advisory == "" {
+				advisory = d.ID
+			}
+			for _, h := range hits {
+				add(d.cves(), advisory, h.Package, inv.lookup(h.Package), h.Fixed, d.severity(), d.Title, feed.path)
+			}
+		}
+	}
+
+	out := make([]PackageVuln, 0, len(found))
+	for _, v := range found {
+		sort.Strings(v.Installed)
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := severityRank(out[i].Severity), severityRank(out[j].Severity); a != b {
+			return a > b
+		}
+		if out[i].Package != out[j].Package {
+			return out[i].Package < out[j].Package
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readPackageInventory lists the installed packages of root (the host when
+// nil) with dpkg or rpm.
+func readPackageInventory(ctx context.Context, root *auditRoot) (*PackageInventory, error) {
+	inv := &PackageInventory{}
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		b, err := root.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		for _, ln := range strings.Split(string(b), "\n") {
+			k, v, ok := strings.Cut(strings.TrimSpace(ln), "=")
+			if !ok {
+				continue
+			}
+			v = strings.Trim(v, `"'`)
+			switch k {
+			case "ID":
+				inv.Distro = v
+			case "VERSION_ID":
+				inv.Release = v
+			case "VERSION_CODENAME":
+				inv.Codename = v
+			case "PRETTY_NAME":
+				inv.Pretty = v
+			}
+		}
+		break
+	}
+
+	var err error
+	if root.isImage() {
+		err = readImagePackages(ctx, root, inv)
+	} else {
+		err = readHostPackages(ctx, inv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(inv.Packages, func(i, j int) bool { return inv.Packages[i].Name < inv.Packages[j].Name })
+	return inv, nil
+}
+
+const (
+	dpkgQueryFormat = "${db:Status-Abbrev}\t${Package}\t${Version}\t${Architecture}\t${source:Package}\t${source:Version}\n"
+	rpmQueryFormat  = "%{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\t%{SOURCERPM}\n"
+)
+
+func readHostPackages(ctx context.Context, inv *PackageInventory) error {
 	if _, err := exec.LookPath("dpkg-query"); err == nil {
-		rep.Manager = "dpkg"
-		cmd := exec.CommandContext(ctx, "dpkg-query", "-W", "-f", "${binary:Package}\n")
-		out, err := cmd.StdoutPipe()
+		inv.Manager = "dpkg"
+		out, err := exec.CommandContext(ctx, "dpkg-query", "-W", "-f", dpkgQueryFormat).Output()
 		if err != nil {
-			return rep, err
+			return fmt.Errorf("dpkg-query: %w", err)
 		}
-		if err := cmd.Start(); err != nil {
-			return rep, err
+		inv.Packages = parseDpkgQuery(out)
+		return nil
+	}
+	if _, err := exec.LookPath("rpm"); err == nil {
+		inv.Manager = "rpm"
+		out, err := exec.CommandContext(ctx, "rpm", "-qa", "--qf", rpmQueryFormat).Output()
+		if err != nil {
+			return fmt.Errorf("rpm: %w", err)
 		}
-		s := bufio.NewScanner(out)
-		for s.Scan() {
-			rep.TotalPackages++
-			if opts.List {
-				rep.Packages = append(rep.Packages, s.Text())
+		inv.Packages = parseRPMQuery(out)
+		return nil
+	}
+	return errors.New("no supported package manager (dpkg or rpm)")
+}
+
+func readImagePackages(ctx context.Context, root *auditRoot, inv *PackageInventory) error {
+	if b, err := root.ReadFile("/var/lib/dpkg/status"); err == nil {
+		inv.Manager = "dpkg"
+		for _, stanza := range strings.Split(string(b), "\n\n") {
+			if p, ok := dpkgStanzaPackage(stanza, true); ok {
+				inv.Packages = append(inv.Packages, p)
 			}
-			if rep.TotalPackages > 5000 && opts.List {
-				// avoid huge output
-				break
+		}
+		return nil
+	}
+	if files, _ := root.Glob("/var/lib/dpkg/status.d/*"); len(files) > 0 {
+		// Distroless images keep one stanza per package and no Status.
+		inv.Manager = "dpkg"
+		for _, f := range files {
+			if b, err := root.ReadFile(f); err == nil {
+				if p, ok := dpkgStanzaPackage(string(b), false); ok {
+					inv.Packages = append(inv.Packages, p)
+				}
 			}
 		}
-		_ = cmd.Wait()
-		return rep, nil
+		return nil
 	}
+	if root.exists("/var/lib/rpm") || root.exists("/usr/lib/sysimage/rpm") {
+		dir, ok := root.fsys.(dirFS)
+		if !ok {
+			return fmt.Errorf("the rpm database cannot be read from an image archive; audit the mounted root instead")
+		}
+		if _, err := exec.LookPath("rpm"); err != nil {
+			return fmt.Errorf("rpm is required to read the image's rpm database")
+		}
+		inv.Manager = "rpm"
+		out, err := exec.CommandContext(ctx, "rpm", "--root", string(dir), "-qa", "--qf", rpmQueryFormat).Output()
+		if err != nil {
+			return fmt.Errorf("rpm: %w", err)
+		}
+		inv.Packages = parseRPMQuery(out)
+		return nil
+	}
+	return fmt.Errorf("no dpkg or rpm package database found in image")
+}
 
-	if _, err := exec.LookPath("rpm"); err == nil {
-		rep.Manager = "rpm"
-		cmd := exec.CommandContext(ctx, "rpm", "-qa")
-		out, err := cmd.StdoutPipe()
+func parseDpkgQuery(out []byte) []InstalledPackage {
+	var pkgs []InstalledPackage
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.Split(s.Text(), "\t")
+		if len(f) < 6 || !strings.HasPrefix(f[0], "ii") {
+			continue
+		}
+		p := InstalledPackage{Name: f[1], Version: f[2], Arch: f[3], Source: f[4], SourceVersion: f[5]}
+		if p.Source == "" {
+			p.Source = p.Name
+		}
+		if p.SourceVersion == "" {
+			p.SourceVersion = p.Version
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// dpkgStanzaPackage reads a status file stanza. "Source: name (version)"
+// carries the source version when it differs from the binary's.
+func dpkgStanzaPackage(stanza string, needStatus bool) (InstalledPackage, bool) {
+	p := InstalledPackage{Name: controlField(stanza, "Package"), Version: controlField(stanza, "Version"), Arch: controlField(stanza, "Architecture")}
+	if p.Name == "" || p.Version == "" {
+		return p, false
+	}
+	if needStatus && !strings.Contains(controlField(stanza, "Status"), "install ok installed") {
+		return p, false
+	}
+	p.Source, p.SourceVersion = p.Name, p.Version
+	if src := controlField(stanza, "Source"); src != "" {
+		name, ver, ok := strings.Cut(src, " ")
+		p.Source = name
+		if ok {
+			p.SourceVersion = strings.Trim(strings.TrimSpace(ver), "()")
+		}
+	}
+	return p, true
+}
+
+func parseRPMQuery(out []byte) []InstalledPackage {
+	var pkgs []InstalledPackage
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.Split(s.Text(), "\t")
+		if len(f) < 4 || f[0] == "gpg-pubkey" {
+			continue
+		}
+		p := InstalledPackage{Name: f[0], Version: f[1], Arch: f[2], Source: srpmName(f[3]), SourceVersion: f[1]}
+		if p.Source == "" {
+			p.Source = p.Name
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// srpmName extracts "openssl" from "openssl-3.0.7-24.el9.src.rpm".
+func srpmName(srpm string) string {
+	s := strings.TrimSuffix(strings.TrimSuffix(srpm, ".rpm"), ".src")
+	for i := 0; i < 2; i++ {
+		j := strings.LastIndexByte(s, '-')
+		if j <= 0 {
+			return ""
+		}
+		s = s[:j]
+	}
+	return s
+}
+
+// ImportVulnFeed checks that src parses as a feed and copies it into dir
+// (DefaultVulnFeedDir when empty), replacing a feed of the same name.
+func ImportVulnFeed(src, dir string) (VulnFeedInfo, error) {
+	if dir == "" {
+		dir = DefaultVulnFeedDir
+	}
+	if !vulnFeedFile(filepath.Base(src)) {
+		return VulnFeedInfo{}, fmt.Errorf("%s: unknown feed format (want .json, .zip or .xml, optionally .gz or .bz2)", src)
+	}
+	check := &VulnDB{}
+	if err := check.loadFile(src); err != nil {
+		return VulnFeedInfo{}, fmt.Errorf("%s: %w", src, err)
+	}
+	info := check.Feeds[0]
+	if info.Entries == 0 {
+		return info, fmt.Errorf("%s: no entries for system packages", src)
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return info, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return info, err
+	}
+	dst := filepath.Join(dir, filepath.Base(src))
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return info, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return info, err
+	}
+	info.Path = dst
+	return info, nil
+}
+
+// packageScan runs the package audit once per audit run for the
+// packages.cve_* checks.
+type packageScan struct {
+	once sync.Once
+	rep  PackageAuditReport
+	err  error
+}
+
+func (s *packageScan) get(ctx context.Context, opts AuditOptions) (PackageAuditReport, error) {
+	run := func() (PackageAuditReport, error) {
+		return AuditPackages(ctx, PackageAuditOptions{Root: opts.Root, Feeds: opts.VulnFeeds})
+	}
+	if s == nil {
+		return run()
+	}
+	s.once.Do(func() { s.rep, s.err = run() })
+	return s.rep, s.err
+}
+
+// maxVulnDetails bounds the CVEs listed in a finding.
+const maxVulnDetails = 10
+
+// packageVulnCheck fails when an installed package has a vulnerability of
+// severity sev with a fixed version available, and warns when none of them
+// can be fixed yet.
+func packageVulnCheck(sev Severity) checkFunc {
+	return func(ctx context.Context, opts AuditOptions) (Finding, error) {
+		f := Finding{}
+		if runtime.GOOS != "linux" && !opts.root.isImage() {
+			f.Result = ResultSkip
+			f.Details = "not supported on this OS"
+			return f, nil
+		}
+		rep, err := opts.packages.get(ctx, opts)
 		if err != nil {
-			return rep, err
+			return f, err
 		}
-		if err := cmd.Start(); err != nil {
-			return rep, err
+		if rep.Note != "" {
+			f.Result = ResultSkip
+			f.Details = "no vulnerability feed imported"
+			f.Recommendation = "Import an OSV, Debian security tracker or OVAL feed with 'fortis harden package-audit --import <file>'"
+			return f, nil
 		}
-		s := bufio.NewScanner(out)
-		for s.Scan() {
-			rep.TotalPackages++
-			if opts.List {
-				rep.Packages = append(rep.Packages, s.Text())
+		var fixable, unfixed []string
+		refs := []string{}
+		for _, v := range rep.Vulnerabilities {
+			if v.Severity != sev {
+				continue
 			}
-			if rep.TotalPackages > 5000 && opts.List {
-				break
+			if v.FixedVersion != "" {
+				fixable = append(fixable, fmt.Sprintf("%s %s %s (fixed in %s)", v.ID, v.Package, v.InstalledVersion, v.FixedVersion))
+			} else {
+				unfixed = append(unfixed, fmt.Sprintf("%s %s %s (no fix yet)", v.ID, v.Package, v.InstalledVersion))
+			}
+			if len(refs) < maxVulnDetails {
+				refs = append(refs, v.ID)
 			}
 		}
-		_ = cmd.Wait()
-		return rep, nil
+		f.References = refs
+		switch {
+		case len(fixable) > 0:
+			f.Result = ResultFail
+			f.Details = fmt.Sprintf("%d fixable, %d unfixed: %s", len(fixable), len(unfixed), truncateList(append(fixable, unfixed...), maxVulnDetails))
+			f.Recommendation = "Upgrade the affected packages; 'fortis harden package-audit' lists them all"
+		case len(unfixed) > 0:
+			f.Result = ResultWarn
+			f.Details = fmt.Sprintf("%d without a released fix: %s", len(unfixed), truncateList(unfixed, maxVulnDetails))
+			f.Recommendation = "Mitigate or accept the risk until a fixed package is released"
+		default:
+			f.Result = ResultPass
+			f.Details = fmt.Sprintf("%d packages checked against %d feeds", rep.TotalPackages, len(rep.Feeds))
+		}
+		return f, nil
 	}
+}
 
-	rep.Manager = "unknown"
-	return rep, nil
+func truncateList(items []string, n int) string {
+	if len(items) <= n {
+		return strings.Join(items, "; ")
+	}
+	return strings.Join(items[:n], "; ") + fmt.Sprintf("; and %d more", len(items)-n)
 }
@@ -0,0 +1,1181 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	pamDir           = "/etc/pam.d"
+	pwqualityConf    = "/etc/security/pwquality.conf"
+	pwqualityConfDir = "/etc/security/pwquality.conf.d"
+	faillockConf     = "/etc/security/faillock.conf"
+	pwhistoryConf    = "/etc/security/pwhistory.conf"
+	authselectConf   = "/etc/authselect/authselect.conf"
+)
+
+// PAM audit issue kinds, the Check field of PAMIssue.
+const (
+	PAMIssueQuality    = "pwquality"
+	PAMIssueMinLen     = "minlen"
+	PAMIssueComplexity = "complexity"
+	PAMIssueLockout    = "lockout"
+	PAMIssueDeny       = "deny"
+	PAMIssueUnlockTime = "unlock_time"
+	PAMIssueHistory    = "history"
+	PAMIssueHash       = "hash"
+	PAMIssueNullok     = "nullok"
+	PAMIssueModule     = "module"
+	PAMIssueSyntax     = "syntax"
+)
+
+// pamStackSets are the shared stacks services include. Debian splits them
+// by type into common-*; RHEL has system-auth for local logins and
+// password-auth for remote ones, each with every type.
+var pamStackSets = []struct {
+	name  string
+	files map[string]string
+}{
+	{"common", map[string]string{"auth": pamDir + "/common-auth", "account": pamDir + "/common-account", "password": pamDir + "/common-password"}},
+	{"system-auth", map[string]string{"auth": pamDir + "/system-auth", "account": pamDir + "/system-auth", "password": pamDir + "/system-auth"}},
+	{"password-auth", map[string]string{"auth": pamDir + "/password-auth", "account": pamDir + "/password-auth", "password": pamDir + "/password-auth"}},
+}
+
+// pamPolicy is what the audit requires and --apply writes: CIS 5.4.1-5.4.3
+// (14 characters, four classes, lockout after 5 failures for 15 minutes,
+// last 5 passwords remembered).
+var pamPolicy = struct {
+	MinLen, MinClass, Retry, Deny, UnlockTime, Remember int
+}{MinLen: 14, MinClass: 4, Retry: 3, Deny: 5, UnlockTime: 900, Remember: 5}
+
+// pamStrongHashes are the pam_unix hash options accepted by the audit.
+var pamStrongHashes = map[string]bool{"sha512": true, "yescrypt": true, "gost_yescrypt": true}
+
+var pamUnixHashes = []string{"md5", "bigcrypt", "sha256", "sha512", "blowfish", "gost_yescrypt", "yescrypt"}
+
+// PAMLine is one module line of a PAM file. Optional is the "-type" form
+// that ignores a missing module.
+type PAMLine struct {
+	Type     string   `json:"type"`
+	Control  string   `json:"control"`
+	Module   string   `json:"module"`
+	Args     []string `json:"args,omitempty"`
+	Optional bool     `json:"optional,omitempty"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+}
+
+// name is the module without directory and .so suffix, e.g. pam_unix.
+func (l PAMLine) name() string {
+	return strings.TrimSuffix(path.Base(l.Module), ".so")
+}
+
+// arg returns the value of key=value, or "" and true for a bare flag.
+func (l PAMLine) arg(key string) (string, bool) {
+	for _, a := range l.Args {
+		if a == key {
+			return "", true
+		}
+		if k, v, ok := strings.Cut(a, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (l PAMLine) origin() string { return fmt.Sprintf("%s:%d", l.File, l.Line) }
+
+// PAMSetting is the effective value of a password or lockout setting and
+// where it comes from: a file:line, or "default".
+type PAMSetting struct {
+	Value  string `json:"value"`
+	Origin string `json:"origin"`
+}
+
+// PAMStack is one shared stack with its include-expanded lines and the
+// settings in effect for it.
+type PAMStack struct {
+	Name     string                `json:"name"`
+	Files    []string              `json:"files"`
+	Auth     []PAMLine             `json:"auth"`
+	Account  []PAMLine             `json:"account"`
+	Password []PAMLine             `json:"password"`
+	Settings map[string]PAMSetting `json:"settings"`
+}
+
+// find returns the first line of typ using module.
+func (s PAMStack) find(typ, module string) (PAMLine, bool) {
+	for _, l := range s.lines(typ) {
+		if l.name() == module {
+			return l, true
+		}
+	}
+	return PAMLine{}, false
+}
+
+func (s PAMStack) lines(typ string) []PAMLine {
+	switch typ {
+	case "auth":
+		return s.Auth
+	case "account":
+		return s.Account
+	default:
+		return s.Password
+	}
+}
+
+type PAMIssue struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Subject  string   `json:"subject"`
+	Detail   string   `json:"detail"`
+}
+
+type PAMAudit struct {
+	// Manager is the tool that owns the stacks (authselect or
+	// pam-auth-update), if any.
+	Manager string     `json:"manager,omitempty"`
+	Stacks  []PAMStack `json:"stacks"`
+	Issues  []PAMIssue `json:"issues"`
+	Notes   []string   `json:"notes,omitempty"`
+}
+
+type PAMAuditOptions struct {
+	// Root audits an image root or tarball instead of the host.
+	Root string
+
+	root *auditRoot
+}
+
+var pamLineRe = regexp.MustCompile(`^(-?)(\w+)\s+(\[[^\]]*\]|\S+)\s+(\S+)\s*(.*)$`)
+
+// parsePAMFile returns the lines of a PAM file as written. Debian's
+// "@include service" gets Type "@include" and the service in Module.
+func parsePAMFile(root *auditRoot, file string) ([]PAMLine, []string, error) {
+	b, err := root.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []PAMLine
+	var errs []string
+	for i, ln := range strings.Split(string(b), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(ln, "@include"); ok {
+			out = append(out, PAMLine{Type: "@include", Module: strings.TrimSpace(rest), File: file, Line: i + 1})
+			continue
+		}
+		m := pamLineRe.FindStringSubmatch(ln)
+		if m == nil {
+			errs = append(errs, fmt.Sprintf("%s:%d: cannot parse %q", file, i+1, ln))
+			continue
+		}
+		l := PAMLine{Optional: m[1] == "-", Type: strings.ToLower(m[2]), Control: m[3], Module: m[4], File: file, Line: i + 1}
+		if m[5] != "" {
+			l.Args = strings.Fields(m[5])
+		}
+		out = append(out, l)
+	}
+	return out, errs, nil
+}
+
+// pamStackLines returns the lines of typ in file with @include, include
+// and substack expanded.
+func pamStackLines(root *auditRoot, file, typ string, depth int, errs *[]string) []PAMLine {
+	lines, perrs, err := parsePAMFile(root, file)
+	if err != nil {
+		*errs = append(*errs, err.Error())
+		return nil
+	}
+	if depth == 0 {
+		*errs = append(*errs, perrs...)
+	}
+	var out []PAMLine
+	for _, l := range lines {
+		switch {
+		case l.Type == "@include":
+			if depth < 16 {
+				out = append(out, pamStackLines(root, pamIncludePath(l.Module), typ, depth+1, errs)...)
+			}
+		case l.Type != typ:
+		case l.Control == "include" || l.Control == "substack":
+			if depth < 16 {
+				out = append(out, pamStackLines(root, pamIncludePath(l.Module), typ, depth+1, errs)...)
+			}
+		default:
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func pamIncludePath(name string) string {
+	if path.IsAbs(name) {
+		return name
+	}
+	return path.Join(pamDir, name)
+}
+
+// pamModuleDirs are searched for modules given without a path.
+var pamModuleDirs = []string{"/lib/security", "/lib64/security", "/usr/lib/security", "/usr/lib64/security", "/lib/*/security", "/usr/lib/*/security"}
+
+// pamModuleInstalled reports whether root has module (pam_x or pam_x.so).
+func pamModuleInstalled(root *auditRoot, module string) bool {
+	if !strings.HasSuffix(module, ".so") {
+		module += ".so"
+	}
+	if path.IsAbs(module) {
+		return root.exists(module)
+	}
+	for _, dir := range pamModuleDirs {
+		if m, _ := root.Glob(path.Join(dir, module)); len(m) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// readPAMConf reads "key = value" settings of the pam_* config files;
+// a bare key is a flag. Later files override earlier ones.
+func readPAMConf(root *auditRoot, files []string, into map[string]PAMSetting) {
+	for _, f := range files {
+		b, err := root.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		for i, ln := range strings.Split(string(b), "\n") {
+			ln = strings.TrimSpace(ln)
+			if ln == "" || ln[0] == '#' {
+				continue
+			}
+			k, v := splitConfigLine(ln)
+			into[k] = PAMSetting{Value: v, Origin: fmt.Sprintf("%s:%d", f, i+1)}
+		}
+	}
+}
+
+func pwqualityConfFiles(root *auditRoot) []string {
+	files := []string{pwqualityConf}
+	matches, _ := root.Glob(pwqualityConfDir + "/*.conf")
+	sort.Strings(matches)
+	return append(files, matches...)
+}
+
+// applyPAMArgs overrides settings with the key=value arguments of l.
+func applyPAMArgs(l PAMLine, into map[string]PAMSetting) {
+	for _, a := range l.Args {
+		k, v, _ := strings.Cut(a, "=")
+		into[k] = PAMSetting{Value: v, Origin: l.origin()}
+	}
+}
+
+func pamDefaults(kv ...string) map[string]PAMSetting {
+	m := map[string]PAMSetting{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m[kv[i]] = PAMSetting{Value: kv[i+1], Origin: "default"}
+	}
+	return m
+}
+
+func settingInt(s map[string]PAMSetting, key string) int {
+	n, _ := strconv.Atoi(s[key].Value)
+	return n
+}
+
+// pamSettings resolves the effective settings of a stack. pam_pwquality
+// reads pwquality.conf, then pwquality.conf.d, then its arguments;
+// pam_faillock and pam_pwhistory read their .conf file, then arguments.
+func pamSettings(root *auditRoot, s PAMStack) map[string]PAMSetting {
+	out := map[string]PAMSetting{}
+
+	quality, hasQuality := s.find("password", "pam_pwquality")
+	if !hasQuality {
+		quality, hasQuality = s.find("password", "pam_cracklib")
+	}
+	unix, hasUnix := s.find("password", "pam_unix")
+	var q map[string]PAMSetting
+	switch {
+	case hasQuality && quality.name() == "pam_pwquality":
+		q = pamDefaults("minlen", "8", "minclass", "0", "dcredit", "0", "ucredit", "0", "lcredit", "0", "ocredit", "0", "retry", "1")
+		readPAMConf(root, pwqualityConfFiles(root), q)
+		applyPAMArgs(quality, q)
+	case hasQuality:
+		q = pamDefaults("minlen", "9", "minclass", "0", "dcredit", "1", "ucredit", "1", "lcredit", "1", "ocredit", "1", "retry", "1")
+		applyPAMArgs(quality, q)
+	default:
+		// Without a quality module only pam_unix's own length check applies.
+		q = pamDefaults("minlen", "6", "minclass", "0")
+		if v, ok := unix.arg("minlen"); ok && hasUnix {
+			q["minlen"] = PAMSetting{Value: v, Origin: unix.origin()}
+		}
+	}
+	out["quality_module"] = PAMSetting{Value: "none", Origin: "default"}
+	if hasQuality {
+		out["quality_module"] = PAMSetting{Value: quality.name(), Origin: quality.origin()}
+	}
+	for _, k := range []string{"minlen", "minclass", "dcredit", "ucredit", "lcredit", "ocredit", "retry"} {
+		if v, ok := q[k]; ok {
+			out[k] = v
+		}
+	}
+	// Classes is what a password must mix: minclass, or more when credits
+	// are negative (a required number of that class).
+	classes, origin := settingInt(q, "minclass"), q["minclass"].Origin
+	required := 0
+	for _, k := range []string{"dcredit", "ucredit", "lcredit", "ocredit"} {
+		if settingInt(q, k) < 0 {
+			required++
+		}
+	}
+	if required > classes {
+		classes, origin = required, "negative credits"
+	}
+	out["classes"] = PAMSetting{Value: strconv.Itoa(classes), Origin: origin}
+
+	out["lockout_module"] = PAMSetting{Value: "none", Origin: "default"}
+	if l, ok := s.find("auth", "pam_faillock"); ok {
+		f := pamDefaults("deny", "3", "unlock_time", "600", "fail_interval", "900")
+		readPAMConf(root, []string{faillockConf}, f)
+		for _, al := range s.Auth {
+			if al.name() == "pam_faillock" {
+				applyPAMArgs(al, f)
+			}
+		}
+		out["lockout_module"] = PAMSetting{Value: "pam_faillock", Origin: l.origin()}
+		out["deny"], out["unlock_time"], out["fail_interval"] = f["deny"], f["unlock_time"], f["fail_interval"]
+	} else if l, ok := s.find("auth", "pam_tally2"); ok {
+		f := pamDefaults("deny", "0", "unlock_time", "0")
+		applyPAMArgs(l, f)
+		out["lockout_module"] = PAMSetting{Value: "pam_tally2", Origin: l.origin()}
+		out["deny"], out["unlock_time"] = f["deny"], f["unlock_time"]
+	}
+
+	out["remember"] = PAMSetting{Value: "0", Origin: "default"}
+	if l, ok := s.find("password", "pam_pwhistory"); ok {
+		h := pamDefaults("remember", "10")
+		readPAMConf(root, []string{pwhistoryConf}, h)
+		applyPAMArgs(l, h)
+		out["remember"] = h["remember"]
+	} else if v, ok := unix.arg("remember"); ok && hasUnix {
+		out["remember"] = PAMSetting{Value: v, Origin: unix.origin()}
+	}
+
+	if hasUnix {
+		// Without a hash option pam_unix uses ENCRYPT_METHOD from login.defs.
+		out["hash"] = PAMSetting{Value: strings.ToLower(readLoginDefs(root).EncryptMethod), Origin: "/etc/login.defs"}
+		for _, h := range pamUnixHashes {
+			if _, ok := unix.arg(h); ok {
+				out["hash"] = PAMSetting{Value: h, Origin: unix.origin()}
+			}
+		}
+	}
+	return out
+}
+
+// AuditPAM reads the shared PAM stacks, pwquality, faillock and
+// pwhistory settings and reports where they fall short of the policy.
+func AuditPAM(ctx context.Context, opts PAMAuditOptions) (PAMAudit, error) {
+	_ = ctx
+	if runtime.GOOS != "linux" && opts.Root == "" {
+		return PAMAudit{}, errors.New("PAM audit is not supported on this OS")
+	}
+	if opts.root == nil && opts.Root != "" {
+		root, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return PAMAudit{}, err
+		}
+		opts.root = root
+	}
+	root := opts.root
+	audit := PAMAudit{Stacks: []PAMStack{}, Issues: []PAMIssue{}}
+	add := func(check string, sev Severity, subject, format string, args ...any) {
+		audit.Issues = append(audit.Issues, PAMIssue{Check: check, Severity: sev, Subject: subject, Detail: fmt.Sprintf(format, args...)})
+	}
+	switch {
+	case root.exists(authselectConf):
+		audit.Manager = "authselect"
+	case root.exists("/usr/sbin/pam-auth-update"):
+		audit.Manager = "pam-auth-update"
+	}
+
+	checkedModules := map[string]bool{}
+	for _, set := range pamStackSets {
+		if !root.exists(set.files["password"]) {
+			continue
+		}
+		var errs []string
+		st := PAMStack{Name: set.name}
+		seen := map[string]bool{}
+		for _, typ := range []string{"auth", "account", "password"} {
+			f := set.files[typ]
+			lines := pamStackLines(root, f, typ, 0, &errs)
+			switch typ {
+			case "auth":
+				st.Auth = lines
+			case "account":
+				st.Account = lines
+			default:
+				st.Password = lines
+			}
+			if !seen[f] && root.exists(f) {
+				seen[f] = true
+				st.Files = append(st.Files, f)
+			}
+		}
+		st.Settings = pamSettings(root, st)
+		audit.Stacks = append(audit.Stacks, st)
+		for _, e := range errs {
+			if i := strings.Index(e, ": "); i > 0 {
+				add(PAMIssueSyntax, SeverityMedium, e[:i], "%s", e[i+2:])
+			} else {
+				add(PAMIssueSyntax, SeverityMedium, st.Name, "%s", e)
+			}
+		}
+		for _, typ := range []string{"auth", "account", "password"} {
+			for _, l := range st.lines(typ) {
+				if l.Optional || checkedModules[l.Module] {
+					continue
+				}
+				checkedModules[l.Module] = true
+				if !pamModuleInstalled(root, l.Module) {
+					add(PAMIssueModule, SeverityHigh, l.origin(), "%s is not installed; the %s stack fails", l.Module, typ)
+				}
+			}
+		}
+		pamStackIssues(st, add)
+	}
+	if len(audit.Stacks) == 0 {
+		return audit, fmt.Errorf("no PAM password stack (%s/common-password, system-auth or password-auth)", pamDir)
+	}
+	if audit.Manager == "authselect" {
+		audit.Notes = append(audit.Notes, "the stacks are generated by authselect; --apply enables authselect features instead of editing them")
+	}
+	return audit, nil
+}
+
+// pamStackIssues compares a stack's settings with pamPolicy.
+func pamStackIssues(st PAMStack, add func(check string, sev Severity, subject, format string, args ...any)) {
+	s := st.Settings
+	where := func(k string) string { return st.Name + " " + k }
+	if s["quality_module"].Value == "none" {
+		add(PAMIssueQuality, SeverityMedium, st.Name, "no pam_pwquality in the password stack; only pam_unix's length check applies")
+	}
+	if n := settingInt(s, "minlen"); n < pamPolicy.MinLen {
+		add(PAMIssueMinLen, SeverityHigh, where("minlen"), "minimum length %d (want %d or more) [%s]", n, pamPolicy.MinLen, s["minlen"].Origin)
+	}
+	if n := settingInt(s, "classes"); n < pamPolicy.MinClass {
+		add(PAMIssueComplexity, SeverityMedium, where("minclass"), "passwords need %d character classes (want %d, or negative d/u/l/ocredit) [%s]", n, pamPolicy.MinClass, s["classes"].Origin)
+	}
+
+	lockout := s["lockout_module"].Value
+	switch lockout {
+	case "none":
+		add(PAMIssueLockout, SeverityHigh, st.Name, "no pam_faillock in the auth stack; failed logins are never locked out")
+	case "pam_faillock":
+		var preauth, authfail bool
+		for _, l := range st.Auth {
+			if l.name() != "pam_faillock" {
+				continue
+			}
+			if _, ok := l.arg("preauth"); ok {
+				preauth = true
+			}
+			if _, ok := l.arg("authfail"); ok {
+				authfail = true
+			}
+		}
+		_, account := st.find("account", "pam_faillock")
+		var missing []string
+		if !preauth {
+			missing = append(missing, "auth preauth")
+		}
+		if !authfail {
+			missing = append(missing, "auth authfail")
+		}
+		if !account {
+			missing = append(missing, "account")
+		}
+		if len(missing) > 0 {
+			add(PAMIssueLockout, SeverityHigh, st.Name, "pam_faillock lacks the %s line(s); failures are not counted or enforced", strings.Join(missing, " and "))
+		}
+	}
+	if lockout != "none" {
+		if n := settingInt(s, "deny"); n == 0 || n > pamPolicy.Deny {
+			add(PAMIssueDeny, SeverityMedium, where("deny"), "deny=%d (want 1-%d) [%s]", n, pamPolicy.Deny, s["deny"].Origin)
+		}
+		// 0 keeps accounts locked until an administrator unlocks them.
+		if n := settingInt(s, "unlock_time"); n != 0 && n < pamPolicy.UnlockTime {
+			add(PAMIssueUnlockTime, SeverityLow, where("unlock_time"), "unlock_time=%d (want 0 or %d or more) [%s]", n, pamPolicy.UnlockTime, s["unlock_time"].Origin)
+		}
+	}
+	if n := settingInt(s, "remember"); n < pamPolicy.Remember {
+		add(PAMIssueHistory, SeverityMedium, where("remember"), "last %d passwords remembered (want %d or more) [%s]", n, pamPolicy.Remember, s["remember"].Origin)
+	}
+	if h, ok := s["hash"]; ok && !pamStrongHashes[h.Value] {
+		add(PAMIssueHash, SeverityHigh, where("hash"), "pam_unix hashes new passwords with %s (want sha512 or yescrypt) [%s]", orNone(h.Value), h.Origin)
+	}
+	if l, ok := st.find("auth", "pam_unix"); ok {
+		if _, nullok := l.arg("nullok"); nullok {
+			add(PAMIssueNullok, SeverityLow, l.origin(), "pam_unix nullok accepts empty passwords")
+		}
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// evalPAMSetting judges one effective setting in every stack; all must
+// pass. It backs the pam_setting check type.
+func evalPAMSetting(ctx context.Context, root *auditRoot, key, op, want string) (Result, string, error) {
+	audit, err := AuditPAM(ctx, PAMAuditOptions{root: root})
+	if err != nil {
+		if len(audit.Stacks) == 0 {
+			return ResultSkip, err.Error(), nil
+		}
+		return ResultWarn, "", err
+	}
+	res := ResultPass
+	var details []string
+	for _, st := range audit.Stacks {
+		s, ok := st.Settings[key]
+		if !ok {
+			res = ResultFail
+			details = append(details, st.Name+": "+key+" is not set")
+			continue
+		}
+		r, d, err := judgeValue(key, s.Value, op, want)
+		if err != nil {
+			return ResultWarn, "", err
+		}
+		if r != ResultPass {
+			res = r
+		}
+		details = append(details, fmt.Sprintf("%s: %s [%s]", st.Name, d, s.Origin))
+	}
+	return res, strings.Join(details, "; "), nil
+}
+
+// pamFile is a PAM file being edited. Only changed lines are rewritten;
+// comments and layout of the rest are kept.
+type pamFile struct {
+	path  string
+	raw   []string
+	lines map[int]PAMLine // raw index -> parsed module line
+	dirty bool
+}
+
+func loadPAMFile(p string) (*pamFile, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	f := &pamFile{path: p, raw: strings.Split(strings.TrimRight(string(b), "\n"), "\n")}
+	f.reparse()
+	return f, nil
+}
+
+func (f *pamFile) reparse() {
+	f.lines = map[int]PAMLine{}
+	for i, ln := range f.raw {
+		t := strings.TrimSpace(ln)
+		if t == "" || t[0] == '#' || strings.HasPrefix(t, "@include") {
+			continue
+		}
+		if m := pamLineRe.FindStringSubmatch(t); m != nil {
+			l := PAMLine{Optional: m[1] == "-", Type: strings.ToLower(m[2]), Control: m[3], Module: m[4], File: f.path, Line: i + 1}
+			if m[5] != "" {
+				l.Args = strings.Fields(m[5])
+			}
+			f.lines[i] = l
+		}
+	}
+}
+
+// index returns the raw index of the first typ line using module, or -1.
+func (f *pamFile) index(typ, module string) int {
+	for i := range f.raw {
+		if l, ok := f.lines[i]; ok && l.Type == typ && l.name() == module {
+			return i
+		}
+	}
+	return -1
+}
+
+// first returns the raw index of the first typ line, or -1.
+func (f *pamFile) first(typ string) int {
+	for i := range f.raw {
+		if l, ok := f.lines[i]; ok && l.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+var pamJumpRe = regexp.MustCompile(`(\w+)=(\d+)`)
+
+// insert adds a module line at raw index at. Earlier lines of the same
+// type that jump ("success=N") over or onto that position get N+1, so
+// every jump still lands on the module it landed on before.
+func (f *pamFile) insert(at int, typ, control, module string, args ...string) {
+	for i := 0; i < at && i < len(f.raw); i++ {
+		l, ok := f.lines[i]
+		if !ok || l.Type != typ || !strings.HasPrefix(l.Control, "[") {
+			continue
+		}
+		control := pamJumpRe.ReplaceAllStringFunc(l.Control, func(kv string) string {
+			m := pamJumpRe.FindStringSubmatch(kv)
+			n, _ := strconv.Atoi(m[2])
+			// The module a jump of n lands on is the (n+1)th same-type
+			// line after i.
+			count, landing := 0, len(f.raw)
+			for j := i + 1; j < len(f.raw); j++ {
+				if lj, ok := f.lines[j]; ok && lj.Type == typ {
+					count++
+					if count == n+1 {
+						landing = j
+						break
+					}
+				}
+			}
+			if at <= landing {
+				n++
+			}
+			return fmt.Sprintf("%s=%d", m[1], n)
+		})
+		if control != l.Control {
+			l.Control = control
+			f.raw[i] = formatPAMLine(l)
+		}
+	}
+	l := PAMLine{Type: typ, Control: control, Module: module, Args: args}
+	f.raw = append(f.raw[:at], append([]string{formatPAMLine(l)}, f.raw[at:]...)...)
+	f.dirty = true
+	f.reparse()
+}
+
+// setArgs sets key=value (or a bare flag when value is "") on the line at
+// raw index i, replacing an existing value of the key.
+func (f *pamFile) setArgs(i int, kv ...string) {
+	l := f.lines[i]
+	for k := 0; k+1 < len(kv); k += 2 {
+		key, value := kv[k], kv[k+1]
+		a := key
+		if value != "" {
+			a = key + "=" + value
+		}
+		replaced := false
+		for j, old := range l.Args {
+			if old == key || strings.HasPrefix(old, key+"=") {
+				l.Args[j], replaced = a, true
+			}
+		}
+		if !replaced {
+			l.Args = append(l.Args, a)
+		}
+	}
+	if s := formatPAMLine(l); s != f.raw[i] {
+		f.raw[i] = s
+		f.dirty = true
+		f.reparse()
+	}
+}
+
+// removeArgs drops the given flags or key= arguments from the line at i.
+func (f *pamFile) removeArgs(i int, keys ...string) {
+	l := f.lines[i]
+	var args []string
+	for _, a := range l.Args {
+		k, _, _ := strings.Cut(a, "=")
+		drop := false
+		for _, key := range keys {
+			drop = drop || k == key
+		}
+		if !drop {
+			args = append(args, a)
+		}
+	}
+	if len(args) != len(l.Args) {
+		l.Args = args
+		f.raw[i] = formatPAMLine(l)
+		f.dirty = true
+		f.reparse()
+	}
+}
+
+func formatPAMLine(l PAMLine) string {
+	typ := l.Type
+	if l.Optional {
+		typ = "-" + typ
+	}
+	s := typ + "\t" + l.Control + "\t" + l.Module
+	if len(l.Args) > 0 {
+		s += " " + strings.Join(l.Args, " ")
+	}
+	return s
+}
+
+func (f *pamFile) bytes() []byte { return []byte(strings.Join(f.raw, "\n") + "\n") }
+
+// setPAMConf sets "key = value" lines in a pam_* config file, replacing
+// active or commented-out lines of the key in place.
+func setPAMConf(content string, kv ...string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+	for k := 0; k+1 < len(kv); k += 2 {
+		key, line := kv[k], kv[k]+" = "+kv[k+1]
+		set, commented := false, -1
+		for i, ln := range lines {
+			t := strings.TrimSpace(ln)
+			if ck, _ := splitConfigLine(strings.TrimSpace(strings.TrimPrefix(t, "#"))); ck != key {
+				continue
+			}
+			if strings.HasPrefix(t, "#") {
+				if commented < 0 {
+					commented = i
+				}
+				continue
+			}
+			lines[i], set = line, true
+		}
+		switch {
+		case set:
+		case commented >= 0:
+			lines = append(lines[:commented+1], append([]string{line}, lines[commented+1:]...)...)
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// pamEdit is one step of a PAM policy change: write content to path, or
+// run a command that changes the snapshot paths.
+type pamEdit struct {
+	desc    string
+	path    string
+	content []byte
+	mode    os.FileMode
+	paths   []string
+	run     func(ctx context.Context) error
+}
+
+// pamPlan is the set of edits that bring the host's PAM stacks to
+// pamPolicy, the issue kinds they are expected to fix and the module and
+// syntax problems that were there before.
+type pamPlan struct {
+	edits  []pamEdit
+	fixes  map[string]bool
+	broken map[string]bool
+	notes  []string
+}
+
+func (p *pamPlan) changes() []string {
+	out := make([]string, 0, len(p.edits))
+	for _, e := range p.edits {
+		out = append(out, e.desc)
+	}
+	return out
+}
+
+// planPAMPolicy works out the edits for the host. Modules that are not
+// installed are not added; a note says which package provides them.
+func planPAMPolicy(ctx context.Context) (*pamPlan, error) {
+	audit, err := AuditPAM(ctx, PAMAuditOptions{})
+	if err != nil {
+		return nil, err
+	}
+	plan := &pamPlan{fixes: map[string]bool{}, broken: map[string]bool{}}
+	for _, is := range audit.Issues {
+		if is.Check == PAMIssueModule || is.Check == PAMIssueSyntax {
+			plan.broken[is.Detail] = true
+		}
+	}
+	pwquality := pamModuleInstalled(hostRoot, "pam_pwquality")
+	faillock := pamModuleInstalled(hostRoot, "pam_faillock")
+	pwhistory := pamModuleInstalled(hostRoot, "pam_pwhistory")
+	if !pwquality {
+		plan.notes = append(plan.notes, "pam_pwquality is not installed (libpam-pwquality or libpwquality): only the minimum length is enforced, through pam_unix")
+	}
+	if !faillock {
+		plan.notes = append(plan.notes, "pam_faillock is not installed: lockout cannot be configured")
+	}
+
+	confEdit := func(p string, create bool, kv ...string) {
+		b, err := os.ReadFile(p)
+		if err != nil && (!create || !errors.Is(err, os.ErrNotExist)) {
+			return
+		}
+		mode := os.FileMode(0o644)
+		if fi, err := os.Stat(p); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		if out := setPAMConf(string(b), kv...); out != string(b) {
+			var set []string
+			for k := 0; k+1 < len(kv); k += 2 {
+				set = append(set, kv[k]+"="+kv[k+1])
+			}
+			plan.edits = append(plan.edits, pamEdit{desc: fmt.Sprintf("Set %s in %s", strings.Join(set, ", "), p), path: p, content: []byte(out), mode: mode})
+		}
+	}
+	if pwquality {
+		kv := []string{"minlen", strconv.Itoa(pamPolicy.MinLen), "minclass", strconv.Itoa(pamPolicy.MinClass), "retry", strconv.Itoa(pamPolicy.Retry)}
+		confEdit(pwqualityConf, true, kv...)
+		// A drop-in that sets a key overrides pwquality.conf.
+		for _, f := range pwqualityConfFiles(hostRoot)[1:] {
+			over := map[string]PAMSetting{}
+			readPAMConf(hostRoot, []string{f}, over)
+			var set []string
+			for k := 0; k+1 < len(kv); k += 2 {
+				if _, ok := over[kv[k]]; ok {
+					set = append(set, kv[k], kv[k+1])
+				}
+			}
+			if len(set) > 0 {
+				confEdit(f, false, set...)
+			}
+		}
+	}
+	if faillock {
+		confEdit(faillockConf, true, "deny", strconv.Itoa(pamPolicy.Deny), "unlock_time", strconv.Itoa(pamPolicy.UnlockTime))
+		plan.fixes[PAMIssueLockout] = true
+		plan.fixes[PAMIssueDeny] = true
+		plan.fixes[PAMIssueUnlockTime] = true
+	}
+	if pwhistory {
+		confEdit(pwhistoryConf, false, "remember", strconv.Itoa(pamPolicy.Remember))
+		plan.fixes[PAMIssueHistory] = true
+	}
+
+	if audit.Manager == "authselect" {
+		// authselect regenerates system-auth and password-auth; enable
+		// its features rather than editing the generated files.
+		var features []string
+		for _, st := range audit.Stacks {
+			if faillock && st.Settings["lockout_module"].Value == "none" {
+				features = append(features, "with-faillock")
+			}
+			if pwhistory && settingInt(st.Settings, "remember") < pamPolicy.Remember {
+				if _, ok := st.find("password", "pam_pwhistory"); !ok {
+					features = append(features, "with-pwhistory")
+				}
+			}
+		}
+		for _, feat := range uniqueStrings(features) {
+			feat := feat
+			plan.edits = append(plan.edits, pamEdit{
+				desc:  "Run authselect enable-feature " + feat,
+				paths: []string{authselectConf, "/etc/authselect/system-auth", "/etc/authselect/password-auth"},
+				run: func(ctx context.Context) error {
+					return runCmd(ctx, nil, "authselect", "enable-feature", feat)
+				},
+			})
+		}
+		// The quality settings only apply if the profile uses pwquality.
+		quality := pwquality
+		for _, st := range audit.Stacks {
+			quality = quality && st.Settings["quality_module"].Value == "pam_pwquality"
+		}
+		plan.fixes[PAMIssueMinLen] = quality
+		plan.fixes[PAMIssueComplexity] = quality
+		return plan, nil
+	}
+
+	// Editing the stacks directly fixes everything the installed modules
+	// allow.
+	plan.fixes[PAMIssueMinLen] = true
+	plan.fixes[PAMIssueHistory] = true
+	plan.fixes[PAMIssueHash] = true
+	if pwquality {
+		plan.fixes[PAMIssueQuality] = true
+		plan.fixes[PAMIssueComplexity] = true
+	}
+
+	files := map[string]*pamFile{}
+	load := func(p string) *pamFile {
+		if f, ok := files[p]; ok {
+			return f
+		}
+		f, err := loadPAMFile(p)
+		if err != nil {
+			return nil
+		}
+		files[p] = f
+		return f
+	}
+	var order []string
+	for _, set := range pamStackSets {
+		pw, auth, account := load(set.files["password"]), load(set.files["auth"]), load(set.files["account"])
+		if pw == nil {
+			continue
+		}
+		for _, f := range []*pamFile{pw, auth, account} {
+			if f != nil && !containsString(order, f.path) {
+				order = append(order, f.path)
+			}
+		}
+		unix := pw.index("password", "pam_unix")
+		if unix < 0 {
+			plan.notes = append(plan.notes, pw.path+" has no pam_unix password line; left unchanged")
+			continue
+		}
+
+		// Password quality: pam_pwquality before pam_unix, or pam_unix's
+		// own minlen when it is not installed. Arguments override the
+		// config file, so weaker ones are raised.
+		q := pw.index("password", "pam_pwquality")
+		if q < 0 {
+			q = pw.index("password", "pam_cracklib")
+		}
+		switch {
+		case q >= 0:
+			l := pw.lines[q]
+			for _, k := range []string{"minlen", "minclass"} {
+				want := map[string]int{"minlen": pamPolicy.MinLen, "minclass": pamPolicy.MinClass}[k]
+				if v, ok := l.arg(k); ok {
+					if n, _ := strconv.Atoi(v); n < want {
+						pw.setArgs(q, k, strconv.Itoa(want))
+					}
+				}
+			}
+			if l.name() == "pam_cracklib" {
+				pw.setArgs(q, "minlen", strconv.Itoa(pamPolicy.MinLen), "minclass", strconv.Itoa(pamPolicy.MinClass))
+			}
+		case pwquality:
+			// pam_unix must take the password pam_pwquality read instead
+			// of prompting again.
+			pw.insert(unix, "password", "requisite", "pam_pwquality.so", "retry="+strconv.Itoa(pamPolicy.Retry))
+			pw.setArgs(pw.index("password", "pam_unix"), "try_first_pass", "", "use_authtok", "")
+		default:
+			pw.setArgs(pw.index("password", "pam_unix"), "minlen", strconv.Itoa(pamPolicy.MinLen))
+		}
+
+		// Password history, before pam_unix so a reused password is
+		// rejected before it is written.
+		// pam_pwhistory goes after the quality module and reuses the
+		// password it read (use_authtok). Without one in front of it,
+		// pam_unix keeps the history itself in /etc/security/opasswd.
+		quality := pw.index("password", "pam_pwquality") >= 0 || pw.index("password", "pam_cracklib") >= 0
+		if h := pw.index("password", "pam_pwhistory"); h >= 0 {
+			if v, ok := pw.lines[h].arg("remember"); ok {
+				if n, _ := strconv.Atoi(v); n < pamPolicy.Remember {
+					pw.setArgs(h, "remember", strconv.Itoa(pamPolicy.Remember))
+				}
+			}
+		} else if v, ok := pw.lines[pw.index("password", "pam_unix")].arg("remember"); !pwhistory || !quality || ok {
+			if n, _ := strconv.Atoi(v); n < pamPolicy.Remember {
+				pw.setArgs(pw.index("password", "pam_unix"), "remember", strconv.Itoa(pamPolicy.Remember))
+			}
+		} else {
+			pw.insert(pw.index("password", "pam_unix"), "password", "requisite", "pam_pwhistory.so", "remember="+strconv.Itoa(pamPolicy.Remember), "use_authtok")
+		}
+
+		// Hashing: replace a weak pam_unix hash option, or add sha512 when
+		// pam_unix falls back to a weak ENCRYPT_METHOD.
+		unix = pw.index("password", "pam_unix")
+		hashed := false
+		for _, h := range pamUnixHashes {
+			if _, ok := pw.lines[unix].arg(h); ok {
+				hashed = true
+				if !pamStrongHashes[h] {
+					pw.removeArgs(unix, h)
+					pw.setArgs(unix, "sha512", "")
+				}
+			}
+		}
+		if !hashed && !pamStrongHashes[strings.ToLower(readLoginDefs(hostRoot).EncryptMethod)] {
+			pw.setArgs(unix, "sha512", "")
+		}
+
+		// Lockout: preauth before pam_unix, authfail right after it and
+		// the account check first in the account stack.
+		if faillock && auth != nil {
+			au := auth.index("auth", "pam_unix")
+			if au >= 0 && auth.index("auth", "pam_tally2") < 0 {
+				var preauth, authfail bool
+				for i, l := range auth.lines {
+					if l.Type != "auth" || l.name() != "pam_faillock" {
+						continue
+					}
+					if _, ok := l.arg("preauth"); ok {
+						preauth = true
+					}
+					if _, ok := l.arg("authfail"); ok {
+						authfail = true
+					}
+					for _, k := range []string{"deny", "unlock_time"} {
+						if v, ok := l.arg(k); ok {
+							n, _ := strconv.Atoi(v)
+							if k == "deny" && (n == 0 || n > pamPolicy.Deny) {
+								auth.setArgs(i, k, strconv.Itoa(pamPolicy.Deny))
+							}
+							if k == "unlock_time" && n != 0 && n < pamPolicy.UnlockTime {
+								auth.setArgs(i, k, strconv.Itoa(pamPolicy.UnlockTime))
+							}
+						}
+					}
+				}
+				if !preauth {
+					auth.insert(au, "auth", "required", "pam_faillock.so", "preauth")
+				}
+				if !authfail {
+					auth.insert(auth.index("auth", "pam_unix")+1, "auth", "[default=die]", "pam_faillock.so", "authfail")
+				}
+			}
+		}
+		if faillock && account != nil && account.index("account", "pam_faillock") < 0 && account.first("account") >= 0 {
+			account.insert(account.first("account"), "account", "required", "pam_faillock.so")
+		}
+	}
+	for _, p := range order {
+		f := files[p]
+		if !f.dirty {
+			continue
+		}
+		mode := os.FileMode(0o644)
+		if fi, err := os.Stat(p); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		plan.edits = append(plan.edits, pamEdit{desc: "Update PAM stack " + p, path: p, content: f.bytes(), mode: mode})
+	}
+	if audit.Manager == "pam-auth-update" && len(order) > 0 {
+		plan.notes = append(plan.notes, "pam-auth-update keeps the edited common-* files as local changes; do not run it with --force")
+	}
+	return plan, nil
+}
+
+func uniqueStrings(in []string) []string {
+	var out []string
+	for _, s := range in {
+		if !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// applyPAMPlan snapshots and writes each edit, then re-reads the stacks:
+// a newly missing module or unparsable line, a stack without pam_unix or
+// a setting the plan should have fixed still failing the policy is an
+// error, and the transaction reverts every file.
+func applyPAMPlan(ctx context.Context, tx *Transaction, plan *pamPlan) error {
+	for _, e := range plan.edits {
+		e := e
+		paths := e.paths
+		if e.path != "" {
+			paths = append(paths, e.path)
+		}
+		for _, p := range paths {
+			if err := tx.SnapshotFile(p); err != nil {
+				return err
+			}
+		}
+		if err := tx.Do(e.desc, func() error {
+			if e.run != nil {
+				return e.run(ctx)
+			}
+			if err := os.MkdirAll(path.Dir(e.path), 0o755); err != nil {
+				return err
+			}
+			return writeFileMode(e.path, e.content, e.mode)
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Do("Validate PAM stacks", func() error { return validatePAMPolicy(ctx, plan) })
+}
+
+func writeFileMode(p string, data []byte, mode os.FileMode) error {
+	if err := os.WriteFile(p, data, mode); err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
+
+func validatePAMPolicy(ctx context.Context, plan *pamPlan) error {
+	audit, err := AuditPAM(ctx, PAMAuditOptions{})
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, st := range audit.Stacks {
+		if _, ok := st.find("auth", "pam_unix"); !ok && audit.Manager != "authselect" {
+			problems = append(problems, st.Name+": no pam_unix in the auth stack")
+		}
+		if _, ok := st.find("password", "pam_unix"); !ok {
+			problems = append(problems, st.Name+": no pam_unix in the password stack")
+		}
+	}
+	for _, is := range audit.Issues {
+		if (is.Check == PAMIssueModule || is.Check == PAMIssueSyntax) && !plan.broken[is.Detail] || plan.fixes[is.Check] {
+			problems = append(problems, is.Subject+": "+is.Detail)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("PAM validation failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+type PAMPolicyOptions struct {
+	Yes         bool
+	DryRun      bool
+	RollbackDir string
+}
+
+type PAMPolicyResult struct {
+	Changes       []string `json:"changes"`
+	Notes         []string `json:"notes,omitempty"`
+	TransactionID string   `json:"transaction_id,omitempty"`
+}
+
+// ApplyPAMPolicy edits the PAM stacks, pwquality.conf, faillock.conf and
+// pwhistory.conf to meet the password and lockout policy in one
+// transaction, validated before it is kept.
+func ApplyPAMPolicy(ctx context.Context, opts PAMPolicyOptions) (PAMPolicyResult, error) {
+	var res PAMPolicyResult
+	if runtime.GOOS != "linux" {
+		return res, errors.New("PAM policy is not supported on this OS")
+	}
+	plan, err := planPAMPolicy(ctx)
+	if err != nil {
+		return res, err
+	}
+	res.Changes, res.Notes = plan.changes(), plan.notes
+	if len(plan.edits) == 0 || opts.DryRun {
+		return res, nil
+	}
+	if !opts.Yes {
+		return res, errors.New("changing PAM requires --yes (or use --dry-run)")
+	}
+	tx, err := BeginTransaction(opts.RollbackDir, "pam policy")
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	return res, tx.Finish(ctx, applyPAMPlan(ctx, tx, plan))
+}
+
+// pamIssues loads the PAM audit for the pam.* checks.
+func pamIssues(ctx context.Context, opts AuditOptions) ([]PAMIssue, string, error) {
+	audit, err := AuditPAM(ctx, PAMAuditOptions{root: opts.root})
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	return audit.Issues, "", nil
+}
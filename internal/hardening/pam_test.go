@@ -0,0 +1,310 @@
+package hardening
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPAMStackLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		file     string
+		typ      string
+		want     []string
+		wantErrs int
+	}{
+		{
+			name: "@include expands in place",
+			files: map[string]string{
+				"/etc/pam.d/login":       "auth required pam_securetty.so\n@include common-auth\nauth optional pam_group.so\n",
+				"/etc/pam.d/common-auth": "auth required pam_faillock.so preauth\nauth [success=1 default=ignore] pam_unix.so\n",
+			},
+			file: "/etc/pam.d/login",
+			typ:  "auth",
+			want: []string{"pam_securetty", "pam_faillock", "pam_unix", "pam_group"},
+		},
+		{
+			name: "include and substack only expand lines of the type",
+			files: map[string]string{
+				"/etc/pam.d/sshd":          "auth substack password-auth\naccount include password-auth\nauth required pam_env.so\n",
+				"/etc/pam.d/password-auth": "auth required pam_unix.so\naccount required pam_faillock.so\naccount required pam_unix.so\n",
+			},
+			file: "/etc/pam.d/sshd",
+			typ:  "account",
+			want: []string{"pam_faillock", "pam_unix"},
+		},
+		{
+			name: "substack keeps its position in the stack",
+			files: map[string]string{
+				"/etc/pam.d/sshd":          "auth required pam_env.so\nauth substack password-auth\nauth optional pam_motd.so\n",
+				"/etc/pam.d/password-auth": "auth required pam_faillock.so preauth\nauth sufficient pam_unix.so\n",
+			},
+			file: "/etc/pam.d/sshd",
+			typ:  "auth",
+			want: []string{"pam_env", "pam_faillock", "pam_unix", "pam_motd"},
+		},
+		{
+			name: "absolute include path and optional module",
+			files: map[string]string{
+				"/etc/pam.d/su":   "password include /etc/pam.d/base\n",
+				"/etc/pam.d/base": "-password optional pam_gnome_keyring.so\npassword required pam_unix.so sha512\n",
+			},
+			file: "/etc/pam.d/su",
+			typ:  "password",
+			want: []string{"-pam_gnome_keyring", "pam_unix"},
+		},
+		{
+			name: "missing include and unparsable line are reported",
+			files: map[string]string{
+				"/etc/pam.d/a": "auth include missing\nauth\nauth required pam_unix.so\n",
+			},
+			file:     "/etc/pam.d/a",
+			typ:      "auth",
+			want:     []string{"pam_unix"},
+			wantErrs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs, got []string
+			for _, l := range pamStackLines(mapRoot(tt.files), tt.file, tt.typ, 0, &errs) {
+				n := l.name()
+				if l.Optional {
+					n = "-" + n
+				}
+				got = append(got, n)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %v, want %v", got, tt.want)
+			}
+			if len(errs) != tt.wantErrs {
+				t.Errorf("errors = %v, want %d", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestPAMStackLinesIncludeLoop(t *testing.T) {
+	root := mapRoot(map[string]string{
+		"/etc/pam.d/a": "auth required pam_env.so\nauth include b\n",
+		"/etc/pam.d/b": "auth include a\n",
+	})
+	var errs []string
+	lines := pamStackLines(root, "/etc/pam.d/a", "auth", 0, &errs)
+	if len(lines) == 0 || len(lines) > 16 {
+		t.Errorf("%d lines from an include loop, want a bounded expansion", len(lines))
+	}
+}
+
+func TestPAMFileInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		at      func(f *pamFile) int
+		typ     string
+		control string
+		module  string
+		args    []string
+		want    string
+	}{
+		{
+			name: "jump over the insertion point grows",
+			file: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\trequired\tpam_permit.so\n",
+			at:      func(f *pamFile) int { return f.index("auth", "pam_deny") },
+			typ:     "auth",
+			control: "[default=die]",
+			module:  "pam_faillock.so",
+			args:    []string{"authfail"},
+			want: "auth\t[success=2 default=ignore]\tpam_unix.so\n" +
+				"auth\t[default=die]\tpam_faillock.so authfail\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\trequired\tpam_permit.so\n",
+		},
+		{
+			name: "jump landing before the insertion point is kept",
+			file: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\trequired\tpam_permit.so\n",
+			at:      func(f *pamFile) int { return len(f.raw) },
+			typ:     "auth",
+			control: "required",
+			module:  "pam_faillock.so",
+			args:    []string{"authsucc"},
+			want: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\trequired\tpam_permit.so\n" +
+				"auth\trequired\tpam_faillock.so authsucc\n",
+		},
+		{
+			name: "insert onto the landing module grows the jump",
+			file: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\trequired\tpam_permit.so\n",
+			at:      func(f *pamFile) int { return f.index("auth", "pam_permit") },
+			typ:     "auth",
+			control: "optional",
+			module:  "pam_cap.so",
+			want: "auth\t[success=2 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"auth\toptional\tpam_cap.so\n" +
+				"auth\trequired\tpam_permit.so\n",
+		},
+		{
+			name: "lines of other types and comments are not counted",
+			file: "password\t[success=2 default=ignore]\tpam_unix.so obscure\n" +
+				"# sss fallback\n" +
+				"auth\trequired\tpam_env.so\n" +
+				"password\tsufficient\tpam_sss.so\n" +
+				"password\trequisite\tpam_deny.so\n" +
+				"password\trequired\tpam_permit.so\n",
+			at:      func(f *pamFile) int { return f.first("password") },
+			typ:     "password",
+			control: "requisite",
+			module:  "pam_pwquality.so",
+			args:    []string{"retry=3"},
+			want: "password\trequisite\tpam_pwquality.so retry=3\n" +
+				"password\t[success=2 default=ignore]\tpam_unix.so obscure\n" +
+				"# sss fallback\n" +
+				"auth\trequired\tpam_env.so\n" +
+				"password\tsufficient\tpam_sss.so\n" +
+				"password\trequisite\tpam_deny.so\n" +
+				"password\trequired\tpam_permit.so\n",
+		},
+		{
+			name: "jump of another type is left alone",
+			file: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"password\trequired\tpam_unix.so\n",
+			at:      func(f *pamFile) int { return f.first("password") },
+			typ:     "password",
+			control: "required",
+			module:  "pam_pwhistory.so",
+			args:    []string{"remember=5"},
+			want: "auth\t[success=1 default=ignore]\tpam_unix.so\n" +
+				"auth\trequisite\tpam_deny.so\n" +
+				"password\trequired\tpam_pwhistory.so remember=5\n" +
+				"password\trequired\tpam_unix.so\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &pamFile{path: "/etc/pam.d/test", raw: strings.Split(strings.TrimRight(tt.file, "\n"), "\n")}
+			f.reparse()
+			f.insert(tt.at(f), tt.typ, tt.control, tt.module, tt.args...)
+			if got := string(f.bytes()); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if !f.dirty {
+				t.Error("insert did not mark the file dirty")
+			}
+		})
+	}
+}
+
+func TestPAMSettingsPrecedence(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]PAMSetting
+	}{
+		{
+			name: "pwquality defaults",
+			files: map[string]string{
+				"/etc/pam.d/common-password": "password requisite pam_pwquality.so\npassword required pam_unix.so\n",
+			},
+			want: map[string]PAMSetting{
+				"quality_module": {"pam_pwquality", "/etc/pam.d/common-password:1"},
+				"minlen":         {"8", "default"},
+				"remember":       {"0", "default"},
+				"lockout_module": {"none", "default"},
+			},
+		},
+		{
+			name: "conf.d overrides pwquality.conf and arguments override both",
+			files: map[string]string{
+				"/etc/pam.d/common-password":            "password requisite pam_pwquality.so minclass=3\npassword required pam_unix.so\n",
+				"/etc/security/pwquality.conf":          "minlen = 10\nminclass = 2\n",
+				"/etc/security/pwquality.conf.d/a.conf": "minlen = 12\n",
+				"/etc/security/pwquality.conf.d/b.conf": "# minlen = 8\nminlen = 14\n",
+			},
+			want: map[string]PAMSetting{
+				"minlen":   {"14", "/etc/security/pwquality.conf.d/b.conf:2"},
+				"minclass": {"3", "/etc/pam.d/common-password:1"},
+				"classes":  {"3", "/etc/pam.d/common-password:1"},
+			},
+		},
+		{
+			name: "negative credits raise the class count",
+			files: map[string]string{
+				"/etc/pam.d/common-password": "password requisite pam_pwquality.so dcredit=-1 ucredit=-1 ocredit=-1 minclass=2\npassword required pam_unix.so\n",
+			},
+			want: map[string]PAMSetting{
+				"classes": {"3", "negative credits"},
+			},
+		},
+		{
+			name: "unix minlen only applies without a quality module",
+			files: map[string]string{
+				"/etc/pam.d/common-password": "password required pam_unix.so minlen=12 remember=5 sha512\n",
+			},
+			want: map[string]PAMSetting{
+				"quality_module": {"none", "default"},
+				"minlen":         {"12", "/etc/pam.d/common-password:1"},
+				"remember":       {"5", "/etc/pam.d/common-password:1"},
+			},
+		},
+		{
+			name: "faillock arguments override faillock.conf on any auth line",
+			files: map[string]string{
+				"/etc/pam.d/common-auth": "auth required pam_faillock.so preauth\n" +
+					"auth [success=1 default=ignore] pam_unix.so\n" +
+					"auth [default=die] pam_faillock.so authfail deny=4\n",
+				"/etc/pam.d/common-password":  "password required pam_unix.so\n",
+				"/etc/security/faillock.conf": "deny = 5\nunlock_time = 900\n",
+			},
+			want: map[string]PAMSetting{
+				"lockout_module": {"pam_faillock", "/etc/pam.d/common-auth:1"},
+				"deny":           {"4", "/etc/pam.d/common-auth:3"},
+				"unlock_time":    {"900", "/etc/security/faillock.conf:2"},
+				"fail_interval":  {"900", "default"},
+			},
+		},
+		{
+			name: "pwhistory argument overrides pwhistory.conf",
+			files: map[string]string{
+				"/etc/pam.d/common-password":   "password required pam_pwhistory.so remember=24\npassword required pam_unix.so remember=3\n",
+				"/etc/security/pwhistory.conf": "remember = 12\n",
+			},
+			want: map[string]PAMSetting{
+				"remember": {"24", "/etc/pam.d/common-password:1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.files["/etc/pam.d/common-auth"]; !ok {
+				tt.files["/etc/pam.d/common-auth"] = "auth required pam_unix.so\n"
+			}
+			root := mapRoot(tt.files)
+			var errs []string
+			st := PAMStack{
+				Name:     "common",
+				Auth:     pamStackLines(root, "/etc/pam.d/common-auth", "auth", 0, &errs),
+				Password: pamStackLines(root, "/etc/pam.d/common-password", "password", 0, &errs),
+			}
+			if len(errs) > 0 {
+				t.Fatalf("stack errors: %v", errs)
+			}
+			got := pamSettings(root, st)
+			for k, want := range tt.want {
+				if got[k] != want {
+					t.Errorf("%s = %+v, want %+v", k, got[k], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package hardening
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A minimal PDF 1.4 writer for reports: US Letter pages, the standard
+// Courier and Helvetica fonts (no embedding) and word-wrapped text. Courier
+// keeps wrapping exact without font metrics.
+
+const (
+	pdfPageWidth  = 612
+	pdfPageHeight = 792
+	pdfMargin     = 50
+	pdfBodySize   = 9
+	pdfLeading    = 12
+	// Courier glyphs are 600/1000 em wide.
+	pdfCharsPerLine = (pdfPageWidth - 2*pdfMargin) * 1000 / (600 * pdfBodySize)
+)
+
+type pdfLine struct {
+	Text   string
+	Font   string // F1 Courier, F2 Courier-Bold, F3 Helvetica-Bold
+	Size   int
+	Color  [3]float64
+	Indent int
+	Gap    int // extra space before the line
+}
+
+type pdfDoc struct {
+	title string
+	lines []pdfLine
+}
+
+func (d *pdfDoc) heading(text string, size int) {
+	d.lines = append(d.lines, pdfLine{Text: text, Font: "F3", Size: size, Gap: size / 2})
+}
+
+// text adds body text wrapped to the page width.
+func (d *pdfDoc) text(s string, indent int, bold bool, color [3]float64) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	width := pdfCharsPerLine - indent
+	for _, para := range strings.Split(s, "\n") {
+		for _, ln := range wrapText(para, width) {
+			d.lines = append(d.lines, pdfLine{Text: ln, Font: font, Size: pdfBodySize, Color: color, Indent: indent})
+		}
+	}
+}
+
+func wrapText(s string, width int) []string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var out []string
+	cur := ""
+	for _, w := range words {
+		for len([]rune(w)) > width {
+			if cur != "" {
+				out = append(out, cur)
+				cur = ""
+			}
+			r := []rune(w)
+			out = append(out, string(r[:width]))
+			w = string(r[width:])
+		}
+		switch {
+		case cur == "":
+			cur = w
+		case len([]rune(cur))+1+len([]rune(w)) <= width:
+			cur += " " + w
+		default:
+			out = append(out, cur)
+			cur = w
+		}
+	}
+	return append(out, cur)
+}
+
+var (
+	pdfBlack = [3]float64{0, 0, 0}
+	pdfGrey  = [3]float64{0.35, 0.35, 0.35}
+)
+
+func pdfResultColor(r Result) [3]float64 {
+	switch r {
+	case ResultPass:
+		return [3]float64{0.07, 0.42, 0.17}
+	case ResultFail:
+		return [3]float64{0.70, 0.10, 0.10}
+	case ResultWarn:
+		return [3]float64{0.60, 0.42, 0}
+	default:
+		return [3]float64{0.16, 0.23, 0.48}
+	}
+}
+
+func renderPDF(w io.Writer, doc exportDoc) error {
+	d := &pdfDoc{title: doc.Title}
+	d.heading(doc.Title, 18)
+	d.text("Generated "+doc.Timestamp.Format(time.RFC3339), 0, false, pdfGrey)
+	for _, m := range doc.Meta {
+		d.text(m.Key+": "+m.Value, 0, false, pdfBlack)
+	}
+	d.heading("Findings", 13)
+	if len(doc.Items) == 0 {
+		d.text("No findings.", 0, false, pdfBlack)
+	}
+	for _, it := range doc.Items {
+		head := fmt.Sprintf("[%s] %s", strings.ToUpper(it.label()), it.ID)
+		if it.Severity != "" {
+			head += " - " + string(it.Severity)
+		}
+		d.lines = append(d.lines, pdfLine{Gap: 4})
+		d.text(head, 0, true, pdfResultColor(it.Result))
+		d.text(it.Title, 2, false, pdfBlack)
+		if it.Details != "" {
+			d.text(it.Details, 2, false, pdfGrey)
+		}
+		if len(it.Checks) > 0 {
+			d.text("Checks: "+strings.Join(it.Checks, ", "), 2, false, pdfGrey)
+		}
+		if it.Recommendation != "" && it.Result != ResultPass {
+			d.text("Fix: "+it.Recommendation, 2, false, pdfBlack)
+		}
+	}
+	_, err := w.Write(d.bytes(doc.Timestamp))
+	return err
+}
+
+// paginate turns lines into one content stream per page.
+func (d *pdfDoc) paginate() []string {
+	var pages []string
+	var b strings.Builder
+	y := pdfPageHeight - pdfMargin
+	flush := func() {
+		pages = append(pages, b.String())
+		b.Reset()
+		y = pdfPageHeight - pdfMargin
+	}
+	for _, ln := range d.lines {
+		size := ln.Size
+		if size == 0 {
+			size = pdfBodySize
+		}
+		step := ln.Gap + pdfLeading*size/pdfBodySize
+		if ln.Text == "" && ln.Gap > 0 {
+			step = ln.Gap
+		}
+		if y-step < pdfMargin && b.Len() > 0 {
+			flush()
+		}
+		y -= step
+		if ln.Text == "" {
+			continue
+		}
+		x := pdfMargin + ln.Indent*600*pdfBodySize/1000
+		fmt.Fprintf(&b, "BT /%s %d Tf %.2f %.2f %.2f rg %d %d Td (%s) Tj ET\n",
+			ln.Font, size, ln.Color[0], ln.Color[1], ln.Color[2], x, y, pdfEscape(ln.Text))
+	}
+	flush()
+	for i := range pages {
+		footer := fmt.Sprintf("%s - page %d of %d", d.title, i+1, len(pages))
+		pages[i] += fmt.Sprintf("BT /F1 8 Tf 0.5 0.5 0.5 rg %d %d Td (%s) Tj ET\n", pdfMargin, pdfMargin/2, pdfEscape(footer))
+	}
+	return pages
+}
+
+func (d *pdfDoc) bytes(created time.Time) []byte {
+	pages := d.paginate()
+
+	// Object layout: 1 catalog, 2 page tree, 3-5 fonts, 6 info, then a
+	// page object and its content stream for every page.
+	var objs []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 7+2*i)
+	}
+	objs = append(objs,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (fortis) /CreationDate (D:%s) >>", pdfEscape(d.title), created.UTC().Format("20060102150405Z")),
+	)
+	for i, content := range pages {
+		objs = append(objs,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 8+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfEscape encodes s as the body of a PDF literal string in WinAnsi
+// (Latin-1 subset); other characters become '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '–' || r == '—':
+			b.WriteByte('-')
+		case r == '‘' || r == '’':
+			b.WriteByte('\'')
+		case r == '“' || r == '”':
+			b.WriteByte('"')
+		case r == '…':
+			b.WriteString("...")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
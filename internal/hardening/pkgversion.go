@@ -0,0 +1,233 @@
+package hardening
+
+import (
+	"strconv"
+	"strings"
+)
+
+// comparePackageVersions orders two versions of a package under the rules
+// of its package manager: negative when a is older than b.
+func comparePackageVersions(manager, a, b string) int {
+	if manager == "rpm" {
+		return compareRPMVersions(a, b)
+	}
+	return compareDebVersions(a, b)
+}
+
+// splitEpoch splits "epoch:rest"; a missing or malformed epoch is 0.
+func splitEpoch(v string) (int, string) {
+	e, rest, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, v
+	}
+	n, err := strconv.Atoi(e)
+	if err != nil {
+		return 0, v
+	}
+	return n, rest
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareDebVersions implements dpkg's [epoch:]upstream[-revision]
+// ordering: letters sort before non-letters and "~" before everything,
+// even the end of the string, so 1.0~rc1 < 1.0.
+func compareDebVersions(a, b string) int {
+	ea, ra := splitEpoch(strings.TrimSpace(a))
+	eb, rb := splitEpoch(strings.TrimSpace(b))
+	if c := compareInts(ea, eb); c != 0 {
+		return c
+	}
+	ua, reva := splitDebRevision(ra)
+	ub, revb := splitDebRevision(rb)
+	if c := debVerRevCmp(ua, ub); c != 0 {
+		return c
+	}
+	return debVerRevCmp(reva, revb)
+}
+
+func splitDebRevision(v string) (upstream, revision string) {
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+func debOrder(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return 0
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func debVerRevCmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := 0, 0
+			if i < len(a) {
+				ac = debOrder(a[i])
+			}
+			if j < len(b) {
+				bc = debOrder(b[j])
+			}
+			if ac != bc {
+				return compareInts(ac, bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = compareInts(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// compareRPMVersions compares [epoch:]version[-release] the way rpm does.
+// The release is only compared when both sides have one, so a feed saying
+// "fixed in 3.0.7" covers every 3.0.7 build.
+func compareRPMVersions(a, b string) int {
+	ea, ra := splitEpoch(strings.TrimSpace(a))
+	eb, rb := splitEpoch(strings.TrimSpace(b))
+	if c := compareInts(ea, eb); c != 0 {
+		return c
+	}
+	va, rela, _ := strings.Cut(ra, "-")
+	vb, relb, _ := strings.Cut(rb, "-")
+	if c := rpmVerCmp(va, vb); c != 0 {
+		return c
+	}
+	if rela == "" || relb == "" {
+		return 0
+	}
+	return rpmVerCmp(rela, relb)
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+// rpmVerCmp is rpmvercmp(3): alternating numeric and alphabetic segments,
+// numbers newer than letters, "~" older and "^" newer than the end.
+func rpmVerCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	skip := func(s string, i int) int {
+		for i < len(s) && !isDigit(s[i]) && !isAlpha(s[i]) && s[i] != '~' && s[i] != '^' {
+			i++
+		}
+		return i
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		i, j = skip(a, i), skip(b, j)
+		at := func(s string, k int) byte {
+			if k < len(s) {
+				return s[k]
+			}
+			return 0
+		}
+		ca, cb := at(a, i), at(b, j)
+		if ca == '~' || cb == '~' {
+			if ca != '~' {
+				return 1
+			}
+			if cb != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if ca == '^' || cb == '^' {
+			switch {
+			case ca == 0:
+				return -1
+			case cb == 0:
+				return 1
+			case ca != '^':
+				return 1
+			case cb != '^':
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if ca == 0 || cb == 0 {
+			break
+		}
+		si, sj := i, j
+		numeric := isDigit(ca)
+		class := isAlpha
+		if numeric {
+			class = isDigit
+		}
+		for i < len(a) && class(a[i]) {
+			i++
+		}
+		for j < len(b) && class(b[j]) {
+			j++
+		}
+		sa, sb := a[si:i], b[sj:j]
+		if sb == "" {
+			// Segments of different types: numbers are newer.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if c := compareInts(len(sa), len(sb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i < len(a):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,109 @@
+package hardening
+
+import "testing"
+
+func TestCompareDebVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1:0.9", "2.0", 1},
+		{"0:2.0", "2.0", 0},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"1.0-1ubuntu1", "1.0-2", -1},
+		{"5.2.15-2", "5.2.15-2+b9", -1},
+		{"1.01", "1.1", 0},
+		{"1.10", "1.9", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0+", -1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{"3.0.2-0ubuntu1.15", "3.0.2-0ubuntu1.9", 1},
+	}
+	for _, tt := range tests {
+		if got := compareDebVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDebVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareDebVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareDebVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestDebVerRevCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"~", "", -1},
+		{"a", "", 1},
+		{"a", "B", 1},
+		{"a", ".", -1},
+		{"007", "7", 0},
+		{"1ubuntu1", "1", 1},
+	}
+	for _, tt := range tests {
+		if got := debVerRevCmp(tt.a, tt.b); got != tt.want {
+			t.Errorf("debVerRevCmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareRPMVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1.el9", "1.0-1.el9", 0},
+		{"1.0-1.el9", "1.0-2.el9", -1},
+		{"1:0.9-1", "2.0-1", 1},
+		{"0:3.0.7-24.el9", "3.0.7-24.el9", 0},
+		{"3.0.7", "3.0.7-24.el9", 0},
+		{"3.0.7-24.el9", "3.0.7", 0},
+		{"3.0.6-24.el9", "3.0.7", -1},
+		{"1.01", "1.1", 0},
+		{"1.10", "1.9", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"2.02-156.el9", "2.02-150.el9", 1},
+	}
+	for _, tt := range tests {
+		if got := compareRPMVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareRPMVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareRPMVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareRPMVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestRPMVerCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1_0", 0},
+		{"1.0", "1.0.0", -1},
+		{"1.a", "1.1", -1},
+		{"abc", "abd", -1},
+		{"1~", "1", -1},
+		{"1~a", "1~b", -1},
+		{"1^", "1", 1},
+		{"1^a", "1^b", -1},
+		{"10", "9", 1},
+		{"000010", "9", 1},
+	}
+	for _, tt := range tests {
+		if got := rpmVerCmp(tt.a, tt.b); got != tt.want {
+			t.Errorf("rpmVerCmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -6,17 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/exec"
 	"strings"
-	"time"
 )
 
 type ApplyOptions struct {
 	Profile    string
 	DryRun     bool
-	Rollback   bool
 	SkipChecks bool
 	Yes        bool
+	// RollbackDir holds the transaction journal and snapshots; empty uses
+	// DefaultRollbackDir.
+	RollbackDir string
 }
 
 type PlanItem struct {
@@ -25,13 +26,17 @@ type PlanItem struct {
 }
 
 type ApplyResult struct {
-	Profile     string
-	Plan        []PlanItem
-	RollbackDir string
+	Profile       string
+	Plan          []PlanItem
+	TransactionID string
+	RollbackDir   string
 }
 
+// ApplyProfile applies a hardening profile inside a transaction: affected
+// files are snapshotted before they change, and any failure reverts every
+// change made so far. Committed transactions can be undone later with
+// Rollback.
 func ApplyProfile(ctx context.Context, opts ApplyOptions) (ApplyResult, error) {
-	_ = ctx
 	if opts.Profile == "" {
 		p, err := promptProfile()
 		if err != nil {
@@ -41,84 +46,125 @@ func ApplyProfile(ctx context.Context, opts ApplyOptions) (ApplyResult, error) {
 	}
 
 	res := ApplyResult{Profile: opts.Profile}
+	if !opts.DryRun && !opts.Yes {
+		return res, errors.New("refusing to apply without --yes")
+	}
 
-	// Very small starter set of actions; safe and expandable.
-	// Only applies when --yes is provided.
-	filesToBackup := map[string]struct{}{}
+	var tx *Transaction
+	if !opts.DryRun {
+		t, err := BeginTransaction(opts.RollbackDir, "apply "+opts.Profile)
+		if err != nil {
+			return res, err
+		}
+		tx = t
+		res.TransactionID, res.RollbackDir = tx.ID(), tx.Dir()
+	}
 
+	sshChanged := false
 	applySSHD := func(key, value string) error {
-		path := "/etc/ssh/sshd_config"
-		res.Plan = append(res.Plan, PlanItem{Description: fmt.Sprintf("Set %s %s", key, value), ChangedFile: path})
-		filesToBackup[path] = struct{}{}
+		path := SSHDConfigPath
+		changed := path
+		if f, _, err := sshdSettingLocation(path, key); err == nil {
+			changed = f
+		}
+		res.Plan = append(res.Plan, PlanItem{Description: fmt.Sprintf("Set %s %s", key, value), ChangedFile: changed})
 		if opts.DryRun {
 			return nil
 		}
-		if !opts.Yes {
-			return errors.New("refusing to apply without --yes")
-		}
-		if err := setSSHDConfigKey(path, key, value); err != nil {
+		if err := snapshotSSHDConfig(tx, path); err != nil {
 			return err
 		}
-		return nil
+		sshChanged = true
+		return tx.Do(fmt.Sprintf("Set %s %s", key, value), func() error {
+			return setSSHDConfigKey(ctx, tx, path, key, value)
+		})
 	}
 
 	applySysctl := func(key, value string) error {
-		res.Plan = append(res.Plan, PlanItem{Description: fmt.Sprintf("Persist sysctl %s=%s", key, value), ChangedFile: "/etc/sysctl.d/99-fortis.conf"})
+		res.Plan = append(res.Plan, PlanItem{Description: fmt.Sprintf("Persist sysctl %s=%s", key, value), ChangedFile: sysctlPersistPath})
 		if opts.DryRun {
 			return nil
 		}
-		if !opts.Yes {
-			return errors.New("refusing to apply without --yes")
+		if err := tx.SnapshotFile(sysctlPersistPath); err != nil {
+			return err
 		}
-		return persistSysctl(key, value)
+		return tx.Do(fmt.Sprintf("Persist sysctl %s=%s", key, value), func() error {
+			return persistSysctl(key, value)
+		})
 	}
 
 	if !opts.SkipChecks {
 		res.Plan = append(res.Plan, PlanItem{Description: "Pre-check: ensure running on supported OS", ChangedFile: ""})
 	}
 
-	switch strings.ToLower(opts.Profile) {
-	case "cis", "cis-level1", "baseline":
-		if err := applySSHD("PermitRootLogin", "no"); err != nil {
-			return res, err
-		}
-		if err := applySSHD("PasswordAuthentication", "no"); err != nil {
-			return res, err
-		}
-		if err := applySysctl("net.ipv4.ip_forward", "0"); err != nil {
-			return res, err
-		}
-	case "webserver":
-		if err := applySSHD("PermitRootLogin", "no"); err != nil {
-			return res, err
-		}
-		if err := applySSHD("PasswordAuthentication", "no"); err != nil {
-			return res, err
-		}
-	case "database":
-		if err := applySSHD("PermitRootLogin", "no"); err != nil {
-			return res, err
-		}
-	case "desktop":
-		if err := applySSHD("PermitRootLogin", "no"); err != nil {
-			return res, err
+	steps := func() error {
+		switch strings.ToLower(opts.Profile) {
+		case "cis", "cis-level1", "baseline":
+			if err := applySSHD("PermitRootLogin", "no"); err != nil {
+				return err
+			}
+			if err := applySSHD("PasswordAuthentication", "no"); err != nil {
+				return err
+			}
+			if err := applySysctl("net.ipv4.ip_forward", "0"); err != nil {
+				return err
+			}
+		case "webserver":
+			if err := applySSHD("PermitRootLogin", "no"); err != nil {
+				return err
+			}
+			if err := applySSHD("PasswordAuthentication", "no"); err != nil {
+				return err
+			}
+		case "database":
+			if err := applySSHD("PermitRootLogin", "no"); err != nil {
+				return err
+			}
+		case "desktop":
+			if err := applySSHD("PermitRootLogin", "no"); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown profile: %s", opts.Profile)
+		}
+		if sshChanged {
+			return reloadSSHDInTx(ctx, tx)
 		}
-	default:
-		return res, fmt.Errorf("unknown profile: %s", opts.Profile)
+		return nil
 	}
 
-	if opts.Rollback && !opts.DryRun {
-		if !opts.Yes {
-			return res, errors.New("--rollback requires --yes to create and store backup")
-		}
-		rb, err := backupFiles(filesToBackup)
-		if err != nil {
-			return res, err
+	err := steps()
+	if opts.DryRun {
+		return res, err
+	}
+	return res, tx.Finish(ctx, err)
+}
+
+// snapshotSSHDConfig saves every file of the sshd configuration, since an
+// edit lands in whichever file currently sets the option.
+func snapshotSSHDConfig(tx *Transaction, path string) error {
+	if tx == nil {
+		return nil
+	}
+	files := []string{path}
+	if cfg, err := ParseSSHDConfig(path); err == nil {
+		files = cfg.Files
+	}
+	for _, f := range files {
+		if err := tx.SnapshotFile(f); err != nil {
+			return err
 		}
-		res.RollbackDir = rb
 	}
+	return nil
+}
 
-	return res, nil
+// reloadSSHDInTx reloads sshd as a transaction step. Hosts without sshd
+// have nothing to reload.
+func reloadSSHDInTx(ctx context.Context, tx *Transaction) error {
+	if _, err := exec.LookPath("sshd"); err != nil {
+		return nil
+	}
+	return tx.Do("Reload sshd", func() error { return reloadSSHD(ctx) })
 }
 
 func promptProfile() (string, error) {
@@ -148,28 +194,3 @@ func promptProfile() (string, error) {
 		return "", errors.New("invalid selection")
 	}
 }
-
-func backupFiles(files map[string]struct{}) (string, error) {
-	ts := time.Now().Format("20060102-150405")
-	rbDir := filepath.Join("/var/lib/fortis/rollback", ts)
-	if err := os.MkdirAll(rbDir, 0o755); err != nil {
-		// fallback
-		rbDir = filepath.Join(".", ".fortis-rollback", ts)
-		if err2 := os.MkdirAll(rbDir, 0o755); err2 != nil {
-			return "", err
-		}
-	}
-
-	for p := range files {
-		b, err := os.ReadFile(p)
-		if err != nil {
-			// if file missing, skip backup
-			continue
-		}
-		name := strings.ReplaceAll(strings.TrimPrefix(p, "/"), "/", "_")
-		if err := os.WriteFile(filepath.Join(rbDir, name), b, 0o600); err != nil {
-			return "", err
-		}
-	}
-	return rbDir, nil
-}
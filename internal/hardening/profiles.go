@@ -0,0 +1,336 @@
+package hardening
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Audit levels, from least to most demanding. A check selected at a level
+// also runs at every higher level.
+const (
+	LevelBasic  = "basic"
+	LevelMedium = "medium"
+	LevelStrict = "strict"
+)
+
+var auditLevels = []string{LevelBasic, LevelMedium, LevelStrict}
+
+// Built-in audit profiles. A file with the same name in
+// <config-dir>/profiles replaces the built-in one.
+//
+//go:embed profiles/*.yaml
+var profileFS embed.FS
+
+// AuditProfile selects the checks an audit runs and adjusts their
+// thresholds per audit level.
+//
+//	name: pci
+//	benchmark_level: {strict: 2}
+//	checks:
+//	  - tag: ssh                       # select by tag or id glob
+//	  - id: ssh.max_auth_tries
+//	    params:
+//	      basic:  {value: "6"}
+//	      medium: {value: "4"}
+//	      strict: {value: "3"}
+//	  - tag: auditd
+//	    level: strict                  # only from this level up
+//	  - id: ssh.x11_forwarding
+//	    exclude: true
+//
+// Rules are applied in order and later rules win. A rule selects the
+// matching checks from its level (basic when unset); a rule that only has
+// params adjusts thresholds without changing the selection. Site checks
+// loaded from <config-dir>/checks are selected at basic level unless a rule
+// says otherwise.
+type AuditProfile struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// BenchmarkLevel maps an audit level to the CIS profile level used when
+	// --cis-level is not given.
+	BenchmarkLevel map[string]int `json:"benchmark_level,omitempty" yaml:"benchmark_level,omitempty"`
+	Checks         []ProfileRule  `json:"checks" yaml:"checks"`
+	Source         string         `json:"source" yaml:"-"`
+}
+
+type ProfileRule struct {
+	ID      string `json:"id,omitempty" yaml:"id,omitempty"`
+	Tag     string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Level   string `json:"level,omitempty" yaml:"level,omitempty"`
+	Exclude bool   `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	// Params overrides check parameters per audit level. Overrides for
+	// lower levels carry over to higher ones.
+	Params map[string]ParamOverride `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// ParamOverride replaces the comparison of a YAML-defined check (op, value)
+// and/or the scoring of any check (weight, severity).
+type ParamOverride struct {
+	Op       string   `json:"op,omitempty" yaml:"op,omitempty"`
+	Value    string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Weight   int      `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Severity Severity `json:"severity,omitempty" yaml:"severity,omitempty"`
+}
+
+func (o ParamOverride) merge(n ParamOverride) ParamOverride {
+	if n.Op != "" {
+		o.Op = n.Op
+	}
+	if n.Value != "" {
+		o.Value = n.Value
+	}
+	if n.Weight != 0 {
+		o.Weight = n.Weight
+	}
+	if n.Severity != "" {
+		o.Severity = n.Severity
+	}
+	return o
+}
+
+func (o ParamOverride) empty() bool {
+	return o == ParamOverride{}
+}
+
+func levelRank(level string) (int, error) {
+	l := strings.ToLower(strings.TrimSpace(level))
+	for i, v := range auditLevels {
+		if v == l {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown audit level %q (want %s)", level, strings.Join(auditLevels, ", "))
+}
+
+func (r ProfileRule) matches(c Check) bool {
+	if r.ID == "" && r.Tag == "" {
+		return false
+	}
+	if r.ID != "" {
+		if ok, _ := path.Match(r.ID, c.ID); !ok {
+			return false
+		}
+	}
+	if r.Tag != "" && !c.HasTag(r.Tag) {
+		return false
+	}
+	return true
+}
+
+func (p AuditProfile) validate() error {
+	for k := range p.BenchmarkLevel {
+		if _, err := levelRank(k); err != nil {
+			return fmt.Errorf("profile %s: benchmark_level: %w", p.Name, err)
+		}
+	}
+	for i, r := range p.Checks {
+		if r.ID == "" && r.Tag == "" {
+			return fmt.Errorf("profile %s: rule %d needs id or tag", p.Name, i+1)
+		}
+		if r.ID != "" {
+			if _, err := path.Match(r.ID, ""); err != nil {
+				return fmt.Errorf("profile %s: rule %d: bad id pattern %q", p.Name, i+1, r.ID)
+			}
+		}
+		if r.Level != "" {
+			if _, err := levelRank(r.Level); err != nil {
+				return fmt.Errorf("profile %s: rule %d: %w", p.Name, i+1, err)
+			}
+		}
+		for k := range r.Params {
+			if _, err := levelRank(k); err != nil {
+				return fmt.Errorf("profile %s: rule %d params: %w", p.Name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Select returns the checks of reg that the profile runs at level, with the
+// level's parameter overrides applied.
+func (p AuditProfile) Select(reg *Registry, level string) ([]Check, error) {
+	rank, err := levelRank(level)
+	if err != nil {
+		return nil, err
+	}
+	out := []Check{}
+	for _, c := range reg.Checks() {
+		included := isSiteCheck(c)
+		minRank := 0
+		var ov ParamOverride
+		for _, r := range p.Checks {
+			if !r.matches(c) {
+				continue
+			}
+			switch {
+			case r.Exclude:
+				included = false
+			case r.Level != "":
+				included = true
+				minRank, _ = levelRank(r.Level)
+			case len(r.Params) == 0:
+				included = true
+				minRank = 0
+			}
+			for i := 0; i <= rank; i++ {
+				if o, ok := r.Params[auditLevels[i]]; ok {
+					ov = ov.merge(o)
+				}
+			}
+		}
+		if !included || minRank > rank {
+			continue
+		}
+		if !ov.empty() {
+			c, err = c.withParams(ov)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// benchmarkLevelFor returns the CIS level configured for an audit level, or
+// the highest one configured for a lower level; 1 when none is set.
+func (p AuditProfile) benchmarkLevelFor(level string) int {
+	rank, err := levelRank(level)
+	if err != nil {
+		return 1
+	}
+	out := 1
+	for i := 0; i <= rank; i++ {
+		if v, ok := p.BenchmarkLevel[auditLevels[i]]; ok && v > 0 {
+			out = v
+		}
+	}
+	return out
+}
+
+// isSiteCheck reports whether c was loaded from the site config directory
+// rather than shipped with fortis.
+func isSiteCheck(c Check) bool {
+	return c.Source != "builtin" && !strings.HasPrefix(c.Source, "benchmark:")
+}
+
+// withParams returns a copy of c with op/value recompiled from its YAML spec
+// and weight/severity overridden on the findings it produces.
+func (c Check) withParams(o ParamOverride) (Check, error) {
+	if o.Op != "" || o.Value != "" {
+		if c.spec == nil {
+			return c, fmt.Errorf("check %s: op/value overrides need a YAML-defined check", c.ID)
+		}
+		spec := *c.spec
+		if o.Op != "" {
+			spec.Op = o.Op
+		}
+		if o.Value != "" {
+			spec.Value = o.Value
+		}
+		nc, err := spec.Compile()
+		if err != nil {
+			return c, err
+		}
+		c.Run = nc.Run
+		c.spec = nc.spec
+	}
+	if o.Weight > 0 {
+		c.Weight = o.Weight
+	}
+	if o.Severity != "" {
+		c.Severity = o.Severity
+	}
+	if o.Weight > 0 || o.Severity != "" {
+		run := c.Run
+		c.Run = func(ctx context.Context, opts AuditOptions) (Finding, error) {
+			f, err := run(ctx, opts)
+			if o.Weight > 0 {
+				f.Weight = o.Weight
+			}
+			if o.Severity != "" {
+				f.Severity = o.Severity
+			}
+			return f, err
+		}
+	}
+	return c, nil
+}
+
+func parseProfile(source string, b []byte) (AuditProfile, error) {
+	var p AuditProfile
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return AuditProfile{}, fmt.Errorf("%s: %w", source, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(path.Base(source), path.Ext(source))
+	}
+	p.Source = source
+	if err := p.validate(); err != nil {
+		return AuditProfile{}, fmt.Errorf("%s: %w", source, err)
+	}
+	return p, nil
+}
+
+// LoadProfile reads <configDir>/profiles/<name>.yaml, falling back to the
+// built-in profile of that name.
+func LoadProfile(configDir, name string) (AuditProfile, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return AuditProfile{}, fmt.Errorf("invalid profile name %q", name)
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		p := filepath.Join(resolveConfigDir(configDir), "profiles", name+ext)
+		b, err := os.ReadFile(p)
+		if err == nil {
+			return parseProfile(p, b)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return AuditProfile{}, err
+		}
+	}
+	b, err := profileFS.ReadFile("profiles/" + name + ".yaml")
+	if err != nil {
+		names, _ := ProfileNames(configDir)
+		return AuditProfile{}, fmt.Errorf("unknown audit profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return parseProfile("builtin:"+name+".yaml", b)
+}
+
+// ProfileNames lists the built-in and site profile names.
+func ProfileNames(configDir string) ([]string, error) {
+	seen := map[string]bool{}
+	builtin, err := fs.Glob(profileFS, "profiles/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range builtin {
+		seen[strings.TrimSuffix(path.Base(n), ".yaml")] = true
+	}
+	entries, err := os.ReadDir(filepath.Join(resolveConfigDir(configDir), "profiles"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			seen[strings.TrimSuffix(e.Name(), ext)] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for n := range seen {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out, nil
+}
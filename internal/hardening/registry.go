@@ -0,0 +1,208 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultConfigDir is consulted for site-specific checks when --config-dir is
+// not given. A missing directory is not an error.
+const DefaultConfigDir = "/etc/fortis/harden"
+
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+type checkFunc func(ctx context.Context, opts AuditOptions) (Finding, error)
+
+// Check is a registered audit check. Built-in checks are Go functions;
+// checks declared in YAML are compiled into the same shape.
+type Check struct {
+	ID         string   `json:"id" yaml:"id"`
+	Title      string   `json:"title" yaml:"title"`
+	Weight     int      `json:"weight" yaml:"weight"`
+	Severity   Severity `json:"severity" yaml:"severity"`
+	Tags       []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	References []string `json:"references,omitempty" yaml:"references,omitempty"`
+	// Benchmark is the benchmark recommendation ID (e.g. "CIS 5.2.7") and
+	// Level its profile level (1 or 2; 0 when not part of a benchmark).
+	Benchmark string `json:"benchmark,omitempty" yaml:"benchmark,omitempty"`
+	Level     int    `json:"level,omitempty" yaml:"level,omitempty"`
+	Source    string `json:"source" yaml:"source"`
+
+	Run checkFunc `json:"-" yaml:"-"`
+	// spec is the YAML definition, kept so profiles can recompile the check
+	// with other thresholds.
+	spec *CheckSpec
+}
+
+func (c Check) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+type Registry struct {
+	checks []Check
+	byID   map[string]int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byID: map[string]int{}}
+}
+
+func (r *Registry) Register(c Check) error {
+	c.ID = strings.TrimSpace(c.ID)
+	if c.ID == "" {
+		return errors.New("check id is required")
+	}
+	if c.Run == nil {
+		return fmt.Errorf("check %s: no implementation", c.ID)
+	}
+	if _, ok := r.byID[c.ID]; ok {
+		return fmt.Errorf("check %s: already registered", c.ID)
+	}
+	if c.Weight <= 0 {
+		c.Weight = 10
+	}
+	if c.Severity == "" {
+		c.Severity = SeverityMedium
+	}
+	if c.Source == "" {
+		c.Source = "builtin"
+	}
+	r.byID[c.ID] = len(r.checks)
+	r.checks = append(r.checks, c)
+	return nil
+}
+
+func (r *Registry) Get(id string) (Check, bool) {
+	i, ok := r.byID[id]
+	if !ok {
+		return Check{}, false
+	}
+	return r.checks[i], true
+}
+
+// Checks returns the registered checks in registration order.
+func (r *Registry) Checks() []Check {
+	out := make([]Check, len(r.checks))
+	copy(out, r.checks)
+	return out
+}
+
+// LoadDir registers every YAML check file (*.yaml, *.yml) found in dir, in
+// lexical file order.
+func (r *Registry) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if ext == ".yaml" || ext == ".yml" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := r.LoadFile(filepath.Join(dir, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultRegistry returns the built-in checks and the embedded benchmark
+// content.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, c := range builtinChecks() {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+	if err := loadBenchmarks(r); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// LoadRegistry returns the built-in checks plus any YAML checks found in
+// <configDir>/checks. An empty configDir falls back to DefaultConfigDir.
+func LoadRegistry(configDir string) (*Registry, error) {
+	r := DefaultRegistry()
+	dir := filepath.Join(resolveConfigDir(configDir), "checks")
+	if err := r.LoadDir(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func resolveConfigDir(configDir string) string {
+	if strings.TrimSpace(configDir) == "" {
+		return DefaultConfigDir
+	}
+	return configDir
+}
+
+func builtinChecks() []Check {
+	return []Check{
+		{ID: "ssh.root_login", Title: "Ensure root SSH login is disabled", Weight: 30, Severity: SeverityHigh, Tags: []string{"ssh", "cis"}, Benchmark: "CIS 5.2.7", Level: 1, Run: checkSSHRootLogin},
+		{ID: "ssh.password_auth", Title: "Ensure SSH password authentication is disabled", Weight: 25, Severity: SeverityHigh, Tags: []string{"ssh"}, Run: checkSSHPasswordAuth},
+		{ID: "sysctl.ip_forward", Title: "Ensure IPv4 forwarding is disabled", Weight: 20, Severity: SeverityMedium, Tags: []string{"sysctl", "network", "cis"}, Benchmark: "CIS 3.2.2", Level: 1, Run: checkIPForwarding},
+		{ID: "firewall.present", Title: "Ensure a firewall is installed", Weight: 25, Severity: SeverityHigh, Tags: []string{"firewall", "cis"}, Benchmark: "CIS 3.5.1.1", Level: 1, Run: checkFirewallPresence},
+		{ID: "firewall.enabled", Title: "Ensure the firewall is active", Weight: 25, Severity: SeverityHigh, Tags: []string{"firewall", "cis"}, Benchmark: "CIS 3.5.1.3", Level: 1, Run: checkFirewallEnabled},
+		{ID: "firewall.default_deny", Title: "Ensure the firewall denies incoming and forwarded traffic by default", Weight: 20, Severity: SeverityHigh, Tags: []string{"firewall", "cis"}, Benchmark: "CIS 3.5.1.7", Level: 1, Run: checkFirewallDefaultDeny},
+		{ID: "firewall.any_any", Title: "Ensure no firewall rule allows any traffic from anywhere", Weight: 15, Severity: SeverityHigh, Tags: []string{"firewall"}, Run: checkFirewallAnyAny},
+		{ID: "firewall.uncovered_ports", Title: "Ensure every listening port is covered by a firewall rule", Weight: 15, Severity: SeverityMedium, Tags: []string{"firewall", "cis"}, Benchmark: "CIS 3.5.1.6", Level: 1, Run: checkFirewallUncoveredPorts},
+		{ID: "firewall.policy_drift", Title: "Ensure the firewall matches the declared policy", Weight: 15, Severity: SeverityMedium, Tags: []string{"firewall"}, Run: checkFirewallPolicyDrift},
+		{ID: "packages.cve_critical", Title: "Ensure no installed package has a known critical vulnerability", Weight: 30, Severity: SeverityCritical, Tags: []string{"packages", "vulnerabilities"}, Run: packageVulnCheck(SeverityCritical)},
+		{ID: "packages.cve_high", Title: "Ensure no installed package has a known high-severity vulnerability", Weight: 20, Severity: SeverityHigh, Tags: []string{"packages", "vulnerabilities"}, Run: packageVulnCheck(SeverityHigh)},
+		{ID: "packages.cve_medium", Title: "Ensure no installed package has a known medium-severity vulnerability", Weight: 10, Severity: SeverityMedium, Tags: []string{"packages", "vulnerabilities"}, Run: packageVulnCheck(SeverityMedium)},
+		{ID: "packages.cve_low", Title: "Ensure no installed package has a known low-severity vulnerability", Weight: 5, Severity: SeverityLow, Tags: []string{"packages", "vulnerabilities"}, Run: packageVulnCheck(SeverityLow)},
+		{ID: "accounts.root_only_uid0", Title: "Ensure root is the only UID 0 account", Weight: 30, Severity: SeverityCritical, Tags: []string{"accounts", "cis"}, Benchmark: "CIS 6.2.10", Level: 1, Run: issueCheck("Remove the extra UID 0 accounts or give them their own UID", SeverityLow, userIssues, UserIssueUID0)},
+		{ID: "accounts.shadowed_passwords", Title: "Ensure accounts in /etc/passwd use shadowed passwords", Weight: 15, Severity: SeverityHigh, Tags: []string{"accounts", "cis"}, Benchmark: "CIS 6.2.1", Level: 1, Run: issueCheck("Run pwconv to move the hashes to /etc/shadow", SeverityLow, userIssues, UserIssueUnshadowed)},
+		{ID: "accounts.empty_passwords", Title: "Ensure password fields are not empty", Weight: 25, Severity: SeverityCritical, Tags: []string{"accounts", "passwords", "cis"}, Benchmark: "CIS 6.2.2", Level: 1, Run: issueCheck("Lock the accounts (passwd -l) or set a password", SeverityLow, userIssues, UserIssueEmptyPassword)},
+		{ID: "accounts.password_hashing", Title: "Ensure password hashing algorithm is up to date", Weight: 10, Severity: SeverityHigh, Tags: []string{"accounts", "passwords", "cis"}, Benchmark: "CIS 5.4.4", Level: 1, Run: issueCheck("Set ENCRYPT_METHOD SHA512 or YESCRYPT in /etc/login.defs and have the listed users change their passwords", SeverityHigh, userIssues, UserIssueWeakHash)},
+		{ID: "accounts.password_aging", Title: "Ensure user password aging matches the password policy", Weight: 5, Severity: SeverityLow, Tags: []string{"accounts", "passwords"}, Run: issueCheck("Apply the policy to existing users with chage --maxdays, --mindays and --warndays", SeverityMedium, userIssues, UserIssueAging)},
+		{ID: "accounts.inactive", Title: "Ensure inactive accounts are disabled", Weight: 10, Severity: SeverityMedium, Tags: []string{"accounts"}, Run: issueCheck("Lock the accounts with 'fortis harden users --lock-inactive --yes' or remove them", SeverityLow, inactiveUserIssues, UserIssueInactive)},
+		{ID: "accounts.duplicate_uids", Title: "Ensure no duplicate UIDs or user names exist", Weight: 10, Severity: SeverityHigh, Tags: []string{"accounts", "cis"}, Benchmark: "CIS 6.2.5", Level: 1, Run: issueCheck("Give every account its own UID and name", SeverityLow, userIssues, UserIssueDuplicateUID)},
+		{ID: "accounts.duplicate_gids", Title: "Ensure no duplicate GIDs or group names exist", Weight: 5, Severity: SeverityMedium, Tags: []string{"accounts", "cis"}, Benchmark: "CIS 6.2.6", Level: 1, Run: issueCheck("Give every group its own GID and name", SeverityLow, userIssues, UserIssueDuplicateGID)},
+		{ID: "accounts.home_directories", Title: "Ensure local interactive user home directories exist, are owned by the user and are 750 or stricter", Weight: 5, Severity: SeverityMedium, Tags: []string{"accounts", "cis"}, Benchmark: "CIS 6.2.12", Level: 1, Run: issueCheck("Create missing home directories, chown them to their users and chmod 750", SeverityMedium, userIssues, UserIssueHome)},
+		{ID: "ssh.authorized_keys", Title: "Ensure authorized_keys files are protected and grant no dangerous options", Weight: 10, Severity: SeverityHigh, Tags: []string{"ssh", "accounts"}, Run: issueCheck("Remove environment=, tunnel= and wildcard permitopen= options, restrict root keys with from= or command=, replace DSA and short RSA keys and chmod 600 the files", SeverityHigh, userIssues, UserIssueAuthorizedKeys)},
+		{ID: "pam.lockout", Title: "Ensure lockout for failed password attempts is configured", Weight: 10, Severity: SeverityHigh, Tags: []string{"pam", "passwords", "cis"}, Benchmark: "CIS 5.4.2", Level: 1, Run: issueCheck("Add pam_faillock preauth, authfail and account lines with deny=5 and unlock_time=900, or run 'fortis harden pam --apply --yes'", SeverityMedium, pamIssues, PAMIssueLockout, PAMIssueDeny, PAMIssueUnlockTime)},
+		{ID: "pam.password_history", Title: "Ensure password reuse is limited", Weight: 5, Severity: SeverityMedium, Tags: []string{"pam", "passwords", "cis"}, Benchmark: "CIS 5.4.3", Level: 1, Run: issueCheck("Add pam_pwhistory remember=5 (or pam_unix remember=5) to the password stack", SeverityLow, pamIssues, PAMIssueHistory)},
+		{ID: "pam.password_hashing", Title: "Ensure pam_unix hashes passwords with SHA-512 or yescrypt", Weight: 10, Severity: SeverityHigh, Tags: []string{"pam", "passwords", "cis"}, Benchmark: "CIS 5.4.4", Level: 1, Run: issueCheck("Replace md5/sha256/bigcrypt on the pam_unix password line with sha512 or yescrypt", SeverityLow, pamIssues, PAMIssueHash)},
+		{ID: "pam.stack_integrity", Title: "Ensure every module in the shared PAM stacks is installed and parses", Weight: 10, Severity: SeverityHigh, Tags: []string{"pam"}, Run: issueCheck("Install the missing modules or remove their lines; a missing module fails the stack", SeverityHigh, pamIssues, PAMIssueModule, PAMIssueSyntax)},
+		{ID: "pam.nullok", Title: "Ensure pam_unix does not accept empty passwords", Weight: 3, Severity: SeverityLow, Tags: []string{"pam", "passwords"}, Run: issueCheck("Remove nullok from the pam_unix auth line", SeverityHigh, pamIssues, PAMIssueNullok)},
+		{ID: "auditd.rules_privileged", Title: "Ensure use of privileged commands is collected", Weight: 5, Severity: SeverityMedium, Tags: []string{"auditd", "cis"}, Benchmark: "CIS 4.1.3.6", Level: 2, Run: auditdPrivilegedCheck},
+		{ID: "auditd.rules_loaded", Title: "Ensure the running and on disk audit configuration is the same", Weight: 5, Severity: SeverityMedium, Tags: []string{"auditd", "cis"}, Benchmark: "CIS 4.1.3.21", Level: 2, Run: auditdLoadedCheck},
+		{ID: "sudo.use_pty", Title: "Ensure sudo commands use a pseudo terminal", Weight: 5, Severity: SeverityMedium, Tags: []string{"sudo", "cis"}, Benchmark: "CIS 5.3.2", Level: 1, Run: issueCheck("Add 'Defaults use_pty' with visudo, or run 'fortis harden users --sudo-secure --yes'", SeverityLow, sudoIssues, SudoIssueUsePTY)},
+		{ID: "sudo.logfile", Title: "Ensure sudo log file exists", Weight: 5, Severity: SeverityLow, Tags: []string{"sudo", "logging", "cis"}, Benchmark: "CIS 5.3.3", Level: 1, Run: issueCheck("Add 'Defaults logfile=\"/var/log/sudo.log\"' with visudo, or run 'fortis harden users --sudo-secure --yes'", SeverityMedium, sudoIssues, SudoIssueLogfile)},
+		{ID: "sudo.nopasswd", Title: "Ensure users must provide password for privilege escalation", Weight: 15, Severity: SeverityHigh, Tags: []string{"sudo", "cis"}, Benchmark: "CIS 5.3.4", Level: 2, Run: issueCheck("Remove NOPASSWD from rules granting ALL; keep it only for narrow, fixed commands", SeverityHigh, sudoIssues, SudoIssueNoPasswdAll, SudoIssueNoPasswd)},
+		{ID: "sudo.authenticate", Title: "Ensure re-authentication for privilege escalation is not disabled globally", Weight: 15, Severity: SeverityHigh, Tags: []string{"sudo", "cis"}, Benchmark: "CIS 5.3.5", Level: 1, Run: issueCheck("Remove '!authenticate' and '!env_reset' from the sudo Defaults", SeverityLow, sudoIssues, SudoIssueAuthenticate, SudoIssueEnvReset)},
+		{ID: "sudo.timestamp_timeout", Title: "Ensure sudo authentication timeout is configured correctly", Weight: 3, Severity: SeverityLow, Tags: []string{"sudo", "cis"}, Benchmark: "CIS 5.3.6", Level: 1, Run: issueCheck("Set 'Defaults timestamp_timeout=15' or lower", SeverityMedium, sudoIssues, SudoIssueTimeout)},
+		{ID: "sudo.rules", Title: "Ensure sudo rules do not grant more than the listed commands", Weight: 10, Severity: SeverityHigh, Tags: []string{"sudo"}, Run: issueCheck("Replace wildcards and '!cmd' exclusions with explicit command lines, use sudoedit instead of editors and fix the listed syntax errors", SeverityHigh, sudoIssues, SudoIssueWildcard, SudoIssueNegation, SudoIssueShellEscape, SudoIssueSyntax)},
+		{ID: "sudo.file_permissions", Title: "Ensure sudoers files and the commands they grant are writable only by root", Weight: 20, Severity: SeverityCritical, Tags: []string{"sudo", "permissions"}, Run: issueCheck("chown root:root and chmod 0440 the sudoers files (0750 for directories) and make the granted commands root-owned and not group or world writable", SeverityLow, sudoIssues, SudoIssueWritable)},
+		{ID: "files.world_writable", Title: "Ensure no world writable files exist", Weight: 10, Severity: SeverityMedium, Tags: []string{"files", "cis"}, Benchmark: "CIS 6.1.9", Level: 1, Run: checkWorldWritableFiles},
+		{ID: "files.unowned", Title: "Ensure no unowned or ungrouped files or directories exist", Weight: 5, Severity: SeverityMedium, Tags: []string{"files", "cis"}, Benchmark: "CIS 6.1.10", Level: 1, Run: checkUnownedFiles},
+	}
+}
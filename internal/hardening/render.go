@@ -6,6 +6,7 @@ import (
 	"errors"
 	"html/template"
 	"io"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,19 +14,65 @@ import (
 type OutputFormat string
 
 const (
-	FormatJSON OutputFormat = "json"
-	FormatYAML OutputFormat = "yaml"
-	FormatHTML OutputFormat = "html"
+	FormatJSON     OutputFormat = "json"
+	FormatYAML     OutputFormat = "yaml"
+	FormatHTML     OutputFormat = "html"
+	FormatSARIF    OutputFormat = "sarif"
+	FormatJUnit    OutputFormat = "junit"
+	FormatCSV      OutputFormat = "csv"
+	FormatMarkdown OutputFormat = "markdown"
+	FormatPDF      OutputFormat = "pdf"
 )
 
+// Ext is the file extension used for generated report names.
+func (f OutputFormat) Ext() string {
+	switch f {
+	case FormatJUnit:
+		return "xml"
+	case FormatMarkdown:
+		return "md"
+	default:
+		return string(f)
+	}
+}
+
+// DetectFormat accepts a bare format name (json, yaml, html, sarif, junit,
+// csv, md, pdf) or a file path, whose extension selects the format.
 func DetectFormat(output string) OutputFormat {
+	o := strings.ToLower(strings.TrimSpace(output))
+	switch o {
+	case "yaml", "yml":
+		return FormatYAML
+	case "html", "htm":
+		return FormatHTML
+	case "sarif":
+		return FormatSARIF
+	case "junit", "xml":
+		return FormatJUnit
+	case "csv":
+		return FormatCSV
+	case "md", "markdown":
+		return FormatMarkdown
+	case "pdf":
+		return FormatPDF
+	}
 	// if output is a file path, infer by extension
 	switch {
-	case hasSuffix(output, ".yaml") || hasSuffix(output, ".yml"):
+	case hasSuffix(o, ".yaml") || hasSuffix(o, ".yml"):
 		return FormatYAML
-	case hasSuffix(output, ".html") || hasSuffix(output, ".htm"):
+	case hasSuffix(o, ".html") || hasSuffix(o, ".htm"):
 		return FormatHTML
-	case hasSuffix(output, ".json"):
+	case hasSuffix(o, ".sarif") || hasSuffix(o, ".sarif.json"):
+		return FormatSARIF
+	case hasSuffix(o, ".xml"):
+		return FormatJUnit
+	case hasSuffix(o, ".csv"):
+		return FormatCSV
+	case hasSuffix(o, ".md") || hasSuffix(o, ".markdown"):
+		return FormatMarkdown
+	case hasSuffix(o, ".pdf"):
+		return FormatPDF
+	case hasSuffix(o, ".json"):
 		return FormatJSON
 	default:
 		return FormatJSON
@@ -47,6 +94,8 @@ func Render(w io.Writer, rep Report, format OutputFormat) error {
 		return err
 	case FormatHTML:
 		return renderHTML(w, rep)
+	case FormatSARIF, FormatJUnit, FormatCSV, FormatMarkdown, FormatPDF:
+		return renderExport(w, auditExportDoc(rep), format)
 	default:
 		return errors.New("unsupported format")
 	}
@@ -66,18 +115,25 @@ code{background:#f2f2f2;padding:2px 6px;border-radius:4px}
 .fail{background:#ffe8e8;color:#7d1b1b}
 .warn{background:#fff8e0;color:#6b4b00}
 .skip{background:#eef2ff;color:#2a3a7a}
+.waived{background:#f0f0f0;color:#444}
+small{color:#555}
+.trend{margin:8px 0 16px}
 </style>
 </head>
 <body>
 <h1>Fortis Audit Report</h1>
 <p><b>Timestamp:</b> {{ .Timestamp }}</p>
 <p><b>Host:</b> {{ .Hostname }} <b>OS:</b> {{ .Platform }}</p>
+{{ if .Root }}<p><b>Image root:</b> {{ .Root }} (runtime-only checks skipped)</p>{{ end }}
 <p><b>Profile:</b> {{ .Profile }} <b>Level:</b> {{ .Level }}</p>
 <p><b>Score:</b> {{ .Score }}/100 ({{ .ScoreLabel }})</p>
-<p><b>Stats:</b> Passed {{ .Passed }} | Failed {{ .Failed }} | Warnings {{ .Warnings }} | Skipped {{ .Skipped }}</p>
-<hr/>
+<p><b>Stats:</b> Passed {{ .Passed }} | Failed {{ .Failed }} | Warnings {{ .Warnings }} | Skipped {{ .Skipped }} | Waived {{ .Waived }}{{ if .ExpiredWaivers }} | Expired waivers {{ .ExpiredWaivers }}{{ end }}</p>
+{{ with trendChart .History }}<h2>Score trend</h2>
+<div class="trend">{{ . }}</div>
+{{ with lastPoint $.History }}{{ if .Delta }}<p><b>Since last run:</b> score {{ printf "%+d" (deref .Delta) }}{{ if .NewFailures }} | newly failing: {{ range $i, $id := .NewFailures }}{{ if $i }}, {{ end }}<code>{{ $id }}</code>{{ end }}{{ end }}{{ if .Fixed }} | fixed: {{ range $i, $id := .Fixed }}{{ if $i }}, {{ end }}<code>{{ $id }}</code>{{ end }}{{ end }}</p>{{ end }}{{ end }}
+{{ end }}<hr/>
 <table cellpadding="8" cellspacing="0" border="0">
-<thead><tr><th align="left">ID</th><th align="left">Result</th><th align="left">Title</th><th align="left">Recommendation</th></tr></thead>
+<thead><tr><th align="left">ID</th><th align="left">Result</th><th align="left">Severity</th><th align="left">Title</th><th align="left">Recommendation</th></tr></thead>
 <tbody>
 {{ range .Findings }}
 <tr>
@@ -85,7 +141,8 @@ code{background:#f2f2f2;padding:2px 6px;border-radius:4px}
 <td>
 <span class="badge {{ .Result }}">{{ .Result }}</span>
 </td>
-<td>{{ .Title }}</td>
+<td>{{ .Severity }}</td>
+<td>{{ .Title }}{{ with .Waiver }}<br/><small>{{ if .Expired }}Waiver expired {{ .Expires }} (approved by {{ .Approver }}){{ else }}Waived ({{ .Result }}) until {{ .Expires }}, approved by {{ .Approver }}: {{ .Justification }}{{ end }}{{ if .Ticket }} [{{ .Ticket }}]{{ end }}</small>{{ end }}</td>
 <td>{{ .Recommendation }}</td>
 </tr>
 {{ end }}
@@ -94,7 +151,16 @@ code{background:#f2f2f2;padding:2px 6px;border-radius:4px}
 </body>
 </html>`
 
-	t, err := template.New("rep").Parse(tpl)
+	t, err := template.New("rep").Funcs(template.FuncMap{
+		"trendChart": trendChart,
+		"lastPoint": func(ps []HistoryPoint) *HistoryPoint {
+			if len(ps) == 0 {
+				return nil
+			}
+			return &ps[len(ps)-1]
+		},
+		"deref": func(p *int) int { return *p },
+	}).Parse(tpl)
 	if err != nil {
 		return err
 	}
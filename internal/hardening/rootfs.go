@@ -0,0 +1,904 @@
+package hardening
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditRoot is the filesystem file-based checks read. A nil root is the
+// running host; an audit with --root reads a mounted image directory or an
+// image archive instead, where runtime state (/proc, services, commands) is
+// not available.
+type auditRoot struct {
+	// Source is the --root argument as given.
+	Source string
+	fsys   fs.FS
+
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+// hostRoot reads the running system.
+var hostRoot *auditRoot
+
+// runtimeOnly is the detail of checks that need a running system.
+const runtimeOnly = "runtime-only check; not evaluated against an image root"
+
+// maxArchiveFileSize bounds the content kept in memory per archive member.
+// Larger files keep their metadata but cannot be read by content checks.
+const maxArchiveFileSize = 4 << 20
+
+func (r *auditRoot) isImage() bool { return r != nil && r.fsys != nil }
+
+// OpenAuditRoot opens an alternate root: a directory holding a mounted or
+// unpacked filesystem, an OCI image layout directory, or a tar archive
+// (optionally gzip-compressed) holding a root filesystem, a single layer, an
+// OCI image layout or a "docker save" image.
+func OpenAuditRoot(src string) (*auditRoot, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	r := &auditRoot{Source: src}
+	if fi.IsDir() {
+		if isImageLayout(src) {
+			a, err := loadImageLayout(src)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", src, err)
+			}
+			r.fsys = a
+			return r, nil
+		}
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return nil, err
+		}
+		r.fsys = dirFS(abs)
+		return r, nil
+	}
+	a, err := loadImageArchive(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	r.fsys = a
+	return r, nil
+}
+
+func rel(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// absErr reports image paths as absolute paths, like host errors do.
+func absErr(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) && !strings.HasPrefix(pe.Path, "/") {
+		pe.Path = path.Join("/", pe.Path)
+	}
+	return err
+}
+
+func (r *auditRoot) ReadFile(name string) ([]byte, error) {
+	if !r.isImage() {
+		return os.ReadFile(name)
+	}
+	b, err := fs.ReadFile(r.fsys, rel(name))
+	return b, absErr(err)
+}
+
+func (r *auditRoot) Stat(name string) (fs.FileInfo, error) {
+	if !r.isImage() {
+		return os.Stat(name)
+	}
+	fi, err := fs.Stat(r.fsys, rel(name))
+	return fi, absErr(err)
+}
+
+func (r *auditRoot) Glob(pattern string) ([]string, error) {
+	if !r.isImage() {
+		return filepath.Glob(pattern)
+	}
+	matches, err := fs.Glob(r.fsys, rel(pattern))
+	for i := range matches {
+		matches[i] = path.Join("/", matches[i])
+	}
+	return matches, err
+}
+
+// WalkDir walks the tree below dir without following symbolic links.
+func (r *auditRoot) WalkDir(dir string, fn fs.WalkDirFunc) error {
+	if !r.isImage() {
+		return filepath.WalkDir(dir, fn)
+	}
+	return fs.WalkDir(r.fsys, rel(dir), func(p string, d fs.DirEntry, err error) error {
+		return fn(path.Join("/", p), d, absErr(err))
+	})
+}
+
+// Readlink returns the target of a symbolic link. Links in the parent
+// directories of name are resolved inside the root.
+func (r *auditRoot) Readlink(name string) (string, error) {
+	if !r.isImage() {
+		return os.Readlink(name)
+	}
+	rl, ok := r.fsys.(interface{ Readlink(string) (string, error) })
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+	}
+	target, err := rl.Readlink(rel(name))
+	return target, absErr(err)
+}
+
+// lookPath finds an executable in the standard PATH of the root.
+func (r *auditRoot) lookPath(file string) (string, error) {
+	for _, dir := range []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"} {
+		p := path.Join(dir, file)
+		if fi, err := r.Stat(p); err == nil && fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0 {
+			return p, nil
+		}
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+func (r *auditRoot) exists(name string) bool {
+	_, err := r.Stat(name)
+	return err == nil
+}
+
+// userName and groupName resolve IDs against the root's own account
+// databases; the host's name service says nothing about an image.
+func (r *auditRoot) userName(uid uint32) string {
+	if !r.isImage() {
+		return lookupUserName(uid)
+	}
+	if name, ok := r.accounts("/etc/passwd", &r.users)[uid]; ok {
+		return name
+	}
+	if uid == 0 {
+		return "root"
+	}
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+func (r *auditRoot) groupName(gid uint32) string {
+	if !r.isImage() {
+		return lookupGroupName(gid)
+	}
+	if name, ok := r.accounts("/etc/group", &r.groups)[gid]; ok {
+		return name
+	}
+	if gid == 0 {
+		return "root"
+	}
+	return strconv.FormatUint(uint64(gid), 10)
+}
+
+// knownUser and knownGroup report whether an ID has an account entry.
+func (r *auditRoot) knownUser(uid uint32) bool {
+	if !r.isImage() {
+		_, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+		return err == nil
+	}
+	_, ok := r.accounts("/etc/passwd", &r.users)[uid]
+	return ok
+}
+
+func (r *auditRoot) knownGroup(gid uint32) bool {
+	if !r.isImage() {
+		_, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+		return err == nil
+	}
+	_, ok := r.accounts("/etc/group", &r.groups)[gid]
+	return ok
+}
+
+// accounts parses a passwd or group file (name:x:id:...) once.
+func (r *auditRoot) accounts(file string, cache *map[uint32]string) map[uint32]string {
+	if *cache != nil {
+		return *cache
+	}
+	m := map[uint32]string{}
+	if b, err := r.ReadFile(file); err == nil {
+		for _, ln := range strings.Split(string(b), "\n") {
+			f := strings.Split(strings.TrimSpace(ln), ":")
+			if len(f) < 3 || strings.HasPrefix(f[0], "#") {
+				continue
+			}
+			if id, err := strconv.ParseUint(f[2], 10, 32); err == nil {
+				if _, dup := m[uint32(id)]; !dup {
+					m[uint32(id)] = f[0]
+				}
+			}
+		}
+	}
+	*cache = m
+	return m
+}
+
+// imageHostname names the image in reports: its /etc/hostname, or the base
+// name of the root.
+func (r *auditRoot) imageHostname() string {
+	if b, err := r.ReadFile("/etc/hostname"); err == nil {
+		if h := strings.TrimSpace(string(b)); h != "" && h != "localhost" {
+			return h
+		}
+	}
+	return filepath.Base(filepath.Clean(r.Source))
+}
+
+// imagePlatform describes the image's distribution from its os-release.
+func (r *auditRoot) imagePlatform() string {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		b, err := r.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		for _, ln := range strings.Split(string(b), "\n") {
+			if v, ok := strings.CutPrefix(ln, "PRETTY_NAME="); ok {
+				return strings.Trim(strings.TrimSpace(v), `"'`)
+			}
+		}
+	}
+	return "unknown (image)"
+}
+
+// statOwner returns the owner of host files and of archive members.
+func statOwner(fi fs.FileInfo) (uid, gid uint32, ok bool) {
+	if h, isTar := fi.Sys().(*tar.Header); isTar {
+		return uint32(h.Uid), uint32(h.Gid), true
+	}
+	return fileOwner(fi)
+}
+
+const maxSymlinkHops = 40
+
+// resolveIn follows the symbolic links in name the way the kernel does inside
+// a chroot: absolute targets and ".." never leave the root. name and the
+// result are absolute slash paths inside the root.
+func resolveIn(name string, lstat func(string) (fs.FileInfo, error), readlink func(string) (string, error)) (string, error) {
+	parts := strings.Split(path.Clean("/"+name), "/")
+	cur, hops := "/", 0
+	for len(parts) > 0 {
+		p := parts[0]
+		parts = parts[1:]
+		switch p {
+		case "", ".":
+			continue
+		case "..":
+			cur = path.Dir(cur)
+			continue
+		}
+		next := path.Join(cur, p)
+		fi, err := lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", &fs.PathError{Op: "open", Path: name, Err: errors.New("too many levels of symbolic links")}
+		}
+		target, err := readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			cur = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return cur, nil
+}
+
+// dirFS is a directory holding an image's root filesystem. Unlike os.DirFS
+// it resolves symbolic links inside the directory, so a link to /etc/foo in
+// the image does not read the host's /etc/foo.
+type dirFS string
+
+func (d dirFS) host(p string) string { return filepath.Join(string(d), filepath.FromSlash(p)) }
+
+func (d dirFS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := resolveIn(name,
+		func(p string) (fs.FileInfo, error) { return os.Lstat(d.host(p)) },
+		func(p string) (string, error) { return os.Readlink(d.host(p)) })
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: unwrapPathErr(err)}
+	}
+	return d.host(p), nil
+}
+
+func unwrapPathErr(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	p, err := d.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	p, err := d.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (d dirFS) Readlink(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := d.resolve("readlink", path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(filepath.Join(dir, path.Base(name)))
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+// archiveFS is a root filesystem flattened from one or more layer tarballs
+// and held in memory: metadata for every member, content for regular files
+// up to maxArchiveFileSize.
+type archiveFS struct {
+	root  *archiveNode
+	layer int
+}
+
+type archiveNode struct {
+	hdr       *tar.Header
+	data      []byte
+	truncated bool
+	layer     int
+	kids      map[string]*archiveNode
+}
+
+func newArchiveFS() *archiveFS {
+	return &archiveFS{root: &archiveNode{hdr: &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0o755}, kids: map[string]*archiveNode{}}}
+}
+
+func (a *archiveFS) lstat(p string) (*archiveNode, error) {
+	n := a.root
+	for _, part := range strings.Split(strings.TrimPrefix(path.Clean("/"+p), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if n.kids == nil {
+			return nil, &fs.PathError{Op: "lstat", Path: p, Err: fs.ErrNotExist}
+		}
+		next, ok := n.kids[part]
+		if !ok {
+			return nil, &fs.PathError{Op: "lstat", Path: p, Err: fs.ErrNotExist}
+		}
+		n = next
+	}
+	return n, nil
+}
+
+func (a *archiveFS) resolve(op, name string) (*archiveNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := resolveIn(name,
+		func(p string) (fs.FileInfo, error) {
+			n, err := a.lstat(p)
+			if err != nil {
+				return nil, err
+			}
+			return n.hdr.FileInfo(), nil
+		},
+		func(p string) (string, error) {
+			n, err := a.lstat(p)
+			if err != nil {
+				return "", err
+			}
+			return n.hdr.Linkname, nil
+		})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: unwrapPathErr(err)}
+	}
+	return a.lstat(p)
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	n, err := a.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.truncated {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("file too large to read from an image archive")}
+	}
+	return &archiveFile{node: n, r: bytes.NewReader(n.data)}, nil
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := a.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.hdr.FileInfo(), nil
+}
+
+func (a *archiveFS) ReadFile(name string) ([]byte, error) {
+	n, err := a.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.hdr.Typeflag == tar.TypeDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	if n.truncated {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("file too large to read from an image archive")}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+func (a *archiveFS) Readlink(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := a.resolve("readlink", path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	n, ok := dir.kids[path.Base(name)]
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.hdr.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.hdr.Linkname, nil
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := a.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.hdr.Typeflag != tar.TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.entries(), nil
+}
+
+func (n *archiveNode) entries() []fs.DirEntry {
+	out := make([]fs.DirEntry, 0, len(n.kids))
+	for _, k := range n.kids {
+		out = append(out, fs.FileInfoToDirEntry(k.hdr.FileInfo()))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+type archiveFile struct {
+	node *archiveNode
+	r    *bytes.Reader
+	dirs []fs.DirEntry
+	read bool
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.node.hdr.FileInfo(), nil }
+func (f *archiveFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *archiveFile) Close() error               { return nil }
+
+func (f *archiveFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.read {
+		f.dirs, f.read = f.node.entries(), true
+	}
+	if n <= 0 {
+		out := f.dirs
+		f.dirs = nil
+		return out, nil
+	}
+	if len(f.dirs) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.dirs) {
+		n = len(f.dirs)
+	}
+	out := f.dirs[:n]
+	f.dirs = f.dirs[n:]
+	return out, nil
+}
+
+// OCI whiteouts: ".wh.<name>" deletes name from lower layers and
+// ".wh..wh..opq" hides everything a lower layer put in the directory.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// applyLayer adds the members of one layer tarball on top of the layers
+// already applied.
+func (a *archiveFS) applyLayer(r io.Reader) error {
+	a.layer++
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := path.Clean("/" + hdr.Name)
+		if p == "/" {
+			if hdr.Typeflag == tar.TypeDir {
+				h := *hdr
+				h.Name = "/"
+				a.root.hdr = &h
+			}
+			continue
+		}
+		dir, base := path.Split(p)
+		parent := a.mkdirAll(path.Clean(dir))
+		switch {
+		case base == whiteoutOpaque:
+			for k, n := range parent.kids {
+				if n.layer < a.layer {
+					delete(parent.kids, k)
+				}
+			}
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			delete(parent.kids, strings.TrimPrefix(base, whiteoutPrefix))
+			continue
+		}
+
+		h := *hdr
+		h.Name = p
+		n := &archiveNode{hdr: &h, layer: a.layer}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if old, ok := parent.kids[base]; ok && old.kids != nil {
+				// Layers re-list directories to change their metadata;
+				// the contents stay.
+				n.kids = old.kids
+			} else {
+				n.kids = map[string]*archiveNode{}
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if hdr.Size > maxArchiveFileSize {
+				n.truncated = true
+				break
+			}
+			b, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			n.data = b
+		case tar.TypeLink:
+			target, err := a.lstat(path.Clean("/" + hdr.Linkname))
+			if err != nil {
+				return fmt.Errorf("%s: hard link target %s not in image", p, hdr.Linkname)
+			}
+			th := *target.hdr
+			th.Name = p
+			n.hdr, n.data, n.truncated = &th, target.data, target.truncated
+		}
+		parent.kids[base] = n
+	}
+}
+
+// mkdirAll returns the directory node at p, creating missing parents the way
+// a layer implies them.
+func (a *archiveFS) mkdirAll(p string) *archiveNode {
+	n := a.root
+	for _, part := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		next, ok := n.kids[part]
+		if !ok || next.kids == nil {
+			next = &archiveNode{
+				hdr:   &tar.Header{Name: path.Join(n.hdr.Name, part), Typeflag: tar.TypeDir, Mode: 0o755, ModTime: time.Unix(0, 0)},
+				layer: a.layer,
+				kids:  map[string]*archiveNode{},
+			}
+			n.kids[part] = next
+		}
+		n = next
+	}
+	return n
+}
+
+// decompress wraps gzip streams; other layer media types are read as plain
+// tar.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// ociIndex, ociManifest and dockerManifest cover the two image formats: the
+// OCI layout (index.json pointing at manifests in blobs/) and the
+// manifest.json list written by "docker save".
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type dockerManifest struct {
+	Layers []string `json:"Layers"`
+}
+
+func isImageLayout(dir string) bool {
+	for _, name := range []string{"oci-layout", "manifest.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadImageArchive reads a tar file. An image archive (OCI layout or docker
+// save) is unpacked to a temporary directory so its layers can be applied in
+// manifest order; anything else is taken as a single root filesystem layer.
+func loadImageArchive(file string) (*archiveFS, error) {
+	image, err := archiveHasImageLayout(file)
+	if err != nil {
+		return nil, err
+	}
+	if !image {
+		a := newArchiveFS()
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r, err := decompress(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.applyLayer(r); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	tmp, err := os.MkdirTemp("", "fortis-image-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := unpackImageArchive(file, tmp); err != nil {
+		return nil, err
+	}
+	return loadImageLayout(tmp)
+}
+
+func archiveHasImageLayout(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return false, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch path.Clean(hdr.Name) {
+		case "oci-layout", "manifest.json":
+			return true, nil
+		}
+	}
+}
+
+// unpackImageArchive extracts the regular files of an image archive. Member
+// names are confined to dir.
+func unpackImageArchive(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean("/" + hdr.Name)
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// loadImageLayout applies the layers of an unpacked image in order.
+func loadImageLayout(dir string) (*archiveFS, error) {
+	layers, err := imageLayers(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("image has no layers")
+	}
+	a := newArchiveFS()
+	for _, l := range layers {
+		if err := applyLayerFile(a, l); err != nil {
+			return nil, fmt.Errorf("layer %s: %w", filepath.Base(l), err)
+		}
+	}
+	return a, nil
+}
+
+func applyLayerFile(a *archiveFS, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	return a.applyLayer(r)
+}
+
+// imageLayers lists the layer files of the first image in an OCI layout or
+// docker save directory, bottom layer first.
+func imageLayers(dir string) ([]string, error) {
+	inside := func(p string) (string, error) {
+		clean := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p)))
+		if _, err := os.Stat(clean); err != nil {
+			return "", err
+		}
+		return clean, nil
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "index.json")); err == nil {
+		var idx ociIndex
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return nil, fmt.Errorf("index.json: %w", err)
+		}
+		blob := func(digest string) (string, error) {
+			algo, hex, ok := strings.Cut(digest, ":")
+			if !ok {
+				return "", fmt.Errorf("bad digest %q", digest)
+			}
+			return inside(path.Join("blobs", algo, hex))
+		}
+		descs := idx.Manifests
+		// An index may point at another index (multi-platform images);
+		// the first manifest with layers is used.
+		for depth := 0; depth < 4 && len(descs) > 0; depth++ {
+			p, err := blob(descs[0].Digest)
+			if err != nil {
+				return nil, err
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			var m ociManifest
+			if err := json.Unmarshal(b, &m); err != nil {
+				return nil, fmt.Errorf("manifest %s: %w", descs[0].Digest, err)
+			}
+			if len(m.Layers) > 0 {
+				out := []string{}
+				for _, l := range m.Layers {
+					if strings.Contains(l.MediaType, "zstd") {
+						return nil, fmt.Errorf("layer %s: zstd compression is not supported", l.Digest)
+					}
+					p, err := blob(l.Digest)
+					if err != nil {
+						return nil, err
+					}
+					out = append(out, p)
+				}
+				return out, nil
+			}
+			descs = m.Manifests
+		}
+		return nil, errors.New("index.json references no image manifest with layers")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, errors.New("neither index.json nor manifest.json found")
+	}
+	var dm []dockerManifest
+	if err := json.Unmarshal(b, &dm); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if len(dm) == 0 {
+		return nil, errors.New("manifest.json lists no images")
+	}
+	out := []string{}
+	for _, l := range dm[0].Layers {
+		p, err := inside(l)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
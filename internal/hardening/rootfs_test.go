@@ -0,0 +1,275 @@
+package hardening
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// mapRoot is an image root holding files, keyed by absolute path.
+func mapRoot(files map[string]string) *auditRoot {
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[strings.TrimPrefix(name, "/")] = &fstest.MapFile{Data: []byte(data), Mode: 0o644}
+	}
+	return &auditRoot{Source: "test", fsys: fsys}
+}
+
+// layerEntry is one tar member: a directory when name ends in "/", a
+// symbolic link when link is set, a regular file otherwise.
+type layerEntry struct {
+	name, body, link string
+}
+
+func tarLayer(t *testing.T, entries ...layerEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			h.Typeflag, h.Mode, h.Size = tar.TypeDir, 0o755, 0
+		case e.link != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// treeOf lists every path of fsys with its content or link target.
+func treeOf(t *testing.T, fsys fs.FS) []string {
+	t.Helper()
+	var out []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			out = append(out, p+"/")
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := fsys.(interface{ Readlink(string) (string, error) }).Readlink(p)
+			if err != nil {
+				return err
+			}
+			out = append(out, p+" -> "+target)
+		default:
+			b, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			out = append(out, p+"="+string(b))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestApplyLayerWhiteouts(t *testing.T) {
+	base := tarLayer(t,
+		layerEntry{name: "etc/"},
+		layerEntry{name: "etc/passwd", body: "root"},
+		layerEntry{name: "etc/shadow", body: "secret"},
+		layerEntry{name: "etc/ssh/"},
+		layerEntry{name: "etc/ssh/sshd_config", body: "PermitRootLogin yes"},
+		layerEntry{name: "etc/ssh/moduli", body: "m"},
+		layerEntry{name: "opt/app/", body: ""},
+		layerEntry{name: "opt/app/old.conf", body: "old"},
+		layerEntry{name: "var/cache/", body: ""},
+		layerEntry{name: "var/cache/big", body: "b"},
+	)
+	tests := []struct {
+		name   string
+		layers [][]byte
+		want   []string
+	}{
+		{
+			name:   "single layer",
+			layers: [][]byte{base},
+			want: []string{
+				"etc/", "etc/passwd=root", "etc/shadow=secret", "etc/ssh/", "etc/ssh/moduli=m", "etc/ssh/sshd_config=PermitRootLogin yes",
+				"opt/", "opt/app/", "opt/app/old.conf=old", "var/", "var/cache/", "var/cache/big=b",
+			},
+		},
+		{
+			name: "whiteouts, an opaque directory and overwrites",
+			layers: [][]byte{base, tarLayer(t,
+				layerEntry{name: "etc/.wh.shadow"},
+				layerEntry{name: "etc/ssh/sshd_config", body: "PermitRootLogin no"},
+				layerEntry{name: "opt/app/"},
+				layerEntry{name: "opt/app/new.conf", body: "new"},
+				layerEntry{name: "opt/app/.wh..wh..opq"},
+				layerEntry{name: "var/.wh.cache"},
+				layerEntry{name: "etc/.wh.missing"},
+			)},
+			want: []string{
+				"etc/", "etc/passwd=root", "etc/ssh/", "etc/ssh/moduli=m", "etc/ssh/sshd_config=PermitRootLogin no",
+				"opt/", "opt/app/", "opt/app/new.conf=new", "var/",
+			},
+		},
+		{
+			name: "a whited-out path recreated by a later layer",
+			layers: [][]byte{
+				base,
+				tarLayer(t, layerEntry{name: "etc/.wh.ssh"}),
+				tarLayer(t, layerEntry{name: "etc/ssh/sshd_config", body: "Port 2222"}),
+			},
+			want: []string{
+				"etc/", "etc/passwd=root", "etc/shadow=secret", "etc/ssh/", "etc/ssh/sshd_config=Port 2222",
+				"opt/", "opt/app/", "opt/app/old.conf=old", "var/", "var/cache/", "var/cache/big=b",
+			},
+		},
+		{
+			name: "a directory replaced by a symbolic link",
+			layers: [][]byte{base, tarLayer(t,
+				layerEntry{name: "opt/.wh.app"},
+				layerEntry{name: "opt/app", link: "/srv/app"},
+				layerEntry{name: "srv/app/"},
+				layerEntry{name: "srv/app/x", body: "x"},
+			)},
+			want: []string{
+				"etc/", "etc/passwd=root", "etc/shadow=secret", "etc/ssh/", "etc/ssh/moduli=m", "etc/ssh/sshd_config=PermitRootLogin yes",
+				"opt/", "opt/app -> /srv/app", "srv/", "srv/app/", "srv/app/x=x", "var/", "var/cache/", "var/cache/big=b",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newArchiveFS()
+			for _, l := range tt.layers {
+				if err := a.applyLayer(bytes.NewReader(l)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := treeOf(t, a); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tree:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestResolveInConfinesSymlinks(t *testing.T) {
+	layer := tarLayer(t,
+		layerEntry{name: "etc/"},
+		layerEntry{name: "etc/real.conf", body: "image"},
+		layerEntry{name: "etc/abs.conf", link: "/etc/real.conf"},
+		layerEntry{name: "etc/rel.conf", link: "real.conf"},
+		layerEntry{name: "etc/up.conf", link: "../../../../etc/real.conf"},
+		layerEntry{name: "etc/host-passwd", link: "/../../../etc/passwd"},
+		layerEntry{name: "etc/loop1", link: "loop2"},
+		layerEntry{name: "etc/loop2", link: "loop1"},
+		layerEntry{name: "etc/alt", link: "/etc"},
+		layerEntry{name: "etc/dangling", link: "/nonexistent"},
+	)
+	archive := newArchiveFS()
+	if err := archive.applyLayer(bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(layer))
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		p := filepath.Join(dir, filepath.FromSlash(h.Name))
+		switch h.Typeflag {
+		case tar.TypeReg:
+			b := new(bytes.Buffer)
+			_, _ = b.ReadFrom(tr)
+			err = os.WriteFile(p, b.Bytes(), 0o644)
+		case tar.TypeSymlink:
+			err = os.Symlink(h.Linkname, p)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string // content, or the error after "!"
+	}{
+		{"etc/real.conf", "image"},
+		{"etc/abs.conf", "image"},
+		{"etc/rel.conf", "image"},
+		{"etc/up.conf", "image"},
+		{"etc/alt/alt/rel.conf", "image"},
+		{"etc/host-passwd", "!" + fs.ErrNotExist.Error()},
+		{"etc/dangling", "!" + fs.ErrNotExist.Error()},
+		{"etc/loop1", "!too many levels of symbolic links"},
+		{"etc/../etc/real.conf", "!" + fs.ErrInvalid.Error()},
+	}
+	for name, fsys := range map[string]fs.FS{"archive": archive, "dir": dirFS(dir)} {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				b, err := fs.ReadFile(fsys, tt.name)
+				got := string(b)
+				var pe *fs.PathError
+				switch {
+				case err == nil:
+				case !errors.As(err, &pe):
+					t.Errorf("%s: error %v is not a PathError", tt.name, err)
+				case errors.Is(err, fs.ErrNotExist):
+					got = "!" + fs.ErrNotExist.Error()
+				default:
+					got = "!" + pe.Err.Error()
+				}
+				if got != tt.want {
+					t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// resolveIn on its own: ".." at the root stays at the root.
+	lstat := func(p string) (fs.FileInfo, error) {
+		n, err := archive.lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		return n.hdr.FileInfo(), nil
+	}
+	readlink := func(p string) (string, error) {
+		n, err := archive.lstat(p)
+		if err != nil {
+			return "", err
+		}
+		return n.hdr.Linkname, nil
+	}
+	for in, want := range map[string]string{
+		"/../../etc":           "/etc",
+		"etc/alt/abs.conf":     "/etc/real.conf",
+		"/etc/up.conf":         "/etc/real.conf",
+		"/etc/./alt/../../etc": "/etc",
+	} {
+		if got, err := resolveIn(in, lstat, readlink); err != nil || got != want {
+			t.Errorf("resolveIn(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
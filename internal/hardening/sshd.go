@@ -0,0 +1,516 @@
+package hardening
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSHDSetting is one effective sshd option and where it came from. File and
+// Line are empty for compiled-in defaults.
+type SSHDSetting struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Default bool   `json:"default,omitempty"`
+}
+
+func (s SSHDSetting) Origin() string {
+	if s.Default {
+		return "compiled-in default"
+	}
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// SSHDMatchBlock holds the options set inside one Match block. They only
+// apply to connections matching Criteria.
+type SSHDMatchBlock struct {
+	Criteria string                 `json:"criteria"`
+	File     string                 `json:"file"`
+	Line     int                    `json:"line"`
+	Settings map[string]SSHDSetting `json:"settings"`
+}
+
+// SSHDConfig is the result of evaluating sshd_config the way sshd does:
+// Include directives are followed in place (globs in lexical order), the
+// first value obtained for a keyword wins, and keywords never set fall back
+// to the compiled-in default.
+type SSHDConfig struct {
+	Path     string                 `json:"path"`
+	Files    []string               `json:"files"`
+	Settings map[string]SSHDSetting `json:"settings"`
+	Matches  []SSHDMatchBlock       `json:"matches,omitempty"`
+	// Shadowed lists later definitions that lost to an earlier one.
+	Shadowed []SSHDSetting `json:"shadowed,omitempty"`
+}
+
+// sshdDefaults are the compiled-in defaults of OpenSSH 9.x for the options
+// fortis audits. Keys are lower case, as printed by "sshd -T".
+var sshdDefaults = map[string]string{
+	"port":                         "22",
+	"addressfamily":                "any",
+	"permitrootlogin":              "prohibit-password",
+	"pubkeyauthentication":         "yes",
+	"passwordauthentication":       "yes",
+	"kbdinteractiveauthentication": "yes",
+	"permitemptypasswords":         "no",
+	"hostbasedauthentication":      "no",
+	"ignorerhosts":                 "yes",
+	"x11forwarding":                "no",
+	"allowtcpforwarding":           "yes",
+	"allowagentforwarding":         "yes",
+	"allowstreamlocalforwarding":   "yes",
+	"permituserenvironment":        "no",
+	"permittunnel":                 "no",
+	"gatewayports":                 "no",
+	"disableforwarding":            "no",
+	"loglevel":                     "INFO",
+	"syslogfacility":               "AUTH",
+	"maxauthtries":                 "6",
+	"maxsessions":                  "10",
+	"maxstartups":                  "10:30:100",
+	"logingracetime":               "120",
+	"clientaliveinterval":          "0",
+	"clientalivecountmax":          "3",
+	"tcpkeepalive":                 "yes",
+	"usepam":                       "no",
+	"usedns":                       "no",
+	"strictmodes":                  "yes",
+	"printmotd":                    "yes",
+	"printlastlog":                 "yes",
+	"banner":                       "none",
+	"compression":                  "yes",
+	"gssapiauthentication":         "no",
+	"kerberosauthentication":       "no",
+	"authorizedkeysfile":           ".ssh/authorized_keys .ssh/authorized_keys2",
+	"fingerprinthash":              "SHA256",
+	"ciphers":                      "chacha20-poly1305@openssh.com,aes128-ctr,aes192-ctr,aes256-ctr,aes128-gcm@openssh.com,aes256-gcm@openssh.com",
+	"macs":                         "umac-64-etm@openssh.com,umac-128-etm@openssh.com,hmac-sha2-256-etm@openssh.com,hmac-sha2-512-etm@openssh.com,hmac-sha1-etm@openssh.com,umac-64@openssh.com,umac-128@openssh.com,hmac-sha2-256,hmac-sha2-512,hmac-sha1",
+	"kexalgorithms":                "sntrup761x25519-sha512@openssh.com,curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,ecdh-sha2-nistp384,ecdh-sha2-nistp521,diffie-hellman-group-exchange-sha256,diffie-hellman-group16-sha512,diffie-hellman-group18-sha512,diffie-hellman-group14-sha256",
+	"permitopen":                   "any",
+	"exposeauthinfo":               "no",
+}
+
+// sshdAliases maps deprecated keywords to the name sshd -T reports.
+var sshdAliases = map[string]string{
+	"challengeresponseauthentication": "kbdinteractiveauthentication",
+	"pubkeyacceptedkeytypes":          "pubkeyacceptedalgorithms",
+	"hostbasedacceptedkeytypes":       "hostbasedacceptedalgorithms",
+	"dsaauthentication":               "pubkeyauthentication",
+}
+
+// sshdMultiValue keywords accumulate across lines instead of first-wins.
+var sshdMultiValue = map[string]bool{
+	"port": true, "listenaddress": true, "hostkey": true, "hostcertificate": true,
+	"acceptenv": true, "allowusers": true, "denyusers": true, "allowgroups": true,
+	"denygroups": true, "subsystem": true, "setenv": true,
+}
+
+// sshdTimeKeys take sshd time formats (e.g. "1m30s"); sshd -T prints seconds.
+var sshdTimeKeys = map[string]bool{"logingracetime": true, "clientaliveinterval": true}
+
+func sshdKey(k string) string {
+	k = strings.ToLower(k)
+	if a, ok := sshdAliases[k]; ok {
+		return a
+	}
+	return k
+}
+
+// ParseSSHDConfig evaluates the sshd configuration rooted at path. Relative
+// Include patterns are resolved against the directory of path, like sshd
+// resolves them against /etc/ssh.
+func ParseSSHDConfig(path string) (*SSHDConfig, error) {
+	return parseSSHDConfig(hostRoot, path)
+}
+
+// parseSSHDConfig reads path and its Includes from root.
+func parseSSHDConfig(root *auditRoot, path string) (*SSHDConfig, error) {
+	cfg := &SSHDConfig{Path: path, Settings: map[string]SSHDSetting{}}
+	p := &sshdParser{cfg: cfg, root: root, baseDir: filepath.Dir(path), seen: map[string]bool{}}
+	if err := p.parseFile(path, nil, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+type sshdParser struct {
+	cfg     *SSHDConfig
+	root    *auditRoot
+	baseDir string
+	seen    map[string]bool
+}
+
+const maxSSHDIncludeDepth = 16
+
+// parseFile reads one file. match is the enclosing Match block (nil for
+// global scope); a Match started inside an included file ends with it.
+func (p *sshdParser) parseFile(path string, match *SSHDMatchBlock, depth int) error {
+	if depth > maxSSHDIncludeDepth {
+		return fmt.Errorf("%s: Include nested too deeply", path)
+	}
+	if p.seen[path] {
+		return fmt.Errorf("%s: Include loop", path)
+	}
+	p.seen[path] = true
+	defer delete(p.seen, path)
+
+	b, err := p.root.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p.cfg.Files = append(p.cfg.Files, path)
+
+	cur := match
+	s := bufio.NewScanner(bytes.NewReader(b))
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		k, v := splitConfigLine(ln)
+		v = unquoteSSHDValue(v)
+		key := sshdKey(k)
+		switch key {
+		case "include":
+			for _, pat := range strings.Fields(v) {
+				if !filepath.IsAbs(pat) {
+					pat = filepath.Join(p.baseDir, pat)
+				}
+				files, err := p.root.Glob(pat)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+				}
+				sort.Strings(files)
+				for _, f := range files {
+					if err := p.parseFile(f, cur, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		case "match":
+			if strings.EqualFold(strings.TrimSpace(v), "all") {
+				cur = match
+				continue
+			}
+			p.cfg.Matches = append(p.cfg.Matches, SSHDMatchBlock{Criteria: v, File: path, Line: lineNo, Settings: map[string]SSHDSetting{}})
+			cur = &p.cfg.Matches[len(p.cfg.Matches)-1]
+			continue
+		}
+		st := SSHDSetting{Key: key, Value: v, File: path, Line: lineNo}
+		target := p.cfg.Settings
+		if cur != nil {
+			target = cur.Settings
+		}
+		if old, ok := target[key]; ok {
+			if sshdMultiValue[key] {
+				old.Value += " " + v
+				target[key] = old
+			} else if cur == nil {
+				p.cfg.Shadowed = append(p.cfg.Shadowed, st)
+			}
+			continue
+		}
+		target[key] = st
+	}
+	return s.Err()
+}
+
+func unquoteSSHDValue(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// Get returns the global effective value of key: the first definition in
+// file order, or the compiled-in default.
+func (c *SSHDConfig) Get(key string) (SSHDSetting, bool) {
+	key = sshdKey(key)
+	if s, ok := c.Settings[key]; ok {
+		return s, true
+	}
+	if d, ok := sshdDefaults[key]; ok {
+		return SSHDSetting{Key: key, Value: d, Default: true}, true
+	}
+	return SSHDSetting{}, false
+}
+
+// MatchOverrides lists the Match blocks that set key.
+func (c *SSHDConfig) MatchOverrides(key string) []SSHDMatchBlock {
+	key = sshdKey(key)
+	out := []SSHDMatchBlock{}
+	for _, m := range c.Matches {
+		if _, ok := m.Settings[key]; ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Effective returns every explicitly set global option plus the compiled-in
+// defaults, sorted by key.
+func (c *SSHDConfig) Effective() []SSHDSetting {
+	out := []SSHDSetting{}
+	for k := range sshdDefaults {
+		if _, ok := c.Settings[k]; !ok {
+			out = append(out, SSHDSetting{Key: k, Value: sshdDefaults[k], Default: true})
+		}
+	}
+	for _, s := range c.Settings {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// SSHDDiff is an option whose parsed value disagrees with "sshd -T".
+type SSHDDiff struct {
+	Key    string `json:"key"`
+	Parsed string `json:"parsed"`
+	SSHD   string `json:"sshd"`
+	Origin string `json:"origin"`
+}
+
+// ValidateWithSSHD compares the parsed configuration with the output of
+// "sshd -T". Options sshd does not print, and multi-value options, are not
+// compared.
+func (c *SSHDConfig) ValidateWithSSHD(ctx context.Context) ([]SSHDDiff, error) {
+	bin, err := exec.LookPath("sshd")
+	if err != nil {
+		return nil, errors.New("sshd binary not found")
+	}
+	out, err := exec.CommandContext(ctx, bin, "-T", "-f", c.Path).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("sshd -T: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	running := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(s.Text()), " ", 2)
+		if len(fields) == 2 && !sshdMultiValue[fields[0]] {
+			running[fields[0]] = fields[1]
+		}
+	}
+	diffs := []SSHDDiff{}
+	for _, st := range c.Effective() {
+		got, ok := running[st.Key]
+		if !ok || sshdMultiValue[st.Key] {
+			continue
+		}
+		if normalizeSSHDValue(st.Key, st.Value) != normalizeSSHDValue(st.Key, got) {
+			diffs = append(diffs, SSHDDiff{Key: st.Key, Parsed: st.Value, SSHD: got, Origin: st.Origin()})
+		}
+	}
+	return diffs, nil
+}
+
+func normalizeSSHDValue(key, v string) string {
+	v = strings.ToLower(strings.Join(strings.Fields(v), " "))
+	if sshdTimeKeys[key] {
+		if n, err := sshdSeconds(v); err == nil {
+			return strconv.FormatInt(n, 10)
+		}
+	}
+	if key == "permitrootlogin" && v == "without-password" {
+		return "prohibit-password"
+	}
+	return v
+}
+
+// sshdSeconds parses sshd time formats: "90", "90s", "1m30s", "1h".
+func sshdSeconds(v string) (int64, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	var total, num int64
+	digits := false
+	for _, r := range v {
+		switch {
+		case r >= '0' && r <= '9':
+			num = num*10 + int64(r-'0')
+			digits = true
+			continue
+		case !digits:
+			return 0, fmt.Errorf("invalid time %q", v)
+		case r == 's':
+			total += num
+		case r == 'm':
+			total += num * 60
+		case r == 'h':
+			total += num * 3600
+		case r == 'd':
+			total += num * 86400
+		case r == 'w':
+			total += num * 604800
+		default:
+			return 0, fmt.Errorf("invalid time %q", v)
+		}
+		num, digits = 0, false
+	}
+	if digits {
+		total += num
+	}
+	return total, nil
+}
+
+// sshdMatchValue is the value a Match block gives an option.
+type sshdMatchValue struct {
+	Criteria string
+	Origin   string
+	Value    string
+}
+
+// sshdOptionValue resolves an option for audits: the effective global value
+// with its origin, falling back to def and then the compiled-in default, and
+// the values Match blocks override it with. Time values are returned in
+// seconds.
+func sshdOptionValue(root *auditRoot, path, key, def string) (value, origin string, matches []sshdMatchValue, found bool, err error) {
+	cfg, err := parseSSHDConfig(root, path)
+	if err != nil {
+		return "", "", nil, false, err
+	}
+	if st, ok := cfg.Settings[sshdKey(key)]; ok {
+		origin = st.Origin()
+		value, found = st.Value, true
+	} else if def != "" {
+		value, origin, found = def, "not set, default", true
+	} else if st, ok := cfg.Get(key); ok {
+		value, origin, found = st.Value, st.Origin(), true
+	}
+	seconds := func(v string) string {
+		if sshdTimeKeys[sshdKey(key)] {
+			if n, err := sshdSeconds(v); err == nil {
+				return strconv.FormatInt(n, 10)
+			}
+		}
+		return v
+	}
+	if found {
+		value = seconds(value)
+	}
+	for _, m := range cfg.MatchOverrides(key) {
+		mv := sshdMatchValue{Criteria: m.Criteria, Origin: fmt.Sprintf("%s:%d", m.File, m.Line), Value: seconds(m.Settings[sshdKey(key)].Value)}
+		matches = append(matches, mv)
+		origin += fmt.Sprintf("; Match %s (%s) sets %s", mv.Criteria, mv.Origin, mv.Value)
+	}
+	return value, origin, matches, found, nil
+}
+
+// setSSHDConfigKey makes key=value the effective global setting. The file
+// and line that currently win are edited in place; when the option is not
+// set anywhere it is inserted into the main file ahead of any Match block.
+// The result is checked with "sshd -t" and the edit is undone if it fails.
+// Outside a transaction, which has already snapshotted the file, a copy is
+// kept as <file>.bak.<unix time>. Callers reload sshd afterwards.
+func setSSHDConfigKey(ctx context.Context, tx *Transaction, path, key, value string) error {
+	target, line, err := sshdSettingLocation(path, key)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	backup := ""
+	if tx == nil {
+		backup = fmt.Sprintf("%s.bak.%d", target, time.Now().Unix())
+		if err := os.WriteFile(backup, b, 0o600); err != nil {
+			return err
+		}
+	} else {
+		backup = tx.Dir()
+	}
+
+	lines := strings.Split(string(b), "\n")
+	entry := fmt.Sprintf("%s %s", key, value)
+	if line > 0 && line <= len(lines) {
+		lines[line-1] = entry
+	} else {
+		at := len(lines)
+		for i, ln := range lines {
+			k, _ := splitConfigLine(strings.TrimSpace(ln))
+			if strings.EqualFold(k, "match") {
+				at = i
+				break
+			}
+		}
+		if at == len(lines) && at > 0 && lines[at-1] == "" {
+			// keep the trailing newline at the end of the file
+			at--
+		}
+		lines = append(lines[:at], append([]string{entry}, lines[at:]...)...)
+	}
+
+	out := strings.Join(lines, "\n")
+	if err := os.WriteFile(target, []byte(out), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := validateSSHDConfig(ctx, path); err != nil {
+		if rerr := os.WriteFile(target, b, info.Mode().Perm()); rerr != nil {
+			return fmt.Errorf("%v; restoring %s failed: %v (backup at %s)", err, target, rerr, backup)
+		}
+		return fmt.Errorf("%s: change reverted: %w", target, err)
+	}
+	return nil
+}
+
+// sshdSettingLocation returns the file and line that set key globally, or
+// path and 0 when it is not set anywhere.
+func sshdSettingLocation(path, key string) (string, int, error) {
+	cfg, err := ParseSSHDConfig(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if st, ok := cfg.Settings[sshdKey(key)]; ok {
+		return st.File, st.Line, nil
+	}
+	return path, 0, nil
+}
+
+// validateSSHDConfig runs "sshd -t". A missing sshd binary is not an error:
+// there is nothing to reload either.
+func validateSSHDConfig(ctx context.Context, path string) error {
+	bin, err := exec.LookPath("sshd")
+	if err != nil {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, bin, "-t", "-f", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sshd -t failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reloadSSHD reloads the SSH service under either of its usual unit names.
+func reloadSSHD(ctx context.Context) error {
+	var lastErr error
+	for _, unit := range []string{"ssh", "sshd"} {
+		out, err := exec.CommandContext(ctx, "systemctl", "reload", unit).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("systemctl reload %s: %v: %s", unit, err, strings.TrimSpace(string(out)))
+	}
+	return lastErr
+}
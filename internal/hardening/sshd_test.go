@@ -0,0 +1,186 @@
+package hardening
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseSSHDConfig(t *testing.T) {
+	type match struct {
+		criteria, key, value string
+	}
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    map[string]string
+		origin  map[string]string
+		matches []match
+		wantErr string
+	}{
+		{
+			name:   "first value wins",
+			files:  map[string]string{"/etc/ssh/sshd_config": "PermitRootLogin no\nPermitRootLogin yes\n"},
+			want:   map[string]string{"permitrootlogin": "no"},
+			origin: map[string]string{"permitrootlogin": "/etc/ssh/sshd_config:1"},
+		},
+		{
+			name: "include ahead of main file wins",
+			files: map[string]string{
+				"/etc/ssh/sshd_config":             "Include sshd_config.d/*.conf\nPasswordAuthentication yes\n",
+				"/etc/ssh/sshd_config.d/50-a.conf": "# drop-in\nPasswordAuthentication no\n",
+			},
+			want:   map[string]string{"passwordauthentication": "no"},
+			origin: map[string]string{"passwordauthentication": "/etc/ssh/sshd_config.d/50-a.conf:2"},
+		},
+		{
+			name: "include glob in lexical order",
+			files: map[string]string{
+				"/etc/ssh/sshd_config":             "Include /etc/ssh/sshd_config.d/*.conf\n",
+				"/etc/ssh/sshd_config.d/20-b.conf": "X11Forwarding yes\n",
+				"/etc/ssh/sshd_config.d/10-a.conf": "X11Forwarding no\n",
+			},
+			want:   map[string]string{"x11forwarding": "no"},
+			origin: map[string]string{"x11forwarding": "/etc/ssh/sshd_config.d/10-a.conf:1"},
+		},
+		{
+			name:    "match block does not change the global value",
+			files:   map[string]string{"/etc/ssh/sshd_config": "PasswordAuthentication no\nMatch User bob\n  PasswordAuthentication yes\n"},
+			want:    map[string]string{"passwordauthentication": "no"},
+			matches: []match{{"User bob", "passwordauthentication", "yes"}},
+		},
+		{
+			name:    "match all returns to global scope",
+			files:   map[string]string{"/etc/ssh/sshd_config": "Match User bob\nX11Forwarding yes\nMatch all\nX11Forwarding no\n"},
+			want:    map[string]string{"x11forwarding": "no"},
+			matches: []match{{"User bob", "x11forwarding", "yes"}},
+		},
+		{
+			name: "match in an included file ends with the file",
+			files: map[string]string{
+				"/etc/ssh/sshd_config":          "Include sshd_config.d/a.conf\nAllowTcpForwarding no\n",
+				"/etc/ssh/sshd_config.d/a.conf": "Match Group sftp\nAllowTcpForwarding yes\n",
+			},
+			want:    map[string]string{"allowtcpforwarding": "no"},
+			matches: []match{{"Group sftp", "allowtcpforwarding", "yes"}},
+		},
+		{
+			name: "include inside a match block stays in the block",
+			files: map[string]string{
+				"/etc/ssh/sshd_config":          "Match User bob\nInclude sshd_config.d/a.conf\n",
+				"/etc/ssh/sshd_config.d/a.conf": "PasswordAuthentication no\n",
+			},
+			want:    map[string]string{"passwordauthentication": "yes"},
+			origin:  map[string]string{"passwordauthentication": "compiled-in default"},
+			matches: []match{{"User bob", "passwordauthentication", "no"}},
+		},
+		{
+			name:  "multi-value keywords accumulate",
+			files: map[string]string{"/etc/ssh/sshd_config": "Port 22\nPort 2222\n"},
+			want:  map[string]string{"port": "22 2222"},
+		},
+		{
+			name:  "deprecated alias and quoted value",
+			files: map[string]string{"/etc/ssh/sshd_config": "ChallengeResponseAuthentication no\nBanner \"/etc/issue.net\"\n"},
+			want:  map[string]string{"kbdinteractiveauthentication": "no", "banner": "/etc/issue.net"},
+		},
+		{
+			name:  "key=value syntax",
+			files: map[string]string{"/etc/ssh/sshd_config": "MaxAuthTries=3\n"},
+			want:  map[string]string{"maxauthtries": "3"},
+		},
+		{
+			name: "include loop",
+			files: map[string]string{
+				"/etc/ssh/sshd_config":          "Include sshd_config.d/a.conf\n",
+				"/etc/ssh/sshd_config.d/a.conf": "Include /etc/ssh/sshd_config\n",
+			},
+			wantErr: "Include loop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseSSHDConfig(mapRoot(tt.files), "/etc/ssh/sshd_config")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.want {
+				st, ok := cfg.Get(k)
+				if !ok || st.Value != want {
+					t.Errorf("%s = %q, want %q", k, st.Value, want)
+				}
+				if o, ok := tt.origin[k]; ok && st.Origin() != o {
+					t.Errorf("%s origin = %s, want %s", k, st.Origin(), o)
+				}
+			}
+			if len(cfg.Matches) != len(tt.matches) {
+				t.Fatalf("%d match blocks, want %d", len(cfg.Matches), len(tt.matches))
+			}
+			for i, m := range tt.matches {
+				got := cfg.Matches[i]
+				if got.Criteria != m.criteria || got.Settings[m.key].Value != m.value {
+					t.Errorf("match %d = %s %s=%q, want %s %s=%q", i, got.Criteria, m.key, got.Settings[m.key].Value, m.criteria, m.key, m.value)
+				}
+			}
+		})
+	}
+}
+
+func TestSSHChecksJudgeMatchOverrides(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   Result
+		detail string
+	}{
+		{
+			name:   "global no",
+			config: "PermitRootLogin no\nPasswordAuthentication no\n",
+			want:   ResultPass,
+		},
+		{
+			name:   "match block also sets no",
+			config: "PermitRootLogin no\nPasswordAuthentication no\nMatch User backup\n  PermitRootLogin no\n  PasswordAuthentication no\n",
+			want:   ResultPass,
+			detail: "Match User backup",
+		},
+		{
+			name:   "match block turns it back on",
+			config: "PermitRootLogin no\nPasswordAuthentication no\nMatch Address *\n  PermitRootLogin yes\n  PasswordAuthentication yes\n",
+			want:   ResultFail,
+			detail: "Match Address * (/etc/ssh/sshd_config:3) sets yes",
+		},
+		{
+			name:   "global yes",
+			config: "PermitRootLogin yes\nPasswordAuthentication yes\n",
+			want:   ResultFail,
+		},
+	}
+	checks := map[string]func(context.Context, AuditOptions) (Finding, error){
+		"PermitRootLogin":        checkSSHRootLogin,
+		"PasswordAuthentication": checkSSHPasswordAuth,
+	}
+	for _, tt := range tests {
+		for key, check := range checks {
+			t.Run(tt.name+"/"+key, func(t *testing.T) {
+				opts := AuditOptions{root: mapRoot(map[string]string{"/etc/ssh/sshd_config": tt.config})}
+				f, err := check(context.Background(), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Result != tt.want {
+					t.Errorf("result = %s, want %s (%s)", f.Result, tt.want, f.Details)
+				}
+				if !strings.Contains(f.Details, tt.detail) {
+					t.Errorf("details %q do not mention %q", f.Details, tt.detail)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,869 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SudoersPath is the main sudoers file.
+const SudoersPath = "/etc/sudoers"
+
+// sudoersDropIn is where --sudo-secure writes its Defaults.
+const sudoersDropIn = "/etc/sudoers.d/50-fortis"
+
+// Sudoers audit issue kinds, the Check field of SudoIssue.
+const (
+	SudoIssueNoPasswdAll  = "nopasswd_all"
+	SudoIssueNoPasswd     = "nopasswd"
+	SudoIssueWildcard     = "wildcard"
+	SudoIssueNegation     = "negation"
+	SudoIssueShellEscape  = "shell_escape"
+	SudoIssueWritable     = "writable"
+	SudoIssueUsePTY       = "use_pty"
+	SudoIssueLogfile      = "logfile"
+	SudoIssueAuthenticate = "authenticate"
+	SudoIssueEnvReset     = "env_reset"
+	SudoIssueTimeout      = "timestamp_timeout"
+	SudoIssueSyntax       = "syntax"
+)
+
+// SudoersFile is one file read while following includes.
+type SudoersFile struct {
+	Path  string `json:"path"`
+	Mode  string `json:"mode"`
+	Owner string `json:"owner"`
+}
+
+// SudoDefault is one parameter of a Defaults line. Binding is "" for
+// global defaults, or the sigil and list: "@host", ":user", "!cmnd",
+// ">runas".
+type SudoDefault struct {
+	Binding string `json:"binding,omitempty"`
+	Name    string `json:"name"`
+	Op      string `json:"op,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Negated bool   `json:"negated,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+}
+
+// SudoRule is one command of a user specification, with the runas list
+// and tags in effect for it. Aliases are not expanded.
+type SudoRule struct {
+	Users       []string `json:"users"`
+	Hosts       []string `json:"hosts"`
+	RunAsUsers  []string `json:"runas_users,omitempty"`
+	RunAsGroups []string `json:"runas_groups,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Command     string   `json:"command"`
+	File        string   `json:"file"`
+	Line        int      `json:"line"`
+}
+
+func (r SudoRule) source() string { return fmt.Sprintf("%s:%d", r.File, r.Line) }
+
+func (r SudoRule) hasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SudoersConfig is a parsed sudoers tree. Aliases maps the alias kind
+// (User_Alias, Runas_Alias, Host_Alias, Cmnd_Alias) to name and members.
+type SudoersConfig struct {
+	Path     string                         `json:"path"`
+	Files    []SudoersFile                  `json:"files"`
+	Aliases  map[string]map[string][]string `json:"aliases"`
+	Defaults []SudoDefault                  `json:"defaults"`
+	Rules    []SudoRule                     `json:"rules"`
+	Errors   []string                       `json:"errors,omitempty"`
+
+	dirs  []string
+	stats map[string]fs.FileInfo
+}
+
+// SudoPrivilege is one row of the privilege matrix: who can run what, as
+// whom and where, with aliases expanded. Denied rows are "!cmd" entries
+// that subtract from an earlier grant.
+type SudoPrivilege struct {
+	Principal  string   `json:"principal"`
+	Except     []string `json:"except,omitempty"`
+	Hosts      string   `json:"hosts"`
+	RunAs      string   `json:"runas"`
+	Command    string   `json:"command"`
+	Denied     bool     `json:"denied,omitempty"`
+	NoPassword bool     `json:"nopasswd,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Source     string   `json:"source"`
+}
+
+type SudoIssue struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Subject  string   `json:"subject"`
+	Detail   string   `json:"detail"`
+}
+
+type SudoersAudit struct {
+	Path       string          `json:"path"`
+	Files      []SudoersFile   `json:"files"`
+	Defaults   []SudoDefault   `json:"defaults"`
+	Privileges []SudoPrivilege `json:"privileges"`
+	Issues     []SudoIssue     `json:"issues"`
+}
+
+var sudoAliasKinds = map[string]string{
+	"User_Alias": "User_Alias", "Runas_Alias": "Runas_Alias", "Host_Alias": "Host_Alias",
+	"Cmnd_Alias": "Cmnd_Alias", "Cmd_Alias": "Cmnd_Alias",
+}
+
+// sudoTags are the command tags; each NO form clears its base tag.
+var sudoTags = map[string]bool{
+	"PASSWD": true, "NOPASSWD": true, "EXEC": true, "NOEXEC": true, "SETENV": true, "NOSETENV": true,
+	"LOG_INPUT": true, "NOLOG_INPUT": true, "LOG_OUTPUT": true, "NOLOG_OUTPUT": true,
+	"MAIL": true, "NOMAIL": true, "FOLLOW": true, "NOFOLLOW": true, "INTERCEPT": true, "NOINTERCEPT": true,
+}
+
+var (
+	sudoAliasNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	sudoOptionRe    = regexp.MustCompile(`^(CWD|CHROOT|TIMEOUT|NOTBEFORE|NOTAFTER|ROLE|TYPE|APPARMOR_PROFILE|PRIVS|LIMITPRIVS)=("[^"]*"|\S+)\s*`)
+	sudoTagRe       = regexp.MustCompile(`^([A-Z_]+):\s*`)
+	sudoDigestRe    = regexp.MustCompile(`^(sha224|sha256|sha384|sha512):\S+\s+`)
+	sudoAliasDefRe  = regexp.MustCompile(`^\s*[A-Z][A-Z0-9_]*\s*=`)
+	sudoHostSpecRe  = regexp.MustCompile(`^\s*[^\s=:,()]+(\s*,\s*[^\s=:,()]+)*\s*=`)
+	sudoListSpaceRe = regexp.MustCompile(`\s*,\s*`)
+)
+
+// ParseSudoers reads a sudoers file and everything it includes.
+func ParseSudoers(p string) (*SudoersConfig, error) {
+	return parseSudoers(hostRoot, p)
+}
+
+func parseSudoers(root *auditRoot, p string) (*SudoersConfig, error) {
+	if p == "" {
+		p = SudoersPath
+	}
+	host, _ := os.Hostname()
+	if root.isImage() {
+		host = root.imageHostname()
+	}
+	sp := &sudoersParser{
+		root: root,
+		host: host,
+		cfg:  &SudoersConfig{Path: p, Aliases: map[string]map[string][]string{}, Defaults: []SudoDefault{}, Rules: []SudoRule{}, stats: map[string]fs.FileInfo{}},
+		seen: map[string]bool{},
+	}
+	if err := sp.parseFile(p, 0); err != nil {
+		return nil, err
+	}
+	return sp.cfg, nil
+}
+
+type sudoersParser struct {
+	root *auditRoot
+	host string
+	cfg  *SudoersConfig
+	seen map[string]bool
+}
+
+// sudoersMaxDepth matches sudo's limit on nested includes.
+const sudoersMaxDepth = 128
+
+func (p *sudoersParser) parseFile(file string, depth int) error {
+	if depth > sudoersMaxDepth {
+		return fmt.Errorf("%s: includes nested too deeply", file)
+	}
+	if p.seen[file] {
+		return nil
+	}
+	p.seen[file] = true
+	b, err := p.root.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	sf := SudoersFile{Path: file}
+	if fi, err := p.root.Stat(file); err == nil {
+		p.cfg.stats[file] = fi
+		sf.Mode = fmt.Sprintf("%04o", fi.Mode().Perm())
+		if uid, gid, ok := statOwner(fi); ok {
+			sf.Owner = p.root.userName(uid) + ":" + p.root.groupName(gid)
+		}
+	}
+	p.cfg.Files = append(p.cfg.Files, sf)
+
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		ln := strings.TrimRight(lines[i], "\r")
+		for strings.HasSuffix(ln, "\\") && i+1 < len(lines) {
+			i++
+			ln = strings.TrimSuffix(ln, "\\") + " " + strings.TrimRight(lines[i], "\r")
+		}
+		trimmed := strings.TrimSpace(ln)
+		if dir, arg, ok := sudoInclude(trimmed); ok {
+			if err := p.include(file, dir, arg, depth); err != nil {
+				p.errorf(file, lineNo, "%v", err)
+			}
+			continue
+		}
+		trimmed = strings.TrimSpace(stripSudoersComment(trimmed))
+		if trimmed == "" {
+			continue
+		}
+		word := strings.Fields(trimmed)[0]
+		switch {
+		case strings.HasPrefix(word, "Defaults"):
+			p.parseDefaults(trimmed, file, lineNo)
+		case sudoAliasKinds[word] != "":
+			p.parseAlias(sudoAliasKinds[word], strings.TrimSpace(trimmed[len(word):]), file, lineNo)
+		default:
+			p.parseUserSpec(trimmed, file, lineNo)
+		}
+	}
+	return nil
+}
+
+func (p *sudoersParser) errorf(file string, line int, format string, args ...any) {
+	p.cfg.Errors = append(p.cfg.Errors, fmt.Sprintf("%s:%d: %s", file, line, fmt.Sprintf(format, args...)))
+}
+
+// sudoInclude recognizes #include, #includedir and their @ forms.
+func sudoInclude(ln string) (dir bool, arg string, ok bool) {
+	for _, prefix := range []string{"#includedir", "@includedir", "#include", "@include"} {
+		if rest, found := strings.CutPrefix(ln, prefix); found && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return strings.HasSuffix(prefix, "dir"), strings.Trim(strings.TrimSpace(rest), `"`), true
+		}
+	}
+	return false, "", false
+}
+
+// include follows an include directive. Relative paths are relative to
+// the including file and %h is the host name. includedir reads the
+// files of a directory in lexical order, skipping names that end in "~"
+// or contain a ".", as sudo does.
+func (p *sudoersParser) include(from string, dir bool, arg string, depth int) error {
+	arg = strings.ReplaceAll(arg, "%h", p.host)
+	if !path.IsAbs(arg) {
+		arg = path.Join(path.Dir(from), arg)
+	}
+	if !dir {
+		return p.parseFile(arg, depth+1)
+	}
+	p.cfg.dirs = append(p.cfg.dirs, arg)
+	if fi, err := p.root.Stat(arg); err == nil {
+		p.cfg.stats[arg] = fi
+	}
+	matches, err := p.root.Glob(path.Join(arg, "*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for _, m := range matches {
+		base := path.Base(m)
+		if strings.HasSuffix(base, "~") || strings.Contains(base, ".") {
+			continue
+		}
+		if fi, err := p.root.Stat(m); err != nil || fi.IsDir() {
+			continue
+		}
+		if err := p.parseFile(m, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripSudoersComment cuts a line at an unquoted "#" that does not start
+// a "#uid" user or group ID, including one that starts the line.
+func stripSudoersComment(ln string) string {
+	inQuote := false
+	for i := 0; i < len(ln); i++ {
+		switch c := ln[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case c == '#' && !inQuote:
+			if i+1 < len(ln) && ln[i+1] >= '0' && ln[i+1] <= '9' && (i == 0 || strings.ContainsRune(" \t,(:!%=", rune(ln[i-1]))) {
+				continue
+			}
+			return ln[:i]
+		}
+	}
+	return ln
+}
+
+// splitSudoTop splits s at sep outside quotes and parentheses; a
+// backslash escapes the next character.
+func splitSudoTop(s string, sep byte) []string {
+	var out []string
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(out, strings.TrimSpace(s[start:]))
+}
+
+func (p *sudoersParser) parseDefaults(ln, file string, lineNo int) {
+	word := strings.Fields(ln)[0]
+	binding := strings.TrimPrefix(word, "Defaults")
+	if binding != "" && !strings.ContainsAny(binding[:1], "@:!>") {
+		p.errorf(file, lineNo, "invalid Defaults binding %q", word)
+		return
+	}
+	for _, param := range splitSudoTop(strings.TrimSpace(ln[len(word):]), ',') {
+		if param == "" {
+			continue
+		}
+		d := SudoDefault{Binding: binding, File: file, Line: lineNo}
+		for strings.HasPrefix(param, "!") {
+			d.Negated = !d.Negated
+			param = strings.TrimSpace(param[1:])
+		}
+		for _, op := range []string{"+=", "-=", "="} {
+			if name, value, ok := strings.Cut(param, op); ok {
+				d.Op, param = op, name
+				d.Value = strings.Trim(strings.TrimSpace(value), `"`)
+				break
+			}
+		}
+		d.Name = strings.TrimSpace(param)
+		p.cfg.Defaults = append(p.cfg.Defaults, d)
+	}
+}
+
+// parseAlias handles "NAME = a, b : NAME2 = c".
+func (p *sudoersParser) parseAlias(kind, rest, file string, lineNo int) {
+	for _, def := range splitSudoSections(rest, false) {
+		name, members, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !sudoAliasNameRe.MatchString(name) || name == "ALL" {
+			p.errorf(file, lineNo, "invalid %s %q", kind, def)
+			continue
+		}
+		if p.cfg.Aliases[kind] == nil {
+			p.cfg.Aliases[kind] = map[string][]string{}
+		}
+		p.cfg.Aliases[kind][name] = splitSudoTop(members, ',')
+	}
+}
+
+// splitSudoSections splits at ":" separators that start another
+// "NAME =" (aliases) or "host list =" (user specs). Colons inside
+// runas lists, after tags and in digests are not separators.
+func splitSudoSections(s string, userSpec bool) []string {
+	var out []string
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ':' && depth == 0:
+			prev := s[start:i]
+			if k := strings.LastIndexAny(prev, " \t,"); k >= 0 {
+				prev = prev[k+1:]
+			}
+			if sudoTags[prev] || sudoDigestRe.MatchString(prev+":x ") {
+				continue
+			}
+			rest := s[i+1:]
+			if userSpec && !sudoHostSpecRe.MatchString(rest) {
+				continue
+			}
+			if !userSpec && !sudoAliasDefRe.MatchString(rest) {
+				continue
+			}
+			out = append(out, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(out, strings.TrimSpace(s[start:]))
+}
+
+// parseUserSpec handles "users hosts = [(runas)] [TAG:] cmnd, ... [: hosts = ...]".
+func (p *sudoersParser) parseUserSpec(ln, file string, lineNo int) {
+	eq := strings.IndexByte(ln, '=')
+	if eq < 0 {
+		p.errorf(file, lineNo, "cannot parse %q", ln)
+		return
+	}
+	head := strings.Fields(sudoListSpaceRe.ReplaceAllString(ln[:eq], ","))
+	if len(head) != 2 {
+		p.errorf(file, lineNo, "cannot parse %q", ln)
+		return
+	}
+	users := splitSudoTop(head[0], ',')
+	sections := splitSudoSections(head[1]+"="+ln[eq+1:], true)
+	for _, sec := range sections {
+		hostList, cmnds, ok := strings.Cut(sec, "=")
+		if !ok {
+			p.errorf(file, lineNo, "cannot parse %q", sec)
+			continue
+		}
+		hosts := splitSudoTop(strings.TrimSpace(hostList), ',')
+		p.parseCmndList(users, hosts, cmnds, file, lineNo)
+	}
+}
+
+func (p *sudoersParser) parseCmndList(users, hosts []string, cmnds, file string, lineNo int) {
+	var runUsers, runGroups []string
+	tags := map[string]bool{}
+	var order []string
+	for _, item := range splitSudoTop(cmnds, ',') {
+		if strings.HasPrefix(item, "(") {
+			end := strings.IndexByte(item, ')')
+			if end < 0 {
+				p.errorf(file, lineNo, "unterminated runas list in %q", item)
+				return
+			}
+			u, g, _ := strings.Cut(item[1:end], ":")
+			runUsers, runGroups = nil, nil
+			if strings.TrimSpace(u) != "" {
+				runUsers = splitSudoTop(u, ',')
+			}
+			if strings.TrimSpace(g) != "" {
+				runGroups = splitSudoTop(g, ',')
+			}
+			item = strings.TrimSpace(item[end+1:])
+		}
+		for {
+			if m := sudoOptionRe.FindString(item); m != "" {
+				item = item[len(m):]
+				continue
+			}
+			if m := sudoTagRe.FindStringSubmatch(item); m != nil && sudoTags[m[1]] {
+				base := strings.TrimPrefix(m[1], "NO")
+				if _, ok := tags[base]; !ok {
+					order = append(order, base)
+				}
+				tags[base] = !strings.HasPrefix(m[1], "NO")
+				item = item[len(m[0]):]
+				continue
+			}
+			if m := sudoDigestRe.FindString(item); m != "" {
+				item = item[len(m):]
+				continue
+			}
+			break
+		}
+		if item == "" {
+			p.errorf(file, lineNo, "empty command")
+			continue
+		}
+		r := SudoRule{Users: users, Hosts: hosts, RunAsUsers: runUsers, RunAsGroups: runGroups, Command: item, File: file, Line: lineNo}
+		if runUsers == nil && runGroups == nil {
+			r.RunAsUsers = []string{"root"}
+		}
+		for _, base := range order {
+			if tags[base] {
+				// PASSWD, EXEC and NOSETENV are the defaults and not shown.
+				if base != "PASSWD" && base != "EXEC" {
+					r.Tags = append(r.Tags, base)
+				}
+			} else if base == "PASSWD" || base == "EXEC" {
+				r.Tags = append(r.Tags, "NO"+base)
+			}
+		}
+		p.cfg.Rules = append(p.cfg.Rules, r)
+	}
+}
+
+// expand resolves aliases of a kind in a list. Negated members keep a
+// "!" prefix; negating a negation cancels it.
+func (c *SudoersConfig) expand(kind string, items []string) []string {
+	var out []string
+	var walk func(items []string, neg bool, depth int)
+	walk = func(items []string, neg bool, depth int) {
+		for _, it := range items {
+			n := neg
+			for strings.HasPrefix(it, "!") {
+				n = !n
+				it = strings.TrimSpace(it[1:])
+			}
+			if members, ok := c.Aliases[kind][it]; ok && depth < sudoersMaxDepth {
+				walk(members, n, depth+1)
+				continue
+			}
+			if n {
+				it = "!" + it
+			}
+			out = append(out, it)
+		}
+	}
+	walk(items, false, 0)
+	return out
+}
+
+// Matrix expands aliases into one row per principal and command.
+func (c *SudoersConfig) Matrix() []SudoPrivilege {
+	out := []SudoPrivilege{}
+	for _, r := range c.Rules {
+		var principals, except []string
+		for _, u := range c.expand("User_Alias", r.Users) {
+			if strings.HasPrefix(u, "!") {
+				except = append(except, u[1:])
+			} else {
+				principals = append(principals, u)
+			}
+		}
+		runAs := strings.Join(c.expand("Runas_Alias", r.RunAsUsers), ",")
+		if len(r.RunAsGroups) > 0 {
+			runAs += ":" + strings.Join(c.expand("Runas_Alias", r.RunAsGroups), ",")
+		}
+		hosts := strings.Join(c.expand("Host_Alias", r.Hosts), ",")
+		for _, cmd := range c.expand("Cmnd_Alias", []string{r.Command}) {
+			denied := strings.HasPrefix(cmd, "!")
+			for _, who := range principals {
+				out = append(out, SudoPrivilege{
+					Principal:  who,
+					Except:     except,
+					Hosts:      hosts,
+					RunAs:      runAs,
+					Command:    strings.TrimPrefix(cmd, "!"),
+					Denied:     denied,
+					NoPassword: r.hasTag("NOPASSWD"),
+					Tags:       r.Tags,
+					Source:     r.source(),
+				})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Principal < out[j].Principal })
+	return out
+}
+
+// globalDefault returns the last global setting of a Defaults flag or
+// parameter, which is the one in effect.
+func (c *SudoersConfig) globalDefault(name string) (SudoDefault, bool) {
+	var d SudoDefault
+	found := false
+	for _, x := range c.Defaults {
+		if x.Binding == "" && x.Name == name {
+			d, found = x, true
+		}
+	}
+	return d, found
+}
+
+// sudoShellEscapes can run a shell or write arbitrary files when allowed
+// with any arguments. Editors and pagers escape even with fixed ones.
+var sudoShellEscapes = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "csh": true, "tcsh": true, "fish": true,
+	"su": true, "env": true, "find": true, "awk": true, "gawk": true, "mawk": true, "nawk": true, "sed": true,
+	"perl": true, "python": true, "python2": true, "python3": true, "ruby": true, "lua": true, "node": true, "php": true,
+	"tar": true, "zip": true, "rsync": true, "git": true, "cp": true, "mv": true, "tee": true, "dd": true,
+	"chmod": true, "chown": true, "docker": true, "podman": true, "ssh": true, "scp": true, "script": true,
+	"apt": true, "apt-get": true, "yum": true, "dnf": true, "rpm": true, "dpkg": true, "pip": true, "pip3": true,
+	"nmap": true, "systemctl": true, "journalctl": true, "crontab": true, "mount": true,
+}
+
+var sudoEditorsPagers = map[string]bool{
+	"vi": true, "vim": true, "view": true, "nvim": true, "nano": true, "emacs": true, "ed": true,
+	"less": true, "more": true, "most": true, "man": true, "pg": true,
+}
+
+// SudoersAuditOptions selects the sudoers tree to audit.
+type SudoersAuditOptions struct {
+	// File is the main sudoers file; defaults to SudoersPath.
+	File string
+	// Root audits an image root or tarball instead of the host.
+	Root string
+
+	root *auditRoot
+}
+
+// AuditSudoers parses the sudoers tree and reports risky rules, missing
+// Defaults and files or commands others can modify.
+func AuditSudoers(ctx context.Context, opts SudoersAuditOptions) (SudoersAudit, error) {
+	if opts.root == nil && opts.Root != "" {
+		root, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return SudoersAudit{}, err
+		}
+		opts.root = root
+	}
+	root := opts.root
+	cfg, err := parseSudoers(root, opts.File)
+	if err != nil {
+		return SudoersAudit{}, err
+	}
+	audit := SudoersAudit{Path: cfg.Path, Files: cfg.Files, Defaults: cfg.Defaults, Privileges: cfg.Matrix(), Issues: []SudoIssue{}}
+	add := func(check string, sev Severity, subject, format string, args ...any) {
+		audit.Issues = append(audit.Issues, SudoIssue{Check: check, Severity: sev, Subject: subject, Detail: fmt.Sprintf(format, args...)})
+	}
+	for _, e := range cfg.Errors {
+		// Errors are "file:line: message".
+		if i := strings.Index(e, ": "); i > 0 {
+			add(SudoIssueSyntax, SeverityLow, e[:i], "%s", e[i+2:])
+		} else {
+			add(SudoIssueSyntax, SeverityLow, cfg.Path, "%s", e)
+		}
+	}
+
+	// Files and include directories: sudo only refuses world-writable
+	// files, but anyone who can write one can grant themselves root.
+	paths := append([]string{}, cfg.dirs...)
+	for _, f := range cfg.Files {
+		paths = append(paths, f.Path)
+	}
+	for _, p := range paths {
+		fi, ok := cfg.stats[p]
+		if !ok {
+			continue
+		}
+		if uid, _, ok := statOwner(fi); ok && uid != 0 {
+			add(SudoIssueWritable, SeverityCritical, p, "owned by %s, not root", root.userName(uid))
+		}
+		if perm := fi.Mode().Perm(); perm&0o022 != 0 {
+			add(SudoIssueWritable, SeverityCritical, p, "mode %04o is writable by group or others", perm)
+		}
+	}
+
+	// Per-rule checks run on the expanded commands.
+	checkedCmds := map[string]bool{}
+	for _, r := range cfg.Rules {
+		who := strings.Join(r.Users, ",")
+		for _, cmd := range cfg.expand("Cmnd_Alias", []string{r.Command}) {
+			src := r.source()
+			if strings.HasPrefix(cmd, "!") {
+				add(SudoIssueNegation, SeverityMedium, src, "%s: denying %s is easily bypassed (copy or rename the binary); grant an explicit list instead", who, cmd[1:])
+				continue
+			}
+			fields := strings.Fields(cmd)
+			bin := fields[0]
+			switch {
+			case r.hasTag("NOPASSWD") && bin == "ALL":
+				add(SudoIssueNoPasswdAll, SeverityCritical, src, "%s can run any command without a password", who)
+			case r.hasTag("NOPASSWD"):
+				add(SudoIssueNoPasswd, SeverityMedium, src, "%s can run %s without a password", who, cmd)
+			}
+			if bin == "ALL" || bin == "sudoedit" {
+				continue
+			}
+			if strings.ContainsAny(cmd, "*?[") {
+				add(SudoIssueWildcard, SeverityHigh, src, "%s: wildcard in %q matches more than intended (extra arguments, other paths)", who, cmd)
+			}
+			name := path.Base(bin)
+			if i := strings.IndexAny(name, "0123456789."); i > 0 && sudoShellEscapes[name[:i]] {
+				name = name[:i]
+			}
+			anyArgs := len(fields) == 1 || strings.Contains(cmd, "*")
+			if !r.hasTag("NOEXEC") && (sudoEditorsPagers[name] || anyArgs && sudoShellEscapes[name]) {
+				add(SudoIssueShellEscape, SeverityHigh, src, "%s: %s can spawn a root shell; use sudoedit or fixed arguments with NOEXEC", who, cmd)
+			}
+			if checkedCmds[bin] || !path.IsAbs(bin) {
+				continue
+			}
+			checkedCmds[bin] = true
+			if fi, err := root.Stat(bin); err == nil {
+				if uid, _, ok := statOwner(fi); ok && uid != 0 {
+					add(SudoIssueWritable, SeverityHigh, bin, "command run through sudo is owned by %s", root.userName(uid))
+				} else if fi.Mode().Perm()&0o022 != 0 {
+					add(SudoIssueWritable, SeverityHigh, bin, "command run through sudo has mode %04o", fi.Mode().Perm())
+				}
+			}
+		}
+	}
+
+	if d, ok := cfg.globalDefault("use_pty"); !ok || d.Negated {
+		add(SudoIssueUsePTY, SeverityMedium, cfg.Path, "Defaults use_pty is not set; commands can keep running after sudo exits")
+	}
+	if d, ok := cfg.globalDefault("logfile"); !ok || d.Negated || d.Value == "" {
+		add(SudoIssueLogfile, SeverityLow, cfg.Path, "Defaults logfile is not set")
+	}
+	for _, d := range cfg.Defaults {
+		where := fmt.Sprintf("%s:%d", d.File, d.Line)
+		scope := "globally"
+		if d.Binding != "" {
+			scope = "for Defaults" + d.Binding
+		}
+		switch {
+		case d.Name == "authenticate" && d.Negated:
+			add(SudoIssueAuthenticate, SeverityHigh, where, "!authenticate disables password prompts %s", scope)
+		case d.Name == "env_reset" && d.Negated:
+			add(SudoIssueEnvReset, SeverityHigh, where, "!env_reset passes the caller's environment to commands %s", scope)
+		case d.Name == "timestamp_timeout" && d.Binding == "":
+			if n, err := strconv.Atoi(d.Value); err == nil && (n < 0 || n > 15) {
+				add(SudoIssueTimeout, SeverityLow, where, "timestamp_timeout=%s; use 15 minutes or less", d.Value)
+			}
+		}
+	}
+	return audit, nil
+}
+
+// sudoScan runs the sudoers audit once for all sudo.* checks of a run.
+type sudoScan struct {
+	once sync.Once
+	rep  SudoersAudit
+	err  error
+}
+
+func (s *sudoScan) get(ctx context.Context, opts AuditOptions) (SudoersAudit, error) {
+	run := func() (SudoersAudit, error) {
+		return AuditSudoers(ctx, SudoersAuditOptions{root: opts.root})
+	}
+	if s == nil {
+		return run()
+	}
+	s.once.Do(func() { s.rep, s.err = run() })
+	return s.rep, s.err
+}
+
+// sudoIssues loads the sudoers audit for the sudo.* checks.
+func sudoIssues(ctx context.Context, opts AuditOptions) ([]SudoIssue, string, error) {
+	if !opts.root.exists(SudoersPath) {
+		return nil, "sudo is not installed (no " + SudoersPath + ")", nil
+	}
+	audit, err := opts.sudoers.get(ctx, opts)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, err.Error(), nil
+		}
+		return nil, "", err
+	}
+	return audit.Issues, "", nil
+}
+
+// auditIssue is the shape shared by UserIssue, SudoIssue and PAMIssue.
+type auditIssue struct {
+	Check    string
+	Severity Severity
+	Subject  string
+	Detail   string
+}
+
+// issueCheck fails when the issues returned by load include any of the
+// given kinds. Issues below warnBelow only warn. load skips the check by
+// returning a reason instead of issues.
+func issueCheck[I UserIssue | SudoIssue | PAMIssue](recommendation string, warnBelow Severity, load func(context.Context, AuditOptions) ([]I, string, error), kinds ...string) checkFunc {
+	return func(ctx context.Context, opts AuditOptions) (Finding, error) {
+		f := Finding{}
+		if runtime.GOOS != "linux" && !opts.root.isImage() {
+			f.Result = ResultSkip
+			f.Details = "not supported on this OS"
+			return f, nil
+		}
+		issues, skip, err := load(ctx, opts)
+		if err != nil {
+			return f, err
+		}
+		if skip != "" {
+			f.Result = ResultSkip
+			f.Details = skip
+			return f, nil
+		}
+		var found []string
+		worst := SeverityLow
+		for _, k := range kinds {
+			for _, i := range issues {
+				is := auditIssue(i)
+				if is.Check != k {
+					continue
+				}
+				found = append(found, is.Subject+": "+is.Detail)
+				if severityRank(is.Severity) > severityRank(worst) {
+					worst = is.Severity
+				}
+			}
+		}
+		return issueFinding(f, found, worst, warnBelow, recommendation), nil
+	}
+}
+
+// issueFinding fails a check with the listed problems, or warns when
+// the worst of them is below warnBelow.
+func issueFinding(f Finding, found []string, worst, warnBelow Severity, recommendation string) Finding {
+	if len(found) == 0 {
+		f.Result = ResultPass
+		return f
+	}
+	f.Result = ResultFail
+	if severityRank(worst) < severityRank(warnBelow) {
+		f.Result = ResultWarn
+	}
+	f.Details = truncateList(found, maxListedPaths)
+	f.Recommendation = recommendation
+	return f
+}
+
+// sudoersSecureDefaults are the Defaults --sudo-secure adds when the
+// sudoers tree does not set them.
+var sudoersSecureDefaults = []struct{ name, line string }{
+	{"use_pty", "Defaults use_pty"},
+	{"logfile", `Defaults logfile="/var/log/sudo.log"`},
+}
+
+// secureSudoersPlan returns the Defaults lines missing from the host's
+// sudoers tree. sudo not being installed is not an error: there is
+// nothing to secure.
+func secureSudoersPlan() ([]string, error) {
+	if _, err := os.Stat(SudoersPath); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	cfg, err := ParseSudoers(SudoersPath)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, d := range sudoersSecureDefaults {
+		if cur, ok := cfg.globalDefault(d.name); !ok || cur.Negated {
+			lines = append(lines, d.line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	for _, dir := range cfg.dirs {
+		if dir == path.Dir(sudoersDropIn) {
+			return lines, nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not include %s; add the Defaults by hand: %s", SudoersPath, path.Dir(sudoersDropIn), strings.Join(lines, "; "))
+}
+
+// writeSudoersDropIn writes lines to the fortis drop-in and has visudo
+// check the whole tree, so a bad file fails the transaction and is
+// reverted before sudo reads it.
+func writeSudoersDropIn(ctx context.Context, lines []string) error {
+	body := "# Managed by fortis harden users --sudo-secure\n" + strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(sudoersDropIn, []byte(body), 0o440); err != nil {
+		return err
+	}
+	if err := runCmd(ctx, nil, "visudo", "-c", "-q", "-f", SudoersPath); err != nil {
+		return fmt.Errorf("visudo rejected %s: %w", sudoersDropIn, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package hardening
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSudoersIncludes(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantFiles []string
+		wantCmds  []string
+		wantErrs  int
+	}{
+		{
+			name: "include relative to the including file",
+			files: map[string]string{
+				"/etc/sudoers":       "root ALL = (ALL) ALL\n#include sudoers.local\n",
+				"/etc/sudoers.local": "alice ALL = /bin/ls\n",
+			},
+			wantFiles: []string{"/etc/sudoers", "/etc/sudoers.local"},
+			wantCmds:  []string{"ALL", "/bin/ls"},
+		},
+		{
+			name: "includedir skips names with a dot or trailing tilde",
+			files: map[string]string{
+				"/etc/sudoers":              "@includedir /etc/sudoers.d\n",
+				"/etc/sudoers.d/20-ops":     "%ops ALL = /bin/systemctl\n",
+				"/etc/sudoers.d/10-web":     "www ALL = /bin/true\n",
+				"/etc/sudoers.d/README.txt": "bob ALL = ALL\n",
+				"/etc/sudoers.d/10-web~":    "bob ALL = ALL\n",
+			},
+			wantFiles: []string{"/etc/sudoers", "/etc/sudoers.d/10-web", "/etc/sudoers.d/20-ops"},
+			wantCmds:  []string{"/bin/true", "/bin/systemctl"},
+		},
+		{
+			name: "%h expands to the host name",
+			files: map[string]string{
+				"/etc/hostname":     "web1\n",
+				"/etc/sudoers":      "#include /etc/sudoers.%h\n",
+				"/etc/sudoers.web1": "alice ALL = /bin/df\n",
+			},
+			wantFiles: []string{"/etc/sudoers", "/etc/sudoers.web1"},
+			wantCmds:  []string{"/bin/df"},
+		},
+		{
+			name: "comments and #uid are not includes",
+			files: map[string]string{
+				"/etc/sudoers": "# includes follow\n#1000 ALL = /bin/ls # trailing comment\n",
+			},
+			wantFiles: []string{"/etc/sudoers"},
+			wantCmds:  []string{"/bin/ls"},
+		},
+		{
+			name: "missing include is reported, not fatal",
+			files: map[string]string{
+				"/etc/sudoers": "#include /etc/sudoers.missing\nroot ALL = ALL\n",
+			},
+			wantFiles: []string{"/etc/sudoers"},
+			wantCmds:  []string{"ALL"},
+			wantErrs:  1,
+		},
+		{
+			name: "include cycle is read once",
+			files: map[string]string{
+				"/etc/sudoers":   "#include /etc/sudoers.a\n",
+				"/etc/sudoers.a": "#include /etc/sudoers\nalice ALL = /bin/id\n",
+			},
+			wantFiles: []string{"/etc/sudoers", "/etc/sudoers.a"},
+			wantCmds:  []string{"/bin/id"},
+		},
+		{
+			name: "line continuation",
+			files: map[string]string{
+				"/etc/sudoers": "alice ALL = /bin/ls, \\\n  /bin/cat\n",
+			},
+			wantFiles: []string{"/etc/sudoers"},
+			wantCmds:  []string{"/bin/ls", "/bin/cat"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseSudoers(mapRoot(tt.files), "/etc/sudoers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files, cmds []string
+			for _, f := range cfg.Files {
+				files = append(files, f.Path)
+			}
+			for _, r := range cfg.Rules {
+				cmds = append(cmds, r.Command)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+			if !reflect.DeepEqual(cmds, tt.wantCmds) {
+				t.Errorf("commands = %v, want %v", cmds, tt.wantCmds)
+			}
+			if len(cfg.Errors) != tt.wantErrs {
+				t.Errorf("errors = %v, want %d", cfg.Errors, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestSudoersMatrix(t *testing.T) {
+	// Rows are "principal hosts runas command" with "!" for denied
+	// commands, "-except" for excluded users and "+NOPASSWD" when set.
+	row := func(p SudoPrivilege) string {
+		s := fmt.Sprintf("%s %s %s ", p.Principal, p.Hosts, p.RunAs)
+		if p.Denied {
+			s += "!"
+		}
+		s += p.Command
+		for _, e := range p.Except {
+			s += " -" + e
+		}
+		if p.NoPassword {
+			s += " +NOPASSWD"
+		}
+		return s
+	}
+	tests := []struct {
+		name    string
+		sudoers string
+		want    []string
+	}{
+		{
+			name: "user and command aliases",
+			sudoers: "User_Alias ADMINS = alice, %wheel\n" +
+				"Cmnd_Alias SHELLS = /bin/sh, /bin/bash\n" +
+				"ADMINS ALL = (ALL) NOPASSWD: SHELLS\n",
+			want: []string{
+				"%wheel ALL ALL /bin/sh +NOPASSWD",
+				"%wheel ALL ALL /bin/bash +NOPASSWD",
+				"alice ALL ALL /bin/sh +NOPASSWD",
+				"alice ALL ALL /bin/bash +NOPASSWD",
+			},
+		},
+		{
+			name: "nested aliases and several on one line",
+			sudoers: "Cmnd_Alias VIEW = /bin/cat, LIST : LIST = /bin/ls\n" +
+				"Host_Alias WEB = web1, web2\n" +
+				"bob WEB = VIEW\n",
+			want: []string{"bob web1,web2 root /bin/cat", "bob web1,web2 root /bin/ls"},
+		},
+		{
+			name: "negated user and command",
+			sudoers: "User_Alias STAFF = ALL, !mallory\n" +
+				"STAFF ALL = /usr/bin/*, !/usr/bin/su\n",
+			want: []string{"ALL ALL root /usr/bin/* -mallory", "ALL ALL root !/usr/bin/su -mallory"},
+		},
+		{
+			name: "runas alias with group",
+			sudoers: "Runas_Alias OPS = root, www\n" +
+				"carol ALL = (OPS : adm) /usr/bin/tail\n",
+			want: []string{"carol ALL root,www:adm /usr/bin/tail"},
+		},
+		{
+			name:    "host sections and tags carried across commands",
+			sudoers: "dave web1 = NOPASSWD: /bin/ls, /bin/df : db1 = PASSWD: /bin/cat\n",
+			want:    []string{"dave web1 root /bin/ls +NOPASSWD", "dave web1 root /bin/df +NOPASSWD", "dave db1 root /bin/cat"},
+		},
+		{
+			name:    "digest is not a section separator",
+			sudoers: "erin ALL = sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef /usr/bin/uptime\n",
+			want:    []string{"erin ALL root /usr/bin/uptime"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseSudoers(mapRoot(map[string]string{"/etc/sudoers": tt.sudoers}), "/etc/sudoers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Errors) > 0 {
+				t.Fatalf("parse errors: %v", cfg.Errors)
+			}
+			var got []string
+			for _, p := range cfg.Matrix() {
+				got = append(got, row(p))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matrix:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+		})
+	}
+}
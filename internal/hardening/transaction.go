@@ -0,0 +1,639 @@
+package hardening
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRollbackDir holds one directory per hardening transaction.
+const DefaultRollbackDir = "/var/lib/fortis/rollback"
+
+type TxStatus string
+
+const (
+	TxOpen       TxStatus = "open"
+	TxCommitted  TxStatus = "committed"
+	TxReverted   TxStatus = "reverted"
+	TxRolledBack TxStatus = "rolled-back"
+)
+
+// FileSnapshot is the state of a file before the transaction touched it.
+// Files that did not exist are removed again on rollback.
+type FileSnapshot struct {
+	Path     string `json:"path"`
+	Existed  bool   `json:"existed"`
+	Mode     uint32 `json:"mode,omitempty"`
+	UID      int    `json:"uid"`
+	GID      int    `json:"gid"`
+	HasOwner bool   `json:"has_owner,omitempty"`
+	Copy     string `json:"copy,omitempty"`
+}
+
+// SysctlSnapshot is the runtime value of a kernel parameter.
+type SysctlSnapshot struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// FirewallSnapshot is a dump of the live ruleset, restored with the
+// backend's atomic loader.
+type FirewallSnapshot struct {
+	Backend string `json:"backend"`
+	Copy    string `json:"copy,omitempty"`
+	// Copy6 is the ip6tables dump of the iptables backend.
+	Copy6 string   `json:"copy6,omitempty"`
+	Files []string `json:"files,omitempty"`
+}
+
+// TxInfo is the persisted description of a transaction (tx.json).
+type TxInfo struct {
+	ID         string            `json:"id"`
+	Command    string            `json:"command"`
+	Status     TxStatus          `json:"status"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at,omitempty"`
+	Changes    []string          `json:"changes,omitempty"`
+	Files      []FileSnapshot    `json:"files,omitempty"`
+	Sysctls    []SysctlSnapshot  `json:"sysctls,omitempty"`
+	Firewall   *FirewallSnapshot `json:"firewall,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Dir        string            `json:"-"`
+}
+
+// JournalEntry is one line of journal.jsonl, written before and after every
+// step so an interrupted run can be reconstructed.
+type JournalEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Transaction groups hardening changes. Every file, sysctl and firewall
+// ruleset is snapshotted before the first change to it; Revert puts all of
+// them back. A nil *Transaction is valid and records nothing, so remediation
+// code can call it unconditionally.
+type Transaction struct {
+	mu      sync.Mutex
+	info    TxInfo
+	files   map[string]bool
+	sysctls map[string]bool
+	failed  error
+}
+
+// BeginTransaction creates <dir>/<id> and its journal. An empty dir uses
+// DefaultRollbackDir.
+func BeginTransaction(dir, command string) (*Transaction, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	now := time.Now()
+	id := now.Format("20060102-150405.000")
+	id = strings.Replace(id, ".", "-", 1)
+	txDir := filepath.Join(dir, id)
+	if err := os.MkdirAll(filepath.Join(txDir, "files"), 0o700); err != nil {
+		return nil, fmt.Errorf("create rollback dir: %w", err)
+	}
+	t := &Transaction{
+		info:    TxInfo{ID: id, Command: command, Status: TxOpen, StartedAt: now, Dir: txDir},
+		files:   map[string]bool{},
+		sysctls: map[string]bool{},
+	}
+	t.journal("begin", "", command, nil)
+	return t, t.save()
+}
+
+func (t *Transaction) ID() string {
+	if t == nil {
+		return ""
+	}
+	return t.info.ID
+}
+
+func (t *Transaction) Dir() string {
+	if t == nil {
+		return ""
+	}
+	return t.info.Dir
+}
+
+// Failed returns the first error reported through Do.
+func (t *Transaction) Failed() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// SnapshotFile saves path (content, mode, owner) unless it was already
+// saved in this transaction.
+func (t *Transaction) SnapshotFile(path string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path = filepath.Clean(path)
+	if t.files[path] {
+		return nil
+	}
+	snap := FileSnapshot{Path: path}
+	fi, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		snap.Existed = true
+		snap.Mode = uint32(fi.Mode().Perm())
+		if uid, gid, ok := fileOwner(fi); ok {
+			snap.UID, snap.GID, snap.HasOwner = int(uid), int(gid), true
+		}
+		snap.Copy = fmt.Sprintf("files/%03d-%s", len(t.info.Files), filepath.Base(path))
+		if err := os.WriteFile(filepath.Join(t.info.Dir, snap.Copy), b, 0o600); err != nil {
+			return err
+		}
+	}
+	t.files[path] = true
+	t.info.Files = append(t.info.Files, snap)
+	t.journal("snapshot-file", path, fmt.Sprintf("existed=%t", snap.Existed), nil)
+	return t.save()
+}
+
+// SnapshotSysctl saves the runtime value of a kernel parameter.
+func (t *Transaction) SnapshotSysctl(key string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sysctls[key] {
+		return nil
+	}
+	v, err := readSysctl(key)
+	if err != nil {
+		return err
+	}
+	t.sysctls[key] = true
+	t.info.Sysctls = append(t.info.Sysctls, SysctlSnapshot{Key: key, Value: v})
+	t.journal("snapshot-sysctl", key, v, nil)
+	return t.save()
+}
+
+// SnapshotFirewall dumps the live ruleset of backend, or of the detected
+// backend when it is empty.
+func (t *Transaction) SnapshotFirewall(ctx context.Context, backend string) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.info.Firewall != nil {
+		t.mu.Unlock()
+		return nil
+	}
+	t.mu.Unlock()
+
+	if backend == "" {
+		backend = detectFirewallBackend()
+	}
+	snap := &FirewallSnapshot{Backend: backend}
+	dump := func(name string, args ...string) (string, error) {
+		out, err := exec.CommandContext(ctx, name, args...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		copyName := "firewall.rules"
+		if name == "ip6tables-save" {
+			copyName = "firewall6.rules"
+		}
+		return copyName, os.WriteFile(filepath.Join(t.info.Dir, copyName), out, 0o600)
+	}
+	var err error
+	switch backend {
+	case "ufw":
+		for _, p := range []string{"/etc/ufw/user.rules", "/etc/ufw/user6.rules", "/etc/ufw/ufw.conf", "/etc/default/ufw"} {
+			if err := t.SnapshotFile(p); err != nil {
+				return err
+			}
+			snap.Files = append(snap.Files, p)
+		}
+	case "nftables":
+		snap.Copy, err = dump("nft", "list", "ruleset")
+	case "iptables":
+		snap.Copy, err = dump("iptables-save")
+		if err == nil {
+			if _, lerr := exec.LookPath("ip6tables-save"); lerr == nil {
+				snap.Copy6, err = dump("ip6tables-save")
+			}
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.Firewall = snap
+	t.journal("snapshot-firewall", backend, snap.Copy, nil)
+	return t.save()
+}
+
+// Do records desc in the journal and runs fn. The first failure is kept and
+// reported by Failed.
+func (t *Transaction) Do(desc string, fn func() error) error {
+	if t == nil {
+		return fn()
+	}
+	t.mu.Lock()
+	t.journal("change", "", desc, nil)
+	t.mu.Unlock()
+	err := fn()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		if t.failed == nil {
+			t.failed = fmt.Errorf("%s: %w", desc, err)
+		}
+		t.journal("change-failed", "", desc, err)
+	} else {
+		t.info.Changes = append(t.info.Changes, desc)
+		t.journal("change-done", "", desc, nil)
+	}
+	return errors.Join(err, t.save())
+}
+
+// Commit closes the transaction; it stays available to "harden rollback".
+func (t *Transaction) Commit() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.Status = TxCommitted
+	t.info.FinishedAt = time.Now()
+	t.journal("commit", "", "", nil)
+	return t.save()
+}
+
+// Revert restores every snapshot and marks the transaction reverted. cause
+// is recorded as the reason.
+func (t *Transaction) Revert(ctx context.Context, cause error) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cause != nil {
+		t.info.Error = cause.Error()
+	}
+	err := restoreTx(ctx, &t.info, t.journal)
+	t.info.Status = TxReverted
+	t.info.FinishedAt = time.Now()
+	t.journal("revert", "", "", err)
+	return errors.Join(err, t.save())
+}
+
+// Finish commits when err is nil and no step failed; otherwise it reverts
+// and returns the failure together with any revert error.
+func (t *Transaction) Finish(ctx context.Context, err error) error {
+	if t == nil {
+		return err
+	}
+	if err == nil {
+		err = t.Failed()
+	}
+	if err == nil {
+		return t.Commit()
+	}
+	if rerr := t.Revert(ctx, err); rerr != nil {
+		return fmt.Errorf("%w; revert of transaction %s failed: %v", err, t.info.ID, rerr)
+	}
+	return fmt.Errorf("%w (changes reverted, transaction %s)", err, t.info.ID)
+}
+
+func (t *Transaction) journal(action, target, detail string, err error) {
+	e := JournalEntry{Time: time.Now(), Action: action, Target: target, Detail: detail}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	appendJournal(t.info.Dir, e)
+}
+
+func (t *Transaction) save() error {
+	return writeTxInfo(t.info)
+}
+
+func appendJournal(dir string, e JournalEntry) {
+	f, err := os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	b, _ := json.Marshal(e)
+	_, _ = f.Write(append(b, '\n'))
+}
+
+func writeTxInfo(info TxInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(info.Dir, "tx.json.tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(info.Dir, "tx.json"))
+}
+
+// restoreTx puts back firewall rules, sysctls and files, newest first, and
+// reloads sshd if its configuration was restored.
+func restoreTx(ctx context.Context, info *TxInfo, journal func(action, target, detail string, err error)) error {
+	var errs []error
+	note := func(action, target string, err error) {
+		journal(action, target, "", err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", action, target, err))
+		}
+	}
+
+	reloadSSH, reloadAudit := false, false
+	for i := len(info.Files) - 1; i >= 0; i-- {
+		s := info.Files[i]
+		note("restore-file", s.Path, restoreFile(info.Dir, s))
+		if strings.HasPrefix(s.Path, "/etc/ssh/") {
+			reloadSSH = true
+		}
+		if strings.HasPrefix(s.Path, auditRulesDir+"/") {
+			reloadAudit = true
+		}
+	}
+	for i := len(info.Sysctls) - 1; i >= 0; i-- {
+		s := info.Sysctls[i]
+		note("restore-sysctl", s.Key, setSysctl(ctx, s.Key, s.Value))
+	}
+	if fw := info.Firewall; fw != nil {
+		note("restore-firewall", fw.Backend, restoreFirewall(ctx, info.Dir, fw))
+	}
+	if reloadSSH {
+		if err := validateSSHDConfig(ctx, SSHDConfigPath); err != nil {
+			note("validate-sshd", SSHDConfigPath, err)
+		} else if _, err := exec.LookPath("sshd"); err == nil {
+			note("reload-sshd", "", reloadSSHD(ctx))
+		}
+	}
+	if reloadAudit {
+		// Locked rules (-e 2) cannot be reloaded; the restored files
+		// apply at the next boot.
+		if _, enabled, err := loadedAuditRules(ctx); err == nil && enabled != 2 {
+			note("reload-audit-rules", auditRulesDir, loadAuditRules(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func restoreFile(dir string, s FileSnapshot) error {
+	if !s.Existed {
+		if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(dir, s.Copy))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.Path + ".fortis-restore"
+	if err := os.WriteFile(tmp, b, os.FileMode(s.Mode)); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, os.FileMode(s.Mode)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if s.HasOwner {
+		if err := os.Lchown(tmp, s.UID, s.GID); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func restoreFirewall(ctx context.Context, dir string, fw *FirewallSnapshot) error {
+	switch fw.Backend {
+	case "ufw":
+		// files were restored by restoreTx
+		return runCmd(ctx, nil, "ufw", "reload")
+	case "nftables":
+		b, err := os.ReadFile(filepath.Join(dir, fw.Copy))
+		if err != nil {
+			return err
+		}
+		script := append([]byte("flush ruleset\n"), b...)
+		return runCmd(ctx, script, "nft", "-f", "-")
+	case "iptables":
+		b, err := os.ReadFile(filepath.Join(dir, fw.Copy))
+		if err != nil {
+			return err
+		}
+		if err := runCmd(ctx, b, "iptables-restore"); err != nil {
+			return err
+		}
+		if fw.Copy6 == "" {
+			return nil
+		}
+		b, err = os.ReadFile(filepath.Join(dir, fw.Copy6))
+		if err != nil {
+			return err
+		}
+		return runCmd(ctx, b, "ip6tables-restore")
+	}
+	return nil
+}
+
+func runCmd(ctx context.Context, stdin []byte, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = strings.NewReader(string(stdin))
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// setSysctl changes a runtime kernel parameter.
+func setSysctl(ctx context.Context, key, value string) error {
+	return runCmd(ctx, nil, "sysctl", "-w", fmt.Sprintf("%s=%s", key, value))
+}
+
+// ListTransactions returns the transactions in dir, newest first.
+func ListTransactions(dir string) ([]TxInfo, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := []TxInfo{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := loadTxInfo(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out, nil
+}
+
+func loadTxInfo(txDir string) (TxInfo, error) {
+	b, err := os.ReadFile(filepath.Join(txDir, "tx.json"))
+	if err != nil {
+		return TxInfo{}, err
+	}
+	var info TxInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return TxInfo{}, err
+	}
+	info.Dir = txDir
+	return info, nil
+}
+
+// ReadJournal returns the journal entries of a transaction.
+func ReadJournal(dir, id string) ([]JournalEntry, error) {
+	if dir == "" {
+		dir = DefaultRollbackDir
+	}
+	f, err := os.Open(filepath.Join(dir, id, "journal.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := []JournalEntry{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e JournalEntry
+		if json.Unmarshal(s.Bytes(), &e) == nil {
+			out = append(out, e)
+		}
+	}
+	return out, s.Err()
+}
+
+type RollbackOptions struct {
+	Dir string
+	ID  string
+	// Force rolls back even when later transactions touched the same files
+	// or parameters; their changes to those are lost.
+	Force bool
+}
+
+// Rollback undoes a committed transaction.
+func Rollback(ctx context.Context, opts RollbackOptions) (TxInfo, error) {
+	all, err := ListTransactions(opts.Dir)
+	if err != nil {
+		return TxInfo{}, err
+	}
+	idx := -1
+	for i, tx := range all {
+		if tx.ID == opts.ID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return TxInfo{}, fmt.Errorf("transaction %s not found", opts.ID)
+	}
+	info := all[idx]
+	switch info.Status {
+	case TxReverted, TxRolledBack:
+		return info, fmt.Errorf("transaction %s is already %s", info.ID, info.Status)
+	case TxOpen:
+		if !opts.Force {
+			return info, fmt.Errorf("transaction %s never finished; use --force to restore its snapshots", info.ID)
+		}
+	}
+	if !opts.Force {
+		if later := overlappingLater(all[:idx], info); len(later) > 0 {
+			return info, fmt.Errorf("later transactions %s changed the same targets; roll them back first or use --force", strings.Join(later, ", "))
+		}
+	}
+
+	journal := func(action, target, detail string, err error) {
+		e := JournalEntry{Time: time.Now(), Action: action, Target: target, Detail: detail}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		appendJournal(info.Dir, e)
+	}
+	journal("rollback", "", "", nil)
+	rerr := restoreTx(ctx, &info, journal)
+	info.Status = TxRolledBack
+	info.FinishedAt = time.Now()
+	if rerr != nil {
+		info.Error = rerr.Error()
+	}
+	return info, errors.Join(rerr, writeTxInfo(info))
+}
+
+// overlappingLater lists still-active newer transactions that snapshotted
+// any of the targets of tx.
+func overlappingLater(newer []TxInfo, tx TxInfo) []string {
+	targets := map[string]bool{}
+	for _, f := range tx.Files {
+		targets["file:"+f.Path] = true
+	}
+	for _, s := range tx.Sysctls {
+		targets["sysctl:"+s.Key] = true
+	}
+	if tx.Firewall != nil {
+		targets["firewall"] = true
+	}
+	out := []string{}
+	for _, n := range newer {
+		if n.Status == TxReverted || n.Status == TxRolledBack {
+			continue
+		}
+		hit := n.Firewall != nil && targets["firewall"]
+		for _, f := range n.Files {
+			hit = hit || targets["file:"+f.Path]
+		}
+		for _, s := range n.Sysctls {
+			hit = hit || targets["sysctl:"+s.Key]
+		}
+		if hit {
+			out = append(out, n.ID)
+		}
+	}
+	return out
+}
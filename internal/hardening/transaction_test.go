@@ -0,0 +1,189 @@
+package hardening
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// txFile is the expected content and mode of a file after a revert or
+// rollback; a nil content means the file must not exist.
+type txFile struct {
+	content []byte
+	mode    os.FileMode
+}
+
+func writeTxFiles(t *testing.T, dir string, files map[string]txFile) {
+	t.Helper()
+	for name, f := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, f.content, f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkTxFiles(t *testing.T, dir string, want map[string]txFile) {
+	t.Helper()
+	for name, w := range want {
+		p := filepath.Join(dir, name)
+		fi, err := os.Stat(p)
+		if w.content == nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s exists after restore (err %v), want it removed", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if b, _ := os.ReadFile(p); string(b) != string(w.content) {
+			t.Errorf("%s = %q, want %q", name, b, w.content)
+		}
+		if fi.Mode().Perm() != w.mode {
+			t.Errorf("%s mode = %v, want %v", name, fi.Mode().Perm(), w.mode)
+		}
+	}
+}
+
+// changeFiles snapshots and rewrites every file in names inside tx, then
+// creates created.
+func changeFiles(t *testing.T, tx *Transaction, dir string, names []string, created string) {
+	t.Helper()
+	for _, name := range append(append([]string{}, names...), created) {
+		p := filepath.Join(dir, name)
+		if err := tx.SnapshotFile(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Do("rewrite "+name, func() error {
+			if err := os.WriteFile(p, []byte("changed\n"), 0o644); err != nil {
+				return err
+			}
+			return os.Chmod(p, 0o644)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var txOriginals = map[string]txFile{
+	"sshd_config": {content: []byte("PermitRootLogin yes\n"), mode: 0o600},
+	"limits.conf": {content: []byte("* hard core 0\n\x00\xff no trailing newline"), mode: 0o640},
+	"empty":       {content: []byte{}, mode: 0o400},
+}
+
+func txRestored() map[string]txFile {
+	want := map[string]txFile{"created.conf": {}}
+	for k, v := range txOriginals {
+		want[k] = v
+	}
+	return want
+}
+
+func TestTransactionRevertOnFailedStep(t *testing.T) {
+	dir := t.TempDir()
+	writeTxFiles(t, dir, txOriginals)
+	rbDir := filepath.Join(dir, "rollback")
+	tx, err := BeginTransaction(rbDir, "harden apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeFiles(t, tx, dir, []string{"sshd_config", "limits.conf", "empty"}, "created.conf")
+	// A second snapshot of a changed file must keep the original.
+	if err := tx.SnapshotFile(filepath.Join(dir, "sshd_config")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Do("reload service", func() error { return errors.New("exit status 1") }); err == nil {
+		t.Fatal("Do did not return the step error")
+	}
+
+	err = tx.Finish(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "reload service: exit status 1") || !strings.Contains(err.Error(), "changes reverted") {
+		t.Fatalf("Finish = %v, want the step failure and a revert note", err)
+	}
+	checkTxFiles(t, dir, txRestored())
+
+	info, err := loadTxInfo(tx.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != TxReverted || !strings.Contains(info.Error, "exit status 1") {
+		t.Errorf("status %s, error %q; want reverted with the cause", info.Status, info.Error)
+	}
+	if _, err := Rollback(context.Background(), RollbackOptions{Dir: rbDir, ID: tx.ID()}); err == nil || !strings.Contains(err.Error(), "already reverted") {
+		t.Errorf("Rollback of a reverted transaction = %v, want already reverted", err)
+	}
+}
+
+func TestTransactionCommitAndRollback(t *testing.T) {
+	dir := t.TempDir()
+	writeTxFiles(t, dir, txOriginals)
+	rbDir := filepath.Join(dir, "rollback")
+	tx, err := BeginTransaction(rbDir, "harden apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeFiles(t, tx, dir, []string{"sshd_config", "limits.conf", "empty"}, "created.conf")
+	if err := tx.Finish(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "created.conf")); string(b) != "changed\n" {
+		t.Fatalf("created.conf = %q after commit", b)
+	}
+
+	// Transaction IDs have millisecond resolution.
+	time.Sleep(5 * time.Millisecond)
+	later, err := BeginTransaction(rbDir, "harden apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeFiles(t, later, dir, []string{"limits.conf"}, "later.conf")
+	if err := later.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := Rollback(ctx, RollbackOptions{Dir: rbDir, ID: tx.ID()}); err == nil || !strings.Contains(err.Error(), later.ID()) {
+		t.Fatalf("Rollback under an overlapping later transaction = %v, want it refused", err)
+	}
+	if info, err := Rollback(ctx, RollbackOptions{Dir: rbDir, ID: later.ID()}); err != nil || info.Status != TxRolledBack {
+		t.Fatalf("Rollback(later) = %s, %v", info.Status, err)
+	}
+	info, err := Rollback(ctx, RollbackOptions{Dir: rbDir, ID: tx.ID()})
+	if err != nil || info.Status != TxRolledBack {
+		t.Fatalf("Rollback = %s, %v", info.Status, err)
+	}
+	want := txRestored()
+	want["later.conf"] = txFile{}
+	checkTxFiles(t, dir, want)
+
+	if saved, err := loadTxInfo(tx.Dir()); err != nil || saved.Status != TxRolledBack {
+		t.Errorf("saved status = %s, %v; want rolled-back", saved.Status, err)
+	}
+	if _, err := Rollback(ctx, RollbackOptions{Dir: rbDir, ID: tx.ID()}); err == nil || !strings.Contains(err.Error(), "already rolled-back") {
+		t.Errorf("second Rollback = %v, want already rolled-back", err)
+	}
+	if _, err := Rollback(ctx, RollbackOptions{Dir: rbDir, ID: "19700101-000000-000"}); err == nil {
+		t.Error("Rollback of an unknown ID succeeded")
+	}
+
+	entries, err := ReadJournal(rbDir, tx.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	if got := strings.Join(actions, " "); !strings.HasPrefix(got, "begin snapshot-file change change-done") || !strings.Contains(got, "commit rollback restore-file") {
+		t.Errorf("journal = %s", got)
+	}
+}
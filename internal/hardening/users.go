@@ -0,0 +1,1015 @@
+package hardening
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInactiveDays is the PCI DSS 8.2.6 limit for unused accounts.
+const DefaultInactiveDays = 90
+
+// User audit issue kinds, the Check field of UserIssue.
+const (
+	UserIssueUID0           = "uid0"
+	UserIssueUnshadowed     = "unshadowed"
+	UserIssueEmptyPassword  = "empty_password"
+	UserIssueWeakHash       = "weak_hash"
+	UserIssueAging          = "aging"
+	UserIssueInactive       = "inactive"
+	UserIssueDuplicateUID   = "duplicate_uid"
+	UserIssueDuplicateGID   = "duplicate_gid"
+	UserIssueHome           = "home"
+	UserIssueAuthorizedKeys = "authorized_keys"
+)
+
+// LoginDefs holds the /etc/login.defs settings the user audit uses. Unset
+// values carry the shadow-utils defaults.
+type LoginDefs struct {
+	PassMaxDays   int    `json:"pass_max_days"`
+	PassMinDays   int    `json:"pass_min_days"`
+	PassWarnAge   int    `json:"pass_warn_age"`
+	UIDMin        int    `json:"uid_min"`
+	EncryptMethod string `json:"encrypt_method"`
+}
+
+// UserAccount is one /etc/passwd entry joined with its shadow entry and
+// last login. Aging fields are -1 when unset.
+type UserAccount struct {
+	Name        string `json:"name"`
+	UID         int    `json:"uid"`
+	GID         int    `json:"gid"`
+	Home        string `json:"home"`
+	Shell       string `json:"shell"`
+	Interactive bool   `json:"interactive"`
+	// Password is "hashed", "locked", "empty", "unshadowed" (a hash or
+	// empty field in /etc/passwd itself) or "unknown" (shadow unreadable).
+	Password      string     `json:"password"`
+	HashAlgorithm string     `json:"hash_algorithm,omitempty"`
+	LastChange    *time.Time `json:"last_change,omitempty"`
+	MinDays       int        `json:"min_days"`
+	MaxDays       int        `json:"max_days"`
+	WarnDays      int        `json:"warn_days"`
+	Expired       bool       `json:"expired,omitempty"`
+	LastLogin     *time.Time `json:"last_login,omitempty"`
+	LastLoginFrom string     `json:"last_login_from,omitempty"`
+}
+
+// Usable reports whether the account can log in with a password or key:
+// not locked, not expired and with a login shell.
+func (u UserAccount) Usable() bool {
+	return u.Interactive && !u.Expired && u.Password != "locked"
+}
+
+type UserIssue struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Subject  string   `json:"subject"`
+	Detail   string   `json:"detail"`
+}
+
+type UserAudit struct {
+	Policy       LoginDefs     `json:"policy"`
+	InactiveDays int           `json:"inactive_days"`
+	Accounts     []UserAccount `json:"accounts"`
+	Issues       []UserIssue   `json:"issues"`
+	// Inactive are the accounts --lock-inactive locks.
+	Inactive []string `json:"inactive"`
+	Notes    []string `json:"notes,omitempty"`
+}
+
+// Failed reports whether the audit found anything at high severity or
+// above, or any issue at all when strict.
+func (a UserAudit) Failed(strict bool) bool {
+	for _, is := range a.Issues {
+		if strict || severityRank(is.Severity) >= severityRank(SeverityHigh) {
+			return true
+		}
+	}
+	return false
+}
+
+type UserAuditOptions struct {
+	// Root audits an image root or tarball instead of the host. Last
+	// logins are not evaluated in images.
+	Root string
+	// InactiveDays defaults to DefaultInactiveDays.
+	InactiveDays int
+
+	root *auditRoot
+	now  time.Time
+}
+
+// nonLoginShells cannot start an interactive session.
+var nonLoginShells = map[string]bool{
+	"/sbin/nologin": true, "/usr/sbin/nologin": true, "/bin/false": true, "/usr/bin/false": true,
+	"/bin/sync": true, "/sbin/shutdown": true, "/sbin/halt": true, "/usr/sbin/shutdown": true, "/usr/sbin/halt": true,
+}
+
+// hashAlgorithms maps crypt(3) prefixes to names; weak ones are marked.
+var hashAlgorithms = []struct {
+	prefix, name string
+	weak         bool
+}{
+	{"$y$", "yescrypt", false},
+	{"$gy$", "gost-yescrypt", false},
+	{"$7$", "scrypt", false},
+	{"$6$", "sha512crypt", false},
+	{"$5$", "sha256crypt", false},
+	{"$2b$", "bcrypt", false},
+	{"$2y$", "bcrypt", false},
+	{"$2a$", "bcrypt", false},
+	{"$1$", "md5crypt", true},
+	{"$3$", "nthash", true},
+	{"$md5", "sunmd5", true},
+	{"$sha1$", "sha1crypt", true},
+	{"_", "bsdicrypt", true},
+}
+
+// hashAlgorithm names the scheme of a crypt(3) hash. A 13 character hash
+// without a prefix is traditional DES.
+func hashAlgorithm(hash string) (name string, weak bool) {
+	for _, h := range hashAlgorithms {
+		if strings.HasPrefix(hash, h.prefix) {
+			return h.name, h.weak
+		}
+	}
+	if len(hash) == 13 {
+		return "descrypt", true
+	}
+	return "unknown", true
+}
+
+// AuditUsers parses the account databases and reports privileged, weak,
+// stale and duplicate accounts, home directory permissions and risky
+// authorized_keys entries.
+func AuditUsers(ctx context.Context, opts UserAuditOptions) (UserAudit, error) {
+	if runtime.GOOS != "linux" && opts.Root == "" {
+		return UserAudit{}, errors.New("user audit is not supported on this OS")
+	}
+	if opts.root == nil && opts.Root != "" {
+		root, err := OpenAuditRoot(opts.Root)
+		if err != nil {
+			return UserAudit{}, err
+		}
+		opts.root = root
+	}
+	if opts.InactiveDays <= 0 {
+		opts.InactiveDays = DefaultInactiveDays
+	}
+	if opts.now.IsZero() {
+		opts.now = time.Now()
+	}
+	root := opts.root
+
+	passwd, err := root.ReadFile("/etc/passwd")
+	if err != nil {
+		return UserAudit{}, err
+	}
+	audit := UserAudit{Policy: readLoginDefs(root), InactiveDays: opts.InactiveDays, Accounts: []UserAccount{}, Issues: []UserIssue{}, Inactive: []string{}}
+	add := func(check string, sev Severity, subject, format string, args ...any) {
+		audit.Issues = append(audit.Issues, UserIssue{Check: check, Severity: sev, Subject: subject, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	shadow := map[string][]string{}
+	b, err := root.ReadFile("/etc/shadow")
+	shadowOK := err == nil
+	if shadowOK {
+		for _, f := range colonRecords(b) {
+			if len(f) >= 2 {
+				shadow[f[0]] = f
+			}
+		}
+	} else {
+		audit.Notes = append(audit.Notes, "cannot read /etc/shadow ("+err.Error()+"); password checks are incomplete")
+	}
+
+	var lastlog func(uid int) (time.Time, string, bool)
+	if !root.isImage() {
+		lastlog, err = readLastlog(root)
+		if err != nil {
+			audit.Notes = append(audit.Notes, err.Error()+"; inactive accounts are not evaluated")
+		}
+	} else {
+		audit.Notes = append(audit.Notes, "last logins are not evaluated in images")
+	}
+
+	today := int(opts.now.Unix() / 86400)
+	for _, f := range colonRecords(passwd) {
+		if len(f) < 7 {
+			continue
+		}
+		uid, err1 := strconv.Atoi(f[2])
+		gid, err2 := strconv.Atoi(f[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		u := UserAccount{Name: f[0], UID: uid, GID: gid, Home: f[5], Shell: f[6], MinDays: -1, MaxDays: -1, WarnDays: -1}
+		u.Interactive = !nonLoginShells[u.Shell]
+
+		hash := f[1]
+		sh, shadowed := shadow[u.Name]
+		switch {
+		case f[1] != "x":
+			u.Password = "unshadowed"
+		case !shadowOK:
+			u.Password = "unknown"
+		case !shadowed:
+			// No shadow entry: nothing to log in with.
+			u.Password, hash = "locked", "!"
+		default:
+			hash = sh[1]
+		}
+		if u.Password == "" {
+			switch {
+			case hash == "":
+				u.Password = "empty"
+			case strings.HasPrefix(hash, "!") || strings.HasPrefix(hash, "*"):
+				u.Password = "locked"
+			default:
+				u.Password = "hashed"
+			}
+		}
+		if hash != "" && !strings.HasPrefix(hash, "!") && !strings.HasPrefix(hash, "*") {
+			u.HashAlgorithm, _ = hashAlgorithm(hash)
+		}
+		if shadowed {
+			field := func(i int) int {
+				if i >= len(sh) || sh[i] == "" {
+					return -1
+				}
+				n, err := strconv.Atoi(sh[i])
+				if err != nil {
+					return -1
+				}
+				return n
+			}
+			if d := field(2); d > 0 {
+				t := time.Unix(int64(d)*86400, 0).UTC()
+				u.LastChange = &t
+			}
+			u.MinDays, u.MaxDays, u.WarnDays = field(3), field(4), field(5)
+			if exp := field(7); exp >= 0 && exp <= today {
+				u.Expired = true
+			}
+		}
+		if lastlog != nil {
+			if t, from, ok := lastlog(uid); ok {
+				u.LastLogin, u.LastLoginFrom = &t, from
+			}
+		}
+		audit.Accounts = append(audit.Accounts, u)
+
+		if uid == 0 && u.Name != "root" {
+			add(UserIssueUID0, SeverityCritical, u.Name, "has UID 0 (root privileges)")
+		}
+		switch u.Password {
+		case "unshadowed":
+			add(UserIssueUnshadowed, SeverityHigh, u.Name, "password field in /etc/passwd is %q, not \"x\"", redactHash(f[1]))
+			if f[1] == "" {
+				add(UserIssueEmptyPassword, SeverityCritical, u.Name, "has no password in /etc/passwd")
+			}
+		case "empty":
+			add(UserIssueEmptyPassword, SeverityCritical, u.Name, "has an empty password field in /etc/shadow")
+		case "hashed":
+			if !u.Expired {
+				checkPasswordAging(u, audit.Policy, today, add)
+			}
+		}
+		if u.HashAlgorithm != "" {
+			if _, weak := hashAlgorithm(hash); weak {
+				add(UserIssueWeakHash, SeverityHigh, u.Name, "password is hashed with %s", u.HashAlgorithm)
+			}
+		}
+		if uid >= audit.Policy.UIDMin && u.Usable() && lastlog != nil {
+			if last, ok := inactiveSince(u, opts.now, opts.InactiveDays); ok {
+				if u.LastLogin == nil {
+					add(UserIssueInactive, SeverityMedium, u.Name, "never logged in; password set %s", last.Format("2006-01-02"))
+				} else {
+					add(UserIssueInactive, SeverityMedium, u.Name, "last login %s (%d days ago)", last.Format("2006-01-02"), int(opts.now.Sub(last).Hours()/24))
+				}
+				audit.Inactive = append(audit.Inactive, u.Name)
+			}
+		}
+	}
+
+	if m := strings.ToUpper(audit.Policy.EncryptMethod); m != "SHA512" && m != "YESCRYPT" {
+		add(UserIssueWeakHash, SeverityMedium, "/etc/login.defs", "ENCRYPT_METHOD is %s; use SHA512 or YESCRYPT", orUnset(audit.Policy.EncryptMethod))
+	}
+	duplicateIDs(audit.Accounts, func(u UserAccount) (int, string) { return u.UID, u.Name }, func(id int, names []string) {
+		add(UserIssueDuplicateUID, SeverityHigh, "uid "+strconv.Itoa(id), "shared by %s", strings.Join(names, ", "))
+	})
+	duplicateNames(audit.Accounts, func(u UserAccount) string { return u.Name }, func(name string, n int) {
+		add(UserIssueDuplicateUID, SeverityHigh, name, "user name appears %d times in /etc/passwd", n)
+	})
+	if b, err := root.ReadFile("/etc/group"); err == nil {
+		type group struct {
+			name string
+			gid  int
+		}
+		var groups []group
+		for _, f := range colonRecords(b) {
+			if len(f) < 3 {
+				continue
+			}
+			if gid, err := strconv.Atoi(f[2]); err == nil {
+				groups = append(groups, group{f[0], gid})
+			}
+		}
+		duplicateIDs(groups, func(g group) (int, string) { return g.gid, g.name }, func(id int, names []string) {
+			add(UserIssueDuplicateGID, SeverityMedium, "gid "+strconv.Itoa(id), "shared by groups %s", strings.Join(names, ", "))
+		})
+		duplicateNames(groups, func(g group) string { return g.name }, func(name string, n int) {
+			add(UserIssueDuplicateGID, SeverityMedium, name, "group name appears %d times in /etc/group", n)
+		})
+	}
+
+	// Accounts sharing a home (root and toor) are reported once.
+	keyFiles := authorizedKeysFiles(root)
+	seen := map[string]bool{}
+	for _, u := range audit.Accounts {
+		if err := ctx.Err(); err != nil {
+			return UserAudit{}, err
+		}
+		if u.Interactive && (u.UID == 0 || u.UID >= audit.Policy.UIDMin) && !seen[u.Home] {
+			seen[u.Home] = true
+			checkHomeDirectory(root, u, add)
+		}
+		for _, p := range keyFiles(u) {
+			if !seen[p] {
+				seen[p] = true
+				checkAuthorizedKeys(root, u, p, add)
+			}
+		}
+	}
+	return audit, nil
+}
+
+func orUnset(v string) string {
+	if v == "" {
+		return "not set"
+	}
+	return v
+}
+
+// redactHash keeps the scheme of a hash found in /etc/passwd.
+func redactHash(h string) string {
+	if name, _ := hashAlgorithm(h); h != "" && name != "unknown" {
+		return name + " hash"
+	}
+	return h
+}
+
+// colonRecords splits a passwd-style file into fields, skipping blank
+// lines, comments and NIS "+"/"-" entries.
+func colonRecords(b []byte) [][]string {
+	var out [][]string
+	for _, ln := range strings.Split(string(b), "\n") {
+		ln = strings.TrimRight(ln, "\r")
+		if ln == "" || strings.HasPrefix(ln, "#") || strings.HasPrefix(ln, "+") || strings.HasPrefix(ln, "-") {
+			continue
+		}
+		out = append(out, strings.Split(ln, ":"))
+	}
+	return out
+}
+
+func readLoginDefs(root *auditRoot) LoginDefs {
+	d := LoginDefs{PassMaxDays: 99999, PassMinDays: 0, PassWarnAge: 7, UIDMin: 1000}
+	b, err := root.ReadFile("/etc/login.defs")
+	if err != nil {
+		return d
+	}
+	for _, ln := range strings.Split(string(b), "\n") {
+		f := strings.Fields(ln)
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		n, nerr := strconv.Atoi(f[1])
+		switch f[0] {
+		case "PASS_MAX_DAYS":
+			if nerr == nil {
+				d.PassMaxDays = n
+			}
+		case "PASS_MIN_DAYS":
+			if nerr == nil {
+				d.PassMinDays = n
+			}
+		case "PASS_WARN_AGE":
+			if nerr == nil {
+				d.PassWarnAge = n
+			}
+		case "UID_MIN":
+			if nerr == nil {
+				d.UIDMin = n
+			}
+		case "ENCRYPT_METHOD":
+			d.EncryptMethod = f[1]
+		}
+	}
+	return d
+}
+
+// checkPasswordAging compares an account's shadow aging fields with the
+// policy, capped at the CIS limits (365 days maximum, 1 day minimum, 7
+// days warning) when login.defs is laxer.
+func checkPasswordAging(u UserAccount, p LoginDefs, today int, add func(string, Severity, string, string, ...any)) {
+	maxDays := p.PassMaxDays
+	if maxDays <= 0 || maxDays > 365 {
+		maxDays = 365
+	}
+	minDays := p.PassMinDays
+	if minDays < 1 {
+		minDays = 1
+	}
+	warn := p.PassWarnAge
+	if warn < 7 {
+		warn = 7
+	}
+	var problems []string
+	switch {
+	case u.MaxDays < 0 || u.MaxDays >= 99999:
+		problems = append(problems, "password never expires")
+	case u.MaxDays > maxDays:
+		problems = append(problems, fmt.Sprintf("maximum age %d exceeds %d days", u.MaxDays, maxDays))
+	}
+	if u.MinDays < minDays {
+		problems = append(problems, fmt.Sprintf("minimum age %d is below %d", max(u.MinDays, 0), minDays))
+	}
+	if u.WarnDays >= 0 && u.WarnDays < warn {
+		problems = append(problems, fmt.Sprintf("warning period %d is below %d days", u.WarnDays, warn))
+	}
+	if u.LastChange != nil && int(u.LastChange.Unix()/86400) > today {
+		problems = append(problems, "last password change is in the future")
+	}
+	if len(problems) > 0 {
+		add(UserIssueAging, SeverityLow, u.Name, "%s", strings.Join(problems, "; "))
+	}
+}
+
+// inactiveSince returns the last login, or for accounts that never logged
+// in the last password change, when it is older than days.
+func inactiveSince(u UserAccount, now time.Time, days int) (time.Time, bool) {
+	ref := u.LastLogin
+	if ref == nil {
+		ref = u.LastChange
+	}
+	if ref == nil {
+		return time.Time{}, false
+	}
+	return *ref, now.Sub(*ref) > time.Duration(days)*24*time.Hour
+}
+
+func duplicateIDs[T any](items []T, key func(T) (int, string), report func(id int, names []string)) {
+	byID := map[int][]string{}
+	var ids []int
+	for _, it := range items {
+		id, name := key(it)
+		if _, ok := byID[id]; !ok {
+			ids = append(ids, id)
+		}
+		byID[id] = append(byID[id], name)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if len(byID[id]) > 1 {
+			report(id, byID[id])
+		}
+	}
+}
+
+func duplicateNames[T any](items []T, key func(T) string, report func(name string, n int)) {
+	count := map[string]int{}
+	var names []string
+	for _, it := range items {
+		n := key(it)
+		if count[n] == 0 {
+			names = append(names, n)
+		}
+		count[n]++
+	}
+	for _, n := range names {
+		if count[n] > 1 {
+			report(n, count[n])
+		}
+	}
+}
+
+// lastlogRecordSize is sizeof(struct lastlog): ll_time is 32 bits on
+// 32-bit platforms and where glibc keeps 32-bit compatibility
+// (x86_64, ppc64, s390x), 64 bits elsewhere.
+func lastlogRecordSize() int {
+	switch runtime.GOARCH {
+	case "arm64", "riscv64", "loong64", "mips64", "mips64le":
+		return 296
+	}
+	return 292
+}
+
+// readLastlog indexes /var/log/lastlog by UID. Systems that moved to
+// lastlog2 have no such file, or an empty one.
+func readLastlog(root *auditRoot) (func(uid int) (time.Time, string, bool), error) {
+	b, err := root.ReadFile("/var/log/lastlog")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read /var/log/lastlog: %w", err)
+	}
+	if len(b) == 0 {
+		if root.exists("/var/lib/lastlog/lastlog2.db") {
+			return nil, errors.New("last logins are in lastlog2, which fortis cannot read")
+		}
+		// Nobody ever logged in through a lastlog-aware service.
+		return func(int) (time.Time, string, bool) { return time.Time{}, "", false }, nil
+	}
+	size := lastlogRecordSize()
+	return func(uid int) (time.Time, string, bool) {
+		off := uid * size
+		if uid < 0 || off+size > len(b) {
+			return time.Time{}, "", false
+		}
+		rec := b[off : off+size]
+		var sec int64
+		timeLen := 4
+		if size == 296 {
+			sec, timeLen = int64(binary.LittleEndian.Uint64(rec)), 8
+		} else {
+			sec = int64(binary.LittleEndian.Uint32(rec))
+		}
+		if sec == 0 {
+			return time.Time{}, "", false
+		}
+		host := strings.TrimRight(string(rec[timeLen+32:]), "\x00")
+		if host == "" {
+			host = strings.TrimRight(string(rec[timeLen:timeLen+32]), "\x00")
+		}
+		return time.Unix(sec, 0), host, true
+	}, nil
+}
+
+func checkHomeDirectory(root *auditRoot, u UserAccount, add func(string, Severity, string, string, ...any)) {
+	if u.Home == "" || u.Home == "/" {
+		add(UserIssueHome, SeverityMedium, u.Name, "home directory is %q", u.Home)
+		return
+	}
+	fi, err := root.Stat(u.Home)
+	if err != nil {
+		add(UserIssueHome, SeverityLow, u.Name, "home directory %s does not exist", u.Home)
+		return
+	}
+	if !fi.IsDir() {
+		add(UserIssueHome, SeverityMedium, u.Name, "home %s is not a directory", u.Home)
+		return
+	}
+	if uid, _, ok := statOwner(fi); ok && int(uid) != u.UID {
+		add(UserIssueHome, SeverityHigh, u.Name, "home directory %s is owned by %s", u.Home, root.userName(uid))
+	}
+	if perm := fi.Mode().Perm(); perm&0o027 != 0 {
+		add(UserIssueHome, SeverityMedium, u.Name, "home directory %s has mode %04o; use 0750 or stricter", u.Home, perm)
+	}
+}
+
+// authorizedKeysFiles returns, per account, the authorized_keys paths sshd
+// reads: AuthorizedKeysFile from sshd_config with %h, %u and %% expanded
+// and relative paths under the home directory.
+func authorizedKeysFiles(root *auditRoot) func(UserAccount) []string {
+	patterns := []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+	if cfg, err := parseSSHDConfig(root, SSHDConfigPath); err == nil {
+		if st, ok := cfg.Settings[sshdKey("AuthorizedKeysFile")]; ok && !strings.EqualFold(st.Value, "none") {
+			patterns = strings.Fields(st.Value)
+		}
+	}
+	return func(u UserAccount) []string {
+		var out []string
+		for _, p := range patterns {
+			p = strings.NewReplacer("%%", "%", "%h", u.Home, "%u", u.Name, "%U", strconv.Itoa(u.UID)).Replace(p)
+			if !path.IsAbs(p) {
+				p = path.Join(u.Home, p)
+			}
+			if root.exists(p) {
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+}
+
+var sshKeyTypes = map[string]bool{
+	"ssh-rsa": true, "ssh-dss": true, "ssh-ed25519": true, "ssh-ed448": true,
+	"ecdsa-sha2-nistp256": true, "ecdsa-sha2-nistp384": true, "ecdsa-sha2-nistp521": true,
+	"sk-ssh-ed25519@openssh.com": true, "sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// checkAuthorizedKeys reports authorized_keys files other users can
+// modify, weak keys and options that widen what a key can do.
+func checkAuthorizedKeys(root *auditRoot, u UserAccount, p string, add func(string, Severity, string, string, ...any)) {
+	if fi, err := root.Stat(p); err == nil {
+		if uid, _, ok := statOwner(fi); ok && int(uid) != u.UID && uid != 0 {
+			add(UserIssueAuthorizedKeys, SeverityHigh, p, "owned by %s, not %s", root.userName(uid), u.Name)
+		}
+		if perm := fi.Mode().Perm(); perm&0o022 != 0 {
+			add(UserIssueAuthorizedKeys, SeverityHigh, p, "mode %04o is writable by group or others", perm)
+		}
+	}
+	b, err := root.ReadFile(p)
+	if err != nil {
+		return
+	}
+	for i, ln := range strings.Split(string(b), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", p, i+1)
+		opts, keyType, blob := splitAuthorizedKey(ln)
+		for _, problem := range authorizedKeyProblems(u, opts, keyType, blob) {
+			add(UserIssueAuthorizedKeys, problem.sev, where, "%s", problem.text)
+		}
+	}
+}
+
+type keyProblem struct {
+	sev  Severity
+	text string
+}
+
+func authorizedKeyProblems(u UserAccount, opts []string, keyType, blob string) []keyProblem {
+	var out []keyProblem
+	restricted, certAuthority := false, false
+	hasFrom, hasCommand, hasPrincipals := false, false, false
+	for _, o := range opts {
+		name, val, _ := strings.Cut(o, "=")
+		name = strings.ToLower(name)
+		val = strings.Trim(val, `"`)
+		switch name {
+		case "restrict":
+			restricted = true
+		case "from":
+			hasFrom = true
+			if val == "*" || strings.Contains(val, "0.0.0.0/0") || strings.Contains(val, "::/0") {
+				out = append(out, keyProblem{SeverityMedium, fmt.Sprintf("from=%q matches any address", val)})
+			}
+		case "command":
+			hasCommand = true
+		case "principals":
+			hasPrincipals = true
+		case "environment":
+			out = append(out, keyProblem{SeverityHigh, fmt.Sprintf("environment=%q can change the login environment (LD_PRELOAD, PATH)", val)})
+		case "tunnel":
+			out = append(out, keyProblem{SeverityMedium, fmt.Sprintf("tunnel=%q allows tun device forwarding", val)})
+		case "permitopen", "permitlisten":
+			if strings.Contains(val, "*") {
+				out = append(out, keyProblem{SeverityMedium, fmt.Sprintf("%s=%q allows forwarding to any host or port", name, val)})
+			}
+		case "cert-authority":
+			certAuthority = true
+		}
+	}
+	if certAuthority && !hasPrincipals {
+		out = append(out, keyProblem{SeverityMedium, "cert-authority without principals= trusts every certificate the CA signs for this account"})
+	}
+	if u.UID == 0 && !hasFrom && !hasCommand && !restricted {
+		out = append(out, keyProblem{SeverityMedium, "unrestricted root key: no from=, command= or restrict"})
+	}
+	switch {
+	case keyType == "":
+		out = append(out, keyProblem{SeverityLow, "unparseable key line"})
+	case keyType == "ssh-dss":
+		out = append(out, keyProblem{SeverityHigh, "DSA key (deprecated, 1024 bits)"})
+	case keyType == "ssh-rsa":
+		if bits := rsaKeyBits(blob); bits > 0 && bits < 2048 {
+			out = append(out, keyProblem{SeverityHigh, fmt.Sprintf("RSA key of %d bits; use 3072 or more", bits)})
+		}
+	}
+	return out
+}
+
+// splitAuthorizedKey separates the options, key type and base64 key of an
+// authorized_keys line. Options are comma-separated and may quote commas
+// and spaces.
+func splitAuthorizedKey(ln string) (opts []string, keyType, blob string) {
+	fields := strings.Fields(ln)
+	if len(fields) >= 2 && sshKeyTypes[fields[0]] {
+		return nil, fields[0], fields[1]
+	}
+	var cur strings.Builder
+	inQuote := false
+	i := 0
+	for ; i < len(ln); i++ {
+		c := ln[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(ln):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(ln[i])
+			continue
+		case c == '"':
+			inQuote = !inQuote
+		case c == ',' && !inQuote:
+			opts = append(opts, cur.String())
+			cur.Reset()
+			continue
+		case (c == ' ' || c == '\t') && !inQuote:
+			opts = append(opts, cur.String())
+			rest := strings.Fields(ln[i:])
+			if len(rest) >= 2 && sshKeyTypes[rest[0]] {
+				return opts, rest[0], rest[1]
+			}
+			return opts, "", ""
+		}
+		cur.WriteByte(c)
+	}
+	return opts, "", ""
+}
+
+// rsaKeyBits returns the modulus size of an ssh-rsa public key blob.
+func rsaKeyBits(blob string) int {
+	b, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return 0
+	}
+	var parts [][]byte
+	for len(b) >= 4 && len(parts) < 3 {
+		n := int(binary.BigEndian.Uint32(b))
+		if n > len(b)-4 {
+			return 0
+		}
+		parts = append(parts, b[4:4+n])
+		b = b[4+n:]
+	}
+	if len(parts) != 3 || string(parts[0]) != "ssh-rsa" {
+		return 0
+	}
+	return new(big.Int).SetBytes(parts[2]).BitLen()
+}
+
+// LockUsersOptions locks the given accounts (see UserAudit.Inactive).
+type LockUsersOptions struct {
+	Users       []string
+	Yes         bool
+	DryRun      bool
+	RollbackDir string
+}
+
+type LockUsersResult struct {
+	Locked        []string `json:"locked"`
+	TransactionID string   `json:"transaction_id,omitempty"`
+}
+
+// LockUsers locks the password and expires each account, which also stops
+// key-based logins through PAM's account check. /etc/shadow is snapshot
+// first, so any failure restores every account.
+func LockUsers(ctx context.Context, opts LockUsersOptions) (LockUsersResult, error) {
+	res := LockUsersResult{Locked: []string{}}
+	if len(opts.Users) == 0 || opts.DryRun {
+		res.Locked = append(res.Locked, opts.Users...)
+		return res, nil
+	}
+	if !opts.Yes {
+		return res, errors.New("locking accounts requires --yes (or use --dry-run)")
+	}
+	if runtime.GOOS != "linux" {
+		return res, errors.New("locking accounts is not supported on this OS")
+	}
+	tx, err := BeginTransaction(opts.RollbackDir, "users --lock-inactive "+strings.Join(opts.Users, ","))
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	err = func() error {
+		for _, p := range []string{"/etc/shadow", "/etc/passwd"} {
+			if err := tx.SnapshotFile(p); err != nil {
+				return err
+			}
+		}
+		for _, name := range opts.Users {
+			name := name
+			if err := tx.Do("lock account "+name, func() error {
+				return runCmd(ctx, nil, "usermod", "--lock", "--expiredate", "1", name)
+			}); err != nil {
+				return err
+			}
+			res.Locked = append(res.Locked, name)
+		}
+		return nil
+	}()
+	if err := tx.Finish(ctx, err); err != nil {
+		res.Locked = []string{}
+		return res, err
+	}
+	return res, nil
+}
+
+// userScan runs the user audit once for all accounts.* checks of a run.
+type userScan struct {
+	once sync.Once
+	rep  UserAudit
+	err  error
+}
+
+func (s *userScan) get(ctx context.Context, opts AuditOptions) (UserAudit, error) {
+	run := func() (UserAudit, error) {
+		return AuditUsers(ctx, UserAuditOptions{root: opts.root})
+	}
+	if s == nil {
+		return run()
+	}
+	s.once.Do(func() { s.rep, s.err = run() })
+	return s.rep, s.err
+}
+
+// userIssues loads the user audit for the accounts.* checks.
+func userIssues(ctx context.Context, opts AuditOptions) ([]UserIssue, string, error) {
+	audit, err := opts.users.get(ctx, opts)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return nil, err.Error(), nil
+		}
+		return nil, "", err
+	}
+	return audit.Issues, "", nil
+}
+
+// inactiveUserIssues is userIssues for last-login checks, which need the
+// live login records.
+func inactiveUserIssues(ctx context.Context, opts AuditOptions) ([]UserIssue, string, error) {
+	if opts.root.isImage() {
+		return nil, runtimeOnly, nil
+	}
+	return userIssues(ctx, opts)
+}
+
+type loginDefsSetting struct{ Key, Value string }
+
+// loginDefsPolicy is what --password-policy writes to /etc/login.defs.
+var loginDefsPolicy = []loginDefsSetting{
+	{Key: "PASS_MAX_DAYS", Value: "90"},
+	{Key: "PASS_MIN_DAYS", Value: "1"},
+	{Key: "PASS_WARN_AGE", Value: "7"},
+	{Key: "PASS_MIN_LEN", Value: "12"},
+	{Key: "ENCRYPT_METHOD", Value: "SHA512"},
+}
+
+// sessionTimeoutPath holds the idle shell timeout --sudo-secure installs.
+const sessionTimeoutPath = "/etc/profile.d/fortis-timeout.sh"
+
+const sessionTimeoutScript = "# Managed by fortis: log out idle shells after 15 minutes.\nexport TMOUT=900\nreadonly TMOUT\n"
+
+type UserPolicyOptions struct {
+	// PasswordPolicy sets password aging and hashing in /etc/login.defs
+	// for new passwords, and the PAM length, complexity, history and
+	// lockout policy (see ApplyPAMPolicy).
+	PasswordPolicy bool
+	// SessionTimeout installs a 15 minute idle shell timeout.
+	SessionTimeout bool
+	// SudoDefaults adds the use_pty and logfile sudo Defaults the sudoers
+	// tree is missing, in a drop-in checked by visudo.
+	SudoDefaults bool
+	Yes          bool
+	DryRun       bool
+	RollbackDir  string
+}
+
+type UserPolicyResult struct {
+	Changes       []string `json:"changes"`
+	Notes         []string `json:"notes,omitempty"`
+	TransactionID string   `json:"transaction_id,omitempty"`
+}
+
+// ApplyUserPolicy writes the login.defs policy, the session timeout and
+// the sudo Defaults in one transaction. Existing accounts keep their aging; the audit reports
+// them under accounts.password_aging.
+func ApplyUserPolicy(ctx context.Context, opts UserPolicyOptions) (UserPolicyResult, error) {
+	var res UserPolicyResult
+	var policy []loginDefsSetting
+	var pam *pamPlan
+	if opts.PasswordPolicy {
+		// A host already on yescrypt keeps it.
+		strong := map[string]bool{"SHA512": true, "YESCRYPT": true}
+		current := readLoginDefs(hostRoot)
+		for _, p := range loginDefsPolicy {
+			if p.Key == "ENCRYPT_METHOD" && strong[strings.ToUpper(current.EncryptMethod)] {
+				continue
+			}
+			policy = append(policy, p)
+			res.Changes = append(res.Changes, fmt.Sprintf("Set %s %s in /etc/login.defs", p.Key, p.Value))
+		}
+		if runtime.GOOS == "linux" {
+			plan, err := planPAMPolicy(ctx)
+			if err != nil {
+				res.Notes = append(res.Notes, "PAM left unchanged: "+err.Error())
+			} else {
+				pam = plan
+				res.Changes = append(res.Changes, plan.changes()...)
+				res.Notes = append(res.Notes, plan.notes...)
+			}
+		}
+	}
+	if opts.SessionTimeout {
+		res.Changes = append(res.Changes, "Write "+sessionTimeoutPath+" (TMOUT=900)")
+	}
+	var sudoDefaults []string
+	if opts.SudoDefaults {
+		lines, err := secureSudoersPlan()
+		if err != nil {
+			return res, err
+		}
+		sudoDefaults = lines
+		if len(lines) > 0 {
+			res.Changes = append(res.Changes, fmt.Sprintf("Write %s (%s)", sudoersDropIn, strings.Join(lines, "; ")))
+		}
+	}
+	if len(res.Changes) == 0 || opts.DryRun {
+		return res, nil
+	}
+	if !opts.Yes {
+		return res, errors.New("changing the user policy requires --yes (or use --dry-run)")
+	}
+	if runtime.GOOS != "linux" {
+		return res, errors.New("user policy is not supported on this OS")
+	}
+	tx, err := BeginTransaction(opts.RollbackDir, "users policy")
+	if err != nil {
+		return res, err
+	}
+	res.TransactionID = tx.ID()
+	err = func() error {
+		if opts.PasswordPolicy {
+			if err := tx.SnapshotFile("/etc/login.defs"); err != nil {
+				return err
+			}
+			if err := tx.Do("Set password policy in /etc/login.defs", func() error {
+				return setLoginDefs("/etc/login.defs", policy)
+			}); err != nil {
+				return err
+			}
+		}
+		if pam != nil && len(pam.edits) > 0 {
+			if err := applyPAMPlan(ctx, tx, pam); err != nil {
+				return err
+			}
+		}
+		if opts.SessionTimeout {
+			if err := tx.SnapshotFile(sessionTimeoutPath); err != nil {
+				return err
+			}
+			if err := tx.Do("Write "+sessionTimeoutPath, func() error {
+				return os.WriteFile(sessionTimeoutPath, []byte(sessionTimeoutScript), 0o644)
+			}); err != nil {
+				return err
+			}
+		}
+		if len(sudoDefaults) > 0 {
+			if err := tx.SnapshotFile(sudoersDropIn); err != nil {
+				return err
+			}
+			return tx.Do("Write "+sudoersDropIn, func() error {
+				return writeSudoersDropIn(ctx, sudoDefaults)
+			})
+		}
+		return nil
+	}()
+	return res, tx.Finish(ctx, err)
+}
+
+// setLoginDefs sets each key on its first active line, or appends it, and
+// keeps comments and the rest of the file as they are.
+func setLoginDefs(p string, params []loginDefsSetting) error {
+	b, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(b) == 0 {
+		lines = nil
+	}
+	for _, kv := range params {
+		set := false
+		for i, ln := range lines {
+			f := strings.Fields(ln)
+			if len(f) > 0 && f[0] == kv.Key {
+				lines[i] = kv.Key + "\t" + kv.Value
+				set = true
+				break
+			}
+		}
+		if !set {
+			lines = append(lines, kv.Key+"\t"+kv.Value)
+		}
+	}
+	mode := fs.FileMode(0o644)
+	if fi, err := os.Stat(p); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	return os.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
@@ -0,0 +1,775 @@
+package hardening
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultVulnFeedDir holds the vulnerability feeds imported with
+// 'fortis harden package-audit --import'.
+const DefaultVulnFeedDir = "/var/lib/fortis/vulnfeeds"
+
+// Feed formats.
+const (
+	FeedOSV           = "osv"
+	FeedDebianTracker = "debian-tracker"
+	FeedOVAL          = "oval"
+)
+
+// VulnFeedInfo describes one loaded feed file.
+type VulnFeedInfo struct {
+	Path     string    `json:"path" yaml:"path"`
+	Format   string    `json:"format" yaml:"format"`
+	Entries  int       `json:"entries" yaml:"entries"`
+	Modified time.Time `json:"modified" yaml:"modified"`
+}
+
+// vulnRecord is an OSV or security tracker entry: one package of one
+// distribution release and the versions it affects.
+type vulnRecord struct {
+	ID       string
+	CVEs     []string
+	Summary  string
+	Distro   string
+	Release  string
+	Package  string
+	Severity Severity
+	Ranges   []versionRange
+	Versions []string
+	Feed     string
+}
+
+// versionRange is affected from Introduced ("0" or empty: from the first
+// version) up to, not including, Fixed or up to and including LastAffected.
+// Neither set means no fixed version exists yet.
+type versionRange struct {
+	Introduced   string
+	Fixed        string
+	LastAffected string
+}
+
+// affects reports whether version v is affected and the version fixing it.
+func (r vulnRecord) affects(manager, v string) (bool, string) {
+	for _, av := range r.Versions {
+		if comparePackageVersions(manager, v, av) == 0 {
+			return true, r.fixedAfter(manager, v)
+		}
+	}
+	for _, rg := range r.Ranges {
+		if rg.Introduced != "" && rg.Introduced != "0" && comparePackageVersions(manager, v, rg.Introduced) < 0 {
+			continue
+		}
+		switch {
+		case rg.Fixed != "":
+			if comparePackageVersions(manager, v, rg.Fixed) < 0 {
+				return true, rg.Fixed
+			}
+		case rg.LastAffected != "":
+			if comparePackageVersions(manager, v, rg.LastAffected) <= 0 {
+				return true, ""
+			}
+		default:
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+// fixedAfter returns the lowest fixed version above v, for records listing
+// affected versions explicitly.
+func (r vulnRecord) fixedAfter(manager, v string) string {
+	best := ""
+	for _, rg := range r.Ranges {
+		if rg.Fixed != "" && comparePackageVersions(manager, rg.Fixed, v) > 0 && (best == "" || comparePackageVersions(manager, rg.Fixed, best) < 0) {
+			best = rg.Fixed
+		}
+	}
+	return best
+}
+
+// VulnDB holds the loaded feeds.
+type VulnDB struct {
+	Feeds   []VulnFeedInfo
+	records []vulnRecord
+	oval    []*ovalFeed
+}
+
+// LoadVulnFeeds loads feed files, and every feed file in directories,
+// from paths. Files may be gzip or bzip2 compressed; OSV dumps may be zip
+// archives.
+func LoadVulnFeeds(paths []string) (*VulnDB, error) {
+	db := &VulnDB{}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && p == DefaultVulnFeedDir {
+				continue
+			}
+			return nil, err
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, e := range entries {
+				if !e.IsDir() && vulnFeedFile(e.Name()) {
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+		for _, f := range files {
+			if err := db.loadFile(f); err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+		}
+	}
+	return db, nil
+}
+
+func vulnFeedFile(name string) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".bz2")
+	switch filepath.Ext(name) {
+	case ".json", ".xml", ".zip":
+		return true
+	}
+	return false
+}
+
+// Empty reports whether no feed entries were loaded.
+func (db *VulnDB) Empty() bool { return db == nil || len(db.records) == 0 && len(db.oval) == 0 }
+
+func (db *VulnDB) loadFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := path
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		if raw, err = io.ReadAll(zr); err != nil {
+			return err
+		}
+		name = strings.TrimSuffix(name, ".gz")
+	case strings.HasSuffix(name, ".bz2"):
+		if raw, err = io.ReadAll(bzip2.NewReader(bytes.NewReader(raw))); err != nil {
+			return err
+		}
+		name = strings.TrimSuffix(name, ".bz2")
+	}
+	info := VulnFeedInfo{Path: path, Modified: fi.ModTime()}
+	before := len(db.records)
+	switch filepath.Ext(name) {
+	case ".zip":
+		info.Format = FeedOSV
+		if err := db.loadOSVZip(raw, path); err != nil {
+			return err
+		}
+		info.Entries = len(db.records) - before
+	case ".xml":
+		info.Format = FeedOVAL
+		feed, err := parseOVAL(bytes.NewReader(raw), path)
+		if err != nil {
+			return err
+		}
+		db.oval = append(db.oval, feed)
+		info.Entries = len(feed.defs)
+	case ".json":
+		format, err := db.loadJSON(raw, path)
+		if err != nil {
+			return err
+		}
+		info.Format = format
+		info.Entries = len(db.records) - before
+	default:
+		return errors.New("unknown feed format (want .json, .zip or .xml)")
+	}
+	db.Feeds = append(db.Feeds, info)
+	return nil
+}
+
+func (db *VulnDB) loadOSVZip(raw []byte, feed string) error {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		var rec osvRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		db.addOSV(rec, feed)
+	}
+	return nil
+}
+
+// loadJSON accepts an OSV record, an array of OSV records, or the Debian
+// security tracker dump (security-tracker.debian.org/tracker/data/json).
+func (db *VulnDB) loadJSON(raw []byte, feed string) (string, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var recs []osvRecord
+		if err := json.Unmarshal(trimmed, &recs); err != nil {
+			return "", err
+		}
+		for _, r := range recs {
+			db.addOSV(r, feed)
+		}
+		return FeedOSV, nil
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &top); err != nil {
+		return "", err
+	}
+	if _, ok := top["affected"]; ok {
+		var rec osvRecord
+		if err := json.Unmarshal(trimmed, &rec); err != nil {
+			return "", err
+		}
+		db.addOSV(rec, feed)
+		return FeedOSV, nil
+	}
+	var tracker map[string]map[string]debianTrackerEntry
+	if err := json.Unmarshal(trimmed, &tracker); err != nil {
+		return "", fmt.Errorf("neither an OSV record nor a Debian security tracker dump: %w", err)
+	}
+	db.addDebianTracker(tracker, feed)
+	return FeedDebianTracker, nil
+}
+
+// OSV schema (ossf.github.io/osv-schema), the parts used here.
+type osvRecord struct {
+	ID        string        `json:"id"`
+	Aliases   []string      `json:"aliases"`
+	Upstream  []string      `json:"upstream"`
+	Summary   string        `json:"summary"`
+	Details   string        `json:"details"`
+	Withdrawn string        `json:"withdrawn"`
+	Severity  []osvSeverity `json:"severity"`
+	Affected  []osvAffected `json:"affected"`
+	// DatabaseSpecific carries a textual severity in some databases.
+	DatabaseSpecific map[string]any `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string              `json:"type"`
+		Events []map[string]string `json:"events"`
+	} `json:"ranges"`
+	Versions          []string       `json:"versions"`
+	Severity          []osvSeverity  `json:"severity"`
+	EcosystemSpecific map[string]any `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]any `json:"database_specific"`
+}
+
+// osvDistros maps OSV ecosystem names to os-release IDs. Other ecosystems
+// (language package registries) do not describe system packages.
+var osvDistros = map[string]string{
+	"debian":      "debian",
+	"ubuntu":      "ubuntu",
+	"almalinux":   "almalinux",
+	"rocky linux": "rocky",
+	"red hat":     "rhel",
+	"suse":        "sles",
+	"opensuse":    "opensuse-leap",
+	"mageia":      "mageia",
+	"photon os":   "photon",
+	"azure linux": "azurelinux",
+	"alpine":      "alpine",
+}
+
+// parseEcosystem splits "Debian:12", "Ubuntu:Pro:22.04:LTS" or
+// "Alpine:v3.18" into an os-release ID and release.
+func parseEcosystem(eco string) (distro, release string, ok bool) {
+	parts := strings.Split(eco, ":")
+	distro, ok = osvDistros[strings.ToLower(strings.TrimSpace(parts[0]))]
+	if !ok {
+		return "", "", false
+	}
+	for _, p := range parts[1:] {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "v")
+		if p != "" && isDigit(p[0]) {
+			return distro, p, true
+		}
+	}
+	return distro, "", true
+}
+
+func (db *VulnDB) addOSV(rec osvRecord, feed string) {
+	if rec.Withdrawn != "" {
+		return
+	}
+	cves := recordCVEs(rec.ID, append(append([]string{}, rec.Aliases...), rec.Upstream...))
+	summary := rec.Summary
+	if summary == "" {
+		summary = firstLine(rec.Details)
+	}
+	for _, a := range rec.Affected {
+		distro, release, ok := parseEcosystem(a.Package.Ecosystem)
+		if !ok || a.Package.Name == "" {
+			continue
+		}
+		r := vulnRecord{ID: rec.ID, CVEs: cves, Summary: summary, Distro: distro, Release: release, Package: a.Package.Name, Versions: a.Versions, Feed: feed}
+		r.Severity = osvSeverityOf(rec, a)
+		for _, rg := range a.Ranges {
+			if rg.Type != "ECOSYSTEM" {
+				continue
+			}
+			cur := versionRange{}
+			open := false
+			for _, ev := range rg.Events {
+				switch {
+				case ev["introduced"] != "":
+					if open {
+						r.Ranges = append(r.Ranges, cur)
+					}
+					cur, open = versionRange{Introduced: ev["introduced"]}, true
+				case ev["fixed"] != "":
+					cur.Fixed = ev["fixed"]
+					r.Ranges = append(r.Ranges, cur)
+					open = false
+				case ev["last_affected"] != "":
+					cur.LastAffected = ev["last_affected"]
+					r.Ranges = append(r.Ranges, cur)
+					open = false
+				}
+			}
+			if open {
+				r.Ranges = append(r.Ranges, cur)
+			}
+		}
+		if len(r.Ranges) == 0 && len(r.Versions) == 0 {
+			continue
+		}
+		db.records = append(db.records, r)
+	}
+}
+
+// osvSeverityOf prefers a CVSS v3 vector, then the textual severity or
+// urgency of the affected package or the record. Entries without any are
+// rated medium.
+func osvSeverityOf(rec osvRecord, a osvAffected) Severity {
+	for _, list := range [][]osvSeverity{a.Severity, rec.Severity} {
+		for _, s := range list {
+			switch s.Type {
+			case "CVSS_V3":
+				if score, err := cvss3BaseScore(s.Score); err == nil {
+					return cvssSeverity(score)
+				}
+			case "Ubuntu":
+				if sev, ok := textSeverity(s.Score); ok {
+					return sev
+				}
+			}
+		}
+	}
+	for _, m := range []map[string]any{a.EcosystemSpecific, a.DatabaseSpecific, rec.DatabaseSpecific} {
+		for _, k := range []string{"severity", "urgency"} {
+			if v, ok := m[k].(string); ok {
+				if sev, ok := textSeverity(v); ok {
+					return sev
+				}
+			}
+		}
+	}
+	return SeverityMedium
+}
+
+// textSeverity maps the severity words of distribution trackers.
+func textSeverity(s string) (Severity, bool) {
+	switch strings.ToLower(strings.TrimRight(strings.TrimSpace(s), "*")) {
+	case "critical":
+		return SeverityCritical, true
+	case "high", "important":
+		return SeverityHigh, true
+	case "medium", "moderate":
+		return SeverityMedium, true
+	case "low", "negligible", "unimportant":
+		return SeverityLow, true
+	}
+	return "", false
+}
+
+func cvssSeverity(score float64) Severity {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	}
+	return SeverityLow
+}
+
+// cvss3BaseScore computes the base score of a CVSS v3.0/v3.1 vector.
+func cvss3BaseScore(vector string) (float64, error) {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return 0, fmt.Errorf("not a CVSS v3 vector: %q", vector)
+	}
+	m := map[string]string{}
+	for _, part := range strings.Split(vector, "/")[1:] {
+		k, v, _ := strings.Cut(part, ":")
+		m[k] = v
+	}
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	val := map[string]float64{}
+	for k, w := range weights {
+		v, ok := w[m[k]]
+		if !ok {
+			return 0, fmt.Errorf("CVSS vector %q: bad or missing %s", vector, k)
+		}
+		val[k] = v
+	}
+	changed := m["S"] == "C"
+	if m["S"] != "U" && !changed {
+		return 0, fmt.Errorf("CVSS vector %q: bad or missing S", vector)
+	}
+	pr := map[string]float64{"N": 0.85, "L": 0.62, "H": 0.27}
+	if changed {
+		pr = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+	prv, ok := pr[m["PR"]]
+	if !ok {
+		return 0, fmt.Errorf("CVSS vector %q: bad or missing PR", vector)
+	}
+	iss := 1 - (1-val["C"])*(1-val["I"])*(1-val["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	expl := 8.22 * val["AV"] * val["AC"] * prv * val["UI"]
+	score := impact + expl
+	if changed {
+		score *= 1.08
+	}
+	return cvssRoundUp(math.Min(score, 10)), nil
+}
+
+// cvssRoundUp is the Roundup function of the CVSS v3.1 specification.
+func cvssRoundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+type debianTrackerEntry struct {
+	Description string `json:"description"`
+	Releases    map[string]struct {
+		Status       string `json:"status"`
+		FixedVersion string `json:"fixed_version"`
+		Urgency      string `json:"urgency"`
+	} `json:"releases"`
+}
+
+func (db *VulnDB) addDebianTracker(tracker map[string]map[string]debianTrackerEntry, feed string) {
+	for pkg, entries := range tracker {
+		for id, e := range entries {
+			for release, rel := range e.Releases {
+				r := vulnRecord{ID: id, CVEs: recordCVEs(id, nil), Summary: firstLine(e.Description), Distro: "debian", Release: release, Package: pkg, Feed: feed}
+				switch rel.Status {
+				case "resolved":
+					// A fixed version of 0 means the release was never affected.
+					if rel.FixedVersion == "" || rel.FixedVersion == "0" {
+						continue
+					}
+					r.Ranges = []versionRange{{Fixed: rel.FixedVersion}}
+				case "open":
+					r.Ranges = []versionRange{{}}
+				default:
+					continue
+				}
+				if strings.HasPrefix(rel.Urgency, "end-of-life") {
+					continue
+				}
+				r.Severity = SeverityMedium
+				if sev, ok := textSeverity(rel.Urgency); ok {
+					r.Severity = sev
+				}
+				db.records = append(db.records, r)
+			}
+		}
+	}
+}
+
+// recordCVEs returns the CVE IDs of an entry: its own ID when it is one,
+// plus CVE aliases.
+func recordCVEs(id string, aliases []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, a := range append([]string{id}, aliases...) {
+		if strings.HasPrefix(a, "CVE-") && !seen[a] {
+			seen[a] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if len(s) > 200 {
+		s = s[:197] + "..."
+	}
+	return s
+}
+
+// ovalFeed is an OVAL definitions document (Red Hat, Debian, Ubuntu or
+// SUSE). Only rpminfo and dpkginfo tests comparing the package EVR with
+// "less than" are evaluated; every other test (platform, signature key)
+// is assumed true, since the feed is expected to be the one for the
+// host's distribution.
+type ovalFeed struct {
+	path    string
+	defs    []ovalDefinition
+	tests   map[string]ovalTest
+	objects map[string]string
+	states  map[string]ovalState
+}
+
+type ovalDefinition struct {
+	ID       string       `xml:"id,attr"`
+	Class    string       `xml:"class,attr"`
+	Title    string       `xml:"metadata>title"`
+	Refs     []ovalRef    `xml:"metadata>reference"`
+	Severity string       `xml:"metadata>advisory>severity"`
+	CVEs     []ovalCVE    `xml:"metadata>advisory>cve"`
+	Criteria ovalCriteria `xml:"criteria"`
+}
+
+type ovalRef struct {
+	Source string `xml:"source,attr"`
+	RefID  string `xml:"ref_id,attr"`
+}
+
+type ovalCVE struct {
+	CVSS3 string `xml:"cvss3,attr"`
+	ID    string `xml:",chardata"`
+}
+
+type ovalCriteria struct {
+	Operator  string          `xml:"operator,attr"`
+	Negate    bool            `xml:"negate,attr"`
+	Criteria  []ovalCriteria  `xml:"criteria"`
+	Criterion []ovalCriterion `xml:"criterion"`
+}
+
+type ovalCriterion struct {
+	TestRef string `xml:"test_ref,attr"`
+	Negate  bool   `xml:"negate,attr"`
+}
+
+type ovalTest struct {
+	XMLName xml.Name
+	ID      string `xml:"id,attr"`
+	Object  struct {
+		Ref string `xml:"object_ref,attr"`
+	} `xml:"object"`
+	State struct {
+		Ref string `xml:"state_ref,attr"`
+	} `xml:"state"`
+}
+
+type ovalObject struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name"`
+}
+
+type ovalState struct {
+	ID  string `xml:"id,attr"`
+	EVR struct {
+		Operation string `xml:"operation,attr"`
+		Value     string `xml:",chardata"`
+	} `xml:"evr"`
+}
+
+// parseOVAL streams the document so multi-hundred-megabyte feeds are not
+// held as a DOM.
+func parseOVAL(r io.Reader, path string) (*ovalFeed, error) {
+	feed := &ovalFeed{path: path, tests: map[string]ovalTest{}, objects: map[string]string{}, states: map[string]ovalState{}}
+	dec := xml.NewDecoder(r)
+	seenRoot := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		name := se.Name.Local
+		switch {
+		case name == "oval_definitions":
+			seenRoot = true
+		case name == "definition":
+			var d ovalDefinition
+			if err := dec.DecodeElement(&d, &se); err != nil {
+				return nil, err
+			}
+			feed.defs = append(feed.defs, d)
+		case strings.HasSuffix(name, "_test"):
+			var t ovalTest
+			if err := dec.DecodeElement(&t, &se); err != nil {
+				return nil, err
+			}
+			feed.tests[t.ID] = t
+		case strings.HasSuffix(name, "_object"):
+			var o ovalObject
+			if err := dec.DecodeElement(&o, &se); err != nil {
+				return nil, err
+			}
+			feed.objects[o.ID] = o.Name
+		case strings.HasSuffix(name, "_state"):
+			var s ovalState
+			if err := dec.DecodeElement(&s, &se); err != nil {
+				return nil, err
+			}
+			feed.states[s.ID] = s
+		}
+	}
+	if !seenRoot {
+		return nil, errors.New("not an OVAL definitions document")
+	}
+	return feed, nil
+}
+
+// ovalHit is a package test that matched: the package is installed in a
+// version lower than Fixed.
+type ovalHit struct {
+	Package string
+	Fixed   string
+}
+
+func (f *ovalFeed) evalCriteria(c ovalCriteria, inv *PackageInventory, hits *[]ovalHit) bool {
+	var results []bool
+	for _, sub := range c.Criteria {
+		results = append(results, f.evalCriteria(sub, inv, hits))
+	}
+	for _, cr := range c.Criterion {
+		results = append(results, f.evalCriterion(cr, inv, hits))
+	}
+	var out bool
+	switch strings.ToUpper(c.Operator) {
+	case "OR", "ONE", "XOR":
+		for _, r := range results {
+			out = out || r
+		}
+	default:
+		out = true
+		for _, r := range results {
+			out = out && r
+		}
+	}
+	return out != c.Negate
+}
+
+func (f *ovalFeed) evalCriterion(cr ovalCriterion, inv *PackageInventory, hits *[]ovalHit) bool {
+	t, ok := f.tests[cr.TestRef]
+	if !ok {
+		return !cr.Negate
+	}
+	kind := t.XMLName.Local
+	st := f.states[t.State.Ref]
+	if (kind != "rpminfo_test" && kind != "dpkginfo_test") || st.EVR.Operation != "less than" || st.EVR.Value == "" {
+		return !cr.Negate
+	}
+	name := f.objects[t.Object.Ref]
+	matched := false
+	for _, p := range inv.lookup(name) {
+		if comparePackageVersions(inv.Manager, p.versionFor(name), st.EVR.Value) < 0 {
+			matched = true
+			*hits = append(*hits, ovalHit{Package: name, Fixed: strings.TrimPrefix(st.EVR.Value, "0:")})
+			break
+		}
+	}
+	return matched != cr.Negate
+}
+
+func (d ovalDefinition) cves() []string {
+	var ids []string
+	for _, c := range d.CVEs {
+		ids = append(ids, strings.TrimSpace(c.ID))
+	}
+	for _, r := range d.Refs {
+		if r.Source == "CVE" {
+			ids = append(ids, r.RefID)
+		}
+	}
+	return recordCVEs("", ids)
+}
+
+func (d ovalDefinition) severity() Severity {
+	if sev, ok := textSeverity(d.Severity); ok {
+		return sev
+	}
+	for _, c := range d.CVEs {
+		_, vector, _ := strings.Cut(c.CVSS3, "/")
+		if score, err := cvss3BaseScore(vector); err == nil {
+			return cvssSeverity(score)
+		}
+		if score, err := strconv.ParseFloat(strings.Split(c.CVSS3, "/")[0], 64); err == nil && c.CVSS3 != "" {
+			return cvssSeverity(score)
+		}
+	}
+	return SeverityMedium
+}
@@ -0,0 +1,223 @@
+package hardening
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testOSVFeed = `[
+  {
+    "id": "DSA-5001-1",
+    "aliases": ["CVE-2024-0001"],
+    "summary": "openssl: buffer overflow",
+    "severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}],
+    "affected": [{
+      "package": {"ecosystem": "Debian:12", "name": "openssl"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "3.0.11-1~deb12u2"}]}]
+    }]
+  },
+  {
+    "id": "CVE-2024-0002",
+    "summary": "zlib: no fix yet",
+    "affected": [{
+      "package": {"ecosystem": "Debian:12", "name": "zlib"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "1:1.2.13"}]}]
+    }]
+  },
+  {
+    "id": "CVE-2024-0003",
+    "withdrawn": "2024-02-01T00:00:00Z",
+    "affected": [{
+      "package": {"ecosystem": "Debian:12", "name": "openssl"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+    }]
+  },
+  {
+    "id": "CVE-2024-0004",
+    "affected": [{
+      "package": {"ecosystem": "Debian:11", "name": "openssl"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+    }]
+  }
+]
+`
+
+const testDebianTracker = `{
+  "glibc": {
+    "CVE-2024-0010": {
+      "description": "glibc: stack overflow",
+      "releases": {
+        "bookworm": {"status": "resolved", "fixed_version": "2.36-9+deb12u4", "urgency": "high"},
+        "bullseye": {"status": "open", "urgency": "low"}
+      }
+    },
+    "CVE-2024-0011": {
+      "releases": {"bookworm": {"status": "resolved", "fixed_version": "0", "urgency": "not yet assigned"}}
+    },
+    "CVE-2024-0012": {
+      "releases": {"bookworm": {"status": "open", "urgency": "end-of-life"}}
+    }
+  }
+}
+`
+
+const testOVALFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+  <definitions>
+    <definition class="patch" id="oval:com.redhat.rhsa:def:20241234">
+      <metadata>
+        <title>RHSA-2024:1234: sudo security update (Important)</title>
+        <reference ref_id="RHSA-2024:1234" source="RHSA"/>
+        <reference ref_id="CVE-2024-0020" source="CVE"/>
+        <advisory><severity>Important</severity></advisory>
+      </metadata>
+      <criteria operator="AND">
+        <criterion test_ref="oval:test:1"/>
+        <criteria operator="OR">
+          <criterion test_ref="oval:test:2"/>
+          <criterion test_ref="oval:test:3"/>
+        </criteria>
+      </criteria>
+    </definition>
+  </definitions>
+  <tests>
+    <red-def:rpminfo_test xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:test:1">
+      <red-def:object object_ref="oval:obj:release"/>
+    </red-def:rpminfo_test>
+    <red-def:rpminfo_test xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:test:2">
+      <red-def:object object_ref="oval:obj:sudo"/>
+      <red-def:state state_ref="oval:ste:sudo"/>
+    </red-def:rpminfo_test>
+    <red-def:rpminfo_test xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:test:3">
+      <red-def:object object_ref="oval:obj:sudo-python"/>
+      <red-def:state state_ref="oval:ste:sudo"/>
+    </red-def:rpminfo_test>
+  </tests>
+  <objects>
+    <red-def:rpminfo_object xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:obj:release"><red-def:name>redhat-release</red-def:name></red-def:rpminfo_object>
+    <red-def:rpminfo_object xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:obj:sudo"><red-def:name>sudo</red-def:name></red-def:rpminfo_object>
+    <red-def:rpminfo_object xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:obj:sudo-python"><red-def:name>sudo-python-plugin</red-def:name></red-def:rpminfo_object>
+  </objects>
+  <states>
+    <red-def:rpminfo_state xmlns:red-def="http://oval.mitre.org/XMLSchema/oval-definitions-5#linux" id="oval:ste:sudo">
+      <red-def:evr datatype="evr_string" operation="less than">0:1.9.5p2-10.el9_3</red-def:evr>
+    </red-def:rpminfo_state>
+  </states>
+</oval_definitions>
+`
+
+// loadTestFeed writes content to a feed file named name and loads it.
+func loadTestFeed(t *testing.T, name, content string) *VulnDB {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := LoadVulnFeeds([]string{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// vulnKeys renders matches as "ID package installed fixed severity",
+// sorted, for comparison.
+func vulnKeys(vulns []PackageVuln) []string {
+	var out []string
+	for _, v := range vulns {
+		out = append(out, strings.Join([]string{v.ID, v.Package, strings.Join(v.Installed, ","), v.InstalledVersion, v.FixedVersion, string(v.Severity)}, " "))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestVulnDBMatch(t *testing.T) {
+	bookworm := func(pkgs ...InstalledPackage) *PackageInventory {
+		return &PackageInventory{Manager: "dpkg", Distro: "debian", Release: "12", Codename: "bookworm", Packages: pkgs}
+	}
+	rhel := func(pkgs ...InstalledPackage) *PackageInventory {
+		return &PackageInventory{Manager: "rpm", Distro: "rhel", Release: "9.3", Packages: pkgs}
+	}
+	tests := []struct {
+		name   string
+		file   string
+		feed   string
+		format string
+		inv    *PackageInventory
+		want   []string
+	}{
+		{
+			name: "osv affected before the fix",
+			file: "osv.json", feed: testOSVFeed, format: FeedOSV,
+			inv: bookworm(
+				InstalledPackage{Name: "libssl3", Version: "3.0.11-1~deb12u1", Source: "openssl"},
+				InstalledPackage{Name: "openssl", Version: "3.0.11-1~deb12u1"},
+				InstalledPackage{Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Source: "zlib"},
+			),
+			want: []string{
+				"CVE-2024-0001 openssl libssl3,openssl 3.0.11-1~deb12u1 3.0.11-1~deb12u2 critical",
+				"CVE-2024-0002 zlib zlib1g 1:1.2.13.dfsg-1  medium",
+			},
+		},
+		{
+			name: "osv fixed version installed",
+			file: "osv.json", feed: testOSVFeed, format: FeedOSV,
+			inv: bookworm(
+				InstalledPackage{Name: "openssl", Version: "3.0.11-1~deb12u2"},
+				InstalledPackage{Name: "zlib1g", Version: "1:1.2.12-1", Source: "zlib"},
+			),
+		},
+		{
+			name: "osv entries for another release are ignored",
+			file: "osv.json", feed: testOSVFeed, format: FeedOSV,
+			inv: &PackageInventory{Manager: "dpkg", Distro: "debian", Release: "13", Codename: "trixie", Packages: []InstalledPackage{{Name: "openssl", Version: "3.0.0-1"}}},
+		},
+		{
+			name: "debian tracker by codename and source version",
+			file: "tracker.json", feed: testDebianTracker, format: FeedDebianTracker,
+			inv: bookworm(
+				InstalledPackage{Name: "libc6", Version: "2.36-9+deb12u3+b1", Source: "glibc", SourceVersion: "2.36-9+deb12u3"},
+			),
+			want: []string{"CVE-2024-0010 glibc libc6 2.36-9+deb12u3 2.36-9+deb12u4 high"},
+		},
+		{
+			name: "debian tracker fixed",
+			file: "tracker.json", feed: testDebianTracker, format: FeedDebianTracker,
+			inv: bookworm(
+				InstalledPackage{Name: "libc6", Version: "2.36-9+deb12u4", Source: "glibc"},
+			),
+		},
+		{
+			name: "oval criteria on an older build",
+			file: "rhel.xml", feed: testOVALFeed, format: FeedOVAL,
+			inv: rhel(
+				InstalledPackage{Name: "redhat-release", Version: "9.3-0.5.el9"},
+				InstalledPackage{Name: "sudo", Version: "1.9.5p2-9.el9"},
+			),
+			want: []string{"CVE-2024-0020 sudo sudo 1.9.5p2-9.el9 1.9.5p2-10.el9_3 high"},
+		},
+		{
+			name: "oval criteria on the fixed build",
+			file: "rhel.xml", feed: testOVALFeed, format: FeedOVAL,
+			inv: rhel(
+				InstalledPackage{Name: "redhat-release", Version: "9.3-0.5.el9"},
+				InstalledPackage{Name: "sudo", Version: "1.9.5p2-10.el9_3"},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := loadTestFeed(t, tt.file, tt.feed)
+			if len(db.Feeds) != 1 || db.Feeds[0].Format != tt.format {
+				t.Fatalf("feeds = %+v, want one %s feed", db.Feeds, tt.format)
+			}
+			got := vulnKeys(db.Match(tt.inv))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("matches:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package hardening
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WaiversFileName is read from the config directory when no waivers file is
+// given. A missing file means no waivers.
+const WaiversFileName = "waivers.yaml"
+
+// Waiver accepts the risk of a failing check on selected hosts until it
+// expires. Check is a check ID glob; Hosts are hostname globs and Groups
+// inventory groups. A waiver without hosts or groups applies everywhere.
+type Waiver struct {
+	Check         string   `yaml:"check" json:"check"`
+	Hosts         []string `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	Groups        []string `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Justification string   `yaml:"justification" json:"justification"`
+	Approver      string   `yaml:"approver" json:"approver"`
+	// Expires is a date (valid through the end of that day, local time)
+	// or an RFC 3339 timestamp.
+	Expires string `yaml:"expires" json:"expires"`
+	Ticket  string `yaml:"ticket,omitempty" json:"ticket,omitempty"`
+
+	expiresAt time.Time
+	source    string
+}
+
+type waiverFile struct {
+	Waivers []Waiver `yaml:"waivers"`
+}
+
+// AppliedWaiver records the waiver matched to a finding. Result is what the
+// check reported; an expired waiver leaves the finding's result unchanged.
+type AppliedWaiver struct {
+	Check         string `json:"check" yaml:"check"`
+	Justification string `json:"justification" yaml:"justification"`
+	Approver      string `json:"approver" yaml:"approver"`
+	Expires       string `json:"expires" yaml:"expires"`
+	Ticket        string `json:"ticket,omitempty" yaml:"ticket,omitempty"`
+	Expired       bool   `json:"expired,omitempty" yaml:"expired,omitempty"`
+	Result        Result `json:"result" yaml:"result"`
+	Source        string `json:"source" yaml:"source"`
+}
+
+func (w Waiver) ExpiresAt() time.Time { return w.expiresAt }
+
+func (w Waiver) Expired(now time.Time) bool { return !now.Before(w.expiresAt) }
+
+func parseWaiverExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expires %q: want YYYY-MM-DD or RFC 3339", s)
+}
+
+func (w *Waiver) validate() error {
+	if strings.TrimSpace(w.Check) == "" {
+		return errors.New("check is required")
+	}
+	if _, err := path.Match(w.Check, ""); err != nil {
+		return fmt.Errorf("bad check pattern %q", w.Check)
+	}
+	for _, h := range w.Hosts {
+		if _, err := path.Match(h, ""); err != nil {
+			return fmt.Errorf("bad host pattern %q", h)
+		}
+	}
+	if strings.TrimSpace(w.Justification) == "" {
+		return errors.New("justification is required")
+	}
+	if strings.TrimSpace(w.Approver) == "" {
+		return errors.New("approver is required")
+	}
+	if strings.TrimSpace(w.Expires) == "" {
+		return errors.New("expires is required; waivers cannot be permanent")
+	}
+	t, err := parseWaiverExpiry(w.Expires)
+	if err != nil {
+		return err
+	}
+	w.expiresAt = t
+	return nil
+}
+
+// LoadWaivers reads a waivers file. An empty path reads
+// <configDir>/waivers.yaml and tolerates its absence.
+func LoadWaivers(configDir, file string) ([]Waiver, error) {
+	p := file
+	if p == "" {
+		p = filepath.Join(resolveConfigDir(configDir), WaiversFileName)
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if file == "" && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var wf waiverFile
+	if err := yaml.Unmarshal(b, &wf); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	for i := range wf.Waivers {
+		w := &wf.Waivers[i]
+		if err := w.validate(); err != nil {
+			return nil, fmt.Errorf("%s: waiver %d (%s): %w", p, i+1, w.Check, err)
+		}
+		w.source = p
+	}
+	return wf.Waivers, nil
+}
+
+// Selects reports whether the waiver covers checkID on host.
+func (w Waiver) Selects(checkID, host string, groups []string) bool {
+	if ok, _ := path.Match(w.Check, checkID); !ok {
+		return false
+	}
+	return w.AppliesTo(host, groups)
+}
+
+// AppliesTo reports whether the waiver's host and group selectors match.
+// Hostnames match by full name or by the short name before the first dot.
+func (w Waiver) AppliesTo(host string, groups []string) bool {
+	if len(w.Hosts) == 0 && len(w.Groups) == 0 {
+		return true
+	}
+	short, _, _ := strings.Cut(host, ".")
+	for _, h := range w.Hosts {
+		if ok, _ := path.Match(h, host); ok {
+			return true
+		}
+		if ok, _ := path.Match(h, short); ok {
+			return true
+		}
+	}
+	for _, g := range w.Groups {
+		for _, hg := range groups {
+			if g == hg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchWaiver picks the waiver for a check: an active waiver wins over an
+// expired one, and among several the one expiring last.
+func matchWaiver(waivers []Waiver, checkID, host string, groups []string, now time.Time) (Waiver, bool) {
+	var best Waiver
+	found := false
+	for _, w := range waivers {
+		if !w.Selects(checkID, host, groups) {
+			continue
+		}
+		if !found || (!w.Expired(now) && best.Expired(now)) ||
+			(w.Expired(now) == best.Expired(now) && w.expiresAt.After(best.expiresAt)) {
+			best, found = w, true
+		}
+	}
+	return best, found
+}
+
+// applyWaiver marks a failing or warning finding as waived while the waiver
+// is active. An expired waiver is recorded but the finding keeps its result.
+func applyWaiver(f *Finding, w Waiver, now time.Time) {
+	if f.Result != ResultFail && f.Result != ResultWarn {
+		return
+	}
+	f.Waiver = &AppliedWaiver{
+		Check:         w.Check,
+		Justification: w.Justification,
+		Approver:      w.Approver,
+		Expires:       w.Expires,
+		Ticket:        w.Ticket,
+		Expired:       w.Expired(now),
+		Result:        f.Result,
+		Source:        w.source,
+	}
+	if !f.Waiver.Expired {
+		f.Result = ResultWaived
+	}
+}
+
+// waiverNote is the one-line description renderers add to a finding's
+// details.
+func waiverNote(aw *AppliedWaiver) string {
+	if aw == nil {
+		return ""
+	}
+	s := fmt.Sprintf("waived (%s) until %s, approved by %s: %s", aw.Result, aw.Expires, aw.Approver, aw.Justification)
+	if aw.Expired {
+		s = fmt.Sprintf("waiver expired %s (approved by %s)", aw.Expires, aw.Approver)
+	}
+	if aw.Ticket != "" {
+		s += " [" + aw.Ticket + "]"
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package hardening
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaiverExpiry(t *testing.T) {
+	tests := []struct {
+		expires string
+		now     time.Time
+		expired bool
+	}{
+		{"2026-10-18", time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), false},
+		{"2026-10-18", time.Date(2026, 10, 18, 23, 59, 59, 0, time.Local), false},
+		{"2026-10-18", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local), true},
+		{"2026-10-18T12:00:00Z", time.Date(2026, 10, 18, 11, 59, 59, 0, time.UTC), false},
+		{"2026-10-18T12:00:00Z", time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), true},
+		{"2026-10-18T14:00:00+02:00", time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		w := Waiver{Check: "ssh.*", Justification: "j", Approver: "a", Expires: tt.expires}
+		if err := w.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.expires, err)
+		}
+		if got := w.Expired(tt.now); got != tt.expired {
+			t.Errorf("expires %s at %s: expired = %t, want %t", tt.expires, tt.now, got, tt.expired)
+		}
+	}
+}
+
+func TestLoadWaivers(t *testing.T) {
+	dir := t.TempDir()
+	if ws, err := LoadWaivers(dir, ""); err != nil || ws != nil {
+		t.Fatalf("missing default file: %v, %v; want no waivers", ws, err)
+	}
+	if _, err := LoadWaivers(dir, filepath.Join(dir, "absent.yaml")); err == nil {
+		t.Fatal("missing explicit file was not reported")
+	}
+
+	valid := `waivers:
+  - check: ssh.*
+    hosts: [web*]
+    justification: legacy clients
+    approver: sec-team
+    expires: 2026-12-31
+    ticket: SEC-1
+`
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"valid", valid, ""},
+		{"no check", "waivers:\n  - justification: j\n    approver: a\n    expires: 2026-12-31\n", "check is required"},
+		{"no justification", "waivers:\n  - check: x\n    approver: a\n    expires: 2026-12-31\n", "justification is required"},
+		{"no approver", "waivers:\n  - check: x\n    justification: j\n    expires: 2026-12-31\n", "approver is required"},
+		{"permanent", "waivers:\n  - check: x\n    justification: j\n    approver: a\n", "cannot be permanent"},
+		{"bad expiry", "waivers:\n  - check: x\n    justification: j\n    approver: a\n    expires: next year\n", "want YYYY-MM-DD or RFC 3339"},
+		{"bad check pattern", "waivers:\n  - check: 'ssh.[a'\n    justification: j\n    approver: a\n    expires: 2026-12-31\n", "bad check pattern"},
+		{"bad host pattern", "waivers:\n  - check: x\n    hosts: ['web[']\n    justification: j\n    approver: a\n    expires: 2026-12-31\n", "bad host pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, WaiversFileName)
+			if err := os.WriteFile(p, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			ws, err := LoadWaivers(dir, "")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ws) != 1 || ws[0].source != p || ws[0].Ticket != "SEC-1" {
+				t.Fatalf("waivers = %+v", ws)
+			}
+			if want := time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local); !ws[0].ExpiresAt().Equal(want) {
+				t.Errorf("expires at %s, want %s", ws[0].ExpiresAt(), want)
+			}
+		})
+	}
+}
+
+func TestWaiverSelectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		w      Waiver
+		check  string
+		host   string
+		groups []string
+		want   bool
+	}{
+		{"everywhere", Waiver{Check: "ssh.root_login"}, "ssh.root_login", "db1", nil, true},
+		{"other check", Waiver{Check: "ssh.root_login"}, "ssh.password_auth", "db1", nil, false},
+		{"check glob", Waiver{Check: "ssh.*"}, "ssh.password_auth", "db1", nil, true},
+		{"check glob spans dots", Waiver{Check: "ssh*"}, "sshd.x", "db1", nil, true},
+		{"host glob", Waiver{Check: "*", Hosts: []string{"web*"}}, "x", "web3", nil, true},
+		{"host glob misses", Waiver{Check: "*", Hosts: []string{"web*"}}, "x", "db1", nil, false},
+		{"short name", Waiver{Check: "*", Hosts: []string{"web3"}}, "x", "web3.example.com", nil, true},
+		{"full name", Waiver{Check: "*", Hosts: []string{"*.dmz.example.com"}}, "x", "web3.dmz.example.com", nil, true},
+		{"full name misses", Waiver{Check: "*", Hosts: []string{"*.dmz.example.com"}}, "x", "web3.lan.example.com", nil, false},
+		{"group", Waiver{Check: "*", Groups: []string{"legacy"}}, "x", "db1", []string{"db", "legacy"}, true},
+		{"group misses", Waiver{Check: "*", Groups: []string{"legacy"}}, "x", "db1", []string{"db"}, false},
+		{"group without host groups", Waiver{Check: "*", Groups: []string{"legacy"}}, "x", "db1", nil, false},
+		{"host or group", Waiver{Check: "*", Hosts: []string{"web*"}, Groups: []string{"legacy"}}, "x", "db1", []string{"legacy"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.w.Selects(tt.check, tt.host, tt.groups); got != tt.want {
+			t.Errorf("%s: Selects(%q, %q, %v) = %t, want %t", tt.name, tt.check, tt.host, tt.groups, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAndApplyWaiver(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	waiver := func(check, expires string, hosts ...string) Waiver {
+		w := Waiver{Check: check, Hosts: hosts, Justification: "because " + expires, Approver: "sec", Expires: expires}
+		if err := w.validate(); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	waivers := []Waiver{
+		waiver("ssh.*", "2026-10-01"),
+		waiver("ssh.root_login", "2026-11-30"),
+		waiver("ssh.root_login", "2026-12-31", "web*"),
+		waiver("ssh.password_auth", "2026-09-30"),
+		waiver("ssh.password_auth", "2026-10-17"),
+	}
+	tests := []struct {
+		check   string
+		host    string
+		expires string
+	}{
+		{"ssh.root_login", "db1", "2026-11-30"},
+		{"ssh.root_login", "web1", "2026-12-31"},
+		// Only expired waivers: the one expiring last is reported.
+		{"ssh.password_auth", "db1", "2026-10-17"},
+		{"ssh.ciphers", "db1", "2026-10-01"},
+		{"kernel.aslr", "db1", ""},
+	}
+	for _, tt := range tests {
+		w, ok := matchWaiver(waivers, tt.check, tt.host, nil, now)
+		if ok != (tt.expires != "") || w.Expires != tt.expires {
+			t.Errorf("matchWaiver(%s, %s) = %q, %t; want %q", tt.check, tt.host, w.Expires, ok, tt.expires)
+		}
+	}
+
+	active, expired := waivers[1], waivers[0]
+	for _, tt := range []struct {
+		result Result
+		w      Waiver
+		want   Result
+		note   string
+	}{
+		{ResultFail, active, ResultWaived, "waived (fail) until 2026-11-30, approved by sec: because 2026-11-30"},
+		{ResultWarn, active, ResultWaived, "waived (warn) until 2026-11-30"},
+		{ResultFail, expired, ResultFail, "waiver expired 2026-10-01 (approved by sec)"},
+		{ResultPass, active, ResultPass, ""},
+		{ResultSkip, active, ResultSkip, ""},
+	} {
+		f := Finding{Result: tt.result}
+		applyWaiver(&f, tt.w, now)
+		if f.Result != tt.want {
+			t.Errorf("%s with waiver until %s: result %s, want %s", tt.result, tt.w.Expires, f.Result, tt.want)
+		}
+		if note := waiverNote(f.Waiver); !strings.HasPrefix(note, tt.note) || (tt.note == "") != (note == "") {
+			t.Errorf("%s with waiver until %s: note %q, want %q", tt.result, tt.w.Expires, note, tt.note)
+		}
+		if f.Waiver != nil && f.Waiver.Result != tt.result {
+			t.Errorf("recorded result %s, want %s", f.Waiver.Result, tt.result)
+		}
+	}
+}
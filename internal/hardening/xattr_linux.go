@@ -0,0 +1,56 @@
+//go:build linux
+
+package hardening
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// readXattrs returns a file's extended attributes as sorted
+// name=base64(value) pairs joined by ",". Filesystems without xattr
+// support have none.
+func readXattrs(p string) (string, error) {
+	size, err := syscall.Listxattr(p, nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return "", nil
+		}
+		return "", &fs.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+	if size == 0 {
+		return "", nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(p, buf)
+	if err != nil {
+		return "", &fs.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+	var names []string
+	for _, n := range strings.Split(string(buf[:size]), "\x00") {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		vsize, err := syscall.Getxattr(p, n, nil)
+		if err != nil {
+			return "", &fs.PathError{Op: "getxattr", Path: p, Err: err}
+		}
+		val := make([]byte, vsize)
+		if vsize > 0 {
+			vsize, err = syscall.Getxattr(p, n, val)
+			if err != nil {
+				return "", &fs.PathError{Op: "getxattr", Path: p, Err: err}
+			}
+		}
+		pairs = append(pairs, n+"="+base64.StdEncoding.EncodeToString(val[:vsize]))
+	}
+	return strings.Join(pairs, ","), nil
+}
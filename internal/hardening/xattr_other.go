@@ -0,0 +1,8 @@
+//go:build !linux
+
+package hardening
+
+// readXattrs is only implemented on Linux.
+func readXattrs(p string) (string, error) {
+	return "", nil
+}
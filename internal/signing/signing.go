@@ -0,0 +1,175 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Algorithm is the algorithm name recorded in signature files.
+const Algorithm = "ed25519"
+
+var (
+	// ErrUntrusted means no trusted key has the signature's key ID.
+	ErrUntrusted = errors.New("signed by an untrusted key")
+	// ErrInvalid means the signature does not verify.
+	ErrInvalid = errors.New("invalid signature")
+)
+
+// GenerateKey writes a new key pair as PEM (PKCS#8 private, PKIX public)
+// and returns its key ID. Existing key files are only replaced with force,
+// since losing a private key orphans everything it signed.
+func GenerateKey(privPath, pubPath string, force bool) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range []string{privPath, pubPath} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return "", err
+		}
+	}
+	if !force {
+		for _, p := range []string{privPath, pubPath} {
+			if _, err := os.Lstat(p); err == nil {
+				return "", fmt.Errorf("%s already exists; use --force to replace the key", p)
+			}
+		}
+	}
+	if err := writeKeyFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600, force); err != nil {
+		return "", err
+	}
+	if err := writeKeyFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644, force); err != nil {
+		return "", err
+	}
+	return KeyID(pub), nil
+}
+
+func writeKeyFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists; use --force to replace the key", path)
+		}
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadPrivateKey reads a PEM PKCS#8 Ed25519 private key.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKeys reads every PEM public key in the given files. Empty
+// paths are skipped.
+func LoadPublicKeys(paths []string) ([]ed25519.PublicKey, error) {
+	out := []ed25519.PublicKey{}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var blk *pem.Block
+			blk, b = pem.Decode(b)
+			if blk == nil {
+				break
+			}
+			k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+			pub, ok := k.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: not an ed25519 public key", p)
+			}
+			out = append(out, pub)
+		}
+	}
+	return out, nil
+}
+
+// KeyID is a short fingerprint of a public key used to tell keys apart in
+// signatures and chain entries.
+func KeyID(pub ed25519.PublicKey) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:8])
+}
+
+// PrivateKeyID is the key ID of the public half of priv.
+func PrivateKeyID(priv ed25519.PrivateKey) string {
+	return KeyID(priv.Public().(ed25519.PublicKey))
+}
+
+// Sign returns the base64 signature of payload.
+func Sign(priv ed25519.PrivateKey, payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload))
+}
+
+// Verify checks a base64 signature over payload with the trusted key whose
+// ID is keyID. It returns ErrUntrusted when no trusted key has that ID and
+// ErrInvalid when the signature is malformed or does not verify.
+func Verify(trusted []ed25519.PublicKey, keyID string, payload []byte, sig string) error {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return ErrInvalid
+	}
+	for _, pub := range trusted {
+		if KeyID(pub) != keyID {
+			continue
+		}
+		if !ed25519.Verify(pub, payload, raw) {
+			return ErrInvalid
+		}
+		return nil
+	}
+	return ErrUntrusted
+}
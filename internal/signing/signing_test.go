@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv := newKey(t)
+	otherPub, otherPriv := newKey(t)
+	payload := []byte("fortis-backup-chain-head-v1\n3\nabc\n")
+	sig := Sign(priv, payload)
+
+	tests := []struct {
+		name    string
+		trusted []ed25519.PublicKey
+		keyID   string
+		payload []byte
+		sig     string
+		want    error
+	}{
+		{name: "valid", trusted: []ed25519.PublicKey{pub}, keyID: KeyID(pub), payload: payload, sig: sig},
+		{name: "valid among several trusted keys", trusted: []ed25519.PublicKey{otherPub, pub}, keyID: KeyID(pub), payload: payload, sig: sig},
+		{name: "no trusted keys", keyID: KeyID(pub), payload: payload, sig: sig, want: ErrUntrusted},
+		{name: "key not trusted", trusted: []ed25519.PublicKey{otherPub}, keyID: KeyID(pub), payload: payload, sig: sig, want: ErrUntrusted},
+		{name: "payload changed", trusted: []ed25519.PublicKey{pub}, keyID: KeyID(pub), payload: []byte("fortis-backup-chain-head-v1\n2\nabc\n"), sig: sig, want: ErrInvalid},
+		{name: "signed by another key under a trusted ID", trusted: []ed25519.PublicKey{pub, otherPub}, keyID: KeyID(pub), payload: payload, sig: Sign(otherPriv, payload), want: ErrInvalid},
+		{name: "not base64", trusted: []ed25519.PublicKey{pub}, keyID: KeyID(pub), payload: payload, sig: "not base64!", want: ErrInvalid},
+		{name: "truncated signature", trusted: []ed25519.PublicKey{pub}, keyID: KeyID(pub), payload: payload, sig: base64.StdEncoding.EncodeToString([]byte("short")), want: ErrInvalid},
+		{name: "empty signature", trusted: []ed25519.PublicKey{pub}, keyID: KeyID(pub), payload: payload, want: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.trusted, tt.keyID, tt.payload, tt.sig); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string // "priv" and/or "pub" files written beforehand
+		force    bool
+		wantErr  string
+	}{
+		{name: "new key"},
+		{name: "private key exists", existing: []string{"priv"}, wantErr: "already exists"},
+		{name: "public key exists", existing: []string{"pub"}, wantErr: "already exists"},
+		{name: "force replaces both", existing: []string{"priv", "pub"}, force: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := map[string]string{
+				"priv": filepath.Join(dir, "keys", "signing.key"),
+				"pub":  filepath.Join(dir, "keys", "signing.pub"),
+			}
+			if err := os.MkdirAll(filepath.Join(dir, "keys"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range tt.existing {
+				if err := os.WriteFile(paths[k], []byte("old\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			id, err := GenerateKey(paths["priv"], paths["pub"], tt.force)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				for _, k := range tt.existing {
+					if b, _ := os.ReadFile(paths[k]); string(b) != "old\n" {
+						t.Errorf("%s was overwritten", k)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			priv, err := LoadPrivateKey(paths["priv"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubs, err := LoadPublicKeys([]string{"", paths["pub"]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pubs) != 1 || KeyID(pubs[0]) != id || PrivateKeyID(priv) != id {
+				t.Fatalf("key IDs do not match %s", id)
+			}
+			if fi, err := os.Stat(paths["priv"]); err != nil {
+				t.Error(err)
+			} else if fi.Mode().Perm() != 0o600 {
+				t.Errorf("private key mode = %v, want 0600", fi.Mode().Perm())
+			}
+			payload := []byte("payload")
+			if err := Verify(pubs, id, payload, Sign(priv, payload)); err != nil {
+				t.Errorf("round trip: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadPublicKeysRejectsMalformedKey(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "bad.pub")
+	if err := os.WriteFile(p, []byte("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPublicKeys([]string{p}); err == nil {
+		t.Error("loaded a malformed public key")
+	}
+}